@@ -1,2 +1,2 @@
 NAME=kube-router-build
-docker run --rm --name=$NAME -w /go/src/github.com/cloudnativelabs/kube-router -v $GOPATH:/go golang:1.8.3 "make" "$@"
+docker run --rm --name=$NAME -w /go/src/github.com/cloudnativelabs/kube-router -v $GOPATH:/go -e GO111MODULE=off golang:1.16.15 "make" "$@"
@@ -9,22 +9,37 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/routing"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/spf13/pflag"
 )
 
 func main() {
 	if err := Main(); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
+		// the exit code tells the configuration errors from the transient ones, see utils.ExitCode
+		os.Exit(utils.ExitCode(err))
 	}
 	os.Exit(0)
 }
 
 func Main() error {
+	// kube-router cleanup [flags] removes the networking state of kube-router from the node, like --cleanup-config
+	args := os.Args[1:]
+	cleanupCommand := len(args) > 0 && args[0] == "cleanup"
+	if cleanupCommand {
+		args = args[1:]
+	}
+	// kube-router bgp-speaker [flags] <gRPC hosts> runs the BGP speaker process of --bgp-speaker-process
+	bgpSpeakerCommand := len(args) > 0 && args[0] == routing.BgpSpeakerCommand
+	if bgpSpeakerCommand {
+		args = args[1:]
+	}
+
 	config := options.NewKubeRouterConfig()
 	config.AddFlags(pflag.CommandLine)
-	pflag.Parse()
+	pflag.CommandLine.Parse(args)
 
 	// Workaround for this issue:
 	// https://github.com/kubernetes/kubernetes/issues/17162
@@ -43,18 +58,34 @@ func Main() error {
 		return nil
 	}
 
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("kube-router needs to be run with privileges to execute iptables, ipset and configure ipvs")
+	if bgpSpeakerCommand {
+		if pflag.NArg() != 1 {
+			return fmt.Errorf("kube-router %s takes the comma separated hosts serving the gRPC API of the BGP speaker",
+				routing.BgpSpeakerCommand)
+		}
+		return routing.RunBgpSpeaker(pflag.Arg(0))
 	}
 
-	if config.CleanupConfig {
-		cmd.CleanupConfigAndExit()
-		return nil
+	// the capabilities required by the configuration are checked once it is known, when kube-router runs
+	if err := utils.CheckCapabilities([]utils.Capability{utils.CapNetAdmin, utils.CapNetRaw}); err != nil {
+		return fmt.Errorf("kube-router needs to be run with privileges to execute iptables, ipset and configure ipvs: %s", err)
+	}
+
+	fwMarkExcludeMask, err := utils.ParseFwMarkMask(config.FwMarkExcludeMask)
+	if err != nil {
+		return err
+	}
+	if err := utils.ConfigureFwMarks(fwMarkExcludeMask); err != nil {
+		return err
+	}
+
+	if config.CleanupConfig || cleanupCommand {
+		return cmd.CleanupConfigAndExit()
 	}
 
 	kubeRouter, err := cmd.NewKubeRouterDefault(config)
 	if err != nil {
-		return fmt.Errorf("Failed to parse kube-router config: %v", err)
+		return fmt.Errorf("Failed to parse kube-router config: %w", err)
 	}
 
 	if config.EnablePprof {
@@ -65,7 +96,7 @@ func Main() error {
 
 	err = kubeRouter.Run()
 	if err != nil {
-		return fmt.Errorf("Failed to run kube-router: %v", err)
+		return fmt.Errorf("Failed to run kube-router: %w", err)
 	}
 
 	return nil
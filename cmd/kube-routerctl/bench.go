@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/test"
+	"github.com/spf13/pflag"
+)
+
+// IP of the node the synthetic load is benchmarked on
+var benchNodeIP = net.ParseIP("192.0.2.254")
+
+// prefixes of the chains of the network policy controller
+var firewallChainPrefixes = []string{"KUBE-POD-FW-", "KUBE-NWPLCY-"}
+
+// benchSample is the measure of a sync
+type benchSample struct {
+	latency   time.Duration
+	allocated uint64
+}
+
+// runBench synthesizes namespaces, pods and network policies in a fake API server, and measures the syncs of the
+// network policy controller against them. By default only the desired state is rendered, with --apply the rules and
+// ipsets are programmed.
+func runBench(args []string) error {
+	fs := pflag.NewFlagSet("bench", pflag.ContinueOnError)
+	load := test.SyntheticLoad{}
+	fs.IntVar(&load.Namespaces, "namespaces", 10, "Number of namespaces the pods and network policies are spread over.")
+	fs.IntVar(&load.Pods, "pods", 1000, "Number of pods.")
+	fs.IntVar(&load.LocalPods, "local-pods", 100, "Number of pods running on the benchmarked node.")
+	fs.IntVar(&load.Policies, "policies", 100, "Number of network policies.")
+	iterations := fs.Int("iterations", 5, "Number of syncs measured.")
+	apply := fs.Bool("apply", false,
+		"Program the iptables rules and ipsets instead of only rendering them. Must be run in a throwaway network "+
+			"namespace, e.g. with unshare -n, the state is removed once done.")
+	vLevel := fs.StringP("v", "v", "0", "log level for V logs")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	flag.Set("v", *vLevel)
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl bench [--pods=N] [--policies=N] [--apply]\n\n"+
+			"Synthesizes namespaces, pods and network policies in a fake API server and measures the syncs of the\n"+
+			"network policy controller: latency, memory, and the rules and ipsets of the node.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+	if err := load.Validate(); err != nil {
+		return err
+	}
+	if *iterations < 1 {
+		return errors.New("--iterations must be at least 1")
+	}
+	if os.Geteuid() != 0 {
+		return errors.New("kube-routerctl bench needs to be run with privileges to read ipsets")
+	}
+	if *apply {
+		if err := checkNoFirewallState(); err != nil {
+			return err
+		}
+	}
+
+	cluster, err := test.NewCluster("bench-node", benchNodeIP)
+	if err != nil {
+		return err
+	}
+	defer cluster.Stop()
+	cluster.Config.PodsRoutedMode = false
+	start := time.Now()
+	if err := load.Create(cluster); err != nil {
+		return fmt.Errorf("Failed to synthesize the load: %s", err)
+	}
+	npc, err := cluster.NewFirewall(false)
+	if err != nil {
+		return err
+	}
+	synthesized := time.Since(start)
+	if *apply {
+		defer func() {
+			if err := npc.Cleanup(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to clean up the applied rules: %s\n", err)
+			}
+		}()
+	}
+
+	sync := func() error {
+		_, err := npc.RenderDesiredState()
+		return err
+	}
+	if *apply {
+		sync = npc.Sync
+	}
+	samples := make([]benchSample, 0, *iterations)
+	var memStats runtime.MemStats
+	for i := 0; i < *iterations; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&memStats)
+		allocated := memStats.TotalAlloc
+		start := time.Now()
+		if err := sync(); err != nil {
+			return fmt.Errorf("Failed to sync: %s", err)
+		}
+		latency := time.Since(start)
+		runtime.ReadMemStats(&memStats)
+		samples = append(samples, benchSample{latency: latency, allocated: memStats.TotalAlloc - allocated})
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&memStats)
+
+	state, err := npc.RenderDesiredState()
+	if err != nil {
+		return fmt.Errorf("Failed to render the desired state: %s", err)
+	}
+	rules := len(state.Lines(nodestate.IPTables))
+	if *apply {
+		if rules, err = countFirewallRules(); err != nil {
+			return fmt.Errorf("Failed to count the iptables rules: %s", err)
+		}
+	}
+	var ipsets, entries int
+	for _, line := range state.Lines(nodestate.IPSets) {
+		if strings.Contains(line, " ") {
+			entries++
+		} else {
+			ipsets++
+		}
+	}
+
+	mode := "render (the node is not changed)"
+	if *apply {
+		mode = "apply"
+	}
+	fmt.Printf("Load: %d namespaces, %d pods (%d on the node), %d network policies, synthesized in %s\n",
+		load.Namespaces, load.Pods, load.LocalPods, load.Policies, synthesized.Round(time.Millisecond))
+	fmt.Printf("Mode: %s\n\n", mode)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SYNC\tLATENCY\tALLOCATED")
+	for i, sample := range samples {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", i+1, sample.latency.Round(time.Microsecond), formatBytes(sample.allocated))
+	}
+	min, avg, max := summarize(samples)
+	fmt.Fprintf(w, "min/avg/max\t%s / %s / %s\t\n", min.Round(time.Microsecond), avg.Round(time.Microsecond),
+		max.Round(time.Microsecond))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	ruleKind := "rendered iptables rules jumping to the pod firewall chains"
+	if *apply {
+		ruleKind = "iptables rules in the filter table"
+	}
+	fmt.Printf("\n%d %s\n%d ipsets, %d ipset entries\n%s heap in use after the syncs\n", rules, ruleKind, ipsets,
+		entries, formatBytes(memStats.HeapInuse))
+	return nil
+}
+
+func summarize(samples []benchSample) (min, avg, max time.Duration) {
+	var total time.Duration
+	for i, sample := range samples {
+		if i == 0 || sample.latency < min {
+			min = sample.latency
+		}
+		if sample.latency > max {
+			max = sample.latency
+		}
+		total += sample.latency
+	}
+	return min, total / time.Duration(len(samples)), max
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// checkNoFirewallState refuses to benchmark on a node the network policies are enforced on, as the state programmed
+// by the benchmark is removed once done
+func checkNoFirewallState() error {
+	rules, err := nodestate.ReadIPTablesSave("filter")
+	if err != nil {
+		return fmt.Errorf("Failed to read the iptables rules: %s", err)
+	}
+	for _, rule := range rules {
+		if isFirewallChain(rule.Chain) || isFirewallChain(rule.Target) {
+			return errors.New("network policy rules found on the node, run kube-routerctl bench --apply in a " +
+				"throwaway network namespace, e.g. unshare -n kube-routerctl bench --apply")
+		}
+	}
+	return nil
+}
+
+func isFirewallChain(chain string) bool {
+	for _, prefix := range firewallChainPrefixes {
+		if strings.HasPrefix(chain, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// countFirewallRules counts the rules of the filter table
+func countFirewallRules() (int, error) {
+	rules, err := nodestate.ReadIPTablesSave("filter")
+	if err != nil {
+		return 0, err
+	}
+	return len(rules), nil
+}
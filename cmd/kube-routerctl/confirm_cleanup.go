@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/spf13/pflag"
+)
+
+// runConfirmCleanup confirms to the kube-router running on the node the shrink of its desired state, so it removes
+// the stale network policy chains and ipsets it held
+func runConfirmCleanup(args []string) error {
+	fs := pflag.NewFlagSet("confirm-cleanup", pflag.ContinueOnError)
+	socketPath := fs.String("admin-socket", "/var/run/kube-router/admin.sock",
+		"Path of the admin socket of the kube-router running on the node.")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl confirm-cleanup\n\n"+
+			"Confirms the shrink of the desired state of the network policies beyond --policy-shrink-threshold, so\n"+
+			"kube-router removes the stale chains and ipsets it kept enforcing. Only confirm once the network\n"+
+			"policies and pods are known to be deleted, rather than missing from an informer cache.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+
+	held, err := cmd.RequestCleanupConfirmation(*socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to confirm the cleanup: %s", err)
+	}
+	fmt.Printf("Confirmed the shrink of the desired state from %d to %d chains and ipsets, held since %s\n",
+		held.Synced, held.Desired, held.Since.Format(time.RFC3339))
+	return nil
+}
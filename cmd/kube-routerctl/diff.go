@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/proxy"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/routing"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/spf13/pflag"
+)
+
+// runDiff renders the state the enabled controllers would program on the node and compares it with the
+// state found on the node. It accepts the same flags as kube-router, so it renders the same desired state
+// as the agent running on the node when given the agent's arguments.
+func runDiff(args []string) error {
+	config := options.NewKubeRouterConfig()
+	fs := pflag.NewFlagSet("diff", pflag.ContinueOnError)
+	config.AddFlags(fs)
+	colorMode := fs.String("color", "auto", "Colorize the diff: auto, always or never.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	flag.Set("v", config.VLevel)
+
+	if config.HelpRequested {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl diff [kube-router flags] [--color=auto|always|never]\n\n"+
+			"Lines prefixed with '+' are desired but missing on the node, lines prefixed with '-' are on the node\n"+
+			"but not desired. Exits with status 1 when differences are found.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+
+	var color bool
+	switch *colorMode {
+	case "always":
+		color = true
+	case "never":
+		color = false
+	case "auto":
+		if fi, err := os.Stdout.Stat(); err == nil {
+			color = fi.Mode()&os.ModeCharDevice != 0
+		}
+	default:
+		return fmt.Errorf("invalid --color value %q, must be auto, always or never", *colorMode)
+	}
+
+	if os.Geteuid() != 0 {
+		return errors.New("kube-routerctl diff needs to be run with privileges to read iptables, ipset and ipvs")
+	}
+
+	kr, err := cmd.NewKubeRouterDefault(config)
+	if err != nil {
+		return fmt.Errorf("Failed to parse kube-router config: %v", err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informerFactory, err := kr.NewInformerFactory()
+	if err != nil {
+		return errors.New("Failed to create informers: " + err.Error())
+	}
+	svcInformer := informerFactory.Core().V1().Services().Informer()
+	epInformer := informerFactory.Core().V1().Endpoints().Informer()
+	podInformer := informerFactory.Core().V1().Pods().Informer()
+	nodeInformer := informerFactory.Core().V1().Nodes().Informer()
+	nsInformer := informerFactory.Core().V1().Namespaces().Informer()
+	npInformer := informerFactory.Networking().V1().NetworkPolicies().Informer()
+	informerFactory.Start(stopCh)
+
+	err = kr.CacheSyncOrTimeout(informerFactory, stopCh)
+	if err != nil {
+		return errors.New("Failed to synchronize cache: " + err.Error())
+	}
+
+	desired := make(nodestate.State)
+	actual := make(nodestate.State)
+
+	if config.RunFirewall {
+		npc, err := netpol.NewNetworkPolicyController(kr.Client, config, podInformer, npInformer, nsInformer)
+		if err != nil {
+			return errors.New("Failed to create network policy controller: " + err.Error())
+		}
+		if err := collectState(desired, actual, npc.RenderDesiredState, netpol.ReadActualState); err != nil {
+			return err
+		}
+	}
+
+	if config.RunServiceProxy {
+		nsc, err := proxy.NewNetworkServicesController(kr.Client, config, svcInformer, epInformer, podInformer)
+		if err != nil {
+			return errors.New("Failed to create network services controller: " + err.Error())
+		}
+		if err := collectState(desired, actual, nsc.RenderDesiredState, proxy.ReadActualState); err != nil {
+			return err
+		}
+	}
+
+	if config.RunRouter {
+		render := func() (nodestate.State, error) {
+			return routing.RenderDesiredState(kr.Client, config, nodeInformer)
+		}
+		if err := collectState(desired, actual, render, routing.ReadActualState); err != nil {
+			return err
+		}
+	}
+
+	changes := nodestate.Diff(desired, actual)
+	if err := nodestate.WriteDiff(os.Stdout, changes, color); err != nil {
+		return err
+	}
+	if len(changes) != 0 {
+		return errDriftDetected
+	}
+	return nil
+}
+
+func collectState(desired, actual nodestate.State, render, read func() (nodestate.State, error)) error {
+	desiredState, err := render()
+	if err != nil {
+		return errors.New("Failed to render desired state: " + err.Error())
+	}
+	actualState, err := read()
+	if err != nil {
+		return errors.New("Failed to read actual state: " + err.Error())
+	}
+	desired.Merge(desiredState)
+	actual.Merge(actualState)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/spf13/pflag"
+)
+
+// runGraph asks the kube-router running on the node for the service graph of the connections seen on the node
+func runGraph(args []string) error {
+	fs := pflag.NewFlagSet("graph", pflag.ContinueOnError)
+	socketPath := fs.String("admin-socket", "/var/run/kube-router/admin.sock",
+		"Path of the admin socket of the kube-router running on the node.")
+	output := fs.StringP("output", "o", "json", "Output format, json for the adjacency list or dot for Graphviz.")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl graph [--output=json|dot]\n\n"+
+			"Prints the workloads, services, nodes and external addresses the connections seen on the node link,\n"+
+			"sampled from conntrack. kube-router must run with --service-graph-sample-period. Each node reports the\n"+
+			"connections of its pods and the connections from outside the cluster to them, the graph of the cluster\n"+
+			"is the union of the graphs of the nodes.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+	if *output != "json" && *output != "dot" {
+		return fmt.Errorf("unknown --output %q, must be json or dot", *output)
+	}
+
+	graph, err := cmd.RequestServiceGraph(*socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to get the service graph: %s", err)
+	}
+	if *output == "dot" {
+		return writeDot(os.Stdout, graph)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(graph)
+}
+
+// writeDot writes the graph in the Graphviz dot language. The connections the network policies deny are dashed.
+func writeDot(w io.Writer, graph []netpol.GraphAdjacency) error {
+	if _, err := fmt.Fprintln(w, "digraph services {"); err != nil {
+		return err
+	}
+	for _, adjacency := range graph {
+		for _, edge := range adjacency.Destinations {
+			label := edge.Protocol + "/" + strconv.Itoa(edge.Port)
+			if edge.Service != nil {
+				label = edge.Service.String() + " " + label
+			}
+			style := ""
+			if !edge.Allowed {
+				style = ", style=dashed"
+			}
+			if _, err := fmt.Fprintf(w, "  %q -> %q [label=%q%s];\n", adjacency.Source.String(),
+				edge.Destination.String(), label, style); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
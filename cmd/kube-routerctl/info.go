@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/spf13/pflag"
+)
+
+// runInfo asks the kube-router running on the node for its version, its enabled features and the facts of the node
+func runInfo(args []string) error {
+	fs := pflag.NewFlagSet("info", pflag.ContinueOnError)
+	socketPath := fs.String("admin-socket", "/var/run/kube-router/admin.sock",
+		"Path of the admin socket of the kube-router running on the node.")
+	output := fs.StringP("output", "o", "text", "Output format, text or json.")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl info [--output=text|json]\n\n"+
+			"Prints the version of the kube-router running on the node, the features it runs with and the facts of\n"+
+			"the kernel and of the utilities of the node they depend on. kube-router exports the same as the\n"+
+			"kube_router_build_info and kube_router_capabilities metrics.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown --output %q, must be text or json", *output)
+	}
+
+	info, err := cmd.RequestBuildInfo(*socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to get the build info: %s", err)
+	}
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "version\t%s\n", info.Version)
+	fmt.Fprintf(w, "commit\t%s\n", info.Commit)
+	fmt.Fprintf(w, "build date\t%s\n", info.BuildDate)
+	fmt.Fprintf(w, "go version\t%s\n", info.GoVersion)
+	names := make([]string, 0, len(info.Capabilities))
+	for name := range info.Capabilities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, info.Capabilities[name])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+)
+
+// errDriftDetected is returned by the diff command when the node is not in the desired state
+var errDriftDetected = errors.New("drift detected")
+
+// command is a kube-routerctl subcommand, run with the arguments following its name
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"bench": {
+		description: "Measure the network policy syncs on synthetic namespaces, pods and network policies",
+		run:         runBench,
+	},
+	"confirm-cleanup": {
+		description: "Remove the stale network policy chains and ipsets held as the desired state shrank",
+		run:         runConfirmCleanup,
+	},
+	"diff": {
+		description: "Print the differences between the desired and the actual networking state of the node",
+		run:         runDiff,
+	},
+	"graph": {
+		description: "Print the graph of the workloads, services and addresses the connections of the node link",
+		run:         runGraph,
+	},
+	"info": {
+		description: "Print the version, the enabled features and the kernel facts of the kube-router running on the node",
+		run:         runInfo,
+	},
+	"migrate": {
+		description: "Report and remove the networking state left on the node by Calico and flannel",
+		run:         runMigrate,
+	},
+	"sample": {
+		description: "Write the packets going through the chain of a network policy to a pcap file",
+		run:         runSample,
+	},
+	"suggest": {
+		description: "Print network policies allowing the connections of the workloads of a namespace seen over a window",
+		run:         runSuggest,
+	},
+	"unused": {
+		description: "List the network policies whose allow rules matched no packet over a window",
+		run:         runUnused,
+	},
+	"verdict": {
+		description: "Print the verdicts of the network policies for flows, and compare them with logged verdicts",
+		run:         runVerdict,
+	},
+}
+
+func main() {
+	err := Main(os.Args[1:])
+	if err == errDriftDetected || err == errResiduesFound || err == errVerdictMismatch {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func Main(args []string) error {
+	// Workaround for this issue:
+	// https://github.com/kubernetes/kubernetes/issues/17162
+	flag.CommandLine.Parse([]string{})
+	flag.Set("logtostderr", "true")
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage()
+		return nil
+	}
+	if args[0] == "version" || args[0] == "--version" {
+		cmd.PrintVersion(false)
+		return nil
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return command.run(args[1:])
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: kube-routerctl <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "  %-16s %s\n", "version", "Print the version")
+	fmt.Fprintf(os.Stderr, "\nRun 'kube-routerctl <command> --help' for the flags of a command.\n")
+}
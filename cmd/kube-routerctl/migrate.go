@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/cloudnativelabs/kube-router/pkg/migrate"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// errResiduesFound is returned by the migrate command when state of another CNI is left on the node
+var errResiduesFound = errors.New("residues of other CNIs found")
+
+// runMigrate reports the iptables chains, routes and interfaces Calico and flannel left on the node, and removes
+// them when asked to. The pod CIDRs of the nodes are read from the API server to report the conflicts with the
+// routes kube-router injects.
+func runMigrate(args []string) error {
+	config := options.NewKubeRouterConfig()
+	fs := pflag.NewFlagSet("migrate", pflag.ContinueOnError)
+	fs.StringVar(&config.Master, "master", config.Master,
+		"The address of the Kubernetes API server (overrides any value in kubeconfig).")
+	fs.StringVar(&config.Kubeconfig, "kubeconfig", config.Kubeconfig,
+		"Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	fs.StringVarP(&config.VLevel, "v", "v", "0", "log level for V logs")
+	clean := fs.Bool("clean", false, "Remove the residues found instead of only reporting them.")
+	offline := fs.Bool("offline", false,
+		"Do not read the pod CIDRs of the nodes from the API server, conflicts with the routes of kube-router are not reported.")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	flag.Set("v", config.VLevel)
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl migrate [--clean] [--offline] [--kubeconfig=<path>]\n\n"+
+			"Reports the iptables chains and rules, routes, addresses and interfaces left on the node by Calico and\n"+
+			"flannel. Exits with status 1 when residues are found and --clean is not given.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		return errors.New("kube-routerctl migrate needs to be run with privileges to read iptables, routes and interfaces")
+	}
+
+	podCIDRs := make(migrate.PodCIDRs)
+	if !*offline {
+		var err error
+		podCIDRs, err = readPodCIDRs(config)
+		if err != nil {
+			return fmt.Errorf("Failed to read the pod CIDRs of the nodes, run with --offline when the API server "+
+				"cannot be reached: %s", err)
+		}
+	}
+
+	residues, err := migrate.Detect(podCIDRs)
+	if err != nil {
+		return fmt.Errorf("Failed to detect residues of other CNIs: %s", err)
+	}
+	if len(residues) == 0 {
+		fmt.Println("No residues of other CNIs found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CNI\tKIND\tRESIDUE\tCONFLICT")
+	for _, r := range residues {
+		conflict := r.Conflict
+		if conflict == "" {
+			conflict = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.CNI, r.Kind, r.Name, conflict)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !*clean {
+		return errResiduesFound
+	}
+	if err := migrate.Clean(residues); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d residues of other CNIs\n", len(residues))
+	return nil
+}
+
+func readPodCIDRs(config *options.KubeRouterConfig) (migrate.PodCIDRs, error) {
+	kr, err := cmd.NewKubeRouterDefault(config)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := kr.Client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	podCIDRs := make(migrate.PodCIDRs)
+	for _, node := range nodes.Items {
+		if node.Spec.PodCIDR == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(node.Spec.PodCIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod CIDR of node %s: %s", node.Name, err)
+		}
+		podCIDRs[node.Name] = cidr
+	}
+	return podCIDRs, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/spf13/pflag"
+)
+
+// runSample asks the kube-router running on the node to sample the packets going through the chain of a network
+// policy, and writes them to a pcap file
+func runSample(args []string) error {
+	fs := pflag.NewFlagSet("sample", pflag.ContinueOnError)
+	socketPath := fs.String("admin-socket", "/var/run/kube-router/admin.sock",
+		"Path of the admin socket of the kube-router running on the node.")
+	namespace := fs.StringP("namespace", "n", "", "Namespace of the network policy.")
+	policy := fs.String("policy", "", "Name of the network policy.")
+	packets := fs.Int("packets", 100, "Number of packets sampled.")
+	timeout := fs.Duration("timeout", time.Minute, "Time after which the sampling stops, at most 10m.")
+	output := fs.StringP("output", "o", "", "Path of the pcap file written.")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl sample --namespace=NAMESPACE --policy=NAME -o FILE\n\n"+
+			"Attaches a sampler to the chain of the network policy on the node and writes the packets going through\n"+
+			"it to a pcap file. Only the packets of the pods of the node are sampled. The sampler is removed once the\n"+
+			"number of packets was sampled or the timeout expired.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+	if *namespace == "" || *policy == "" {
+		return errors.New("--namespace and --policy are required")
+	}
+	if *output == "" {
+		return errors.New("--output is required")
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = cmd.RequestPolicySample(*socketPath, *namespace, *policy, *packets, *timeout, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		return fmt.Errorf("failed to sample network policy %s/%s: %s", *namespace, *policy, err)
+	}
+	return nil
+}
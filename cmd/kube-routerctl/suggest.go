@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+)
+
+// runSuggest prints network policies allowing the connections of the workloads of a namespace seen in the service
+// graph over a learning window
+func runSuggest(args []string) error {
+	fs := pflag.NewFlagSet("suggest", pflag.ContinueOnError)
+	socketPath := fs.String("admin-socket", "/var/run/kube-router/admin.sock",
+		"Path of the admin socket of the kube-router running on the node.")
+	namespace := fs.StringP("namespace", "n", "", "Namespace of the workloads to suggest network policies for.")
+	window := fs.Duration("window", 24*time.Hour, "Learning window, the connections seen over it are allowed.")
+	graphFiles := fs.StringSlice("graph", nil,
+		"Files of service graphs written by kube-routerctl graph, e.g. on each node. The graph of the kube-router "+
+			"running on the node is used when none is given.")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl suggest --namespace=NAMESPACE [--window=DURATION] "+
+			"[--graph=FILE,...]\n\n"+
+			"Prints, as YAML, a network policy for each workload of the namespace allowing the connections seen in the\n"+
+			"service graph over the window, and only them. A node only sees the connections of its pods, pass the\n"+
+			"graphs of all the nodes running the pods of the namespace or of their peers. Review the policies before\n"+
+			"applying them: connections not seen over the window would be denied.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+	if *namespace == "" {
+		return fmt.Errorf("--namespace is required")
+	}
+	if *window <= 0 {
+		return fmt.Errorf("--window must be a positive duration")
+	}
+
+	var graph []netpol.GraphAdjacency
+	if len(*graphFiles) == 0 {
+		var err error
+		if graph, err = cmd.RequestServiceGraph(*socketPath); err != nil {
+			return fmt.Errorf("failed to get the service graph: %s", err)
+		}
+	}
+	for _, file := range *graphFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var nodeGraph []netpol.GraphAdjacency
+		if err := json.Unmarshal(data, &nodeGraph); err != nil {
+			return fmt.Errorf("invalid service graph %s: %s", file, err)
+		}
+		graph = append(graph, nodeGraph...)
+	}
+
+	policies, warnings := netpol.SuggestPolicies(graph, *namespace, time.Now().Add(-*window))
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	for i, policy := range policies {
+		data, err := yaml.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		fmt.Print(string(data))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/spf13/pflag"
+)
+
+// runUnused asks the kube-router running on the node for the network policies selecting pods of the node whose
+// allow rules matched no packet over a window
+func runUnused(args []string) error {
+	fs := pflag.NewFlagSet("unused", pflag.ContinueOnError)
+	socketPath := fs.String("admin-socket", "/var/run/kube-router/admin.sock",
+		"Path of the admin socket of the kube-router running on the node.")
+	window := fs.Duration("window", 7*24*time.Hour, "Window over which the allow rules matched no packet.")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl unused [--window=DURATION]\n\n"+
+			"Lists the network policies selecting pods of the node whose allow rules matched no packet over the\n"+
+			"window. kube-router must run with --policy-hit-tracking, and only reports the policies it tracked for\n"+
+			"the whole window. A policy is unused in the cluster when every node running its pods reports it.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+	if *window <= 0 {
+		return fmt.Errorf("--window must be a positive duration")
+	}
+
+	unused, err := cmd.RequestUnusedPolicies(*socketPath, *window)
+	if err != nil {
+		return fmt.Errorf("failed to get the unused network policies: %s", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOLICY\tTRACKED SINCE\tLAST HIT")
+	for _, policy := range unused {
+		lastHit := "never"
+		if policy.LastHit != nil {
+			lastHit = policy.LastHit.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", policy.Namespace, policy.Name, policy.Since.Format(time.RFC3339), lastHit)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cmd"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/spf13/pflag"
+)
+
+var errVerdictMismatch = errors.New("verdicts differ")
+
+// runVerdict asks the kube-router running on the node for the verdicts of its network policies for the flows read
+// from stdin, and compares them with the verdicts logged by another network policy engine
+func runVerdict(args []string) error {
+	fs := pflag.NewFlagSet("verdict", pflag.ContinueOnError)
+	socketPath := fs.String("admin-socket", "/var/run/kube-router/admin.sock",
+		"Path of the admin socket of the kube-router running on the node.")
+	onlyMismatches := fs.Bool("mismatches", false, "Only print the flows whose verdicts differ.")
+	help := fs.BoolP("help", "h", false, "Print usage information.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *help {
+		fmt.Fprintf(os.Stderr, "Usage: kube-routerctl verdict [--mismatches] < FLOWS\n\n"+
+			"Reads flows from stdin, one per line as 'SOURCE DESTINATION PROTOCOL PORT [allowed|denied]', and prints\n"+
+			"the verdict of the network policies of kube-router for each. The optional last field is the verdict of\n"+
+			"the engine enforcing the network policies, e.g. taken from its flow logs. Exits with status 1 when\n"+
+			"verdicts differ.\n\n")
+		fs.PrintDefaults()
+		return nil
+	}
+
+	flows, logged, err := readFlows(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if len(flows) == 0 {
+		return nil
+	}
+	verdicts, err := cmd.RequestPolicyVerdicts(*socketPath, flows)
+	if err != nil {
+		return fmt.Errorf("failed to get the verdicts of the network policies: %s", err)
+	}
+
+	mismatch := false
+	for i, verdict := range verdicts {
+		result := verdictString(verdict.Allowed)
+		line := fmt.Sprintf("%s %s %s %d %s (%s)", verdict.Source, verdict.Destination, verdict.Protocol,
+			verdict.Port, result, verdict.Reason)
+		if logged[i] != "" && logged[i] != result {
+			mismatch = true
+			line = "MISMATCH " + line + ", logged " + logged[i]
+		} else if *onlyMismatches {
+			continue
+		}
+		fmt.Println(line)
+	}
+	if mismatch {
+		return errVerdictMismatch
+	}
+	return nil
+}
+
+// readFlows parses the flows and the verdicts logged for them, empty when not given. Empty lines and lines starting
+// with # are skipped.
+func readFlows(r io.Reader) ([]netpol.Flow, []string, error) {
+	var flows []netpol.Flow
+	var logged []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 && len(fields) != 5 {
+			return nil, nil, fmt.Errorf("line %d: expected 'SOURCE DESTINATION PROTOCOL PORT [allowed|denied]'", n)
+		}
+		port, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: invalid port %q", n, fields[3])
+		}
+		verdict := ""
+		if len(fields) == 5 {
+			verdict = strings.ToLower(fields[4])
+			if verdict != verdictString(true) && verdict != verdictString(false) {
+				return nil, nil, fmt.Errorf("line %d: the verdict must be allowed or denied", n)
+			}
+		}
+		flows = append(flows, netpol.Flow{Source: fields[0], Destination: fields[1],
+			Protocol: strings.ToUpper(fields[2]), Port: port})
+		logged = append(logged, verdict)
+	}
+	return flows, logged, scanner.Err()
+}
+
+func verdictString(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
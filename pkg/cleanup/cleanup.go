@@ -0,0 +1,256 @@
+// Package cleanup removes the networking state kube-router programs on a node, to uninstall kube-router or to
+// migrate the node to another CNI.
+package cleanup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/proxy"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/routing"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/docker/libnetwork/ipvs"
+	"github.com/golang/glog"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// prefixes of the iptables chains created by kube-router
+var chainPrefixes = []string{"KUBE-POD-FW-", "KUBE-NWPLCY-", "KUBE-QRNT-", "KUBE-ROUTER-"}
+
+// prefixes of the ipsets created by kube-router
+var ipSetPrefixes = []string{"KUBE-", "kube-router-"}
+
+// routing tables kube-router adds routes and policy routing rules for: the tunnel traffic, the DSR services
+// and their external IPs
+var routeTables = []int{77, 78, 79}
+
+const (
+	// protocol of the routes kube-router injects to the pod CIDRs of the other nodes
+	injectedRouteProtocol = 0x11
+	tunnelPrefix          = "tun"
+)
+
+// isTunnelName tells whether the name is the one of a tunnel created by kube-router, made of the digits of the
+// address of the remote node, unlike the tunl0 fallback device of the ipip module
+func isTunnelName(name string) bool {
+	if !strings.HasPrefix(name, tunnelPrefix) {
+		return false
+	}
+	digits := strings.TrimPrefix(strings.TrimPrefix(name, tunnelPrefix), "-")
+	if digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+type step struct {
+	name string
+	run  func() error
+}
+
+// Run removes the iptables and ip6tables rules and chains, ipsets, IPVS services, routes, policy routing
+// rules, tunnels and dummy interface kube-router creates. It does not need the API server, so it can run from a
+// privileged Job or init container while kube-router is not running, and is idempotent. Every step is run even
+// when some fail, the returned error lists the failed steps.
+func Run() error {
+	steps := []step{
+		{"network policy firewall", (&netpol.NetworkPolicyController{}).Cleanup},
+		{"service proxy", (&proxy.NetworkServicesController{}).Cleanup},
+		{"routing", (&routing.NetworkRoutingController{}).Cleanup},
+		// the controllers stop cleaning up on their first error, what they left is removed by the steps below
+		{"iptables chains", func() error { return deleteChains(iptables.ProtocolIPv4) }},
+		{"ip6tables chains", func() error { return deleteChains(iptables.ProtocolIPv6) }},
+		{"ipsets", destroyIPSets},
+		{"IPVS services", flushIPVS},
+		{"dummy interface", deleteDummyInterface},
+		{"routes", deleteRoutes},
+		{"tunnels", deleteTunnels},
+	}
+
+	failed := make([]string, 0)
+	for _, s := range steps {
+		glog.Infof("Cleaning up %s", s.name)
+		if err := s.run(); err != nil {
+			glog.Errorf("Failed to clean up %s: %s", s.name, err)
+			failed = append(failed, fmt.Sprintf("%s: %s", s.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to clean up %s", strings.Join(failed, "; "))
+	}
+	glog.Infof("Successfully cleaned up the networking state of kube-router")
+	return nil
+}
+
+func isKubeRouterChain(chain string) bool {
+	for _, prefix := range chainPrefixes {
+		if strings.HasPrefix(chain, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteChains deletes the rules jumping to the chains of kube-router, then the chains
+func deleteChains(protocol iptables.Protocol) error {
+	iptablesCmdHandler, err := iptables.NewWithProtocol(protocol)
+	if err != nil {
+		if protocol == iptables.ProtocolIPv6 {
+			glog.Infof("Skipping ip6tables cleanup: %s", err)
+			return nil
+		}
+		return err
+	}
+	for _, table := range []string{"filter", "nat", "mangle"} {
+		chains, err := iptablesCmdHandler.ListChains(table)
+		if err != nil {
+			return fmt.Errorf("failed to list chains of the %s table: %s", table, err)
+		}
+		for _, chain := range chains {
+			if _, err := utils.DeleteIPTablesRules(iptablesCmdHandler, table, chain, jumpsToKubeRouterChain); err != nil {
+				return err
+			}
+		}
+		for _, chain := range chains {
+			if !isKubeRouterChain(chain) {
+				continue
+			}
+			if err := iptablesCmdHandler.ClearChain(table, chain); err != nil {
+				return fmt.Errorf("failed to flush chain %s of the %s table: %s", chain, table, err)
+			}
+		}
+		for _, chain := range chains {
+			if !isKubeRouterChain(chain) {
+				continue
+			}
+			if err := iptablesCmdHandler.DeleteChain(table, chain); err != nil {
+				return fmt.Errorf("failed to delete chain %s of the %s table: %s", chain, table, err)
+			}
+		}
+	}
+	return nil
+}
+
+func jumpsToKubeRouterChain(rule utils.IPTablesSaveRule) bool {
+	return isKubeRouterChain(rule.Target)
+}
+
+// isKubeRouterIPSet tells whether the ipset is one of kube-router, IPv4 or IPv6
+func isKubeRouterIPSet(name string) bool {
+	name = strings.TrimPrefix(name, utils.IPv6SetPrefix)
+	for _, prefix := range ipSetPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// destroyIPSets destroys the ipsets of kube-router, once the rules matching on them are deleted
+func destroyIPSets() error {
+	ipset, err := utils.NewIPSet(false)
+	if err != nil {
+		return err
+	}
+	if err = ipset.Save(); err != nil {
+		return err
+	}
+	for _, set := range ipset.List() {
+		if !isKubeRouterIPSet(set.Name) {
+			continue
+		}
+		if err := set.Destroy(); err != nil {
+			return fmt.Errorf("failed to destroy ipset %s: %s", set.Name, err)
+		}
+	}
+	return nil
+}
+
+func flushIPVS() error {
+	handle, err := ipvs.New("")
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+	return handle.Flush()
+}
+
+// deleteRoutes deletes the routes injected to the pod CIDRs of the other nodes, and the routes and policy
+// routing rules of the kube-router routing tables
+func deleteRoutes() error {
+	for _, family := range []int{nl.FAMILY_V4, nl.FAMILY_V6} {
+		routes, err := netlink.RouteListFiltered(family, &netlink.Route{Protocol: injectedRouteProtocol},
+			netlink.RT_FILTER_PROTOCOL)
+		if err != nil {
+			return err
+		}
+		for _, table := range routeTables {
+			tableRoutes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+			if err != nil {
+				return err
+			}
+			routes = append(routes, tableRoutes...)
+		}
+		for i := range routes {
+			if err := netlink.RouteDel(&routes[i]); err != nil {
+				return fmt.Errorf("failed to delete route %s: %s", routes[i], err)
+			}
+		}
+
+		rules, err := netlink.RuleList(family)
+		if err != nil {
+			return err
+		}
+		for i := range rules {
+			for _, table := range routeTables {
+				if rules[i].Table != table {
+					continue
+				}
+				if err := netlink.RuleDel(&rules[i]); err != nil {
+					return fmt.Errorf("failed to delete policy routing rule %s: %s", rules[i], err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deleteDummyInterface deletes the dummy interface the service proxy assigns the service IPs to
+func deleteDummyInterface() error {
+	link, err := netlink.LinkByName(proxy.KUBE_DUMMY_IF)
+	if _, ok := err.(netlink.LinkNotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete dummy interface %s: %s", proxy.KUBE_DUMMY_IF, err)
+	}
+	return nil
+}
+
+// deleteTunnels deletes the IP-in-IP tunnels to the other nodes
+func deleteTunnels() error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.Type() != "ipip" || !isTunnelName(link.Attrs().Name) {
+			continue
+		}
+		if err := netlink.LinkDel(link); err != nil {
+			return fmt.Errorf("failed to delete tunnel %s: %s", link.Attrs().Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package cleanup
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func TestIsTunnelName(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"tun-1921681":  true,
+		"tun192168100": true,
+		"tunl0":        false,
+		"tun-":         false,
+		"kube-bridge":  false,
+	} {
+		if got := isTunnelName(name); got != expected {
+			t.Errorf("expected isTunnelName(%q) to be %v", name, expected)
+		}
+	}
+}
+
+func TestJumpsToKubeRouterChain(t *testing.T) {
+	for rule, expected := range map[string]bool{
+		`-A FORWARD -m comment --comment "rule to jump traffic destined to POD name:web" -d 10.1.0.5/32 -j KUBE-POD-FW-AAAA`: true,
+		"-A INPUT -m comment --comment \"kube-router netpol\" -j KUBE-ROUTER-SERVICES":                                       true,
+		"-A KUBE-QRNT-AAAA -g KUBE-NWPLCY-BBBB":                                                                              true,
+		`-A FORWARD -m comment --comment "KUBE-POD-FW-AAAA" -j ACCEPT`:                                                       false,
+		"-N KUBE-POD-FW-AAAA":                false,
+		"-A POSTROUTING -j KUBE-POSTROUTING": false,
+	} {
+		parsed, ok, err := utils.ParseIPTablesRule(rule)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", rule, err)
+		}
+		if got := ok && jumpsToKubeRouterChain(parsed); got != expected {
+			t.Errorf("expected jumpsToKubeRouterChain(%q) to be %v", rule, expected)
+		}
+	}
+}
+
+func TestIsKubeRouterIPSet(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"KUBE-DST-3YNVZWWGX3UQQ4VQ":       true,
+		"inet6:KUBE-SRC-3YNVZWWGX3UQQ4VQ": true,
+		"kube-router-pod-subnets":         true,
+		"inet6:kube-router-node-ips":      true,
+		"cali40all-ipam-pools":            false,
+		"inet6:weave-local-pods":          false,
+	} {
+		if got := isKubeRouterIPSet(name); got != expected {
+			t.Errorf("expected isKubeRouterIPSet(%q) to be %v", name, expected)
+		}
+	}
+}
@@ -0,0 +1,563 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/golang/glog"
+)
+
+const (
+	adminStatusPath   = "/status"
+	adminHandoverPath = "/handover"
+	adminSamplePath   = "/netpol/sample"
+	adminStatePath    = "/netpol/state"
+	adminVerdictsPath = "/netpol/verdicts"
+	adminUnusedPath   = "/netpol/unused"
+	adminGraphPath    = "/netpol/graph"
+	adminCleanupPath  = "/netpol/confirm-cleanup"
+
+	// packets sampled and time spent sampling a network policy when not given in the request, and the maximum time
+	defaultPolicySamplePackets = 100
+	defaultPolicySampleTimeout = time.Minute
+	maxPolicySampleTimeout     = 10 * time.Minute
+
+	// window over which the network policies matched no packet to be reported unused, when not given in the request
+	defaultUnusedPolicyWindow = 7 * 24 * time.Hour
+)
+
+// maximum time the running instance takes to stop its controllers and release its ports on a handover
+var handoverTimeout = 2 * time.Minute
+
+// BuildInfo is the version of kube-router, the features enabled on the instance and the facts of its node, answered
+// by the status endpoint of the admin socket
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+	// Capabilities are the features enabled on the instance and the facts of the node, by name
+	Capabilities map[string]string `json:"capabilities,omitempty"`
+}
+
+// policySampler samples the packets going through the chain of a network policy, implemented by the network policy
+// controller
+type policySampler interface {
+	SamplePolicy(ctx context.Context, namespace, name string, packets int, w io.Writer) (int, error)
+}
+
+// policyObserver exposes the model of the network policies, implemented by the network policy controller
+type policyObserver interface {
+	DesiredState() nodestate.State
+	Verdicts(flows []netpol.Flow) ([]netpol.Verdict, error)
+	UnusedPolicies(window time.Duration) ([]netpol.UnusedPolicy, error)
+	ServiceGraph() ([]netpol.GraphAdjacency, error)
+}
+
+// policyCleaner confirms the removal of the stale network policy chains and ipsets held as the desired state shrank,
+// implemented by the network policy controller
+type policyCleaner interface {
+	ConfirmCleanup() (*netpol.HeldCleanup, error)
+}
+
+// adminServer serves the admin socket, on which an instance started in shadow mode asks the running instance to
+// hand over the dataplane, and kube-routerctl samples the packets of network policies and asks for their verdicts
+type adminServer struct {
+	socketPath string
+	buildInfo  BuildInfo
+	// a handover request sends a channel Run closes once the controllers are stopped and their ports released
+	handover chan chan struct{}
+	// nil when the network policy controller does not run
+	policySampler  policySampler
+	policyObserver policyObserver
+	policyCleaner  policyCleaner
+}
+
+func newAdminServer(socketPath string) *adminServer {
+	return &adminServer{socketPath: socketPath, buildInfo: newBuildInfo(nil), handover: make(chan chan struct{})}
+}
+
+// serve serves the admin socket until stopCh is closed. The requests in flight, a handover in particular, are
+// completed after the socket is closed, serve returns once they are.
+func (a *adminServer) serve(stopCh <-chan struct{}) {
+	if err := os.MkdirAll(filepath.Dir(a.socketPath), 0755); err != nil {
+		glog.Errorf("Failed to create the directory of the admin socket: %s", err)
+		return
+	}
+	if err := os.Remove(a.socketPath); err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Failed to remove stale admin socket: %s", err)
+		return
+	}
+	listener, err := net.Listen("unix", a.socketPath)
+	if err != nil {
+		glog.Errorf("Failed to listen on admin socket: %s", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminStatusPath, a.serveStatus)
+	mux.HandleFunc(adminHandoverPath, a.serveHandover)
+	mux.HandleFunc(adminSamplePath, a.serveSample)
+	mux.HandleFunc(adminStatePath, a.serveState)
+	mux.HandleFunc(adminVerdictsPath, a.serveVerdicts)
+	mux.HandleFunc(adminUnusedPath, a.serveUnused)
+	mux.HandleFunc(adminGraphPath, a.serveGraph)
+	mux.HandleFunc(adminCleanupPath, a.serveCleanup)
+	server := &http.Server{Handler: mux}
+	shutdown := make(chan struct{})
+	go func() {
+		<-stopCh
+		if err := server.Shutdown(context.Background()); err != nil {
+			glog.Errorf("Failed to stop admin server: %s", err)
+		}
+		close(shutdown)
+	}()
+	glog.Infof("Serving admin requests on %s", a.socketPath)
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		glog.Errorf("Admin server failed: %s", err)
+		return
+	}
+	// Serve returns as soon as the socket is closed, Shutdown once the requests in flight are completed
+	<-shutdown
+}
+
+func (a *adminServer) serveStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.buildInfo); err != nil {
+		glog.Errorf("Failed to write admin status: %s", err)
+	}
+}
+
+// serveHandover stops the controllers and answers once the new instance can take over
+func (a *adminServer) serveHandover(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "handover must be requested with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case a.handover <- done:
+	case <-time.After(handoverTimeout):
+		http.Error(w, "handover already in progress", http.StatusConflict)
+		return
+	}
+	select {
+	case <-done:
+		glog.Infof("Handed over the dataplane to the new instance")
+		w.WriteHeader(http.StatusOK)
+	case <-time.After(handoverTimeout):
+		http.Error(w, "timed out stopping the controllers", http.StatusInternalServerError)
+	}
+}
+
+// flushWriter flushes the response after each write of the sampler, and tracks whether the response was started
+type flushWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.written = true
+	return f.w.Write(p)
+}
+
+func (f *flushWriter) Flush() {
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// serveSample streams the packets sampled from the chain of a network policy as pcap. The sampling stops once the
+// number of packets was sampled, the timeout expired or the client went away.
+func (a *adminServer) serveSample(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "sampling must be requested with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.policySampler == nil {
+		http.Error(w, "the network policy controller is not running", http.StatusNotFound)
+		return
+	}
+	query := req.URL.Query()
+	namespace, name := query.Get("namespace"), query.Get("name")
+	if namespace == "" || name == "" {
+		http.Error(w, "the namespace and name of the network policy are required", http.StatusBadRequest)
+		return
+	}
+	packets := defaultPolicySamplePackets
+	if value := query.Get("packets"); value != "" {
+		var err error
+		if packets, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "invalid number of packets: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	timeout := defaultPolicySampleTimeout
+	if value := query.Get("timeout"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 || timeout > maxPolicySampleTimeout {
+			http.Error(w, fmt.Sprintf("the timeout must be a duration up to %s", maxPolicySampleTimeout),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	out := &flushWriter{w: w}
+	sampled, err := a.policySampler.SamplePolicy(ctx, namespace, name, packets, out)
+	if err != nil {
+		glog.Errorf("Failed to sample network policy %s/%s: %s", namespace, name, err)
+		if !out.written {
+			w.Header().Del("Content-Type")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	glog.Infof("Sampled %d packets of network policy %s/%s", sampled, namespace, name)
+}
+
+// serveState writes the network policy ipsets and the rules jumping to the pod firewall chains the last sync
+// programmed, or would have programmed in observe-only mode, one per line
+func (a *adminServer) serveState(w http.ResponseWriter, req *http.Request) {
+	if a.policyObserver == nil {
+		http.Error(w, "the network policy controller is not running", http.StatusNotFound)
+		return
+	}
+	state := a.policyObserver.DesiredState()
+	if state == nil {
+		http.Error(w, "no network policy synced yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, section := range []nodestate.Section{nodestate.IPSets, nodestate.IPTables} {
+		for _, line := range state.Lines(section) {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// serveVerdicts answers the verdicts of the network policies for the flows of the request, a JSON list
+func (a *adminServer) serveVerdicts(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "verdicts must be requested with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.policyObserver == nil {
+		http.Error(w, "the network policy controller is not running", http.StatusNotFound)
+		return
+	}
+	var flows []netpol.Flow
+	if err := json.NewDecoder(req.Body).Decode(&flows); err != nil {
+		http.Error(w, "invalid flows: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	verdicts, err := a.policyObserver.Verdicts(flows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(verdicts); err != nil {
+		glog.Errorf("Failed to write the verdicts of the network policies: %s", err)
+	}
+}
+
+// serveUnused answers the network policies selecting pods of the node whose allow rules matched no packet over the
+// window of the request
+func (a *adminServer) serveUnused(w http.ResponseWriter, req *http.Request) {
+	if a.policyObserver == nil {
+		http.Error(w, "the network policy controller is not running", http.StatusNotFound)
+		return
+	}
+	window := defaultUnusedPolicyWindow
+	if value := req.URL.Query().Get("window"); value != "" {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil || window <= 0 {
+			http.Error(w, "the window must be a positive duration", http.StatusBadRequest)
+			return
+		}
+	}
+	unused, err := a.policyObserver.UnusedPolicies(window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(unused); err != nil {
+		glog.Errorf("Failed to write the unused network policies: %s", err)
+	}
+}
+
+// serveGraph answers the service graph of the connections seen on the node, as an adjacency list
+func (a *adminServer) serveGraph(w http.ResponseWriter, req *http.Request) {
+	if a.policyObserver == nil {
+		http.Error(w, "the network policy controller is not running", http.StatusNotFound)
+		return
+	}
+	graph, err := a.policyObserver.ServiceGraph()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(graph); err != nil {
+		glog.Errorf("Failed to write the service graph: %s", err)
+	}
+}
+
+// serveCleanup confirms the removal of the stale chains and ipsets held as the desired state shrank, and answers the
+// confirmed cleanup
+func (a *adminServer) serveCleanup(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "the cleanup must be confirmed with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.policyCleaner == nil {
+		http.Error(w, "the network policy controller is not running", http.StatusNotFound)
+		return
+	}
+	held, err := a.policyCleaner.ConfirmCleanup()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(held); err != nil {
+		glog.Errorf("Failed to write the confirmed cleanup: %s", err)
+	}
+}
+
+// errNoRunningInstance is returned by requestHandover when no instance serves the admin socket
+var errNoRunningInstance = errors.New("no running instance")
+
+// requestHandover asks the instance serving the admin socket to stop its controllers and release its ports
+func requestHandover(socketPath string) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+		Timeout: handoverTimeout + 30*time.Second,
+	}
+	// the host is ignored when dialing the unix socket
+	resp, err := client.Post("http://kube-router"+adminHandoverPath, "", nil)
+	if err != nil {
+		// no socket, or a stale socket left by an instance that exited
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return errNoRunningInstance
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("handover refused with status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// RequestPolicySample asks the instance serving the admin socket to sample the packets going through the chain of a
+// network policy, and writes them to w as pcap. It returns once the number of packets was sampled or the timeout
+// expired.
+func RequestPolicySample(socketPath, namespace, name string, packets int, timeout time.Duration, w io.Writer) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+		// attaching and detaching the sampler each take a sync
+		Timeout: timeout + time.Minute,
+	}
+	query := url.Values{}
+	query.Set("namespace", namespace)
+	query.Set("name", name)
+	query.Set("packets", strconv.Itoa(packets))
+	query.Set("timeout", timeout.String())
+	resp, err := client.Post("http://kube-router"+adminSamplePath+"?"+query.Encode(), "", nil)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return errNoRunningInstance
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("sampling refused with status %d: %s", resp.StatusCode, body)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// RequestPolicyVerdicts asks the instance serving the admin socket for the verdicts of its network policies for the
+// flows
+func RequestPolicyVerdicts(socketPath string, flows []netpol.Flow) ([]netpol.Verdict, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+		Timeout: time.Minute,
+	}
+	body, err := json.Marshal(flows)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Post("http://kube-router"+adminVerdictsPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, errNoRunningInstance
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("verdicts refused with status %d: %s", resp.StatusCode, body)
+	}
+	var verdicts []netpol.Verdict
+	if err := json.NewDecoder(resp.Body).Decode(&verdicts); err != nil {
+		return nil, err
+	}
+	return verdicts, nil
+}
+
+// RequestUnusedPolicies asks the instance serving the admin socket for the network policies selecting pods of its
+// node whose allow rules matched no packet over the window
+func RequestUnusedPolicies(socketPath string, window time.Duration) ([]netpol.UnusedPolicy, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+		Timeout: time.Minute,
+	}
+	resp, err := client.Get("http://kube-router" + adminUnusedPath + "?window=" + url.QueryEscape(window.String()))
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, errNoRunningInstance
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("report refused with status %d: %s", resp.StatusCode, body)
+	}
+	var unused []netpol.UnusedPolicy
+	if err := json.NewDecoder(resp.Body).Decode(&unused); err != nil {
+		return nil, err
+	}
+	return unused, nil
+}
+
+// RequestServiceGraph asks the instance serving the admin socket for the service graph of the connections seen on
+// its node
+func RequestServiceGraph(socketPath string) ([]netpol.GraphAdjacency, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+		Timeout: time.Minute,
+	}
+	resp, err := client.Get("http://kube-router" + adminGraphPath)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, errNoRunningInstance
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("service graph refused with status %d: %s", resp.StatusCode, body)
+	}
+	var graph []netpol.GraphAdjacency
+	if err := json.NewDecoder(resp.Body).Decode(&graph); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// RequestCleanupConfirmation asks the instance serving the admin socket to remove the stale network policy chains and
+// ipsets it holds as the desired state shrank
+func RequestCleanupConfirmation(socketPath string) (*netpol.HeldCleanup, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+		Timeout: time.Minute,
+	}
+	resp, err := client.Post("http://kube-router"+adminCleanupPath, "", nil)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, errNoRunningInstance
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("confirmation refused with status %d: %s", resp.StatusCode, body)
+	}
+	var held netpol.HeldCleanup
+	if err := json.NewDecoder(resp.Body).Decode(&held); err != nil {
+		return nil, err
+	}
+	return &held, nil
+}
+
+// RequestBuildInfo asks the instance serving the admin socket for its version, its enabled features and the facts of
+// its node
+func RequestBuildInfo(socketPath string) (*BuildInfo, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+		Timeout: time.Minute,
+	}
+	resp, err := client.Get("http://kube-router" + adminStatusPath)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, errNoRunningInstance
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("status refused with status %d: %s", resp.StatusCode, body)
+	}
+	var info BuildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakePolicySampler writes one byte per packet of the policy default/sampled
+type fakePolicySampler struct{}
+
+func (fakePolicySampler) SamplePolicy(ctx context.Context, namespace, name string, packets int,
+	w io.Writer) (int, error) {
+	if namespace != "default" || name != "sampled" {
+		return 0, errors.New("network policy " + namespace + "/" + name + " not found")
+	}
+	_, err := w.Write(bytes.Repeat([]byte{'p'}, packets))
+	return packets, err
+}
+
+func TestHandover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "admin.sock")
+
+	if err := requestHandover(socketPath); err != errNoRunningInstance {
+		t.Fatalf("expected no running instance, got %v", err)
+	}
+
+	admin := newAdminServer(socketPath)
+	stopCh := make(chan struct{})
+	served := make(chan struct{})
+	go func() {
+		admin.serve(stopCh)
+		close(served)
+	}()
+	// stands for Run, which stops the controllers and the admin server on a handover
+	go func() {
+		done := <-admin.handover
+		close(stopCh)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := requestHandover(socketPath); err != nil {
+		t.Fatalf("unexpected handover error: %s", err)
+	}
+	<-served
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("expected the admin socket to be removed once handed over, got %v", err)
+	}
+}
+
+func TestHandoverDuringSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "admin.sock")
+
+	admin := newAdminServer(socketPath)
+	stopCh := make(chan struct{})
+	served := make(chan struct{})
+	go func() {
+		admin.serve(stopCh)
+		close(served)
+	}()
+
+	// stands for a controller whose sync is still programming the node when the handover is requested
+	var wg sync.WaitGroup
+	var synced int32
+	syncing := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		close(syncing)
+		<-stopCh
+		time.Sleep(200 * time.Millisecond)
+		atomic.StoreInt32(&synced, 1)
+	}()
+	exited := make(chan struct{})
+	go func() {
+		handOver(<-admin.handover, stopCh, &wg, nil, served)
+		close(exited)
+	}()
+
+	<-syncing
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := requestHandover(socketPath); err != nil {
+		t.Fatalf("unexpected handover error: %s", err)
+	}
+	if atomic.LoadInt32(&synced) == 0 {
+		t.Errorf("expected the handover to be answered once the sync in progress completed")
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the instance to exit once the handover is answered")
+	}
+}
+
+func TestPolicySample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "admin.sock")
+
+	admin := newAdminServer(socketPath)
+	admin.policySampler = fakePolicySampler{}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go admin.serve(stopCh)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var pcap bytes.Buffer
+	if err := RequestPolicySample(socketPath, "default", "sampled", 3, time.Second, &pcap); err != nil {
+		t.Fatalf("unexpected sampling error: %s", err)
+	}
+	if pcap.String() != "ppp" {
+		t.Errorf("expected the sampled packets, got %q", pcap.String())
+	}
+
+	pcap.Reset()
+	err = RequestPolicySample(socketPath, "default", "missing", 3, time.Second, &pcap)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected the policy not to be found, got %v", err)
+	}
+	if err := RequestPolicySample(socketPath, "default", "sampled", 3, time.Hour, &pcap); err == nil {
+		t.Errorf("expected a timeout above the maximum to be refused")
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "admin.sock")
+
+	admin := newAdminServer(socketPath)
+	admin.buildInfo.Capabilities = map[string]string{"firewall": "true", "iptables_backend": "nf_tables"}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go admin.serve(stopCh)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	info, err := RequestBuildInfo(socketPath)
+	if err != nil {
+		t.Fatalf("unexpected build info error: %s", err)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("expected Go version %s, got %s", runtime.Version(), info.GoVersion)
+	}
+	if len(info.Capabilities) != 2 || info.Capabilities["iptables_backend"] != "nf_tables" {
+		t.Errorf("expected the capabilities of the instance, got %v", info.Capabilities)
+	}
+}
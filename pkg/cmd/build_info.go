@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"runtime"
+	"strconv"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/preflight"
+)
+
+// newBuildInfo returns the build info of the binary, with the features enabled by the configuration and the facts of
+// the node as capabilities when the configuration is given
+func newBuildInfo(config *options.KubeRouterConfig) BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if config == nil {
+		return info
+	}
+	info.Capabilities = preflight.Facts()
+	info.Capabilities["firewall"] = strconv.FormatBool(config.RunFirewall)
+	info.Capabilities["router"] = strconv.FormatBool(config.RunRouter)
+	info.Capabilities["service_proxy"] = strconv.FormatBool(config.RunServiceProxy)
+	if config.RunFirewall {
+		info.Capabilities["policy_backend"] = config.PolicyBackend
+	}
+	if config.RunRouter {
+		info.Capabilities["overlay"] = strconv.FormatBool(config.EnableOverlay)
+	}
+	return info
+}
+
+// exportBuildInfo exports the build info and the capabilities as the build_info and capabilities metrics
+func exportBuildInfo(info BuildInfo) {
+	metrics.BuildInfo.WithLabelValues(info.Version, info.Commit, info.GoVersion).Set(1)
+	for name, value := range info.Capabilities {
+		metrics.Capabilities.WithLabelValues(name, value).Set(1)
+	}
+}
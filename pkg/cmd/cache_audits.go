@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cacheAudits returns the audits of the informer caches the controllers read on their syncs
+func (kr *KubeRouter) cacheAudits(podInformer, nsInformer, npInformer, svcInformer, epInformer,
+	nodeInformer cache.SharedIndexInformer) []utils.CacheAudit {
+	core, networking := kr.Client.CoreV1(), kr.Client.NetworkingV1()
+	return []utils.CacheAudit{
+		{
+			Resource: "pods",
+			Indexer:  podInformer.GetIndexer(),
+			Get: func(namespace, name string) (interface{}, error) {
+				return core.Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			},
+			List: func(limit int64) ([]interface{}, error) {
+				return listObjects(core.Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{Limit: limit}))
+			},
+		},
+		{
+			Resource: "namespaces",
+			Indexer:  nsInformer.GetIndexer(),
+			Get: func(_, name string) (interface{}, error) {
+				return core.Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+			},
+			List: func(limit int64) ([]interface{}, error) {
+				return listObjects(core.Namespaces().List(context.Background(), metav1.ListOptions{Limit: limit}))
+			},
+		},
+		{
+			Resource: "networkpolicies",
+			Indexer:  npInformer.GetIndexer(),
+			Get: func(namespace, name string) (interface{}, error) {
+				return networking.NetworkPolicies(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			},
+			List: func(limit int64) ([]interface{}, error) {
+				return listObjects(networking.NetworkPolicies(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{Limit: limit}))
+			},
+		},
+		{
+			Resource: "services",
+			Indexer:  svcInformer.GetIndexer(),
+			Get: func(namespace, name string) (interface{}, error) {
+				return core.Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			},
+			List: func(limit int64) ([]interface{}, error) {
+				return listObjects(core.Services(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{Limit: limit}))
+			},
+		},
+		{
+			Resource: "endpoints",
+			Indexer:  epInformer.GetIndexer(),
+			Get: func(namespace, name string) (interface{}, error) {
+				return core.Endpoints(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			},
+			List: func(limit int64) ([]interface{}, error) {
+				return listObjects(core.Endpoints(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{Limit: limit}))
+			},
+		},
+		{
+			Resource: "nodes",
+			Indexer:  nodeInformer.GetIndexer(),
+			Get: func(_, name string) (interface{}, error) {
+				return core.Nodes().Get(context.Background(), name, metav1.GetOptions{})
+			},
+			List: func(limit int64) ([]interface{}, error) {
+				return listObjects(core.Nodes().List(context.Background(), metav1.ListOptions{Limit: limit}))
+			},
+		},
+	}
+}
+
+// listObjects returns the items of a list read from the API server
+func listObjects(list runtime.Object, err error) ([]interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		objs = append(objs, item)
+	}
+	return objs, nil
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informerResource describes a resource watched by the controllers
+type informerResource struct {
+	object runtime.Object
+	list   func(client kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error)
+	watch  func(client kubernetes.Interface, options metav1.ListOptions) (watch.Interface, error)
+}
+
+// informerResources are the resources watched by the controllers, by the names accepted by
+// --informer-resync-periods
+var informerResources = map[string]informerResource{
+	"pods": {
+		&v1core.Pod{},
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), o)
+		},
+		func(c kubernetes.Interface, o metav1.ListOptions) (watch.Interface, error) {
+			return c.CoreV1().Pods(metav1.NamespaceAll).Watch(context.Background(), o)
+		},
+	},
+	"namespaces": {
+		&v1core.Namespace{},
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Namespaces().List(context.Background(), o)
+		},
+		func(c kubernetes.Interface, o metav1.ListOptions) (watch.Interface, error) {
+			return c.CoreV1().Namespaces().Watch(context.Background(), o)
+		},
+	},
+	"networkpolicies": {
+		&networking.NetworkPolicy{},
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(context.Background(), o)
+		},
+		func(c kubernetes.Interface, o metav1.ListOptions) (watch.Interface, error) {
+			return c.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).Watch(context.Background(), o)
+		},
+	},
+	"services": {
+		&v1core.Service{},
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Services(metav1.NamespaceAll).List(context.Background(), o)
+		},
+		func(c kubernetes.Interface, o metav1.ListOptions) (watch.Interface, error) {
+			return c.CoreV1().Services(metav1.NamespaceAll).Watch(context.Background(), o)
+		},
+	},
+	"endpoints": {
+		&v1core.Endpoints{},
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Endpoints(metav1.NamespaceAll).List(context.Background(), o)
+		},
+		func(c kubernetes.Interface, o metav1.ListOptions) (watch.Interface, error) {
+			return c.CoreV1().Endpoints(metav1.NamespaceAll).Watch(context.Background(), o)
+		},
+	},
+	"nodes": {
+		&v1core.Node{},
+		func(c kubernetes.Interface, o metav1.ListOptions) (runtime.Object, error) {
+			return c.CoreV1().Nodes().List(context.Background(), o)
+		},
+		func(c kubernetes.Interface, o metav1.ListOptions) (watch.Interface, error) {
+			return c.CoreV1().Nodes().Watch(context.Background(), o)
+		},
+	},
+}
+
+// informerTransforms strip the fields the controllers do not read from the objects of the resources before the
+// informers cache them, by resource, with --informer-strip-pods
+var informerTransforms = map[string]func(runtime.Object){
+	"pods": stripPod,
+}
+
+// stripManagedFields removes the managed fields of the object, which none of the controllers read
+func stripManagedFields(obj runtime.Object) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+}
+
+// stripPod removes from the pod the fields the controllers do not read, which make most of the size of the pods: the
+// last applied configuration, the volumes, the init containers and their statuses, the scheduling constraints, all
+// of the containers but their names and ports, and all of the container statuses but their names, ids and readiness
+func stripPod(obj runtime.Object) {
+	pod, ok := obj.(*v1core.Pod)
+	if !ok {
+		return
+	}
+	delete(pod.Annotations, v1core.LastAppliedConfigAnnotation)
+	pod.Spec.Volumes = nil
+	pod.Spec.InitContainers = nil
+	pod.Spec.Affinity = nil
+	pod.Spec.Tolerations = nil
+	for i, container := range pod.Spec.Containers {
+		pod.Spec.Containers[i] = v1core.Container{Name: container.Name, Ports: container.Ports}
+	}
+	pod.Status.InitContainerStatuses = nil
+	for i, status := range pod.Status.ContainerStatuses {
+		pod.Status.ContainerStatuses[i] = v1core.ContainerStatus{Name: status.Name, ContainerID: status.ContainerID,
+			Ready: status.Ready}
+	}
+}
+
+// parseResyncPeriods parses the resource=period pairs of --informer-resync-periods
+func parseResyncPeriods(pairs []string) (map[string]time.Duration, error) {
+	periods := make(map[string]time.Duration)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid informer resync period %q, must be <resource>=<period>", pair)
+		}
+		if _, ok := informerResources[parts[0]]; !ok {
+			return nil, fmt.Errorf("invalid informer resync period %q, unknown resource %s", pair, parts[0])
+		}
+		period, err := time.ParseDuration(parts[1])
+		if err != nil || period < 0 {
+			return nil, fmt.Errorf("invalid informer resync period %q, must be a positive duration or 0", pair)
+		}
+		periods[parts[0]] = period
+	}
+	return periods, nil
+}
+
+// NewInformerFactory returns an informer factory whose informers of the resources watched by the controllers
+// resync with the configured periods, list in pages of the configured size, strip the managed fields and, with
+// --informer-strip-pods, the other fields the controllers do not read, and report, back off and recover from list and watch failures
+func (kr *KubeRouter) NewInformerFactory() (informers.SharedInformerFactory, error) {
+	periods, err := parseResyncPeriods(kr.Config.InformerResyncPeriods)
+	if err != nil {
+		return nil, err
+	}
+	if kr.Config.InformerPageSize < 0 {
+		return nil, errors.New("--informer-page-size must not be negative")
+	}
+	factory := informers.NewSharedInformerFactory(kr.Client, kr.Config.InformerResyncPeriod)
+	for name, resource := range informerResources {
+		name, resource := name, resource
+		resync, ok := periods[name]
+		if !ok {
+			resync = kr.Config.InformerResyncPeriod
+		}
+		transform := stripManagedFields
+		if strip, ok := informerTransforms[name]; ok && kr.Config.InformerStripPods {
+			transform = func(obj runtime.Object) {
+				stripManagedFields(obj)
+				strip(obj)
+			}
+		}
+		// same indexers as the informers of the factory
+		indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+		// the informers returned by the factory for the type are the ones registered here
+		factory.InformerFor(resource.object, func(client kubernetes.Interface, _ time.Duration) cache.SharedIndexInformer {
+			lw := &cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return resource.list(client, options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return resource.watch(client, options)
+				},
+			}
+			bounded := utils.NewBoundedListWatch(lw, kr.Config.InformerPageSize, transform)
+			return cache.NewSharedIndexInformer(utils.NewInstrumentedListWatch(name, bounded), resource.object, resync,
+				indexers)
+		})
+	}
+	return factory, nil
+}
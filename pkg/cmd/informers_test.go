@@ -0,0 +1,17 @@
+package cmd
+
+import (
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStripManagedFields(t *testing.T) {
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1",
+		ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet", Operation: metav1.ManagedFieldsOperationUpdate}}}}
+	stripManagedFields(node)
+	if node.ManagedFields != nil || node.Name != "node-1" {
+		t.Errorf("expected only the managed fields to be stripped, got %+v", node.ObjectMeta)
+	}
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
@@ -9,24 +11,33 @@ import (
 	"sync"
 	"syscall"
 
+	"github.com/cloudnativelabs/kube-router/pkg/cleanup"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/proxy"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/routing"
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
+	"github.com/cloudnativelabs/kube-router/pkg/leaderelection"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/preflight"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/golang/glog"
 
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"time"
 )
 
+// leaderElectionLock is the ConfigMap locking the leadership of the cluster-scope tasks
+const leaderElectionLock = "kube-router-leader"
+
 // These get set at build time via -ldflags magic
 var version string
 var buildDate string
+var commit string
 
 // KubeRouter holds the information needed to run server
 type KubeRouter struct {
@@ -44,12 +55,12 @@ func NewKubeRouterDefault(config *options.KubeRouterConfig) (*KubeRouter, error)
 	if len(config.Master) != 0 || len(config.Kubeconfig) != 0 {
 		clientconfig, err = clientcmd.BuildConfigFromFlags(config.Master, config.Kubeconfig)
 		if err != nil {
-			return nil, errors.New("Failed to build configuration from CLI: " + err.Error())
+			return nil, utils.NewConfigError(errors.New("Failed to build configuration from CLI: " + err.Error()))
 		}
 	} else {
 		clientconfig, err = rest.InClusterConfig()
 		if err != nil {
-			return nil, errors.New("unable to initialize inclusterconfig: " + err.Error())
+			return nil, utils.NewConfigError(errors.New("unable to initialize inclusterconfig: " + err.Error()))
 		}
 	}
 
@@ -61,16 +72,9 @@ func NewKubeRouterDefault(config *options.KubeRouterConfig) (*KubeRouter, error)
 	return &KubeRouter{Client: clientset, Config: config}, nil
 }
 
-// CleanupConfigAndExit performs Cleanup on all three controllers
-func CleanupConfigAndExit() {
-	npc := netpol.NetworkPolicyController{}
-	npc.Cleanup()
-
-	nsc := proxy.NetworkServicesController{}
-	nsc.Cleanup()
-
-	nrc := routing.NetworkRoutingController{}
-	nrc.Cleanup()
+// CleanupConfigAndExit removes the networking state of all three controllers from the node
+func CleanupConfigAndExit() error {
+	return cleanup.Run()
 }
 
 // Run starts the controllers and waits forever till we get SIGINT or SIGTERM
@@ -86,14 +90,63 @@ func (kr *KubeRouter) Run() error {
 		os.Exit(0)
 	}
 
+	capabilities := kr.requiredCapabilities()
+	if err := utils.CheckCapabilities(capabilities); err != nil {
+		return errors.New("Insufficient privileges, run kube-router privileged or grant it the capabilities: " + err.Error())
+	}
+	if err := utils.InheritCapabilities(capabilities); err != nil {
+		return errors.New("Failed to pass capabilities to the executed utilities: " + err.Error())
+	}
+	if err := utils.RaiseAmbientCapabilities(utils.SharedExecCapabilities(capabilities)); err != nil {
+		return errors.New("Failed to pass capabilities to the executed utilities: " + err.Error())
+	}
+
+	if err := utils.CheckIPSetSupport(kr.requiredIPSetTypes()); err != nil {
+		return errors.New("Failed to verify ipset support: " + err.Error())
+	}
+	preflightResults := preflight.Run(preflight.RequiredChecks(kr.Config), kr.Config.PreflightLoadModules)
+
 	hc, err := healthcheck.NewHealthController(kr.Config)
 	if err != nil {
 		return errors.New("Failed to create health controller: " + err.Error())
 	}
-	wg.Add(1)
-	go hc.RunServer(stopCh, &wg)
+	for _, result := range preflight.Failed(preflightResults) {
+		hc.PreflightFailures = append(hc.PreflightFailures, result.String())
+	}
+	hc.APIStaleSince = utils.APIStaleSince
+	// the events of all the controllers go through the sink, so they are aggregated
+	var events *utils.EventSink
+	nodeName := kr.Config.HostnameOverride
+	if node, err := utils.GetNodeObject(kr.Client, kr.Config.HostnameOverride); err == nil {
+		nodeName = node.Name
+		events = utils.NewEventSink(kr.Client, node.Name, kr.Config.EventAggregationWindow)
+		go events.Run(stopCh)
+	} else {
+		glog.Errorf("Failed to find the node, no event will be recorded: %s", err)
+	}
+	// the changes of all the controllers go to the same audit log, rotated as a whole
+	audit, err := utils.NewAuditLog(kr.Config.AuditLogFile, nodeName, int64(kr.Config.AuditLogMaxSize)*1024*1024,
+		kr.Config.AuditLogMaxFiles, kr.Config.AuditLogCompress)
+	if err != nil {
+		return errors.New("Failed to open the audit log: " + err.Error())
+	}
+	defer audit.Close()
+	preflight.RecordEvents(events, preflightResults)
+	// the facts of the node are gathered once the preflight checks loaded the missing kernel modules
+	buildInfo := newBuildInfo(kr.Config)
+	if kr.Config.Shadow && kr.Config.AdminSocket == "" {
+		return errors.New("--shadow requires --admin-socket to take over from the running instance")
+	}
+	// in shadow mode the health port is owned by the running instance until it hands over
+	if !kr.Config.Shadow {
+		wg.Add(1)
+		go hc.RunServer(stopCh, &wg)
+	}
 
-	informerFactory := informers.NewSharedInformerFactory(kr.Client, 0)
+	informerFactory, err := kr.NewInformerFactory()
+	if err != nil {
+		return errors.New("Failed to create informers: " + err.Error())
+	}
 	svcInformer := informerFactory.Core().V1().Services().Informer()
 	epInformer := informerFactory.Core().V1().Endpoints().Informer()
 	podInformer := informerFactory.Core().V1().Pods().Informer()
@@ -104,7 +157,23 @@ func (kr *KubeRouter) Run() error {
 
 	err = kr.CacheSyncOrTimeout(informerFactory, stopCh)
 	if err != nil {
-		return errors.New("Failed to synchronize cache: " + err.Error())
+		return fmt.Errorf("Failed to synchronize cache: %w", err)
+	}
+	if kr.Config.CacheAuditPeriod > 0 {
+		audits := kr.cacheAudits(podInformer, nsInformer, npInformer, svcInformer, epInformer, nodeInformer)
+		go utils.RunCacheAudits(audits, kr.Config.CacheAuditPeriod, kr.Config.CacheAuditSampleSize, stopCh)
+	}
+
+	if kr.Config.Shadow {
+		sources, err := kr.shadowStateSources(podInformer, npInformer, nsInformer, svcInformer, epInformer, nodeInformer)
+		if err != nil {
+			return err
+		}
+		if err := kr.takeOver(sources, stopCh); err != nil {
+			return errors.New("Failed to take over the dataplane: " + err.Error())
+		}
+		wg.Add(1)
+		go hc.RunServer(stopCh, &wg)
 	}
 
 	hc.SetAlive()
@@ -117,8 +186,12 @@ func (kr *KubeRouter) Run() error {
 		if err != nil {
 			return errors.New("Failed to create metrics controller: " + err.Error())
 		}
+		exportBuildInfo(buildInfo)
 		wg.Add(1)
 		go mc.Run(healthChan, stopCh, &wg)
+		go utils.RunInformerMetrics(map[string]cache.SharedIndexInformer{"pods": podInformer, "namespaces": nsInformer,
+			"networkpolicies": npInformer, "services": svcInformer, "endpoints": epInformer, "nodes": nodeInformer},
+			stopCh)
 
 	} else if kr.Config.MetricsPort > 65535 {
 		glog.Errorf("Metrics port must be over 0 and under 65535, given port: %d", kr.Config.MetricsPort)
@@ -127,6 +200,33 @@ func (kr *KubeRouter) Run() error {
 		kr.Config.MetricsEnabled = false
 	}
 
+	var governor *utils.LoadGovernor
+	if kr.Config.LoadGovernor {
+		governor, err = utils.NewLoadGovernor(utils.LoadGovernorConfig{
+			LoadThreshold: kr.Config.LoadGovernorLoadThreshold,
+			LockThreshold: kr.Config.LoadGovernorLockThreshold,
+			MaxStretch:    kr.Config.LoadGovernorMaxStretch,
+		}, events)
+		if err != nil {
+			return errors.New("Failed to create load governor: " + err.Error())
+		}
+		go governor.Run(stopCh)
+	}
+
+	var coordinator *utils.SyncCoordinator
+	if kr.Config.SyncCoordinator {
+		coordinator = utils.NewSyncCoordinator(kr.syncedControllers())
+	}
+
+	// the cluster-scope tasks of the enabled features are added to the elector before it runs
+	elector, err := kr.newElector()
+	if err != nil {
+		return errors.New("Failed to create leader elector: " + err.Error())
+	}
+
+	var sampler policySampler
+	var observer policyObserver
+	var cleaner policyCleaner
 	if kr.Config.RunFirewall {
 		npc, err := netpol.NewNetworkPolicyController(kr.Client,
 			kr.Config, podInformer, npInformer, nsInformer)
@@ -134,29 +234,62 @@ func (kr *KubeRouter) Run() error {
 			return errors.New("Failed to create network policy controller: " + err.Error())
 		}
 
+		npc.Governor = governor
+		npc.Coordinator = coordinator
+		npc.Events = events
+		npc.Audit = audit
+		npc.ServiceLister = svcInformer.GetIndexer()
+		npc.NodeLister = nodeInformer.GetIndexer()
+		npc.EndpointsLister = epInformer.GetIndexer()
+		sampler = npc
+		observer = npc
+		cleaner = npc
+
 		podInformer.AddEventHandler(npc.PodEventHandler)
 		nsInformer.AddEventHandler(npc.NamespaceEventHandler)
 		npInformer.AddEventHandler(npc.NetworkPolicyEventHandler)
+		if npc.EndpointsEventHandler != nil {
+			epInformer.AddEventHandler(npc.EndpointsEventHandler)
+		}
+		nodeInformer.AddEventHandler(npc.NodeEventHandler)
 
 		wg.Add(1)
 		go npc.Run(healthChan, stopCh, &wg)
+
+		if kr.Config.EnablePolicyStatus {
+			aggregator := netpol.NewPolicyStatusAggregator(kr.Client, npInformer.GetIndexer(),
+				nodeInformer.GetIndexer(), kr.Config.PolicyStatusPeriod)
+			elector.AddTask("network policy status aggregation", aggregator.Run)
+		}
+		if kr.Config.IPSetManifestConfigMap != "" {
+			exporter, err := netpol.NewIPSetExporter(kr.Client, npc, kr.Config.IPSetManifestConfigMap)
+			if err != nil {
+				return errors.New("Failed to create ipset manifest exporter: " + err.Error())
+			}
+			elector.AddTask("ipset manifest export", exporter.Run)
+		}
 	}
 
 	if kr.Config.BGPGracefulRestart {
 		if kr.Config.BGPGracefulRestartDeferralTime > time.Hour*18 {
-			return errors.New("BGPGracefuleRestartDeferralTime should be less than 18 hours")
+			return utils.NewConfigError(errors.New("BGPGracefuleRestartDeferralTime should be less than 18 hours"))
 		}
 		if kr.Config.BGPGracefulRestartDeferralTime <= 0 {
-			return errors.New("BGPGracefuleRestartDeferralTime must be positive")
+			return utils.NewConfigError(errors.New("BGPGracefuleRestartDeferralTime must be positive"))
 		}
 	}
 
+	var nrc *routing.NetworkRoutingController
 	if kr.Config.RunRouter {
-		nrc, err := routing.NewNetworkRoutingController(kr.Client, kr.Config, nodeInformer, svcInformer, epInformer)
+		nrc, err = routing.NewNetworkRoutingController(kr.Client, kr.Config, nodeInformer, svcInformer, epInformer)
 		if err != nil {
 			return errors.New("Failed to create network routing controller: " + err.Error())
 		}
 
+		nrc.Governor = governor
+		nrc.Coordinator = coordinator
+		nrc.Audit = audit
+
 		nodeInformer.AddEventHandler(nrc.NodeEventHandler)
 		svcInformer.AddEventHandler(nrc.ServiceEventHandler)
 		epInformer.AddEventHandler(nrc.EndpointsEventHandler)
@@ -172,6 +305,9 @@ func (kr *KubeRouter) Run() error {
 			return errors.New("Failed to create network services controller: " + err.Error())
 		}
 
+		nsc.Governor = governor
+		nsc.Coordinator = coordinator
+
 		svcInformer.AddEventHandler(nsc.ServiceEventHandler)
 		epInformer.AddEventHandler(nsc.EndpointsEventHandler)
 
@@ -179,16 +315,134 @@ func (kr *KubeRouter) Run() error {
 		go nsc.Run(healthChan, stopCh, &wg)
 	}
 
+	wg.Add(1)
+	go elector.Run(stopCh, &wg)
+
+	var handover chan chan struct{}
+	adminServed := make(chan struct{})
+	if kr.Config.AdminSocket != "" {
+		admin := newAdminServer(kr.Config.AdminSocket)
+		admin.buildInfo = buildInfo
+		admin.policySampler = sampler
+		admin.policyObserver = observer
+		admin.policyCleaner = cleaner
+		handover = admin.handover
+		go func() {
+			admin.serve(stopCh)
+			close(adminServed)
+		}()
+	}
+
 	// Handle SIGINT and SIGTERM
 	ch := make(chan os.Signal)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-	<-ch
+	select {
+	case <-ch:
+		glog.Infof("Shutting down the controllers")
+		close(stopCh)
+		wg.Wait()
+	case done := <-handover:
+		handOver(done, stopCh, &wg, nrc, adminServed)
+	}
+	return nil
+}
 
-	glog.Infof("Shutting down the controllers")
+// handOver stops the controllers, leaving the dataplane as is for the new instance, and answers the handover once
+// they returned. The controllers only return once all their goroutines exited, so nothing programs the node anymore
+// when the new instance takes over. It returns once the answer is sent, for this instance to exit.
+func handOver(done chan<- struct{}, stopCh chan struct{}, wg *sync.WaitGroup,
+	nrc *routing.NetworkRoutingController, adminServed <-chan struct{}) {
+	glog.Infof("Handing over the dataplane, shutting down the controllers")
 	close(stopCh)
-
 	wg.Wait()
-	return nil
+	if nrc != nil {
+		nrc.StopBgpServer()
+	}
+	close(done)
+	<-adminServed
+}
+
+// newElector returns the elector of the instance running the cluster-scope tasks, identified by its node and a
+// random suffix so an instance taking over the node from another one is told apart
+func (kr *KubeRouter) newElector() (*leaderelection.Elector, error) {
+	node, err := utils.GetNodeObject(kr.Client, kr.Config.HostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	return leaderelection.NewElector(leaderelection.Config{
+		Namespace:     kr.Config.LeaderElectionNamespace,
+		Name:          leaderElectionLock,
+		Identity:      node.Name + "_" + hex.EncodeToString(suffix),
+		LeaseDuration: kr.Config.LeaderElectionLeaseDuration,
+		RenewDeadline: kr.Config.LeaderElectionRenewDeadline,
+		RetryPeriod:   kr.Config.LeaderElectionRetryPeriod,
+	}, kr.Client)
+}
+
+// requiredCapabilities returns the capabilities kube-router needs to run with the configuration
+func (kr *KubeRouter) requiredCapabilities() []utils.Capability {
+	// iptables, ipset, IPVS and the netlink calls programming routes, addresses and links
+	capabilities := []utils.Capability{utils.CapNetAdmin, utils.CapNetRaw}
+	// sysctls, the iptables lock and the state directory are owned by root
+	if os.Geteuid() != 0 {
+		capabilities = append(capabilities, utils.CapDACOverride)
+	}
+	privilegedPort := func(port uint16) bool {
+		return port > 0 && port < 1024
+	}
+	if kr.Config.RunRouter && privilegedPort(kr.Config.BGPPort) || privilegedPort(kr.Config.HealthPort) ||
+		privilegedPort(kr.Config.MetricsPort) {
+		capabilities = append(capabilities, utils.CapNetBindService)
+	}
+	if kr.Config.PreflightLoadModules {
+		capabilities = append(capabilities, utils.CapSysModule)
+	}
+	return capabilities
+}
+
+// requiredIPSetTypes returns the ipset types used by the enabled controllers
+func (kr *KubeRouter) requiredIPSetTypes() []string {
+	types := make(map[string]bool)
+	if kr.Config.RunFirewall || kr.Config.RunRouter {
+		types[utils.TypeHashIP] = true
+		types[utils.TypeHashNet] = true
+	}
+	if kr.Config.RunFirewall {
+		// the cluster allow lists and critical flows, and the cluster DNS allowed to the pods
+		types[utils.TypeHashNetPort] = true
+		types[utils.TypeHashIPPort] = true
+	}
+	if kr.Config.RunServiceProxy {
+		types[utils.TypeHashIP] = true
+		types[utils.TypeHashIPPort] = true
+	}
+	setTypes := make([]string, 0, len(types))
+	for _, setType := range []string{utils.TypeHashIP, utils.TypeHashNet, utils.TypeHashIPPort, utils.TypeHashNetPort} {
+		if types[setType] {
+			setTypes = append(setTypes, setType)
+		}
+	}
+	return setTypes
+}
+
+// syncedControllers returns the enabled controllers whose syncs the sync coordinator runs, in the order their periodic
+// syncs are staggered in
+func (kr *KubeRouter) syncedControllers() []string {
+	controllers := make([]string, 0, 3)
+	if kr.Config.RunFirewall {
+		controllers = append(controllers, "NPC")
+	}
+	if kr.Config.RunRouter {
+		controllers = append(controllers, "NRC")
+	}
+	if kr.Config.RunServiceProxy {
+		controllers = append(controllers, "NSC")
+	}
+	return controllers
 }
 
 // CacheSync performs cache synchronization under timeout limit
@@ -201,7 +455,7 @@ func (kr *KubeRouter) CacheSyncOrTimeout(informerFactory informers.SharedInforme
 
 	select {
 	case <-time.After(kr.Config.CacheSyncTimeout):
-		return errors.New(kr.Config.CacheSyncTimeout.String() + " timeout")
+		return fmt.Errorf("%w: %s timeout", utils.ErrAPIUnavailable, kr.Config.CacheSyncTimeout)
 	case <-syncOverCh:
 		return nil
 	}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/proxy"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/routing"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/golang/glog"
+	"k8s.io/client-go/tools/cache"
+)
+
+// delay between two comparisons of the desired state with the dataplane in shadow mode, the running instance
+// may be catching up with recent changes
+var shadowVerifyInterval = 10 * time.Second
+
+// stateSource renders the desired state of a controller and reads the state it programmed on the node
+type stateSource struct {
+	name   string
+	render func() (nodestate.State, error)
+	read   func() (nodestate.State, error)
+}
+
+// shadowStateSources returns the state sources of the enabled controllers. The controllers are only created to
+// render their desired state, which does not program the node.
+func (kr *KubeRouter) shadowStateSources(podInformer, npInformer, nsInformer, svcInformer, epInformer,
+	nodeInformer cache.SharedIndexInformer) ([]stateSource, error) {
+	sources := make([]stateSource, 0)
+	if kr.Config.RunFirewall {
+		npc, err := netpol.NewNetworkPolicyController(kr.Client, kr.Config, podInformer, npInformer, nsInformer)
+		if err != nil {
+			return nil, errors.New("Failed to create network policy controller: " + err.Error())
+		}
+		sources = append(sources, stateSource{"network policy", npc.RenderDesiredState, netpol.ReadActualState})
+	}
+	if kr.Config.RunServiceProxy {
+		nsc, err := proxy.NewNetworkServicesController(kr.Client, kr.Config, svcInformer, epInformer, podInformer)
+		if err != nil {
+			return nil, errors.New("Failed to create network services controller: " + err.Error())
+		}
+		sources = append(sources, stateSource{"service proxy", nsc.RenderDesiredState, proxy.ReadActualState})
+	}
+	if kr.Config.RunRouter {
+		render := func() (nodestate.State, error) {
+			return routing.RenderDesiredState(kr.Client, kr.Config, nodeInformer)
+		}
+		sources = append(sources, stateSource{"routing", render, routing.ReadActualState})
+	}
+	return sources, nil
+}
+
+// dataplaneChanges returns the changes needed to bring the node to the state desired by the sources
+func dataplaneChanges(sources []stateSource) ([]nodestate.Change, error) {
+	desired := make(nodestate.State)
+	actual := make(nodestate.State)
+	for _, source := range sources {
+		desiredState, err := source.render()
+		if err != nil {
+			return nil, fmt.Errorf("failed to render the desired %s state: %s", source.name, err)
+		}
+		actualState, err := source.read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the actual %s state: %s", source.name, err)
+		}
+		desired.Merge(desiredState)
+		actual.Merge(actualState)
+	}
+	return nodestate.Diff(desired, actual), nil
+}
+
+// waitForDataplaneMatch compares the desired state with the dataplane programmed by the running instance until
+// they match, so taking over does not reprogram the node
+func waitForDataplaneMatch(sources []stateSource, timeout time.Duration, stopCh <-chan struct{}) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		changes, err := dataplaneChanges(sources)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			glog.Infof("Desired state matches the dataplane")
+			return nil
+		}
+		glog.Infof("Desired state differs from the dataplane by %d lines, waiting for the running instance to converge",
+			len(changes))
+		if glog.V(2) {
+			var diff bytes.Buffer
+			if err := nodestate.WriteDiff(&diff, changes, false); err == nil {
+				glog.Infof("Differences with the dataplane:\n%s", diff.String())
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("desired state still differs from the dataplane by %d lines after %s", len(changes), timeout)
+		}
+		select {
+		case <-stopCh:
+			return errors.New("shut down before taking over")
+		case <-time.After(shadowVerifyInterval):
+		}
+	}
+}
+
+// takeOver waits for the desired state to match the dataplane, then asks the running instance to hand it over.
+// The node is not programmed until it returns.
+func (kr *KubeRouter) takeOver(sources []stateSource, stopCh <-chan struct{}) error {
+	glog.Infof("Running in shadow mode, verifying the dataplane before taking over")
+	if err := waitForDataplaneMatch(sources, kr.Config.ShadowTimeout, stopCh); err != nil {
+		return err
+	}
+	err := requestHandover(kr.Config.AdminSocket)
+	if err == errNoRunningInstance {
+		glog.Infof("No running instance found on %s, taking over the dataplane", kr.Config.AdminSocket)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to request the handover of the dataplane: %s", err)
+	}
+	glog.Infof("Running instance handed over the dataplane")
+	return nil
+}
@@ -0,0 +1,152 @@
+package netpol
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// With --audit-log-file, the changes of each sync to the dataplane are recorded in the audit log: the chains created
+// and deleted, the rules added and removed, compared with the rules of the previous sync, and the ipsets created or
+// refreshed, along with the entries added and removed, and destroyed. The chains are versioned and replaced by each
+// sync, so they are recorded after the pod or network policy they are created for, and the rules with the chains
+// they jump to named the same way. The first sync after a restart records all its chains and rules as created.
+
+const auditController = "NPC"
+
+// auditedChain is a chain of the input of a sync, and its rules with the chains they jump to named for the audit log
+type auditedChain struct {
+	chain string
+	rules []string
+}
+
+// versioned tells whether the chain was named after the pod or network policy it is created for, rather than being a
+// built-in chain
+func (c auditedChain) versioned(name string) bool {
+	return c.chain != name
+}
+
+// auditChain names the chain of the sync in the audit log after the pod or network policy it is created for
+func (npc *NetworkPolicyController) auditChain(chain, name string) {
+	if npc.Audit == nil {
+		return
+	}
+	if npc.auditedChains == nil {
+		npc.auditedChains = make(map[string]string)
+	}
+	npc.auditedChains[chain] = name
+}
+
+// auditChainRules records the chains created and deleted, and the rules added and removed, by the applied input of
+// a sync
+func (npc *NetworkPolicyController) auditChainRules(filterTable *utils.IPTablesRestore) {
+	if npc.Audit == nil {
+		return
+	}
+	replacements := make([]string, 0, 2*len(npc.auditedChains))
+	for chain, name := range npc.auditedChains {
+		replacements = append(replacements, chain, "<"+name+">")
+	}
+	names := strings.NewReplacer(replacements...)
+
+	current := make(map[string]auditedChain)
+	for chain, rules := range filterTable.ChainRules() {
+		// the built-in chains the jumps are inserted in keep their name
+		name := chain
+		if audited, ok := npc.auditedChains[chain]; ok {
+			name = audited
+		}
+		named := make([]string, 0, len(rules))
+		for _, rule := range rules {
+			named = append(named, names.Replace(rule))
+		}
+		current[name] = auditedChain{chain: chain, rules: named}
+	}
+
+	for _, name := range sortedChainNames(current) {
+		audited := current[name]
+		previous, ok := npc.auditedRules[name]
+		if !ok && audited.versioned(name) {
+			npc.Audit.Record(auditController, utils.AuditChainCreated, name,
+				map[string]interface{}{"chain": audited.chain, "rules": len(audited.rules)})
+		}
+		added, removed := diffLines(previous.rules, audited.rules)
+		for _, rule := range added {
+			npc.Audit.Record(auditController, utils.AuditRuleAdded, name,
+				map[string]interface{}{"chain": audited.chain, "rule": rule})
+		}
+		for _, rule := range removed {
+			npc.Audit.Record(auditController, utils.AuditRuleRemoved, name,
+				map[string]interface{}{"chain": audited.chain, "rule": rule})
+		}
+	}
+	for _, name := range sortedChainNames(npc.auditedRules) {
+		if previous := npc.auditedRules[name]; previous.versioned(name) {
+			if _, ok := current[name]; !ok {
+				npc.Audit.Record(auditController, utils.AuditChainDeleted, name,
+					map[string]interface{}{"chain": previous.chain})
+			}
+		}
+	}
+	npc.auditedRules = current
+}
+
+// auditIPSets records the ipsets of the network policies created and refreshed since their entries before. The
+// ipsets destroyed are recorded by destroyStaleIPSets.
+func (npc *NetworkPolicyController) auditIPSets(before map[string][]string) {
+	if npc.Audit == nil {
+		return
+	}
+	after := npc.policyIPSetEntries()
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entries, ok := before[name]
+		if !ok {
+			npc.Audit.Record(auditController, utils.AuditIPSetCreated, name,
+				map[string]interface{}{"entries": after[name]})
+			continue
+		}
+		added, removed := diffLines(entries, after[name])
+		if len(added) != 0 || len(removed) != 0 {
+			npc.Audit.Record(auditController, utils.AuditIPSetRefreshed, name,
+				map[string]interface{}{"added": added, "removed": removed})
+		}
+	}
+}
+
+func sortedChainNames(chains map[string]auditedChain) []string {
+	names := make([]string, 0, len(chains))
+	for name := range chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// diffLines returns the lines of after missing from before, and the lines of before missing from after, in order
+func diffLines(before, after []string) ([]string, []string) {
+	inBefore := make(map[string]bool, len(before))
+	for _, line := range before {
+		inBefore[line] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	added := make([]string, 0)
+	for _, line := range after {
+		inAfter[line] = true
+		if !inBefore[line] {
+			added = append(added, line)
+		}
+	}
+	removed := make([]string, 0)
+	for _, line := range before {
+		if !inAfter[line] {
+			removed = append(removed, line)
+		}
+	}
+	return added, removed
+}
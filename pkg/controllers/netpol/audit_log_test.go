@@ -0,0 +1,74 @@
+package netpol
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func TestAuditChainRules(t *testing.T) {
+	f, err := ioutil.TempFile("", "audit-log")
+	if err != nil {
+		t.Fatalf("failed to create audit log: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	audit, err := utils.NewAuditLog(f.Name(), "node", 1024*1024, 1, false)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	npc := &NetworkPolicyController{Audit: audit}
+
+	sync := func(version string, ports ...string) {
+		filterTable := utils.NewIPTablesRestore("filter")
+		podFw := podFirewallChainName("default", "web-0", version)
+		policy := networkPolicyChainName("default", "web", version)
+		filterTable.NewChain(podFw)
+		npc.auditChain(podFw, "pod default/web-0")
+		filterTable.AppendUnique("FORWARD", "-d", "10.1.0.2/32", "-j", podFw)
+		filterTable.AppendUnique(podFw, "-j", policy)
+		filterTable.NewChain(policy)
+		npc.auditChain(policy, "network policy default/web")
+		for _, port := range ports {
+			filterTable.AppendUnique(policy, "-p", "tcp", "--dport", port, "-j", "ACCEPT")
+		}
+		npc.auditChainRules(filterTable)
+		npc.auditedChains = nil
+	}
+	sync("1", "80")
+	if err := ioutil.WriteFile(f.Name(), nil, 0600); err != nil {
+		t.Fatalf("failed to truncate audit log: %v", err)
+	}
+	// the chains of the next sync are versioned, only the port changed
+	sync("2", "443")
+	sync("3")
+	audit.Close()
+
+	out, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	records := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var record utils.AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode audit record %q: %v", line, err)
+		}
+		rule, _ := record.Details["rule"].(string)
+		records = append(records, strings.TrimSpace(record.Action+" "+record.Object+" "+rule))
+	}
+	expected := []string{
+		"rule_added network policy default/web -p tcp --dport 443 -j ACCEPT",
+		"rule_removed network policy default/web -p tcp --dport 80 -j ACCEPT",
+		// the chain of a failed policy is left empty
+		"rule_removed network policy default/web -p tcp --dport 443 -j ACCEPT",
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected audit records %v, got %v", expected, records)
+	}
+}
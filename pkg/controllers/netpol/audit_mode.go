@@ -0,0 +1,118 @@
+package netpol
+
+import (
+	"strconv"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// In audit mode the traffic the network policies would drop is logged to the drop log and accepted, so new policies
+// can be observed before they are enforced. All the policies are audited with --netpol-audit-mode, otherwise the
+// policies annotated with kube-router.io/netpol-audit=true. A pod is audited in a direction when all the policies
+// isolating it in that direction are. The audited policies are left out of the pod firewall chains of the pods an
+// enforced policy isolates in the same direction, so they never allow traffic the enforced policies drop.
+
+const (
+	auditAnnotation = "kube-router.io/netpol-audit"
+	// --nflog-prefix of the packets the audit rules log, the traffic the network policies would drop
+	auditLogPrefix = "audit"
+	// comment of the rules accepting the traffic the network policies would drop, the prefix of it
+	auditAcceptComment = "rule to ACCEPT traffic in audit mode"
+)
+
+// podAudit is the directions a pod is audited in
+type podAudit struct {
+	ingress bool
+	egress  bool
+}
+
+// isAudited tells whether the annotations of a network policy put it in audit mode
+func isAudited(annotations map[string]string) bool {
+	audit, _ := strconv.ParseBool(annotations[auditAnnotation])
+	return audit
+}
+
+func (policy networkPolicyInfo) isolatesIngress() bool {
+	return policy.policyType == "both" || policy.policyType == "ingress"
+}
+
+func (policy networkPolicyInfo) isolatesEgress() bool {
+	return policy.policyType == "both" || policy.policyType == "egress"
+}
+
+// podAudit returns the directions the pod with the address is audited in
+func (npc *NetworkPolicyController) podAudit(ip string) podAudit {
+	var ingress, egress, enforcedIngress, enforcedEgress bool
+	if npc.networkPoliciesInfo == nil {
+		return podAudit{}
+	}
+	for _, policy := range *npc.networkPoliciesInfo {
+		if _, ok := policy.targetPods[ip]; !ok {
+			continue
+		}
+		if policy.isolatesIngress() {
+			ingress = true
+			enforcedIngress = enforcedIngress || !policy.audit
+		}
+		if policy.isolatesEgress() {
+			egress = true
+			enforcedEgress = enforcedEgress || !policy.audit
+		}
+	}
+	return podAudit{ingress: ingress && !enforcedIngress, egress: egress && !enforcedEgress}
+}
+
+// runsThrough tells whether the pod firewall chain of the pod audited in the directions runs through the policy
+func (audit podAudit) runsThrough(policy networkPolicyInfo) bool {
+	return !policy.audit ||
+		((!policy.isolatesIngress() || audit.ingress) && (!policy.isolatesEgress() || audit.egress))
+}
+
+// directions returns the address match of each direction the pod is audited in, -d for the ingress and -s for the
+// egress
+func (audit podAudit) directions() []string {
+	directions := make([]string, 0, 2)
+	if audit.ingress {
+		directions = append(directions, "-d")
+	}
+	if audit.egress {
+		directions = append(directions, "-s")
+	}
+	return directions
+}
+
+// appendAuditRules appends the rules logging the traffic of the audited directions of the pod no network policy
+// accepted to the drop log, and accepting it, to the pod firewall chain ahead of the drop rules
+func (npc *NetworkPolicyController) appendAuditRules(filterTable *utils.IPTablesRestore, pod podInfo,
+	podFwChainName string) {
+	for _, direction := range npc.podAudit(pod.ip).directions() {
+		if npc.dropLogGroup != 0 {
+			comment := "rule to log traffic in audit mode POD name:" + pod.name + " namespace: " + pod.namespace
+			filterTable.AppendUnique(podFwChainName, "-m", "comment", "--comment", comment, direction, pod.ip,
+				"-j", "NFLOG", "--nflog-group", strconv.Itoa(int(npc.dropLogGroup)), "--nflog-prefix", auditLogPrefix,
+				"-m", "limit", "--limit", npc.dropLogLimit, "--limit-burst", "10")
+		}
+		comment := auditAcceptComment + " POD name:" + pod.name + " namespace: " + pod.namespace
+		filterTable.AppendUnique(podFwChainName, "-m", "comment", "--comment", comment, direction, pod.ip,
+			"-j", "ACCEPT")
+	}
+}
+
+// nftAuditRules is the counterpart of appendAuditRules for the nftables backend
+func (npc *NetworkPolicyController) nftAuditRules(pod podInfo) []string {
+	rules := make([]string, 0)
+	for _, direction := range npc.podAudit(pod.ip).directions() {
+		match := "ip daddr " + pod.ip
+		if direction == "-s" {
+			match = "ip saddr " + pod.ip
+		}
+		if npc.dropLogGroup != 0 {
+			rules = append(rules, match+" limit rate "+npc.dropLogLimit+" burst 10 packets log prefix \""+
+				auditLogPrefix+"\" group "+strconv.Itoa(int(npc.dropLogGroup))+" "+
+				nftComment("rule to log traffic in audit mode POD name:"+pod.name+" namespace: "+pod.namespace))
+		}
+		rules = append(rules, match+" accept "+
+			nftComment(auditAcceptComment+" POD name:"+pod.name+" namespace: "+pod.namespace))
+	}
+	return rules
+}
@@ -0,0 +1,55 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func TestAuditMode(t *testing.T) {
+	web := podInfo{ip: "1.1.1.1", name: "web", namespace: "nsA"}
+	db := podInfo{ip: "1.1.1.2", name: "db", namespace: "nsA"}
+	enforced := networkPolicyInfo{name: "enforced", namespace: "nsA", policyType: "ingress",
+		targetPods: map[string]podInfo{db.ip: db}}
+	audited := networkPolicyInfo{name: "audited", namespace: "nsA", policyType: "both", audit: true,
+		targetPods: map[string]podInfo{web.ip: web, db.ip: db}}
+	policies := []networkPolicyInfo{enforced, audited}
+	npc := &NetworkPolicyController{dropLogGroup: 100, dropLogLimit: "10/minute", networkPoliciesInfo: &policies}
+
+	if audit := npc.podAudit(web.ip); audit != (podAudit{ingress: true, egress: true}) || !audit.runsThrough(audited) {
+		t.Errorf("expected web audited in both directions through the audited policy, got %+v", audit)
+	}
+	// the ingress of db is enforced, the audited policy isolating it for ingress too must not allow more
+	if audit := npc.podAudit(db.ip); audit != (podAudit{egress: true}) || audit.runsThrough(audited) ||
+		!audit.runsThrough(enforced) {
+		t.Errorf("expected db audited for egress only without the audited policy, got %+v", audit)
+	}
+
+	filterTable := utils.NewIPTablesRestore("filter")
+	filterTable.NewChain("KUBE-POD-FW-DB")
+	npc.appendPodFwDropRules(filterTable, db, "KUBE-POD-FW-DB")
+	expected := `*filter
+:KUBE-POD-FW-DB - [0:0]
+-A KUBE-POD-FW-DB -m comment --comment "rule to log traffic in audit mode POD name:db namespace: nsA" -s 1.1.1.2 -j NFLOG --nflog-group 100 --nflog-prefix audit -m limit --limit 10/minute --limit-burst 10
+-A KUBE-POD-FW-DB -m comment --comment "rule to ACCEPT traffic in audit mode POD name:db namespace: nsA" -s 1.1.1.2 -j ACCEPT
+-A KUBE-POD-FW-DB -m comment --comment "rule to log dropped traffic POD name:db namespace: nsA" -j NFLOG --nflog-group 100 -m limit --limit 10/minute --limit-burst 10
+-A KUBE-POD-FW-DB -m comment --comment "default rule to REJECT traffic destined for POD name:db namespace: nsA" -j REJECT
+COMMIT
+`
+	if input := string(filterTable.Bytes()); input != expected {
+		t.Errorf("expected iptables-restore input:\n%s\ngot:\n%s", expected, input)
+	}
+	if rules := npc.nftAuditRules(web); len(rules) != 4 || !strings.HasPrefix(rules[3], "ip saddr 1.1.1.1 accept") {
+		t.Errorf("expected the audit rules of both directions of web, got %q", rules)
+	}
+
+	event := DropEvent{Direction: "egress", Source: DropEndpoint{IP: db.ip, Kind: "Pod", Namespace: "nsA", Name: "db"},
+		Destination: DropEndpoint{IP: "198.51.100.1"}, Protocol: "TCP", Port: 443, Audit: true}
+	if message := denyEventMessage(event); !strings.HasPrefix(message, "Audit mode would have denied egress to 198.51.100.1") {
+		t.Errorf("unexpected message of the audited packet %q", message)
+	}
+	if isAudited(map[string]string{auditAnnotation: "false"}) || !isAudited(map[string]string{auditAnnotation: "true"}) {
+		t.Errorf("expected the policies annotated %s=true only to be audited", auditAnnotation)
+	}
+}
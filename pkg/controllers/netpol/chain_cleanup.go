@@ -0,0 +1,103 @@
+package netpol
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+// newIP6TablesCmdHandler returns the ip6tables executor, or nil when ip6tables is not available on the node
+func newIP6TablesCmdHandler() *iptables.IPTables {
+	iptablesCmdHandler, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	if err != nil {
+		glog.V(3).Infof("Skipping ip6tables: %s", err)
+		return nil
+	}
+	return iptablesCmdHandler
+}
+
+// deletePolicyChains deletes the dispatch chains and the rules of the FORWARD, OUTPUT and INPUT chains jumping to
+// them or to the pod firewall chains, then the pod firewall chains and the network policy chains
+func deletePolicyChains(iptablesCmdHandler *iptables.IPTables) error {
+	if err := deleteDispatchChains(iptablesCmdHandler); err != nil {
+		return err
+	}
+	return deleteStalePolicyChains(iptablesCmdHandler, nil, nil)
+}
+
+// deleteStalePolicyChains deletes the pod firewall and network policy chains not in the active maps, along with the
+// rules jumping to them. Nil maps delete all the chains.
+func deleteStalePolicyChains(iptablesCmdHandler *iptables.IPTables, activePolicyChains,
+	activePodFwChains map[string]bool) error {
+	chains, err := iptablesCmdHandler.ListChains("filter")
+	if err != nil {
+		return fmt.Errorf("Failed to list chains in filter table: %s", err)
+	}
+	stalePodFwChains := make([]string, 0)
+	stalePolicyChains := make([]string, 0)
+	stale := make(map[string]bool)
+	for _, chain := range chains {
+		if strings.HasPrefix(chain, kubePodFirewallChainPrefix) && !activePodFwChains[chain] {
+			stalePodFwChains = append(stalePodFwChains, chain)
+			stale[chain] = true
+		}
+		if strings.HasPrefix(chain, kubeNetworkPolicyChainPrefix) && !activePolicyChains[chain] {
+			stalePolicyChains = append(stalePolicyChains, chain)
+			stale[chain] = true
+		}
+	}
+
+	if len(stalePodFwChains) > 0 {
+		for _, chain := range []string{"FORWARD", "OUTPUT", "INPUT"} {
+			if _, err := utils.DeleteIPTablesRules(iptablesCmdHandler, "filter", chain, jumpsTo(stale)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(stalePolicyChains) > 0 {
+		for chain := range activePodFwChains {
+			if _, err := utils.DeleteIPTablesRules(iptablesCmdHandler, "filter", chain, jumpsTo(stale)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the pod firewall chains jump to the network policy chains, they go first
+	for _, chain := range append(stalePodFwChains, stalePolicyChains...) {
+		if err := iptablesCmdHandler.ClearChain("filter", chain); err != nil {
+			return fmt.Errorf("Failed to flush the rules in chain %s due to %s", chain, err)
+		}
+		if err := iptablesCmdHandler.DeleteChain("filter", chain); err != nil {
+			return fmt.Errorf("Failed to delete the chain %s due to %s", chain, err)
+		}
+		glog.V(2).Infof("Deleted chain: %s from the filter table", chain)
+	}
+	return nil
+}
+
+// jumpsTo returns the matcher of the rules jumping, or going, to one of the chains. Only the target of the rule is
+// compared, so a comment or a match naming a chain does not select the rule.
+func jumpsTo(chains map[string]bool) func(rule utils.IPTablesSaveRule) bool {
+	return func(rule utils.IPTablesSaveRule) bool {
+		return chains[rule.Target]
+	}
+}
+
+// jumpsToPodFirewall tells whether the rule jumps to a pod firewall chain
+func jumpsToPodFirewall(rule utils.IPTablesSaveRule) bool {
+	return strings.HasPrefix(rule.Target, kubePodFirewallChainPrefix)
+}
+
+// isStalePolicyIPSet tells whether the ipset is a network policy ipset not in the active map. The IPv6 sets are
+// named after the IPv4 set of the same peers, with the IPv6 prefix.
+func isStalePolicyIPSet(name string, activePolicyIPSets map[string]bool) bool {
+	name = strings.TrimPrefix(name, utils.IPv6SetPrefix)
+	if !strings.HasPrefix(name, kubeSourceIpSetPrefix) && !strings.HasPrefix(name, kubeDestinationIpSetPrefix) &&
+		!strings.HasPrefix(name, kubeFQDNIpSetPrefix) {
+		return false
+	}
+	return !activePolicyIPSets[name]
+}
@@ -0,0 +1,56 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func TestIsStalePolicyIPSet(t *testing.T) {
+	active := policySourcePodIpSetName("tenant-a", "web")
+	stale := policySourcePodIpSetName("tenant-a", "db")
+	activePolicyIPSets := map[string]bool{active: true}
+	testCases := []struct {
+		name  string
+		stale bool
+	}{
+		{active, false},
+		{stale, true},
+		{utils.IPv6SetPrefix + active, false},
+		{utils.IPv6SetPrefix + stale, true},
+		{"kube-router-pod-subnets", false},
+		{utils.IPv6SetPrefix + "kube-router-pod-subnets", false},
+		{policyFQDNIpSetName("tenant-a", "db"), true},
+	}
+	for _, tc := range testCases {
+		if got := isStalePolicyIPSet(tc.name, activePolicyIPSets); got != tc.stale {
+			t.Errorf("expected ipset %s stale to be %t, got %t", tc.name, tc.stale, got)
+		}
+	}
+}
+
+func TestJumpsTo(t *testing.T) {
+	stale := map[string]bool{"KUBE-POD-FW-AAAA": true, "KUBE-NWPLCY-BBBB": true}
+	for line, expected := range map[string]bool{
+		"-A FORWARD -d 10.1.0.5/32 -m comment --comment \"rule to jump traffic destined to POD\" -j KUBE-POD-FW-AAAA": true,
+		"-A KUBE-POD-FW-CCCC -m comment --comment \"run through nw policy web\" -j KUBE-NWPLCY-BBBB":                  true,
+		"-A KUBE-QRNT-DDDD -g KUBE-NWPLCY-BBBB": true,
+		// rules naming a stale chain only in their comment or in a longer chain name are kept
+		"-A FORWARD -m comment --comment \"moved from KUBE-POD-FW-AAAA\" -j ACCEPT":                      false,
+		"-A KUBE-POD-FW-CCCC -m comment --comment \"run through nw policy web\" -j KUBE-NWPLCY-BBBBCCCC": false,
+		"-A FORWARD -m comment --comment \"-j KUBE-POD-FW-AAAA\" -j KUBE-POD-FW-EEEE":                    false,
+	} {
+		rule, ok, err := utils.ParseIPTablesRule(line)
+		if err != nil || !ok {
+			t.Fatalf("unexpected error parsing %q: %v", line, err)
+		}
+		if got := jumpsTo(stale)(rule); got != expected {
+			t.Errorf("expected jumpsTo(%q) to be %v", line, expected)
+		}
+	}
+
+	rule, _, _ := utils.ParseIPTablesRule("-A OUTPUT -m comment --comment \"KUBE-POD-FW-AAAA\" -j ACCEPT")
+	if jumpsToPodFirewall(rule) {
+		t.Errorf("expected a rule naming a pod firewall chain in its comment not to jump to it")
+	}
+}
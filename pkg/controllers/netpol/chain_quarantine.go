@@ -0,0 +1,146 @@
+package netpol
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+// stale pod firewall and network policy chains are renamed with this prefix, without any reference to them,
+// and deleted once the quarantine window elapsed
+const kubeQuarantineChainPrefix = "KUBE-QRNT-"
+
+// chainQuarantine tracks the quarantined chains, so rules wrongly considered stale by a sync can still be
+// inspected, and restored by hand, for the duration of the quarantine window
+type chainQuarantine struct {
+	window time.Duration
+	// time each quarantined chain was quarantined at. Chains found quarantined by a previous instance are
+	// considered quarantined when first seen.
+	chains map[string]time.Time
+}
+
+func newChainQuarantine(window time.Duration) *chainQuarantine {
+	return &chainQuarantine{window: window, chains: make(map[string]time.Time)}
+}
+
+// quarantineChainName returns the name of the stale chain once quarantined, chain names end with a hash
+func quarantineChainName(chain string) string {
+	return kubeQuarantineChainPrefix + chain[strings.LastIndex(chain, "-")+1:]
+}
+
+// enabled tells whether stale chains are quarantined, instead of deleted right away
+func (q *chainQuarantine) enabled() bool {
+	return q != nil && q.window > 0
+}
+
+// quarantine renames the stale chain, which must not be referenced anymore
+func (q *chainQuarantine) quarantine(iptablesCmdHandler *iptables.IPTables, chain string) error {
+	quarantined := quarantineChainName(chain)
+	if err := iptablesCmdHandler.RenameChain("filter", chain, quarantined); err != nil {
+		return fmt.Errorf("Failed to quarantine the chain %s due to %s", chain, err)
+	}
+	q.chains[quarantined] = time.Now()
+	glog.V(2).Infof("Quarantined stale chain %s as %s", chain, quarantined)
+	return nil
+}
+
+// expired records the quarantined chains found in the filter table and returns the ones quarantined for longer
+// than the window, which are removed from the quarantine
+func (q *chainQuarantine) expired(chains []string, now time.Time) []string {
+	expired := make([]string, 0)
+	found := make(map[string]bool)
+	for _, chain := range chains {
+		if !strings.HasPrefix(chain, kubeQuarantineChainPrefix) {
+			continue
+		}
+		found[chain] = true
+		if q == nil {
+			expired = append(expired, chain)
+			continue
+		}
+		quarantined, ok := q.chains[chain]
+		if !ok {
+			quarantined = now
+			q.chains[chain] = now
+		}
+		if now.Sub(quarantined) >= q.window {
+			expired = append(expired, chain)
+			delete(q.chains, chain)
+		}
+	}
+	if q != nil {
+		for chain := range q.chains {
+			if !found[chain] {
+				delete(q.chains, chain)
+			}
+		}
+	}
+	return expired
+}
+
+// deleteExpired deletes the chains quarantined for longer than the window and returns the ipsets the
+// remaining quarantined chains still match on, which must not be destroyed yet
+func (q *chainQuarantine) deleteExpired(iptablesCmdHandler *iptables.IPTables) (map[string]bool, error) {
+	chains, err := iptablesCmdHandler.ListChains("filter")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chains of the filter table due to %s", err)
+	}
+	expired := q.expired(chains, time.Now())
+
+	// quarantined pod firewall chains may jump to quarantined network policy chains, all the expired chains
+	// are flushed before they are deleted
+	for _, chain := range expired {
+		if err := iptablesCmdHandler.ClearChain("filter", chain); err != nil {
+			return nil, fmt.Errorf("Failed to flush the rules in chain %s due to %s", chain, err)
+		}
+	}
+	for _, chain := range expired {
+		if err := iptablesCmdHandler.DeleteChain("filter", chain); err != nil {
+			return nil, fmt.Errorf("Failed to delete the chain %s due to %s", chain, err)
+		}
+		glog.V(2).Infof("Deleted quarantined chain: %s from the filter table", chain)
+	}
+
+	referencedIPSets := make(map[string]bool)
+	if q == nil {
+		return referencedIPSets, nil
+	}
+	for chain := range q.chains {
+		rules, err := iptablesCmdHandler.List("filter", chain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list rules of the chain %s due to %s", chain, err)
+		}
+		sets, err := matchSetNames(rules)
+		if err != nil {
+			return nil, err
+		}
+		for set := range sets {
+			referencedIPSets[set] = true
+		}
+	}
+	return referencedIPSets, nil
+}
+
+// matchSetNames returns the names of the ipsets matched by the rules listed by iptables -S
+func matchSetNames(listed []string) (map[string]bool, error) {
+	sets := make(map[string]bool)
+	for _, line := range listed {
+		rule, ok, err := utils.ParseIPTablesRule(line)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		for i := 0; i < len(rule.Args)-1; i++ {
+			if rule.Args[i] == "--match-set" {
+				sets[rule.Args[i+1]] = true
+			}
+		}
+	}
+	return sets, nil
+}
@@ -0,0 +1,53 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChainQuarantine(t *testing.T) {
+	if name := quarantineChainName(networkPolicyChainName("default", "allow-web", "1")); len(name) > 28 ||
+		!strings.HasPrefix(name, kubeQuarantineChainPrefix) {
+		t.Errorf("unexpected quarantined chain name %s", name)
+	}
+
+	now := time.Now()
+	q := newChainQuarantine(5 * time.Minute)
+	q.chains["KUBE-QRNT-OLD"] = now.Add(-10 * time.Minute)
+	q.chains["KUBE-QRNT-GONE"] = now.Add(-time.Minute)
+	q.chains["KUBE-QRNT-NEW"] = now.Add(-time.Minute)
+	chains := []string{"INPUT", "KUBE-POD-FW-ACTIVE", "KUBE-QRNT-OLD", "KUBE-QRNT-NEW", "KUBE-QRNT-LEFTOVER"}
+
+	expired := q.expired(chains, now)
+	if len(expired) != 1 || expired[0] != "KUBE-QRNT-OLD" {
+		t.Errorf("expected only KUBE-QRNT-OLD to expire, got %v", expired)
+	}
+	if _, ok := q.chains["KUBE-QRNT-LEFTOVER"]; !ok {
+		t.Errorf("expected the chain quarantined by a previous instance to be quarantined when first seen")
+	}
+	if _, ok := q.chains["KUBE-QRNT-GONE"]; ok {
+		t.Errorf("expected the chain no longer found to be forgotten")
+	}
+	remaining := []string{"INPUT", "KUBE-QRNT-NEW", "KUBE-QRNT-LEFTOVER"}
+	if expired := q.expired(remaining, now.Add(5*time.Minute)); len(expired) != 2 {
+		t.Errorf("expected the remaining chains to expire after the window, got %v", expired)
+	}
+
+	var disabled *chainQuarantine
+	if disabled.enabled() || len(disabled.expired(chains, now)) != 3 {
+		t.Errorf("expected quarantined chains to be deleted right away without quarantine")
+	}
+
+	sets, err := matchSetNames([]string{
+		"-N KUBE-QRNT-OLD",
+		"-A KUBE-QRNT-OLD -m set --match-set KUBE-SRC-AAAA src -m set --match-set KUBE-DST-BBBB dst -j ACCEPT",
+		"-A KUBE-QRNT-OLD -m comment --comment \"copied from --match-set KUBE-SRC-CCCC\" -j KUBE-QRNT-NEW",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sets) != 2 || !sets["KUBE-SRC-AAAA"] || !sets["KUBE-DST-BBBB"] {
+		t.Errorf("unexpected ipsets matched by the quarantined rules %v", sets)
+	}
+}
@@ -0,0 +1,211 @@
+package netpol
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ipset of the CIDRs and ports of the ClusterAllowLists, matched by a single rule of every pod firewall chain
+const clusterAllowListIPSetName = kubeSourceIpSetPrefix + "CLUSTER-ALLOWLIST"
+
+// allowListEntry is a CIDR allowed to a port, or a range of ports, of a ClusterAllowList
+type allowListEntry struct {
+	cidr     string
+	protocol string
+	port     int
+	endPort  int
+}
+
+func (e allowListEntry) ports() string {
+	if e.endPort == 0 || e.endPort == e.port {
+		return strconv.Itoa(e.port)
+	}
+	return strconv.Itoa(e.port) + "-" + strconv.Itoa(e.endPort)
+}
+
+// ipSetEntry returns the entry of the hash:net,port ipset
+func (e allowListEntry) ipSetEntry() string {
+	return e.cidr + "," + e.protocol + ":" + e.ports()
+}
+
+// nftElement returns the element of the concatenated set of the nftables backend
+func (e allowListEntry) nftElement() string {
+	return e.cidr + " . " + e.protocol + " . " + e.ports()
+}
+
+// newClusterAllowListInformer returns the informer of the ClusterAllowLists, which syncs the controller on their
+// changes
+func (npc *NetworkPolicyController) newClusterAllowListInformer(clientset kubernetes.Interface,
+	resync time.Duration) cache.SharedIndexInformer {
+	lw := crd.NewListWatch(clientset, crd.ClusterAllowListResource,
+		func() runtime.Object { return &crd.ClusterAllowListList{} },
+		func() runtime.Object { return &crd.ClusterAllowList{} })
+	informer := cache.NewSharedIndexInformer(utils.NewInstrumentedListWatch(crd.ClusterAllowListResource, lw),
+		&crd.ClusterAllowList{}, resync, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: npc.OnClusterAllowListUpdate,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			npc.OnClusterAllowListUpdate(newObj)
+		},
+		DeleteFunc: npc.OnClusterAllowListUpdate,
+	})
+	return informer
+}
+
+// OnClusterAllowListUpdate handles the changes of the ClusterAllowLists
+func (npc *NetworkPolicyController) OnClusterAllowListUpdate(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	list, ok := obj.(*crd.ClusterAllowList)
+	if !ok {
+		glog.Errorf("unexpected object type: %v", obj)
+		return
+	}
+	glog.V(2).Infof("Received update for cluster allow list: %s", list.Name)
+
+	if !npc.readyForUpdates {
+		glog.V(3).Infof("Skipping update to cluster allow list: %s, controller still performing bootup full-sync",
+			list.Name)
+		return
+	}
+
+	npc.syncClusterAllowLists()
+	npc.syncQueue.add(syncFull)
+}
+
+// syncClusterAllowLists reads the ClusterAllowList custom resources from the informer cache. Like the namespace
+// isolation profiles, they are read on the periodic syncs and on their changes.
+func (npc *NetworkPolicyController) syncClusterAllowLists() {
+	objs := npc.clusterAllowListInformer.GetIndexer().List()
+	lists := make([]crd.ClusterAllowList, 0, len(objs))
+	for _, obj := range objs {
+		lists = append(lists, *obj.(*crd.ClusterAllowList))
+	}
+	npc.setClusterAllowLists(lists)
+}
+
+// setClusterAllowLists keeps the entries of the valid lists, in the order of the entries of the ipset
+func (npc *NetworkPolicyController) setClusterAllowLists(lists []crd.ClusterAllowList) {
+	entries := make([]allowListEntry, 0)
+	seen := make(map[allowListEntry]bool)
+	for i := range lists {
+		if err := lists[i].Validate(); err != nil {
+			glog.Errorf("Ignoring cluster allow list %s: %s", lists[i].Name, err)
+			continue
+		}
+		for _, cidr := range lists[i].Spec.CIDRs {
+			// the ipset stores the network address of the CIDR
+			_, ipNet, _ := net.ParseCIDR(cidr)
+			for _, port := range lists[i].Spec.Ports {
+				protocol := port.Protocol
+				if protocol == "" {
+					protocol = api.ProtocolTCP
+				}
+				entry := allowListEntry{cidr: ipNet.String(), protocol: strings.ToLower(string(protocol)),
+					port: int(port.Port), endPort: int(port.EndPort)}
+				if !seen[entry] {
+					seen[entry] = true
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ipSetEntry() < entries[j].ipSetEntry()
+	})
+
+	npc.mu.Lock()
+	npc.clusterAllowList = entries
+	npc.mu.Unlock()
+}
+
+// clusterAllowListIPSetEntries returns the entries of the ipset of the cluster allow lists
+func (npc *NetworkPolicyController) clusterAllowListIPSetEntries() []string {
+	entries := make([]string, 0, len(npc.clusterAllowList))
+	for _, entry := range npc.clusterAllowList {
+		entries = append(entries, entry.ipSetEntry())
+	}
+	return entries
+}
+
+// syncClusterAllowListIPSet creates and refreshes the ipset of the cluster allow lists, when any
+func (npc *NetworkPolicyController) syncClusterAllowListIPSet(activePolicyIPSets map[string]bool) error {
+	if len(npc.clusterAllowList) == 0 {
+		return nil
+	}
+	set, err := npc.ipSetHandler.Create(clusterAllowListIPSetName, utils.TypeHashNetPort, utils.OptionTimeout, "0")
+	if err != nil {
+		return err
+	}
+	if err := set.Refresh(npc.clusterAllowListIPSetEntries(), utils.OptionTimeout, "0"); err != nil {
+		return err
+	}
+	activePolicyIPSets[set.Name] = true
+	return nil
+}
+
+// clusterAllowListRuleArgs returns the rule of the pod firewall chain accepting the traffic of the cluster allow
+// lists to the pod, or nil if there is none
+func (npc *NetworkPolicyController) clusterAllowListRuleArgs(podIP string) []string {
+	if len(npc.clusterAllowList) == 0 {
+		return nil
+	}
+	return []string{"-m", "comment", "--comment", "rule to permit the traffic of the cluster allow lists to pods",
+		"-m", "set", "--match-set", clusterAllowListIPSetName, "src,dst", "-d", podIP, "-j", "ACCEPT"}
+}
+
+// nftClusterAllowListRule is the counterpart of clusterAllowListRuleArgs for the nftables backend
+func (npc *NetworkPolicyController) nftClusterAllowListRule(podIP string) string {
+	if len(npc.clusterAllowList) == 0 {
+		return ""
+	}
+	return "ip daddr " + podIP + " ip saddr . meta l4proto . th dport @" + clusterAllowListIPSetName + " accept " +
+		nftComment("rule to permit the traffic of the cluster allow lists to pods")
+}
+
+// nftClusterAllowListSet returns the set of the cluster allow lists of the nftables backend, or nil if there is none
+func (npc *NetworkPolicyController) nftClusterAllowListSet() *nftSet {
+	if len(npc.clusterAllowList) == 0 {
+		return nil
+	}
+	elements := make([]string, 0, len(npc.clusterAllowList))
+	for _, entry := range npc.clusterAllowList {
+		elements = append(elements, entry.nftElement())
+	}
+	return &nftSet{name: clusterAllowListIPSetName, setType: "ipv4_addr . inet_proto . inet_service", interval: true,
+		elements: elements}
+}
+
+// clusterAllowListMatches tells whether the cluster allow lists allow the flow
+func clusterAllowListMatches(entries []allowListEntry, flow Flow) bool {
+	src := net.ParseIP(flow.Source)
+	for _, entry := range entries {
+		if !strings.EqualFold(entry.protocol, flow.Protocol) {
+			continue
+		}
+		endPort := entry.endPort
+		if endPort == 0 {
+			endPort = entry.port
+		}
+		if flow.Port < entry.port || flow.Port > endPort {
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(entry.cidr); err == nil && cidr.Contains(src) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package netpol
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSyncPodFirewallChainsClusterAllowList(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	// the lists are read from the informer cache, the entries found in several lists are kept once and the invalid
+	// lists are ignored
+	krNetPol.clusterAllowListInformer = krNetPol.newClusterAllowListInformer(fake.NewSimpleClientset(), 0)
+	for _, list := range []*crd.ClusterAllowList{
+		{ObjectMeta: metav1.ObjectMeta{Name: "metrics"}, Spec: crd.ClusterAllowListSpec{
+			CIDRs: []string{"192.168.0.1/24"},
+			Ports: []crd.ClusterAllowListPort{{Port: 9100}, {Protocol: v1.ProtocolUDP, Port: 8125, EndPort: 8126}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-exporter"}, Spec: crd.ClusterAllowListSpec{
+			CIDRs: []string{"192.168.0.0/24"}, Ports: []crd.ClusterAllowListPort{{Protocol: v1.ProtocolTCP, Port: 9100}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "invalid"}, Spec: crd.ClusterAllowListSpec{
+			CIDRs: []string{"192.168.1.0/24"}}},
+	} {
+		krNetPol.clusterAllowListInformer.GetIndexer().Add(list)
+	}
+	krNetPol.syncClusterAllowLists()
+	expectedEntries := []allowListEntry{
+		{cidr: "192.168.0.0/24", protocol: "tcp", port: 9100},
+		{cidr: "192.168.0.0/24", protocol: "udp", port: 8125, endPort: 8126},
+	}
+	if !reflect.DeepEqual(krNetPol.clusterAllowList, expectedEntries) {
+		t.Fatalf("expected cluster allow list entries %v but got %v", expectedEntries, krNetPol.clusterAllowList)
+	}
+
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+	netpol := tNetpol{
+		name:        "deny-all",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress:     []netv1.NetworkPolicyIngressRule{},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filterTable := utils.NewIPTablesRestore("filter")
+	if _, _, err := krNetPol.syncPodFirewallChains(filterTable, "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	podFwChain := podFirewallChainName("nsA", "web", "1")
+
+	// the allow list is accepted before the network policies are evaluated
+	input := string(filterTable.Bytes())
+	var positions []int
+	for _, rule := range []string{
+		"-A " + podFwChain + " -m comment --comment \"rule for stateful firewall for pod\"",
+		"-A " + podFwChain + " -m comment --comment \"rule to permit the traffic of the cluster allow lists to pods\" -m set --match-set " +
+			clusterAllowListIPSetName + " src,dst -d 1.1.1.1 -j ACCEPT\n",
+		"-A " + podFwChain + " -m comment --comment \"run through nw policy deny-all\"",
+	} {
+		if strings.Count(input, rule) != 1 {
+			t.Fatalf("expected %q once in the iptables-restore input:\n%s", rule, input)
+		}
+		positions = append(positions, strings.Index(input, rule))
+	}
+	if !sort.IntsAreSorted(positions) {
+		t.Errorf("expected the rules in order in the iptables-restore input:\n%s", input)
+	}
+
+	var allowListSet *desiredIPSet
+	sets := krNetPol.desiredIPSets()
+	for i := range sets {
+		if sets[i].name == clusterAllowListIPSetName {
+			allowListSet = &sets[i]
+		}
+	}
+	expected := [][]string{{"192.168.0.0/24,tcp:9100"}, {"192.168.0.0/24,udp:8125-8126"}}
+	if allowListSet == nil || allowListSet.setType != utils.TypeHashNetPort || !reflect.DeepEqual(allowListSet.entries, expected) {
+		t.Errorf("expected the ipset %s with entries %v, got %+v", clusterAllowListIPSetName, expected, allowListSet)
+	}
+
+	ruleset, _, _, err := krNetPol.renderNFTables()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	script := ruleset.script()
+	for _, line := range []string{
+		"type ipv4_addr . inet_proto . inet_service\n",
+		"elements = { 192.168.0.0/24 . tcp . 9100, 192.168.0.0/24 . udp . 8125-8126 }\n",
+		"ip daddr 1.1.1.1 ip saddr . meta l4proto . th dport @" + clusterAllowListIPSetName + " accept",
+	} {
+		if !strings.Contains(script, line) {
+			t.Errorf("expected %q in the nftables script:\n%s", line, script)
+		}
+	}
+
+	verdict := krNetPol.evaluateFlow(krNetPol.podsByIP(),
+		Flow{Source: "192.168.0.5", Destination: "1.1.1.1", Protocol: "UDP", Port: 8126})
+	if !verdict.Allowed {
+		t.Errorf("expected the flow allowed by the cluster allow lists, got %s", verdict.Reason)
+	}
+}
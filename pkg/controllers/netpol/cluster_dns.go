@@ -0,0 +1,190 @@
+package netpol
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ipset of the addresses and ports of the cluster DNS service and of its endpoints, the pods isolated for egress are
+// allowed to whatever their network policies
+const clusterDNSIPSetName = kubeDestinationIpSetPrefix + "CLUSTER-DNS"
+
+// clusterDNSEntry is an address and port of the cluster DNS, of the service or of one of its endpoints
+type clusterDNSEntry struct {
+	ip       string
+	protocol string
+	port     int
+}
+
+// ipSetEntry returns the entry of the hash:ip,port ipset
+func (e clusterDNSEntry) ipSetEntry() string {
+	return e.ip + "," + e.protocol + ":" + strconv.Itoa(e.port)
+}
+
+// nftElement returns the element of the concatenated set of the nftables backend
+func (e clusterDNSEntry) nftElement() string {
+	return e.ip + " . " + e.protocol + " . " + strconv.Itoa(e.port)
+}
+
+// clusterDNSEntries returns the addresses and ports of the service, by namespace/name, and of its ready endpoints,
+// each port with its own protocol. Traffic to a service proxied by IPVS is evaluated once sent to the endpoint,
+// other proxies may leave the service address to the pod firewalls.
+func clusterDNSEntries(serviceLister, endpointsLister cache.Indexer, service string) []clusterDNSEntry {
+	entries := make([]clusterDNSEntry, 0)
+	seen := make(map[clusterDNSEntry]bool)
+	add := func(ip string, protocol api.Protocol, port int32) {
+		p, err := policyPortProtocol(&protocol)
+		if err != nil || net.ParseIP(ip) == nil || port == 0 {
+			return
+		}
+		entry := clusterDNSEntry{ip: ip, protocol: strings.ToLower(p), port: int(port)}
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	if serviceLister != nil {
+		if obj, exists, err := serviceLister.GetByKey(service); err == nil && exists {
+			svc := obj.(*api.Service)
+			for _, port := range svc.Spec.Ports {
+				add(svc.Spec.ClusterIP, port.Protocol, port.Port)
+			}
+		}
+	}
+	if endpointsLister != nil {
+		if obj, exists, err := endpointsLister.GetByKey(service); err == nil && exists {
+			for _, subset := range obj.(*api.Endpoints).Subsets {
+				for _, address := range subset.Addresses {
+					for _, port := range subset.Ports {
+						add(address.IP, port.Protocol, port.Port)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ipSetEntry() < entries[j].ipSetEntry()
+	})
+	return entries
+}
+
+// resolveClusterDNS reads the addresses of the cluster DNS the pods are allowed to, when enabled, from the informer
+// caches. They are resolved again on every sync, the changes of the endpoints queuing a sync of the pods.
+func (npc *NetworkPolicyController) resolveClusterDNS() {
+	if npc.clusterDNSService == "" {
+		return
+	}
+	entries := clusterDNSEntries(npc.ServiceLister, npc.EndpointsLister, npc.clusterDNSService)
+	// warned once until the service has addresses again
+	if len(entries) == 0 && (npc.clusterDNS == nil || len(npc.clusterDNS) != 0) {
+		glog.Warningf("No address of the cluster DNS service %s, the DNS queries of the pods are left to the "+
+			"network policies", npc.clusterDNSService)
+	}
+	npc.clusterDNS = entries
+}
+
+// newEndpointsEventHandler queues a sync of the pods when the endpoints of the cluster DNS change
+func (npc *NetworkPolicyController) newEndpointsEventHandler() cache.ResourceEventHandler {
+	onUpdate := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		endpoints, ok := obj.(*api.Endpoints)
+		if !ok || endpoints.Namespace+"/"+endpoints.Name != npc.clusterDNSService || !npc.readyForUpdates {
+			return
+		}
+		glog.V(2).Infof("Received update to the endpoints of the cluster DNS service %s", npc.clusterDNSService)
+		npc.syncQueue.add(syncPods)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: onUpdate,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			onUpdate(newObj)
+		},
+		DeleteFunc: onUpdate,
+	}
+}
+
+// clusterDNSIPSetEntries returns the entries of the ipset of the cluster DNS
+func (npc *NetworkPolicyController) clusterDNSIPSetEntries() []string {
+	entries := make([]string, 0, len(npc.clusterDNS))
+	for _, entry := range npc.clusterDNS {
+		entries = append(entries, entry.ipSetEntry())
+	}
+	return entries
+}
+
+// clusterDNSServer returns the address and port of a UDP entry of the cluster DNS, empty when it has none
+func (npc *NetworkPolicyController) clusterDNSServer() string {
+	for _, entry := range npc.clusterDNS {
+		if entry.protocol == "udp" {
+			return net.JoinHostPort(entry.ip, strconv.Itoa(entry.port))
+		}
+	}
+	return ""
+}
+
+// syncClusterDNSIPSet creates and refreshes the ipset of the cluster DNS, when it has any address
+func (npc *NetworkPolicyController) syncClusterDNSIPSet(activePolicyIPSets map[string]bool) error {
+	if len(npc.clusterDNS) == 0 {
+		return nil
+	}
+	set, err := npc.ipSetHandler.Create(clusterDNSIPSetName, utils.TypeHashIPPort, utils.OptionTimeout, "0")
+	if err != nil {
+		return err
+	}
+	if err := set.Refresh(npc.clusterDNSIPSetEntries(), utils.OptionTimeout, "0"); err != nil {
+		return err
+	}
+	activePolicyIPSets[set.Name] = true
+	return nil
+}
+
+// clusterDNSRuleArgs returns the rule of the pod firewall chain accepting the traffic of the pod to the cluster DNS,
+// or nil if it has no address
+func (npc *NetworkPolicyController) clusterDNSRuleArgs(podIP string) []string {
+	if len(npc.clusterDNS) == 0 {
+		return nil
+	}
+	return []string{"-m", "comment", "--comment", "rule to permit the traffic of pods to the cluster DNS",
+		"-s", podIP, "-m", "set", "--match-set", clusterDNSIPSetName, "dst,dst", "-j", "ACCEPT"}
+}
+
+// nftClusterDNSRule is the counterpart of clusterDNSRuleArgs for the nftables backend
+func (npc *NetworkPolicyController) nftClusterDNSRule(podIP string) string {
+	if len(npc.clusterDNS) == 0 {
+		return ""
+	}
+	return "ip saddr " + podIP + " ip daddr . meta l4proto . th dport @" + clusterDNSIPSetName + " accept " +
+		nftComment("rule to permit the traffic of pods to the cluster DNS")
+}
+
+// nftClusterDNSSet returns the set of the cluster DNS of the nftables backend, or nil if it has no address
+func (npc *NetworkPolicyController) nftClusterDNSSet() *nftSet {
+	if len(npc.clusterDNS) == 0 {
+		return nil
+	}
+	elements := make([]string, 0, len(npc.clusterDNS))
+	for _, entry := range npc.clusterDNS {
+		elements = append(elements, entry.nftElement())
+	}
+	return &nftSet{name: clusterDNSIPSetName, setType: "ipv4_addr . inet_proto . inet_service", elements: elements}
+}
+
+// clusterDNSMatches tells whether the flow is to the cluster DNS
+func clusterDNSMatches(entries []clusterDNSEntry, flow Flow) bool {
+	for _, entry := range entries {
+		if entry.ip == flow.Destination && entry.port == flow.Port && strings.EqualFold(entry.protocol, flow.Protocol) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package netpol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSyncPodFirewallChainsClusterDNS(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.clusterDNSService = "kube-system/kube-dns"
+	krNetPol.ServiceLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	krNetPol.ServiceLister.Add(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+		Spec: v1.ServiceSpec{ClusterIP: "10.96.0.10", Ports: []v1.ServicePort{
+			{Name: "dns", Protocol: v1.ProtocolUDP, Port: 53},
+			{Name: "dns-tcp", Protocol: v1.ProtocolTCP, Port: 53},
+		}}})
+	krNetPol.EndpointsLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	krNetPol.EndpointsLister.Add(&v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+		Subsets: []v1.EndpointSubset{{
+			Addresses:         []v1.EndpointAddress{{IP: "10.1.2.3"}},
+			NotReadyAddresses: []v1.EndpointAddress{{IP: "10.1.2.4"}},
+			Ports: []v1.EndpointPort{
+				{Name: "dns", Protocol: v1.ProtocolUDP, Port: 5353},
+				{Name: "dns-tcp", Protocol: v1.ProtocolTCP, Port: 5353},
+			},
+		}}})
+
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+	httpsPort := intstr.FromInt(443)
+	netpol := tNetpol{
+		name:        "allow-https",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		egress:      []netv1.NetworkPolicyEgressRule{{Ports: []netv1.NetworkPolicyPort{{Port: &httpsPort}}}},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	krNetPol.resolveClusterDNS()
+	expectedEntries := []string{"10.1.2.3,tcp:5353", "10.1.2.3,udp:5353", "10.96.0.10,tcp:53", "10.96.0.10,udp:53"}
+	if entries := krNetPol.clusterDNSIPSetEntries(); !reflect.DeepEqual(entries, expectedEntries) {
+		t.Errorf("expected the cluster DNS entries %v, got %v", expectedEntries, entries)
+	}
+
+	filterTable := utils.NewIPTablesRestore("filter")
+	if _, _, err := krNetPol.syncPodFirewallChains(filterTable, "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rule := "-A " + podFirewallChainName("nsA", "web", "1") + " -m comment --comment \"rule to permit the traffic " +
+		"of pods to the cluster DNS\" -s 1.1.1.1 -m set --match-set " + clusterDNSIPSetName + " dst,dst -j ACCEPT\n"
+	if input := string(filterTable.Bytes()); strings.Count(input, rule) != 1 {
+		t.Errorf("expected %q once in the iptables-restore input:\n%s", rule, input)
+	}
+
+	ruleset, _, _, err := krNetPol.renderNFTables()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if script := ruleset.script(); !strings.Contains(script,
+		"ip saddr 1.1.1.1 ip daddr . meta l4proto . th dport @"+clusterDNSIPSetName+" accept") {
+		t.Errorf("expected the cluster DNS rule in the nftables script:\n%s", script)
+	}
+
+	for flow, allowed := range map[Flow]bool{
+		{Source: "1.1.1.1", Destination: "10.1.2.3", Protocol: "UDP", Port: 5353}: true,
+		{Source: "1.1.1.1", Destination: "10.96.0.10", Protocol: "TCP", Port: 53}: true,
+		{Source: "1.1.1.1", Destination: "10.1.2.4", Protocol: "UDP", Port: 5353}: false,
+		{Source: "1.1.1.1", Destination: "10.1.2.3", Protocol: "TCP", Port: 80}:   false,
+	} {
+		verdict := krNetPol.evaluateFlow(krNetPol.podsByIP(), flow)
+		if verdict.Allowed != allowed {
+			t.Errorf("flow %v: expected allowed %t, got %s", flow, allowed, verdict.Reason)
+		}
+	}
+}
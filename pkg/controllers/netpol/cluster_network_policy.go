@@ -0,0 +1,467 @@
+package netpol
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The ClusterNetworkPolicy custom resources, watched by their own informer, are rendered into a chain every pod
+// firewall chain jumps to ahead of the network policies of the pod, so their Allow and Deny rules apply to the pods of
+// all the namespaces, or of the namespaces they select, whatever their network policies. The pods they apply to get a
+// pod firewall chain even when no network policy isolates them, which accepts the traffic the chain does not decide.
+// The chain runs through a chain per tier, the ClusterAdmin tier then the Platform tier, and the network policies of
+// the namespaces make up the last tier, the tenant one. The first rule of a tier matching the traffic decides: Allow
+// accepts it, Deny rejects it and Pass returns from the chain of the tier so the traffic goes on to the next tier, like
+// the traffic no rule of the tier matches. Like the network policy chains, the chains are versioned and replaced on
+// every sync.
+
+// clusterNetworkPolicyTiers are the tiers of the ClusterNetworkPolicies, in the order the traffic runs through them
+var clusterNetworkPolicyTiers = []string{crd.ClusterNetworkPolicyTierClusterAdmin, crd.ClusterNetworkPolicyTierPlatform}
+
+// clusterNetworkPolicyInfo is a ClusterNetworkPolicy as the sync renders it
+type clusterNetworkPolicyInfo struct {
+	name     string
+	tier     string
+	priority int32
+	// target of the rules, ACCEPT, REJECT or RETURN
+	target string
+	// IPs of the pods of the namespaces the policy applies to
+	pods         []string
+	ingressRules []clusterNetworkPolicyRuleInfo
+	egressRules  []clusterNetworkPolicyRuleInfo
+}
+
+type clusterNetworkPolicyRuleInfo struct {
+	ipBlocks [][]string
+	// the rule matches all the ports when empty
+	ports []protocolAndPort
+}
+
+// newClusterNetworkPolicyInformer returns the informer of the ClusterNetworkPolicies, which syncs the controller on
+// their changes
+func (npc *NetworkPolicyController) newClusterNetworkPolicyInformer(clientset kubernetes.Interface,
+	resync time.Duration) cache.SharedIndexInformer {
+	lw := crd.NewListWatch(clientset, crd.ClusterNetworkPolicyResource,
+		func() runtime.Object { return &crd.ClusterNetworkPolicyList{} },
+		func() runtime.Object { return &crd.ClusterNetworkPolicy{} })
+	informer := cache.NewSharedIndexInformer(utils.NewInstrumentedListWatch(crd.ClusterNetworkPolicyResource, lw),
+		&crd.ClusterNetworkPolicy{}, resync, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: npc.OnClusterNetworkPolicyUpdate,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			npc.OnClusterNetworkPolicyUpdate(newObj)
+		},
+		DeleteFunc: npc.OnClusterNetworkPolicyUpdate,
+	})
+	return informer
+}
+
+// OnClusterNetworkPolicyUpdate handles the changes of the ClusterNetworkPolicies
+func (npc *NetworkPolicyController) OnClusterNetworkPolicyUpdate(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	policy, ok := obj.(*crd.ClusterNetworkPolicy)
+	if !ok {
+		glog.Errorf("unexpected object type: %v", obj)
+		return
+	}
+	glog.V(2).Infof("Received update for cluster network policy: %s", policy.Name)
+
+	if !npc.readyForUpdates {
+		glog.V(3).Infof("Skipping update to cluster network policy: %s, controller still performing bootup "+
+			"full-sync", policy.Name)
+		return
+	}
+
+	npc.syncQueue.add(syncFull)
+}
+
+// buildClusterNetworkPolicies builds the ClusterNetworkPolicies of the informer cache, in the order of their tiers
+// and of their chains
+func (npc *NetworkPolicyController) buildClusterNetworkPolicies() error {
+	if npc.cnpLister == nil {
+		return nil
+	}
+	resources := make([]crd.ClusterNetworkPolicy, 0)
+	for _, obj := range npc.cnpLister.List() {
+		resources = append(resources, *obj.(*crd.ClusterNetworkPolicy))
+	}
+	return npc.setClusterNetworkPolicies(resources)
+}
+
+// setClusterNetworkPolicies builds the valid ClusterNetworkPolicies, in the order of their tiers and of their chains
+func (npc *NetworkPolicyController) setClusterNetworkPolicies(resources []crd.ClusterNetworkPolicy) error {
+	policies := make([]clusterNetworkPolicyInfo, 0)
+	for i := range resources {
+		policy := &resources[i]
+		if err := policy.Validate(); err != nil {
+			glog.Errorf("Ignoring cluster network policy %s: %s", policy.Name, err)
+			continue
+		}
+		info, err := npc.buildClusterNetworkPolicy(policy)
+		if err != nil {
+			return err
+		}
+		policies = append(policies, info)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].tier != policies[j].tier {
+			return tierIndex(policies[i].tier) < tierIndex(policies[j].tier)
+		}
+		if policies[i].priority != policies[j].priority {
+			return policies[i].priority < policies[j].priority
+		}
+		return policies[i].name < policies[j].name
+	})
+	npc.clusterNetworkPolicies = policies
+	return nil
+}
+
+func (npc *NetworkPolicyController) buildClusterNetworkPolicy(policy *crd.ClusterNetworkPolicy) (
+	clusterNetworkPolicyInfo, error) {
+	info := clusterNetworkPolicyInfo{name: policy.Name, tier: policy.Spec.Tier, priority: policy.Spec.Priority,
+		target: "ACCEPT"}
+	if info.tier == "" {
+		info.tier = crd.ClusterNetworkPolicyTierClusterAdmin
+	}
+	switch policy.Spec.Action {
+	case crd.ClusterNetworkPolicyActionDeny:
+		info.target = "REJECT"
+	case crd.ClusterNetworkPolicyActionPass:
+		info.target = "RETURN"
+	}
+
+	namespaceSelector := labels.Everything()
+	if policy.Spec.NamespaceSelector != nil {
+		namespaceSelector, _ = metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	}
+	namespaces, err := npc.ListNamespaceByLabels(namespaceSelector)
+	if err != nil {
+		return info, errors.New("Failed to build cluster network policies due to " + err.Error())
+	}
+	for _, namespace := range namespaces {
+		pods, err := npc.ListPodsByNamespaceAndLabels(namespace.Name, labels.Everything())
+		if err != nil {
+			return info, errors.New("Failed to build cluster network policies due to " + err.Error())
+		}
+		for _, pod := range pods {
+			if pod.Status.PodIP == "" || pod.Spec.HostNetwork {
+				continue
+			}
+			info.pods = append(info.pods, pod.Status.PodIP)
+		}
+	}
+	sort.Strings(info.pods)
+
+	buildRules := func(rules []crd.ClusterNetworkPolicyRule) ([]clusterNetworkPolicyRuleInfo, error) {
+		infos := make([]clusterNetworkPolicyRuleInfo, 0, len(rules))
+		for _, rule := range rules {
+			ruleInfo := clusterNetworkPolicyRuleInfo{ipBlocks: make([][]string, 0)}
+			for i := range rule.IPBlocks {
+				ruleInfo.ipBlocks = append(ruleInfo.ipBlocks,
+					npc.evalIPBlockPeer(networking.NetworkPolicyPeer{IPBlock: &rule.IPBlocks[i]})...)
+			}
+			for _, account := range rule.ServiceAccounts {
+				ips, err := npc.serviceAccountPodIPs(account.Namespace, account.Name)
+				if err != nil {
+					return nil, errors.New("Failed to build cluster network policies due to " + err.Error())
+				}
+				for _, ip := range ips {
+					ruleInfo.ipBlocks = append(ruleInfo.ipBlocks, []string{ip + "/32", utils.OptionTimeout, "0"})
+				}
+			}
+			for _, port := range rule.Ports {
+				protocol := port.Protocol
+				if protocol == "" {
+					protocol = api.ProtocolTCP
+				}
+				dPort := ""
+				if port.Port != 0 {
+					dPort = portRange(strconv.Itoa(int(port.Port)), port.EndPort)
+				}
+				ruleInfo.ports = append(ruleInfo.ports, protocolAndPort{protocol: string(protocol), port: dPort})
+			}
+			infos = append(infos, ruleInfo)
+		}
+		return infos, nil
+	}
+	if info.ingressRules, err = buildRules(policy.Spec.Ingress); err != nil {
+		return info, err
+	}
+	if info.egressRules, err = buildRules(policy.Spec.Egress); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// serviceAccountPodIPs returns the IPv4 addresses of the pods of the namespace running as the service account, from
+// the pod lister. The pods not naming a service account run as the default one.
+func (npc *NetworkPolicyController) serviceAccountPodIPs(namespace, name string) ([]string, error) {
+	pods, err := npc.ListPodsByNamespaceAndLabels(namespace, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0)
+	for _, pod := range pods {
+		account := pod.Spec.ServiceAccountName
+		if account == "" {
+			account = "default"
+		}
+		if account != name || pod.Status.PodIP == "" || pod.Spec.HostNetwork {
+			continue
+		}
+		if ip := net.ParseIP(pod.Status.PodIP); ip != nil && ip.To4() != nil {
+			ips = append(ips, pod.Status.PodIP)
+		}
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// tierIndex returns the position of the tier in the order the traffic runs through the tiers
+func tierIndex(tier string) int {
+	for i, t := range clusterNetworkPolicyTiers {
+		if t == tier {
+			return i
+		}
+	}
+	return len(clusterNetworkPolicyTiers)
+}
+
+// clusterNetworkPolicyChainName returns the name of the chain of the ClusterNetworkPolicies of the sync, a network
+// policy chain no namespaced network policy can collide with as namespace names hold no space
+func clusterNetworkPolicyChainName(version string) string {
+	hash := sha256.Sum256([]byte("cluster network policies" + version))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+	return kubeNetworkPolicyChainPrefix + encoded[:16]
+}
+
+// clusterNetworkPolicyTierChainName returns the name of the chain of the ClusterNetworkPolicies of a tier of the sync
+func clusterNetworkPolicyTierChainName(tier, version string) string {
+	hash := sha256.Sum256([]byte("cluster network policies " + tier + version))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+	return kubeNetworkPolicyChainPrefix + encoded[:16]
+}
+
+// clusterNetworkPolicyPodIPSetName returns the name of the ipset of the pods a ClusterNetworkPolicy applies to
+func clusterNetworkPolicyPodIPSetName(policyName string) string {
+	return utils.HashedIPSetName(kubeDestinationIpSetPrefix, "cluster network policy "+policyName, false)
+}
+
+// clusterNetworkPolicyIPBlockIPSetName returns the name of the ipset of the IP blocks of a rule of a
+// ClusterNetworkPolicy
+func clusterNetworkPolicyIPBlockIPSetName(policyName, direction string, ruleNo int) string {
+	return utils.HashedIPSetName(kubeSourceIpSetPrefix, "cluster network policy "+policyName+" "+direction+"rule"+
+		strconv.Itoa(ruleNo), false)
+}
+
+// clusterNetworkPolicyIPSets returns the ipsets of the ClusterNetworkPolicies
+func (npc *NetworkPolicyController) clusterNetworkPolicyIPSets() []desiredIPSet {
+	sets := make([]desiredIPSet, 0)
+	for _, policy := range npc.clusterNetworkPolicies {
+		pods := make([][]string, 0, len(policy.pods))
+		for _, ip := range policy.pods {
+			pods = append(pods, []string{ip})
+		}
+		sets = append(sets, desiredIPSet{name: clusterNetworkPolicyPodIPSetName(policy.name),
+			setType: utils.TypeHashIP, entries: pods})
+		for _, direction := range []string{"ingress", "egress"} {
+			rules := policy.ingressRules
+			if direction == "egress" {
+				rules = policy.egressRules
+			}
+			for i, rule := range rules {
+				entries := make([][]string, len(rule.ipBlocks))
+				copy(entries, rule.ipBlocks)
+				sets = append(sets, desiredIPSet{name: clusterNetworkPolicyIPBlockIPSetName(policy.name, direction, i),
+					setType: utils.TypeHashNet, ipBlocks: true, entries: entries})
+			}
+		}
+	}
+	return sets
+}
+
+// clusterNetworkPolicyRulesArgs returns the rules of the chains of the tiers of the ClusterNetworkPolicies, in the
+// order of the tiers and of the policies
+func (npc *NetworkPolicyController) clusterNetworkPolicyRulesArgs() [][]string {
+	rules := make([][]string, 0)
+	for _, tier := range clusterNetworkPolicyTiers {
+		rules = append(rules, npc.clusterNetworkPolicyTierRulesArgs(tier)...)
+	}
+	return rules
+}
+
+// clusterNetworkPolicyTierRulesArgs returns the rules of the chain of a tier of the ClusterNetworkPolicies, in their
+// order
+func (npc *NetworkPolicyController) clusterNetworkPolicyTierRulesArgs(tier string) [][]string {
+	rules := make([][]string, 0)
+	for _, policy := range npc.clusterNetworkPolicies {
+		if policy.tier != tier {
+			continue
+		}
+		podSetName := clusterNetworkPolicyPodIPSetName(policy.name)
+		verb := "ALLOW"
+		switch policy.target {
+		case "REJECT":
+			verb = "DENY"
+		case "RETURN":
+			verb = "PASS"
+		}
+		for _, direction := range []string{"ingress", "egress"} {
+			policyRules := policy.ingressRules
+			if direction == "egress" {
+				policyRules = policy.egressRules
+			}
+			comment := "rule to " + verb + " " + direction + " traffic of cluster network policy " + policy.name
+			for i, rule := range policyRules {
+				ipBlockSetName := clusterNetworkPolicyIPBlockIPSetName(policy.name, direction, i)
+				srcSetName, dstSetName := ipBlockSetName, podSetName
+				if direction == "egress" {
+					srcSetName, dstSetName = podSetName, ipBlockSetName
+				}
+				ports := rule.ports
+				if len(ports) == 0 {
+					ports = []protocolAndPort{{}}
+				}
+				for _, port := range ports {
+					args := policyRuleArgs(nil, comment, srcSetName, dstSetName, port.protocol, port.port)
+					args[len(args)-1] = policy.target
+					rules = append(rules, args)
+				}
+			}
+		}
+	}
+	return rules
+}
+
+// syncClusterNetworkPolicyChain creates the ipsets and the chain of the ClusterNetworkPolicies, when any
+func (npc *NetworkPolicyController) syncClusterNetworkPolicyChain(filterTable *utils.IPTablesRestore, version string,
+	activePolicyChains, activePolicyIPSets map[string]bool) error {
+	if len(npc.clusterNetworkPolicies) == 0 {
+		return nil
+	}
+	for _, set := range npc.clusterNetworkPolicyIPSets() {
+		ipset, err := npc.ipSetHandler.Create(set.name, set.setType, utils.OptionTimeout, "0")
+		if err != nil {
+			return fmt.Errorf("failed to create ipset: %s", err.Error())
+		}
+		if set.ipBlocks {
+			err = ipset.RefreshWithBuiltinOptions(set.entries)
+		} else {
+			ips := make([]string, 0, len(set.entries))
+			for _, entry := range set.entries {
+				ips = append(ips, entry[0])
+			}
+			err = ipset.Refresh(ips, utils.OptionTimeout, "0")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to refresh ipset %s: %s", set.name, err.Error())
+		}
+		activePolicyIPSets[set.name] = true
+	}
+	npc.renderClusterNetworkPolicyChains(filterTable, version, activePolicyChains)
+	return nil
+}
+
+// renderClusterNetworkPolicyChains adds the chain of the ClusterNetworkPolicies and the chains of their tiers to
+// filterTable
+func (npc *NetworkPolicyController) renderClusterNetworkPolicyChains(filterTable *utils.IPTablesRestore,
+	version string, activePolicyChains map[string]bool) {
+	chain := clusterNetworkPolicyChainName(version)
+	filterTable.NewChain(chain)
+	activePolicyChains[chain] = true
+	npc.auditChain(chain, "cluster network policies")
+	for _, tier := range clusterNetworkPolicyTiers {
+		rules := npc.clusterNetworkPolicyTierRulesArgs(tier)
+		if len(rules) == 0 {
+			continue
+		}
+		tierChain := clusterNetworkPolicyTierChainName(tier, version)
+		filterTable.NewChain(tierChain)
+		npc.auditChain(tierChain, "cluster network policies tier "+tier)
+		for _, args := range rules {
+			filterTable.AppendUnique(tierChain, args...)
+		}
+		activePolicyChains[tierChain] = true
+		filterTable.AppendUnique(chain, "-m", "comment", "--comment",
+			"run through cluster network policy tier "+tier, "-j", tierChain)
+	}
+}
+
+// clusterNetworkPolicyJumpArgs returns the rule of the pod firewall chains jumping to the chain of the
+// ClusterNetworkPolicies of the sync, or nil if there is none
+func (npc *NetworkPolicyController) clusterNetworkPolicyJumpArgs(version string) []string {
+	if len(npc.clusterNetworkPolicies) == 0 {
+		return nil
+	}
+	return []string{"-m", "comment", "--comment", "run through cluster network policies",
+		"-j", clusterNetworkPolicyChainName(version)}
+}
+
+// clusterNetworkPoliciesSelectPod returns whether a ClusterNetworkPolicy with rules in the direction, ingress or
+// egress, applies to the pod with the IP
+func (npc *NetworkPolicyController) clusterNetworkPoliciesSelectPod(podIP, direction string) bool {
+	for _, policy := range npc.clusterNetworkPolicies {
+		rules := policy.ingressRules
+		if direction == "egress" {
+			rules = policy.egressRules
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		if i := sort.SearchStrings(policy.pods, podIP); i < len(policy.pods) && policy.pods[i] == podIP {
+			return true
+		}
+	}
+	return false
+}
+
+// appendClusterNetworkPolicyAcceptRules appends the rules accepting the traffic of the directions of the pod the
+// ClusterNetworkPolicies apply to but no network policy isolates, which only runs through the chain of the
+// ClusterNetworkPolicies, to the pod firewall chain
+func (npc *NetworkPolicyController) appendClusterNetworkPolicyAcceptRules(filterTable *utils.IPTablesRestore,
+	pod podInfo, podFwChainName string) {
+	for _, direction := range []string{"ingress", "egress"} {
+		if !npc.clusterNetworkPoliciesSelectPod(pod.ip, direction) ||
+			npc.policiesIsolatePod(pod.namespace, pod.ip, direction) {
+			continue
+		}
+		match := "-d"
+		if direction == "egress" {
+			match = "-s"
+		}
+		comment := "rule to ACCEPT " + direction + " traffic of POD name:" + pod.name + " namespace: " +
+			pod.namespace + " not isolated by network policies"
+		filterTable.AppendUnique(podFwChainName, "-m", "comment", "--comment", comment, match, pod.ip, "-j", "ACCEPT")
+	}
+}
+
+// writeClusterNetworkPoliciesDigest writes the rules of the ClusterNetworkPolicies, their pods and IP blocks are
+// ipset entries
+func (npc *NetworkPolicyController) writeClusterNetworkPoliciesDigest(h hash.Hash) {
+	for _, tier := range clusterNetworkPolicyTiers {
+		for _, args := range npc.clusterNetworkPolicyTierRulesArgs(tier) {
+			fmt.Fprintf(h, "cluster network policy tier %s rule %q\n", tier, args)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package netpol
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSyncPodFirewallChainsClusterNetworkPolicies(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.cnpLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, spec := range []string{
+		`{"metadata": {"name": "deny-metadata"}, "spec": {"priority": 10, "action": "Deny", "egress": ` +
+			`[{"ipBlocks": [{"cidr": "169.254.169.254/32"}]}]}}`,
+		`{"metadata": {"name": "allow-monitoring"}, "spec": {"priority": 1, "action": "Allow", ` +
+			`"namespaceSelector": {"matchLabels": {"team": "a"}}, "ingress": [{"ipBlocks": [{"cidr": "192.168.0.0/24"}], ` +
+			`"ports": [{"port": 9100}]}]}}`,
+		`{"metadata": {"name": "invalid"}, "spec": {"action": "Log", "egress": [{"ipBlocks": [{"cidr": "10.0.0.0/8"}]}]}}`,
+	} {
+		policy := &crd.ClusterNetworkPolicy{}
+		if err := json.Unmarshal([]byte(spec), policy); err != nil {
+			t.Fatalf("unexpected error decoding cluster network policy: %v", err)
+		}
+		krNetPol.cnpLister.Add(policy)
+	}
+
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA",
+		Labels: map[string]string{"team": "a"}}})
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsB"}})
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "nsB", Labels: map[string]string{"app": "db"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.2"}})
+	netpol := tNetpol{
+		name:        "deny-all",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress:     []netv1.NetworkPolicyIngressRule{},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = krNetPol.buildClusterNetworkPolicies(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, policy := range krNetPol.clusterNetworkPolicies {
+		names = append(names, policy.name)
+	}
+	if !reflect.DeepEqual(names, []string{"allow-monitoring", "deny-metadata"}) {
+		t.Fatalf("expected the valid cluster network policies in the order of their priority, got %v", names)
+	}
+
+	pods := map[string][][]string{}
+	for _, set := range krNetPol.clusterNetworkPolicyIPSets() {
+		pods[set.name] = set.entries
+	}
+	if entries := pods[clusterNetworkPolicyPodIPSetName("allow-monitoring")]; !reflect.DeepEqual(entries,
+		[][]string{{"1.1.1.1"}}) {
+		t.Errorf("expected the pods of the selected namespaces in the ipset of allow-monitoring, got %v", entries)
+	}
+	if entries := pods[clusterNetworkPolicyPodIPSetName("deny-metadata")]; !reflect.DeepEqual(entries,
+		[][]string{{"1.1.1.1"}, {"1.1.1.2"}}) {
+		t.Errorf("expected the pods of all the namespaces in the ipset of deny-metadata, got %v", entries)
+	}
+
+	// the rules of the cluster network policies are in the order of their priority
+	var rules []string
+	for _, args := range krNetPol.clusterNetworkPolicyRulesArgs() {
+		rules = append(rules, strings.Join(args, " "))
+	}
+	expectedRules := []string{
+		"-m comment --comment rule to ALLOW ingress traffic of cluster network policy allow-monitoring " +
+			"-m set --match-set " + clusterNetworkPolicyIPBlockIPSetName("allow-monitoring", "ingress", 0) + " src " +
+			"-m set --match-set " + clusterNetworkPolicyPodIPSetName("allow-monitoring") + " dst -p TCP --dport 9100 -j ACCEPT",
+		"-m comment --comment rule to DENY egress traffic of cluster network policy deny-metadata " +
+			"-m set --match-set " + clusterNetworkPolicyPodIPSetName("deny-metadata") + " src " +
+			"-m set --match-set " + clusterNetworkPolicyIPBlockIPSetName("deny-metadata", "egress", 0) + " dst -j REJECT",
+	}
+	if !reflect.DeepEqual(rules, expectedRules) {
+		t.Errorf("expected the rules of the cluster network policies %q, got %q", expectedRules, rules)
+	}
+
+	// the pod firewall chains run through the cluster network policies right after the stateful rules
+	filterTable := utils.NewIPTablesRestore("filter")
+	if _, _, err = krNetPol.syncPodFirewallChains(filterTable, "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	podFwChain := podFirewallChainName("nsA", "web", "1")
+	input := string(filterTable.Bytes())
+	var positions []int
+	for _, rule := range []string{
+		"-A " + podFwChain + " -m comment --comment \"rule for stateful firewall for pod\"",
+		"-A " + podFwChain + " -m comment --comment \"run through cluster network policies\" -j " +
+			clusterNetworkPolicyChainName("1") + "\n",
+		"-A " + podFwChain + " -m comment --comment \"run through nw policy deny-all\"",
+	} {
+		if strings.Count(input, rule) != 1 {
+			t.Fatalf("expected %q once in the iptables-restore input:\n%s", rule, input)
+		}
+		positions = append(positions, strings.Index(input, rule))
+	}
+	if !sort.IntsAreSorted(positions) {
+		t.Errorf("expected the rules in order in the iptables-restore input:\n%s", input)
+	}
+
+	// the pods no network policy isolates in a direction the cluster network policies apply to accept the traffic of
+	// that direction past them
+	for _, pod := range []struct{ name, namespace, ip string }{{"web", "nsA", "1.1.1.1"}, {"db", "nsB", "1.1.1.2"}} {
+		podFwChain = podFirewallChainName(pod.namespace, pod.name, "1")
+		positions = nil
+		for _, rule := range []string{
+			"-A " + podFwChain + " -m comment --comment \"run through cluster network policies\" -j " +
+				clusterNetworkPolicyChainName("1") + "\n",
+			"-A " + podFwChain + " -m comment --comment \"rule to ACCEPT egress traffic of POD name:" + pod.name +
+				" namespace: " + pod.namespace + " not isolated by network policies\" -s " + pod.ip + " -j ACCEPT\n",
+			"-A " + podFwChain + " -m comment --comment \"default rule to REJECT traffic destined for POD name:" +
+				pod.name,
+		} {
+			if strings.Count(input, rule) != 1 {
+				t.Fatalf("expected %q once in the iptables-restore input:\n%s", rule, input)
+			}
+			positions = append(positions, strings.Index(input, rule))
+		}
+		if !sort.IntsAreSorted(positions) {
+			t.Errorf("expected the rules in order in the iptables-restore input:\n%s", input)
+		}
+		if strings.Contains(input, "rule to ACCEPT ingress traffic of POD name:"+pod.name) {
+			t.Errorf("expected no rule accepting the ingress traffic of pod %s:\n%s", pod.name, input)
+		}
+	}
+}
+
+func TestClusterNetworkPolicyServiceAccountPeers(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.cnpLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	policy := &crd.ClusterNetworkPolicy{}
+	spec := `{"metadata": {"name": "allow-prometheus"}, "spec": {"action": "Allow", "ingress": [{"serviceAccounts": ` +
+		`[{"namespace": "monitoring", "name": "prometheus"}], "ipBlocks": [{"cidr": "192.168.0.0/24"}], ` +
+		`"ports": [{"port": 9100}]}], "egress": [{"serviceAccounts": [{"namespace": "nsA", "name": "default"}]}]}}`
+	if err := json.Unmarshal([]byte(spec), policy); err != nil {
+		t.Fatalf("unexpected error decoding cluster network policy: %v", err)
+	}
+	krNetPol.cnpLister.Add(policy)
+
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "monitoring"}})
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+	for _, pod := range []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "prometheus-1", Namespace: "monitoring"},
+			Spec:   v1.PodSpec{ServiceAccountName: "prometheus"},
+			Status: v1.PodStatus{HostIP: "10.10.10.11", PodIP: "1.1.2.2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "prometheus-0", Namespace: "monitoring"},
+			Spec:   v1.PodSpec{ServiceAccountName: "prometheus"},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.2.1"}},
+		// running as another service account, or not started yet
+		{ObjectMeta: metav1.ObjectMeta{Name: "grafana", Namespace: "monitoring"},
+			Spec:   v1.PodSpec{ServiceAccountName: "grafana"},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.2.3"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "prometheus-2", Namespace: "monitoring"},
+			Spec:   v1.PodSpec{ServiceAccountName: "prometheus"},
+			Status: v1.PodStatus{HostIP: "10.10.10.10"}},
+		// running as the default service account
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA"},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}},
+	} {
+		tAddToInformerStore(t, cluster.podInformer, pod)
+	}
+
+	if err := krNetPol.buildClusterNetworkPolicies(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := map[string][][]string{}
+	for _, set := range krNetPol.clusterNetworkPolicyIPSets() {
+		entries[set.name] = set.entries
+	}
+	expected := map[string][][]string{
+		clusterNetworkPolicyIPBlockIPSetName("allow-prometheus", "ingress", 0): {
+			{"192.168.0.0/24", utils.OptionTimeout, "0"},
+			{"1.1.2.1/32", utils.OptionTimeout, "0"},
+			{"1.1.2.2/32", utils.OptionTimeout, "0"},
+		},
+		clusterNetworkPolicyIPBlockIPSetName("allow-prometheus", "egress", 0): {
+			{"1.1.1.1/32", utils.OptionTimeout, "0"},
+		},
+	}
+	for name, expectedEntries := range expected {
+		if !reflect.DeepEqual(entries[name], expectedEntries) {
+			t.Errorf("expected the entries %v in ipset %s, got %v", expectedEntries, name, entries[name])
+		}
+	}
+}
+
+func TestClusterNetworkPolicyTiers(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.cnpLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, spec := range []string{
+		`{"metadata": {"name": "deny-internal"}, "spec": {"tier": "Platform", "action": "Deny", "egress": ` +
+			`[{"ipBlocks": [{"cidr": "10.0.0.0/8"}]}]}}`,
+		`{"metadata": {"name": "pass-dns"}, "spec": {"priority": 5, "action": "Pass", "egress": ` +
+			`[{"ipBlocks": [{"cidr": "10.96.0.10/32"}], "ports": [{"protocol": "UDP", "port": 53}]}]}}`,
+		`{"metadata": {"name": "deny-metadata"}, "spec": {"priority": 10, "action": "Deny", "egress": ` +
+			`[{"ipBlocks": [{"cidr": "169.254.169.254/32"}]}]}}`,
+	} {
+		policy := &crd.ClusterNetworkPolicy{}
+		if err := json.Unmarshal([]byte(spec), policy); err != nil {
+			t.Fatalf("unexpected error decoding cluster network policy: %v", err)
+		}
+		krNetPol.cnpLister.Add(policy)
+	}
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+
+	if err := krNetPol.buildClusterNetworkPolicies(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, policy := range krNetPol.clusterNetworkPolicies {
+		names = append(names, policy.tier+"/"+policy.name)
+	}
+	// the policies without tier are in the ClusterAdmin tier, ahead of the Platform tier whatever their priority
+	expectedNames := []string{"ClusterAdmin/pass-dns", "ClusterAdmin/deny-metadata", "Platform/deny-internal"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("expected the cluster network policies %v, got %v", expectedNames, names)
+	}
+	if rules := krNetPol.clusterNetworkPolicyTierRulesArgs(crd.ClusterNetworkPolicyTierClusterAdmin); len(rules) != 2 ||
+		rules[0][len(rules[0])-1] != "RETURN" || rules[1][len(rules[1])-1] != "REJECT" {
+		t.Errorf("expected the Pass rule returning from the ClusterAdmin tier ahead of the Deny rule, got %q", rules)
+	}
+
+	// the chain of the cluster network policies runs through the chains of the tiers in order
+	filterTable := utils.NewIPTablesRestore("filter")
+	activePolicyChains := make(map[string]bool)
+	krNetPol.renderClusterNetworkPolicyChains(filterTable, "1", activePolicyChains)
+	chain := clusterNetworkPolicyChainName("1")
+	adminChain := clusterNetworkPolicyTierChainName(crd.ClusterNetworkPolicyTierClusterAdmin, "1")
+	platformChain := clusterNetworkPolicyTierChainName(crd.ClusterNetworkPolicyTierPlatform, "1")
+	expectedChains := map[string]bool{chain: true, adminChain: true, platformChain: true}
+	if !reflect.DeepEqual(activePolicyChains, expectedChains) {
+		t.Errorf("expected the chains %v, got %v", expectedChains, activePolicyChains)
+	}
+	input := string(filterTable.Bytes())
+	var positions []int
+	for _, rule := range []string{
+		"-A " + chain + " -m comment --comment \"run through cluster network policy tier ClusterAdmin\" -j " +
+			adminChain + "\n",
+		"-A " + chain + " -m comment --comment \"run through cluster network policy tier Platform\" -j " +
+			platformChain + "\n",
+	} {
+		if strings.Count(input, rule) != 1 {
+			t.Fatalf("expected %q once in the iptables-restore input:\n%s", rule, input)
+		}
+		positions = append(positions, strings.Index(input, rule))
+	}
+	if !sort.IntsAreSorted(positions) {
+		t.Errorf("expected the ClusterAdmin tier ahead of the Platform tier:\n%s", input)
+	}
+	if !strings.Contains(input, "-A "+adminChain+" -m comment --comment \"rule to PASS egress traffic of cluster "+
+		"network policy pass-dns\"") {
+		t.Errorf("expected the Pass rule in the chain of the ClusterAdmin tier:\n%s", input)
+	}
+}
@@ -0,0 +1,208 @@
+package netpol
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// the pod firewall chains accept the packets of all the connections conntrack tracks as established or related
+	conntrackModePermissive = "permissive"
+	// the pod firewall chains only accept established connections and the ICMP errors related to them, and drop the
+	// TCP packets opening connections without SYN
+	conntrackModeStrict = "strict"
+)
+
+// validateConntrackMode returns an error if the --policy-conntrack-mode is unknown
+func validateConntrackMode(mode string) error {
+	switch mode {
+	case conntrackModePermissive, conntrackModeStrict:
+		return nil
+	}
+	return fmt.Errorf("unknown --policy-conntrack-mode %q, must be %s or %s", mode, conntrackModePermissive,
+		conntrackModeStrict)
+}
+
+// statefulRulesArgs returns the rules at the top of a pod firewall chain accepting the packets of the connections
+// the network policies accepted, in the order of the chain.
+//
+// Conntrack only confirms the connections whose first packet was accepted, so the original direction of an
+// established connection was allowed. In strict mode the rules close the ways around it: with the loose TCP
+// tracking of the kernel, a mid-stream packet such as a spoofed ACK is tracked as a new connection, which is only
+// accepted when it starts with a SYN, and the connections conntrack helpers relate to an established one are
+// evaluated against the network policies like any other.
+func (npc *NetworkPolicyController) statefulRulesArgs() [][]string {
+	comment := "rule for stateful firewall for pod"
+	if npc.conntrackMode != conntrackModeStrict {
+		return [][]string{{"-m", "comment", "--comment", comment,
+			"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}
+	}
+	return [][]string{
+		{"-m", "comment", "--comment", comment, "-m", "conntrack", "--ctstate", "ESTABLISHED", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "rule to permit the ICMP errors related to the connections of pod",
+			"-p", "icmp", "-m", "conntrack", "--ctstate", "RELATED", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "rule to drop the TCP packets opening connections without SYN",
+			"-p", "tcp", "!", "--syn", "-m", "conntrack", "--ctstate", "NEW", "-j", "DROP"},
+	}
+}
+
+// nftStatefulRules is the counterpart of statefulRulesArgs for the nftables backend
+func (npc *NetworkPolicyController) nftStatefulRules() []string {
+	comment := nftComment("rule for stateful firewall for pod")
+	if npc.conntrackMode != conntrackModeStrict {
+		return []string{"ct state related,established accept " + comment}
+	}
+	return []string{
+		"ct state established accept " + comment,
+		"meta l4proto icmp ct state related accept " +
+			nftComment("rule to permit the ICMP errors related to the connections of pod"),
+		// the flags --syn of iptables matches
+		"ct state new tcp flags & (fin|syn|rst|ack) != syn drop " +
+			nftComment("rule to drop the TCP packets opening connections without SYN"),
+	}
+}
+
+// insertStatefulRules inserts the stateful rules at the top of the pod firewall chain
+func (npc *NetworkPolicyController) insertStatefulRules(filterTable *utils.IPTablesRestore, podFwChainName string) {
+	rules := npc.statefulRulesArgs()
+	for i := len(rules) - 1; i >= 0; i-- {
+		filterTable.InsertUnique(podFwChainName, rules[i]...)
+	}
+}
+
+// With --policy-flush-revoked-conntrack, the addresses removed from the ipsets of the network policies by a sync, or
+// whose ipsets were removed, are revoked: once the sync programmed the rules, the connections conntrack tracks
+// between a revoked address and a pod of the node with a firewall are deleted, so their next packet is evaluated
+// against the network policies rather than accepted by the stateful rule. The connections still allowed by another
+// rule are tracked again from that packet, but in strict mode the TCP packets of a connection without SYN are dropped.
+
+// policyIPSetEntries returns the entries of the ipsets of the network policies programmed by the controller, by
+// ipset. The ipsets of the domain names are left out, their entries expire on their own.
+func (npc *NetworkPolicyController) policyIPSetEntries() map[string][]string {
+	entries := make(map[string][]string)
+	for _, set := range npc.ipSetHandler.List() {
+		if !strings.HasPrefix(set.Name, kubeSourceIpSetPrefix) && !strings.HasPrefix(set.Name, kubeDestinationIpSetPrefix) {
+			continue
+		}
+		setEntries := make([]string, 0, len(set.Entries))
+		for _, entry := range set.Entries {
+			if len(entry.Options) == 0 || hasOption(entry.Options, utils.OptionNoMatch) {
+				continue
+			}
+			setEntries = append(setEntries, entry.Options[0])
+		}
+		entries[set.Name] = setEntries
+	}
+	return entries
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// revokedAddresses returns the addresses and CIDRs of the entries of the ipsets before that are no longer in the
+// ipsets after
+func revokedAddresses(before, after map[string][]string) []*net.IPNet {
+	revoked := make([]*net.IPNet, 0)
+	seen := make(map[string]bool)
+	for name, entries := range before {
+		kept := make(map[string]bool, len(after[name]))
+		for _, entry := range after[name] {
+			kept[entry] = true
+		}
+		for _, entry := range entries {
+			if kept[entry] {
+				continue
+			}
+			// the address of hash:ip,port entries
+			address := strings.SplitN(entry, ",", 2)[0]
+			if seen[address] {
+				continue
+			}
+			seen[address] = true
+			if !strings.Contains(address, "/") {
+				address += "/32"
+			}
+			_, ipNet, err := net.ParseCIDR(address)
+			if err != nil || ipNet.IP.To4() == nil {
+				continue
+			}
+			revoked = append(revoked, ipNet)
+		}
+	}
+	return revoked
+}
+
+// revokedFlowFilter matches the connections between a revoked address and a pod of the node with a firewall
+type revokedFlowFilter struct {
+	revoked   []*net.IPNet
+	localPods map[string]bool
+}
+
+func (f *revokedFlowFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	// the reply source is the endpoint of the connections to a service
+	addresses := []net.IP{flow.Forward.SrcIP, flow.Forward.DstIP, flow.Reverse.SrcIP}
+	local, revoked := false, false
+	for _, address := range addresses {
+		if address == nil {
+			continue
+		}
+		local = local || f.localPods[address.String()]
+		for _, ipNet := range f.revoked {
+			if ipNet.Contains(address) {
+				revoked = true
+				break
+			}
+		}
+	}
+	return local && revoked
+}
+
+// flushRevokedConnections deletes the connections tracked between the addresses revoked since the ipset entries before
+// and the pods of the node with a firewall
+func (npc *NetworkPolicyController) flushRevokedConnections(before map[string][]string) {
+	revoked := revokedAddresses(before, npc.policyIPSetEntries())
+	if len(revoked) == 0 {
+		return
+	}
+	filter := &revokedFlowFilter{revoked: revoked, localPods: make(map[string]bool)}
+	ingressPods, err := npc.getIngressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		glog.Errorf("Failed to flush the connections of the revoked addresses: %s", err)
+		return
+	}
+	egressPods, err := npc.getEgressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		glog.Errorf("Failed to flush the connections of the revoked addresses: %s", err)
+		return
+	}
+	for _, pods := range []map[string]podInfo{*ingressPods, *egressPods} {
+		for ip := range pods {
+			filter.localPods[ip] = true
+		}
+	}
+	if len(filter.localPods) == 0 {
+		return
+	}
+	deleted, err := netlink.ConntrackDeleteFilter(netlink.ConntrackTable, syscall.AF_INET, filter)
+	if err != nil {
+		glog.Errorf("Failed to flush the connections of the revoked addresses: %s", err)
+		return
+	}
+	if npc.MetricsEnabled {
+		metrics.ControllerPolicyConntrackFlushed.Add(float64(deleted))
+	}
+	glog.V(1).Infof("Flushed %d connections of %d revoked addresses", deleted, len(revoked))
+}
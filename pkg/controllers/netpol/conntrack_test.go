@@ -0,0 +1,129 @@
+package netpol
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncPodFirewallChainsStrictConntrackMode(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.conntrackMode = conntrackModeStrict
+
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+	netpol := tNetpol{
+		name:        "deny-all",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress:     []netv1.NetworkPolicyIngressRule{},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filterTable := utils.NewIPTablesRestore("filter")
+	if _, _, err := krNetPol.syncPodFirewallChains(filterTable, "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	podFwChain := podFirewallChainName("nsA", "web", "1")
+
+	// only established connections are accepted before the TCP packets opening connections without SYN are dropped,
+	// and the policies evaluate all the others
+	input := string(filterTable.Bytes())
+	var positions []int
+	for _, rule := range []string{
+		"-A " + podFwChain + " -m comment --comment \"rule for stateful firewall for pod\" -m conntrack --ctstate ESTABLISHED -j ACCEPT\n",
+		"-A " + podFwChain + " -m comment --comment \"rule to permit the ICMP errors related to the connections of pod\" -p icmp -m conntrack --ctstate RELATED -j ACCEPT\n",
+		"-A " + podFwChain + " -m comment --comment \"rule to drop the TCP packets opening connections without SYN\" -p tcp ! --syn -m conntrack --ctstate NEW -j DROP\n",
+		"-A " + podFwChain + " -m comment --comment \"rule to permit the traffic traffic to pods when source is the pod's local node\"",
+		"-A " + podFwChain + " -m comment --comment \"run through nw policy deny-all\"",
+	} {
+		if strings.Count(input, rule) != 1 {
+			t.Fatalf("expected %q once in the iptables-restore input:\n%s", rule, input)
+		}
+		positions = append(positions, strings.Index(input, rule))
+	}
+	if !sort.IntsAreSorted(positions) {
+		t.Errorf("expected the rules in order in the iptables-restore input:\n%s", input)
+	}
+	if strings.Contains(input, "RELATED,ESTABLISHED") {
+		t.Errorf("expected no related connection to be accepted in strict mode:\n%s", input)
+	}
+
+	ruleset, _, _, err := krNetPol.renderNFTables()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	script := ruleset.script()
+	for _, expected := range []string{
+		"\t\tct state established accept comment",
+		"\t\tmeta l4proto icmp ct state related accept comment",
+		"\t\tct state new tcp flags & (fin|syn|rst|ack) != syn drop comment",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected %q in the rendered nftables script:\n%s", expected, script)
+		}
+	}
+}
+
+func TestRevokedConnections(t *testing.T) {
+	before := map[string][]string{
+		"KUBE-SRC-A": {"10.1.0.1", "10.1.0.2"},
+		"KUBE-SRC-B": {"10.2.0.0/16", "10.3.0.0/16"},
+		"KUBE-DST-C": {"10.4.0.1,tcp:53"},
+	}
+	after := map[string][]string{
+		"KUBE-SRC-A": {"10.1.0.2", "10.1.0.3"},
+		"KUBE-SRC-B": {"10.3.0.0/16"},
+	}
+	revoked := revokedAddresses(before, after)
+	got := make([]string, 0, len(revoked))
+	for _, ipNet := range revoked {
+		got = append(got, ipNet.String())
+	}
+	sort.Strings(got)
+	expected := []string{"10.1.0.1/32", "10.2.0.0/16", "10.4.0.1/32"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the revoked addresses %v, got %v", expected, got)
+	}
+
+	filter := &revokedFlowFilter{revoked: revoked, localPods: map[string]bool{"10.5.0.1": true}}
+	flow := func(src, dst, replySrc string) *netlink.ConntrackFlow {
+		f := &netlink.ConntrackFlow{}
+		f.Forward.SrcIP, f.Forward.DstIP = net.ParseIP(src), net.ParseIP(dst)
+		f.Reverse.SrcIP, f.Reverse.DstIP = net.ParseIP(replySrc), net.ParseIP(src)
+		return f
+	}
+	for _, tc := range []struct {
+		flow  *netlink.ConntrackFlow
+		match bool
+	}{
+		{flow("10.1.0.1", "10.5.0.1", "10.5.0.1"), true},
+		{flow("10.5.0.1", "10.2.3.4", "10.2.3.4"), true},
+		// to a service whose endpoint is revoked
+		{flow("10.5.0.1", "10.96.0.10", "10.4.0.1"), true},
+		{flow("10.1.0.2", "10.5.0.1", "10.5.0.1"), false},
+		// no pod of the node
+		{flow("10.1.0.1", "10.6.0.1", "10.6.0.1"), false},
+	} {
+		if match := filter.MatchConntrackFlow(tc.flow); match != tc.match {
+			t.Errorf("expected the flow %s -> %s to match %t, got %t", tc.flow.Forward.SrcIP, tc.flow.Forward.DstIP,
+				tc.match, match)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package netpol
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The critical flows are the traffic the nodes need to operate, e.g. of the pods to the API server, which no
+// ClusterNetworkPolicy or network policy may deny. They come from --critical-flows, for all the pods, and from the
+// CriticalFlow custom resources, for all the pods or the pods of some priority classes. Their CIDRs and ports go into
+// a hash:net,port ipset by direction and priority class, matched by a rule of each pod firewall chain ahead of the
+// jump to the cluster network policies. Every flow added or removed is logged, along with where it comes from, and
+// recorded as an event of the node.
+
+const (
+	// ipsets of the critical flows of all the pods
+	criticalIngressIPSetName = kubeSourceIpSetPrefix + "CRITICAL-FLOWS"
+	criticalEgressIPSetName  = kubeDestinationIpSetPrefix + "CRITICAL-FLOWS"
+
+	criticalFlowsChangedReason = "CriticalFlowsChanged"
+
+	// source of the critical flows of --critical-flows
+	criticalFlowsFlagSource = "--critical-flows"
+)
+
+// criticalFlow is a CIDR allowed to or from a port, or a range of ports, of the pods of a priority class, or of all
+// the pods
+type criticalFlow struct {
+	// "ingress" or "egress"
+	direction string
+	// the flows of all the pods have none
+	priorityClass string
+	entry         allowListEntry
+	// --critical-flows or the CriticalFlow the flow comes from
+	source string
+}
+
+func (f criticalFlow) String() string {
+	class := "all pods"
+	if f.priorityClass != "" {
+		class = "priority class " + f.priorityClass
+	}
+	return fmt.Sprintf("%s %s %s/%s of %s from %s", f.direction, f.entry.cidr, f.entry.protocol, f.entry.ports(),
+		class, f.source)
+}
+
+// criticalFlowIPSetName returns the ipset of the critical flows of the direction for the pods of the priority class
+func criticalFlowIPSetName(direction, priorityClass string) string {
+	switch {
+	case direction == "ingress" && priorityClass == "":
+		return criticalIngressIPSetName
+	case direction == "ingress":
+		return utils.HashedIPSetName(kubeSourceIpSetPrefix, "critical flows "+priorityClass, false)
+	case priorityClass == "":
+		return criticalEgressIPSetName
+	default:
+		return utils.HashedIPSetName(kubeDestinationIpSetPrefix, "critical flows "+priorityClass, false)
+	}
+}
+
+// criticalFlowsOf returns the critical flows of a validated CriticalFlow
+func criticalFlowsOf(flow *crd.CriticalFlow, source string) []criticalFlow {
+	direction := strings.ToLower(flow.Spec.Direction)
+	classes := flow.Spec.PriorityClassNames
+	if len(classes) == 0 {
+		classes = []string{""}
+	}
+	flows := make([]criticalFlow, 0)
+	for _, cidr := range flow.Spec.CIDRs {
+		// the ipset stores the network address of the CIDR
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		for _, port := range flow.Spec.Ports {
+			protocol := port.Protocol
+			if protocol == "" {
+				protocol = api.ProtocolTCP
+			}
+			entry := allowListEntry{cidr: ipNet.String(), protocol: strings.ToLower(string(protocol)),
+				port: int(port.Port), endPort: int(port.EndPort)}
+			for _, class := range classes {
+				flows = append(flows, criticalFlow{direction: direction, priorityClass: class, entry: entry,
+					source: source})
+			}
+		}
+	}
+	return flows
+}
+
+// parseCriticalFlows parses the direction:cidr:protocol:port[-endport] flows of --critical-flows
+func parseCriticalFlows(flags []string) ([]criticalFlow, error) {
+	flows := make([]criticalFlow, 0)
+	for _, flag := range flags {
+		parts := strings.Split(flag, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid critical flow %q, must be <direction>:<cidr>:<protocol>:<port>[-<endport>]",
+				flag)
+		}
+		flow := &crd.CriticalFlow{Spec: crd.CriticalFlowSpec{CIDRs: []string{parts[1]}}}
+		switch strings.ToLower(parts[0]) {
+		case "ingress":
+			flow.Spec.Direction = crd.CriticalFlowDirectionIngress
+		case "egress":
+			flow.Spec.Direction = crd.CriticalFlowDirectionEgress
+		default:
+			return nil, fmt.Errorf("invalid critical flow %q, the direction must be ingress or egress", flag)
+		}
+		port := crd.CriticalFlowPort{Protocol: api.Protocol(strings.ToUpper(parts[2]))}
+		ports := strings.SplitN(parts[3], "-", 2)
+		p, err := strconv.ParseInt(ports[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid critical flow %q, invalid port %q", flag, ports[0])
+		}
+		port.Port = int32(p)
+		if len(ports) == 2 {
+			p, err = strconv.ParseInt(ports[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid critical flow %q, invalid end port %q", flag, ports[1])
+			}
+			port.EndPort = int32(p)
+		}
+		flow.Spec.Ports = []crd.CriticalFlowPort{port}
+		if err := flow.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid critical flow %q: %s", flag, err)
+		}
+		flows = append(flows, criticalFlowsOf(flow, criticalFlowsFlagSource)...)
+	}
+	return flows, nil
+}
+
+// newCriticalFlowInformer returns the informer of the CriticalFlows, which syncs the controller on their changes
+func (npc *NetworkPolicyController) newCriticalFlowInformer(clientset kubernetes.Interface,
+	resync time.Duration) cache.SharedIndexInformer {
+	lw := crd.NewListWatch(clientset, crd.CriticalFlowResource,
+		func() runtime.Object { return &crd.CriticalFlowList{} },
+		func() runtime.Object { return &crd.CriticalFlow{} })
+	informer := cache.NewSharedIndexInformer(utils.NewInstrumentedListWatch(crd.CriticalFlowResource, lw),
+		&crd.CriticalFlow{}, resync, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: npc.OnCriticalFlowUpdate,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			npc.OnCriticalFlowUpdate(newObj)
+		},
+		DeleteFunc: npc.OnCriticalFlowUpdate,
+	})
+	return informer
+}
+
+// OnCriticalFlowUpdate handles the changes of the CriticalFlows
+func (npc *NetworkPolicyController) OnCriticalFlowUpdate(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	flow, ok := obj.(*crd.CriticalFlow)
+	if !ok {
+		glog.Errorf("unexpected object type: %v", obj)
+		return
+	}
+	glog.V(2).Infof("Received update for critical flow: %s", flow.Name)
+
+	if !npc.readyForUpdates {
+		glog.V(3).Infof("Skipping update to critical flow: %s, controller still performing bootup full-sync",
+			flow.Name)
+		return
+	}
+
+	npc.syncCriticalFlows()
+	npc.syncQueue.add(syncFull)
+}
+
+// syncCriticalFlows reads the CriticalFlow custom resources from the informer cache, when enabled, and audits the
+// changes of the critical flows. Like the cluster allow lists, they are read on the periodic syncs and on their
+// changes.
+func (npc *NetworkPolicyController) syncCriticalFlows() {
+	resources := make([]crd.CriticalFlow, 0)
+	if npc.criticalFlowInformer != nil {
+		for _, obj := range npc.criticalFlowInformer.GetIndexer().List() {
+			resources = append(resources, *obj.(*crd.CriticalFlow))
+		}
+	}
+	npc.setCriticalFlows(resources)
+}
+
+// setCriticalFlows keeps the flows of --critical-flows and of the valid resources, the first source of a flow
+// being the flag, then the resources in the order of their names
+func (npc *NetworkPolicyController) setCriticalFlows(resources []crd.CriticalFlow) {
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Name < resources[j].Name })
+	flows := append([]criticalFlow(nil), npc.criticalFlowsFlag...)
+	for i := range resources {
+		if err := resources[i].Validate(); err != nil {
+			glog.Errorf("Ignoring critical flow %s: %s", resources[i].Name, err)
+			continue
+		}
+		flows = append(flows, criticalFlowsOf(&resources[i], "CriticalFlow "+resources[i].Name)...)
+	}
+	flows = uniqueCriticalFlows(flows)
+
+	npc.mu.Lock()
+	previous := npc.criticalFlows
+	npc.criticalFlows = flows
+	npc.mu.Unlock()
+	npc.auditCriticalFlows(previous, flows)
+}
+
+// uniqueCriticalFlows sorts the flows by ipset and entry, and keeps the first source of the flows found more than once
+func uniqueCriticalFlows(flows []criticalFlow) []criticalFlow {
+	unique := make([]criticalFlow, 0, len(flows))
+	seen := make(map[criticalFlow]bool)
+	for _, flow := range flows {
+		key := flow
+		key.source = ""
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, flow)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		a := criticalFlowIPSetName(unique[i].direction, unique[i].priorityClass)
+		b := criticalFlowIPSetName(unique[j].direction, unique[j].priorityClass)
+		if a != b {
+			return a < b
+		}
+		return unique[i].entry.ipSetEntry() < unique[j].entry.ipSetEntry()
+	})
+	return unique
+}
+
+// auditCriticalFlows logs the critical flows added and removed, records them as an event of the node, and exports
+// the number of critical flows by direction
+func (npc *NetworkPolicyController) auditCriticalFlows(previous, current []criticalFlow) {
+	before := make(map[string]bool, len(previous))
+	for _, flow := range previous {
+		before[flow.String()] = true
+	}
+	after := make(map[string]bool, len(current))
+	changes := make([]string, 0)
+	for _, flow := range current {
+		after[flow.String()] = true
+		if !before[flow.String()] {
+			glog.Infof("Injecting critical flow %s ahead of the network policies", flow)
+			changes = append(changes, "added "+flow.String())
+		}
+	}
+	for _, flow := range previous {
+		if !after[flow.String()] {
+			glog.Infof("Removing critical flow %s", flow)
+			changes = append(changes, "removed "+flow.String())
+		}
+	}
+	if len(changes) != 0 {
+		npc.Events.RecordNodeEvent(api.EventTypeNormal, criticalFlowsChangedReason,
+			fmt.Sprintf("%d critical flows injected ahead of the network policies: %s", len(current),
+				strings.Join(changes, ", ")))
+	}
+	if npc.MetricsEnabled {
+		counts := map[string]int{"ingress": 0, "egress": 0}
+		for _, flow := range current {
+			counts[flow.direction]++
+		}
+		for direction, count := range counts {
+			metrics.ControllerPolicyCriticalFlows.WithLabelValues(direction).Set(float64(count))
+		}
+	}
+}
+
+// criticalFlowIPSetEntries returns the names of the ipsets of the critical flows, in order, and their entries
+func (npc *NetworkPolicyController) criticalFlowIPSetEntries() ([]string, map[string][]string) {
+	names := make([]string, 0)
+	entries := make(map[string][]string)
+	// the flows are sorted by ipset
+	for _, flow := range npc.criticalFlows {
+		name := criticalFlowIPSetName(flow.direction, flow.priorityClass)
+		if _, ok := entries[name]; !ok {
+			names = append(names, name)
+		}
+		entries[name] = append(entries[name], flow.entry.ipSetEntry())
+	}
+	return names, entries
+}
+
+// syncCriticalFlowIPSets creates and refreshes the ipsets of the critical flows
+func (npc *NetworkPolicyController) syncCriticalFlowIPSets(activePolicyIPSets map[string]bool) error {
+	names, entries := npc.criticalFlowIPSetEntries()
+	for _, name := range names {
+		set, err := npc.ipSetHandler.Create(name, utils.TypeHashNetPort, utils.OptionTimeout, "0")
+		if err != nil {
+			return err
+		}
+		if err := set.Refresh(entries[name], utils.OptionTimeout, "0"); err != nil {
+			return err
+		}
+		activePolicyIPSets[set.Name] = true
+	}
+	return nil
+}
+
+// criticalFlowRuleArgs returns the rules of the pod firewall chain accepting the critical flows of the direction of
+// the pod, those of all the pods and of its priority class
+func (npc *NetworkPolicyController) criticalFlowRuleArgs(direction string, pod podInfo) [][]string {
+	sets := make(map[string]bool)
+	for _, flow := range npc.criticalFlows {
+		if flow.direction == direction && (flow.priorityClass == "" || flow.priorityClass == pod.priorityClass) {
+			sets[criticalFlowIPSetName(flow.direction, flow.priorityClass)] = true
+		}
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rules := make([][]string, 0, len(names))
+	for _, name := range names {
+		if direction == "ingress" {
+			rules = append(rules, []string{"-m", "comment", "--comment",
+				"rule to permit the critical ingress flows to pods", "-m", "set", "--match-set", name, "src,dst",
+				"-d", pod.ip, "-j", "ACCEPT"})
+		} else {
+			rules = append(rules, []string{"-m", "comment", "--comment",
+				"rule to permit the critical egress flows from pods", "-m", "set", "--match-set", name, "dst,dst",
+				"-s", pod.ip, "-j", "ACCEPT"})
+		}
+	}
+	return rules
+}
@@ -0,0 +1,69 @@
+package netpol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCriticalFlows(t *testing.T) {
+	flags, err := parseCriticalFlows([]string{"egress:10.0.0.10/32:tcp:6443", "egress:10.0.0.10/32:TCP:6443",
+		"ingress:192.168.0.0/16:udp:8472"})
+	if err != nil {
+		t.Fatalf("unexpected error parsing critical flows: %s", err)
+	}
+	for _, invalid := range []string{"both:10.0.0.10/32:tcp:6443", "egress:10.0.0.10:tcp:6443",
+		"egress:10.0.0.10/32:tcp:2380-2379", "egress:10.0.0.10/32:6443"} {
+		if _, err := parseCriticalFlows([]string{invalid}); err == nil {
+			t.Errorf("expected critical flow %q to be invalid", invalid)
+		}
+	}
+
+	etcd := &crd.CriticalFlow{ObjectMeta: metav1.ObjectMeta{Name: "etcd"}, Spec: crd.CriticalFlowSpec{
+		Direction: crd.CriticalFlowDirectionEgress, CIDRs: []string{"10.0.0.0/24"},
+		Ports:              []crd.CriticalFlowPort{{Port: 2379, EndPort: 2380}},
+		PriorityClassNames: []string{"system-cluster-critical"}}}
+	// the resources are read from the informer cache, the invalid ones are ignored
+	npc := &NetworkPolicyController{criticalFlowsFlag: flags, enableCriticalFlows: true}
+	npc.criticalFlowInformer = npc.newCriticalFlowInformer(fake.NewSimpleClientset(), 0)
+	npc.criticalFlowInformer.GetIndexer().Add(etcd)
+	npc.criticalFlowInformer.GetIndexer().Add(&crd.CriticalFlow{ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+		Spec: crd.CriticalFlowSpec{Direction: "Both", CIDRs: []string{"10.0.1.0/24"},
+			Ports: []crd.CriticalFlowPort{{Port: 443}}}})
+	npc.syncCriticalFlows()
+	if len(npc.criticalFlows) != 3 {
+		t.Fatalf("expected the duplicate flow dropped, got %v", npc.criticalFlows)
+	}
+	if source := npc.criticalFlows[0].source; source != criticalFlowsFlagSource {
+		t.Errorf("expected the flows of the flag to come from %s, got %s", criticalFlowsFlagSource, source)
+	}
+
+	classSet := criticalFlowIPSetName("egress", "system-cluster-critical")
+	names, entries := npc.criticalFlowIPSetEntries()
+	expected := map[string][]string{criticalEgressIPSetName: {"10.0.0.10/32,tcp:6443"},
+		classSet: {"10.0.0.0/24,tcp:2379-2380"}, criticalIngressIPSetName: {"192.168.0.0/16,udp:8472"}}
+	if len(names) != 3 || !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected the ipsets %v, got %v %v", expected, names, entries)
+	}
+
+	pod := podInfo{ip: "1.1.1.1", priorityClass: "system-cluster-critical"}
+	rules := npc.criticalFlowRuleArgs("egress", pod)
+	if len(rules) != 2 || !strings.Contains(strings.Join(append(rules[0], rules[1]...), " "),
+		"--match-set "+classSet+" dst,dst -s 1.1.1.1 -j ACCEPT") {
+		t.Errorf("expected the egress flows of all the pods and of the priority class, got %v", rules)
+	}
+	pod.priorityClass = ""
+	if rules = npc.criticalFlowRuleArgs("egress", pod); len(rules) != 1 {
+		t.Errorf("expected only the egress flows of all the pods, got %v", rules)
+	}
+	rules = npc.criticalFlowRuleArgs("ingress", pod)
+	if len(rules) != 1 || strings.Join(rules[0], " ") != "-m comment --comment rule to permit the critical "+
+		"ingress flows to pods -m set --match-set "+criticalIngressIPSetName+" src,dst -d 1.1.1.1 -j ACCEPT" {
+		t.Errorf("expected the ingress flows of all the pods, got %v", rules)
+	}
+}
@@ -0,0 +1,105 @@
+package netpol
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Namespaces annotated with kube-router.io/default-deny, e.g. kube-router.io/default-deny=ingress,egress, get a network
+// policy selecting all their pods without any rule in the directions of the annotation, which isolates the pods like
+// an empty-selector network policy created in the namespace would, without the namespace owners having to create it.
+// The network policies of the namespace allow the traffic on top of it.
+
+const (
+	defaultDenyAnnotation = "kube-router.io/default-deny"
+	defaultDenyPolicyName = "kube-router-default-deny"
+)
+
+// parseDefaultDeny returns the directions of the default-deny annotation of the namespace, nil when the namespace has
+// none or the annotation is malformed
+func parseDefaultDeny(namespace *api.Namespace) []networking.PolicyType {
+	value, ok := namespace.Annotations[defaultDenyAnnotation]
+	if !ok {
+		return nil
+	}
+	ingress, egress := false, false
+	for _, direction := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(direction)) {
+		case "ingress":
+			ingress = true
+		case "egress":
+			egress = true
+		default:
+			glog.Errorf("Ignoring annotation %s of namespace %s, %q is not a list of ingress and egress",
+				defaultDenyAnnotation, namespace.Name, value)
+			return nil
+		}
+	}
+	policyTypes := make([]networking.PolicyType, 0, 2)
+	if ingress {
+		policyTypes = append(policyTypes, networking.PolicyTypeIngress)
+	}
+	if egress {
+		policyTypes = append(policyTypes, networking.PolicyTypeEgress)
+	}
+	return policyTypes
+}
+
+// defaultDenyPolicies generates the deny-all network policy of each namespace with a default-deny annotation
+func defaultDenyPolicies(namespaces []*api.Namespace) []*networking.NetworkPolicy {
+	policies := make([]*networking.NetworkPolicy, 0)
+	for _, namespace := range namespaces {
+		policyTypes := parseDefaultDeny(namespace)
+		if len(policyTypes) == 0 {
+			continue
+		}
+		policies = append(policies, &networking.NetworkPolicy{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        defaultDenyPolicyName,
+				Namespace:   namespace.Name,
+				Annotations: map[string]string{defaultDenyAnnotation: namespace.Annotations[defaultDenyAnnotation]},
+			},
+			Spec: networking.NetworkPolicySpec{
+				PolicyTypes: policyTypes,
+			},
+		})
+	}
+	return policies
+}
+
+// namespaceDefaultDenyChanged tells whether the update of the namespace changed its default-deny annotation
+func namespaceDefaultDenyChanged(oldObj, newObj interface{}) bool {
+	oldNamespace, ok := oldObj.(*api.Namespace)
+	if !ok {
+		return true
+	}
+	newNamespace, ok := newObj.(*api.Namespace)
+	if !ok {
+		return true
+	}
+	return oldNamespace.Annotations[defaultDenyAnnotation] != newNamespace.Annotations[defaultDenyAnnotation]
+}
+
+// OnNamespaceDefaultDenyUpdate handles updates to the default-deny annotation of a namespace, which adds, changes or
+// removes its deny-all network policy
+func (npc *NetworkPolicyController) OnNamespaceDefaultDenyUpdate(obj interface{}) {
+	namespace := obj.(*api.Namespace)
+	glog.V(2).Infof("Received update for the default-deny annotation of namespace: %s", namespace.Name)
+
+	if !npc.readyForUpdates {
+		glog.V(3).Infof("Skipping update to namespace: %s, controller still performing bootup full-sync", namespace.Name)
+		return
+	}
+	if !npc.enforcesNamespace(namespace.Name) {
+		glog.V(3).Infof("Skipping update to namespace: %s, the network policies of the namespace are not enforced",
+			namespace.Name)
+		return
+	}
+
+	npc.syncQueue.add(syncFull)
+}
@@ -0,0 +1,58 @@
+package netpol
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDefaultDenyAnnotation(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+
+	for name, annotation := range map[string]string{
+		"tenant-a": "ingress,egress",
+		"tenant-b": "Ingress",
+		"tenant-c": "ingress,sideways",
+		"tenant-d": "",
+	} {
+		namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if name != "tenant-d" {
+			namespace.Annotations = map[string]string{defaultDenyAnnotation: annotation}
+		}
+		tAddToInformerStore(t, cluster.nsInformer, namespace)
+		tAddToInformerStore(t, cluster.podInformer,
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: name, Labels: map[string]string{"app": "web"}},
+				Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1." + name[len(name)-1:]}})
+	}
+
+	policies, err := krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{"tenant-a": "both", "tenant-b": "ingress"}
+	if len(*policies) != len(expected) {
+		t.Fatalf("expected %d generated policies but got %d: %v", len(expected), len(*policies), *policies)
+	}
+	for _, policy := range *policies {
+		policyType, ok := expected[policy.namespace]
+		if !ok || policy.name != defaultDenyPolicyName {
+			t.Errorf("unexpected generated policy %s/%s", policy.namespace, policy.name)
+			continue
+		}
+		if policy.policyType != policyType {
+			t.Errorf("expected the policy of %s to be of type %s but got %s", policy.namespace, policyType,
+				policy.policyType)
+		}
+		if len(policy.targetPods) != 1 || len(policy.ingressRules) != 0 || len(policy.egressRules) != 0 {
+			t.Errorf("expected the policy of %s to deny all the traffic of its pod, got %+v", policy.namespace, policy)
+		}
+	}
+
+	oldNamespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-d"}}
+	newNamespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-d",
+		Annotations: map[string]string{defaultDenyAnnotation: "egress"}}}
+	if !namespaceDefaultDenyChanged(oldNamespace, newNamespace) || namespaceDefaultDenyChanged(newNamespace, newNamespace) {
+		t.Errorf("expected only the change of the annotation to be detected")
+	}
+}
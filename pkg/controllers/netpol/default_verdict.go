@@ -0,0 +1,79 @@
+package netpol
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+)
+
+// The traffic no network policy accepted is rejected by the last rule of the pod firewall chains, or silently dropped,
+// for all the pods with --netpol-default-verdict or for the pods of the namespaces annotated with
+// kube-router.io/netpol-default-verdict.
+
+const (
+	defaultVerdictAnnotation = "kube-router.io/netpol-default-verdict"
+	defaultVerdictReject     = "reject"
+	defaultVerdictDrop       = "drop"
+)
+
+func validateDefaultVerdict(verdict string) error {
+	if verdict != defaultVerdictReject && verdict != defaultVerdictDrop {
+		return fmt.Errorf("unknown --netpol-default-verdict %q, must be drop or reject", verdict)
+	}
+	return nil
+}
+
+// namespaceDefaultVerdict returns the verdict of the traffic of the pods of the namespace no network policy accepted,
+// defaultVerdictReject or defaultVerdictDrop
+func (npc *NetworkPolicyController) namespaceDefaultVerdict(namespace string) string {
+	verdict := npc.defaultVerdict
+	if verdict == "" {
+		verdict = defaultVerdictReject
+	}
+	if npc.nsLister == nil {
+		return verdict
+	}
+	obj, exists, err := npc.nsLister.GetByKey(namespace)
+	if err != nil || !exists {
+		return verdict
+	}
+	annotation, ok := obj.(*api.Namespace).Annotations[defaultVerdictAnnotation]
+	if !ok {
+		return verdict
+	}
+	if err := validateDefaultVerdict(annotation); err != nil {
+		glog.Errorf("Ignoring annotation %s of namespace %s, %q is neither drop nor reject", defaultVerdictAnnotation,
+			namespace, annotation)
+		return verdict
+	}
+	return annotation
+}
+
+// namespaceDefaultVerdictChanged tells whether the update of the namespace changed its default verdict annotation
+func namespaceDefaultVerdictChanged(oldObj, newObj interface{}) bool {
+	oldNamespace, ok := oldObj.(*api.Namespace)
+	if !ok {
+		return true
+	}
+	newNamespace, ok := newObj.(*api.Namespace)
+	if !ok {
+		return true
+	}
+	return oldNamespace.Annotations[defaultVerdictAnnotation] != newNamespace.Annotations[defaultVerdictAnnotation]
+}
+
+// OnNamespaceDefaultVerdictUpdate handles updates to the default verdict annotation of a namespace, which change the
+// last rule of the pod firewall chains of its pods
+func (npc *NetworkPolicyController) OnNamespaceDefaultVerdictUpdate(obj interface{}) {
+	namespace := obj.(*api.Namespace)
+	glog.V(2).Infof("Received update for the default verdict of namespace: %s", namespace.Name)
+
+	if !npc.readyForUpdates {
+		glog.V(3).Infof("Skipping update to namespace: %s, controller still performing bootup full-sync", namespace.Name)
+		return
+	}
+
+	npc.syncQueue.add(syncFull)
+}
@@ -0,0 +1,46 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDefaultVerdict(t *testing.T) {
+	nsLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nsLister.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "secure",
+		Annotations: map[string]string{defaultVerdictAnnotation: "drop"}}})
+	nsLister.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "typo",
+		Annotations: map[string]string{defaultVerdictAnnotation: "deny"}}})
+	nsLister.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	npc := &NetworkPolicyController{nsLister: nsLister, defaultVerdict: defaultVerdictReject}
+
+	for namespace, expected := range map[string]string{"secure": "DROP", "typo": "REJECT", "default": "REJECT"} {
+		filterTable := utils.NewIPTablesRestore("filter")
+		npc.appendPodFwDropRules(filterTable, podInfo{ip: "1.1.1.1", name: "web", namespace: namespace}, "KUBE-POD-FW-WEB")
+		rule := "--comment \"default rule to " + expected + " traffic destined for POD name:web namespace: " +
+			namespace + "\" -j " + expected + "\n"
+		if input := string(filterTable.Bytes()); !strings.Contains(input, rule) {
+			t.Errorf("expected the %s rule in namespace %s:\n%s", expected, namespace, input)
+		}
+	}
+
+	npc.defaultVerdict = defaultVerdictDrop
+	if verdict := npc.namespaceDefaultVerdict("default"); verdict != defaultVerdictDrop {
+		t.Errorf("expected the default verdict drop, got %s", verdict)
+	}
+	if err := validateDefaultVerdict("deny"); err == nil {
+		t.Errorf("expected an error for an unknown verdict")
+	}
+	annotated := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "secure",
+		Annotations: map[string]string{defaultVerdictAnnotation: "drop"}}}
+	if !namespaceDefaultVerdictChanged(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "secure"}}, annotated) ||
+		namespaceDefaultVerdictChanged(annotated, annotated) {
+		t.Errorf("expected the changes of the default verdict annotation only to be detected")
+	}
+}
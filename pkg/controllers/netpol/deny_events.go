@@ -0,0 +1,68 @@
+package netpol
+
+import (
+	"strconv"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+)
+
+const (
+	// reason of the events recorded on the pods whose network policies dropped a packet of the drop log
+	packetDeniedReason = "PacketDeniedByNetworkPolicy"
+	// reason of the events of the packets the network policies in audit mode would have dropped
+	packetAuditedReason = "PacketWouldBeDeniedByNetworkPolicy"
+)
+
+// recordDenyEvent records an event on the pod the drop is accounted to, the destination of the ingress drops and the
+// source of the egress drops. The events of a pod are aggregated by the event sink, so a pod under denied traffic
+// gets a single event counting the drops, with the message of the last one.
+func (npc *NetworkPolicyController) recordDenyEvent(event DropEvent) {
+	pod := event.Destination
+	if event.Direction == "egress" {
+		pod = event.Source
+	}
+	if pod.Kind != "Pod" {
+		return
+	}
+	ref := api.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: pod.Namespace, Name: pod.Name}
+	if obj, exists, err := npc.podLister.GetByKey(pod.Namespace + "/" + pod.Name); err == nil && exists {
+		if p, ok := obj.(*api.Pod); ok {
+			ref.UID = p.UID
+		}
+	}
+	reason := packetDeniedReason
+	if event.Audit {
+		reason = packetAuditedReason
+	}
+	npc.Events.RecordEvent(ref, api.EventTypeWarning, reason, denyEventMessage(event))
+}
+
+// denyEventMessage words the drop from the point of view of the pod it is accounted to, e.g. "Denied ingress from
+// 10.1.1.7 (pod db/postgres) on TCP/5432, none of the network policies web/deny-all isolating the pod allows it"
+func denyEventMessage(event DropEvent) string {
+	port := event.Protocol
+	if event.Port != 0 {
+		port += "/" + strconv.Itoa(event.Port)
+	}
+	denied := "Denied"
+	if event.Audit {
+		denied = "Audit mode would have denied"
+	}
+	peer := event.Source
+	message := denied + " ingress from "
+	if event.Direction == "egress" {
+		peer = event.Destination
+		message = denied + " egress to "
+	}
+	message += peer.IP
+	if peer.Kind != "" {
+		message += " (" + peer.object() + ")"
+	}
+	message += " on " + port
+	if len(event.Policies) == 0 {
+		return message + ", no network policy isolating the pod allows it"
+	}
+	return message + ", none of the network policies " + strings.Join(event.Policies, ", ") +
+		" isolating the pod allows it"
+}
@@ -0,0 +1,51 @@
+package netpol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDenyEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pods.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend", UID: "frontend-uid"}})
+	npc := &NetworkPolicyController{podLister: pods, Events: utils.NewEventSink(client, "node", time.Minute)}
+
+	ingress := DropEvent{Direction: "ingress", Protocol: "TCP", Port: 8080,
+		Source:      DropEndpoint{IP: "10.1.1.7", Kind: "Pod", Namespace: "db", Name: "postgres"},
+		Destination: DropEndpoint{IP: "10.1.0.5", Kind: "Pod", Namespace: "web", Name: "frontend"},
+		Policies:    []string{"web/allow-lb", "web/deny-all"}}
+	npc.recordDenyEvent(ingress)
+	// the egress drops of pods of other nodes are not seen, nor the drops of addresses outside the cluster
+	npc.recordDenyEvent(DropEvent{Direction: "egress", Protocol: "ICMP",
+		Source: DropEndpoint{IP: "203.0.113.9"}, Destination: DropEndpoint{IP: "10.1.0.5"}})
+
+	events, err := client.CoreV1().Events("web").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("expected an event of the frontend pod, got %+v", events.Items)
+	}
+	event := events.Items[0]
+	expected := "Denied ingress from 10.1.1.7 (pod db/postgres) on TCP/8080, none of the network policies " +
+		"web/allow-lb, web/deny-all isolating the pod allows it"
+	if event.Reason != packetDeniedReason || event.InvolvedObject.UID != "frontend-uid" || event.Message != expected {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	egress := denyEventMessage(DropEvent{Direction: "egress", Protocol: "UDP", Port: 53,
+		Source:      DropEndpoint{IP: "10.1.0.5", Kind: "Pod", Namespace: "web", Name: "frontend"},
+		Destination: DropEndpoint{IP: "198.51.100.1"}})
+	if egress != "Denied egress to 198.51.100.1 on UDP/53, no network policy isolating the pod allows it" {
+		t.Errorf("unexpected message %q", egress)
+	}
+}
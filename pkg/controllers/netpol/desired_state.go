@@ -0,0 +1,284 @@
+package netpol
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// chain names carry the version of the sync that created them, so they are masked when rendering state
+var versionedChainName = regexp.MustCompile("(" + kubePodFirewallChainPrefix + "|" + kubeNetworkPolicyChainPrefix + ")[A-Z2-7]{16}")
+
+func normalizeChainNames(s string) string {
+	return versionedChainName.ReplaceAllString(s, "${1}*")
+}
+
+// podFirewallJumpRule renders the rule of the dispatch chain of the built-in chain jumping to a pod firewall chain
+func podFirewallJumpRule(builtIn, comment, podFwChainName string) string {
+	return nodestate.IPTablesRule("filter", podFwDispatchChains[builtIn], normalizeChainNames(podFwChainName),
+		normalizeChainNames(comment))
+}
+
+func dispatchJumpRule(builtIn, comment string) string {
+	return nodestate.IPTablesRule("filter", builtIn, podFwDispatchChains[builtIn], comment)
+}
+
+// RenderDesiredState renders the network policy ipsets, the rules jumping to the dispatch chains and the rules of
+// the dispatch chains jumping to the pod firewall chains the controller would program for the current network policies and pods, without modifying the node.
+func (npc *NetworkPolicyController) RenderDesiredState() (nodestate.State, error) {
+	var err error
+	// the informers of the custom resources only run along with the controller
+	if npc.enableIsolationProfiles {
+		profiles, err := crd.ListNamespaceIsolationProfiles(npc.clientset)
+		if err != nil {
+			return nil, errors.New("Failed to read namespace isolation profiles: " + err.Error())
+		}
+		npc.setIsolationProfiles(profiles)
+	}
+	if npc.enableClusterAllowLists {
+		lists, err := crd.ListClusterAllowLists(npc.clientset)
+		if err != nil {
+			return nil, errors.New("Failed to read cluster allow lists: " + err.Error())
+		}
+		npc.setClusterAllowLists(lists)
+	}
+	if len(npc.criticalFlowsFlag) != 0 || npc.enableCriticalFlows {
+		resources := make([]crd.CriticalFlow, 0)
+		if npc.enableCriticalFlows {
+			if resources, err = crd.ListCriticalFlows(npc.clientset); err != nil {
+				return nil, errors.New("Failed to read critical flows: " + err.Error())
+			}
+		}
+		npc.setCriticalFlows(resources)
+	}
+	var clusterNetworkPolicies []crd.ClusterNetworkPolicy
+	if npc.cnpLister != nil {
+		if clusterNetworkPolicies, err = crd.ListClusterNetworkPolicies(npc.clientset); err != nil {
+			return nil, errors.New("Failed to read cluster network policies: " + err.Error())
+		}
+	}
+
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+
+	if npc.v1NetworkPolicy {
+		npc.networkPoliciesInfo, err = npc.buildNetworkPoliciesInfo()
+	} else {
+		npc.networkPoliciesInfo, err = npc.buildBetaNetworkPoliciesInfo()
+	}
+	if err != nil {
+		return nil, errors.New("Failed to build network policies: " + err.Error())
+	}
+	npc.enforcePolicyLimits()
+	npc.resolveClusterDNS()
+	if npc.cnpLister != nil {
+		if err := npc.setClusterNetworkPolicies(clusterNetworkPolicies); err != nil {
+			return nil, err
+		}
+	}
+	return npc.renderState()
+}
+
+func (npc *NetworkPolicyController) renderState() (nodestate.State, error) {
+	state := make(nodestate.State)
+
+	for _, builtIn := range podFwBuiltInChains {
+		state.Add(nodestate.IPTables, dispatchJumpRule(builtIn, dispatchJumpComment))
+	}
+	for _, set := range npc.desiredIPSets() {
+		state.Add(nodestate.IPSets, nodestate.IPSetName(set.name))
+		for _, entry := range set.entries {
+			state.Add(nodestate.IPSets, nodestate.IPSetEntry(set.name, entry...))
+		}
+	}
+
+	ingressPods, err := npc.getIngressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range *ingressPods {
+		if len(pod.ip) == 0 {
+			continue
+		}
+		podFwChainName := podFirewallChainName(pod.namespace, pod.name, "")
+		comment := "rule to jump traffic destined to POD name:" + pod.name + " namespace: " + pod.namespace +
+			" to chain " + podFwChainName
+		state.Add(nodestate.IPTables,
+			podFirewallJumpRule("FORWARD", comment, podFwChainName),
+			podFirewallJumpRule("OUTPUT", comment, podFwChainName))
+	}
+
+	egressPods, err := npc.getEgressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range *egressPods {
+		if len(pod.ip) == 0 {
+			continue
+		}
+		podFwChainName := podFirewallChainName(pod.namespace, pod.name, "")
+		comment := "rule to jump traffic from POD name:" + pod.name + " namespace: " + pod.namespace +
+			" to chain " + podFwChainName
+		state.Add(nodestate.IPTables,
+			podFirewallJumpRule("FORWARD", comment, podFwChainName),
+			podFirewallJumpRule("OUTPUT", comment, podFwChainName),
+			podFirewallJumpRule("INPUT", comment, podFwChainName))
+	}
+
+	for _, pod := range npc.getHostNetworkPolicyEnabledPods(npc.nodeIP.String()) {
+		podFwChainName := podFirewallChainName(pod.namespace, pod.name, "")
+		for _, port := range pod.ports {
+			comment := "rule to jump traffic destined to host network POD name:" + pod.name + " namespace: " +
+				pod.namespace + " port: " + port.protocol + "/" + port.port + " to chain " + podFwChainName
+			state.Add(nodestate.IPTables, podFirewallJumpRule("INPUT", comment, podFwChainName))
+		}
+	}
+
+	return state, nil
+}
+
+// desiredIPSet is an ipset of the network policies as the sync programs it, its entries sorted
+type desiredIPSet struct {
+	name    string
+	setType string
+	// the entries are CIDRs with their options, refreshed with their builtin options
+	ipBlocks bool
+	entries  [][]string
+}
+
+// desiredIPSets returns the ipsets the sync programs for the current network policies, as syncNetworkPolicyChains
+// creates them
+func (npc *NetworkPolicyController) desiredIPSets() []desiredIPSet {
+	sets := make([]desiredIPSet, 0)
+	addIPs := func(name, setType string, ips []string) {
+		entries := make([][]string, 0, len(ips))
+		for _, ip := range ips {
+			entries = append(entries, []string{ip})
+		}
+		sets = append(sets, desiredIPSet{name: name, setType: setType, entries: entries})
+	}
+	addIPBlocks := func(name string, ipBlocks [][]string) {
+		entries := make([][]string, len(ipBlocks))
+		copy(entries, ipBlocks)
+		sets = append(sets, desiredIPSet{name: name, setType: utils.TypeHashNet, ipBlocks: true, entries: entries})
+	}
+
+	for _, policy := range *npc.networkPoliciesInfo {
+		targetPodIps := make([]string, 0, len(policy.targetPods))
+		for ip := range policy.targetPods {
+			targetPodIps = append(targetPodIps, ip)
+		}
+
+		if policy.policyType == "both" || policy.policyType == "ingress" {
+			addIPs(policyDestinationPodIpSetName(policy.namespace, policy.name), utils.TypeHashIP, targetPodIps)
+			for i, ingressRule := range policy.ingressRules {
+				if npc.keepPeerPodIPSet(ingressRule.srcPods, ingressRule.namespaceSelectorPeers) {
+					name, setType, srcPodIps := npc.peerPodIPSet(policyIndexedSourcePodIpSetName(policy.namespace, policy.name, i),
+						policyIndexedSourcePodNetIpSetName(policy.namespace, policy.name, i), ingressRule.srcPods)
+					addIPs(name, setType, srcPodIps)
+				}
+				if npc.keepPeerPodIPSet(ingressRule.srcPods, ingressRule.namespaceSelectorPeers) || (ingressRule.matchAllSource && !ingressRule.matchAllPorts) ||
+					(len(ingressRule.srcIPBlocks) != 0 && !ingressRule.matchAllPorts) {
+					for j, endPoints := range ingressRule.namedPorts {
+						addIPs(policyIndexedIngressNamedPortIpSetName(policy.namespace, policy.name, i, j), utils.TypeHashIP, endPoints.ips)
+					}
+				}
+				if len(ingressRule.srcIPBlocks) != 0 {
+					addIPBlocks(policyIndexedSourceIpBlockIpSetName(policy.namespace, policy.name, i), ingressRule.srcIPBlocks)
+				}
+			}
+		}
+
+		if policy.policyType == "both" || policy.policyType == "egress" {
+			addIPs(policySourcePodIpSetName(policy.namespace, policy.name), utils.TypeHashIP, targetPodIps)
+			for i, egressRule := range policy.egressRules {
+				if npc.keepPeerPodIPSet(egressRule.dstPods, egressRule.namespaceSelectorPeers) {
+					name, setType, dstPodIps := npc.peerPodIPSet(policyIndexedDestinationPodIpSetName(policy.namespace, policy.name, i),
+						policyIndexedDestinationPodNetIpSetName(policy.namespace, policy.name, i), egressRule.dstPods)
+					addIPs(name, setType, dstPodIps)
+					for j, endPoints := range egressRule.namedPorts {
+						addIPs(policyIndexedEgressNamedPortIpSetName(policy.namespace, policy.name, i, j), utils.TypeHashIP, endPoints.ips)
+					}
+				}
+				if len(egressRule.dstIPBlocks) != 0 {
+					addIPBlocks(policyIndexedDestinationIpBlockIpSetName(policy.namespace, policy.name, i), egressRule.dstIPBlocks)
+				}
+			}
+		}
+	}
+
+	if len(npc.clusterAllowList) != 0 {
+		addIPs(clusterAllowListIPSetName, utils.TypeHashNetPort, npc.clusterAllowListIPSetEntries())
+	}
+	if len(npc.clusterDNS) != 0 {
+		addIPs(clusterDNSIPSetName, utils.TypeHashIPPort, npc.clusterDNSIPSetEntries())
+	}
+	names, entries := npc.criticalFlowIPSetEntries()
+	for _, name := range names {
+		addIPs(name, utils.TypeHashNetPort, entries[name])
+	}
+	sets = append(sets, npc.clusterNetworkPolicyIPSets()...)
+
+	for _, set := range sets {
+		entries := set.entries
+		sort.Slice(entries, func(i, j int) bool {
+			return strings.Join(entries[i], " ") < strings.Join(entries[j], " ")
+		})
+	}
+	return sets
+}
+
+// ReadActualState reads the network policy ipsets, the rules jumping to the dispatch chains and the rules of the
+// dispatch chains jumping to the pod firewall chains found on the node, rendered the same way as RenderDesiredState.
+func ReadActualState() (nodestate.State, error) {
+	state := make(nodestate.State)
+
+	ipset, err := utils.NewIPSet(false)
+	if err != nil {
+		return nil, err
+	}
+	err = ipset.Save()
+	if err != nil {
+		return nil, errors.New("Failed to read ipsets: " + err.Error())
+	}
+	for name, set := range ipset.Sets {
+		if !strings.HasPrefix(name, kubeSourceIpSetPrefix) && !strings.HasPrefix(name, kubeDestinationIpSetPrefix) {
+			continue
+		}
+		// skip the temporary sets used while refreshing a set
+		if strings.HasSuffix(name, "-") || strings.HasSuffix(name, "-temp") {
+			continue
+		}
+		state.Add(nodestate.IPSets, nodestate.IPSetName(name))
+		for _, entry := range set.Entries {
+			state.Add(nodestate.IPSets, nodestate.IPSetEntry(name, entry.Options...))
+		}
+	}
+
+	rules, err := nodestate.ReadIPTablesSave("filter")
+	if err != nil {
+		return nil, err
+	}
+	builtIns := make(map[string]string, len(podFwDispatchChains))
+	for builtIn, dispatchChain := range podFwDispatchChains {
+		builtIns[dispatchChain] = builtIn
+	}
+	for _, rule := range rules {
+		if jumpsToDispatchChain(rule) {
+			state.Add(nodestate.IPTables, dispatchJumpRule(rule.Chain, rule.Comment))
+			continue
+		}
+		builtIn, ok := builtIns[rule.Chain]
+		if !ok || !strings.HasPrefix(rule.Target, kubePodFirewallChainPrefix) {
+			continue
+		}
+		state.Add(nodestate.IPTables, podFirewallJumpRule(builtIn, rule.Comment, rule.Target))
+	}
+
+	return state, nil
+}
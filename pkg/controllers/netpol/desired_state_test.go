@@ -0,0 +1,67 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderDesiredState(t *testing.T) {
+	krNetPol, cluster := newTestController(t,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "nsA", Labels: map[string]string{"app": "client"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.11", PodIP: "1.1.2.1"}})
+
+	netpol := tNetpol{
+		name:        "allow-client",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress: []netv1.NetworkPolicyIngressRule{
+			{From: []netv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}}}},
+		},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	state, err := krNetPol.RenderDesiredState()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	targetSet := policyDestinationPodIpSetName("nsA", "allow-client")
+	sourceSet := policyIndexedSourcePodIpSetName("nsA", "allow-client", 0)
+	expectedIPSets := []string{targetSet, targetSet + " 1.1.1.1", sourceSet, sourceSet + " 1.1.2.1"}
+	ipsets := strings.Join(state.Lines(nodestate.IPSets), "\n")
+	for _, line := range expectedIPSets {
+		if !strings.Contains(ipsets, line) {
+			t.Errorf("expected ipset line %q in rendered state:\n%s", line, ipsets)
+		}
+	}
+
+	rules := state.Lines(nodestate.IPTables)
+	if len(rules) != 5 {
+		t.Fatalf("expected the jumps to the dispatch chains and the FORWARD and OUTPUT jump rules for the local pod, "+
+			"got %v", rules)
+	}
+	podJumps := 0
+	for _, rule := range rules {
+		switch {
+		case strings.HasPrefix(rule, "filter KUBE-ROUTER-"):
+			if !strings.Contains(rule, "POD name:web namespace: nsA to chain KUBE-POD-FW-*") {
+				t.Errorf("expected normalized jump rule to the pod firewall chain, got %s", rule)
+			}
+			podJumps++
+		case !strings.Contains(rule, " -j KUBE-ROUTER-"):
+			t.Errorf("expected a jump rule to a dispatch chain, got %s", rule)
+		}
+	}
+	if podJumps != 2 {
+		t.Errorf("expected the jump rules for the local pod in the dispatch chains, got %v", rules)
+	}
+}
@@ -0,0 +1,152 @@
+package netpol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+// The rules jumping to the pod firewall chains are appended to the dispatch chains kube-router owns, which are
+// replaced along with the pod firewall chains by each sync. The FORWARD, OUTPUT and INPUT chains only get a single
+// rule jumping to their dispatch chain, inserted once at its position and left as is by the following syncs, so the
+// rules of the node and of the other components around it never shift as pods come and go.
+
+// built-in chains the pod firewall chains are jumped to from, in the order they are synced
+var podFwBuiltInChains = []string{"FORWARD", "OUTPUT", "INPUT"}
+
+// podFwDispatchChains are the dispatch chains of the built-in chains
+var podFwDispatchChains = map[string]string{
+	"FORWARD": "KUBE-ROUTER-FORWARD",
+	"OUTPUT":  "KUBE-ROUTER-OUTPUT",
+	"INPUT":   "KUBE-ROUTER-INPUT",
+}
+
+// podFwJump is a rule of a dispatch chain jumping to a pod firewall chain
+type podFwJump struct {
+	chain string
+	args  []string
+}
+
+// target returns the pod firewall chain the rule jumps to
+func (j podFwJump) target() string {
+	return j.args[len(j.args)-1]
+}
+
+// newPodFwJump returns the rule of the dispatch chain of the built-in chain jumping to a pod firewall chain
+func newPodFwJump(builtIn string, args []string) podFwJump {
+	return podFwJump{chain: podFwDispatchChains[builtIn], args: args}
+}
+
+const dispatchJumpComment = "rule to jump traffic to the pod firewall chains"
+
+func dispatchJumpArgs(builtIn string) []string {
+	return []string{"-m", "comment", "--comment", dispatchJumpComment, "-j", podFwDispatchChains[builtIn]}
+}
+
+// jumpsToDispatchChain tells whether the rule of a built-in chain jumps to its dispatch chain
+func jumpsToDispatchChain(rule utils.IPTablesSaveRule) bool {
+	return podFwDispatchChains[rule.Chain] == rule.Target
+}
+
+// syncDispatchJumps adds the rules of the built-in chains jumping to the dispatch chains to filterTable when they are
+// missing, duplicated or away from their position, and deletes the rules jumping to the pod firewall chains directly
+// left by the previous versions, unless the removal of the stale chains is held. The built-in chains are listed for
+// the jumps, and for the marker rule of the position.
+func (npc *NetworkPolicyController) syncDispatchJumps(filterTable *utils.IPTablesRestore, held bool) error {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return fmt.Errorf("Failed to initialize iptables executor: %s", err.Error())
+	}
+	p := npc.jumpPosition
+	for _, builtIn := range podFwBuiltInChains {
+		listed, err := iptablesCmdHandler.List("filter", builtIn)
+		if err != nil {
+			return fmt.Errorf("failed to list the rules of the %s chain: %s", builtIn, err)
+		}
+		listedRules, err := chainRules(listed)
+		if err != nil {
+			return err
+		}
+		// the deleted rules go first, by the same iptables-restore
+		jumps := 0
+		rules := make([]utils.IPTablesSaveRule, 0, len(listedRules))
+		others := make([]utils.IPTablesSaveRule, 0, len(listedRules))
+		for _, rule := range listedRules {
+			switch {
+			case jumpsToPodFirewall(rule) && !held:
+				filterTable.DeleteRule(builtIn, rule.Args...)
+				continue
+			case jumpsToDispatchChain(rule):
+				jumps++
+			default:
+				others = append(others, rule)
+			}
+			rules = append(rules, rule)
+		}
+		if p != nil && p.strategy == jumpPositionAfterMarker {
+			missing := p.markerIndex(others) < 0
+			if missing && !p.missingMarker[builtIn] {
+				glog.Warningf("No rule of the %s chain has the comment %q, the jump to the pod firewall chains is "+
+					"inserted at its top", builtIn, p.marker)
+			}
+			p.missingMarker[builtIn] = missing
+		}
+		if jumps == 1 && !p.misplaced(rules) {
+			continue
+		}
+
+		for _, rule := range rules {
+			if jumpsToDispatchChain(rule) {
+				filterTable.DeleteRule(builtIn, rule.Args...)
+			}
+		}
+		filterTable.SetRulePosition(builtIn, p.rulePosition(others))
+		filterTable.InsertUnique(builtIn, dispatchJumpArgs(builtIn)...)
+	}
+	return nil
+}
+
+// applyFilterTable programs the network policy, pod firewall and dispatch chains built by the sync, and the rules
+// jumping to the dispatch chains, in a single iptables-restore, so packets never go through a chain still being
+// built: the dispatch chains jump to either the previous or the new version of the pod firewall chains, complete.
+func (npc *NetworkPolicyController) applyFilterTable(filterTable *utils.IPTablesRestore) error {
+	start := time.Now()
+	if err := filterTable.Apply(); err != nil {
+		return err
+	}
+	glog.V(2).Infof("Applied %d rules with iptables-restore in %v", filterTable.Rules(), time.Since(start))
+	npc.dispatchChainsSynced = true
+	return nil
+}
+
+// deleteDispatchChains deletes the dispatch chains, along with the rules of the built-in chains jumping to them
+func deleteDispatchChains(iptablesCmdHandler *iptables.IPTables) error {
+	chains, err := iptablesCmdHandler.ListChains("filter")
+	if err != nil {
+		return fmt.Errorf("Failed to list chains in filter table: %s", err)
+	}
+	exists := make(map[string]bool, len(chains))
+	for _, chain := range chains {
+		exists[chain] = true
+	}
+	for _, builtIn := range podFwBuiltInChains {
+		if _, err := utils.DeleteIPTablesRules(iptablesCmdHandler, "filter", builtIn, jumpsToDispatchChain); err != nil {
+			return err
+		}
+		dispatchChain := podFwDispatchChains[builtIn]
+		if !exists[dispatchChain] {
+			continue
+		}
+		if err := iptablesCmdHandler.ClearChain("filter", dispatchChain); err != nil {
+			return fmt.Errorf("Failed to flush the rules in chain %s due to %s", dispatchChain, err)
+		}
+		if err := iptablesCmdHandler.DeleteChain("filter", dispatchChain); err != nil {
+			return fmt.Errorf("Failed to delete the chain %s due to %s", dispatchChain, err)
+		}
+		glog.V(2).Infof("Deleted chain: %s from the filter table", dispatchChain)
+	}
+	return nil
+}
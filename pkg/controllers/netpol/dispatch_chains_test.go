@@ -0,0 +1,116 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDispatchChains(t *testing.T) {
+	jump := newPodFwJump("INPUT", []string{"-s", "10.1.0.5", "-j", "KUBE-POD-FW-AAAA"})
+	if jump.chain != "KUBE-ROUTER-INPUT" {
+		t.Errorf("expected the jump to the pod firewall chain in the dispatch chain of INPUT, got %+v", jump)
+	}
+
+	for line, dispatch := range map[string]bool{
+		"-A FORWARD -m comment --comment \"rule to jump traffic to the pod firewall chains\" -j KUBE-ROUTER-FORWARD": true,
+		"-A OUTPUT -j KUBE-ROUTER-OUTPUT":                         true,
+		"-A OUTPUT -j KUBE-ROUTER-FORWARD":                        false,
+		"-A FORWARD -d 10.1.0.5/32 -j KUBE-POD-FW-AAAA":           false,
+		"-A KUBE-ROUTER-INPUT -s 10.1.0.5/32 -j KUBE-POD-FW-AAAA": false,
+	} {
+		rule, _, err := utils.ParseIPTablesRule(line)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if jumpsToDispatchChain(rule) != dispatch {
+			t.Errorf("expected %q to jump to its dispatch chain: %v", line, dispatch)
+		}
+	}
+}
+
+func TestHeldShrinkKeepsDispatchJumps(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.shrinkGuard = &shrinkGuard{threshold: 40}
+
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+		Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}}
+	tAddToInformerStore(t, cluster.podInformer, pod)
+	tAddToInformerStore(t, cluster.podInformer, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "nsA",
+		Labels: map[string]string{"app": "web"}}, Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.2"}})
+	netpol := tNetpol{
+		name:        "deny-all",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress:     []netv1.NetworkPolicyIngressRule{},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	// syncs the pod firewall chains of the version, and returns the iptables-restore input and whether the removal
+	// of the stale chains was held
+	sync := func(version string) (string, bool) {
+		var err error
+		krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		filterTable := utils.NewIPTablesRestore("filter")
+		chains, jumps, err := krNetPol.syncPodFirewallChains(filterTable, version)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		held := krNetPol.holdStaleRules(filterTable, nil, chains, nil)
+		krNetPol.dispatchJumps = jumps
+		krNetPol.syncedRules = &activeRules{podFwChains: chains}
+		return string(filterTable.Bytes()), held
+	}
+	staleJump := "-j " + podFirewallChainName("nsA", "web", "1") + "\n"
+	if _, held := sync("1"); held {
+		t.Fatalf("expected the first sync not to hold the removal of the stale chains")
+	}
+
+	// the pod is gone from the lister, the shrink is held
+	if err := cluster.podInformer.GetStore().Delete(pod); err != nil {
+		t.Fatalf("error deleting pod from Informer Store: %v", err)
+	}
+	input, held := sync("2")
+	if !held {
+		t.Fatalf("expected the shrink to hold the removal of the stale chains")
+	}
+	for _, dispatchChain := range []string{"KUBE-ROUTER-FORWARD", "KUBE-ROUTER-OUTPUT"} {
+		if !strings.Contains(input, "-A "+dispatchChain+" ") || !strings.Contains(input, staleJump) {
+			t.Errorf("expected %s to keep jumping to the stale pod firewall chain:\n%s", dispatchChain, input)
+		}
+	}
+	heldJumps := len(krNetPol.heldJumps)
+	if krNetPol.heldRules == nil || !krNetPol.heldRules.podFwChains[podFirewallChainName("nsA", "web", "1")] {
+		t.Errorf("expected the chains of the last sync before the shrink to be kept")
+	}
+	// carried over by the following syncs until the shrink is confirmed, without the jumps of the held syncs
+	input, _ = sync("3")
+	if !strings.Contains(input, staleJump) {
+		t.Errorf("expected the held sync to keep jumping to the stale pod firewall chain:\n%s", input)
+	}
+	if strings.Contains(input, "-j "+podFirewallChainName("nsA", "db", "2")+"\n") {
+		t.Errorf("expected the held sync not to jump to the pod firewall chains of the previous held sync:\n%s", input)
+	}
+	if len(krNetPol.heldJumps) != heldJumps {
+		t.Errorf("expected the held jumps to stay %d, got %d", heldJumps, len(krNetPol.heldJumps))
+	}
+
+	// the pod is back, the stale chains are removed and no longer jumped to
+	tAddToInformerStore(t, cluster.podInformer, pod)
+	input, held = sync("4")
+	if held || strings.Contains(input, staleJump) || krNetPol.heldJumps != nil {
+		t.Errorf("expected the stale pod firewall chains not to be jumped to once the shrink is dropped:\n%s", input)
+	}
+	if !strings.Contains(input, "-j "+podFirewallChainName("nsA", "web", "4")+"\n") {
+		t.Errorf("expected the dispatch chains to jump to the pod firewall chain of the sync:\n%s", input)
+	}
+}
@@ -0,0 +1,212 @@
+package netpol
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+)
+
+// The packets of the drop log are turned into drop events naming the objects of their addresses and the network
+// policies isolating the pod the drop is accounted to, and exported as JSON lines to a file or a unix socket, and as
+// counters by namespace. The drop log being rate limited per pod, the events are a sample of the dropped traffic.
+
+const (
+	dropExportUnixPrefix = "unix://"
+	// time a write to the socket of the drop exporter may block the reader of the drop log
+	dropExportWriteTimeout = time.Second
+)
+
+// DropEndpoint is an address of a dropped packet and the object it belongs to, the kind is Pod, Service or Node, and
+// empty for the addresses outside the cluster
+type DropEndpoint struct {
+	IP        string `json:"ip"`
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// object names the object of the endpoint the way the drop log does, e.g. "pod web/frontend" or the address
+func (e DropEndpoint) object() string {
+	switch {
+	case e.Kind == "":
+		return e.IP
+	case e.Namespace == "":
+		return strings.ToLower(e.Kind) + " " + e.Name
+	}
+	return strings.ToLower(e.Kind) + " " + e.Namespace + "/" + e.Name
+}
+
+// DropEvent is a packet dropped by the network policies. Packets from the pods of the node were dropped by their
+// egress policies, the others by the ingress policies of their destination.
+type DropEvent struct {
+	Time        time.Time    `json:"time"`
+	Direction   string       `json:"direction"`
+	Source      DropEndpoint `json:"source"`
+	Destination DropEndpoint `json:"destination"`
+	Protocol    string       `json:"protocol"`
+	// Port is the destination port, 0 for protocols without ports
+	Port int `json:"port,omitempty"`
+	// Policies are the namespace/name of the policies isolating the pod in the direction of the drop, none of
+	// which allowed the packet
+	Policies []string `json:"policies,omitempty"`
+	// Audit is set for the packets the policies would have dropped, accepted as they are in audit mode
+	Audit bool `json:"audit,omitempty"`
+}
+
+// dropEvents turns the packets of the drop log into drop events, with the policies of the last sync
+type dropEvents struct {
+	resolver *addressResolver
+
+	mu sync.Mutex
+	// namespace/name of the policies isolating the pods, by address of the pod
+	ingressPolicies map[string][]string
+	egressPolicies  map[string][]string
+	now             func() time.Time
+}
+
+func newDropEvents(resolver *addressResolver) *dropEvents {
+	return &dropEvents{resolver: resolver, now: time.Now}
+}
+
+// recordSync indexes the policies isolating the pods from the policies of a sync
+func (d *dropEvents) recordSync(policies *[]networkPolicyInfo) {
+	if d == nil || policies == nil {
+		return
+	}
+	ingressPolicies, egressPolicies := make(map[string][]string), make(map[string][]string)
+	for _, policy := range *policies {
+		for ip := range policy.targetPods {
+			name := policy.namespace + "/" + policy.name
+			if policy.policyType == "both" || policy.policyType == "ingress" {
+				ingressPolicies[ip] = append(ingressPolicies[ip], name)
+			}
+			if policy.policyType == "both" || policy.policyType == "egress" {
+				egressPolicies[ip] = append(egressPolicies[ip], name)
+			}
+		}
+	}
+	for _, index := range []map[string][]string{ingressPolicies, egressPolicies} {
+		for _, names := range index {
+			sort.Strings(names)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ingressPolicies, d.egressPolicies = ingressPolicies, egressPolicies
+}
+
+// event returns the drop event of a packet of the drop log
+func (d *dropEvents) event(p droppedPacket) DropEvent {
+	src, local := d.resolver.resolve(p.src)
+	dst, _ := d.resolver.resolve(p.dst)
+	event := DropEvent{Time: d.now(), Direction: "ingress", Source: src, Destination: dst, Protocol: p.protocol,
+		Port: p.dstPort, Audit: p.audit}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if local {
+		event.Direction = "egress"
+		event.Policies = d.egressPolicies[src.IP]
+	} else {
+		event.Policies = d.ingressPolicies[dst.IP]
+	}
+	return event
+}
+
+// dropExporter writes the drop events as JSON lines to a file, or to a unix socket it reconnects to on failure
+type dropExporter struct {
+	target string
+	socket bool
+
+	w      io.WriteCloser
+	failed bool
+}
+
+// newDropExporter returns the exporter of the drop events to a file path or to a unix:// socket
+func newDropExporter(target string) (*dropExporter, error) {
+	e := &dropExporter{target: target}
+	if strings.HasPrefix(target, dropExportUnixPrefix) {
+		e.target, e.socket = strings.TrimPrefix(target, dropExportUnixPrefix), true
+	}
+	if e.target == "" {
+		return nil, errors.New("--drop-flow-export must be a file path or a unix:// socket path")
+	}
+	return e, nil
+}
+
+// open opens the file, or connects to the socket, of the exporter
+func (e *dropExporter) open() error {
+	if e.socket {
+		conn, err := net.Dial("unix", e.target)
+		if err != nil {
+			return err
+		}
+		e.w = conn
+		return nil
+	}
+	f, err := os.OpenFile(e.target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	e.w = f
+	return nil
+}
+
+// export writes the event. On failure the event is lost and the file, or the socket, opened again for the next
+// one, the failure being logged once until an event is exported again.
+func (e *dropExporter) export(event DropEvent) {
+	err := e.write(event)
+	if err != nil && !e.failed {
+		glog.Errorf("Failed to export the drop events to %s: %s", e.target, err)
+	}
+	e.failed = err != nil
+}
+
+func (e *dropExporter) write(event DropEvent) error {
+	if e.w == nil {
+		if err := e.open(); err != nil {
+			return err
+		}
+	}
+	if conn, ok := e.w.(net.Conn); ok {
+		if err := conn.SetWriteDeadline(time.Now().Add(dropExportWriteTimeout)); err != nil {
+			return err
+		}
+	}
+	if err := json.NewEncoder(e.w).Encode(event); err != nil {
+		e.close()
+		return err
+	}
+	return nil
+}
+
+func (e *dropExporter) close() {
+	if e.w != nil {
+		e.w.Close()
+		e.w = nil
+	}
+}
+
+// exportDropEventMetrics counts the event by direction and namespaces of its addresses, empty for the addresses that
+// are not of a pod or service. The namespaces are bounded by the tenant labels when enabled.
+func exportDropEventMetrics(event DropEvent, tenantLabels *metrics.TenantLabels) {
+	namespace := func(endpoint DropEndpoint) string {
+		if tenantLabels == nil || endpoint.Namespace == "" {
+			return endpoint.Namespace
+		}
+		namespace, _ := tenantLabels.Values(endpoint.Namespace, "")
+		return namespace
+	}
+	metrics.ControllerPolicyDropEvents.WithLabelValues(event.Direction, namespace(event.Source),
+		namespace(event.Destination)).Inc()
+}
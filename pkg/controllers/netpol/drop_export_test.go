@@ -0,0 +1,80 @@
+package netpol
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	dto "github.com/prometheus/client_model/go"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDropEvents(t *testing.T) {
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pods.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend"},
+		Status: v1.PodStatus{PodIP: "10.1.0.5", HostIP: "192.168.0.1"}})
+	pods.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "postgres"},
+		Status: v1.PodStatus{PodIP: "10.1.1.7", HostIP: "192.168.0.2"}})
+	events := newDropEvents(newAddressResolver("192.168.0.1", pods, nil, nil))
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	events.now = func() time.Time { return now }
+	frontend := map[string]podInfo{"10.1.0.5": {ip: "10.1.0.5", name: "frontend", namespace: "web"}}
+	events.recordSync(&[]networkPolicyInfo{
+		{name: "deny-all", namespace: "web", targetPods: frontend, policyType: "both"},
+		{name: "allow-lb", namespace: "web", targetPods: frontend, policyType: "ingress"},
+	})
+
+	egress := events.event(droppedPacket{src: net.ParseIP("10.1.0.5"), dst: net.ParseIP("10.1.1.7"), protocol: "TCP",
+		dstPort: 5432})
+	expected := DropEvent{Time: now, Direction: "egress",
+		Source:      DropEndpoint{IP: "10.1.0.5", Kind: "Pod", Namespace: "web", Name: "frontend"},
+		Destination: DropEndpoint{IP: "10.1.1.7", Kind: "Pod", Namespace: "db", Name: "postgres"},
+		Protocol:    "TCP", Port: 5432, Policies: []string{"web/deny-all"}}
+	if !reflect.DeepEqual(egress, expected) {
+		t.Errorf("expected egress drop event %+v, got %+v", expected, egress)
+	}
+	ingress := events.event(droppedPacket{src: net.ParseIP("203.0.113.9"), dst: net.ParseIP("10.1.0.5"),
+		protocol: "ICMP"})
+	if ingress.Direction != "ingress" || ingress.Source != (DropEndpoint{IP: "203.0.113.9"}) ||
+		!reflect.DeepEqual(ingress.Policies, []string{"web/allow-lb", "web/deny-all"}) {
+		t.Errorf("unexpected ingress drop event %+v", ingress)
+	}
+
+	path := t.TempDir() + "/drops.json"
+	exporter, err := newDropExporter(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exporter.export(egress)
+	exporter.export(ingress)
+	exporter.close()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the exported drop events: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	exported := DropEvent{}
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[0]), &exported) != nil || !reflect.DeepEqual(exported, egress) {
+		t.Errorf("expected the drop events as JSON lines, got:\n%s", data)
+	}
+	if _, err := newDropExporter("unix://"); err == nil {
+		t.Error("expected a unix socket without path to be rejected")
+	}
+
+	exportDropEventMetrics(egress, nil)
+	m := &dto.Metric{}
+	if err := metrics.ControllerPolicyDropEvents.WithLabelValues("egress", "web", "db").Write(m); err != nil {
+		t.Fatalf("unexpected error reading counter: %v", err)
+	}
+	if m.GetCounter().GetValue() != 1 {
+		t.Errorf("expected a drop event counted from web to db, got %v", m.GetCounter().GetValue())
+	}
+}
@@ -0,0 +1,286 @@
+package netpol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// the addresses of the cluster objects are indexed again when older than this
+var addressIndexTTL = 30 * time.Second
+
+// droppedPacket is the addresses and protocol of a packet dropped by the network policies
+type droppedPacket struct {
+	src, dst net.IP
+	protocol string
+	// destination port, 0 for protocols without ports
+	dstPort int
+	// logged by the audit rules, accepted as the network policies are in audit mode
+	audit bool
+}
+
+// logLimitUnits are the units of the rate limits of the drop log, as nftables spells them. iptables accepts them as
+// well as their prefixes.
+var logLimitUnits = []string{"second", "minute", "hour", "day"}
+
+// parseLogLimit validates the rate limit of the drop log, e.g. '10/minute', and returns it with its unit spelled
+// out, as both the iptables and nftables backends accept it
+func parseLogLimit(limit string) (string, error) {
+	parts := strings.SplitN(limit, "/", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		if n, err := strconv.Atoi(parts[0]); err == nil && n > 0 {
+			for _, unit := range logLimitUnits {
+				if strings.HasPrefix(unit, strings.ToLower(parts[1])) {
+					return parts[0] + "/" + unit, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("invalid --netpol-log-limit %q, must be a number of packets per second, minute, hour or "+
+		"day, e.g. '10/minute'", limit)
+}
+
+// parseDroppedPacket parses the network and transport headers of a logged packet, returns false when they are
+// truncated or not IP
+func parseDroppedPacket(payload []byte) (droppedPacket, bool) {
+	var p droppedPacket
+	var protocol byte
+	var transport []byte
+	if len(payload) < 1 {
+		return p, false
+	}
+	switch payload[0] >> 4 {
+	case 4:
+		headerLen := int(payload[0]&0x0f) * 4
+		if len(payload) < 20 || headerLen < 20 || len(payload) < headerLen {
+			return p, false
+		}
+		protocol = payload[9]
+		p.src, p.dst = net.IP(payload[12:16]), net.IP(payload[16:20])
+		transport = payload[headerLen:]
+	case 6:
+		if len(payload) < 40 {
+			return p, false
+		}
+		// extension headers are not followed, the port is only reported when the transport header comes first
+		protocol = payload[6]
+		p.src, p.dst = net.IP(payload[8:24]), net.IP(payload[24:40])
+		transport = payload[40:]
+	default:
+		return p, false
+	}
+
+	switch protocol {
+	case 1, 58:
+		p.protocol = "ICMP"
+	case 6:
+		p.protocol = "TCP"
+	case 17:
+		p.protocol = "UDP"
+	case 132:
+		p.protocol = "SCTP"
+	default:
+		p.protocol = strconv.Itoa(int(protocol))
+	}
+	if (protocol == 6 || protocol == 17 || protocol == 132) && len(transport) >= 4 {
+		p.dstPort = int(binary.BigEndian.Uint16(transport[2:4]))
+	}
+	return p, true
+}
+
+// addressResolver names the pods, services and nodes of the addresses found in the drop log. The addresses are
+// indexed from the informer caches at most every addressIndexTTL, as looking them up on each packet would scan the
+// caches. The other addresses are named after the domain the pods resolved them from, when the FQDN snooper cached
+// the DNS answer.
+type addressResolver struct {
+	nodeIP        string
+	podLister     cache.Indexer
+	serviceLister cache.Indexer
+	nodeLister    cache.Indexer
+	// DNS answers snooped for the FQDN network policies, nil without them
+	fqdns *fqdnCache
+
+	mu        sync.Mutex
+	objects   map[string]DropEndpoint
+	localPods map[string]bool
+	indexed   time.Time
+	now       func() time.Time
+}
+
+func newAddressResolver(nodeIP string, podLister, serviceLister, nodeLister cache.Indexer) *addressResolver {
+	return &addressResolver{nodeIP: nodeIP, podLister: podLister, serviceLister: serviceLister, nodeLister: nodeLister,
+		now: time.Now}
+}
+
+// resolve returns the object the address belongs to, the domain it was resolved from or only its address for the
+// addresses outside the cluster, and whether it is a pod of the node
+func (r *addressResolver) resolve(ip net.IP) (DropEndpoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if r.objects == nil || now.Sub(r.indexed) > addressIndexTTL {
+		r.index()
+		r.indexed = now
+	}
+	object, ok := r.objects[ip.String()]
+	if !ok && r.fqdns != nil {
+		if name, ok := r.fqdns.name(ip.String(), now); ok {
+			object = DropEndpoint{Kind: "Domain", Name: name}
+		}
+	}
+	object.IP = ip.String()
+	return object, r.localPods[ip.String()]
+}
+
+// lookup returns the object the address belongs to, e.g. "service kube-system/kube-dns" or the address itself, and
+// whether it is a pod of the node
+func (r *addressResolver) lookup(ip net.IP) (string, bool) {
+	object, local := r.resolve(ip)
+	return object.object(), local
+}
+
+// index maps the addresses to their objects. Services win over pods and pods over nodes, so host network pods
+// are reported as their node.
+func (r *addressResolver) index() {
+	objects := make(map[string]DropEndpoint)
+	localPods := make(map[string]bool)
+	add := func(ip string, object DropEndpoint) {
+		if ip == "" || ip == api.ClusterIPNone {
+			return
+		}
+		if _, ok := objects[ip]; !ok {
+			objects[ip] = object
+		}
+	}
+	if r.serviceLister != nil {
+		for _, obj := range r.serviceLister.List() {
+			svc, ok := obj.(*api.Service)
+			if !ok {
+				continue
+			}
+			object := DropEndpoint{Kind: "Service", Namespace: svc.Namespace, Name: svc.Name}
+			add(svc.Spec.ClusterIP, object)
+			for _, ip := range svc.Spec.ExternalIPs {
+				add(ip, object)
+			}
+			for _, ingress := range svc.Status.LoadBalancer.Ingress {
+				add(ingress.IP, object)
+			}
+		}
+	}
+	if r.podLister != nil {
+		for _, obj := range r.podLister.List() {
+			pod, ok := obj.(*api.Pod)
+			if !ok || pod.Spec.HostNetwork {
+				continue
+			}
+			add(pod.Status.PodIP, DropEndpoint{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name})
+			if pod.Status.HostIP == r.nodeIP && pod.Status.PodIP != "" {
+				localPods[pod.Status.PodIP] = true
+			}
+		}
+	}
+	if r.nodeLister != nil {
+		for _, obj := range r.nodeLister.List() {
+			node, ok := obj.(*api.Node)
+			if !ok {
+				continue
+			}
+			for _, address := range node.Status.Addresses {
+				if address.Type == api.NodeInternalIP || address.Type == api.NodeExternalIP {
+					add(address.Address, DropEndpoint{Kind: "Node", Name: node.Name})
+				}
+			}
+		}
+	}
+	r.objects, r.localPods = objects, localPods
+}
+
+// describe words the drop of a packet. Packets from the pods of the node were dropped by their egress policies,
+// the others by the ingress policies of their destination. The addresses are given along with the object they
+// were resolved to.
+func (r *addressResolver) describe(p droppedPacket) string {
+	port := p.protocol
+	if p.dstPort != 0 {
+		port = p.protocol + "/" + strconv.Itoa(p.dstPort)
+	}
+	denied := "Denied"
+	if p.audit {
+		denied = "Audit mode would have denied"
+	}
+	src, local := r.lookup(p.src)
+	dst, _ := r.lookup(p.dst)
+	if local {
+		if dst != p.dst.String() {
+			port = p.dst.String() + " " + port
+		}
+		return denied + " egress of " + src + " to " + dst + " (" + port + ")"
+	}
+	if src != p.src.String() {
+		src += " (" + p.src.String() + ")"
+	}
+	return denied + " ingress to " + dst + " (" + port + ") from " + src
+}
+
+// runDropLog reads the packets dropped by the network policies from the drop log NFLOG group and logs them with the
+// objects of their addresses, exports them as drop events, counts them and records them as events of the pods, until
+// stopCh is closed
+func (npc *NetworkPolicyController) runDropLog(stopCh <-chan struct{}) {
+	reader, err := utils.NewNFLogReader(npc.dropLogGroup)
+	if err != nil {
+		glog.Errorf("Failed to read the drop log: %s", err)
+		return
+	}
+	defer reader.Close()
+	if npc.dropExporter != nil {
+		defer npc.dropExporter.close()
+	}
+	for {
+		packets, err := reader.Read()
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		if err != nil {
+			// typically the socket buffer overflowed and packets were lost
+			glog.Errorf("Failed to read the drop log: %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, packet := range packets {
+			dropped, ok := parseDroppedPacket(packet.Payload)
+			if !ok {
+				continue
+			}
+			dropped.audit = packet.Prefix == auditLogPrefix
+			if npc.logDroppedTraffic {
+				glog.Info(npc.dropEvents.resolver.describe(dropped))
+			}
+			if npc.dropExporter == nil && !npc.dropEventMetrics && !npc.denyEvents {
+				continue
+			}
+			event := npc.dropEvents.event(dropped)
+			if npc.dropExporter != nil {
+				npc.dropExporter.export(event)
+			}
+			// the audited packets are counted from the rules accepting them
+			if npc.dropEventMetrics && !event.Audit {
+				exportDropEventMetrics(event, npc.tenantLabels)
+			}
+			if npc.denyEvents {
+				npc.recordDenyEvent(event)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package netpol
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestParseDroppedPacket(t *testing.T) {
+	ipv4 := make([]byte, 28)
+	ipv4[0], ipv4[9] = 0x45, 17
+	copy(ipv4[12:16], net.ParseIP("10.1.0.5").To4())
+	copy(ipv4[16:20], net.ParseIP("10.96.0.10").To4())
+	ipv4[22], ipv4[23] = 0, 53
+	p, ok := parseDroppedPacket(ipv4)
+	if !ok || !p.src.Equal(net.ParseIP("10.1.0.5")) || !p.dst.Equal(net.ParseIP("10.96.0.10")) ||
+		p.protocol != "UDP" || p.dstPort != 53 {
+		t.Errorf("unexpected IPv4 packet %+v", p)
+	}
+
+	ipv6 := make([]byte, 44)
+	ipv6[0], ipv6[6] = 0x60, 6
+	copy(ipv6[8:24], net.ParseIP("fd00::5"))
+	copy(ipv6[24:40], net.ParseIP("fd00::6"))
+	ipv6[42], ipv6[43] = 0x1f, 0x90
+	p, ok = parseDroppedPacket(ipv6)
+	if !ok || !p.dst.Equal(net.ParseIP("fd00::6")) || p.protocol != "TCP" || p.dstPort != 8080 {
+		t.Errorf("unexpected IPv6 packet %+v", p)
+	}
+
+	if _, ok := parseDroppedPacket(ipv4[:16]); ok {
+		t.Error("expected a truncated IPv4 header to be rejected")
+	}
+	if _, ok := parseDroppedPacket([]byte{0x20}); ok {
+		t.Error("expected a packet that is not IP to be rejected")
+	}
+}
+
+func TestAddressResolverDescribe(t *testing.T) {
+	newIndexer := func(objs ...interface{}) cache.Indexer {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		for _, obj := range objs {
+			indexer.Add(obj)
+		}
+		return indexer
+	}
+	pods := newIndexer(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend"},
+			Status: v1.PodStatus{PodIP: "10.1.0.5", HostIP: "192.168.0.1"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "postgres"},
+			Status: v1.PodStatus{PodIP: "10.1.1.7", HostIP: "192.168.0.2"}},
+	)
+	services := newIndexer(&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-dns"},
+		Spec: v1.ServiceSpec{ClusterIP: "10.96.0.10"}})
+	nodes := newIndexer(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+		Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.0.2"}}}})
+	resolver := newAddressResolver("192.168.0.1", pods, services, nodes)
+
+	tests := []struct {
+		packet   droppedPacket
+		expected string
+	}{
+		{
+			droppedPacket{src: net.ParseIP("10.1.0.5"), dst: net.ParseIP("10.96.0.10"), protocol: "UDP", dstPort: 53},
+			"Denied egress of pod web/frontend to service kube-system/kube-dns (10.96.0.10 UDP/53)",
+		},
+		{
+			droppedPacket{src: net.ParseIP("10.1.0.5"), dst: net.ParseIP("192.168.0.2"), protocol: "ICMP"},
+			"Denied egress of pod web/frontend to node node-2 (192.168.0.2 ICMP)",
+		},
+		{
+			droppedPacket{src: net.ParseIP("10.1.1.7"), dst: net.ParseIP("10.1.0.5"), protocol: "TCP", dstPort: 80},
+			"Denied ingress to pod web/frontend (TCP/80) from pod db/postgres (10.1.1.7)",
+		},
+		{
+			droppedPacket{src: net.ParseIP("203.0.113.9"), dst: net.ParseIP("10.1.0.5"), protocol: "TCP", dstPort: 443},
+			"Denied ingress to pod web/frontend (TCP/443) from 203.0.113.9",
+		},
+		{
+			droppedPacket{src: net.ParseIP("10.1.0.5"), dst: net.ParseIP("198.51.100.1"), protocol: "TCP", dstPort: 443},
+			"Denied egress of pod web/frontend to 198.51.100.1 (TCP/443)",
+		},
+	}
+	for _, test := range tests {
+		if got := resolver.describe(test.packet); got != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, got)
+		}
+	}
+
+	// the addresses outside the cluster are named after the domain the DNS answers snooped resolved them from
+	resolver.fqdns = newFQDNCache()
+	ttl := func(ttl uint32) time.Duration { return time.Duration(ttl) * time.Second }
+	resolver.fqdns.add(dnsResponse{name: "API.example.com.",
+		addresses: []dnsAddress{{"198.51.100.1", 300}, {"10.96.0.10", 300}}}, ttl, time.Now())
+	resolver.fqdns.add(dnsResponse{name: "expired.example.com.", addresses: []dnsAddress{{"198.51.100.2", 300}}},
+		ttl, time.Now().Add(-time.Hour))
+	tests = []struct {
+		packet   droppedPacket
+		expected string
+	}{
+		{
+			droppedPacket{src: net.ParseIP("10.1.0.5"), dst: net.ParseIP("198.51.100.1"), protocol: "TCP", dstPort: 443},
+			"Denied egress of pod web/frontend to domain api.example.com (198.51.100.1 TCP/443)",
+		},
+		{
+			droppedPacket{src: net.ParseIP("10.1.0.5"), dst: net.ParseIP("10.96.0.10"), protocol: "UDP", dstPort: 53},
+			"Denied egress of pod web/frontend to service kube-system/kube-dns (10.96.0.10 UDP/53)",
+		},
+		{
+			droppedPacket{src: net.ParseIP("10.1.0.5"), dst: net.ParseIP("198.51.100.2"), protocol: "TCP", dstPort: 443},
+			"Denied egress of pod web/frontend to 198.51.100.2 (TCP/443)",
+		},
+	}
+	for _, test := range tests {
+		if got := resolver.describe(test.packet); got != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, got)
+		}
+	}
+}
+
+func TestDropLogLimit(t *testing.T) {
+	for limit, expected := range map[string]string{
+		"10/minute": "10/minute",
+		"5/s":       "5/second",
+		"1/HOUR":    "1/hour",
+		"3/d":       "3/day",
+	} {
+		parsed, err := parseLogLimit(limit)
+		if err != nil || parsed != expected {
+			t.Errorf("expected %q to parse as %q, got %q, %v", limit, expected, parsed, err)
+		}
+	}
+	for _, limit := range []string{"", "10", "10/", "0/minute", "-1/second", "ten/minute", "10/week"} {
+		if _, err := parseLogLimit(limit); err == nil {
+			t.Errorf("expected %q to be rejected", limit)
+		}
+	}
+
+	npc := &NetworkPolicyController{dropLogGroup: 100, dropLogLimit: "10/minute"}
+	pod := podInfo{name: "frontend", namespace: "web"}
+	filterTable := utils.NewIPTablesRestore("filter")
+	npc.appendPodFwDropRules(filterTable, pod, "KUBE-POD-FW-TEST")
+	if input := string(filterTable.Bytes()); !strings.Contains(input, "--nflog-group 100 -m limit --limit 10/minute") {
+		t.Errorf("expected the dropped traffic to be logged to group 100, got:\n%s", input)
+	}
+	npc.dropLogGroup = 0
+	filterTable = utils.NewIPTablesRestore("filter")
+	npc.appendPodFwDropRules(filterTable, pod, "KUBE-POD-FW-TEST")
+	if input := string(filterTable.Bytes()); strings.Contains(input, "NFLOG") || !strings.Contains(input, "-j REJECT") {
+		t.Errorf("expected the dropped traffic to be rejected without being logged, got:\n%s", input)
+	}
+}
@@ -0,0 +1,109 @@
+package netpol
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+)
+
+// The rules of kube-router can be removed behind its back, e.g. by iptables -F FORWARD or a CNI plugin restarting,
+// leaving the pods without enforcement until the next periodic sync. With --policy-flush-check-period, each sync
+// reads back the rules of its dispatch, pod firewall and network policy chains, and the rules of the built-in chains
+// jumping to them, and records their digest. The filter table is read again every period, and a full sync is queued
+// as soon as the digest differs. The rules of the other chains are not part of the digest, nor is the position of
+// the jumps among them, which the jump position check covers.
+
+// programmedRules are the chains programmed by a sync and the digest of their rules read back
+type programmedRules struct {
+	chains map[string]bool
+	digest string
+}
+
+// programmedRulesDigest returns the digest of the rules of the chains, and of the rules of the built-in chains jumping
+// to them, in their order. The stale chains jumping to them are left out, they are removed outside of the syncs.
+func programmedRulesDigest(rules []utils.IPTablesSaveRule, chains map[string]bool) string {
+	h := sha256.New()
+	for _, rule := range rules {
+		if chains[rule.Chain] || (chains[rule.Target] && !strings.HasPrefix(rule.Chain, "KUBE-")) {
+			fmt.Fprintf(h, "%s %s\n", rule.Chain, utils.JoinIPTablesArgs(rule.Args))
+		}
+	}
+	return base32.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// recordProgrammedRules reads back the rules of the dispatch, pod firewall and network policy chains the sync
+// programmed
+func (npc *NetworkPolicyController) recordProgrammedRules(activePolicyChains, activePodFwChains map[string]bool) {
+	chains := make(map[string]bool, len(activePolicyChains)+len(activePodFwChains))
+	for chain := range activePolicyChains {
+		chains[chain] = true
+	}
+	for chain := range activePodFwChains {
+		chains[chain] = true
+	}
+	for _, chain := range podFwDispatchChains {
+		chains[chain] = true
+	}
+	rules, err := nodestate.ReadIPTablesSave("filter")
+	if err != nil {
+		glog.Errorf("Failed to read the rules of the sync for the flush check: %s", err)
+		return
+	}
+	npc.programmedRules = &programmedRules{chains: chains, digest: programmedRulesDigest(rules, chains)}
+}
+
+// runFlushCheck checks every --policy-flush-check-period that the rules of the last sync are in place
+func (npc *NetworkPolicyController) runFlushCheck(stopCh <-chan struct{}) {
+	t := time.NewTicker(npc.flushCheckPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+		if !npc.readyForUpdates {
+			continue
+		}
+		if err := npc.checkFlush(); err != nil {
+			glog.Errorf("Failed to check the rules of the pod firewall and network policy chains: %s", err)
+		}
+	}
+}
+
+// checkFlush queues a full sync when the rules of the last sync were changed, or removed, outside of kube-router
+func (npc *NetworkPolicyController) checkFlush() error {
+	npc.mu.Lock()
+	programmed := npc.programmedRules
+	npc.mu.Unlock()
+	if programmed == nil {
+		return nil
+	}
+	rules, err := nodestate.ReadIPTablesSave("filter")
+	if err != nil {
+		return err
+	}
+
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	// the rules were read while a sync changed them
+	if npc.programmedRules != programmed || programmedRulesDigest(rules, programmed.chains) == programmed.digest {
+		return nil
+	}
+	glog.Warningf("The rules of the pod firewall and network policy chains were changed outside of kube-router, " +
+		"syncing to program them again")
+	if npc.MetricsEnabled {
+		metrics.ControllerPolicyExternalFlushes.Inc()
+	}
+	// checked again once the sync programmed the rules
+	npc.programmedRules = nil
+	npc.syncQueue.add(syncFull)
+	return nil
+}
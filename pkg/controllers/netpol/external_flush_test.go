@@ -0,0 +1,37 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func TestProgrammedRulesDigest(t *testing.T) {
+	rule := func(chain, target string, args ...string) utils.IPTablesSaveRule {
+		return utils.IPTablesSaveRule{Chain: chain, Target: target, Args: append(args, "-j", target)}
+	}
+	podFw := "KUBE-POD-FW-4SDRLFXJXMQ2TJCI"
+	policy := "KUBE-NWPLCY-7ZX6YNNXL3JRSQFN"
+	chains := map[string]bool{podFw: true, policy: true}
+	synced := []utils.IPTablesSaveRule{
+		rule("FORWARD", "ACCEPT", "-i", "eth1"),
+		rule("FORWARD", podFw, "-d", "10.1.0.2/32"),
+		rule(podFw, policy),
+		rule(policy, "ACCEPT", "-p", "tcp", "--dport", "80"),
+		rule("KUBE-POD-FW-STALE", policy),
+	}
+	digest := programmedRulesDigest(synced, chains)
+
+	unchanged := append([]utils.IPTablesSaveRule{rule("FORWARD", "DROP", "-i", "eth2")}, synced[:4]...)
+	if programmedRulesDigest(unchanged, chains) != digest {
+		t.Errorf("expected the digest unchanged by the other rules and the removal of the stale chains")
+	}
+	flushed := synced[2:]
+	if programmedRulesDigest(flushed, chains) == digest {
+		t.Errorf("expected the digest changed by the flush of the FORWARD chain")
+	}
+	changed := append(append([]utils.IPTablesSaveRule(nil), synced[:3]...), rule(policy, "ACCEPT", "-p", "tcp"))
+	if programmedRulesDigest(changed, chains) == digest {
+		t.Errorf("expected the digest changed by a rule of a network policy chain")
+	}
+}
@@ -0,0 +1,143 @@
+package netpol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+)
+
+const (
+	// pods with this label set to "true" get the first packet of each accepted connection logged
+	acceptedFlowLogLabel = "kube-router.io/audit-accepted-flows"
+
+	kubeAcceptedFlowLogChain = "KUBE-ROUTER-ACCEPT-LOG"
+)
+
+// Accepted flow logging is done in the POSTROUTING chain of the mangle table. Packets only get there once the
+// filter table (and so the pod firewall and network policy chains) accepted them, so logging the packets of
+// connections in NEW state there logs the first packet of every accepted connection to or from the audited pods.
+
+func acceptedFlowLogJumpArgs() []string {
+	return []string{"-m", "comment", "--comment", "rule to log accepted connections of audited pods",
+		"-m", "conntrack", "--ctstate", "NEW", "-j", kubeAcceptedFlowLogChain}
+}
+
+// getAcceptedFlowLogPods returns the pods running on the node that are labeled for auditing accepted flows
+func (npc *NetworkPolicyController) getAcceptedFlowLogPods() []podInfo {
+	pods := make([]podInfo, 0)
+	for _, obj := range npc.podLister.List() {
+		pod := obj.(*api.Pod)
+		if pod.Status.HostIP != npc.nodeIP.String() || pod.Status.PodIP == "" {
+			continue
+		}
+		if audit, _ := strconv.ParseBool(pod.ObjectMeta.Labels[acceptedFlowLogLabel]); !audit {
+			continue
+		}
+		pods = append(pods, podInfo{ip: pod.Status.PodIP,
+			name:      pod.ObjectMeta.Name,
+			namespace: pod.ObjectMeta.Namespace,
+			labels:    pod.ObjectMeta.Labels})
+	}
+	return pods
+}
+
+// acceptedFlowLogRules returns the rules of the accepted flow log chain for given pods, logging both the
+// connections to the pod and the connections initiated by the pod
+func (npc *NetworkPolicyController) acceptedFlowLogRules(pods []podInfo) [][]string {
+	rules := make([][]string, 0, 2*len(pods))
+	for _, pod := range pods {
+		for _, direction := range []string{"-d", "-s"} {
+			comment := "rule to log accepted traffic to POD name:" + pod.name + " namespace: " + pod.namespace
+			prefix := "ALLOW-IN "
+			if direction == "-s" {
+				comment = "rule to log accepted traffic from POD name:" + pod.name + " namespace: " + pod.namespace
+				prefix = "ALLOW-OUT "
+			}
+			rules = append(rules, []string{"-m", "comment", "--comment", comment, direction, pod.ip,
+				"-m", "limit", "--limit", npc.acceptedFlowLogLimit, "--limit-burst", strconv.Itoa(npc.acceptedFlowLogBurst),
+				"-j", "NFLOG", "--nflog-group", strconv.Itoa(int(npc.acceptedFlowLogGroup)),
+				"--nflog-prefix", nflogPrefix(prefix + pod.namespace + "/" + pod.name)})
+		}
+	}
+	return rules
+}
+
+// nflogPrefix truncates the prefix to the 64 characters (including the trailing NUL) allowed by the kernel
+func nflogPrefix(prefix string) string {
+	if len(prefix) > 63 {
+		return prefix[:63]
+	}
+	return prefix
+}
+
+// syncAcceptedFlowLog adds the rules to log accepted connections of the audited pods to filterTable, so that the
+// chain is replaced along with the pod firewall chains, without a window where the connections are not logged
+func (npc *NetworkPolicyController) syncAcceptedFlowLog(filterTable *utils.IPTablesRestore) error {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return fmt.Errorf("Failed to initialize iptables executor: %s", err.Error())
+	}
+
+	if npc.acceptedFlowLogGroup == 0 {
+		return cleanupAcceptedFlowLog(iptablesCmdHandler)
+	}
+
+	mangleTable := utils.NewIPTablesRestore("mangle")
+	mangleTable.NewChain(kubeAcceptedFlowLogChain)
+	for _, args := range npc.acceptedFlowLogRules(npc.getAcceptedFlowLogPods()) {
+		mangleTable.AppendUnique(kubeAcceptedFlowLogChain, args...)
+	}
+	exists, err := iptablesCmdHandler.Exists("mangle", "POSTROUTING", acceptedFlowLogJumpArgs()...)
+	if err != nil {
+		return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+	}
+	if !exists {
+		mangleTable.SetRulePosition("POSTROUTING", 0)
+		mangleTable.AppendUnique("POSTROUTING", acceptedFlowLogJumpArgs()...)
+	}
+	filterTable.AddTable(mangleTable)
+	return nil
+}
+
+func cleanupAcceptedFlowLog(iptablesCmdHandler *iptables.IPTables) error {
+	chains, err := iptablesCmdHandler.ListChains("mangle")
+	if err != nil {
+		return fmt.Errorf("Failed to list chains in mangle table: %s", err.Error())
+	}
+	found := false
+	for _, chain := range chains {
+		if strings.Compare(chain, kubeAcceptedFlowLogChain) == 0 {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	exists, err := iptablesCmdHandler.Exists("mangle", "POSTROUTING", acceptedFlowLogJumpArgs()...)
+	if err != nil {
+		return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+	}
+	if exists {
+		err = iptablesCmdHandler.Delete("mangle", "POSTROUTING", acceptedFlowLogJumpArgs()...)
+		if err != nil {
+			return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+		}
+	}
+	err = iptablesCmdHandler.ClearChain("mangle", kubeAcceptedFlowLogChain)
+	if err != nil {
+		return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+	}
+	err = iptablesCmdHandler.DeleteChain("mangle", kubeAcceptedFlowLogChain)
+	if err != nil {
+		return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+	}
+	glog.V(1).Infof("Removed accepted flow logging chain %s", kubeAcceptedFlowLogChain)
+	return nil
+}
@@ -0,0 +1,55 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAcceptedFlowLogPods(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.acceptedFlowLogGroup = 200
+	krNetPol.acceptedFlowLogLimit = "10/second"
+	krNetPol.acceptedFlowLogBurst = 10
+
+	pods := []struct {
+		name   string
+		hostIP string
+		podIP  string
+		audit  string
+	}{
+		{"audited", "10.10.10.10", "1.1.1.1", "true"},
+		{"not-audited", "10.10.10.10", "1.1.1.2", "false"},
+		{"unlabeled", "10.10.10.10", "1.1.1.3", ""},
+		{"audited-other-node", "10.10.10.11", "1.1.2.1", "true"},
+		{"audited-no-ip", "10.10.10.10", "", "true"},
+	}
+	for _, pod := range pods {
+		labels := map[string]string{}
+		if pod.audit != "" {
+			labels[acceptedFlowLogLabel] = pod.audit
+		}
+		tAddToInformerStore(t, cluster.podInformer,
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: pod.name, Namespace: "nsA", Labels: labels},
+				Status: v1.PodStatus{HostIP: pod.hostIP, PodIP: pod.podIP}})
+	}
+
+	auditedPods := krNetPol.getAcceptedFlowLogPods()
+	if len(auditedPods) != 1 || auditedPods[0].name != "audited" {
+		t.Fatalf("expected only pod audited to be selected for accepted flow logging, got %v", auditedPods)
+	}
+
+	rules := krNetPol.acceptedFlowLogRules(auditedPods)
+	if len(rules) != 2 {
+		t.Fatalf("expected a rule for each direction, got %d rules", len(rules))
+	}
+	for i, direction := range []string{"-d 1.1.1.1", "-s 1.1.1.1"} {
+		rule := strings.Join(rules[i], " ")
+		if !strings.Contains(rule, direction) || !strings.Contains(rule, "--nflog-group 200") ||
+			!strings.Contains(rule, "--limit 10/second --limit-burst 10") {
+			t.Errorf("unexpected accepted flow log rule: %s", rule)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package netpol
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+)
+
+// The snooper only sees the responses of the lookups the pods make once a network policy has domain names, so the
+// ipset of a new policy would stay empty until its pods resolve its names again, which they may not do before the
+// records they cached expire. The snooper therefore caches the addresses of the responses it snoops until their
+// records expire, and fills the ipset of a new policy with the cached addresses of its names, each with the time it
+// has left. The names of the policy that are not wildcards and have no cached address are resolved from the cluster
+// DNS. Nothing is refreshed on a timer: the addresses leave the cache and the ipsets, through their ipset timeout,
+// when their records expire, and come back with the next lookup of a pod.
+
+const (
+	// bounds the names cached, the responses of new names are not cached while it is full
+	fqdnCacheMaxNames = 4096
+
+	fqdnResolveTimeout = 2 * time.Second
+)
+
+// fqdnCache is the addresses the cluster DNS resolved names to, by name, until their records expire
+type fqdnCache struct {
+	mu      sync.Mutex
+	records map[string]map[string]time.Time
+}
+
+func newFQDNCache() *fqdnCache {
+	return &fqdnCache{records: make(map[string]map[string]time.Time)}
+}
+
+// add caches the addresses of the response, each expiring after its timeout
+func (c *fqdnCache) add(response dnsResponse, timeout func(uint32) time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := strings.TrimSuffix(strings.ToLower(response.name), ".")
+	addresses, ok := c.records[name]
+	if !ok {
+		if len(c.records) >= fqdnCacheMaxNames {
+			c.expire(now)
+		}
+		if len(c.records) >= fqdnCacheMaxNames {
+			glog.V(2).Infof("Not caching the addresses of %s, %d names are cached", name, len(c.records))
+			return
+		}
+		addresses = make(map[string]time.Time)
+		c.records[name] = addresses
+	}
+	for _, address := range response.addresses {
+		expiry := now.Add(timeout(address.ttl))
+		if expiry.After(addresses[address.ip]) {
+			addresses[address.ip] = expiry
+		}
+	}
+}
+
+// expire removes the expired addresses, and the names left without address
+func (c *fqdnCache) expire(now time.Time) {
+	for name, addresses := range c.records {
+		for ip, expiry := range addresses {
+			if !expiry.After(now) {
+				delete(addresses, ip)
+			}
+		}
+		if len(addresses) == 0 {
+			delete(c.records, name)
+		}
+	}
+}
+
+// lookup returns the cached addresses of the names matching the domain names, with the time they have left
+func (c *fqdnCache) lookup(fqdns []string, now time.Time) map[string]time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := make(map[string]time.Duration)
+	for name, addresses := range c.records {
+		matches := false
+		for _, fqdn := range fqdns {
+			if fqdnMatches(fqdn, name) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		for ip, expiry := range addresses {
+			if left := expiry.Sub(now); left > 0 && left > found[ip] {
+				found[ip] = left
+			}
+		}
+	}
+	return found
+}
+
+// name returns the cached name the address was resolved from, the first in order when it was resolved from several,
+// until its record expires
+func (c *fqdnCache) name(ip string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := ""
+	for name, addresses := range c.records {
+		if expiry, ok := addresses[ip]; ok && expiry.After(now) && (found == "" || name < found) {
+			found = name
+		}
+	}
+	return found, found != ""
+}
+
+// seed fills the ipsets with the cached addresses of their domain names, and resolves from the DNS server, an
+// address and port, the names that are not wildcards and have no cached address
+func (s *fqdnSnooper) seed(sets []fqdnIPSet, server string) {
+	ipsets, err := utils.NewIPSet(false)
+	if err != nil {
+		glog.Errorf("Failed to fill the ipsets of the FQDN network policies: %s", err)
+		return
+	}
+	for _, fqdnSet := range sets {
+		set, err := ipsets.Ensure(fqdnSet.name, utils.TypeHashNet, utils.OptionTimeout, "0")
+		if err != nil {
+			glog.Errorf("Failed to fill ipset %s: %s", fqdnSet.name, err)
+			continue
+		}
+		for ip, left := range s.cache.lookup(fqdnSet.fqdns, time.Now()) {
+			if err := set.AddExpiring(left, ip); err != nil {
+				glog.Errorf("Failed to add address %s to ipset %s: %s", ip, fqdnSet.name, err)
+			}
+		}
+		if server == "" {
+			continue
+		}
+		for _, fqdn := range fqdnSet.fqdns {
+			if strings.HasPrefix(fqdn, "*.") || len(s.cache.lookup([]string{fqdn}, time.Now())) > 0 {
+				continue
+			}
+			response, err := resolveFQDN(server, fqdn)
+			if err != nil {
+				glog.Errorf("Failed to resolve %s for ipset %s: %s", fqdn, fqdnSet.name, err)
+				continue
+			}
+			s.record(ipsets, response)
+		}
+	}
+}
+
+// resolveFQDN asks the DNS server, an address and port, for the IPv4 addresses of the name
+func resolveFQDN(server, name string) (dnsResponse, error) {
+	conn, err := net.DialTimeout("udp", server, fqdnResolveTimeout)
+	if err != nil {
+		return dnsResponse{}, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(fqdnResolveTimeout)); err != nil {
+		return dnsResponse{}, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	if _, err := conn.Write(dnsQueryMessage(id, name)); err != nil {
+		return dnsResponse{}, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return dnsResponse{}, err
+		}
+		if n < 2 || binary.BigEndian.Uint16(buf[:2]) != id {
+			continue
+		}
+		response, ok := parseDNSResponse(buf[:n])
+		if !ok {
+			return dnsResponse{}, errors.New("no addresses in the response")
+		}
+		return response, nil
+	}
+}
+
+// dnsQueryMessage returns the DNS query, recursion desired, for the A records of the name
+func dnsQueryMessage(id uint16, name string) []byte {
+	msg := []byte{byte(id >> 8), byte(id), 0x01, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0, 0, dnsTypeA, 0, dnsClassINET)
+}
@@ -0,0 +1,46 @@
+package netpol
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFQDNCache(t *testing.T) {
+	snooper := &fqdnSnooper{minTTL: time.Minute, cache: newFQDNCache()}
+	now := time.Now()
+	response, _ := parseDNSResponse(dnsResponseMessage(0))
+	snooper.cache.add(response, snooper.timeout, now)
+	snooper.cache.add(dnsResponse{name: "api.example.com.", addresses: []dnsAddress{{"10.0.0.1", 30}}},
+		snooper.timeout, now)
+
+	expected := map[string]time.Duration{"140.82.121.3": time.Minute, "140.82.121.4": 5 * time.Minute}
+	if found := snooper.cache.lookup([]string{"*.github.com"}, now); !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+	if found := snooper.cache.lookup([]string{"github.com"}, now); len(found) != 0 {
+		t.Errorf("expected no address of github.com, got %v", found)
+	}
+	expected = map[string]time.Duration{"140.82.121.4": 3 * time.Minute}
+	found := snooper.cache.lookup([]string{"*.github.com", "api.example.com"}, now.Add(2*time.Minute))
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected the expired addresses to be left out, got %v", found)
+	}
+	snooper.cache.expire(now.Add(2 * time.Minute))
+	if _, ok := snooper.cache.records["api.example.com"]; ok {
+		t.Error("expected the name without address left to be removed")
+	}
+
+	sets := []fqdnIPSet{{name: "KUBE-FQDN-A", fqdns: []string{"*.github.com"}}}
+	if changed := snooper.setIPSets(sets); !reflect.DeepEqual(changed, sets) {
+		t.Errorf("expected the new ipset, got %v", changed)
+	}
+	sets = append(sets, fqdnIPSet{name: "KUBE-FQDN-B", fqdns: []string{"api.example.com"}})
+	if changed := snooper.setIPSets(sets); !reflect.DeepEqual(changed, sets[1:]) {
+		t.Errorf("expected only the new ipset, got %v", changed)
+	}
+	sets = []fqdnIPSet{sets[0], {name: "KUBE-FQDN-B", fqdns: []string{"api.example.org"}}}
+	if changed := snooper.setIPSets(sets); !reflect.DeepEqual(changed, sets[1:]) {
+		t.Errorf("expected the ipset whose domain names changed, got %v", changed)
+	}
+}
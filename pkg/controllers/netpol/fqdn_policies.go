@@ -0,0 +1,442 @@
+package netpol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+
+	networking "k8s.io/api/networking/v1"
+)
+
+const (
+	// network policies annotated with a comma separated list of domain names allow the egress of the pods they
+	// select to the addresses the cluster DNS resolves the names to, e.g. "*.github.com, api.example.com". A name
+	// starting with "*." matches its subdomains, not the name itself.
+	egressFQDNsAnnotation = "kube-router.io/egress-fqdns"
+
+	// prefix of the hash:net ipsets of the addresses resolved for the domain names of a network policy. They are
+	// filled by the DNS snooper rather than refreshed on sync, so they are told apart from the other policy ipsets.
+	kubeFQDNIpSetPrefix = "KUBE-FQDN-"
+
+	fqdnSnoopComment = "rule to snoop the responses of the cluster DNS for the FQDN network policies"
+)
+
+// With --enable-fqdn-policies, the UDP responses of the cluster DNS are queued to the NFQUEUE queue from the
+// POSTROUTING chain of the mangle table, which they only reach once the filter table accepted them. The snooper adds
+// the IPv4 addresses of the responses to the questions matching the domain names of a policy to its ipset, with the
+// TTL of their records, before accepting the response, so the pod can connect as soon as it gets the addresses. Only
+// the responses of the addresses and ports of the cluster DNS are snooped, so a pod cannot spoof them.
+
+// fqdnIPSet is the ipset of the addresses resolved for the domain names of a network policy
+type fqdnIPSet struct {
+	name  string
+	fqdns []string
+}
+
+// fqdnSnooper adds the addresses the cluster DNS resolves the domain names of the network policies to, to their
+// ipsets
+type fqdnSnooper struct {
+	queue uint16
+	// addresses are kept in the ipsets at least this long, whatever the TTL of their records
+	minTTL time.Duration
+	// addresses of the responses snooped, filling the ipsets of new policies
+	cache *fqdnCache
+
+	mu   sync.Mutex
+	sets []fqdnIPSet
+}
+
+func validateFQDNMinTTL(minTTL time.Duration) error {
+	if minTTL < time.Second {
+		return fmt.Errorf("--fqdn-policy-min-ttl must be at least 1s")
+	}
+	return nil
+}
+
+// validFQDN tells whether the domain name of the annotation is well formed, lowercased and without trailing dot
+func validFQDN(fqdn string) bool {
+	fqdn = strings.TrimPrefix(fqdn, "*.")
+	if fqdn == "" || len(fqdn) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(fqdn, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// egressFQDNs returns the domain names of the egress-fqdns annotation of the network policy, nil when the policy has
+// none
+func egressFQDNs(policy *networking.NetworkPolicy) []string {
+	value, ok := policy.Annotations[egressFQDNsAnnotation]
+	if !ok {
+		return nil
+	}
+	fqdns := make([]string, 0)
+	for _, fqdn := range strings.Split(value, ",") {
+		fqdn = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(fqdn)), ".")
+		if fqdn == "" {
+			continue
+		}
+		if !validFQDN(fqdn) {
+			glog.Errorf("Ignoring annotation %s of network policy %s/%s, %q is not a domain name",
+				egressFQDNsAnnotation, policy.Namespace, policy.Name, fqdn)
+			return nil
+		}
+		fqdns = append(fqdns, fqdn)
+	}
+	if len(fqdns) == 0 {
+		return nil
+	}
+	return fqdns
+}
+
+// fqdnMatches tells whether the name of a DNS question matches the domain name of a policy
+func fqdnMatches(fqdn, name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if strings.HasPrefix(fqdn, "*.") {
+		return len(name) > len(fqdn)-1 && strings.HasSuffix(name, fqdn[1:])
+	}
+	return name == fqdn
+}
+
+func policyFQDNIpSetName(namespace, policyName string) string {
+	return utils.HashedIPSetName(kubeFQDNIpSetPrefix, policyIPSetKey(namespace, policyName, "fqdn"), false)
+}
+
+// fqdnRuleComment returns the comment of the rule of the policy chain accepting the traffic to the domain names
+func fqdnRuleComment(policy networkPolicyInfo) string {
+	return "rule to ACCEPT traffic from source pods to the FQDNs selected by policy name: " + policy.name +
+		" namespace " + policy.namespace
+}
+
+// syncPolicyFQDNRule creates the ipset of the domain names of the policy, whose addresses are added by the snooper
+// and expire on their own, and appends the rule accepting the traffic of the pods of the policy to them
+func (npc *NetworkPolicyController) syncPolicyFQDNRule(filterTable *utils.IPTablesRestore, policy networkPolicyInfo,
+	targetSourcePodIpSetName string, activePolicyIpSets map[string]bool, version string) (*fqdnIPSet, error) {
+	if npc.fqdnSnooper == nil || len(policy.egressFQDNs) == 0 {
+		return nil, nil
+	}
+	name := policyFQDNIpSetName(policy.namespace, policy.name)
+	if _, err := npc.ipSetHandler.Create(name, utils.TypeHashNet, utils.OptionTimeout, "0"); err != nil {
+		return nil, fmt.Errorf("failed to create ipset: %s", err.Error())
+	}
+	activePolicyIpSets[name] = true
+	if err := npc.appendRuleToPolicyChain(filterTable, networkPolicyChainName(policy.namespace, policy.name, version),
+		fqdnRuleComment(policy), targetSourcePodIpSetName, name, "", ""); err != nil {
+		return nil, err
+	}
+	return &fqdnIPSet{name: name, fqdns: policy.egressFQDNs}, nil
+}
+
+// setIPSets replaces the ipsets the snooper adds the addresses to, and returns those that are new or whose domain
+// names changed
+func (s *fqdnSnooper) setIPSets(sets []fqdnIPSet) []fqdnIPSet {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := make(map[string][]string, len(s.sets))
+	for _, set := range s.sets {
+		previous[set.name] = set.fqdns
+	}
+	changed := make([]fqdnIPSet, 0)
+	for _, set := range sets {
+		if fqdns, ok := previous[set.name]; !ok || !reflect.DeepEqual(fqdns, set.fqdns) {
+			changed = append(changed, set)
+		}
+	}
+	s.sets = sets
+	return changed
+}
+
+// snooping tells whether a network policy has domain names, whose addresses are snooped
+func (s *fqdnSnooper) snooping() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sets) > 0
+}
+
+// matchingIPSets returns the ipsets of the policies with a domain name matching the name of the DNS question
+func (s *fqdnSnooper) matchingIPSets(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sets := make([]string, 0)
+	for _, set := range s.sets {
+		for _, fqdn := range set.fqdns {
+			if fqdnMatches(fqdn, name) {
+				sets = append(sets, set.name)
+				break
+			}
+		}
+	}
+	return sets
+}
+
+// timeout returns how long an address of a record with the TTL is kept in the ipsets
+func (s *fqdnSnooper) timeout(ttl uint32) time.Duration {
+	if timeout := time.Duration(ttl) * time.Second; timeout > s.minTTL {
+		return timeout
+	}
+	return s.minTTL
+}
+
+func fqdnSnoopRuleArgs(queue uint16) []string {
+	return []string{"-m", "comment", "--comment", fqdnSnoopComment, "-p", "udp",
+		"-m", "set", "--match-set", clusterDNSIPSetName, "src,src",
+		"-j", "NFQUEUE", "--queue-num", strconv.Itoa(int(queue)), "--queue-bypass"}
+}
+
+func isFQDNSnoopRule(rule utils.IPTablesSaveRule) bool {
+	return rule.Comment == fqdnSnoopComment
+}
+
+// syncFQDNSnoopRule queues the responses of the cluster DNS to the snooper while a network policy has domain names
+// and the cluster DNS has addresses, and removes the rule otherwise, before the ipset of the cluster DNS is removed
+func (npc *NetworkPolicyController) syncFQDNSnoopRule() error {
+	if npc.fqdnSnooper == nil {
+		return nil
+	}
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return fmt.Errorf("Failed to initialize iptables executor: %s", err.Error())
+	}
+	if !npc.fqdnSnooper.snooping() || len(npc.clusterDNS) == 0 {
+		return cleanupFQDNSnoop(iptablesCmdHandler)
+	}
+	args := fqdnSnoopRuleArgs(npc.fqdnSnooper.queue)
+	exists, err := iptablesCmdHandler.Exists("mangle", "POSTROUTING", args...)
+	if err != nil {
+		return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+	}
+	if !exists {
+		err = iptablesCmdHandler.Insert("mangle", "POSTROUTING", 1, args...)
+		if err != nil {
+			return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func cleanupFQDNSnoop(iptablesCmdHandler *iptables.IPTables) error {
+	deleted, err := utils.DeleteIPTablesRules(iptablesCmdHandler, "mangle", "POSTROUTING", isFQDNSnoopRule)
+	if err != nil {
+		return err
+	}
+	if len(deleted) > 0 {
+		glog.V(1).Infof("Removed the rule snooping the responses of the cluster DNS")
+	}
+	return nil
+}
+
+// runFQDNSnooper reads the responses of the cluster DNS from the queue and adds the addresses of the ones to questions
+// matching the domain names of the network policies to their ipsets, until stopCh is closed
+func (npc *NetworkPolicyController) runFQDNSnooper(stopCh <-chan struct{}) {
+	queue, err := utils.NewNFQueue(npc.fqdnSnooper.queue)
+	if err != nil {
+		glog.Errorf("Failed to snoop the responses of the cluster DNS, the FQDN network policies allow no "+
+			"traffic: %s", err)
+		return
+	}
+	defer queue.Close()
+	ipsets, err := utils.NewIPSet(false)
+	if err != nil {
+		glog.Errorf("Failed to snoop the responses of the cluster DNS: %s", err)
+		return
+	}
+	for {
+		packets, err := queue.Read()
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		if err != nil {
+			// typically the socket buffer overflowed, the packets are accepted
+			glog.Errorf("Failed to read the responses of the cluster DNS: %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, packet := range packets {
+			npc.fqdnSnooper.snoop(ipsets, packet.Payload)
+			if err := queue.Accept(packet.ID); err != nil {
+				glog.Errorf("Failed to accept a response of the cluster DNS: %s", err)
+			}
+		}
+	}
+}
+
+// snoop adds the addresses of the DNS response to the ipsets of the policies whose domain names match its question
+func (s *fqdnSnooper) snoop(ipsets *utils.IPSet, packet []byte) {
+	payload, ok := udpPayload(packet)
+	if !ok {
+		return
+	}
+	response, ok := parseDNSResponse(payload)
+	if !ok {
+		return
+	}
+	s.record(ipsets, response)
+}
+
+// record caches the addresses of the DNS response and adds them to the ipsets of the policies whose domain names
+// match its question
+func (s *fqdnSnooper) record(ipsets *utils.IPSet, response dnsResponse) {
+	if len(response.addresses) == 0 {
+		return
+	}
+	if s.cache != nil {
+		s.cache.add(response, s.timeout, time.Now())
+	}
+	for _, name := range s.matchingIPSets(response.name) {
+		set := ipsets.Get(name)
+		if set == nil {
+			var err error
+			if set, err = ipsets.Ensure(name, utils.TypeHashNet, utils.OptionTimeout, "0"); err != nil {
+				glog.Errorf("Failed to add the addresses of %s: %s", response.name, err)
+				continue
+			}
+		}
+		for _, address := range response.addresses {
+			if err := set.AddExpiring(s.timeout(address.ttl), address.ip); err != nil {
+				glog.Errorf("Failed to add address %s of %s to ipset %s: %s", address.ip, response.name, name, err)
+			}
+		}
+		glog.V(3).Infof("Added %d addresses of %s to ipset %s", len(response.addresses), response.name, name)
+	}
+}
+
+// udpPayload returns the payload of the IPv4 UDP packet, which is not a fragment
+func udpPayload(packet []byte) ([]byte, bool) {
+	if len(packet) < 20 || packet[0]>>4 != 4 || packet[9] != 17 {
+		return nil, false
+	}
+	headerLen := int(packet[0]&0x0f) * 4
+	// more fragments flag or fragment offset
+	if headerLen < 20 || binary.BigEndian.Uint16(packet[6:8])&0x3fff != 0 || len(packet) < headerLen+8 {
+		return nil, false
+	}
+	return packet[headerLen+8:], true
+}
+
+// dnsAddress is an IPv4 address of a DNS response, with the TTL of its record
+type dnsAddress struct {
+	ip  string
+	ttl uint32
+}
+
+// dnsResponse is the name of the question of a DNS response and the IPv4 addresses of its answers
+type dnsResponse struct {
+	name      string
+	addresses []dnsAddress
+}
+
+const (
+	dnsTypeA     = 1
+	dnsClassINET = 1
+)
+
+// parseDNSResponse parses the DNS message, false unless it is a successful response to a single question. The
+// addresses are those of all the A records of the answers, those of the names the question is an alias of included.
+func parseDNSResponse(msg []byte) (dnsResponse, bool) {
+	var response dnsResponse
+	if len(msg) < 12 {
+		return response, false
+	}
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	// a response with no error code
+	if flags&0x8000 == 0 || flags&0x000f != 0 {
+		return response, false
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return response, false
+	}
+	answers := int(binary.BigEndian.Uint16(msg[6:8]))
+
+	name, offset, ok := readDNSName(msg, 12)
+	if !ok || offset+4 > len(msg) {
+		return response, false
+	}
+	response.name = name
+	offset += 4
+
+	for i := 0; i < answers; i++ {
+		_, next, ok := readDNSName(msg, offset)
+		if !ok || next+10 > len(msg) {
+			return response, false
+		}
+		recordType := binary.BigEndian.Uint16(msg[next : next+2])
+		class := binary.BigEndian.Uint16(msg[next+2 : next+4])
+		ttl := binary.BigEndian.Uint32(msg[next+4 : next+8])
+		length := int(binary.BigEndian.Uint16(msg[next+8 : next+10]))
+		data := next + 10
+		if data+length > len(msg) {
+			return response, false
+		}
+		if recordType == dnsTypeA && class == dnsClassINET && length == 4 {
+			response.addresses = append(response.addresses,
+				dnsAddress{ip: net.IP(msg[data : data+4]).String(), ttl: ttl})
+		}
+		offset = data + length
+	}
+	return response, true
+}
+
+// readDNSName reads the possibly compressed name at the offset of the DNS message, and returns it with the offset
+// following it
+func readDNSName(msg []byte, offset int) (string, int, bool) {
+	labels := make([]string, 0)
+	end := -1
+	// bounds the pointers followed, a message may loop
+	for jumps := 0; jumps < 32; {
+		if offset >= len(msg) {
+			return "", 0, false
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, true
+		case length&0xc0 == 0xc0:
+			if offset+2 > len(msg) {
+				return "", 0, false
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:offset+2]) & 0x3fff)
+			jumps++
+		case length&0xc0 != 0:
+			return "", 0, false
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, false
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+	return "", 0, false
+}
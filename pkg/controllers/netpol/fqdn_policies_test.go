@@ -0,0 +1,151 @@
+package netpol
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEgressFQDNs(t *testing.T) {
+	testCases := []struct {
+		annotation string
+		fqdns      []string
+	}{
+		{"*.GitHub.com, api.example.com.", []string{"*.github.com", "api.example.com"}},
+		{"api.example.com,,", []string{"api.example.com"}},
+		{" , ", nil},
+		{"api.example.com, https://example.com", nil},
+		{"*example.com", nil},
+		{"-bad.example.com", nil},
+	}
+	for _, tc := range testCases {
+		policy := &netv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ci",
+			Annotations: map[string]string{egressFQDNsAnnotation: tc.annotation}}}
+		if fqdns := egressFQDNs(policy); !reflect.DeepEqual(fqdns, tc.fqdns) {
+			t.Errorf("expected annotation %q to give %v, got %v", tc.annotation, tc.fqdns, fqdns)
+		}
+	}
+	if fqdns := egressFQDNs(&netv1.NetworkPolicy{}); fqdns != nil {
+		t.Errorf("expected no domain name without the annotation, got %v", fqdns)
+	}
+}
+
+func TestFQDNMatches(t *testing.T) {
+	testCases := []struct {
+		fqdn, name string
+		match      bool
+	}{
+		{"api.example.com", "api.example.com.", true},
+		{"api.example.com", "API.Example.com", true},
+		{"api.example.com", "www.api.example.com", false},
+		{"*.github.com", "api.github.com.", true},
+		{"*.github.com", "a.b.github.com", true},
+		{"*.github.com", "github.com", false},
+		{"*.github.com", "notgithub.com", false},
+	}
+	for _, tc := range testCases {
+		if got := fqdnMatches(tc.fqdn, tc.name); got != tc.match {
+			t.Errorf("expected %s matching %s to be %t, got %t", tc.fqdn, tc.name, tc.match, got)
+		}
+	}
+
+	snooper := &fqdnSnooper{minTTL: time.Minute}
+	snooper.setIPSets([]fqdnIPSet{{name: "KUBE-FQDN-A", fqdns: []string{"*.github.com"}},
+		{name: "KUBE-FQDN-B", fqdns: []string{"api.example.com", "api.github.com"}}})
+	if sets := snooper.matchingIPSets("api.github.com"); !reflect.DeepEqual(sets, []string{"KUBE-FQDN-A", "KUBE-FQDN-B"}) {
+		t.Errorf("expected both ipsets to match, got %v", sets)
+	}
+	if timeout := snooper.timeout(30); timeout != time.Minute {
+		t.Errorf("expected the minimum TTL to apply, got %s", timeout)
+	}
+	if timeout := snooper.timeout(300); timeout != 5*time.Minute {
+		t.Errorf("expected the TTL of the record to apply, got %s", timeout)
+	}
+}
+
+// dnsResponseMessage returns a response to the question of www.github.com, an alias of github.com, whose names are
+// compressed
+func dnsResponseMessage(rcode byte) []byte {
+	msg := []byte{0x12, 0x34, 0x81, 0x80 | rcode, 0, 1, 0, 3, 0, 0, 0, 0}
+	// question at offset 12, github.com at offset 16
+	msg = append(msg, 3, 'w', 'w', 'w', 6, 'g', 'i', 't', 'h', 'u', 'b', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1)
+	// CNAME www.github.com to github.com
+	msg = append(msg, 0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 2, 0xc0, 16)
+	// A records of github.com
+	msg = append(msg, 0xc0, 16, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 140, 82, 121, 3)
+	msg = append(msg, 0xc0, 16, 0, 1, 0, 1, 0, 0, 1, 44, 0, 4, 140, 82, 121, 4)
+	return msg
+}
+
+func TestParseDNSResponse(t *testing.T) {
+	response, ok := parseDNSResponse(dnsResponseMessage(0))
+	if !ok {
+		t.Fatal("expected the response to be parsed")
+	}
+	expected := dnsResponse{name: "www.github.com", addresses: []dnsAddress{{"140.82.121.3", 60}, {"140.82.121.4", 300}}}
+	if !reflect.DeepEqual(response, expected) {
+		t.Errorf("expected %+v, got %+v", expected, response)
+	}
+
+	if _, ok := parseDNSResponse(dnsResponseMessage(3)); ok {
+		t.Error("expected a NXDOMAIN response to be ignored")
+	}
+	query := dnsResponseMessage(0)
+	query[2] &^= 0x80
+	if _, ok := parseDNSResponse(query); ok {
+		t.Error("expected a query to be ignored")
+	}
+	truncated := dnsResponseMessage(0)
+	if _, ok := parseDNSResponse(truncated[:len(truncated)-2]); ok {
+		t.Error("expected a truncated response to be rejected")
+	}
+	loop := []byte{0, 0, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12}
+	if _, ok := parseDNSResponse(loop); ok {
+		t.Error("expected a name pointing to itself to be rejected")
+	}
+
+	packet := append([]byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, 17, 0, 0, 10, 96, 0, 10, 10, 1, 1, 1,
+		0, 53, 0x9c, 0x40, 0, 0, 0, 0}, dnsResponseMessage(0)...)
+	if payload, ok := udpPayload(packet); !ok || !reflect.DeepEqual(payload, dnsResponseMessage(0)) {
+		t.Errorf("expected the DNS message of the UDP packet, got %v", payload)
+	}
+	// more fragments
+	packet[6] = 0x20
+	if _, ok := udpPayload(packet); ok {
+		t.Error("expected a fragment to be ignored")
+	}
+}
+
+func TestResolveFQDN(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		name, _, ok := readDNSName(buf[:n], 12)
+		if !ok || name != "www.github.com" || buf[2]&0x01 == 0 {
+			return
+		}
+		response := dnsResponseMessage(0)
+		copy(response[:2], buf[:2])
+		conn.WriteTo(response, addr)
+	}()
+	response, err := resolveFQDN(conn.LocalAddr().String(), "www.github.com")
+	if err != nil {
+		t.Fatalf("failed to resolve: %v", err)
+	}
+	expected := dnsResponse{name: "www.github.com", addresses: []dnsAddress{{"140.82.121.3", 60}, {"140.82.121.4", 300}}}
+	if !reflect.DeepEqual(response, expected) {
+		t.Errorf("expected %+v, got %+v", expected, response)
+	}
+}
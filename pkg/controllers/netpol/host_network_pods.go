@@ -0,0 +1,179 @@
+package netpol
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Host-network pods share the IP of the node, so the traffic to them can not be told apart from the traffic to the
+// node, or to the other host-network pods, by IP. With --enforce-host-network-pods the ingress network policies
+// selecting a host-network pod are enforced on the traffic the node receives on the ports the containers of the pod
+// declare: the INPUT chain jumps the traffic to these ports to the firewall chain of the pod, which runs through the
+// network policies like the firewall chains of the other pods. The host-network pods are left out of the firewall
+// chains matching the pods by IP. The egress of host-network pods is not enforced, as their connections can not be
+// told apart from those of the node.
+
+// hostNetworkPod is a host-network pod of the node selected by ingress network policies, and the ports it declares
+type hostNetworkPod struct {
+	podInfo
+	ports    []protocolAndPort
+	policies []networkPolicyInfo
+}
+
+// skipsPod tells whether the pod is left out of the firewall chains matching the pods by IP
+func (npc *NetworkPolicyController) skipsPod(pod *api.Pod) bool {
+	return npc.enforceHostNetworkPods && pod.Spec.HostNetwork
+}
+
+// hostNetworkPodPorts returns the ports the containers of the pod declare, sorted
+func hostNetworkPodPorts(pod *api.Pod) []protocolAndPort {
+	seen := make(map[protocolAndPort]bool)
+	ports := make([]protocolAndPort, 0)
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			protocol := string(port.Protocol)
+			if protocol == "" {
+				protocol = string(api.ProtocolTCP)
+			}
+			p := protocolAndPort{protocol: strings.ToLower(protocol), port: strconv.Itoa(int(port.ContainerPort))}
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].protocol != ports[j].protocol {
+			return ports[i].protocol < ports[j].protocol
+		}
+		return ports[i].port < ports[j].port
+	})
+	return ports
+}
+
+// getHostNetworkPolicyEnabledPods returns the host-network pods of the node selected by ingress network policies,
+// sorted by namespace and name. The pods declaring no port are left out, the traffic to them can not be matched.
+func (npc *NetworkPolicyController) getHostNetworkPolicyEnabledPods(nodeIP string) []hostNetworkPod {
+	pods := make([]hostNetworkPod, 0)
+	if !npc.enforceHostNetworkPods {
+		return pods
+	}
+	for _, obj := range npc.podLister.List() {
+		pod := obj.(*api.Pod)
+		if !pod.Spec.HostNetwork || pod.Status.HostIP != nodeIP || pod.Status.PodIP == "" || exemptsPod(pod) ||
+			npc.excludesNamespace(pod.Namespace) {
+			continue
+		}
+		policies := npc.hostNetworkPodPolicies(pod)
+		if len(policies) == 0 {
+			continue
+		}
+		ports := hostNetworkPodPorts(pod)
+		if len(ports) == 0 {
+			glog.V(2).Infof("Not enforcing the network policies of host-network pod %s/%s, it declares no port",
+				pod.Namespace, pod.Name)
+			continue
+		}
+		info := podInfo{ip: pod.Status.PodIP, name: pod.Name, namespace: pod.Namespace, labels: pod.Labels,
+			priorityClass: pod.Spec.PriorityClassName}
+		pods = append(pods, hostNetworkPod{
+			podInfo:  info,
+			ports:    ports,
+			policies: policies,
+		})
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].namespace != pods[j].namespace {
+			return pods[i].namespace < pods[j].namespace
+		}
+		return pods[i].name < pods[j].name
+	})
+	return pods
+}
+
+// hostNetworkPodPolicies returns the ingress network policies selecting the host-network pod. The policies select
+// the pods by labels, as all the host-network pods of the node share its IP.
+func (npc *NetworkPolicyController) hostNetworkPodPolicies(pod *api.Pod) []networkPolicyInfo {
+	policies := make([]networkPolicyInfo, 0)
+	if npc.networkPoliciesInfo == nil {
+		return policies
+	}
+	for _, policy := range *npc.networkPoliciesInfo {
+		if policy.namespace != pod.Namespace || !policy.isolatesIngress() || policy.podSelector == nil {
+			continue
+		}
+		if policy.podSelector.Matches(labels.Set(pod.Labels)) {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// syncHostNetworkPodFirewallChains adds the firewall chains of the host-network pods of the node to filterTable, and
+// returns the rules jumping to them
+func (npc *NetworkPolicyController) syncHostNetworkPodFirewallChains(filterTable *utils.IPTablesRestore, version string,
+	activePodFwChains map[string]bool) []podFwJump {
+	jumps := make([]podFwJump, 0)
+	for _, pod := range npc.getHostNetworkPolicyEnabledPods(npc.nodeIP.String()) {
+		podFwChainName := podFirewallChainName(pod.namespace, pod.name, version)
+		filterTable.NewChain(podFwChainName)
+		activePodFwChains[podFwChainName] = true
+		npc.auditChain(podFwChainName, "pod "+pod.namespace+"/"+pod.name)
+		if npc.MetricsEnabled {
+			npc.podFwChainOwners[podFwChainName] = chainOwner{namespace: pod.namespace, name: pod.name}
+		}
+
+		// the policies run through the traffic to the IP of the node, their target pods ipsets hold it
+		audit := npc.podAudit(pod.ip)
+		for _, policy := range pod.policies {
+			if !audit.runsThrough(policy) {
+				continue
+			}
+			comment := "run through nw policy " + policy.name
+			policyChainName := networkPolicyChainName(policy.namespace, policy.name, version)
+			filterTable.InsertUnique(podFwChainName, "-m", "comment", "--comment", comment, "-j", policyChainName)
+		}
+
+		comment := "rule to permit the traffic to host network pods when source is the pod's local node"
+		args := append([]string{"-m", "comment", "--comment", comment}, npc.localSourceArgs()...)
+		args = append(args, "-j", "ACCEPT")
+		filterTable.InsertUnique(podFwChainName, args...)
+
+		// permit the traffic of the cluster allow lists whatever the network policies
+		if args = npc.clusterAllowListRuleArgs(pod.ip); args != nil {
+			filterTable.InsertUnique(podFwChainName, args...)
+		}
+
+		// run through the cluster network policies ahead of the network policies and the cluster allow lists
+		if args = npc.clusterNetworkPolicyJumpArgs(version); args != nil {
+			filterTable.InsertUnique(podFwChainName, args...)
+		}
+
+		// permit the critical flows ahead of all the policies
+		for _, args := range npc.criticalFlowRuleArgs("ingress", pod.podInfo) {
+			filterTable.InsertUnique(podFwChainName, args...)
+		}
+
+		// ensure statefull firewall, that permits return traffic for the traffic originated by the pod
+		npc.insertStatefulRules(filterTable, podFwChainName)
+
+		// the INPUT chain jumps the traffic the node receives on the ports of the pod to its firewall chain
+		for _, port := range pod.ports {
+			comment = "rule to jump traffic destined to host network POD name:" + pod.name + " namespace: " +
+				pod.namespace + " port: " + port.protocol + "/" + port.port + " to chain " + podFwChainName
+			args = []string{"-m", "comment", "--comment", comment, "-p", port.protocol, "--dport", port.port,
+				"-j", podFwChainName}
+			jumps = append(jumps, newPodFwJump("INPUT", args))
+		}
+
+		npc.appendPodFwDropRules(filterTable, pod.podInfo, podFwChainName)
+	}
+	return jumps
+}
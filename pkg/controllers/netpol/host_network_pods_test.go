@@ -0,0 +1,88 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncHostNetworkPodFirewallChains(t *testing.T) {
+	krNetPol, cluster := newTestController(t, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+
+	hostPod := func(name string, ports ...v1.ContainerPort) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "nsA", Labels: map[string]string{"app": "agent"}},
+			Spec:   v1.PodSpec{HostNetwork: true, Containers: []v1.Container{{Name: name, Ports: ports}}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "10.10.10.10"}}
+	}
+	tAddToInformerStore(t, cluster.podInformer, hostPod("agent",
+		v1.ContainerPort{ContainerPort: 9100},
+		v1.ContainerPort{ContainerPort: 53, Protocol: v1.ProtocolUDP}))
+	tAddToInformerStore(t, cluster.podInformer, hostPod("portless"))
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "agent"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+	netpol := tNetpol{
+		name:        "deny-all",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "agent"}},
+		ingress:     []netv1.NetworkPolicyIngressRule{},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hostFwChain := podFirewallChainName("nsA", "agent", "1")
+	portlessFwChain := podFirewallChainName("nsA", "portless", "1")
+	webFwChain := podFirewallChainName("nsA", "web", "1")
+
+	// without the option the host-network pods are matched by the IP of the node they share
+	filterTable := utils.NewIPTablesRestore("filter")
+	if _, _, err := krNetPol.syncPodFirewallChains(filterTable, "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(filterTable.Bytes()), "host network POD") {
+		t.Errorf("expected no host-network pod firewall without --enforce-host-network-pods:\n%s", filterTable.Bytes())
+	}
+
+	krNetPol.enforceHostNetworkPods = true
+	filterTable = utils.NewIPTablesRestore("filter")
+	chains, jumps, err := krNetPol.syncPodFirewallChains(filterTable, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chains) != 2 || !chains[hostFwChain] || !chains[webFwChain] || chains[portlessFwChain] {
+		t.Errorf("expected the firewall chains of the web pod and of the host-network pod declaring ports, got %v",
+			chains)
+	}
+	input := string(filterTable.Bytes())
+	for _, rule := range []string{
+		"-A " + hostFwChain + " -m comment --comment \"run through nw policy deny-all\" -j " +
+			networkPolicyChainName("nsA", "deny-all", "1") + "\n",
+		"-A " + hostFwChain + " -m comment --comment \"rule to permit the traffic to host network pods when source is the pod's local node\"",
+		"-A " + hostFwChain + " -m comment --comment \"default rule to REJECT traffic destined for POD name:agent namespace: nsA\" -j REJECT\n",
+		"-A KUBE-ROUTER-INPUT -m comment --comment \"rule to jump traffic destined to host network POD name:agent namespace: nsA port: tcp/9100 to chain " +
+			hostFwChain + "\" -p tcp --dport 9100 -j " + hostFwChain + "\n",
+		"-A KUBE-ROUTER-INPUT -m comment --comment \"rule to jump traffic destined to host network POD name:agent namespace: nsA port: udp/53 to chain " +
+			hostFwChain + "\" -p udp --dport 53 -j " + hostFwChain + "\n",
+	} {
+		if strings.Count(input, rule) != 1 {
+			t.Errorf("expected %q once in the iptables-restore input:\n%s", rule, input)
+		}
+	}
+	// the host-network pods are not matched by the IP of the node
+	if strings.Contains(input, "-d 10.10.10.10 -j") {
+		t.Errorf("expected no jump matching the IP of the node:\n%s", input)
+	}
+	// FORWARD and OUTPUT, and FORWARD for the bridged traffic, for the web pod, and a jump per port of the agent
+	if len(jumps) != 5 {
+		t.Errorf("expected 5 jumps to the pod firewall chains, got %d", len(jumps))
+	}
+}
@@ -0,0 +1,265 @@
+package netpol
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+)
+
+const (
+	incrementalSyncApplied  = "applied"
+	incrementalSyncFullSync = "full_sync"
+)
+
+// errRulesChanged is returned by syncIPSets when the rules of the chains changed, which only a full sync programs
+var errRulesChanged = errors.New("the rules of the network policy and pod firewall chains changed")
+
+// rulesDigest returns a digest of everything the rules of the network policy and pod firewall chains are rendered
+// from, all but the entries of the ipsets they match. A pod event that leaves it unchanged only changes the entries
+// of ipsets, which syncIPSets refreshes without touching the chains.
+func (npc *NetworkPolicyController) rulesDigest() (string, error) {
+	h := sha256.New()
+	for _, policy := range *npc.networkPoliciesInfo {
+		fmt.Fprintf(h, "policy %s/%s %s %t %v\n", policy.namespace, policy.name, policy.policyType, policy.audit,
+			policy.egressFQDNs)
+		for i, rule := range policy.ingressRules {
+			fmt.Fprintf(h, "ingress %d %t %t %v %v\n", i, rule.matchAllPorts, rule.matchAllSource, rule.ports,
+				rule.srcIPBlocks)
+			writeNamedPortsDigest(h, rule.namedPorts)
+			if npc.keepPeerPodIPSet(rule.srcPods, rule.namespaceSelectorPeers) {
+				name, _, _ := npc.peerPodIPSet(policyIndexedSourcePodIpSetName(policy.namespace, policy.name, i),
+					policyIndexedSourcePodNetIpSetName(policy.namespace, policy.name, i), rule.srcPods)
+				fmt.Fprintf(h, "peers %s\n", name)
+			}
+		}
+		for i, rule := range policy.egressRules {
+			fmt.Fprintf(h, "egress %d %t %t %v %v\n", i, rule.matchAllPorts, rule.matchAllDestinations, rule.ports,
+				rule.dstIPBlocks)
+			writeNamedPortsDigest(h, rule.namedPorts)
+			if npc.keepPeerPodIPSet(rule.dstPods, rule.namespaceSelectorPeers) {
+				name, _, _ := npc.peerPodIPSet(policyIndexedDestinationPodIpSetName(policy.namespace, policy.name, i),
+					policyIndexedDestinationPodNetIpSetName(policy.namespace, policy.name, i), rule.dstPods)
+				fmt.Fprintf(h, "peers %s\n", name)
+			}
+		}
+	}
+
+	// the pod firewall chains, and the policies each one runs through
+	var podIfaces map[string]string
+	if npc.podInterfaceRules {
+		podIfaces = npc.podInterfaces()
+	}
+	ingressPods, err := npc.getIngressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		return "", err
+	}
+	egressPods, err := npc.getEgressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		return "", err
+	}
+	for i, pods := range []map[string]podInfo{*ingressPods, *egressPods} {
+		fmt.Fprintf(h, "pod firewalls %d\n", i)
+		ips := make([]string, 0, len(pods))
+		for ip := range pods {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		for _, ip := range ips {
+			pod := pods[ip].withInterface(podIfaces)
+			fmt.Fprintf(h, "pod %s/%s %s %s %s\n", pod.namespace, pod.name, pod.ip, pod.iface,
+				npc.namespaceDefaultVerdict(pod.namespace))
+			for _, policy := range *npc.networkPoliciesInfo {
+				if _, ok := policy.targetPods[ip]; ok {
+					fmt.Fprintf(h, "runs through %s/%s\n", policy.namespace, policy.name)
+				}
+			}
+		}
+	}
+
+	for _, pod := range npc.getHostNetworkPolicyEnabledPods(npc.nodeIP.String()) {
+		fmt.Fprintf(h, "host network pod %s/%s %s %s\n", pod.namespace, pod.name,
+			npc.namespaceDefaultVerdict(pod.namespace), pod.ports)
+		for _, policy := range pod.policies {
+			fmt.Fprintf(h, "runs through %s/%s\n", policy.namespace, policy.name)
+		}
+	}
+
+	if npc.acceptedFlowLogGroup != 0 {
+		for _, pod := range npc.getAcceptedFlowLogPods() {
+			fmt.Fprintf(h, "audited %s/%s %s\n", pod.namespace, pod.name, pod.ip)
+		}
+	}
+	if npc.sample != nil {
+		fmt.Fprintf(h, "sampled %s/%s\n", npc.sample.namespace, npc.sample.name)
+	}
+	// the entries of the cluster allow lists are in their ipset, only whether there are any changes the rules
+	fmt.Fprintf(h, "cluster allow lists %t\n", len(npc.clusterAllowList) != 0)
+	fmt.Fprintf(h, "cluster DNS %t\n", len(npc.clusterDNS) != 0)
+	// like the cluster allow lists, only the ipsets of the critical flows change the rules
+	names, _ := npc.criticalFlowIPSetEntries()
+	for _, name := range names {
+		fmt.Fprintf(h, "critical flows %s\n", name)
+	}
+	npc.writeClusterNetworkPoliciesDigest(h)
+	return base32.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeNamedPortsDigest writes the ports of the named ports, their endpoints are ipset entries
+func writeNamedPortsDigest(h hash.Hash, namedPorts []endPoints) {
+	for _, endPoints := range namedPorts {
+		fmt.Fprintf(h, "named port %s %s\n", endPoints.protocol, endPoints.port)
+	}
+}
+
+// syncIPSets refreshes the ipsets whose entries changed since the last sync, and returns errRulesChanged without
+// changing the node when the rules of the chains changed. It only runs with the iptables backend, after a full sync
+// succeeded.
+func (npc *NetworkPolicyController) syncIPSets() (err error) {
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+
+	if npc.syncedRulesDigest == "" {
+		return errRulesChanged
+	}
+	start := time.Now()
+	defer func() {
+		if npc.MetricsEnabled {
+			result := incrementalSyncApplied
+			if err == errRulesChanged {
+				result = incrementalSyncFullSync
+			}
+			metrics.ControllerPolicyIncrementalSyncs.WithLabelValues(result).Inc()
+		}
+		glog.V(1).Infof("incremental sync of ipsets took %v", time.Since(start))
+	}()
+
+	localPods := npc.policyReadiness.localPods(npc.podLister, npc.nodeIP.String())
+	policies := npc.networkPoliciesInfo
+	if npc.v1NetworkPolicy {
+		npc.networkPoliciesInfo, err = npc.buildNetworkPoliciesInfo()
+	} else {
+		npc.networkPoliciesInfo, err = npc.buildBetaNetworkPoliciesInfo()
+	}
+	if err != nil {
+		npc.networkPoliciesInfo = policies
+		return errors.New("Aborting sync. Failed to build network policies: " + err.Error())
+	}
+	if err := npc.checkEmptyCaches(); err != nil {
+		npc.networkPoliciesInfo = policies
+		return err
+	}
+	npc.enforcePolicyLimits()
+	npc.resolveClusterDNS()
+	if err := npc.buildClusterNetworkPolicies(); err != nil {
+		npc.networkPoliciesInfo = policies
+		return errors.New("Aborting sync. " + err.Error())
+	}
+
+	digest, err := npc.rulesDigest()
+	if err != nil {
+		npc.networkPoliciesInfo = policies
+		return err
+	}
+	// new ipset names, whose collisions are checked by the full sync, need a full sync
+	sets := npc.desiredIPSets()
+	if digest != npc.syncedRulesDigest || len(sets) != len(npc.syncedIPSets) {
+		npc.networkPoliciesInfo = policies
+		return errRulesChanged
+	}
+	for _, set := range sets {
+		if _, ok := npc.syncedIPSets[set.name]; !ok {
+			npc.networkPoliciesInfo = policies
+			return errRulesChanged
+		}
+	}
+
+	if npc.flushRevokedConntrack {
+		defer npc.flushRevokedConnections(npc.policyIPSetEntries())
+	}
+	if npc.Audit != nil {
+		defer npc.auditIPSets(npc.policyIPSetEntries())
+	}
+	refreshed := 0
+	for _, set := range sets {
+		if reflect.DeepEqual(set.entries, npc.syncedIPSets[set.name]) {
+			continue
+		}
+		ipset, err := npc.ipSetHandler.Create(set.name, set.setType, utils.OptionTimeout, "0")
+		if err != nil {
+			return fmt.Errorf("failed to create ipset: %s", err.Error())
+		}
+		if set.ipBlocks {
+			err = ipset.RefreshWithBuiltinOptions(set.entries)
+		} else {
+			ips := make([]string, 0, len(set.entries))
+			for _, entry := range set.entries {
+				ips = append(ips, entry[0])
+			}
+			err = ipset.Refresh(ips, utils.OptionTimeout, "0")
+		}
+		if err != nil {
+			// the set is no longer recorded, so the next sync is a full one
+			delete(npc.syncedIPSets, set.name)
+			return fmt.Errorf("failed to refresh ipset %s: %s", set.name, err.Error())
+		}
+		npc.syncedIPSets[set.name] = set.entries
+		refreshed++
+	}
+	glog.V(1).Infof("Refreshed %d of the %d ipsets of the network policies", refreshed, len(sets))
+
+	appliedState, err := npc.renderState()
+	if err == nil {
+		npc.recordRenderedState(appliedState)
+		err = npc.appliedStateCache.Save(appliedState)
+	}
+	if err != nil {
+		glog.Errorf("Failed to persist the applied state: %s", err)
+	}
+	// the rules are recorded by full syncs without failed policies only, so they are all enforced
+	npc.policyReadiness.synced(localPods)
+	npc.dropEvents.recordSync(npc.networkPoliciesInfo)
+	return nil
+}
+
+// recordSyncedRules records the rules and ipsets programmed by a successful full sync, for the following pod events
+// to only refresh the ipsets
+func (npc *NetworkPolicyController) recordSyncedRules() {
+	if !npc.incrementalSync || npc.policyBackend != policyBackendIPTables {
+		return
+	}
+	digest, err := npc.rulesDigest()
+	if err != nil {
+		glog.Errorf("Failed to record the synced network policy rules, pod events trigger full syncs: %s", err)
+		npc.syncedRulesDigest = ""
+		return
+	}
+	npc.syncedRulesDigest = digest
+	npc.syncedIPSets = make(map[string][][]string)
+	for _, set := range npc.desiredIPSets() {
+		npc.syncedIPSets[set.name] = set.entries
+	}
+}
+
+// syncPodEvent programs the changes following a pod event, only refreshing the ipsets when it left the rules of the
+// chains unchanged and falling back to a full sync otherwise
+func (npc *NetworkPolicyController) syncPodEvent() error {
+	if npc.incrementalSync && npc.policyBackend == policyBackendIPTables {
+		err := npc.syncIPSets()
+		if err == nil {
+			return nil
+		}
+		if err != errRulesChanged {
+			glog.Errorf("Failed to refresh the ipsets of the network policies, falling back to a full sync: %s", err)
+		}
+	}
+	return npc.Sync()
+}
@@ -0,0 +1,69 @@
+package netpol
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRulesDigest(t *testing.T) {
+	krNetPol, cluster := newTestController(t,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+
+	clientPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "nsA", Labels: map[string]string{"app": "client"}},
+		Status: v1.PodStatus{HostIP: "10.10.10.11", PodIP: "2.2.2.2"}}
+	tAddToInformerStore(t, cluster.podInformer, clientPod)
+	netpol := tNetpol{
+		name:        "allow-client",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress: []netv1.NetworkPolicyIngressRule{{From: []netv1.NetworkPolicyPeer{{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}}}}},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	render := func() (string, []desiredIPSet) {
+		var err error
+		krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		digest, err := krNetPol.rulesDigest()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return digest, krNetPol.desiredIPSets()
+	}
+	digest, sets := render()
+	sourceSet := policyIndexedSourcePodIpSetName("nsA", "allow-client", 0)
+
+	// a peer changing its IP only changes the entries of the ipset of the peers
+	clientPod = clientPod.DeepCopy()
+	clientPod.Status.PodIP = "2.2.2.3"
+	if err := cluster.podInformer.GetStore().Update(clientPod); err != nil {
+		t.Fatalf("error updating object in Informer Store: %v", err)
+	}
+	peerDigest, peerSets := render()
+	if peerDigest != digest {
+		t.Errorf("expected the rules digest to be unchanged when a peer changes its IP")
+	}
+	for i, set := range peerSets {
+		changed := !reflect.DeepEqual(set.entries, sets[i].entries)
+		if changed != (set.name == sourceSet) {
+			t.Errorf("expected only the entries of %s to change, %s changed: %t", sourceSet, set.name, changed)
+		}
+	}
+
+	// a new pod of the node selected by the policy needs its pod firewall chain
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web2", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.2"}})
+	if localDigest, _ := render(); localDigest == digest {
+		t.Errorf("expected the rules digest to change when a pod of the node gets a pod firewall chain")
+	}
+}
@@ -0,0 +1,138 @@
+package netpol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// interval the ipset manifest is checked for changes at, overridden by the tests
+var ipSetExportPeriod = 30 * time.Second
+
+// IPSetManifestEntry describes an ipset the network policy controller maintains, for external consumers to reference
+// in their own rules
+type IPSetManifestEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Policy is the name of the network policy the ipset was generated for
+	Policy string `json:"policy"`
+	// Description is the role of the ipset in the policy, e.g. "source pods of ingress rule 0 of policy web/frontend"
+	Description string `json:"description"`
+}
+
+// ipSetManifest groups by namespace the active ipsets out of the ipsets of the network policies
+func ipSetManifest(sets []policyIPSet, active map[string]bool) map[string][]IPSetManifestEntry {
+	manifest := make(map[string][]IPSetManifestEntry)
+	for _, set := range sets {
+		if !active[set.name] {
+			continue
+		}
+		manifest[set.namespace] = append(manifest[set.namespace], IPSetManifestEntry{
+			Name:        set.name,
+			Type:        set.setType,
+			Policy:      set.policy,
+			Description: set.description,
+		})
+	}
+	for _, entries := range manifest {
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].Policy != entries[j].Policy {
+				return entries[i].Policy < entries[j].Policy
+			}
+			return entries[i].Description < entries[j].Description
+		})
+	}
+	return manifest
+}
+
+// IPSetManifest returns by namespace the ipsets of the network policies programmed by the last successful sync, nil
+// until a sync succeeded or when the manifest is not exported
+func (npc *NetworkPolicyController) IPSetManifest() map[string][]IPSetManifestEntry {
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	return npc.ipSetManifest
+}
+
+// IPSetExporter writes the ipset manifest of the network policy controller to a ConfigMap, one key per namespace
+// holding the JSON list of the ipsets of its network policies. The ipset names only depend on the network policies,
+// so the manifest applies to all the nodes and is written by the leader only.
+type IPSetExporter struct {
+	clientset kubernetes.Interface
+	npc       *NetworkPolicyController
+	namespace string
+	name      string
+}
+
+// NewIPSetExporter returns an exporter of the ipset manifest to the ConfigMap given as namespace/name
+func NewIPSetExporter(clientset kubernetes.Interface, npc *NetworkPolicyController, configMap string) (*IPSetExporter, error) {
+	parts := strings.Split(configMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid ipset manifest ConfigMap %q, expected namespace/name", configMap)
+	}
+	return &IPSetExporter{clientset: clientset, npc: npc, namespace: parts[0], name: parts[1]}, nil
+}
+
+// Run writes the manifest whenever it changed until stopCh is closed
+func (e *IPSetExporter) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(ipSetExportPeriod)
+	defer ticker.Stop()
+	var written map[string]string
+	for {
+		if manifest := e.npc.IPSetManifest(); manifest != nil {
+			data, err := ipSetManifestData(manifest)
+			if err != nil {
+				glog.Errorf("Failed to encode the ipset manifest: %s", err)
+			} else if !reflect.DeepEqual(data, written) {
+				if err := e.write(data); err != nil {
+					glog.Errorf("Failed to write the ipset manifest to ConfigMap %s/%s: %s", e.namespace, e.name, err)
+				} else {
+					written = data
+				}
+			}
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func ipSetManifestData(manifest map[string][]IPSetManifestEntry) (map[string]string, error) {
+	data := make(map[string]string, len(manifest))
+	for namespace, entries := range manifest {
+		encoded, err := json.Marshal(entries)
+		if err != nil {
+			return nil, err
+		}
+		data[namespace] = string(encoded)
+	}
+	return data, nil
+}
+
+func (e *IPSetExporter) write(data map[string]string) error {
+	cms := e.clientset.CoreV1().ConfigMaps(e.namespace)
+	cm, err := cms.Get(context.Background(), e.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = cms.Create(context.Background(), &api.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: e.namespace, Name: e.name}, Data: data}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm = cm.DeepCopy()
+	cm.Data = data
+	_, err = cms.Update(context.Background(), cm, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,61 @@
+package netpol
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIPSetManifest(t *testing.T) {
+	policies := []networkPolicyInfo{
+		{namespace: "web", name: "frontend", ingressRules: []ingressRule{{}}},
+		{namespace: "db", name: "postgres"},
+	}
+	active := map[string]bool{
+		policyDestinationPodIpSetName("web", "frontend"):      true,
+		policyIndexedSourcePodIpSetName("web", "frontend", 0): true,
+		policySourcePodIpSetName("db", "postgres"):            true,
+	}
+	manifest := ipSetManifest(policyIPSets(policies), active)
+	if len(manifest) != 2 || len(manifest["web"]) != 2 || len(manifest["db"]) != 1 {
+		t.Fatalf("expected the active ipsets grouped by namespace, got %+v", manifest)
+	}
+	entry := manifest["web"][0]
+	if entry.Name != policyDestinationPodIpSetName("web", "frontend") || entry.Type != utils.TypeHashIP ||
+		entry.Policy != "frontend" || entry.Description != "destination pods of policy web/frontend" {
+		t.Errorf("unexpected manifest entry %+v", entry)
+	}
+
+	client := fake.NewSimpleClientset()
+	exporter, err := NewIPSetExporter(client, nil, "kube-system/kube-router-ipsets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ipSetManifestData(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := exporter.write(data); err != nil {
+			t.Fatalf("failed to write the manifest: %s", err)
+		}
+	}
+	cm, err := client.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "kube-router-ipsets", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []IPSetManifestEntry
+	if err := json.Unmarshal([]byte(cm.Data["db"]), &entries); err != nil || len(entries) != 1 ||
+		entries[0].Description != "source pods of policy db/postgres" {
+		t.Errorf("unexpected manifest of namespace db %q", cm.Data["db"])
+	}
+
+	if _, err := NewIPSetExporter(client, nil, "kube-router-ipsets"); err == nil {
+		t.Error("expected a ConfigMap without namespace to be rejected")
+	}
+}
@@ -0,0 +1,110 @@
+package netpol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// policyIPSetKey returns the key hashed into the name of an ipset of a network policy. The namespace and name of the
+// policy cannot contain a slash, which separates them from each other and from the rule and port indexes, so the keys
+// of distinct policies or rules differ.
+func policyIPSetKey(namespace, policyName string, parts ...string) string {
+	return strings.Join(append([]string{namespace, policyName}, parts...), "/")
+}
+
+// policyIPSet is an ipset generated for a network policy
+type policyIPSet struct {
+	name      string
+	setType   string
+	namespace string
+	policy    string
+	// role of the ipset in the policy, e.g. "source pods of ingress rule 0 of policy web/frontend"
+	description string
+}
+
+// policyIPSets returns the ipsets that may be generated for the network policies, whether they are needed or not
+func policyIPSets(policies []networkPolicyInfo) []policyIPSet {
+	sets := make([]policyIPSet, 0)
+	add := func(policy networkPolicyInfo, name, setType, format string, args ...interface{}) {
+		sets = append(sets, policyIPSet{name: name, setType: setType, namespace: policy.namespace,
+			policy: policy.name, description: fmt.Sprintf(format, args...)})
+	}
+
+	for _, policy := range policies {
+		add(policy, policySourcePodIpSetName(policy.namespace, policy.name), utils.TypeHashIP,
+			"source pods of policy %s/%s", policy.namespace, policy.name)
+		add(policy, policyDestinationPodIpSetName(policy.namespace, policy.name), utils.TypeHashIP,
+			"destination pods of policy %s/%s", policy.namespace, policy.name)
+		for i, ingressRule := range policy.ingressRules {
+			add(policy, policyIndexedSourcePodIpSetName(policy.namespace, policy.name, i), utils.TypeHashIP,
+				"source pods of ingress rule %d of policy %s/%s", i, policy.namespace, policy.name)
+			add(policy, policyIndexedSourcePodNetIpSetName(policy.namespace, policy.name, i), utils.TypeHashNet,
+				"aggregated source pods of ingress rule %d of policy %s/%s", i, policy.namespace, policy.name)
+			add(policy, policyIndexedSourceIpBlockIpSetName(policy.namespace, policy.name, i), utils.TypeHashNet,
+				"source ip blocks of ingress rule %d of policy %s/%s", i, policy.namespace, policy.name)
+			for j := range ingressRule.namedPorts {
+				add(policy, policyIndexedIngressNamedPortIpSetName(policy.namespace, policy.name, i, j), utils.TypeHashIP,
+					"named port %d of ingress rule %d of policy %s/%s", j, i, policy.namespace, policy.name)
+			}
+		}
+		for i, egressRule := range policy.egressRules {
+			add(policy, policyIndexedDestinationPodIpSetName(policy.namespace, policy.name, i), utils.TypeHashIP,
+				"destination pods of egress rule %d of policy %s/%s", i, policy.namespace, policy.name)
+			add(policy, policyIndexedDestinationPodNetIpSetName(policy.namespace, policy.name, i), utils.TypeHashNet,
+				"aggregated destination pods of egress rule %d of policy %s/%s", i, policy.namespace, policy.name)
+			add(policy, policyIndexedDestinationIpBlockIpSetName(policy.namespace, policy.name, i), utils.TypeHashNet,
+				"destination ip blocks of egress rule %d of policy %s/%s", i, policy.namespace, policy.name)
+			for j := range egressRule.namedPorts {
+				add(policy, policyIndexedEgressNamedPortIpSetName(policy.namespace, policy.name, i, j), utils.TypeHashIP,
+					"named port %d of egress rule %d of policy %s/%s", j, i, policy.namespace, policy.name)
+			}
+		}
+		if len(policy.egressFQDNs) != 0 {
+			add(policy, policyFQDNIpSetName(policy.namespace, policy.name), utils.TypeHashNet,
+				"FQDNs of policy %s/%s", policy.namespace, policy.name)
+		}
+	}
+	return sets
+}
+
+// ipSetNameCollisions returns the errors of the network policies, by namespace/name, an ipset name generated for
+// collides with an ipset name of another policy or rule, as the policies would otherwise silently share their ipsets.
+// Of the policies whose names collide, the first in the order of their namespace/name keeps its ipsets.
+func ipSetNameCollisions(policies []networkPolicyInfo) map[string]error {
+	sorted := append([]networkPolicyInfo(nil), policies...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].namespace != sorted[j].namespace {
+			return sorted[i].namespace < sorted[j].namespace
+		}
+		return sorted[i].name < sorted[j].name
+	})
+
+	collisions := make(map[string]error)
+	names := make(utils.IPSetNames)
+	for _, policy := range sorted {
+		// the names of a colliding policy are not registered, they would only collide with the next policies
+		owned := make(utils.IPSetNames)
+		var err error
+		for _, set := range policyIPSets([]networkPolicyInfo{policy}) {
+			if owner, ok := names[set.name]; ok {
+				err = &utils.IPSetNameCollisionError{Name: set.name, Owner: set.description, ExistingOwner: owner}
+			} else {
+				err = owned.Register(set.name, set.description)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			collisions[policy.namespace+"/"+policy.name] = err
+			continue
+		}
+		for name, owner := range owned {
+			names[name] = owner
+		}
+	}
+	return collisions
+}
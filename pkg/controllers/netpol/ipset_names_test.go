@@ -0,0 +1,25 @@
+package netpol
+
+import (
+	"testing"
+)
+
+func TestIPSetNameCollisions(t *testing.T) {
+	// the namespace, the name and the indexes of the rules and named ports are separated before hashing
+	rules := make([]ingressRule, 12)
+	rules[1].namedPorts = make([]endPoints, 12)
+	rules[11].namedPorts = make([]endPoints, 2)
+	policies := []networkPolicyInfo{
+		{name: "web", namespace: "team", ingressRules: []ingressRule{{}}, egressRules: []egressRule{{}}},
+		{name: "web", namespace: "other", ingressRules: []ingressRule{{}}},
+		{name: "mweb", namespace: "tea", ingressRules: []ingressRule{{}}, egressRules: []egressRule{{}}},
+		{name: "api", namespace: "team", ingressRules: rules},
+	}
+	if collisions := ipSetNameCollisions(policies); len(collisions) != 0 {
+		t.Errorf("unexpected collisions: %v", collisions)
+	}
+	if policyIndexedIngressNamedPortIpSetName("team", "api", 1, 11) ==
+		policyIndexedIngressNamedPortIpSetName("team", "api", 11, 1) {
+		t.Errorf("expected the ipsets of named port 11 of rule 1 and named port 1 of rule 11 to differ")
+	}
+}
@@ -0,0 +1,209 @@
+package netpol
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	isolationProfilePolicyPrefix = "kube-router-isolation-"
+	isolationProfileAnnotation   = "kube-router.io/isolation-profile"
+	kubeSystemNamespace          = "kube-system"
+)
+
+// pods of the cluster DNS, both kube-dns and CoreDNS deployments carry the label
+var kubeDNSPodSelector = labels.SelectorFromSet(labels.Set{"k8s-app": "kube-dns"})
+
+// newIsolationProfileInformer returns the informer of the NamespaceIsolationProfiles, which syncs the controller on
+// their changes
+func (npc *NetworkPolicyController) newIsolationProfileInformer(clientset kubernetes.Interface,
+	resync time.Duration) cache.SharedIndexInformer {
+	lw := crd.NewListWatch(clientset, crd.NamespaceIsolationProfileResource,
+		func() runtime.Object { return &crd.NamespaceIsolationProfileList{} },
+		func() runtime.Object { return &crd.NamespaceIsolationProfile{} })
+	informer := cache.NewSharedIndexInformer(utils.NewInstrumentedListWatch(crd.NamespaceIsolationProfileResource, lw),
+		&crd.NamespaceIsolationProfile{}, resync, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: npc.OnNamespaceIsolationProfileUpdate,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			npc.OnNamespaceIsolationProfileUpdate(newObj)
+		},
+		DeleteFunc: npc.OnNamespaceIsolationProfileUpdate,
+	})
+	return informer
+}
+
+// OnNamespaceIsolationProfileUpdate handles the changes of the NamespaceIsolationProfiles
+func (npc *NetworkPolicyController) OnNamespaceIsolationProfileUpdate(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	profile, ok := obj.(*crd.NamespaceIsolationProfile)
+	if !ok {
+		glog.Errorf("unexpected object type: %v", obj)
+		return
+	}
+	glog.V(2).Infof("Received update for namespace isolation profile: %s", profile.Name)
+
+	if !npc.readyForUpdates {
+		glog.V(3).Infof("Skipping update to namespace isolation profile: %s, controller still performing bootup "+
+			"full-sync", profile.Name)
+		return
+	}
+
+	npc.syncIsolationProfiles()
+	npc.syncQueue.add(syncFull)
+}
+
+// syncIsolationProfiles reads the NamespaceIsolationProfile custom resources from the informer cache. They are
+// read on the periodic syncs and on their changes, the syncs triggered by updates to pods, namespaces and network
+// policies reuse them.
+func (npc *NetworkPolicyController) syncIsolationProfiles() {
+	objs := npc.isolationProfileInformer.GetIndexer().List()
+	profiles := make([]crd.NamespaceIsolationProfile, 0, len(objs))
+	for _, obj := range objs {
+		profiles = append(profiles, *obj.(*crd.NamespaceIsolationProfile))
+	}
+	npc.setIsolationProfiles(profiles)
+}
+
+// setIsolationProfiles keeps the valid profiles, in the order of their names
+func (npc *NetworkPolicyController) setIsolationProfiles(profiles []crd.NamespaceIsolationProfile) {
+	valid := make([]crd.NamespaceIsolationProfile, 0, len(profiles))
+	for i := range profiles {
+		if err := profiles[i].Validate(); err != nil {
+			glog.Errorf("Ignoring namespace isolation profile %s: %s", profiles[i].Name, err)
+			continue
+		}
+		valid = append(valid, profiles[i])
+	}
+	sort.Slice(valid, func(i, j int) bool { return valid[i].Name < valid[j].Name })
+
+	npc.mu.Lock()
+	npc.isolationProfiles = valid
+	npc.mu.Unlock()
+}
+
+// listNetworkPolicies returns the network policies of the cluster along with the network policies the
+// namespace isolation profiles and the default-deny annotations of the namespaces expand into
+func (npc *NetworkPolicyController) listNetworkPolicies() ([]interface{}, error) {
+	policies := npc.npLister.List()
+	namespaces, err := npc.ListNamespaceByLabels(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range defaultDenyPolicies(namespaces) {
+		policies = append(policies, policy)
+	}
+	if len(npc.isolationProfiles) == 0 {
+		return npc.enforcedPolicies(policies), nil
+	}
+
+	dnsPods, err := npc.ListPodsByNamespaceAndLabels(kubeSystemNamespace, kubeDNSPodSelector)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range expandIsolationProfiles(npc.isolationProfiles, namespaces, dnsPods) {
+		policies = append(policies, policy)
+	}
+	return npc.enforcedPolicies(policies), nil
+}
+
+// expandIsolationProfiles generates for each namespace matching the namespace selector of a profile a
+// network policy denying all traffic in the directions of the profile, and network policies allowing the
+// traffic within the namespace and the DNS queries to the cluster DNS pods when requested by the profile
+func expandIsolationProfiles(profiles []crd.NamespaceIsolationProfile, namespaces []*api.Namespace,
+	dnsPods []*api.Pod) []*networking.NetworkPolicy {
+	policies := make([]*networking.NetworkPolicy, 0)
+
+	for _, profile := range profiles {
+		namespaceSelector, err := v1.LabelSelectorAsSelector(&profile.Spec.NamespaceSelector)
+		if err != nil {
+			continue
+		}
+		policyTypes := profile.Spec.PolicyTypes
+		if len(policyTypes) == 0 {
+			policyTypes = []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress}
+		}
+		ingress, egress := false, false
+		for _, policyType := range policyTypes {
+			ingress = ingress || policyType == networking.PolicyTypeIngress
+			egress = egress || policyType == networking.PolicyTypeEgress
+		}
+
+		for _, namespace := range namespaces {
+			if !namespaceSelector.Matches(labels.Set(namespace.Labels)) {
+				continue
+			}
+			newPolicy := func(suffix string) *networking.NetworkPolicy {
+				return &networking.NetworkPolicy{
+					ObjectMeta: v1.ObjectMeta{
+						Name:        isolationProfilePolicyPrefix + profile.Name + "-" + suffix,
+						Namespace:   namespace.Name,
+						Annotations: map[string]string{isolationProfileAnnotation: profile.Name},
+					},
+					Spec: networking.NetworkPolicySpec{
+						PolicyTypes: policyTypes,
+					},
+				}
+			}
+
+			policies = append(policies, newPolicy("deny-all"))
+
+			if profile.Spec.AllowSameNamespace {
+				policy := newPolicy("allow-same-namespace")
+				samePodsPeer := []networking.NetworkPolicyPeer{{PodSelector: &v1.LabelSelector{}}}
+				if ingress {
+					policy.Spec.Ingress = []networking.NetworkPolicyIngressRule{{From: samePodsPeer}}
+				}
+				if egress {
+					policy.Spec.Egress = []networking.NetworkPolicyEgressRule{{To: samePodsPeer}}
+				}
+				policies = append(policies, policy)
+			}
+
+			// DNS queries are only denied when egress is isolated. A rule without destinations would allow
+			// all traffic, so no rule is generated until the DNS pods have addresses.
+			if profile.Spec.AllowKubeSystemDNS && egress {
+				dnsPeers := make([]networking.NetworkPolicyPeer, 0, len(dnsPods))
+				for _, pod := range dnsPods {
+					if pod.Status.PodIP == "" {
+						continue
+					}
+					dnsPeers = append(dnsPeers, networking.NetworkPolicyPeer{
+						IPBlock: &networking.IPBlock{CIDR: pod.Status.PodIP + "/32"},
+					})
+				}
+				if len(dnsPeers) != 0 {
+					udp, tcp := api.ProtocolUDP, api.ProtocolTCP
+					dnsPort := intstr.FromInt(53)
+					policy := newPolicy("allow-dns")
+					policy.Spec.PolicyTypes = []networking.PolicyType{networking.PolicyTypeEgress}
+					policy.Spec.Egress = []networking.NetworkPolicyEgressRule{{
+						To: dnsPeers,
+						Ports: []networking.NetworkPolicyPort{
+							{Protocol: &udp, Port: &dnsPort},
+							{Protocol: &tcp, Port: &dnsPort},
+						},
+					}}
+					policies = append(policies, policy)
+				}
+			}
+		}
+	}
+
+	return policies
+}
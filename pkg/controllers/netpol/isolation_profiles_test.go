@@ -0,0 +1,84 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIsolationProfiles(t *testing.T) {
+	krNetPol, _ := newTestController(t,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Labels: map[string]string{"tenant": "true"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: kubeSystemNamespace}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "tenant-a"},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shared"},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.2.1"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: kubeSystemNamespace, Labels: map[string]string{"k8s-app": "kube-dns"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.11", PodIP: "1.1.3.1"}})
+
+	// the profiles are read from the informer cache, the invalid ones are ignored
+	krNetPol.isolationProfileInformer = krNetPol.newIsolationProfileInformer(fake.NewSimpleClientset(), 0)
+	krNetPol.isolationProfileInformer.GetIndexer().Add(&crd.NamespaceIsolationProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "strict"},
+		Spec: crd.NamespaceIsolationProfileSpec{
+			NamespaceSelector:  metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+			AllowSameNamespace: true,
+			AllowKubeSystemDNS: true,
+		},
+	})
+	krNetPol.isolationProfileInformer.GetIndexer().Add(&crd.NamespaceIsolationProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+		Spec: crd.NamespaceIsolationProfileSpec{
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "true"}},
+			PolicyTypes:       []netv1.PolicyType{"Sideways"},
+		},
+	})
+	krNetPol.syncIsolationProfiles()
+	if len(krNetPol.isolationProfiles) != 1 || krNetPol.isolationProfiles[0].Name != "strict" {
+		t.Fatalf("expected the strict profile only but got %v", krNetPol.isolationProfiles)
+	}
+
+	policies, err := krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"kube-router-isolation-strict-deny-all":             "both",
+		"kube-router-isolation-strict-allow-same-namespace": "both",
+		"kube-router-isolation-strict-allow-dns":            "egress",
+	}
+	if len(*policies) != len(expected) {
+		t.Fatalf("expected %d generated policies but got %d: %v", len(expected), len(*policies), *policies)
+	}
+	for _, policy := range *policies {
+		policyType, ok := expected[policy.name]
+		if !ok || policy.namespace != "tenant-a" {
+			t.Errorf("unexpected generated policy %s/%s", policy.namespace, policy.name)
+			continue
+		}
+		if policy.policyType != policyType {
+			t.Errorf("expected policy %s to be of type %s but got %s", policy.name, policyType, policy.policyType)
+		}
+		if _, ok := policy.targetPods["1.1.1.1"]; !ok || len(policy.targetPods) != 1 {
+			t.Errorf("expected policy %s to only target the pod of tenant-a, got %v", policy.name, policy.targetPods)
+		}
+		switch policy.name {
+		case "kube-router-isolation-strict-allow-same-namespace":
+			if len(policy.ingressRules) != 1 || len(policy.ingressRules[0].srcPods) != 1 || policy.ingressRules[0].srcPods[0].ip != "1.1.1.1" {
+				t.Errorf("expected ingress only from pods of tenant-a, got %+v", policy.ingressRules)
+			}
+		case "kube-router-isolation-strict-allow-dns":
+			if len(policy.egressRules) != 1 || len(policy.egressRules[0].dstIPBlocks) != 1 ||
+				policy.egressRules[0].dstIPBlocks[0][0] != "1.1.3.1/32" || len(policy.egressRules[0].ports) != 2 {
+				t.Errorf("expected egress to the DNS pod on port 53, got %+v", policy.egressRules)
+			}
+		}
+	}
+}
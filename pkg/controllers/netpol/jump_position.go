@@ -0,0 +1,166 @@
+package netpol
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+// The rules jumping to the dispatch chains are inserted at the top of the FORWARD, OUTPUT and INPUT chains by
+// default. They can be appended instead, or inserted after a rule of the node marked by its comment, e.g. so the
+// accounting rules of the node see the traffic first. The chains are checked periodically, and a full sync, which
+// inserts the jumps again, is queued when they were moved away.
+
+const (
+	jumpPositionTop         = "top"
+	jumpPositionBottom      = "bottom"
+	jumpPositionAfterMarker = "after-marker-comment"
+
+	jumpPositionCheckPeriod = time.Minute
+)
+
+// jumpPosition is where the jumps to the dispatch chains are inserted in the built-in chains, at the top when nil
+type jumpPosition struct {
+	strategy string
+	// comment of the rule the jumps follow with jumpPositionAfterMarker
+	marker string
+	// built-in chains found without the marker rule by the last sync, their jumps are at the top
+	missingMarker map[string]bool
+}
+
+func newJumpPosition(strategy, marker string) (*jumpPosition, error) {
+	switch strategy {
+	case jumpPositionTop, jumpPositionBottom:
+		if marker != "" {
+			return nil, errors.New("--netpol-jump-marker-comment requires --netpol-jump-position=after-marker-comment")
+		}
+	case jumpPositionAfterMarker:
+		if marker == "" {
+			return nil, errors.New("--netpol-jump-position=after-marker-comment requires --netpol-jump-marker-comment")
+		}
+	default:
+		return nil, fmt.Errorf("unknown --netpol-jump-position %q, must be top, bottom or after-marker-comment", strategy)
+	}
+	return &jumpPosition{strategy: strategy, marker: marker, missingMarker: make(map[string]bool)}, nil
+}
+
+// chainRules returns the rules of a chain listed by iptables -S, without the policy of the chain
+func chainRules(listed []string) ([]utils.IPTablesSaveRule, error) {
+	rules := make([]utils.IPTablesSaveRule, 0, len(listed))
+	for _, line := range listed {
+		rule, ok, err := utils.ParseIPTablesRule(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// markerIndex returns the index of the last of the rules with the comment of the marker, -1 if none has it
+func (p *jumpPosition) markerIndex(rules []utils.IPTablesSaveRule) int {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].Comment == p.marker {
+			return i
+		}
+	}
+	return -1
+}
+
+// rulePosition returns the position, from 1, the jumps are inserted at in the chain with the rules, 0 to append them
+func (p *jumpPosition) rulePosition(rules []utils.IPTablesSaveRule) int {
+	if p == nil {
+		return 1
+	}
+	switch p.strategy {
+	case jumpPositionBottom:
+		return 0
+	case jumpPositionAfterMarker:
+		if i := p.markerIndex(rules); i >= 0 {
+			return i + 2
+		}
+	}
+	return 1
+}
+
+// misplaced tells whether the jump to the dispatch chain is away from its position in the chain with the rules: the
+// first rule is the jump when it is at the top, the last one at the bottom, and the rule following the marker after
+// it
+func (p *jumpPosition) misplaced(rules []utils.IPTablesSaveRule) bool {
+	index := p.rulePosition(rules) - 1
+	if index < 0 {
+		index = len(rules) - 1
+	}
+	return index < 0 || index >= len(rules) || !jumpsToDispatchChain(rules[index])
+}
+
+// runJumpPositionCheck checks the position of the jumps to the dispatch chains every jumpPositionCheckPeriod
+// until stopCh is closed
+func (npc *NetworkPolicyController) runJumpPositionCheck(stopCh <-chan struct{}) {
+	t := time.NewTicker(jumpPositionCheckPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+		if !npc.readyForUpdates {
+			continue
+		}
+		if err := npc.checkJumpPositions(); err != nil {
+			glog.Errorf("Failed to check the position of the jumps to the dispatch chains: %s", err)
+		}
+	}
+}
+
+// checkJumpPositions queues a full sync when the jumps to the dispatch chains were moved away from their position in
+// any of the built-in chains, e.g. by rules inserted above them, or removed
+func (npc *NetworkPolicyController) checkJumpPositions() error {
+	npc.mu.Lock()
+	synced := npc.dispatchChainsSynced
+	npc.mu.Unlock()
+	if !synced {
+		return nil
+	}
+
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return fmt.Errorf("Failed to initialize iptables executor: %s", err.Error())
+	}
+	drifted := make([]string, 0)
+	for _, chain := range podFwBuiltInChains {
+		listed, err := iptablesCmdHandler.List("filter", chain)
+		if err != nil {
+			return fmt.Errorf("failed to list the rules of the %s chain: %s", chain, err)
+		}
+		rules, err := chainRules(listed)
+		if err != nil {
+			return err
+		}
+		if npc.jumpPosition.misplaced(rules) {
+			drifted = append(drifted, chain)
+		}
+	}
+	if len(drifted) == 0 {
+		return nil
+	}
+
+	glog.Warningf("The jumps to the dispatch chains were moved in the %s chains, syncing to insert them again",
+		strings.Join(drifted, ", "))
+	if npc.MetricsEnabled {
+		for _, chain := range drifted {
+			metrics.ControllerPolicyJumpPositionDrift.WithLabelValues(chain).Inc()
+		}
+	}
+	npc.syncQueue.add(syncFull)
+	return nil
+}
@@ -0,0 +1,51 @@
+package netpol
+
+import (
+	"testing"
+)
+
+func TestJumpPosition(t *testing.T) {
+	listed := []string{
+		"-P FORWARD ACCEPT",
+		`-A FORWARD -m comment --comment "accounting" -j ACCT`,
+		"-A FORWARD -m comment --comment \"rule to jump traffic to the pod firewall chains\" -j KUBE-ROUTER-FORWARD",
+		"-A FORWARD -j DOCKER-USER",
+	}
+	rules, err := chainRules(listed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		strategy  string
+		marker    string
+		position  int
+		misplaced bool
+	}{
+		{jumpPositionTop, "", 1, true},
+		{jumpPositionBottom, "", 0, true},
+		{jumpPositionAfterMarker, "accounting", 2, false},
+		// without the marker rule the jumps go at the top
+		{jumpPositionAfterMarker, "metering", 1, true},
+	} {
+		p, err := newJumpPosition(test.strategy, test.marker)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", test.strategy, err)
+		}
+		if position := p.rulePosition(rules); position != test.position {
+			t.Errorf("expected the jumps at %d with %s %q, got %d", test.position, test.strategy, test.marker, position)
+		}
+		if misplaced := p.misplaced(rules); misplaced != test.misplaced {
+			t.Errorf("expected misplaced %v with %s %q, got %v", test.misplaced, test.strategy, test.marker, misplaced)
+		}
+	}
+
+	var top *jumpPosition
+	if top.rulePosition(rules) != 1 || top.misplaced(rules[1:]) {
+		t.Errorf("expected the jumps at the top of the chain without a position set")
+	}
+	for _, invalid := range [][2]string{{"middle", ""}, {jumpPositionAfterMarker, ""}, {jumpPositionTop, "accounting"}} {
+		if _, err := newJumpPosition(invalid[0], invalid[1]); err == nil {
+			t.Errorf("expected an error for position %q with marker %q", invalid[0], invalid[1])
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package netpol
+
+import (
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+// chain matches are probed in, removed right after the probe
+const matchProbeChain = "KUBE-ROUTER-MATCH-PROBE"
+
+// iptables matches used by the pod firewall chains that some kernels are built without
+var probedMatches = map[string][]string{
+	"physdev":  {"-m", "physdev", "--physdev-is-bridged"},
+	"addrtype": {"-m", "addrtype", "--src-type", "LOCAL"},
+}
+
+// probeMatchSupport tells which of the probed matches the kernel supports, by appending a rule using each to a
+// temporary chain. Matches are assumed to be supported when the probe itself cannot run.
+func probeMatchSupport(iptablesCmdHandler *iptables.IPTables) map[string]bool {
+	supported := make(map[string]bool)
+	for match := range probedMatches {
+		supported[match] = true
+	}
+	if err := iptablesCmdHandler.ClearChain("filter", matchProbeChain); err != nil {
+		glog.Errorf("Failed to create chain %s to probe the iptables matches supported by the kernel: %s",
+			matchProbeChain, err)
+		return supported
+	}
+	defer func() {
+		if err := iptablesCmdHandler.ClearChain("filter", matchProbeChain); err != nil {
+			glog.Errorf("Failed to flush chain %s: %s", matchProbeChain, err)
+			return
+		}
+		if err := iptablesCmdHandler.DeleteChain("filter", matchProbeChain); err != nil {
+			glog.Errorf("Failed to delete chain %s: %s", matchProbeChain, err)
+		}
+	}()
+	for match, args := range probedMatches {
+		if err := iptablesCmdHandler.Append("filter", matchProbeChain, append(args, "-j", "RETURN")...); err != nil {
+			glog.V(1).Infof("iptables %s match is not supported: %s", match, err)
+			supported[match] = false
+		}
+	}
+	return supported
+}
+
+// probeMatches records the matches the kernel lacks, so the rules using them are skipped or replaced instead of
+// failing on every sync
+func (npc *NetworkPolicyController) probeMatches() {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		glog.Errorf("Failed to probe the iptables matches supported by the kernel: %s", err)
+		return
+	}
+	supported := probeMatchSupport(iptablesCmdHandler)
+	if !supported["physdev"] {
+		npc.physdevUnsupported = true
+		if !npc.podsRoutedMode {
+			glog.Warningf("The kernel does not support the iptables physdev match, traffic between pods on the " +
+				"same bridge is not subject to network policies. Run with --pods-routed-mode if pods are not attached to a bridge.")
+		}
+	}
+	if !supported["addrtype"] {
+		npc.addrtypeUnsupported = true
+		glog.Warningf("The kernel does not support the iptables addrtype match, only traffic to pods from the " +
+			"node IP is permitted regardless of network policies instead of traffic from any local address")
+	}
+}
+
+// localPodJumpArgs returns the rule in the FORWARD chain jumping the traffic between the pod and the other pods of
+// the node to the pod firewall chain, or nil if that traffic cannot be matched. addrFlag is -d for the traffic to
+// the pod and -s for the traffic from the pod. Bridged traffic is matched with the physdev match, routed traffic by
+// the host side interface of the pod alone when it was discovered, by the interface prefix and the pod IP otherwise.
+func (npc *NetworkPolicyController) localPodJumpArgs(comment, addrFlag string, pod podInfo, chain string) []string {
+	if npc.podsRoutedMode {
+		ifaceFlag := "-o"
+		if addrFlag == "-s" {
+			ifaceFlag = "-i"
+		}
+		if pod.iface != "" {
+			// all the traffic through the interface belongs to the pod, whatever its IP
+			return []string{ifaceFlag, pod.iface,
+				"-m", "comment", "--comment", comment,
+				"-j", chain}
+		}
+		return []string{ifaceFlag, npc.podInterfacePrefix + "+",
+			"-m", "comment", "--comment", comment,
+			addrFlag, pod.ip,
+			"-j", chain}
+	}
+	if npc.physdevUnsupported {
+		return nil
+	}
+	return []string{"-m", "physdev", "--physdev-is-bridged",
+		"-m", "comment", "--comment", comment,
+		addrFlag, pod.ip,
+		"-j", chain}
+}
+
+// localSourceArgs returns the arguments matching the traffic originated by the node
+func (npc *NetworkPolicyController) localSourceArgs() []string {
+	if npc.addrtypeUnsupported {
+		return []string{"-s", npc.nodeIP.String()}
+	}
+	return []string{"-m", "addrtype", "--src-type", "LOCAL"}
+}
@@ -0,0 +1,34 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalPodJumpArgs(t *testing.T) {
+	npc := &NetworkPolicyController{podInterfacePrefix: "veth"}
+	pod := podInfo{ip: "1.1.1.1"}
+	rule := strings.Join(npc.localPodJumpArgs("comment", "-d", pod, "KUBE-POD-FW-XXXXXXXXXXXXXXXX"), " ")
+	if !strings.HasPrefix(rule, "-m physdev --physdev-is-bridged") {
+		t.Errorf("expected bridged traffic to be matched with physdev: %s", rule)
+	}
+
+	npc.physdevUnsupported = true
+	if args := npc.localPodJumpArgs("comment", "-d", pod, "KUBE-POD-FW-XXXXXXXXXXXXXXXX"); args != nil {
+		t.Errorf("expected no rule without physdev support, got %v", args)
+	}
+
+	npc.podsRoutedMode = true
+	for addrFlag, ifaceFlag := range map[string]string{"-d": "-o", "-s": "-i"} {
+		rule = strings.Join(npc.localPodJumpArgs("comment", addrFlag, pod, "KUBE-POD-FW-XXXXXXXXXXXXXXXX"), " ")
+		if !strings.HasPrefix(rule, ifaceFlag+" veth+") || !strings.HasSuffix(rule, addrFlag+" 1.1.1.1 -j KUBE-POD-FW-XXXXXXXXXXXXXXXX") {
+			t.Errorf("unexpected jump of routed traffic to pod firewall chain: %s", rule)
+		}
+	}
+
+	pod.iface = "veth1234"
+	rule = strings.Join(npc.localPodJumpArgs("comment", "-s", pod, "KUBE-POD-FW-XXXXXXXXXXXXXXXX"), " ")
+	if rule != "-i veth1234 -m comment --comment comment -j KUBE-POD-FW-XXXXXXXXXXXXXXXX" {
+		t.Errorf("unexpected jump of the traffic from the pod interface to pod firewall chain: %s", rule)
+	}
+}
@@ -0,0 +1,42 @@
+package netpol
+
+import (
+	"github.com/golang/glog"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// keepPeerPodIPSet tells whether the ipset of the pod peers of a rule and the rules matching it are needed.
+// They are kept as placeholders for peers selecting namespaces even when no pod matches, so a namespace
+// gaining matching labels only needs the ipset to be refreshed for its pods to be allowed.
+func (npc *NetworkPolicyController) keepPeerPodIPSet(pods []podInfo, namespaceSelectorPeers bool) bool {
+	return len(pods) != 0 || (npc.namespacePlaceholderIPSets && namespaceSelectorPeers)
+}
+
+// namespaceLabelsChanged tells whether an update of a namespace changed its labels, which are the only
+// attribute of namespaces GA network policies depend on
+func namespaceLabelsChanged(oldObj, newObj interface{}) bool {
+	oldNamespace, ok := oldObj.(*api.Namespace)
+	if !ok {
+		return true
+	}
+	newNamespace, ok := newObj.(*api.Namespace)
+	if !ok {
+		return true
+	}
+	return !labels.Equals(oldNamespace.Labels, newNamespace.Labels)
+}
+
+// OnNamespaceLabelsUpdate handles updates to the labels of a namespace, which change the pods matched by the
+// namespace selectors of GA network policies
+func (npc *NetworkPolicyController) OnNamespaceLabelsUpdate(obj interface{}) {
+	namespace := obj.(*api.Namespace)
+	glog.V(2).Infof("Received update for labels of namespace: %s", namespace.Name)
+
+	if !npc.readyForUpdates {
+		glog.V(3).Infof("Skipping update to namespace: %s, controller still performing bootup full-sync", namespace.Name)
+		return
+	}
+
+	npc.syncQueue.add(syncFull)
+}
@@ -0,0 +1,96 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceSelectorPlaceholders(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+
+	unlabeled := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "frontend"}}
+	labeled := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Labels: map[string]string{"team": "frontend"}}}
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "backend"}})
+	tAddToInformerStore(t, cluster.nsInformer, unlabeled)
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "backend"},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+	tAddToInformerStore(t, cluster.podInformer,
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "frontend"},
+			Status: v1.PodStatus{HostIP: "10.10.10.11", PodIP: "1.1.2.1"}})
+	netpol := tNetpol{
+		name:      "allow-frontend",
+		namespace: "backend",
+		ingress: []netv1.NetworkPolicyIngressRule{
+			{From: []netv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "frontend"}}}}},
+		},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+	sourceSet := policyIndexedSourcePodIpSetName("backend", "allow-frontend", 0)
+
+	render := func(placeholders bool) ([]podInfo, string) {
+		krNetPol.namespacePlaceholderIPSets = placeholders
+		state, err := krNetPol.RenderDesiredState()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		policies := *krNetPol.networkPoliciesInfo
+		if len(policies) != 1 || len(policies[0].ingressRules) != 1 {
+			t.Fatalf("expected a single policy with a single ingress rule, got %+v", policies)
+		}
+		return policies[0].ingressRules[0].srcPods, strings.Join(state.Lines(nodestate.IPSets), "\n")
+	}
+
+	if _, ipsets := render(false); strings.Contains(ipsets, sourceSet) {
+		t.Errorf("expected no source ipset without placeholders while no namespace matches:\n%s", ipsets)
+	}
+	srcPods, ipsets := render(true)
+	if len(srcPods) != 0 {
+		t.Errorf("expected no source pods while no namespace matches, got %v", srcPods)
+	}
+	if !strings.Contains(ipsets, sourceSet) || strings.Contains(ipsets, sourceSet+" ") {
+		t.Errorf("expected an empty placeholder source ipset while no namespace matches:\n%s", ipsets)
+	}
+
+	// namespace gaining the matching label
+	if !namespaceLabelsChanged(unlabeled, labeled) {
+		t.Errorf("expected the namespace gaining a label to be detected as a label change")
+	}
+	if err := cluster.nsInformer.GetStore().Update(labeled); err != nil {
+		t.Fatalf("error updating namespace in Informer Store: %v", err)
+	}
+	srcPods, ipsets = render(true)
+	if len(srcPods) != 1 || srcPods[0].ip != "1.1.2.1" {
+		t.Errorf("expected the pod of the labeled namespace as source, got %v", srcPods)
+	}
+	if !strings.Contains(ipsets, sourceSet+" 1.1.2.1") {
+		t.Errorf("expected the pod of the labeled namespace in the source ipset:\n%s", ipsets)
+	}
+
+	// namespace losing the matching label
+	if !namespaceLabelsChanged(labeled, unlabeled) {
+		t.Errorf("expected the namespace losing a label to be detected as a label change")
+	}
+	if err := cluster.nsInformer.GetStore().Update(unlabeled); err != nil {
+		t.Fatalf("error updating namespace in Informer Store: %v", err)
+	}
+	srcPods, ipsets = render(true)
+	if len(srcPods) != 0 {
+		t.Errorf("expected no source pods once the namespace lost the label, got %v", srcPods)
+	}
+	if !strings.Contains(ipsets, sourceSet) || strings.Contains(ipsets, sourceSet+" ") {
+		t.Errorf("expected the source ipset to be emptied but kept once the namespace lost the label:\n%s", ipsets)
+	}
+
+	annotated := unlabeled.DeepCopy()
+	annotated.Annotations = map[string]string{"owner": "frontend-team"}
+	if namespaceLabelsChanged(unlabeled, annotated) {
+		t.Errorf("expected an annotation change not to be detected as a label change")
+	}
+}
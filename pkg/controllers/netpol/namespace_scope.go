@@ -0,0 +1,101 @@
+package netpol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// With --netpol-namespace-selector only the network policies of the namespaces matching the label selector are
+// enforced, the pods of the other namespaces are left unisolated whatever their network policies, e.g. to roll out the
+// enforcement tenant by tenant in a cluster whose network policies were not enforced so far. The pods of the other
+// namespaces remain peers of the enforced network policies. The namespaces are read from the informer cache, a change
+// of their labels applies on the next sync.
+//
+// With --netpol-exclude-namespaces the network policies of the listed namespaces are not enforced either, whatever
+// the selector, and their pods get no pod firewall chain, so no network policy, cluster network policy or default
+// verdict applies to their traffic, e.g. to keep the critical infrastructure out of the enforcement while rolling it
+// out. Their pods remain peers of the network policies of the other namespaces.
+
+// parseNamespaceScope returns the selector of the namespaces whose network policies are enforced, nil for all of them
+func parseNamespaceScope(selector string) (labels.Selector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --netpol-namespace-selector %q: %s", selector, err)
+	}
+	return parsed, nil
+}
+
+// parseExcludedNamespaces returns the set of the namespaces excluded from the enforcement, nil for none
+func parseExcludedNamespaces(namespaces []string) map[string]bool {
+	var excluded map[string]bool
+	for _, namespace := range namespaces {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" {
+			continue
+		}
+		if excluded == nil {
+			excluded = make(map[string]bool)
+		}
+		excluded[namespace] = true
+	}
+	return excluded
+}
+
+// excludesNamespace tells whether the namespace is excluded from the enforcement, its pods get no pod firewall chain
+func (npc *NetworkPolicyController) excludesNamespace(namespace string) bool {
+	return npc.excludedNamespaces[namespace]
+}
+
+// excludedNamespaceNames returns the namespaces excluded from the enforcement, sorted
+func (npc *NetworkPolicyController) excludedNamespaceNames() []string {
+	names := make([]string, 0, len(npc.excludedNamespaces))
+	for namespace := range npc.excludedNamespaces {
+		names = append(names, namespace)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enforcesNamespace tells whether the network policies of the namespace are enforced
+func (npc *NetworkPolicyController) enforcesNamespace(namespace string) bool {
+	if npc.excludesNamespace(namespace) {
+		return false
+	}
+	if npc.namespaceScope == nil {
+		return true
+	}
+	obj, exists, err := npc.nsLister.GetByKey(namespace)
+	if err != nil || !exists {
+		return false
+	}
+	return npc.namespaceScope.Matches(labels.Set(obj.(*api.Namespace).Labels))
+}
+
+// enforcedPolicies returns the network policies of the namespaces whose network policies are enforced
+func (npc *NetworkPolicyController) enforcedPolicies(policies []interface{}) []interface{} {
+	if npc.namespaceScope == nil && len(npc.excludedNamespaces) == 0 {
+		return policies
+	}
+	enforced := make([]interface{}, 0, len(policies))
+	for _, policy := range policies {
+		object, err := meta.Accessor(policy)
+		if err != nil {
+			glog.Errorf("unexpected object type: %v", policy)
+			continue
+		}
+		if npc.enforcesNamespace(object.GetNamespace()) {
+			enforced = append(enforced, policy)
+		}
+	}
+	return enforced
+}
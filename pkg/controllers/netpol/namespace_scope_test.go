@@ -0,0 +1,113 @@
+package netpol
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceScope(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+
+	enforced := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a",
+		Labels: map[string]string{"netpol": "enforced"}}}
+	tAddToInformerStore(t, cluster.nsInformer, enforced)
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-b"}})
+	for _, namespace := range []string{"tenant-a", "tenant-b", "tenant-c"} {
+		tAddToInformerStore(t, cluster.netpolInformer, &netv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "deny-all", Namespace: namespace},
+			Spec:       netv1.NetworkPolicySpec{PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress}},
+		})
+	}
+
+	namespaces := func() []string {
+		policies, err := krNetPol.buildNetworkPoliciesInfo()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		namespaces := make([]string, 0)
+		for _, policy := range *policies {
+			namespaces = append(namespaces, policy.namespace)
+		}
+		sort.Strings(namespaces)
+		return namespaces
+	}
+	if got := namespaces(); !reflect.DeepEqual(got, []string{"tenant-a", "tenant-b", "tenant-c"}) {
+		t.Errorf("expected the policies of all the namespaces without selector, got %v", got)
+	}
+
+	if _, err := parseNamespaceScope("netpol in (enforced"); err == nil {
+		t.Error("expected an invalid selector to be rejected")
+	}
+	scope, err := parseNamespaceScope("netpol=enforced")
+	if err != nil {
+		t.Fatal(err)
+	}
+	krNetPol.namespaceScope = scope
+	// the policies of a namespace missing from the cache are not enforced either
+	if got := namespaces(); !reflect.DeepEqual(got, []string{"tenant-a"}) {
+		t.Errorf("expected the policies of the selected namespace only, got %v", got)
+	}
+
+	enforced = enforced.DeepCopy()
+	enforced.Labels = nil
+	if err := cluster.nsInformer.GetStore().Update(enforced); err != nil {
+		t.Fatalf("error updating namespace in Informer Store: %v", err)
+	}
+	if got := namespaces(); len(got) != 0 {
+		t.Errorf("expected no policy once the namespace is unlabeled, got %v", got)
+	}
+}
+
+func TestExcludedNamespaces(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.excludedNamespaces = parseExcludedNamespaces([]string{" kube-system", "", "monitoring"})
+
+	for i, namespace := range []string{"kube-system", "nsA"} {
+		tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+		tAddToInformerStore(t, cluster.podInformer,
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace,
+				Labels: map[string]string{"app": "web"}},
+				Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1." + strconv.Itoa(i+1)}})
+		netpol := tNetpol{
+			name:        "deny-all",
+			namespace:   namespace,
+			podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			ingress:     []netv1.NetworkPolicyIngressRule{},
+			egress:      []netv1.NetworkPolicyEgressRule{},
+		}
+		netpol.createFakeNetpol(t, cluster.netpolInformer)
+	}
+	if names := krNetPol.excludedNamespaceNames(); !reflect.DeepEqual(names, []string{"kube-system", "monitoring"}) {
+		t.Errorf("expected the namespaces kube-system and monitoring excluded, got %v", names)
+	}
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*krNetPol.networkPoliciesInfo) != 1 || (*krNetPol.networkPoliciesInfo)[0].namespace != "nsA" {
+		t.Errorf("expected the network policy of nsA only, got %v", *krNetPol.networkPoliciesInfo)
+	}
+	filterTable := utils.NewIPTablesRestore("filter")
+	chains, _, err := krNetPol.syncPodFirewallChains(filterTable, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]bool{podFirewallChainName("nsA", "web", "1"): true}
+	if !reflect.DeepEqual(chains, expected) {
+		t.Errorf("expected the pod firewall chains %v, got %v", expected, chains)
+	}
+	if strings.Contains(string(filterTable.Bytes()), "1.1.1.1") {
+		t.Errorf("expected no rule for the pod of the excluded namespace:\n%s", filterTable.Bytes())
+	}
+}
@@ -9,13 +9,16 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"net"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/coreos/go-iptables/iptables"
@@ -61,6 +64,181 @@ type NetworkPolicyController struct {
 	readyForUpdates bool
 	healthChan      chan<- *healthcheck.ControllerHeartbeat
 
+	// stretches the periodic sync period while the node is overloaded, nil if disabled
+	Governor *utils.LoadGovernor
+	// runs the syncs of the controllers one at a time and staggers their periodic syncs, nil if disabled
+	Coordinator *utils.SyncCoordinator
+	// records the events about the network policies, nil if events are not recorded
+	Events *utils.EventSink
+	// records the changes of the chains, rules and ipsets, nil if disabled
+	Audit *utils.AuditLog
+
+	// dataplane enforcing the network policies, policyBackendIPTables or policyBackendNFTables
+	policyBackend string
+	// which connections tracked by conntrack the pod firewall chains accept without evaluating the network policies,
+	// conntrackModePermissive or conntrackModeStrict
+	conntrackMode string
+	// deletes the connections of the addresses the syncs remove from the ipsets of the network policies
+	flushRevokedConntrack bool
+
+	// maximum number of iptables rules and ipset entries of a network policy, 0 for no limit
+	maxPolicyRules        int
+	maxPolicyIPSetEntries int
+
+	// number of pods of a peer past which its ipset holds the CIDRs aggregating their addresses, 0 to disable
+	peerIPSetAggregationThreshold int
+
+	// NFLOG group the traffic dropped by the network policies is logged to, 0 if not logged, and the rate limit of
+	// the logged packets
+	dropLogGroup uint16
+	dropLogLimit string
+	// drop events of the packets of the drop log, set when it is read
+	dropEvents       *dropEvents
+	dropExporter     *dropExporter
+	dropEventMetrics bool
+	// record the drops as events of the pods whose network policies dropped the packets
+	denyEvents bool
+	// read the packets dropped by the network policies from the drop log and log them with the services and nodes
+	// of their addresses, set from the informers of the services and nodes
+	logDroppedTraffic bool
+	ServiceLister     cache.Indexer
+	NodeLister        cache.Indexer
+	EndpointsLister   cache.Indexer
+
+	// NFLOG group for logging accepted connections of audited pods, 0 if disabled
+	acceptedFlowLogGroup uint16
+	acceptedFlowLogLimit string
+	acceptedFlowLogBurst int
+
+	// keep the ipsets of peers selecting namespaces even while no namespace matches
+	namespacePlaceholderIPSets bool
+
+	// pods are routed by the node rather than attached to a bridge, the traffic between the pods of the node is
+	// matched by the host side interfaces of the pods named after podInterfacePrefix
+	podsRoutedMode     bool
+	podInterfacePrefix string
+	// match the traffic between the pods of the node by the interface of each pod rather than its IP
+	podInterfaceRules bool
+	// enforce the ingress network policies of the host-network pods on the ports they declare
+	enforceHostNetworkPods bool
+	// iptables matches the kernel was found to lack
+	physdevUnsupported  bool
+	addrtypeUnsupported bool
+
+	// unix socket the CNI plugin queries the policy readiness of pods on, empty if disabled
+	policyReadinessSocket string
+	policyReadiness       *policyReadiness
+
+	postSyncHook      *utils.PostSyncHook
+	appliedStateCache *nodestate.AppliedStateCache
+	// publishes the outcome of the syncs in the NodePolicyStatus of the node, nil if disabled
+	policyStatus *policyStatusReporter
+	// ipsets of the network policies programmed by the last successful sync, by namespace, only kept when exported
+	exportIPSets  bool
+	ipSetManifest map[string][]IPSetManifestEntry
+
+	clientset               kubernetes.Interface
+	enableIsolationProfiles bool
+	// namespace isolation profiles expanded into network policies on each sync, and their informer, nil unless
+	// enabled
+	isolationProfiles        []crd.NamespaceIsolationProfile
+	isolationProfileInformer cache.SharedIndexInformer
+
+	// labels of the dataplane metrics, and the policies and pods the chains were created for
+	tenantLabels      *metrics.TenantLabels
+	policyChainOwners map[string]chainOwner
+	podFwChainOwners  map[string]chainOwner
+	// rules appended to the policy chains by the current sync, nil when the metrics are disabled
+	policyChainRules map[string]int
+	// where the rules jumping to the dispatch chains are inserted in the built-in chains, and whether a sync
+	// programmed them
+	jumpPosition         *jumpPosition
+	dispatchChainsSynced bool
+	// rules of the dispatch chains programmed by the last sync, the ones to the stale pod firewall chains are carried
+	// over by the syncs holding their removal
+	dispatchJumps []podFwJump
+	// refresh only the ipsets on the pod events leaving the rules of the chains unchanged
+	incrementalSync bool
+	// digest of the rules and the entries of the ipsets programmed by the last sync, the digest is empty until a
+	// sync succeeded
+	syncedRulesDigest string
+	syncedIPSets      map[string][][]string
+	// keep the rules of the last sync when the caches empty, nil in the tests
+	emptyCacheGuards *emptyCacheGuards
+	// network policy whose packets are sampled, nil if none
+	sample *policySample
+	// CIDRs and ports of the ClusterAllowLists, allowed to all the pods the network policies isolate
+	enableClusterAllowLists  bool
+	clusterAllowList         []allowListEntry
+	clusterAllowListInformer cache.SharedIndexInformer
+	// flows of --critical-flows and, when enabled, of the CriticalFlows, accepted ahead of all the policies
+	criticalFlowsFlag    []criticalFlow
+	enableCriticalFlows  bool
+	criticalFlows        []criticalFlow
+	criticalFlowInformer cache.SharedIndexInformer
+	// ClusterNetworkPolicies of the informer, nil unless enabled, in the order of their chain
+	clusterNetworkPolicyInformer cache.SharedIndexInformer
+	cnpLister                    cache.Indexer
+	clusterNetworkPolicies       []clusterNetworkPolicyInfo
+	// namespace/name of the cluster DNS service the pods are allowed to whatever their egress policies, empty if
+	// not allowed, and its addresses and ports resolved by the last sync
+	clusterDNSService string
+	clusterDNS        []clusterDNSEntry
+	// adds the addresses of the domain names of the network policies to their ipsets, nil unless enabled
+	fqdnSnooper *fqdnSnooper
+	// selector of the namespaces whose network policies are enforced, nil for all of them
+	namespaceScope labels.Selector
+	// namespaces whose pods get no pod firewall chain and whose network policies are not enforced, nil for none
+	excludedNamespaces map[string]bool
+	// network policies have egress-node-peers annotations, the changes of the nodes queue a full sync
+	nodePeers bool
+	// node labels named by the peer-topology annotations of the network policies, their changes queue a full sync
+	peerTopologyLabels map[string]bool
+	// build the model of the network policies without programming the node, another engine enforces them
+	observeOnly bool
+	// put all the network policies in audit mode, see podAudit
+	auditMode bool
+	// verdict of the traffic no network policy accepted, unless overridden by the namespace, defaultVerdictReject
+	// or defaultVerdictDrop
+	defaultVerdict string
+	// state programmed by the last sync, or that would have been in observe-only mode
+	renderedState nodestate.State
+
+	// hits of the allow rules of the network policies, nil unless tracked
+	policyHits *policyHits
+	// counters of the rules of the policy chains and of the default rules of the pod firewall chains exported as
+	// metrics, nil unless enabled
+	policyCounters       *chainCounters
+	podRejectCounters    *chainCounters
+	policyCountersPeriod time.Duration
+	// holds the removal of the stale chains and ipsets when the desired state shrank, nil unless enabled
+	shrinkGuard *shrinkGuard
+	// chains and ipsets of the last sync that programmed its rules, and while a removal is held, the ones of the last
+	// sync before the shrink and the rules of its dispatch chains jumping to its pod firewall chains
+	syncedRules *activeRules
+	heldRules   *activeRules
+	heldJumps   []podFwJump
+	// period of the removal of the stale chains and ipsets outside of the syncs, 0 to remove them in each sync
+	policyGCPeriod time.Duration
+	// chains and ipsets programmed by the last successful sync, nil while their removal is held or with no
+	// policyGCPeriod
+	activeRules *activeRules
+	// period of the check of the rules of the last sync against external changes, 0 to disable it
+	flushCheckPeriod time.Duration
+	// rules programmed by the last sync, nil while a sync is queued or with no flushCheckPeriod
+	programmedRules *programmedRules
+	// names of the chains of the current sync in the audit log, and the rules of the chains of the last sync by name
+	auditedChains map[string]string
+	auditedRules  map[string]auditedChain
+	// syncs requested by the events, run at most once per minSyncPeriod
+	syncQueue     *syncQueue
+	minSyncPeriod time.Duration
+	// goroutines started by Run and the syncs, Run returns once they exited
+	workers sync.WaitGroup
+	// workloads and addresses the connections seen on the node link, nil unless sampled
+	serviceGraph             *serviceGraph
+	serviceGraphSamplePeriod time.Duration
+
 	// list of all active network policies expressed as networkPolicyInfo
 	networkPoliciesInfo *[]networkPolicyInfo
 	ipSetHandler        *utils.IPSet
@@ -68,10 +246,18 @@ type NetworkPolicyController struct {
 	podLister cache.Indexer
 	npLister  cache.Indexer
 	nsLister  cache.Indexer
+	// stale chains kept renamed for a while before they are deleted
+	chainQuarantine *chainQuarantine
+
+	// syncs only read the informer caches, and are skipped until the caches completed their initial listing
+	cachesSynced []cache.InformerSynced
 
 	PodEventHandler           cache.ResourceEventHandler
 	NamespaceEventHandler     cache.ResourceEventHandler
 	NetworkPolicyEventHandler cache.ResourceEventHandler
+	// handler of the endpoints, nil unless the pods are allowed to the cluster DNS
+	EndpointsEventHandler cache.ResourceEventHandler
+	NodeEventHandler      cache.ResourceEventHandler
 }
 
 // internal structure to represent a network policy
@@ -91,6 +277,12 @@ type networkPolicyInfo struct {
 
 	// policy type "ingress" or "egress" or "both" as defined by PolicyType in the spec
 	policyType string
+
+	// the traffic the policy would drop is logged and accepted, see podAudit
+	audit bool
+
+	// domain names of the egress-fqdns annotation the pods are allowed to, only with --enable-fqdn-policies
+	egressFQDNs []string
 }
 
 // internal structure to represent Pod
@@ -99,6 +291,10 @@ type podInfo struct {
 	name      string
 	namespace string
 	labels    map[string]string
+	// host side interface of the pod, only discovered with --pod-interface-rules
+	iface string
+	// name of the priority class of the pod, which may have critical flows
+	priorityClass string
 }
 
 // internal stucture to represent NetworkPolicyIngressRule in the spec
@@ -109,6 +305,8 @@ type ingressRule struct {
 	matchAllSource bool
 	srcPods        []podInfo
 	srcIPBlocks    [][]string
+	// some of the source peers select pods by namespace selector
+	namespaceSelectorPeers bool
 }
 
 // internal structure to represent NetworkPolicyEgressRule in the spec
@@ -119,11 +317,14 @@ type egressRule struct {
 	matchAllDestinations bool
 	dstPods              []podInfo
 	dstIPBlocks          [][]string
+	// some of the destination peers select pods by namespace selector
+	namespaceSelectorPeers bool
 }
 
 type protocolAndPort struct {
 	protocol string
-	port     string
+	// destination port, or range of ports first:last
+	port string
 }
 
 type endPoints struct {
@@ -132,18 +333,92 @@ type endPoints struct {
 }
 
 type numericPort2eps map[string]*endPoints
+
+// sorted returns the endpoints by port, so the ipsets of the named ports keep their index from a sync to the next
+func (m numericPort2eps) sorted() []endPoints {
+	ports := make([]string, 0, len(m))
+	for port := range m {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	eps := make([]endPoints, 0, len(m))
+	for _, port := range ports {
+		eps = append(eps, *m[port])
+	}
+	return eps
+}
+
 type protocol2eps map[string]numericPort2eps
 type namedPort2eps map[string]protocol2eps
 
 // Run runs forver till we receive notification on stopCh
 func (npc *NetworkPolicyController) Run(healthChan chan<- *healthcheck.ControllerHeartbeat, stopCh <-chan struct{}, wg *sync.WaitGroup) {
-	t := time.NewTicker(npc.syncPeriod)
+	t := npc.Coordinator.NewTicker("NPC", npc.syncPeriod)
 	defer t.Stop()
 	defer wg.Done()
+	// a new instance taking over the node on a handover must not race a sync still programming the dataplane
+	defer npc.workers.Wait()
 
 	glog.Info("Starting network policy controller")
 	npc.healthChan = healthChan
 
+	if npc.observeOnly {
+		glog.Info("Network policy controller in observe-only mode, the network policies are not programmed")
+	} else if npc.policyBackend == policyBackendNFTables {
+		npc.cleanupIPTablesBackend()
+	} else {
+		if err := deleteNFTablesTable(); err != nil {
+			glog.Errorf("Failed to delete the table of the nftables backend: %s", err)
+		}
+		npc.appliedStateCache.ReportDrift(ReadActualState)
+		npc.probeMatches()
+		npc.goWorker(func() { npc.runJumpPositionCheck(stopCh) })
+		if npc.policyGCPeriod > 0 {
+			npc.goWorker(func() { npc.runStaleRulesGC(stopCh) })
+		}
+		if npc.flushCheckPeriod > 0 {
+			npc.goWorker(func() { npc.runFlushCheck(stopCh) })
+		}
+		if npc.policyCounters != nil {
+			npc.goWorker(func() { npc.runPolicyCounters(stopCh) })
+		}
+	}
+
+	if npc.clusterNetworkPolicyInformer != nil {
+		npc.goWorker(func() { npc.clusterNetworkPolicyInformer.Run(stopCh) })
+	}
+	if npc.isolationProfileInformer != nil {
+		npc.goWorker(func() { npc.isolationProfileInformer.Run(stopCh) })
+	}
+	if npc.clusterAllowListInformer != nil {
+		npc.goWorker(func() { npc.clusterAllowListInformer.Run(stopCh) })
+	}
+	if npc.criticalFlowInformer != nil {
+		npc.goWorker(func() { npc.criticalFlowInformer.Run(stopCh) })
+	}
+	if npc.fqdnSnooper != nil {
+		npc.goWorker(func() { npc.runFQDNSnooper(stopCh) })
+	}
+	if npc.policyReadinessSocket != "" {
+		npc.goWorker(func() { npc.servePolicyReadiness(npc.policyReadinessSocket, stopCh) })
+	}
+	if npc.policyStatus != nil {
+		npc.goWorker(func() { npc.policyStatus.run(stopCh) })
+	}
+	if npc.logDroppedTraffic || npc.dropExporter != nil || npc.dropEventMetrics || npc.denyEvents {
+		// the service and node listers are only set once the controller is created
+		resolver := newAddressResolver(npc.nodeIP.String(), npc.podLister, npc.ServiceLister, npc.NodeLister)
+		if npc.fqdnSnooper != nil {
+			resolver.fqdns = npc.fqdnSnooper.cache
+		}
+		npc.dropEvents = newDropEvents(resolver)
+		npc.goWorker(func() { npc.runDropLog(stopCh) })
+	}
+	if npc.serviceGraph != nil {
+		npc.goWorker(func() { npc.runServiceGraph(stopCh) })
+	}
+	npc.goWorker(func() { npc.runSyncQueue(stopCh) })
+
 	// loop forever till notified to stop on stopCh
 	for {
 		select {
@@ -153,24 +428,46 @@ func (npc *NetworkPolicyController) Run(healthChan chan<- *healthcheck.Controlle
 		default:
 		}
 
+		if npc.enableIsolationProfiles {
+			npc.syncIsolationProfiles()
+		}
+		if npc.enableClusterAllowLists {
+			npc.syncClusterAllowLists()
+		}
+		if len(npc.criticalFlowsFlag) != 0 || npc.enableCriticalFlows {
+			npc.syncCriticalFlows()
+		}
+
 		glog.V(1).Info("Performing periodic sync of iptables to reflect network policies")
+		release := npc.Coordinator.Acquire("NPC", utils.SyncPeriodic)
 		err := npc.Sync()
+		release()
 		if err != nil {
 			glog.Errorf("Error during periodic sync of network policies in network policy controller. Error: " + err.Error())
 			glog.Errorf("Skipping sending heartbeat from network policy controller as periodic sync failed.")
+			healthcheck.SendError(healthChan, "NPC", utils.CountError("NPC", err), err)
+			// the sync queue retries the failed sync with its backoff
+			npc.syncQueue.add(syncFull)
 		} else {
 			healthcheck.SendHeartBeat(healthChan, "NPC")
 		}
 		npc.readyForUpdates = true
-		select {
-		case <-stopCh:
+		if !npc.Governor.WaitTick(t.C, stopCh) {
 			glog.Infof("Shutting down network policies controller")
 			return
-		case <-t.C:
 		}
 	}
 }
 
+// goWorker runs f in a goroutine Run waits for before it returns
+func (npc *NetworkPolicyController) goWorker(f func()) {
+	npc.workers.Add(1)
+	go func() {
+		defer npc.workers.Done()
+		f()
+	}()
+}
+
 // OnPodUpdate handles updates to pods from the Kubernetes api server
 func (npc *NetworkPolicyController) OnPodUpdate(obj interface{}) {
 	pod := obj.(*api.Pod)
@@ -181,10 +478,7 @@ func (npc *NetworkPolicyController) OnPodUpdate(obj interface{}) {
 		return
 	}
 
-	err := npc.Sync()
-	if err != nil {
-		glog.Errorf("Error syncing network policy for the update to pod: %s/%s Error: %s", pod.Namespace, pod.Name, err)
-	}
+	npc.syncQueue.add(syncPods)
 }
 
 // OnNetworkPolicyUpdate handles updates to network policy from the kubernetes api server
@@ -196,11 +490,12 @@ func (npc *NetworkPolicyController) OnNetworkPolicyUpdate(obj interface{}) {
 		glog.V(3).Infof("Skipping update to network policy: %s/%s, controller still performing bootup full-sync", netpol.Namespace, netpol.Name)
 		return
 	}
-
-	err := npc.Sync()
-	if err != nil {
-		glog.Errorf("Error syncing network policy for the update to network policy: %s/%s Error: %s", netpol.Namespace, netpol.Name, err)
+	if !npc.enforcesNamespace(netpol.Namespace) {
+		glog.V(3).Infof("Skipping update to network policy: %s/%s, the network policies of its namespace are not enforced", netpol.Namespace, netpol.Name)
+		return
 	}
+
+	npc.syncQueue.add(syncFull)
 }
 
 // OnNamespaceUpdate handles updates to namespace from kubernetes api server
@@ -217,16 +512,12 @@ func (npc *NetworkPolicyController) OnNamespaceUpdate(obj interface{}) {
 		return
 	}
 
-	err := npc.Sync()
-	if err != nil {
-		glog.Errorf("Error syncing on namespace update: %s", err)
-	}
+	npc.syncQueue.add(syncFull)
 }
 
 // Sync synchronizes iptables to desired state of network policies
-func (npc *NetworkPolicyController) Sync() error {
+func (npc *NetworkPolicyController) Sync() (err error) {
 
-	var err error
 	npc.mu.Lock()
 	defer npc.mu.Unlock()
 
@@ -242,6 +533,15 @@ func (npc *NetworkPolicyController) Sync() error {
 	}()
 
 	glog.V(1).Infof("Starting sync of iptables with version: %s", syncVersion)
+	if !utils.CachesSynced(npc.cachesSynced...) {
+		return errors.New("Aborting sync. Informer caches are not synced yet")
+	}
+	defer func() {
+		npc.policyStatus.recordSync(npc.networkPoliciesInfo, err)
+		npc.dropEvents.recordSync(npc.networkPoliciesInfo)
+	}()
+	localPods := npc.policyReadiness.localPods(npc.podLister, npc.nodeIP.String())
+	policies := npc.networkPoliciesInfo
 	if npc.v1NetworkPolicy {
 		npc.networkPoliciesInfo, err = npc.buildNetworkPoliciesInfo()
 		if err != nil {
@@ -255,21 +555,168 @@ func (npc *NetworkPolicyController) Sync() error {
 		}
 	}
 
-	activePolicyChains, activePolicyIpSets, err := npc.syncNetworkPolicyChains(syncVersion)
-	if err != nil {
-		return errors.New("Aborting sync. Failed to sync network policy chains: " + err.Error())
+	// the rules of the last sync are kept in place, as are the policies they were built from
+	if err := npc.checkEmptyCaches(); err != nil {
+		npc.networkPoliciesInfo = policies
+		return err
+	}
+	npc.enforcePolicyLimits()
+	npc.resolveClusterDNS()
+	if err := npc.buildClusterNetworkPolicies(); err != nil {
+		return errors.New("Aborting sync. " + err.Error())
 	}
 
-	activePodFwChains, err := npc.syncPodFirewallChains(syncVersion)
-	if err != nil {
-		return errors.New("Aborting sync. Failed to sync pod firewalls: " + err.Error())
+	// pod events trigger full syncs until this one succeeded
+	npc.syncedRulesDigest = ""
+	if npc.observeOnly {
+		return npc.observe()
 	}
+	var activePolicyChains, activePodFwChains, activePolicyIpSets map[string]bool
+	failures := newPolicyFailures()
+	collisions := ipSetNameCollisions(*npc.networkPoliciesInfo)
+	if npc.policyBackend == policyBackendNFTables {
+		// the nftables backend programs the policies all at once
+		for _, err := range collisions {
+			npc.networkPoliciesInfo = policies
+			return errors.New("Aborting sync. " + err.Error())
+		}
+		activePolicyChains, activePodFwChains, activePolicyIpSets, err = npc.syncNFTables()
+		if err != nil {
+			return errors.New("Aborting sync. Failed to sync the nftables table: " + err.Error())
+		}
+	} else {
+		var ipSetEntries map[string][]string
+		if npc.flushRevokedConntrack || npc.Audit != nil {
+			ipSetEntries = npc.policyIPSetEntries()
+		}
+		// the chains of a sync failing past the restore are in use, so nothing is removed until a sync succeeds
+		npc.activeRules = nil
+		npc.programmedRules = nil
+		npc.auditedChains = nil
+		for _, policy := range *npc.networkPoliciesInfo {
+			if err, ok := collisions[policy.namespace+"/"+policy.name]; ok {
+				failures.add(policy, err)
+			}
+		}
+		filterTable := utils.NewIPTablesRestore("filter")
+		activePolicyChains, activePolicyIpSets, err = npc.syncNetworkPolicyChains(filterTable, syncVersion,
+			failures)
+		if err != nil {
+			return errors.New("Aborting sync. Failed to sync network policy chains: " + err.Error())
+		}
 
-	err = cleanupStaleRules(activePolicyChains, activePodFwChains, activePolicyIpSets)
-	if err != nil {
-		return errors.New("Aborting sync. Failed to cleanup stale iptables rules: " + err.Error())
+		if err = npc.syncClusterNetworkPolicyChain(filterTable, syncVersion, activePolicyChains,
+			activePolicyIpSets); err != nil {
+			return errors.New("Aborting sync. Failed to sync the chain of the cluster network policies: " +
+				err.Error())
+		}
+		if err = npc.syncClusterAllowListIPSet(activePolicyIpSets); err != nil {
+			return errors.New("Aborting sync. Failed to sync the ipset of the cluster allow lists: " + err.Error())
+		}
+		if err = npc.syncCriticalFlowIPSets(activePolicyIpSets); err != nil {
+			return errors.New("Aborting sync. Failed to sync the ipsets of the critical flows: " + err.Error())
+		}
+		if err = npc.syncClusterDNSIPSet(activePolicyIpSets); err != nil {
+			return errors.New("Aborting sync. Failed to sync the ipset of the cluster DNS: " + err.Error())
+		}
+		if err = npc.syncFQDNSnoopRule(); err != nil {
+			return errors.New("Aborting sync. Failed to sync the snooping of the cluster DNS: " + err.Error())
+		}
+
+		var dispatchJumps []podFwJump
+		activePodFwChains, dispatchJumps, err = npc.syncPodFirewallChains(filterTable, syncVersion)
+		if err != nil {
+			return errors.New("Aborting sync. Failed to sync pod firewalls: " + err.Error())
+		}
+		// the stale chains, their counters and the rules jumping to them are kept until the shrink of the desired
+		// state is confirmed
+		held := npc.holdStaleRules(filterTable, activePolicyChains, activePodFwChains, activePolicyIpSets)
+		if err = npc.syncDispatchJumps(filterTable, held); err != nil {
+			return errors.New("Aborting sync. Failed to sync the jumps to the dispatch chains: " + err.Error())
+		}
+		if err = npc.syncAcceptedFlowLog(filterTable); err != nil {
+			return errors.New("Aborting sync. Failed to sync accepted flow logging: " + err.Error())
+		}
+
+		if err = npc.applyPolicyFilterTable(filterTable, syncVersion, failures); err != nil {
+			return errors.New("Aborting sync. Failed to program the network policy and pod firewall chains: " +
+				err.Error())
+		}
+		npc.dispatchJumps = dispatchJumps
+		npc.syncedRules = &activeRules{policyChains: activePolicyChains, podFwChains: activePodFwChains,
+			policyIPSets: activePolicyIpSets}
+		npc.auditChainRules(filterTable)
+
+		if held {
+			npc.activeRules = nil
+			// the chains of the syncs held before this one are superseded by its own
+			if npc.heldRules != nil {
+				err = npc.cleanupStaleRules(npc.heldRules.union(activePolicyChains, activePodFwChains,
+					activePolicyIpSets))
+				if err != nil {
+					return errors.New("Aborting sync. Failed to cleanup superseded iptables rules: " + err.Error())
+				}
+			}
+		} else if npc.policyGCPeriod > 0 {
+			npc.activeRules = &activeRules{policyChains: activePolicyChains, podFwChains: activePodFwChains,
+				policyIPSets: activePolicyIpSets}
+		} else {
+			if npc.MetricsEnabled || npc.policyHits != nil {
+				if err := npc.exportStaleChainCounters(activePolicyChains, activePodFwChains); err != nil {
+					glog.Errorf("Failed to export the counters of network policy chains: %s", err)
+				}
+			}
+
+			err = npc.cleanupStaleRules(activePolicyChains, activePodFwChains, activePolicyIpSets)
+			if err != nil {
+				return errors.New("Aborting sync. Failed to cleanup stale iptables rules: " + err.Error())
+			}
+		}
+		if npc.flushRevokedConntrack {
+			npc.flushRevokedConnections(ipSetEntries)
+		}
+		npc.auditIPSets(ipSetEntries)
+		if npc.flushCheckPeriod > 0 {
+			npc.recordProgrammedRules(activePolicyChains, activePodFwChains)
+		}
+	}
+	if npc.exportIPSets {
+		npc.ipSetManifest = ipSetManifest(policyIPSets(*npc.networkPoliciesInfo), activePolicyIpSets)
 	}
 
+	if npc.policyBackend == policyBackendIPTables {
+		appliedState, err := npc.renderState()
+		if err == nil {
+			npc.recordRenderedState(appliedState)
+			err = npc.appliedStateCache.Save(appliedState)
+		}
+		if err != nil {
+			glog.Errorf("Failed to persist the applied state: %s", err)
+		}
+	}
+
+	npc.recordSyncedCaches()
+	// pod events trigger full syncs, which sync the failed policies again, until a sync has none. The pods are only
+	// reported ready, and the post sync hook only run, once all the policies are enforced.
+	if len(failures.failed) != 0 {
+		return failures.err()
+	}
+	npc.recordSyncedRules()
+	npc.policyReadiness.synced(localPods)
+	npc.postSyncHook.Run(utils.SyncSummary{
+		Controller: "NPC",
+		Node:       npc.nodeHostName,
+		Timestamp:  time.Now(),
+		Duration:   time.Since(start).Seconds(),
+		Details: map[string]interface{}{
+			"version":         syncVersion,
+			"networkPolicies": len(*npc.networkPoliciesInfo),
+			"podFirewalls":    len(activePodFwChains),
+			"policyChains":    len(activePolicyChains),
+			"ipsets":          len(activePolicyIpSets),
+		},
+	})
+
 	return nil
 }
 
@@ -277,8 +724,11 @@ func (npc *NetworkPolicyController) Sync() error {
 // network policy spec podselector labels are grouped together in one ipset which
 // is used for matching destination ip address. Each ingress rule in the network
 // policyspec is evaluated to set of matching pods, which are grouped in to a
-// ipset used for source ip addr matching.
-func (npc *NetworkPolicyController) syncNetworkPolicyChains(version string) (map[string]bool, map[string]bool, error) {
+// ipset used for source ip addr matching. The ipsets are programmed right away, the
+// chains are added to filterTable, applied once the pod firewall chains are added too.
+// The policies failing to sync are recorded in failures, and their chains left without rules.
+func (npc *NetworkPolicyController) syncNetworkPolicyChains(filterTable *utils.IPTablesRestore, version string,
+	failures *policyFailures) (map[string]bool, map[string]bool, error) {
 	start := time.Now()
 	defer func() {
 		endTime := time.Since(start)
@@ -287,73 +737,121 @@ func (npc *NetworkPolicyController) syncNetworkPolicyChains(version string) (map
 	}()
 	activePolicyChains := make(map[string]bool)
 	activePolicyIpSets := make(map[string]bool)
-
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		glog.Fatalf("Failed to initialize iptables executor due to: %s", err.Error())
+	if npc.MetricsEnabled {
+		npc.policyChainRules = make(map[string]int)
 	}
+	fqdnIPSets := make([]fqdnIPSet, 0)
 
 	// run through all network policies
 	for _, policy := range *npc.networkPoliciesInfo {
+		policyStart := time.Now()
 
 		// ensure there is a unique chain per network policy in filter table
 		policyChainName := networkPolicyChainName(policy.namespace, policy.name, version)
-		err := iptablesCmdHandler.NewChain("filter", policyChainName)
-		if err != nil && err.(*iptables.Error).ExitStatus() != 1 {
-			return nil, nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
-
+		filterTable.NewChain(policyChainName)
 		activePolicyChains[policyChainName] = true
-
-		currnetPodIps := make([]string, 0, len(policy.targetPods))
-		for ip := range policy.targetPods {
-			currnetPodIps = append(currnetPodIps, ip)
+		npc.auditChain(policyChainName, "network policy "+policy.namespace+"/"+policy.name)
+		if npc.MetricsEnabled || npc.policyHits != nil {
+			npc.policyChainOwners[policyChainName] = chainOwner{namespace: policy.namespace, name: policy.name}
 		}
 
-		if policy.policyType == "both" || policy.policyType == "ingress" {
-			// create a ipset for all destination pod ip's matched by the policy spec PodSelector
-			targetDestPodIpSetName := policyDestinationPodIpSetName(policy.namespace, policy.name)
-			targetDestPodIpSet, err := npc.ipSetHandler.Create(targetDestPodIpSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to create ipset: %s", err.Error())
-			}
-			err = targetDestPodIpSet.Refresh(currnetPodIps, utils.OptionTimeout, "0")
-			if err != nil {
-				glog.Errorf("failed to refresh targetDestPodIpSet,: " + err.Error())
-			}
-			err = npc.processIngressRules(policy, targetDestPodIpSetName, activePolicyIpSets, version)
-			if err != nil {
-				return nil, nil, err
-			}
-			activePolicyIpSets[targetDestPodIpSet.Name] = true
+		// the chain of a policy already failed, e.g. as its ipset names collide with another policy, stays without
+		// rules
+		if failures.has(policy) {
+			continue
 		}
-
-		if policy.policyType == "both" || policy.policyType == "egress" {
-			// create a ipset for all source pod ip's matched by the policy spec PodSelector
-			targetSourcePodIpSetName := policySourcePodIpSetName(policy.namespace, policy.name)
-			targetSourcePodIpSet, err := npc.ipSetHandler.Create(targetSourcePodIpSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to create ipset: %s", err.Error())
-			}
-			err = targetSourcePodIpSet.Refresh(currnetPodIps, utils.OptionTimeout, "0")
-			if err != nil {
-				glog.Errorf("failed to refresh targetSourcePodIpSet: " + err.Error())
-			}
-			err = npc.processEgressRules(policy, targetSourcePodIpSetName, activePolicyIpSets, version)
-			if err != nil {
-				return nil, nil, err
+		fqdnIPSet, err := npc.syncNetworkPolicyChain(filterTable, policy, policyChainName, activePolicyIpSets,
+			version)
+		if err != nil {
+			// the chain stays, without rules, so its pods are denied the traffic the policy allows
+			filterTable.FlushChain(policyChainName)
+			if npc.policyChainRules != nil {
+				delete(npc.policyChainRules, policyChainName)
 			}
-			activePolicyIpSets[targetSourcePodIpSet.Name] = true
+			failures.add(policy, err)
+			continue
+		}
+		if fqdnIPSet != nil {
+			fqdnIPSets = append(fqdnIPSets, *fqdnIPSet)
 		}
 
+		if npc.MetricsEnabled {
+			namespace, name := npc.tenantLabels.Values(policy.namespace, policy.name)
+			metrics.ControllerPolicySyncTime.WithLabelValues(namespace, name).Observe(time.Since(policyStart).Seconds())
+		}
+	}
+	if npc.MetricsEnabled {
+		exportPolicyRuleCounts(npc.policyChainRules, npc.policyChainOwners, npc.tenantLabels)
+	}
+	if newFQDNIPSets := npc.fqdnSnooper.setIPSets(fqdnIPSets); len(newFQDNIPSets) > 0 {
+		server := npc.clusterDNSServer()
+		npc.goWorker(func() { npc.fqdnSnooper.seed(newFQDNIPSets, server) })
 	}
 
-	glog.V(2).Infof("Iptables chains in the filter table are synchronized with the network policies.")
+	glog.V(2).Infof("Iptables chains in the filter table are rendered for the network policies.")
 
 	return activePolicyChains, activePolicyIpSets, nil
 }
 
-func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo,
+// syncNetworkPolicyChain programs the ipsets of the policy and adds the rules of its chain to filterTable, and returns
+// the ipset of its domain names, if any
+func (npc *NetworkPolicyController) syncNetworkPolicyChain(filterTable *utils.IPTablesRestore, policy networkPolicyInfo,
+	policyChainName string, activePolicyIpSets map[string]bool, version string) (*fqdnIPSet, error) {
+	if args := npc.sampleRuleArgs(policy); args != nil {
+		filterTable.AppendUnique(policyChainName, args...)
+	}
+
+	currnetPodIps := make([]string, 0, len(policy.targetPods))
+	for ip := range policy.targetPods {
+		currnetPodIps = append(currnetPodIps, ip)
+	}
+
+	if policy.policyType == "both" || policy.policyType == "ingress" {
+		// create a ipset for all destination pod ip's matched by the policy spec PodSelector
+		targetDestPodIpSetName := policyDestinationPodIpSetName(policy.namespace, policy.name)
+		targetDestPodIpSet, err := npc.ipSetHandler.Create(targetDestPodIpSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ipset: %s", err.Error())
+		}
+		err = targetDestPodIpSet.Refresh(currnetPodIps, utils.OptionTimeout, "0")
+		if err != nil {
+			glog.Errorf("failed to refresh targetDestPodIpSet,: " + err.Error())
+		}
+		err = npc.processIngressRules(filterTable, policy, targetDestPodIpSetName, activePolicyIpSets, version)
+		if err != nil {
+			return nil, err
+		}
+		activePolicyIpSets[targetDestPodIpSet.Name] = true
+	}
+
+	if policy.policyType == "both" || policy.policyType == "egress" {
+		// create a ipset for all source pod ip's matched by the policy spec PodSelector
+		targetSourcePodIpSetName := policySourcePodIpSetName(policy.namespace, policy.name)
+		targetSourcePodIpSet, err := npc.ipSetHandler.Create(targetSourcePodIpSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ipset: %s", err.Error())
+		}
+		err = targetSourcePodIpSet.Refresh(currnetPodIps, utils.OptionTimeout, "0")
+		if err != nil {
+			glog.Errorf("failed to refresh targetSourcePodIpSet: " + err.Error())
+		}
+		err = npc.processEgressRules(filterTable, policy, targetSourcePodIpSetName, activePolicyIpSets, version)
+		if err != nil {
+			return nil, err
+		}
+		fqdnIPSet, err := npc.syncPolicyFQDNRule(filterTable, policy, targetSourcePodIpSetName,
+			activePolicyIpSets, version)
+		if err != nil {
+			return nil, err
+		}
+		activePolicyIpSets[targetSourcePodIpSet.Name] = true
+		return fqdnIPSet, nil
+	}
+
+	return nil, nil
+}
+
+func (npc *NetworkPolicyController) processIngressRules(filterTable *utils.IPTablesRestore, policy networkPolicyInfo,
 	targetDestPodIpSetName string, activePolicyIpSets map[string]bool, version string) error {
 
 	// From network policy spec: "If field 'Ingress' is empty then this NetworkPolicy does not allow any traffic "
@@ -362,30 +860,24 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 		return nil
 	}
 
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		return fmt.Errorf("Failed to initialize iptables executor due to: %s", err.Error())
-	}
-
 	policyChainName := networkPolicyChainName(policy.namespace, policy.name, version)
+	comments := newPolicyRuleComments(policy)
 
 	// run through all the ingress rules in the spec and create iptables rules
 	// in the chain for the network policy
 	for i, ingressRule := range policy.ingressRules {
 
-		if len(ingressRule.srcPods) != 0 {
-			srcPodIpSetName := policyIndexedSourcePodIpSetName(policy.namespace, policy.name, i)
-			srcPodIpSet, err := npc.ipSetHandler.Create(srcPodIpSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
+		if npc.keepPeerPodIPSet(ingressRule.srcPods, ingressRule.namespaceSelectorPeers) {
+			srcPodIpSetName, setType, ingressRuleSrcPodIps := npc.peerPodIPSet(
+				policyIndexedSourcePodIpSetName(policy.namespace, policy.name, i),
+				policyIndexedSourcePodNetIpSetName(policy.namespace, policy.name, i), ingressRule.srcPods)
+			srcPodIpSet, err := npc.ipSetHandler.Create(srcPodIpSetName, setType, utils.OptionTimeout, "0")
 			if err != nil {
 				return fmt.Errorf("failed to create ipset: %s", err.Error())
 			}
 
 			activePolicyIpSets[srcPodIpSet.Name] = true
 
-			ingressRuleSrcPodIps := make([]string, 0, len(ingressRule.srcPods))
-			for _, pod := range ingressRule.srcPods {
-				ingressRuleSrcPodIps = append(ingressRuleSrcPodIps, pod.ip)
-			}
 			err = srcPodIpSet.Refresh(ingressRuleSrcPodIps, utils.OptionTimeout, "0")
 			if err != nil {
 				glog.Errorf("failed to refresh srcPodIpSet: " + err.Error())
@@ -395,9 +887,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 				// case where 'ports' details and 'from' details specified in the ingress rule
 				// so match on specified source and destination ip's and specified port (if any) and protocol
 				for _, portProtocol := range ingressRule.ports {
-					comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, srcPodIpSetName, targetDestPodIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
+					comment := comments.sourcePods
+					if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, srcPodIpSetName, targetDestPodIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
 						return err
 					}
 				}
@@ -415,9 +906,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 					if err != nil {
 						glog.Errorf("failed to refresh namedPortIpSet: " + err.Error())
 					}
-					comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, srcPodIpSetName, namedPortIpSetName, endPoints.protocol, endPoints.port); err != nil {
+					comment := comments.sourcePods
+					if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, srcPodIpSetName, namedPortIpSetName, endPoints.protocol, endPoints.port); err != nil {
 						return err
 					}
 				}
@@ -426,9 +916,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 			if len(ingressRule.ports) == 0 && len(ingressRule.namedPorts) == 0 {
 				// case where no 'ports' details specified in the ingress rule but 'from' details specified
 				// so match on specified source and destination ip with all port and protocol
-				comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, srcPodIpSetName, targetDestPodIpSetName, "", ""); err != nil {
+				comment := comments.sourcePods
+				if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, srcPodIpSetName, targetDestPodIpSetName, "", ""); err != nil {
 					return err
 				}
 			}
@@ -438,9 +927,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 		// so match on all sources, with specified port (if any) and protocol
 		if ingressRule.matchAllSource && !ingressRule.matchAllPorts {
 			for _, portProtocol := range ingressRule.ports {
-				comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, "", targetDestPodIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
+				comment := comments.allSources
+				if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, "", targetDestPodIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
 					return err
 				}
 			}
@@ -458,9 +946,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 				if err != nil {
 					glog.Errorf("failed to refresh namedPortIpSet: " + err.Error())
 				}
-				comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, "", namedPortIpSetName, endPoints.protocol, endPoints.port); err != nil {
+				comment := comments.allSources
+				if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, "", namedPortIpSetName, endPoints.protocol, endPoints.port); err != nil {
 					return err
 				}
 			}
@@ -469,9 +956,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 		// case where nether ports nor from details are speified in the ingress rule
 		// so match on all ports, protocol, source IP's
 		if ingressRule.matchAllSource && ingressRule.matchAllPorts {
-			comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
-				policy.name + " namespace " + policy.namespace
-			if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, "", targetDestPodIpSetName, "", ""); err != nil {
+			comment := comments.allSources
+			if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, "", targetDestPodIpSetName, "", ""); err != nil {
 				return err
 			}
 		}
@@ -489,9 +975,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 			}
 			if !ingressRule.matchAllPorts {
 				for _, portProtocol := range ingressRule.ports {
-					comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, srcIpBlockIpSetName, targetDestPodIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
+					comment := comments.sourceIPBlocks
+					if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, srcIpBlockIpSetName, targetDestPodIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
 						return err
 					}
 				}
@@ -509,17 +994,15 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 					if err != nil {
 						glog.Errorf("failed to refresh namedPortIpSet: " + err.Error())
 					}
-					comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, srcIpBlockIpSetName, namedPortIpSetName, endPoints.protocol, endPoints.port); err != nil {
+					comment := comments.sourceIPBlocks
+					if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, srcIpBlockIpSetName, namedPortIpSetName, endPoints.protocol, endPoints.port); err != nil {
 						return err
 					}
 				}
 			}
 			if ingressRule.matchAllPorts {
-				comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, srcIpBlockIpSetName, targetDestPodIpSetName, "", ""); err != nil {
+				comment := comments.sourceIPBlocks
+				if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, srcIpBlockIpSetName, targetDestPodIpSetName, "", ""); err != nil {
 					return err
 				}
 			}
@@ -529,7 +1012,7 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 	return nil
 }
 
-func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
+func (npc *NetworkPolicyController) processEgressRules(filterTable *utils.IPTablesRestore, policy networkPolicyInfo,
 	targetSourcePodIpSetName string, activePolicyIpSets map[string]bool, version string) error {
 
 	// From network policy spec: "If field 'Ingress' is empty then this NetworkPolicy does not allow any traffic "
@@ -538,30 +1021,24 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 		return nil
 	}
 
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		return fmt.Errorf("Failed to initialize iptables executor due to: %s", err.Error())
-	}
-
 	policyChainName := networkPolicyChainName(policy.namespace, policy.name, version)
+	comments := newPolicyRuleComments(policy)
 
 	// run through all the egress rules in the spec and create iptables rules
 	// in the chain for the network policy
 	for i, egressRule := range policy.egressRules {
 
-		if len(egressRule.dstPods) != 0 {
-			dstPodIpSetName := policyIndexedDestinationPodIpSetName(policy.namespace, policy.name, i)
-			dstPodIpSet, err := npc.ipSetHandler.Create(dstPodIpSetName, utils.TypeHashIP, utils.OptionTimeout, "0")
+		if npc.keepPeerPodIPSet(egressRule.dstPods, egressRule.namespaceSelectorPeers) {
+			dstPodIpSetName, setType, egressRuleDstPodIps := npc.peerPodIPSet(
+				policyIndexedDestinationPodIpSetName(policy.namespace, policy.name, i),
+				policyIndexedDestinationPodNetIpSetName(policy.namespace, policy.name, i), egressRule.dstPods)
+			dstPodIpSet, err := npc.ipSetHandler.Create(dstPodIpSetName, setType, utils.OptionTimeout, "0")
 			if err != nil {
 				return fmt.Errorf("failed to create ipset: %s", err.Error())
 			}
 
 			activePolicyIpSets[dstPodIpSet.Name] = true
 
-			egressRuleDstPodIps := make([]string, 0, len(egressRule.dstPods))
-			for _, pod := range egressRule.dstPods {
-				egressRuleDstPodIps = append(egressRuleDstPodIps, pod.ip)
-			}
 			err = dstPodIpSet.Refresh(egressRuleDstPodIps, utils.OptionTimeout, "0")
 			if err != nil {
 				glog.Errorf("failed to refresh dstPodIpSet: " + err.Error())
@@ -570,9 +1047,8 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 				// case where 'ports' details and 'from' details specified in the egress rule
 				// so match on specified source and destination ip's and specified port (if any) and protocol
 				for _, portProtocol := range egressRule.ports {
-					comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, targetSourcePodIpSetName, dstPodIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
+					comment := comments.sourcePods
+					if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, targetSourcePodIpSetName, dstPodIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
 						return err
 					}
 				}
@@ -592,9 +1068,8 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 					if err != nil {
 						glog.Errorf("failed to refresh namedPortIpSet: " + err.Error())
 					}
-					comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, targetSourcePodIpSetName, namedPortIpSetName, endPoints.protocol, endPoints.port); err != nil {
+					comment := comments.sourcePods
+					if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, targetSourcePodIpSetName, namedPortIpSetName, endPoints.protocol, endPoints.port); err != nil {
 						return err
 					}
 				}
@@ -604,9 +1079,8 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 			if len(egressRule.ports) == 0 && len(egressRule.namedPorts) == 0 {
 				// case where no 'ports' details specified in the ingress rule but 'from' details specified
 				// so match on specified source and destination ip with all port and protocol
-				comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, targetSourcePodIpSetName, dstPodIpSetName, "", ""); err != nil {
+				comment := comments.sourcePods
+				if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, targetSourcePodIpSetName, dstPodIpSetName, "", ""); err != nil {
 					return err
 				}
 			}
@@ -616,9 +1090,8 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 		// so match on all sources, with specified port (if any) and protocol
 		if egressRule.matchAllDestinations && !egressRule.matchAllPorts {
 			for _, portProtocol := range egressRule.ports {
-				comment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, targetSourcePodIpSetName, "", portProtocol.protocol, portProtocol.port); err != nil {
+				comment := comments.allDestinations
+				if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, targetSourcePodIpSetName, "", portProtocol.protocol, portProtocol.port); err != nil {
 					return err
 				}
 			}
@@ -627,9 +1100,8 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 		// case where nether ports nor from details are speified in the egress rule
 		// so match on all ports, protocol, source IP's
 		if egressRule.matchAllDestinations && egressRule.matchAllPorts {
-			comment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
-				policy.name + " namespace " + policy.namespace
-			if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, targetSourcePodIpSetName, "", "", ""); err != nil {
+			comment := comments.allDestinations
+			if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, targetSourcePodIpSetName, "", "", ""); err != nil {
 				return err
 			}
 		}
@@ -646,17 +1118,15 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 			}
 			if !egressRule.matchAllPorts {
 				for _, portProtocol := range egressRule.ports {
-					comment := "rule to ACCEPT traffic from source pods to specified ipBlocks selected by policy name: " +
-						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, targetSourcePodIpSetName, dstIpBlockIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
+					comment := comments.destinationIPBlocks
+					if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, targetSourcePodIpSetName, dstIpBlockIpSetName, portProtocol.protocol, portProtocol.port); err != nil {
 						return err
 					}
 				}
 			}
 			if egressRule.matchAllPorts {
-				comment := "rule to ACCEPT traffic from source pods to specified ipBlocks selected by policy name: " +
-					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(iptablesCmdHandler, policyChainName, comment, targetSourcePodIpSetName, dstIpBlockIpSetName, "", ""); err != nil {
+				comment := comments.destinationIPBlocks
+				if err := npc.appendRuleToPolicyChain(filterTable, policyChainName, comment, targetSourcePodIpSetName, dstIpBlockIpSetName, "", ""); err != nil {
 					return err
 				}
 			}
@@ -665,47 +1135,39 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 	return nil
 }
 
-func (npc *NetworkPolicyController) appendRuleToPolicyChain(iptablesCmdHandler *iptables.IPTables, policyChainName, comment, srcIpSetName, dstIpSetName, protocol, dPort string) error {
-	if iptablesCmdHandler == nil {
-		return fmt.Errorf("Failed to run iptables command: iptablesCmdHandler is nil")
-	}
-	args := make([]string, 0)
-	if comment != "" {
-		args = append(args, "-m", "comment", "--comment", comment)
-	}
-	if srcIpSetName != "" {
-		args = append(args, "-m", "set", "--match-set", srcIpSetName, "src")
-	}
-	if dstIpSetName != "" {
-		args = append(args, "-m", "set", "--match-set", dstIpSetName, "dst")
+func (npc *NetworkPolicyController) appendRuleToPolicyChain(filterTable *utils.IPTablesRestore, policyChainName, comment, srcIpSetName, dstIpSetName, protocol, dPort string) error {
+	if filterTable == nil {
+		return fmt.Errorf("Failed to add rule to chain %s: no iptables-restore input", policyChainName)
 	}
-	if protocol != "" {
-		args = append(args, "-p", protocol)
-	}
-	if dPort != "" {
-		args = append(args, "--dport", dPort)
-	}
-	args = append(args, "-j", "ACCEPT")
-	err := iptablesCmdHandler.AppendUnique("filter", policyChainName, args...)
-	if err != nil {
-		return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+	buf := ruleArgsPool.Get().(*[]string)
+	args := policyRuleArgs((*buf)[:0], comment, srcIpSetName, dstIpSetName, protocol, dPort)
+	filterTable.AppendUnique(policyChainName, args...)
+	// the rule is rendered right away, the buffer can be reused
+	*buf = args
+	ruleArgsPool.Put(buf)
+	if npc.policyChainRules != nil {
+		npc.policyChainRules[policyChainName]++
 	}
 	return nil
 }
 
-func (npc *NetworkPolicyController) syncPodFirewallChains(version string) (map[string]bool, error) {
+// syncPodFirewallChains adds the pod firewall chains of the pods of the node to filterTable, along with the dispatch
+// chains jumping to them, and returns the active chains and the jumps
+func (npc *NetworkPolicyController) syncPodFirewallChains(filterTable *utils.IPTablesRestore, version string) (map[string]bool, []podFwJump, error) {
 
 	activePodFwChains := make(map[string]bool)
 
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		glog.Fatalf("Failed to initialize iptables executor: %s", err.Error())
+	var podIfaces map[string]string
+	if npc.podInterfaceRules {
+		podIfaces = npc.podInterfaces()
 	}
+	// the rules jumping to the pod firewall chains
+	jumps := make([]podFwJump, 0)
 
 	// loop through the pods running on the node which to which ingress network policies to be applied
 	ingressNetworkPolicyEnabledPods, err := npc.getIngressNetworkPolicyEnabledPods(npc.nodeIP.String())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	for _, pod := range *ingressNetworkPolicyEnabledPods {
 
@@ -717,127 +1179,72 @@ func (npc *NetworkPolicyController) syncPodFirewallChains(version string) (map[s
 
 		// ensure pod specific firewall chain exist for all the pods that need ingress firewall
 		podFwChainName := podFirewallChainName(pod.namespace, pod.name, version)
-		err = iptablesCmdHandler.NewChain("filter", podFwChainName)
-		if err != nil && err.(*iptables.Error).ExitStatus() != 1 {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
+		filterTable.NewChain(podFwChainName)
 		activePodFwChains[podFwChainName] = true
+		npc.auditChain(podFwChainName, "pod "+pod.namespace+"/"+pod.name)
+		if npc.MetricsEnabled {
+			npc.podFwChainOwners[podFwChainName] = chainOwner{namespace: pod.namespace, name: pod.name}
+		}
 
 		// add entries in pod firewall to run through required network policies
+		audit := npc.podAudit(pod.ip)
 		for _, policy := range *npc.networkPoliciesInfo {
-			if _, ok := policy.targetPods[pod.ip]; ok {
+			if _, ok := policy.targetPods[pod.ip]; ok && audit.runsThrough(policy) {
 				comment := "run through nw policy " + policy.name
 				policyChainName := networkPolicyChainName(policy.namespace, policy.name, version)
-				args := []string{"-m", "comment", "--comment", comment, "-j", policyChainName}
-				exists, err := iptablesCmdHandler.Exists("filter", podFwChainName, args...)
-				if err != nil {
-					return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-				}
-				if !exists {
-					err := iptablesCmdHandler.Insert("filter", podFwChainName, 1, args...)
-					if err != nil && err.(*iptables.Error).ExitStatus() != 1 {
-						return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-					}
-				}
+				filterTable.InsertUnique(podFwChainName, "-m", "comment", "--comment", comment, "-j", policyChainName)
 			}
 		}
 
 		comment := "rule to permit the traffic traffic to pods when source is the pod's local node"
-		args := []string{"-m", "comment", "--comment", comment, "-m", "addrtype", "--src-type", "LOCAL", "-d", pod.ip, "-j", "ACCEPT"}
-		exists, err := iptablesCmdHandler.Exists("filter", podFwChainName, args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
-		if !exists {
-			err := iptablesCmdHandler.Insert("filter", podFwChainName, 1, args...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-			}
+		args := append([]string{"-m", "comment", "--comment", comment}, npc.localSourceArgs()...)
+		args = append(args, "-d", pod.ip, "-j", "ACCEPT")
+		filterTable.InsertUnique(podFwChainName, args...)
+
+		// permit the traffic of the cluster allow lists whatever the network policies
+		if args = npc.clusterAllowListRuleArgs(pod.ip); args != nil {
+			filterTable.InsertUnique(podFwChainName, args...)
 		}
 
-		// ensure statefull firewall, that permits return traffic for the traffic originated by the pod
-		comment = "rule for stateful firewall for pod"
-		args = []string{"-m", "comment", "--comment", comment, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
-		exists, err = iptablesCmdHandler.Exists("filter", podFwChainName, args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
+		// run through the cluster network policies ahead of the network policies and the cluster allow lists
+		if args = npc.clusterNetworkPolicyJumpArgs(version); args != nil {
+			filterTable.InsertUnique(podFwChainName, args...)
 		}
-		if !exists {
-			err := iptablesCmdHandler.Insert("filter", podFwChainName, 1, args...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-			}
+
+		// permit the critical flows ahead of all the policies
+		for _, args := range npc.criticalFlowRuleArgs("ingress", pod) {
+			filterTable.InsertUnique(podFwChainName, args...)
 		}
 
+		// ensure statefull firewall, that permits return traffic for the traffic originated by the pod
+		npc.insertStatefulRules(filterTable, podFwChainName)
+
 		// ensure there is rule in filter table and FORWARD chain to jump to pod specific firewall chain
 		// this rule applies to the traffic getting routed (coming for other node pods)
 		comment = "rule to jump traffic destined to POD name:" + pod.name + " namespace: " + pod.namespace +
 			" to chain " + podFwChainName
 		args = []string{"-m", "comment", "--comment", comment, "-d", pod.ip, "-j", podFwChainName}
-		exists, err = iptablesCmdHandler.Exists("filter", "FORWARD", args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
-		if !exists {
-			err := iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-			}
-		}
+		jumps = append(jumps, newPodFwJump("FORWARD", args))
 
 		// ensure there is rule in filter table and OUTPUT chain to jump to pod specific firewall chain
 		// this rule applies to the traffic from a pod getting routed back to another pod on same node by service proxy
-		exists, err = iptablesCmdHandler.Exists("filter", "OUTPUT", args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
-		if !exists {
-			err := iptablesCmdHandler.Insert("filter", "OUTPUT", 1, args...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-			}
-		}
+		jumps = append(jumps, newPodFwJump("OUTPUT", args))
 
 		// ensure there is rule in filter table and forward chain to jump to pod specific firewall chain
-		// this rule applies to the traffic getting switched (coming for same node pods)
+		// this rule applies to the traffic getting switched or routed between the pods of the node
 		comment = "rule to jump traffic destined to POD name:" + pod.name + " namespace: " + pod.namespace +
 			" to chain " + podFwChainName
-		args = []string{"-m", "physdev", "--physdev-is-bridged",
-			"-m", "comment", "--comment", comment,
-			"-d", pod.ip,
-			"-j", podFwChainName}
-		exists, err = iptablesCmdHandler.Exists("filter", "FORWARD", args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
-		if !exists {
-			err = iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-			}
-		}
-
-		// add rule to log the packets that will be dropped due to network policy enforcement
-		comment = "rule to log dropped traffic POD name:" + pod.name + " namespace: " + pod.namespace
-		args = []string{"-m", "comment", "--comment", comment, "-j", "NFLOG", "--nflog-group", "100", "-m", "limit", "--limit", "10/minute", "--limit-burst", "10"}
-		err = iptablesCmdHandler.AppendUnique("filter", podFwChainName, args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
+		if args = npc.localPodJumpArgs(comment, "-d", pod.withInterface(podIfaces), podFwChainName); args != nil {
+			jumps = append(jumps, newPodFwJump("FORWARD", args))
 		}
 
-		// add default DROP rule at the end of chain
-		comment = "default rule to REJECT traffic destined for POD name:" + pod.name + " namespace: " + pod.namespace
-		args = []string{"-m", "comment", "--comment", comment, "-j", "REJECT"}
-		err = iptablesCmdHandler.AppendUnique("filter", podFwChainName, args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
+		npc.appendPodFwDropRules(filterTable, pod, podFwChainName)
 	}
 
 	// loop through the pods running on the node which egress network policies to be applied
 	egressNetworkPolicyEnabledPods, err := npc.getEgressNetworkPolicyEnabledPods(npc.nodeIP.String())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	for _, pod := range *egressNetworkPolicyEnabledPods {
 
@@ -849,45 +1256,42 @@ func (npc *NetworkPolicyController) syncPodFirewallChains(version string) (map[s
 
 		// ensure pod specific firewall chain exist for all the pods that need egress firewall
 		podFwChainName := podFirewallChainName(pod.namespace, pod.name, version)
-		err = iptablesCmdHandler.NewChain("filter", podFwChainName)
-		if err != nil && err.(*iptables.Error).ExitStatus() != 1 {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
+		filterTable.NewChain(podFwChainName)
 		activePodFwChains[podFwChainName] = true
+		npc.auditChain(podFwChainName, "pod "+pod.namespace+"/"+pod.name)
+		if npc.MetricsEnabled {
+			npc.podFwChainOwners[podFwChainName] = chainOwner{namespace: pod.namespace, name: pod.name}
+		}
 
 		// add entries in pod firewall to run through required network policies
+		audit := npc.podAudit(pod.ip)
 		for _, policy := range *npc.networkPoliciesInfo {
-			if _, ok := policy.targetPods[pod.ip]; ok {
+			if _, ok := policy.targetPods[pod.ip]; ok && audit.runsThrough(policy) {
 				comment := "run through nw policy " + policy.name
 				policyChainName := networkPolicyChainName(policy.namespace, policy.name, version)
-				args := []string{"-m", "comment", "--comment", comment, "-j", policyChainName}
-				exists, err := iptablesCmdHandler.Exists("filter", podFwChainName, args...)
-				if err != nil {
-					return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-				}
-				if !exists {
-					err := iptablesCmdHandler.Insert("filter", podFwChainName, 1, args...)
-					if err != nil && err.(*iptables.Error).ExitStatus() != 1 {
-						return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-					}
-				}
+				filterTable.InsertUnique(podFwChainName, "-m", "comment", "--comment", comment, "-j", policyChainName)
 			}
 		}
 
-		// ensure statefull firewall, that permits return traffic for the traffic originated by the pod
-		comment := "rule for stateful firewall for pod"
-		args := []string{"-m", "comment", "--comment", comment, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
-		exists, err := iptablesCmdHandler.Exists("filter", podFwChainName, args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
+		// permit the traffic to the cluster DNS whatever the network policies
+		if args := npc.clusterDNSRuleArgs(pod.ip); args != nil {
+			filterTable.InsertUnique(podFwChainName, args...)
 		}
-		if !exists {
-			err := iptablesCmdHandler.Insert("filter", podFwChainName, 1, args...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-			}
+
+		// run through the cluster network policies ahead of the network policies and the cluster DNS
+		if args := npc.clusterNetworkPolicyJumpArgs(version); args != nil {
+			filterTable.InsertUnique(podFwChainName, args...)
+		}
+
+		// permit the critical flows ahead of all the policies
+		for _, args := range npc.criticalFlowRuleArgs("egress", pod) {
+			filterTable.InsertUnique(podFwChainName, args...)
 		}
 
+		// ensure statefull firewall, that permits return traffic for the traffic originated by the pod
+		npc.insertStatefulRules(filterTable, podFwChainName)
+
+		var comment string
 		egressFilterChains := []string{"FORWARD", "OUTPUT", "INPUT"}
 		for _, chain := range egressFilterChains {
 			// ensure there is rule in filter table and FORWARD chain to jump to pod specific firewall chain
@@ -895,59 +1299,90 @@ func (npc *NetworkPolicyController) syncPodFirewallChains(version string) (map[s
 			// to pod on a different node)
 			comment = "rule to jump traffic from POD name:" + pod.name + " namespace: " + pod.namespace +
 				" to chain " + podFwChainName
-			args = []string{"-m", "comment", "--comment", comment, "-s", pod.ip, "-j", podFwChainName}
-			exists, err = iptablesCmdHandler.Exists("filter", chain, args...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-			}
-			if !exists {
-				err := iptablesCmdHandler.Insert("filter", chain, 1, args...)
-				if err != nil {
-					return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-				}
+			args := []string{"-m", "comment", "--comment", comment, "-s", pod.ip, "-j", podFwChainName}
+			if chain == "INPUT" {
+				args = egressInputChainJumpArgs(comment, pod.ip, podFwChainName)
 			}
+			jumps = append(jumps, newPodFwJump(chain, args))
 		}
 
 		// ensure there is rule in filter table and forward chain to jump to pod specific firewall chain
-		// this rule applies to the traffic getting switched (coming for same node pods)
+		// this rule applies to the traffic getting switched or routed between the pods of the node
 		comment = "rule to jump traffic from POD name:" + pod.name + " namespace: " + pod.namespace +
 			" to chain " + podFwChainName
-		args = []string{"-m", "physdev", "--physdev-is-bridged",
-			"-m", "comment", "--comment", comment,
-			"-s", pod.ip,
-			"-j", podFwChainName}
-		exists, err = iptablesCmdHandler.Exists("filter", "FORWARD", args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
-		if !exists {
-			err = iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-			}
+		if args := npc.localPodJumpArgs(comment, "-s", pod.withInterface(podIfaces), podFwChainName); args != nil {
+			jumps = append(jumps, newPodFwJump("FORWARD", args))
 		}
 
-		// add rule to log the packets that will be dropped due to network policy enforcement
-		comment = "rule to log dropped traffic POD name:" + pod.name + " namespace: " + pod.namespace
-		args = []string{"-m", "comment", "--comment", comment, "-j", "NFLOG", "--nflog-group", "100", "-m", "limit", "--limit", "10/minute", "--limit-burst", "10"}
-		err = iptablesCmdHandler.AppendUnique("filter", podFwChainName, args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
+		npc.appendPodFwDropRules(filterTable, pod, podFwChainName)
+	}
 
-		// add default DROP rule at the end of chain
-		comment = "default rule to REJECT traffic destined for POD name:" + pod.name + " namespace: " + pod.namespace
-		args = []string{"-m", "comment", "--comment", comment, "-j", "REJECT"}
-		err = iptablesCmdHandler.AppendUnique("filter", podFwChainName, args...)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to run iptables command: %s", err.Error())
-		}
+	// the host-network pods are matched by the ports they declare rather than by IP
+	jumps = append(jumps, npc.syncHostNetworkPodFirewallChains(filterTable, version, activePodFwChains)...)
+
+	// the dispatch chains are replaced by the same iptables-restore as the chains, which are complete once it is
+	// applied
+	for _, builtIn := range podFwBuiltInChains {
+		filterTable.NewChain(podFwDispatchChains[builtIn])
+	}
+	for _, jump := range jumps {
+		filterTable.AppendUnique(jump.chain, jump.args...)
 	}
 
-	return activePodFwChains, nil
+	return activePodFwChains, jumps, nil
 }
 
-func cleanupStaleRules(activePolicyChains, activePodFwChains, activePolicyIPSets map[string]bool) error {
+// appendPodFwDropRules appends the rules logging and rejecting the traffic no network policy accepted to the pod
+// firewall chain, once per chain
+func (npc *NetworkPolicyController) appendPodFwDropRules(filterTable *utils.IPTablesRestore, pod podInfo, podFwChainName string) {
+	// the traffic of the directions of the pod no network policy isolates is accepted past the cluster network
+	// policies
+	npc.appendClusterNetworkPolicyAcceptRules(filterTable, pod, podFwChainName)
+
+	// the traffic of the audited directions of the pod is logged and accepted before the drop rules
+	npc.appendAuditRules(filterTable, pod, podFwChainName)
+
+	// add rule to log the packets that will be dropped due to network policy enforcement
+	if npc.dropLogGroup != 0 {
+		comment := "rule to log dropped traffic POD name:" + pod.name + " namespace: " + pod.namespace
+		filterTable.AppendUnique(podFwChainName, "-m", "comment", "--comment", comment, "-j", "NFLOG",
+			"--nflog-group", strconv.Itoa(int(npc.dropLogGroup)), "-m", "limit", "--limit", npc.dropLogLimit,
+			"--limit-burst", "10")
+	}
+
+	// add default REJECT, or DROP, rule at the end of chain
+	target := "REJECT"
+	if npc.namespaceDefaultVerdict(pod.namespace) == defaultVerdictDrop {
+		target = "DROP"
+	}
+	comment := "default rule to " + target + " traffic destined for POD name:" + pod.name + " namespace: " + pod.namespace
+	filterTable.AppendUnique(podFwChainName, "-m", "comment", "--comment", comment, "-j", target)
+}
+
+// egressInputChainJumpArgs returns the rule in the INPUT chain that jumps the traffic from the pod to its
+// firewall chain. Traffic to IPVS services is marked by the service proxy and is not evaluated here, since
+// destination is still the service VIP. It gets evaluated against the egress policies in the OUTPUT chain
+// once IPVS has DNATed it to the selected endpoint.
+func egressInputChainJumpArgs(comment, podIP, podFwChainName string) []string {
+	return []string{"-m", "comment", "--comment", comment,
+		"-m", "mark", "!", "--mark", utils.GetFwMark(utils.FwMarkServiceTraffic).String(),
+		"-s", podIP, "-j", podFwChainName}
+}
+
+func (npc *NetworkPolicyController) cleanupStaleRules(activePolicyChains, activePodFwChains, activePolicyIPSets map[string]bool) error {
+	staleIPSets, referencedIPSets, err := npc.cleanupStaleChains(activePolicyChains, activePodFwChains,
+		activePolicyIPSets, nil)
+	if err != nil {
+		return err
+	}
+	return npc.destroyStaleIPSets(staleIPSets, referencedIPSets)
+}
+
+// cleanupStaleChains deletes the pod firewall and network policy chains that are not active, and the references to
+// them, and returns the ipsets of the network policies that are not active, along with the ones the quarantined
+// chains still match on. Only the chains of listedChains are deleted, unless it is nil.
+func (npc *NetworkPolicyController) cleanupStaleChains(activePolicyChains, activePodFwChains,
+	activePolicyIPSets, listedChains map[string]bool) ([]*utils.Set, map[string]bool, error) {
 
 	cleanupPodFwChains := make([]string, 0)
 	cleanupPolicyChains := make([]string, 0)
@@ -970,6 +1405,9 @@ func cleanupStaleRules(activePolicyChains, activePodFwChains, activePolicyIPSets
 	// find iptables chains and ipsets that are no longer used by comparing current to the active maps we were passed
 	chains, err := iptablesCmdHandler.ListChains("filter")
 	for _, chain := range chains {
+		if listedChains != nil && !listedChains[chain] {
+			continue
+		}
 		if strings.HasPrefix(chain, kubeNetworkPolicyChainPrefix) {
 			if _, ok := activePolicyChains[chain]; !ok {
 				cleanupPolicyChains = append(cleanupPolicyChains, chain)
@@ -981,35 +1419,22 @@ func cleanupStaleRules(activePolicyChains, activePodFwChains, activePolicyIPSets
 			}
 		}
 	}
-	for _, set := range ipsets.Sets {
-		if strings.HasPrefix(set.Name, kubeSourceIpSetPrefix) ||
-			strings.HasPrefix(set.Name, kubeDestinationIpSetPrefix) {
-			if _, ok := activePolicyIPSets[set.Name]; !ok {
-				cleanupPolicyIPSets = append(cleanupPolicyIPSets, set)
-			}
+	for _, set := range ipsets.List() {
+		if isStalePolicyIPSet(set.Name, activePolicyIPSets) {
+			cleanupPolicyIPSets = append(cleanupPolicyIPSets, set)
 		}
 	}
 
 	// remove stale iptables podFwChain references from the filter table chains
-	for _, podFwChain := range cleanupPodFwChains {
-
-		primaryChains := []string{"FORWARD", "OUTPUT", "INPUT"}
-		for _, egressChain := range primaryChains {
-			forwardChainRules, err := iptablesCmdHandler.List("filter", egressChain)
-			if err != nil {
-				return fmt.Errorf("failed to list rules in filter table, %s podFwChain due to %s", egressChain, err.Error())
-			}
-
-			// TODO delete rule by spec, than rule number to avoid extra loop
-			var realRuleNo int
-			for i, rule := range forwardChainRules {
-				if strings.Contains(rule, podFwChain) {
-					err = iptablesCmdHandler.Delete("filter", egressChain, strconv.Itoa(i-realRuleNo))
-					if err != nil {
-						return fmt.Errorf("failed to delete rule: %s from the %s podFwChain of filter table due to %s", rule, egressChain, err.Error())
-					}
-					realRuleNo++
-				}
+	if len(cleanupPodFwChains) > 0 {
+		stalePodFwChains := make(map[string]bool, len(cleanupPodFwChains))
+		for _, podFwChain := range cleanupPodFwChains {
+			stalePodFwChains[podFwChain] = true
+		}
+		for _, egressChain := range []string{"FORWARD", "OUTPUT", "INPUT"} {
+			if _, err := utils.DeleteIPTablesRules(iptablesCmdHandler, "filter", egressChain,
+				jumpsTo(stalePodFwChains)); err != nil {
+				return nil, nil, err
 			}
 		}
 	}
@@ -1017,56 +1442,84 @@ func cleanupStaleRules(activePolicyChains, activePodFwChains, activePolicyIPSets
 	// cleanup pod firewall chain
 	for _, chain := range cleanupPodFwChains {
 		glog.V(2).Infof("Found pod fw chain to cleanup: %s", chain)
+		if npc.chainQuarantine.enabled() {
+			if err = npc.chainQuarantine.quarantine(iptablesCmdHandler, chain); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
 		err = iptablesCmdHandler.ClearChain("filter", chain)
 		if err != nil {
-			return fmt.Errorf("Failed to flush the rules in chain %s due to %s", chain, err.Error())
+			return nil, nil, fmt.Errorf("Failed to flush the rules in chain %s due to %s", chain, err.Error())
 		}
 		err = iptablesCmdHandler.DeleteChain("filter", chain)
 		if err != nil {
-			return fmt.Errorf("Failed to delete the chain %s due to %s", chain, err.Error())
+			return nil, nil, fmt.Errorf("Failed to delete the chain %s due to %s", chain, err.Error())
 		}
 		glog.V(2).Infof("Deleted pod specific firewall chain: %s from the filter table", chain)
 	}
 
+	// first clean up the references to the stale network policy chains from the active pod firewall chains
+	if len(cleanupPolicyChains) > 0 {
+		stalePolicyChains := make(map[string]bool, len(cleanupPolicyChains))
+		for _, policyChain := range cleanupPolicyChains {
+			stalePolicyChains[policyChain] = true
+		}
+		for podFwChain := range activePodFwChains {
+			if _, err := utils.DeleteIPTablesRules(iptablesCmdHandler, "filter", podFwChain,
+				jumpsTo(stalePolicyChains)); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
 	// cleanup network policy chains
 	for _, policyChain := range cleanupPolicyChains {
 		glog.V(2).Infof("Found policy chain to cleanup %s", policyChain)
 
-		// first clean up any references from active pod firewall chains
-		for podFwChain := range activePodFwChains {
-			podFwChainRules, err := iptablesCmdHandler.List("filter", podFwChain)
-			if err != nil {
-
-			}
-			for i, rule := range podFwChainRules {
-				if strings.Contains(rule, policyChain) {
-					err = iptablesCmdHandler.Delete("filter", podFwChain, strconv.Itoa(i))
-					if err != nil {
-						return fmt.Errorf("Failed to delete rule %s from the chain %s", rule, podFwChain)
-					}
-					break
-				}
+		// now that all stale and active references to the network policy chain have been removed, delete the chain
+		if npc.chainQuarantine.enabled() {
+			if err = npc.chainQuarantine.quarantine(iptablesCmdHandler, policyChain); err != nil {
+				return nil, nil, err
 			}
+			continue
 		}
-
-		// now that all stale and active references to the network policy chain have been removed, delete the chain
 		err = iptablesCmdHandler.ClearChain("filter", policyChain)
 		if err != nil {
-			return fmt.Errorf("Failed to flush the rules in chain %s due to  %s", policyChain, err)
+			return nil, nil, fmt.Errorf("Failed to flush the rules in chain %s due to  %s", policyChain, err)
 		}
 		err = iptablesCmdHandler.DeleteChain("filter", policyChain)
 		if err != nil {
-			return fmt.Errorf("Failed to flush the rules in chain %s due to %s", policyChain, err)
+			return nil, nil, fmt.Errorf("Failed to flush the rules in chain %s due to %s", policyChain, err)
 		}
 		glog.V(2).Infof("Deleted network policy chain: %s from the filter table", policyChain)
 	}
 
-	// cleanup network policy ipsets
-	for _, set := range cleanupPolicyIPSets {
-		err = set.Destroy()
-		if err != nil {
+	// the chains left in ip6tables match on the IPv6 ipsets, they are not quarantined
+	if ip6tablesCmdHandler := newIP6TablesCmdHandler(); ip6tablesCmdHandler != nil {
+		if err = deleteStalePolicyChains(ip6tablesCmdHandler, activePolicyChains, activePodFwChains); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	referencedIPSets, err := npc.chainQuarantine.deleteExpired(iptablesCmdHandler)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cleanupPolicyIPSets, referencedIPSets, nil
+}
+
+// destroyStaleIPSets destroys the stale ipsets of the network policies, but the ones quarantined chains still match on
+func (npc *NetworkPolicyController) destroyStaleIPSets(staleIPSets []*utils.Set,
+	referencedIPSets map[string]bool) error {
+	for _, set := range staleIPSets {
+		if referencedIPSets[set.Name] {
+			continue
+		}
+		if err := set.Destroy(); err != nil {
 			return fmt.Errorf("Failed to delete ipset %s due to %s", set.Name, err)
 		}
+		npc.Audit.Record(auditController, utils.AuditIPSetDestroyed, set.Name, nil)
 	}
 	return nil
 }
@@ -1077,22 +1530,20 @@ func (npc *NetworkPolicyController) getIngressNetworkPolicyEnabledPods(nodeIp st
 	for _, obj := range npc.podLister.List() {
 		pod := obj.(*api.Pod)
 
-		if strings.Compare(pod.Status.HostIP, nodeIp) != 0 {
+		if strings.Compare(pod.Status.HostIP, nodeIp) != 0 || npc.skipsPod(pod) || exemptsPod(pod) ||
+			npc.excludesNamespace(pod.Namespace) {
 			continue
 		}
-		for _, policy := range *npc.networkPoliciesInfo {
-			if policy.namespace != pod.ObjectMeta.Namespace {
-				continue
-			}
-			_, ok := policy.targetPods[pod.Status.PodIP]
-			if ok && (policy.policyType == "both" || policy.policyType == "ingress") {
-				glog.V(2).Infof("Found pod name: " + pod.ObjectMeta.Name + " namespace: " + pod.ObjectMeta.Namespace + " for which network policies need to be applied.")
-				nodePods[pod.Status.PodIP] = podInfo{ip: pod.Status.PodIP,
-					name:      pod.ObjectMeta.Name,
-					namespace: pod.ObjectMeta.Namespace,
-					labels:    pod.ObjectMeta.Labels}
-				break
-			}
+		// the pods the cluster network policies select get a pod firewall chain even when no network policy
+		// isolates them
+		if npc.policiesIsolatePod(pod.Namespace, pod.Status.PodIP, "ingress") ||
+			npc.clusterNetworkPoliciesSelectPod(pod.Status.PodIP, "ingress") {
+			glog.V(2).Infof("Found pod name: " + pod.ObjectMeta.Name + " namespace: " + pod.ObjectMeta.Namespace + " for which network policies need to be applied.")
+			nodePods[pod.Status.PodIP] = podInfo{ip: pod.Status.PodIP,
+				name:          pod.ObjectMeta.Name,
+				namespace:     pod.ObjectMeta.Namespace,
+				labels:        pod.ObjectMeta.Labels,
+				priorityClass: pod.Spec.PriorityClassName}
 		}
 	}
 	return &nodePods, nil
@@ -1106,40 +1557,63 @@ func (npc *NetworkPolicyController) getEgressNetworkPolicyEnabledPods(nodeIp str
 	for _, obj := range npc.podLister.List() {
 		pod := obj.(*api.Pod)
 
-		if strings.Compare(pod.Status.HostIP, nodeIp) != 0 {
+		if strings.Compare(pod.Status.HostIP, nodeIp) != 0 || npc.skipsPod(pod) || exemptsPod(pod) ||
+			npc.excludesNamespace(pod.Namespace) {
 			continue
 		}
-		for _, policy := range *npc.networkPoliciesInfo {
-			if policy.namespace != pod.ObjectMeta.Namespace {
-				continue
-			}
-			_, ok := policy.targetPods[pod.Status.PodIP]
-			if ok && (policy.policyType == "both" || policy.policyType == "egress") {
-				glog.V(2).Infof("Found pod name: " + pod.ObjectMeta.Name + " namespace: " + pod.ObjectMeta.Namespace + " for which network policies need to be applied.")
-				nodePods[pod.Status.PodIP] = podInfo{ip: pod.Status.PodIP,
-					name:      pod.ObjectMeta.Name,
-					namespace: pod.ObjectMeta.Namespace,
-					labels:    pod.ObjectMeta.Labels}
-				break
-			}
+		// the pods the cluster network policies select get a pod firewall chain even when no network policy
+		// isolates them
+		if npc.policiesIsolatePod(pod.Namespace, pod.Status.PodIP, "egress") ||
+			npc.clusterNetworkPoliciesSelectPod(pod.Status.PodIP, "egress") {
+			glog.V(2).Infof("Found pod name: " + pod.ObjectMeta.Name + " namespace: " + pod.ObjectMeta.Namespace + " for which network policies need to be applied.")
+			nodePods[pod.Status.PodIP] = podInfo{ip: pod.Status.PodIP,
+				name:          pod.ObjectMeta.Name,
+				namespace:     pod.ObjectMeta.Namespace,
+				labels:        pod.ObjectMeta.Labels,
+				priorityClass: pod.Spec.PriorityClassName}
 		}
 	}
 	return &nodePods, nil
 }
 
+// policiesIsolatePod returns whether a network policy of the namespace of the pod with the IP isolates the pod in the
+// direction, ingress or egress
+func (npc *NetworkPolicyController) policiesIsolatePod(namespace, podIP, direction string) bool {
+	for _, policy := range *npc.networkPoliciesInfo {
+		if policy.namespace != namespace {
+			continue
+		}
+		_, ok := policy.targetPods[podIP]
+		if ok && (policy.policyType == "both" || policy.policyType == direction) {
+			return true
+		}
+	}
+	return false
+}
+
+// processNetworkPolicyPorts returns the numeric ports, or ranges of ports up to their endPort, and the endpoints of the
+// named ports of a rule
 func (npc *NetworkPolicyController) processNetworkPolicyPorts(npPorts []networking.NetworkPolicyPort, namedPort2eps namedPort2eps) (numericPorts []protocolAndPort, namedPorts []endPoints) {
 	numericPorts, namedPorts = make([]protocolAndPort, 0), make([]endPoints, 0)
 	for _, npPort := range npPorts {
+		protocol, err := policyPortProtocol(npPort.Protocol)
+		if err != nil {
+			// the rule allows nothing on the port rather than all the protocols
+			glog.Errorf("Ignoring a port of a network policy: %s", err)
+			continue
+		}
 		if npPort.Port == nil {
-			numericPorts = append(numericPorts, protocolAndPort{port: "", protocol: string(*npPort.Protocol)})
+			numericPorts = append(numericPorts, protocolAndPort{port: "", protocol: protocol})
 		} else if npPort.Port.Type == intstr.Int {
-			numericPorts = append(numericPorts, protocolAndPort{port: npPort.Port.String(), protocol: string(*npPort.Protocol)})
+			port := npPort.Port.String()
+			if npPort.EndPort != nil {
+				port = portRange(port, *npPort.EndPort)
+			}
+			numericPorts = append(numericPorts, protocolAndPort{port: port, protocol: protocol})
 		} else {
 			if protocol2eps, ok := namedPort2eps[npPort.Port.String()]; ok {
-				if numericPort2eps, ok := protocol2eps[string(*npPort.Protocol)]; ok {
-					for _, eps := range numericPort2eps {
-						namedPorts = append(namedPorts, *eps)
-					}
+				if numericPort2eps, ok := protocol2eps[protocol]; ok {
+					namedPorts = append(namedPorts, numericPort2eps.sorted()...)
 				}
 			}
 		}
@@ -1150,16 +1624,20 @@ func (npc *NetworkPolicyController) processNetworkPolicyPorts(npPorts []networki
 func (npc *NetworkPolicyController) processBetaNetworkPolicyPorts(npPorts []apiextensions.NetworkPolicyPort, namedPort2eps namedPort2eps) (numericPorts []protocolAndPort, namedPorts []endPoints) {
 	numericPorts, namedPorts = make([]protocolAndPort, 0), make([]endPoints, 0)
 	for _, npPort := range npPorts {
+		protocol, err := policyPortProtocol(npPort.Protocol)
+		if err != nil {
+			// the rule allows nothing on the port rather than all the protocols
+			glog.Errorf("Ignoring a port of a network policy: %s", err)
+			continue
+		}
 		if npPort.Port == nil {
-			numericPorts = append(numericPorts, protocolAndPort{port: "", protocol: string(*npPort.Protocol)})
+			numericPorts = append(numericPorts, protocolAndPort{port: "", protocol: protocol})
 		} else if npPort.Port.Type == intstr.Int {
-			numericPorts = append(numericPorts, protocolAndPort{port: npPort.Port.String(), protocol: string(*npPort.Protocol)})
+			numericPorts = append(numericPorts, protocolAndPort{port: npPort.Port.String(), protocol: protocol})
 		} else {
 			if protocol2eps, ok := namedPort2eps[npPort.Port.String()]; ok {
-				if numericPort2eps, ok := protocol2eps[string(*npPort.Protocol)]; ok {
-					for _, eps := range numericPort2eps {
-						namedPorts = append(namedPorts, *eps)
-					}
+				if numericPort2eps, ok := protocol2eps[protocol]; ok {
+					namedPorts = append(namedPorts, numericPort2eps.sorted()...)
 				}
 			}
 		}
@@ -1170,8 +1648,14 @@ func (npc *NetworkPolicyController) processBetaNetworkPolicyPorts(npPorts []apie
 func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicyInfo, error) {
 
 	NetworkPolicies := make([]networkPolicyInfo, 0)
+	topologies := make(map[string]*peerTopology)
+	nodePeers := false
 
-	for _, policyObj := range npc.npLister.List() {
+	policyObjs, err := npc.listNetworkPolicies()
+	if err != nil {
+		return nil, err
+	}
+	for _, policyObj := range policyObjs {
 
 		policy, ok := policyObj.(*networking.NetworkPolicy)
 		podSelector, _ := v1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
@@ -1183,6 +1667,7 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicy
 			namespace:   policy.Namespace,
 			podSelector: podSelector,
 			policyType:  "ingress",
+			audit:       npc.auditMode || isAudited(policy.Annotations),
 		}
 
 		ingressType, egressType := false, false
@@ -1201,6 +1686,9 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicy
 		} else if ingressType {
 			newPolicy.policyType = "ingress"
 		}
+		if npc.fqdnSnooper != nil && newPolicy.policyType != "ingress" {
+			newPolicy.egressFQDNs = egressFQDNs(policy)
+		}
 
 		matchingPods, err := npc.ListPodsByNamespaceAndLabels(policy.Namespace, podSelector)
 		newPolicy.targetPods = make(map[string]podInfo)
@@ -1229,6 +1717,8 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicy
 		} else {
 			newPolicy.egressRules = make([]egressRule, 0)
 		}
+		except := newPeerExcept(policy)
+		topology := npc.newPeerTopology(policy, topologies)
 
 		for _, specIngressRule := range policy.Spec.Ingress {
 			ingressRule := ingressRule{}
@@ -1241,9 +1731,10 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicy
 			} else {
 				ingressRule.matchAllSource = false
 				for _, peer := range specIngressRule.From {
+					ingressRule.namespaceSelectorPeers = ingressRule.namespaceSelectorPeers || peer.NamespaceSelector != nil
 					if peerPods, err := npc.evalPodPeer(policy, peer); err == nil {
 						for _, peerPod := range peerPods {
-							if peerPod.Status.PodIP == "" {
+							if peerPod.Status.PodIP == "" || except.excludes(peer, peerPod) || topology.excludes(peerPod) {
 								continue
 							}
 							ingressRule.srcPods = append(ingressRule.srcPods,
@@ -1255,6 +1746,7 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicy
 					}
 					ingressRule.srcIPBlocks = append(ingressRule.srcIPBlocks, npc.evalIPBlockPeer(peer)...)
 				}
+				ingressRule.srcIPBlocks = append(ingressRule.srcIPBlocks, npc.ipBlockEntries(except, specIngressRule.From)...)
 			}
 
 			ingressRule.ports = make([]protocolAndPort, 0)
@@ -1282,9 +1774,10 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicy
 			} else {
 				egressRule.matchAllDestinations = false
 				for _, peer := range specEgressRule.To {
+					egressRule.namespaceSelectorPeers = egressRule.namespaceSelectorPeers || peer.NamespaceSelector != nil
 					if peerPods, err := npc.evalPodPeer(policy, peer); err == nil {
 						for _, peerPod := range peerPods {
-							if peerPod.Status.PodIP == "" {
+							if peerPod.Status.PodIP == "" || except.excludes(peer, peerPod) || topology.excludes(peerPod) {
 								continue
 							}
 							egressRule.dstPods = append(egressRule.dstPods,
@@ -1298,6 +1791,7 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicy
 					}
 					egressRule.dstIPBlocks = append(egressRule.dstIPBlocks, npc.evalIPBlockPeer(peer)...)
 				}
+				egressRule.dstIPBlocks = append(egressRule.dstIPBlocks, npc.ipBlockEntries(except, specEgressRule.To)...)
 			}
 
 			egressRule.ports = make([]protocolAndPort, 0)
@@ -1312,8 +1806,18 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() (*[]networkPolicy
 
 			newPolicy.egressRules = append(newPolicy.egressRules, egressRule)
 		}
+		if _, ok := policy.Annotations[egressNodePeersAnnotation]; ok && newPolicy.policyType != "ingress" {
+			nodePeers = true
+			newPolicy.egressRules = append(newPolicy.egressRules, npc.nodePeerEgressRules(policy)...)
+		}
 		NetworkPolicies = append(NetworkPolicies, newPolicy)
 	}
+	npc.nodePeers = nodePeers
+	topologyLabels := make(map[string]bool, len(topologies))
+	for key := range topologies {
+		topologyLabels[key] = true
+	}
+	npc.peerTopologyLabels = topologyLabels
 
 	return &NetworkPolicies, nil
 }
@@ -1394,7 +1898,10 @@ func (npc *NetworkPolicyController) grabNamedPortFromPod(pod *api.Pod, namedPort
 	for k := range pod.Spec.Containers {
 		for _, port := range pod.Spec.Containers[k].Ports {
 			name := port.Name
-			protocol := string(port.Protocol)
+			protocol, err := policyPortProtocol(&port.Protocol)
+			if err != nil {
+				continue
+			}
 			containerPort := strconv.Itoa(int(port.ContainerPort))
 
 			if (*namedPort2eps)[name] == nil {
@@ -1419,7 +1926,7 @@ func (npc *NetworkPolicyController) buildBetaNetworkPoliciesInfo() (*[]networkPo
 
 	NetworkPolicies := make([]networkPolicyInfo, 0)
 
-	for _, policyObj := range npc.npLister.List() {
+	for _, policyObj := range npc.enforcedPolicies(npc.npLister.List()) {
 
 		policy, _ := policyObj.(*apiextensions.NetworkPolicy)
 		podSelector, _ := v1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
@@ -1427,6 +1934,7 @@ func (npc *NetworkPolicyController) buildBetaNetworkPoliciesInfo() (*[]networkPo
 			name:        policy.Name,
 			namespace:   policy.Namespace,
 			podSelector: podSelector,
+			audit:       npc.auditMode || isAudited(policy.Annotations),
 		}
 		matchingPods, err := npc.ListPodsByNamespaceAndLabels(policy.Namespace, podSelector)
 		newPolicy.targetPods = make(map[string]podInfo)
@@ -1489,143 +1997,131 @@ func networkPolicyChainName(namespace, policyName string, version string) string
 }
 
 func policySourcePodIpSetName(namespace, policyName string) string {
-	hash := sha256.Sum256([]byte(namespace + policyName))
-	encoded := base32.StdEncoding.EncodeToString(hash[:])
-	return kubeSourceIpSetPrefix + encoded[:16]
+	return utils.HashedIPSetName(kubeSourceIpSetPrefix, policyIPSetKey(namespace, policyName), false)
 }
 
 func policyDestinationPodIpSetName(namespace, policyName string) string {
-	hash := sha256.Sum256([]byte(namespace + policyName))
-	encoded := base32.StdEncoding.EncodeToString(hash[:])
-	return kubeDestinationIpSetPrefix + encoded[:16]
+	return utils.HashedIPSetName(kubeDestinationIpSetPrefix, policyIPSetKey(namespace, policyName), false)
 }
 
 func policyIndexedSourcePodIpSetName(namespace, policyName string, ingressRuleNo int) string {
-	hash := sha256.Sum256([]byte(namespace + policyName + "ingressrule" + strconv.Itoa(ingressRuleNo) + "pod"))
-	encoded := base32.StdEncoding.EncodeToString(hash[:])
-	return kubeSourceIpSetPrefix + encoded[:16]
+	return utils.HashedIPSetName(kubeSourceIpSetPrefix, policyIPSetKey(namespace, policyName, "ingressrule", strconv.Itoa(ingressRuleNo), "pod"), false)
 }
 
 func policyIndexedDestinationPodIpSetName(namespace, policyName string, egressRuleNo int) string {
-	hash := sha256.Sum256([]byte(namespace + policyName + "egressrule" + strconv.Itoa(egressRuleNo) + "pod"))
-	encoded := base32.StdEncoding.EncodeToString(hash[:])
-	return kubeDestinationIpSetPrefix + encoded[:16]
+	return utils.HashedIPSetName(kubeDestinationIpSetPrefix, policyIPSetKey(namespace, policyName, "egressrule", strconv.Itoa(egressRuleNo), "pod"), false)
+}
+
+func policyIndexedSourcePodNetIpSetName(namespace, policyName string, ingressRuleNo int) string {
+	return utils.HashedIPSetName(kubeSourceIpSetPrefix, policyIPSetKey(namespace, policyName, "ingressrule", strconv.Itoa(ingressRuleNo), "podnet"), false)
+}
+
+func policyIndexedDestinationPodNetIpSetName(namespace, policyName string, egressRuleNo int) string {
+	return utils.HashedIPSetName(kubeDestinationIpSetPrefix, policyIPSetKey(namespace, policyName, "egressrule", strconv.Itoa(egressRuleNo), "podnet"), false)
 }
 
 func policyIndexedSourceIpBlockIpSetName(namespace, policyName string, ingressRuleNo int) string {
-	hash := sha256.Sum256([]byte(namespace + policyName + "ingressrule" + strconv.Itoa(ingressRuleNo) + "ipblock"))
-	encoded := base32.StdEncoding.EncodeToString(hash[:])
-	return kubeSourceIpSetPrefix + encoded[:16]
+	return utils.HashedIPSetName(kubeSourceIpSetPrefix, policyIPSetKey(namespace, policyName, "ingressrule", strconv.Itoa(ingressRuleNo), "ipblock"), false)
 }
 
 func policyIndexedDestinationIpBlockIpSetName(namespace, policyName string, egressRuleNo int) string {
-	hash := sha256.Sum256([]byte(namespace + policyName + "egressrule" + strconv.Itoa(egressRuleNo) + "ipblock"))
-	encoded := base32.StdEncoding.EncodeToString(hash[:])
-	return kubeDestinationIpSetPrefix + encoded[:16]
+	return utils.HashedIPSetName(kubeDestinationIpSetPrefix, policyIPSetKey(namespace, policyName, "egressrule", strconv.Itoa(egressRuleNo), "ipblock"), false)
 }
 
 func policyIndexedIngressNamedPortIpSetName(namespace, policyName string, ingressRuleNo, namedPortNo int) string {
-	hash := sha256.Sum256([]byte(namespace + policyName + "ingressrule" + strconv.Itoa(ingressRuleNo) + strconv.Itoa(namedPortNo) + "namedport"))
-	encoded := base32.StdEncoding.EncodeToString(hash[:])
-	return kubeDestinationIpSetPrefix + encoded[:16]
+	return utils.HashedIPSetName(kubeDestinationIpSetPrefix, policyIPSetKey(namespace, policyName, "ingressrule", strconv.Itoa(ingressRuleNo), strconv.Itoa(namedPortNo), "namedport"), false)
 }
 
 func policyIndexedEgressNamedPortIpSetName(namespace, policyName string, egressRuleNo, namedPortNo int) string {
-	hash := sha256.Sum256([]byte(namespace + policyName + "egressrule" + strconv.Itoa(egressRuleNo) + strconv.Itoa(namedPortNo) + "namedport"))
-	encoded := base32.StdEncoding.EncodeToString(hash[:])
-	return kubeDestinationIpSetPrefix + encoded[:16]
+	return utils.HashedIPSetName(kubeDestinationIpSetPrefix, policyIPSetKey(namespace, policyName, "egressrule", strconv.Itoa(egressRuleNo), strconv.Itoa(namedPortNo), "namedport"), false)
 }
 
-// Cleanup cleanup configurations done
-func (npc *NetworkPolicyController) Cleanup() {
+// Cleanup cleanup configurations done. It stops on the first failure to delete the chains, and returns the last
+// error of the other steps, which it goes on past.
+func (npc *NetworkPolicyController) Cleanup() error {
 
 	glog.Info("Cleaning up iptables configuration permanently done by kube-router")
 
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		glog.Errorf("Failed to initialize iptables executor: %s", err.Error())
+	var cleanupErr error
+	if err := deleteNFTablesTable(); err != nil {
+		glog.Errorf("Failed to delete the table of the nftables backend: %s", err)
+		cleanupErr = fmt.Errorf("failed to delete the table of the nftables backend: %s", err)
 	}
 
-	// delete jump rules in FORWARD chain to pod specific firewall chain
-	forwardChainRules, err := iptablesCmdHandler.List("filter", "FORWARD")
+	iptablesCmdHandler, err := iptables.New()
 	if err != nil {
-		glog.Errorf("Failed to delete iptables rules as part of cleanup")
-		return
+		return fmt.Errorf("failed to initialize iptables executor: %s", err)
 	}
 
-	// TODO: need a better way to delte rule with out using number
-	var realRuleNo int
-	for i, rule := range forwardChainRules {
-		if strings.Contains(rule, kubePodFirewallChainPrefix) {
-			err = iptablesCmdHandler.Delete("filter", "FORWARD", strconv.Itoa(i-realRuleNo))
-			realRuleNo++
+	// delete jump rules in FORWARD, OUTPUT and INPUT chains to pod specific firewall chain left by the previous
+	// versions, then the dispatch chains jumping to them
+	for _, chain := range []string{"FORWARD", "OUTPUT", "INPUT"} {
+		if _, err = utils.DeleteIPTablesRules(iptablesCmdHandler, "filter", chain, jumpsToPodFirewall); err != nil {
+			return fmt.Errorf("failed to delete iptables rules as part of cleanup: %s", err)
 		}
 	}
+	if err = deleteDispatchChains(iptablesCmdHandler); err != nil {
+		return fmt.Errorf("failed to delete iptables rules as part of cleanup: %s", err)
+	}
 
-	// delete jump rules in OUTPUT chain to pod specific firewall chain
-	forwardChainRules, err = iptablesCmdHandler.List("filter", "OUTPUT")
+	err = cleanupAcceptedFlowLog(iptablesCmdHandler)
 	if err != nil {
-		glog.Errorf("Failed to delete iptables rules as part of cleanup")
-		return
+		glog.Errorf("Failed to cleanup accepted flow logging: %s", err.Error())
+		cleanupErr = fmt.Errorf("failed to cleanup accepted flow logging: %s", err)
+	}
+	err = cleanupFQDNSnoop(iptablesCmdHandler)
+	if err != nil {
+		glog.Errorf("Failed to cleanup the snooping of the cluster DNS: %s", err.Error())
+		cleanupErr = fmt.Errorf("failed to cleanup the snooping of the cluster DNS: %s", err)
 	}
 
-	// TODO: need a better way to delte rule with out using number
-	realRuleNo = 0
-	for i, rule := range forwardChainRules {
-		if strings.Contains(rule, kubePodFirewallChainPrefix) {
-			err = iptablesCmdHandler.Delete("filter", "OUTPUT", strconv.Itoa(i-realRuleNo))
-			realRuleNo++
-		}
+	// delete quarantined chains, they may jump to network policy chains
+	if _, err = npc.chainQuarantine.deleteExpired(iptablesCmdHandler); err != nil {
+		return fmt.Errorf("failed to cleanup iptables rules: %s", err)
 	}
 
-	// flush and delete pod specific firewall chain
-	chains, err := iptablesCmdHandler.ListChains("filter")
-	for _, chain := range chains {
-		if strings.HasPrefix(chain, kubePodFirewallChainPrefix) {
-			err = iptablesCmdHandler.ClearChain("filter", chain)
-			if err != nil {
-				glog.Errorf("Failed to cleanup iptables rules: " + err.Error())
-				return
+	// flush and delete pod specific firewall chain, then per network policy specific chain
+	for _, prefix := range []string{kubePodFirewallChainPrefix, kubeNetworkPolicyChainPrefix} {
+		chains, err := iptablesCmdHandler.ListChains("filter")
+		if err != nil {
+			return fmt.Errorf("failed to cleanup iptables rules: %s", err)
+		}
+		for _, chain := range chains {
+			if !strings.HasPrefix(chain, prefix) {
+				continue
 			}
-			err = iptablesCmdHandler.DeleteChain("filter", chain)
-			if err != nil {
-				glog.Errorf("Failed to cleanup iptables rules: " + err.Error())
-				return
+			if err = iptablesCmdHandler.ClearChain("filter", chain); err != nil {
+				return fmt.Errorf("failed to cleanup iptables rules: %s", err)
+			}
+			if err = iptablesCmdHandler.DeleteChain("filter", chain); err != nil {
+				return fmt.Errorf("failed to cleanup iptables rules: %s", err)
 			}
 		}
 	}
 
-	// flush and delete per network policy specific chain
-	chains, err = iptablesCmdHandler.ListChains("filter")
-	for _, chain := range chains {
-		if strings.HasPrefix(chain, kubeNetworkPolicyChainPrefix) {
-			err = iptablesCmdHandler.ClearChain("filter", chain)
-			if err != nil {
-				glog.Errorf("Failed to cleanup iptables rules: " + err.Error())
-				return
-			}
-			err = iptablesCmdHandler.DeleteChain("filter", chain)
-			if err != nil {
-				glog.Errorf("Failed to cleanup iptables rules: " + err.Error())
-				return
-			}
+	// delete the chains in ip6tables before the IPv6 ipsets they match on
+	if ip6tablesCmdHandler := newIP6TablesCmdHandler(); ip6tablesCmdHandler != nil {
+		if err = deletePolicyChains(ip6tablesCmdHandler); err != nil {
+			return fmt.Errorf("failed to cleanup ip6tables rules: %s", err)
 		}
 	}
 
-	// delete all ipsets
+	// delete all ipsets, the IPv6 ones included
 	ipset, err := utils.NewIPSet(false)
-	if err != nil {
-		glog.Errorf("Failed to clean up ipsets: " + err.Error())
+	if err == nil {
+		err = ipset.Save()
 	}
-	err = ipset.Save()
-	if err != nil {
-		glog.Errorf("Failed to clean up ipsets: " + err.Error())
+	if err == nil {
+		err = ipset.DestroyAllWithin()
 	}
-	err = ipset.DestroyAllWithin()
 	if err != nil {
-		glog.Errorf("Failed to clean up ipsets: " + err.Error())
+		return fmt.Errorf("failed to clean up ipsets: %s", err)
+	}
+	if cleanupErr != nil {
+		return cleanupErr
 	}
 	glog.Infof("Successfully cleaned the iptables configuration done by kube-router")
+	return nil
 }
 
 func (npc *NetworkPolicyController) newPodEventHandler() cache.ResourceEventHandler {
@@ -1637,8 +2133,11 @@ func (npc *NetworkPolicyController) newPodEventHandler() cache.ResourceEventHand
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			newPoObj := newObj.(*api.Pod)
 			oldPoObj := oldObj.(*api.Pod)
-			if newPoObj.Status.Phase != oldPoObj.Status.Phase || newPoObj.Status.PodIP != oldPoObj.Status.PodIP {
-				// for the network policies, we are only interested in pod status phase change or IP change
+			if newPoObj.Status.Phase != oldPoObj.Status.Phase || newPoObj.Status.PodIP != oldPoObj.Status.PodIP ||
+				newPoObj.Labels[acceptedFlowLogLabel] != oldPoObj.Labels[acceptedFlowLogLabel] ||
+				podNetpolAnnotationChanged(oldPoObj, newPoObj) {
+				// for the network policies, we are only interested in pod status phase change or IP change,
+				// in the pod being labeled for auditing accepted flows or annotated to be exempt
 				npc.OnPodUpdate(newObj)
 			}
 		},
@@ -1655,6 +2154,16 @@ func (npc *NetworkPolicyController) newNamespaceEventHandler() cache.ResourceEve
 
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
+			if npc.v1NetworkPolicy {
+				if namespaceLabelsChanged(oldObj, newObj) {
+					npc.OnNamespaceLabelsUpdate(newObj)
+				} else if namespaceDefaultVerdictChanged(oldObj, newObj) {
+					npc.OnNamespaceDefaultVerdictUpdate(newObj)
+				} else if namespaceDefaultDenyChanged(oldObj, newObj) {
+					npc.OnNamespaceDefaultDenyUpdate(newObj)
+				}
+				return
+			}
 			npc.OnNamespaceUpdate(newObj)
 
 		},
@@ -1700,10 +2209,7 @@ func (npc *NetworkPolicyController) handlePodDelete(obj interface{}) {
 		return
 	}
 
-	err := npc.Sync()
-	if err != nil {
-		glog.Errorf("Error syncing network policy for pod: %s/%s delete event Error: %s", pod.Namespace, pod.Name, err)
-	}
+	npc.syncQueue.add(syncPods)
 }
 
 func (npc *NetworkPolicyController) handleNamespaceDelete(obj interface{}) {
@@ -1730,10 +2236,7 @@ func (npc *NetworkPolicyController) handleNamespaceDelete(obj interface{}) {
 		return
 	}
 
-	err := npc.Sync()
-	if err != nil {
-		glog.Errorf("Error syncing network policies on namespace: %s delete event", err)
-	}
+	npc.syncQueue.add(syncFull)
 }
 
 func (npc *NetworkPolicyController) handleNetworkPolicyDelete(obj interface{}) {
@@ -1756,10 +2259,7 @@ func (npc *NetworkPolicyController) handleNetworkPolicyDelete(obj interface{}) {
 		return
 	}
 
-	err := npc.Sync()
-	if err != nil {
-		glog.Errorf("Error syncing network policy for the network policy: %s/%s delete event, Error: %s", netpol.Namespace, netpol.Name, err)
-	}
+	npc.syncQueue.add(syncFull)
 }
 
 // NewNetworkPolicyController returns new NetworkPolicyController object
@@ -1772,10 +2272,45 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 		//Register the metrics for this controller
 		prometheus.MustRegister(metrics.ControllerIptablesSyncTime)
 		prometheus.MustRegister(metrics.ControllerPolicyChainsSyncTime)
+		prometheus.MustRegister(metrics.ControllerPolicyAcceptedPackets)
+		prometheus.MustRegister(metrics.ControllerPolicyAcceptedBytes)
+		prometheus.MustRegister(metrics.ControllerPolicyRejectedPackets)
+		prometheus.MustRegister(metrics.ControllerPolicyAuditedPackets)
+		prometheus.MustRegister(metrics.ControllerPolicyDropEvents)
+		prometheus.MustRegister(metrics.ControllerPolicySyncTime)
+		prometheus.MustRegister(metrics.ControllerPolicyRules)
+		prometheus.MustRegister(metrics.ControllerPolicyLimitExceeded)
+		prometheus.MustRegister(metrics.ControllerPolicyIncrementalSyncs)
+		prometheus.MustRegister(metrics.ControllerPolicyDesiredState)
+		prometheus.MustRegister(metrics.ControllerPolicyJumpPositionDrift)
+		prometheus.MustRegister(metrics.ControllerPolicyShrinksHeld)
+		prometheus.MustRegister(metrics.ControllerPolicyConntrackFlushed)
+		prometheus.MustRegister(metrics.ControllerPolicyCriticalFlows)
+		prometheus.MustRegister(metrics.ControllerPolicyExternalFlushes)
 		npc.MetricsEnabled = true
 	}
+	npc.tenantLabels = metrics.NewTenantLabels(config.MetricsTenantLabels, config.MetricsTenantNamespaces, config.MetricsTenantMaxSeries)
+	npc.policyChainOwners = make(map[string]chainOwner)
+	npc.podFwChainOwners = make(map[string]chainOwner)
 
 	npc.syncPeriod = config.IPTablesSyncPeriod
+	npc.minSyncPeriod = config.NetpolMinSyncPeriod
+	if npc.minSyncPeriod < 0 || npc.minSyncPeriod > npc.syncPeriod {
+		return nil, errors.New("--netpol-min-sync-period must be between 0 and --iptables-sync-period")
+	}
+	npc.syncQueue = newSyncQueue()
+	npc.acceptedFlowLogGroup = config.AcceptedFlowLogGroup
+	npc.acceptedFlowLogLimit = config.AcceptedFlowLogLimit
+	npc.acceptedFlowLogBurst = config.AcceptedFlowLogBurst
+	npc.dropLogGroup = config.NetpolNFLogGroup
+	dropLogLimit, err := parseLogLimit(config.NetpolLogLimit)
+	if err != nil {
+		return nil, err
+	}
+	npc.dropLogLimit = dropLogLimit
+	if npc.acceptedFlowLogGroup != 0 && npc.acceptedFlowLogGroup == npc.dropLogGroup {
+		return nil, fmt.Errorf("NFLOG group %d for accepted flows is already used for logging dropped traffic", npc.dropLogGroup)
+	}
 
 	npc.v1NetworkPolicy = true
 	v, _ := clientset.Discovery().ServerVersion()
@@ -1799,6 +2334,181 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 	}
 	npc.nodeIP = nodeIP
 
+	npc.postSyncHook, err = utils.NewPostSyncHook(config.PostSyncHook, config.PostSyncHookTimeout)
+	if err != nil {
+		return nil, err
+	}
+	npc.appliedStateCache = nodestate.NewAppliedStateCache(config.AppliedStateDir, "netpol", npc.nodeHostName)
+	npc.exportIPSets = config.IPSetManifestConfigMap != ""
+	npc.logDroppedTraffic = config.LogDroppedTraffic
+	if npc.logDroppedTraffic && npc.dropLogGroup == 0 {
+		return nil, errors.New("--log-dropped-traffic needs the dropped traffic to be logged, --netpol-nflog-group can not be 0")
+	}
+	if config.DropFlowExport != "" {
+		if npc.dropExporter, err = newDropExporter(config.DropFlowExport); err != nil {
+			return nil, err
+		}
+	}
+	npc.dropEventMetrics = config.DropFlowMetrics
+	if npc.dropEventMetrics && !npc.MetricsEnabled {
+		return nil, errors.New("--drop-flow-metrics needs the metrics to be enabled with --metrics-port")
+	}
+	npc.denyEvents = config.NetpolDenyEvents
+	if (npc.dropExporter != nil || npc.dropEventMetrics || npc.denyEvents) && npc.dropLogGroup == 0 {
+		return nil, errors.New("--drop-flow-export, --drop-flow-metrics and --netpol-deny-events need the dropped " +
+			"traffic to be logged, --netpol-nflog-group can not be 0")
+	}
+	npc.policyBackend = config.PolicyBackend
+	switch npc.policyBackend {
+	case policyBackendIPTables:
+	case policyBackendNFTables:
+		if npc.acceptedFlowLogGroup != 0 {
+			return nil, errors.New("--accepted-flow-log-nflog-group is not supported with --policy-backend=nftables")
+		}
+	default:
+		return nil, fmt.Errorf("unknown --policy-backend %q, must be iptables or nftables", npc.policyBackend)
+	}
+	if npc.jumpPosition, err = newJumpPosition(config.NetpolJumpPosition, config.NetpolJumpMarkerComment); err != nil {
+		return nil, err
+	}
+	if npc.policyBackend != policyBackendIPTables && npc.jumpPosition.strategy != jumpPositionTop {
+		return nil, errors.New("--netpol-jump-position is only supported with --policy-backend=iptables")
+	}
+	npc.incrementalSync = config.PolicyIncrementalSync
+	npc.conntrackMode = config.PolicyConntrackMode
+	if config.PolicyFlushRevokedConntrack {
+		if npc.policyBackend != policyBackendIPTables {
+			return nil, errors.New("--policy-flush-revoked-conntrack is only supported with --policy-backend=iptables")
+		}
+		npc.flushRevokedConntrack = true
+	}
+	if config.PolicyHitTracking {
+		if npc.policyBackend != policyBackendIPTables {
+			return nil, errors.New("--policy-hit-tracking is only supported with --policy-backend=iptables")
+		}
+		npc.policyHits = newPolicyHits()
+	}
+	npc.policyCountersPeriod = config.PolicyCountersPeriod
+	if npc.policyCountersPeriod < 0 {
+		return nil, errors.New("--policy-counters-period must not be negative")
+	}
+	if npc.policyCountersPeriod > 0 {
+		if npc.policyBackend != policyBackendIPTables {
+			return nil, errors.New("--policy-counters-period is only supported with --policy-backend=iptables")
+		}
+		if npc.MetricsEnabled {
+			npc.policyCounters = newPolicyCounters()
+			npc.podRejectCounters = newPodRejectCounters()
+			prometheus.MustRegister(npc.policyCounters, npc.podRejectCounters)
+		} else {
+			glog.Warningf("--policy-counters-period requires the metrics to be enabled with --metrics-port")
+		}
+	}
+	if config.PolicyShrinkThreshold != 0 {
+		if err := validateShrinkThreshold(config.PolicyShrinkThreshold); err != nil {
+			return nil, err
+		}
+		if config.PolicyShrinkConfirmPeriod < 0 {
+			return nil, errors.New("--policy-shrink-confirm-period must not be negative")
+		}
+		if npc.policyBackend != policyBackendIPTables {
+			return nil, errors.New("--policy-shrink-threshold is only supported with --policy-backend=iptables")
+		}
+		npc.shrinkGuard = &shrinkGuard{threshold: config.PolicyShrinkThreshold,
+			confirmPeriod: config.PolicyShrinkConfirmPeriod}
+	}
+	npc.policyGCPeriod = config.PolicyGCPeriod
+	if npc.policyGCPeriod < 0 {
+		return nil, errors.New("--policy-gc-period must not be negative")
+	}
+	if npc.policyGCPeriod > 0 && npc.policyBackend != policyBackendIPTables {
+		return nil, errors.New("--policy-gc-period is only supported with --policy-backend=iptables")
+	}
+	npc.flushCheckPeriod = config.PolicyFlushCheckPeriod
+	if npc.flushCheckPeriod < 0 {
+		return nil, errors.New("--policy-flush-check-period must not be negative")
+	}
+	if npc.flushCheckPeriod > 0 && npc.policyBackend != policyBackendIPTables {
+		return nil, errors.New("--policy-flush-check-period is only supported with --policy-backend=iptables")
+	}
+	npc.serviceGraphSamplePeriod = config.ServiceGraphSamplePeriod
+	if npc.serviceGraphSamplePeriod < 0 {
+		return nil, errors.New("--service-graph-sample-period must not be negative")
+	}
+	if npc.serviceGraphSamplePeriod > 0 {
+		npc.serviceGraph = newServiceGraph()
+	}
+	if err := validateConntrackMode(npc.conntrackMode); err != nil {
+		return nil, err
+	}
+	npc.observeOnly = config.PolicyObserveOnly
+	npc.auditMode = config.NetpolAuditMode
+	npc.defaultVerdict = config.NetpolDefaultVerdict
+	if err := validateDefaultVerdict(npc.defaultVerdict); err != nil {
+		return nil, err
+	}
+	if npc.observeOnly && (config.PolicyReadinessSocket != "" || config.EnablePolicyStatus) {
+		return nil, errors.New("--policy-readiness-socket and --enable-policy-status report the network policies " +
+			"as enforced, they are not supported with --policy-observe-only")
+	}
+	npc.maxPolicyRules = config.MaxPolicyRules
+	npc.maxPolicyIPSetEntries = config.MaxPolicyIPSetEntries
+	npc.peerIPSetAggregationThreshold = config.PeerIPSetAggregationThreshold
+	if config.EnablePolicyStatus {
+		if config.PolicyStatusPeriod <= 0 {
+			return nil, errors.New("--policy-status-period must be greater than 0")
+		}
+		npc.policyStatus = newPolicyStatusReporter(clientset, node, config.PolicyStatusPeriod)
+	}
+
+	npc.namespacePlaceholderIPSets = config.NamespacePlaceholderIPSets
+	npc.podsRoutedMode = config.PodsRoutedMode
+	npc.podInterfacePrefix = config.PodInterfacePrefix
+	if npc.podsRoutedMode && npc.podInterfacePrefix == "" {
+		return nil, errors.New("--pod-interface-prefix must be set with --pods-routed-mode")
+	}
+	npc.podInterfaceRules = config.PodInterfaceRules
+	if npc.podInterfaceRules && !npc.podsRoutedMode {
+		return nil, errors.New("--pod-interface-rules requires --pods-routed-mode")
+	}
+	npc.enforceHostNetworkPods = config.EnforceHostNetworkPods
+	if npc.enforceHostNetworkPods && npc.policyBackend != policyBackendIPTables {
+		return nil, errors.New("--enforce-host-network-pods is only supported with --policy-backend=iptables")
+	}
+	npc.chainQuarantine = newChainQuarantine(config.StaleChainQuarantine)
+
+	npc.clientset = clientset
+	npc.emptyCacheGuards = newEmptyCacheGuards(clientset, npc.v1NetworkPolicy)
+	npc.enableIsolationProfiles = config.EnableIsolationProfiles
+	npc.enableClusterAllowLists = config.EnableClusterAllowLists
+	if npc.enableClusterAllowLists {
+		npc.clusterAllowListInformer = npc.newClusterAllowListInformer(clientset, config.InformerResyncPeriod)
+	}
+	if npc.criticalFlowsFlag, err = parseCriticalFlows(config.CriticalFlows); err != nil {
+		return nil, err
+	}
+	npc.enableCriticalFlows = config.EnableCriticalFlows
+	if (len(npc.criticalFlowsFlag) != 0 || npc.enableCriticalFlows) && npc.policyBackend != policyBackendIPTables {
+		return nil, errors.New("--critical-flows and --enable-critical-flows are only supported with " +
+			"--policy-backend=iptables")
+	}
+	if npc.enableCriticalFlows {
+		npc.criticalFlowInformer = npc.newCriticalFlowInformer(clientset, config.InformerResyncPeriod)
+	}
+	if config.EnableClusterNetworkPolicies {
+		if npc.policyBackend != policyBackendIPTables {
+			return nil, errors.New("--enable-cluster-network-policies is only supported with --policy-backend=iptables")
+		}
+		npc.clusterNetworkPolicyInformer = npc.newClusterNetworkPolicyInformer(clientset, config.InformerResyncPeriod)
+		npc.cnpLister = npc.clusterNetworkPolicyInformer.GetIndexer()
+	}
+	if npc.enableIsolationProfiles {
+		if !npc.v1NetworkPolicy {
+			glog.Warningf("Namespace isolation profiles are only supported with GA network policies")
+		}
+		npc.isolationProfileInformer = npc.newIsolationProfileInformer(clientset, config.InformerResyncPeriod)
+	}
+
 	ipset, err := utils.NewIPSet(false)
 	if err != nil {
 		return nil, err
@@ -1810,13 +2520,68 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 	npc.ipSetHandler = ipset
 
 	npc.podLister = podInformer.GetIndexer()
+	npc.policyReadinessSocket = config.PolicyReadinessSocket
+	if npc.policyReadinessSocket != "" {
+		npc.policyReadiness = newPolicyReadiness(config.PolicyReadinessMaxWait)
+		npc.podLister = &pendingPodIPIndexer{Indexer: npc.podLister, readiness: npc.policyReadiness,
+			nodeIP: npc.nodeIP.String()}
+	}
 	npc.PodEventHandler = npc.newPodEventHandler()
 
 	npc.nsLister = nsInformer.GetIndexer()
 	npc.NamespaceEventHandler = npc.newNamespaceEventHandler()
+	npc.NodeEventHandler = npc.newNodeEventHandler()
+	if npc.namespaceScope, err = parseNamespaceScope(config.NetpolNamespaceSelector); err != nil {
+		return nil, err
+	}
+	if npc.namespaceScope != nil {
+		glog.Infof("Enforcing the network policies of the namespaces matching %s only", npc.namespaceScope)
+	}
+	npc.excludedNamespaces = parseExcludedNamespaces(config.NetpolExcludeNamespaces)
+	if len(npc.excludedNamespaces) != 0 {
+		glog.Infof("Not enforcing the network policies of the namespaces %s",
+			strings.Join(npc.excludedNamespaceNames(), ","))
+	}
 
 	npc.npLister = npInformer.GetIndexer()
 	npc.NetworkPolicyEventHandler = npc.newNetworkPolicyEventHandler()
 
+	if config.NetpolAllowClusterDNS {
+		npc.clusterDNSService = config.NetpolClusterDNSService
+		if parts := strings.Split(npc.clusterDNSService, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --netpol-cluster-dns-service %q, must be namespace/name",
+				npc.clusterDNSService)
+		}
+		npc.EndpointsEventHandler = npc.newEndpointsEventHandler()
+	}
+	if config.EnableFQDNPolicies {
+		if npc.policyBackend != policyBackendIPTables {
+			return nil, errors.New("--enable-fqdn-policies is only supported with --policy-backend=iptables")
+		}
+		// only the responses of the cluster DNS are trusted
+		if npc.clusterDNSService == "" {
+			return nil, errors.New("--enable-fqdn-policies requires --netpol-allow-cluster-dns")
+		}
+		if err := validateFQDNMinTTL(config.FQDNPolicyMinTTL); err != nil {
+			return nil, err
+		}
+		npc.fqdnSnooper = &fqdnSnooper{queue: config.FQDNPolicyQueue, minTTL: config.FQDNPolicyMinTTL,
+			cache: newFQDNCache()}
+	}
+
+	npc.cachesSynced = []cache.InformerSynced{podInformer.HasSynced, nsInformer.HasSynced, npInformer.HasSynced}
+	if npc.clusterNetworkPolicyInformer != nil {
+		npc.cachesSynced = append(npc.cachesSynced, npc.clusterNetworkPolicyInformer.HasSynced)
+	}
+	if npc.isolationProfileInformer != nil {
+		npc.cachesSynced = append(npc.cachesSynced, npc.isolationProfileInformer.HasSynced)
+	}
+	if npc.clusterAllowListInformer != nil {
+		npc.cachesSynced = append(npc.cachesSynced, npc.clusterAllowListInformer.HasSynced)
+	}
+	if npc.criticalFlowInformer != nil {
+		npc.cachesSynced = append(npc.cachesSynced, npc.criticalFlowInformer.HasSynced)
+	}
+
 	return &npc, nil
 }
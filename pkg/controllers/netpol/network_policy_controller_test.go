@@ -2,20 +2,25 @@ package netpol
 
 import (
 	"context"
-	netv1 "k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
-	"k8s.io/client-go/tools/cache"
 	"net"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
 	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 )
 
 // newFakeInformersFromClient creates the different informers used in the uneventful network policy controller
@@ -185,6 +190,8 @@ func newUneventfulNetworkPolicyController(podInformer cache.SharedIndexInformer,
 
 	npc := NetworkPolicyController{}
 	npc.syncPeriod = time.Hour
+	npc.dropLogGroup = 100
+	npc.dropLogLimit = "10/minute"
 
 	npc.v1NetworkPolicy = true
 	npc.nodeHostName = "node"
@@ -196,6 +203,43 @@ func newUneventfulNetworkPolicyController(podInformer cache.SharedIndexInformer,
 	return &npc, nil
 }
 
+// tFakeCluster is the fake client and the informers the network policy controller of a test reads from
+type tFakeCluster struct {
+	client         *fake.Clientset
+	podInformer    cache.SharedIndexInformer
+	nsInformer     cache.SharedIndexInformer
+	netpolInformer cache.SharedIndexInformer
+}
+
+// newTestController returns a network policy controller without any event handler, reading from the informers of
+// a fake client with the node, once their caches synced. The pods, namespaces and network policies in objs are added
+// to the informer stores. The informers are stopped at the end of the test.
+func newTestController(t *testing.T, objs ...runtime.Object) (*NetworkPolicyController, *tFakeCluster) {
+	client := fake.NewSimpleClientset(&v1.NodeList{Items: []v1.Node{*newFakeNode("node", "10.10.10.10")}})
+	informerFactory, podInformer, nsInformer, netpolInformer := newFakeInformersFromClient(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	informerFactory.Start(ctx.Done())
+	cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced)
+	krNetPol, _ := newUneventfulNetworkPolicyController(podInformer, netpolInformer, nsInformer)
+
+	cluster := &tFakeCluster{client: client, podInformer: podInformer, nsInformer: nsInformer,
+		netpolInformer: netpolInformer}
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *v1.Pod:
+			tAddToInformerStore(t, podInformer, obj)
+		case *v1.Namespace:
+			tAddToInformerStore(t, nsInformer, obj)
+		case *netv1.NetworkPolicy:
+			tAddToInformerStore(t, netpolInformer, obj)
+		default:
+			t.Fatalf("unexpected object %T for the informer stores", obj)
+		}
+	}
+	return krNetPol, cluster
+}
+
 // tNetpolTestCase helper struct to define the inputs to the test case (netpols) and
 // 				  the expected selected targets (targetPods, inSourcePods for ingress targets, and outDestPods
 //				  for egress targets) as maps with key being the namespace and a csv of pod names
@@ -372,3 +416,105 @@ func TestNewNetworkPolicySelectors(t *testing.T) {
 // Ref:
 // https://github.com/kubernetes/kubernetes/blob/master/pkg/controller/podgc/gc_controller_test.go
 // https://github.com/kubernetes/kubernetes/blob/master/pkg/controller/testutil/test_utils.go
+
+func TestEgressInputChainJumpArgs(t *testing.T) {
+	args := egressInputChainJumpArgs("comment", "1.1.1.1", "KUBE-POD-FW-XXXXXXXXXXXXXXXX")
+	rule := strings.Join(args, " ")
+	if !strings.Contains(rule, "-m mark ! --mark "+utils.GetFwMark(utils.FwMarkServiceTraffic).String()) {
+		t.Errorf("traffic marked by the service proxy must not be evaluated in INPUT chain: %s", rule)
+	}
+	if !strings.HasSuffix(rule, "-s 1.1.1.1 -j KUBE-POD-FW-XXXXXXXXXXXXXXXX") {
+		t.Errorf("unexpected jump to pod firewall chain: %s", rule)
+	}
+
+	// the traffic of the pod to a service is evaluated once DNATed to the endpoint, in the OUTPUT chain when IPVS
+	// delivered it from the INPUT chain, so only the jump of the INPUT chain skips the marked traffic
+	krNetPol, cluster := newTestController(t,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+	netpol := tNetpol{
+		name:        "deny-egress",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		egress:      []netv1.NetworkPolicyEgressRule{{}},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, jumps, err := krNetPol.syncPodFirewallChains(utils.NewIPTablesRestore("filter"), "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	skipsMarked := make(map[string]bool)
+	for _, jump := range jumps {
+		if strings.HasSuffix(strings.Join(jump.args, " "), "-s 1.1.1.1 -j "+podFirewallChainName("nsA", "web", "1")) {
+			skipsMarked[jump.chain] = strings.Contains(strings.Join(jump.args, " "), "--mark")
+		}
+	}
+	expected := map[string]bool{podFwDispatchChains["INPUT"]: true, podFwDispatchChains["OUTPUT"]: false,
+		podFwDispatchChains["FORWARD"]: false}
+	if !reflect.DeepEqual(skipsMarked, expected) {
+		t.Errorf("expected only the jump of the INPUT chain to skip the traffic to services, got %v", skipsMarked)
+	}
+}
+
+func TestSyncPodFirewallChainsRestoreInput(t *testing.T) {
+	krNetPol, cluster := newTestController(t,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}})
+
+	netpol := tNetpol{
+		name:        "deny-all",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress:     []netv1.NetworkPolicyIngressRule{},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filterTable := utils.NewIPTablesRestore("filter")
+	chains, jumps, err := krNetPol.syncPodFirewallChains(filterTable, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	podFwChain := podFirewallChainName("nsA", "web", "1")
+	if len(chains) != 1 || !chains[podFwChain] {
+		t.Fatalf("expected the pod firewall chain %s, got %v", podFwChain, chains)
+	}
+	// FORWARD and OUTPUT, and FORWARD for the bridged traffic
+	if len(jumps) != 3 {
+		t.Errorf("expected 3 jumps to the pod firewall chain, got %d", len(jumps))
+	}
+
+	// the rules in the order the pod firewall chains always had, followed by the jumps to the chain in the dispatch
+	// chains
+	input := string(filterTable.Bytes())
+	var positions []int
+	for _, rule := range []string{
+		":" + podFwChain + " - [0:0]\n",
+		"-A " + podFwChain + " -m comment --comment \"rule for stateful firewall for pod\"",
+		"-A " + podFwChain + " -m comment --comment \"rule to permit the traffic traffic to pods when source is the pod's local node\"",
+		"-A " + podFwChain + " -m comment --comment \"run through nw policy deny-all\" -j " + networkPolicyChainName("nsA", "deny-all", "1") + "\n",
+		"-A " + podFwChain + " -m comment --comment \"rule to log dropped traffic POD name:web namespace: nsA\" -j NFLOG",
+		"-A " + podFwChain + " -m comment --comment \"default rule to REJECT traffic destined for POD name:web namespace: nsA\" -j REJECT\n",
+		"-A KUBE-ROUTER-OUTPUT ",
+		"COMMIT\n",
+	} {
+		if strings.Count(input, rule) != 1 {
+			t.Fatalf("expected %q once in the iptables-restore input:\n%s", rule, input)
+		}
+		positions = append(positions, strings.Index(input, rule))
+	}
+	if !sort.IntsAreSorted(positions) {
+		t.Errorf("expected the rules in order in the iptables-restore input:\n%s", input)
+	}
+}
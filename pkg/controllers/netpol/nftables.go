@@ -0,0 +1,568 @@
+package netpol
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+const (
+	policyBackendIPTables = "iptables"
+	policyBackendNFTables = "nftables"
+
+	// table of the nftables backend, holding the sets and chains of the network policies. Like the iptables
+	// backend, it only filters IPv4.
+	nftTableFamily = "ip"
+	nftTableName   = "kube-router-netpol"
+
+	// nftables rejects the rule comments longer than this
+	nftMaxCommentLen = 127
+	// suffix of the sets holding the except entries of an ipBlock set, nftables sets have no nomatch entries
+	nftExceptSetSuffix = "-except"
+)
+
+// nftSet is a set of addresses of the nftables table, the interval sets hold CIDRs
+type nftSet struct {
+	name string
+	// type of the elements of the set, ipv4_addr when empty
+	setType  string
+	interval bool
+	elements []string
+}
+
+// nftChain is a chain of the nftables table, hooked to the netfilter hook if any
+type nftChain struct {
+	name  string
+	hook  string
+	rules []string
+}
+
+// nftRuleset is the content of the nftables table. It is rendered as a script replacing the whole table in a single
+// transaction, so packets go through either the previous ruleset or the new one, complete.
+type nftRuleset struct {
+	sets   []*nftSet
+	chains []*nftChain
+	// sets of the ipBlocks with except entries, by name of the ipBlock set
+	excepts map[string]bool
+	// names of the sets, to add each once
+	setNames map[string]bool
+}
+
+func newNFTRuleset() *nftRuleset {
+	return &nftRuleset{excepts: make(map[string]bool), setNames: make(map[string]bool)}
+}
+
+// addSet adds the set of addresses, a set already added is kept as is. nftables refuses the elements added twice
+// to a set, the addresses are deduplicated.
+func (r *nftRuleset) addSet(name string, interval bool, addresses []string) {
+	if r.setNames[name] {
+		return
+	}
+	r.setNames[name] = true
+	elements := make([]string, 0, len(addresses))
+	seen := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		if !seen[address] {
+			seen[address] = true
+			elements = append(elements, address)
+		}
+	}
+	r.sets = append(r.sets, &nftSet{name: name, interval: interval, elements: elements})
+}
+
+// addIPBlockSet adds the set of the CIDRs of ipBlock peers, their except entries going to the except set of the set
+func (r *nftRuleset) addIPBlockSet(name string, ipBlocks [][]string) {
+	cidrs := make([]string, 0, len(ipBlocks))
+	excepts := make([]string, 0)
+	for _, ipBlock := range ipBlocks {
+		if len(ipBlock) == 0 {
+			continue
+		}
+		if ipBlock[len(ipBlock)-1] == utils.OptionNoMatch {
+			excepts = append(excepts, ipBlock[0])
+		} else {
+			cidrs = append(cidrs, ipBlock[0])
+		}
+	}
+	r.addSet(name, true, cidrs)
+	if len(excepts) != 0 {
+		r.addSet(name+nftExceptSetSuffix, true, excepts)
+		r.excepts[name] = true
+	}
+}
+
+func (r *nftRuleset) addChain(name, hook string) *nftChain {
+	chain := &nftChain{name: name, hook: hook}
+	r.chains = append(r.chains, chain)
+	return chain
+}
+
+// policyRule returns the rule of a policy chain accepting the traffic, the counterpart of policyRuleArgs
+func (r *nftRuleset) policyRule(comment, srcSetName, dstSetName, protocol, dPort string) string {
+	matches := make([]string, 0, 6)
+	if srcSetName != "" {
+		matches = append(matches, "ip saddr @"+srcSetName)
+		if r.excepts[srcSetName] {
+			matches = append(matches, "ip saddr != @"+srcSetName+nftExceptSetSuffix)
+		}
+	}
+	if dstSetName != "" {
+		matches = append(matches, "ip daddr @"+dstSetName)
+		if r.excepts[dstSetName] {
+			matches = append(matches, "ip daddr != @"+dstSetName+nftExceptSetSuffix)
+		}
+	}
+	if protocol != "" {
+		protocol = strings.ToLower(protocol)
+		if dPort != "" {
+			// iptables port ranges are first:last, nftables ones first-last
+			matches = append(matches, protocol+" dport "+strings.Replace(dPort, ":", "-", 1))
+		} else {
+			matches = append(matches, "meta l4proto "+protocol)
+		}
+	}
+	return strings.Join(append(matches, "accept", nftComment(comment)), " ")
+}
+
+// nftComment returns the comment statement of a rule, truncated to the length nftables accepts
+func nftComment(comment string) string {
+	comment = strings.Replace(comment, `"`, "'", -1)
+	if len(comment) > nftMaxCommentLen {
+		comment = comment[:nftMaxCommentLen]
+	}
+	return `comment "` + comment + `"`
+}
+
+// script renders the ruleset as an nft script replacing the table. The table is added before it is deleted so the
+// deletion succeeds on the first sync too.
+func (r *nftRuleset) script() string {
+	var b strings.Builder
+	table := nftTableFamily + " " + nftTableName
+	b.WriteString("add table " + table + "\n")
+	b.WriteString("delete table " + table + "\n")
+	b.WriteString("table " + table + " {\n")
+	for _, set := range r.sets {
+		setType := set.setType
+		if setType == "" {
+			setType = "ipv4_addr"
+		}
+		b.WriteString("\tset " + set.name + " {\n\t\ttype " + setType + "\n")
+		switch {
+		case set.interval && set.setType == "":
+			// overlapping CIDRs, e.g. of two ipBlocks, are merged rather than rejected
+			b.WriteString("\t\tflags interval\n\t\tauto-merge\n")
+		case set.interval:
+			// the concatenations of intervals can not be merged
+			b.WriteString("\t\tflags interval\n")
+		}
+		if len(set.elements) != 0 {
+			b.WriteString("\t\telements = { " + strings.Join(set.elements, ", ") + " }\n")
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, chain := range r.chains {
+		b.WriteString("\tchain " + chain.name + " {\n")
+		if chain.hook != "" {
+			b.WriteString("\t\ttype filter hook " + chain.hook + " priority 0; policy accept;\n")
+		}
+		for _, rule := range chain.rules {
+			b.WriteString("\t\t" + rule + "\n")
+		}
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderNFTables renders the network policy and pod firewall chains of the iptables backend as the chains of the
+// nftables table, and the ipsets as its sets. The chains hooked to forward, output and input jump the traffic of
+// the pods to their pod firewall chains like the rules of the FORWARD, OUTPUT and INPUT chains do.
+func (npc *NetworkPolicyController) renderNFTables() (*nftRuleset, map[string]bool, map[string]bool, error) {
+	r := newNFTRuleset()
+	activePolicyChains := make(map[string]bool)
+	activePodFwChains := make(map[string]bool)
+	if npc.MetricsEnabled {
+		npc.policyChainRules = make(map[string]int)
+	}
+
+	for _, policy := range *npc.networkPoliciesInfo {
+		chain := r.addChain(networkPolicyChainName(policy.namespace, policy.name, ""), "")
+		activePolicyChains[chain.name] = true
+		if rule := npc.nftSampleRule(policy); rule != "" {
+			chain.rules = append(chain.rules, rule)
+		}
+		// the sampler is not counted in the rules of the policy
+		sampleRules := len(chain.rules)
+		if npc.MetricsEnabled {
+			npc.policyChainOwners[chain.name] = chainOwner{namespace: policy.namespace, name: policy.name}
+		}
+
+		targetPodIps := make([]string, 0, len(policy.targetPods))
+		for ip := range policy.targetPods {
+			targetPodIps = append(targetPodIps, ip)
+		}
+		sort.Strings(targetPodIps)
+
+		if policy.policyType == "both" || policy.policyType == "ingress" {
+			targetDestPodSetName := policyDestinationPodIpSetName(policy.namespace, policy.name)
+			r.addSet(targetDestPodSetName, false, targetPodIps)
+			npc.renderNFTIngressRules(r, chain, policy, targetDestPodSetName)
+		}
+		if policy.policyType == "both" || policy.policyType == "egress" {
+			targetSourcePodSetName := policySourcePodIpSetName(policy.namespace, policy.name)
+			r.addSet(targetSourcePodSetName, false, targetPodIps)
+			npc.renderNFTEgressRules(r, chain, policy, targetSourcePodSetName)
+		}
+		if npc.policyChainRules != nil {
+			npc.policyChainRules[chain.name] = len(chain.rules) - sampleRules
+		}
+	}
+
+	for _, set := range []*nftSet{npc.nftClusterAllowListSet(), npc.nftClusterDNSSet()} {
+		if set != nil {
+			r.sets = append(r.sets, set)
+			r.setNames[set.name] = true
+		}
+	}
+	if err := npc.renderNFTPodFirewalls(r, activePodFwChains); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return r, activePolicyChains, activePodFwChains, nil
+}
+
+func (npc *NetworkPolicyController) renderNFTIngressRules(r *nftRuleset, chain *nftChain, policy networkPolicyInfo,
+	targetDestPodSetName string) {
+	comments := newPolicyRuleComments(policy)
+	for i, ingressRule := range policy.ingressRules {
+		if npc.keepPeerPodIPSet(ingressRule.srcPods, ingressRule.namespaceSelectorPeers) {
+			srcPodSetName, setType, srcPodIps := npc.peerPodIPSet(
+				policyIndexedSourcePodIpSetName(policy.namespace, policy.name, i),
+				policyIndexedSourcePodNetIpSetName(policy.namespace, policy.name, i), ingressRule.srcPods)
+			r.addSet(srcPodSetName, setType == utils.TypeHashNet, srcPodIps)
+			for _, portProtocol := range ingressRule.ports {
+				chain.rules = append(chain.rules, r.policyRule(comments.sourcePods, srcPodSetName,
+					targetDestPodSetName, portProtocol.protocol, portProtocol.port))
+			}
+			for j, endPoints := range ingressRule.namedPorts {
+				namedPortSetName := policyIndexedIngressNamedPortIpSetName(policy.namespace, policy.name, i, j)
+				r.addSet(namedPortSetName, false, endPoints.ips)
+				chain.rules = append(chain.rules, r.policyRule(comments.sourcePods, srcPodSetName, namedPortSetName,
+					endPoints.protocol, endPoints.port))
+			}
+			if len(ingressRule.ports) == 0 && len(ingressRule.namedPorts) == 0 {
+				chain.rules = append(chain.rules, r.policyRule(comments.sourcePods, srcPodSetName,
+					targetDestPodSetName, "", ""))
+			}
+		}
+
+		if ingressRule.matchAllSource && !ingressRule.matchAllPorts {
+			for _, portProtocol := range ingressRule.ports {
+				chain.rules = append(chain.rules, r.policyRule(comments.allSources, "", targetDestPodSetName,
+					portProtocol.protocol, portProtocol.port))
+			}
+			for j, endPoints := range ingressRule.namedPorts {
+				namedPortSetName := policyIndexedIngressNamedPortIpSetName(policy.namespace, policy.name, i, j)
+				r.addSet(namedPortSetName, false, endPoints.ips)
+				chain.rules = append(chain.rules, r.policyRule(comments.allSources, "", namedPortSetName,
+					endPoints.protocol, endPoints.port))
+			}
+		}
+		if ingressRule.matchAllSource && ingressRule.matchAllPorts {
+			chain.rules = append(chain.rules, r.policyRule(comments.allSources, "", targetDestPodSetName, "", ""))
+		}
+
+		if len(ingressRule.srcIPBlocks) != 0 {
+			srcIPBlockSetName := policyIndexedSourceIpBlockIpSetName(policy.namespace, policy.name, i)
+			r.addIPBlockSet(srcIPBlockSetName, ingressRule.srcIPBlocks)
+			if ingressRule.matchAllPorts {
+				chain.rules = append(chain.rules, r.policyRule(comments.sourceIPBlocks, srcIPBlockSetName,
+					targetDestPodSetName, "", ""))
+				continue
+			}
+			for _, portProtocol := range ingressRule.ports {
+				chain.rules = append(chain.rules, r.policyRule(comments.sourceIPBlocks, srcIPBlockSetName,
+					targetDestPodSetName, portProtocol.protocol, portProtocol.port))
+			}
+			for j, endPoints := range ingressRule.namedPorts {
+				namedPortSetName := policyIndexedIngressNamedPortIpSetName(policy.namespace, policy.name, i, j)
+				r.addSet(namedPortSetName, false, endPoints.ips)
+				chain.rules = append(chain.rules, r.policyRule(comments.sourceIPBlocks, srcIPBlockSetName,
+					namedPortSetName, endPoints.protocol, endPoints.port))
+			}
+		}
+	}
+}
+
+func (npc *NetworkPolicyController) renderNFTEgressRules(r *nftRuleset, chain *nftChain, policy networkPolicyInfo,
+	targetSourcePodSetName string) {
+	comments := newPolicyRuleComments(policy)
+	for i, egressRule := range policy.egressRules {
+		if npc.keepPeerPodIPSet(egressRule.dstPods, egressRule.namespaceSelectorPeers) {
+			dstPodSetName, setType, dstPodIps := npc.peerPodIPSet(
+				policyIndexedDestinationPodIpSetName(policy.namespace, policy.name, i),
+				policyIndexedDestinationPodNetIpSetName(policy.namespace, policy.name, i), egressRule.dstPods)
+			r.addSet(dstPodSetName, setType == utils.TypeHashNet, dstPodIps)
+			for _, portProtocol := range egressRule.ports {
+				chain.rules = append(chain.rules, r.policyRule(comments.sourcePods, targetSourcePodSetName,
+					dstPodSetName, portProtocol.protocol, portProtocol.port))
+			}
+			for j, endPoints := range egressRule.namedPorts {
+				namedPortSetName := policyIndexedEgressNamedPortIpSetName(policy.namespace, policy.name, i, j)
+				r.addSet(namedPortSetName, false, endPoints.ips)
+				chain.rules = append(chain.rules, r.policyRule(comments.sourcePods, targetSourcePodSetName,
+					namedPortSetName, endPoints.protocol, endPoints.port))
+			}
+			if len(egressRule.ports) == 0 && len(egressRule.namedPorts) == 0 {
+				chain.rules = append(chain.rules, r.policyRule(comments.sourcePods, targetSourcePodSetName,
+					dstPodSetName, "", ""))
+			}
+		}
+
+		if egressRule.matchAllDestinations && !egressRule.matchAllPorts {
+			for _, portProtocol := range egressRule.ports {
+				chain.rules = append(chain.rules, r.policyRule(comments.allDestinations, targetSourcePodSetName, "",
+					portProtocol.protocol, portProtocol.port))
+			}
+		}
+		if egressRule.matchAllDestinations && egressRule.matchAllPorts {
+			chain.rules = append(chain.rules, r.policyRule(comments.allDestinations, targetSourcePodSetName, "",
+				"", ""))
+		}
+
+		if len(egressRule.dstIPBlocks) != 0 {
+			dstIPBlockSetName := policyIndexedDestinationIpBlockIpSetName(policy.namespace, policy.name, i)
+			r.addIPBlockSet(dstIPBlockSetName, egressRule.dstIPBlocks)
+			if egressRule.matchAllPorts {
+				chain.rules = append(chain.rules, r.policyRule(comments.destinationIPBlocks, targetSourcePodSetName,
+					dstIPBlockSetName, "", ""))
+				continue
+			}
+			for _, portProtocol := range egressRule.ports {
+				chain.rules = append(chain.rules, r.policyRule(comments.destinationIPBlocks, targetSourcePodSetName,
+					dstIPBlockSetName, portProtocol.protocol, portProtocol.port))
+			}
+		}
+	}
+}
+
+// nftPodFirewall is a pod of the node the network policies apply to, and the directions they apply in
+type nftPodFirewall struct {
+	pod     podInfo
+	ingress bool
+	egress  bool
+}
+
+// renderNFTPodFirewalls renders the pod firewall chains of the pods of the node and the chains hooked to forward,
+// output and input jumping to them
+func (npc *NetworkPolicyController) renderNFTPodFirewalls(r *nftRuleset, activePodFwChains map[string]bool) error {
+	ingressPods, err := npc.getIngressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		return err
+	}
+	egressPods, err := npc.getEgressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		return err
+	}
+	var podIfaces map[string]string
+	if npc.podInterfaceRules {
+		podIfaces = npc.podInterfaces()
+	}
+
+	firewalls := make(map[string]*nftPodFirewall)
+	firewall := func(pod podInfo) *nftPodFirewall {
+		chainName := podFirewallChainName(pod.namespace, pod.name, "")
+		if _, ok := firewalls[chainName]; !ok {
+			firewalls[chainName] = &nftPodFirewall{pod: pod.withInterface(podIfaces)}
+		}
+		return firewalls[chainName]
+	}
+	for _, pod := range *ingressPods {
+		// below condition occurs when we get trasient update while removing or adding pod
+		// subseqent update will do the correct action
+		if pod.ip != "" {
+			firewall(pod).ingress = true
+		}
+	}
+	for _, pod := range *egressPods {
+		if pod.ip != "" {
+			firewall(pod).egress = true
+		}
+	}
+	chainNames := make([]string, 0, len(firewalls))
+	for chainName := range firewalls {
+		chainNames = append(chainNames, chainName)
+	}
+	sort.Strings(chainNames)
+
+	forward := &nftChain{name: "forward", hook: "forward"}
+	output := &nftChain{name: "output", hook: "output"}
+	input := &nftChain{name: "input", hook: "input"}
+	serviceMark := utils.GetFwMark(utils.FwMarkServiceTraffic)
+	for _, podFwChainName := range chainNames {
+		fw := firewalls[podFwChainName]
+		pod := fw.pod
+		chain := r.addChain(podFwChainName, "")
+		activePodFwChains[podFwChainName] = true
+		if npc.MetricsEnabled {
+			npc.podFwChainOwners[podFwChainName] = chainOwner{namespace: pod.namespace, name: pod.name}
+		}
+
+		// ensure statefull firewall, that permits return traffic for the traffic originated by the pod
+		chain.rules = append(chain.rules, npc.nftStatefulRules()...)
+		if rule := npc.nftClusterAllowListRule(pod.ip); fw.ingress && rule != "" {
+			chain.rules = append(chain.rules, rule)
+		}
+		if rule := npc.nftClusterDNSRule(pod.ip); fw.egress && rule != "" {
+			chain.rules = append(chain.rules, rule)
+		}
+		if fw.ingress {
+			chain.rules = append(chain.rules, npc.nftLocalSourceMatch()+" ip daddr "+pod.ip+" accept "+
+				nftComment("rule to permit the traffic traffic to pods when source is the pod's local node"))
+		}
+		audit := npc.podAudit(pod.ip)
+		for _, policy := range *npc.networkPoliciesInfo {
+			if _, ok := policy.targetPods[pod.ip]; ok && audit.runsThrough(policy) {
+				chain.rules = append(chain.rules, "jump "+networkPolicyChainName(policy.namespace, policy.name, "")+" "+
+					nftComment("run through nw policy "+policy.name))
+			}
+		}
+		chain.rules = append(chain.rules, npc.nftAuditRules(pod)...)
+		if npc.dropLogGroup != 0 {
+			chain.rules = append(chain.rules, "limit rate "+npc.dropLogLimit+" burst 10 packets log group "+
+				strconv.Itoa(int(npc.dropLogGroup))+" "+
+				nftComment("rule to log dropped traffic POD name:"+pod.name+" namespace: "+pod.namespace))
+		}
+		verdict := npc.namespaceDefaultVerdict(pod.namespace)
+		chain.rules = append(chain.rules,
+			verdict+" "+nftComment("default rule to "+strings.ToUpper(verdict)+" traffic destined for POD name:"+
+				pod.name+" namespace: "+pod.namespace))
+
+		if fw.ingress {
+			comment := nftComment("rule to jump traffic destined to POD name:" + pod.name + " namespace: " +
+				pod.namespace + " to chain " + podFwChainName)
+			jump := "ip daddr " + pod.ip + " jump " + podFwChainName + " " + comment
+			forward.rules = append(forward.rules, jump)
+			output.rules = append(output.rules, jump)
+			if local := npc.nftLocalPodMatch("oifname", "ip daddr", pod); local != "" {
+				forward.rules = append(forward.rules, local+" jump "+podFwChainName+" "+comment)
+			}
+		}
+		if fw.egress {
+			comment := nftComment("rule to jump traffic from POD name:" + pod.name + " namespace: " +
+				pod.namespace + " to chain " + podFwChainName)
+			jump := "ip saddr " + pod.ip + " jump " + podFwChainName + " " + comment
+			forward.rules = append(forward.rules, jump)
+			output.rules = append(output.rules, jump)
+			// the traffic to IPVS services is evaluated in output once DNATed, see egressInputChainJumpArgs
+			input.rules = append(input.rules, fmt.Sprintf("meta mark & 0x%x != 0x%x %s", serviceMark.Mask,
+				serviceMark.Value, jump))
+			if local := npc.nftLocalPodMatch("iifname", "ip saddr", pod); local != "" {
+				forward.rules = append(forward.rules, local+" jump "+podFwChainName+" "+comment)
+			}
+		}
+	}
+	r.chains = append(r.chains, forward, output, input)
+	return nil
+}
+
+// nftLocalSourceMatch returns the match of the traffic originated by the node
+func (npc *NetworkPolicyController) nftLocalSourceMatch() string {
+	if npc.addrtypeUnsupported {
+		return "ip saddr " + npc.nodeIP.String()
+	}
+	return "fib saddr type local"
+}
+
+// nftLocalPodMatch returns the match of the traffic between the pod and the other pods of the node, the
+// counterpart of localPodJumpArgs. Bridged traffic goes through the hooks of the ip family as well when the bridge
+// netfilter module is loaded, it is matched by the address of the pod alone, so there is no match for it.
+func (npc *NetworkPolicyController) nftLocalPodMatch(ifaceMatch, addrMatch string, pod podInfo) string {
+	if !npc.podsRoutedMode {
+		return ""
+	}
+	if pod.iface != "" {
+		// all the traffic through the interface belongs to the pod, whatever its IP
+		return ifaceMatch + ` "` + pod.iface + `"`
+	}
+	return ifaceMatch + ` "` + npc.podInterfacePrefix + `*" ` + addrMatch + " " + pod.ip
+}
+
+// syncNFTables replaces the nftables table with the rendering of the network policies and pod firewalls, and returns
+// the active policy chains, pod firewall chains and sets
+func (npc *NetworkPolicyController) syncNFTables() (map[string]bool, map[string]bool, map[string]bool, error) {
+	start := time.Now()
+	defer func() {
+		endTime := time.Since(start)
+		metrics.ControllerPolicyChainsSyncTime.Observe(endTime.Seconds())
+		glog.V(2).Infof("Syncing the nftables table took %v", endTime)
+	}()
+
+	r, activePolicyChains, activePodFwChains, err := npc.renderNFTables()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := utils.ExecWithOptions(utils.ExecOptions{Stdin: strings.NewReader(r.script())}, "nft", "-f",
+		"-"); err != nil {
+		return nil, nil, nil, err
+	}
+	if npc.MetricsEnabled {
+		exportPolicyRuleCounts(npc.policyChainRules, npc.policyChainOwners, npc.tenantLabels)
+	}
+	activeSets := make(map[string]bool, len(r.setNames))
+	for name := range r.setNames {
+		activeSets[name] = true
+	}
+	return activePolicyChains, activePodFwChains, activeSets, nil
+}
+
+// deleteNFTablesTable deletes the table of the nftables backend, if nft is installed
+func deleteNFTablesTable() error {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return nil
+	}
+	table := nftTableFamily + " " + nftTableName
+	_, err := utils.ExecWithOptions(utils.ExecOptions{
+		Stdin: strings.NewReader("add table " + table + "\ndelete table " + table + "\n")}, "nft", "-f", "-")
+	return err
+}
+
+// cleanupIPTablesBackend deletes the chains and ipsets left by the iptables backend, e.g. before kube-router was
+// switched to the nftables backend, as they would still reject the traffic the network policies allow
+func (npc *NetworkPolicyController) cleanupIPTablesBackend() {
+	if iptablesCmdHandler, err := iptables.New(); err == nil {
+		// the quarantined chains jump to the network policy chains, they go first
+		var quarantine *chainQuarantine
+		if _, err := quarantine.deleteExpired(iptablesCmdHandler); err != nil {
+			glog.Errorf("Failed to delete the quarantined chains of the iptables backend: %s", err)
+		}
+		if err := deletePolicyChains(iptablesCmdHandler); err != nil {
+			glog.Errorf("Failed to delete the chains of the iptables backend: %s", err)
+		}
+	}
+	if ip6tablesCmdHandler := newIP6TablesCmdHandler(); ip6tablesCmdHandler != nil {
+		if err := deletePolicyChains(ip6tablesCmdHandler); err != nil {
+			glog.Errorf("Failed to delete the ip6tables chains of the iptables backend: %s", err)
+		}
+	}
+	if err := npc.ipSetHandler.Save(); err != nil {
+		glog.Errorf("Failed to list the ipsets of the iptables backend: %s", err)
+		return
+	}
+	for _, set := range npc.ipSetHandler.List() {
+		if !isStalePolicyIPSet(set.Name, nil) {
+			continue
+		}
+		if err := set.Destroy(); err != nil {
+			glog.Errorf("Failed to delete ipset %s of the iptables backend: %s", set.Name, err)
+		}
+	}
+}
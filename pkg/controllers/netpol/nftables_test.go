@@ -0,0 +1,83 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestRenderNFTables(t *testing.T) {
+	krNetPol, cluster := newTestController(t,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA", Labels: map[string]string{"app": "web"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.1"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "nsA", Labels: map[string]string{"app": "client"}},
+			Status: v1.PodStatus{HostIP: "10.10.10.11", PodIP: "1.1.2.1"}})
+
+	tcp := v1.ProtocolTCP
+	port := intstr.FromInt(80)
+	netpol := tNetpol{
+		name:        "allow-client",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress: []netv1.NetworkPolicyIngressRule{
+			{
+				From: []netv1.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}},
+					{IPBlock: &netv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}},
+				},
+				Ports: []netv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+			},
+		},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, policyChains, podFwChains, err := krNetPol.renderNFTables()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policyChain := networkPolicyChainName("nsA", "allow-client", "")
+	podFwChain := podFirewallChainName("nsA", "web", "")
+	if !policyChains[policyChain] || !podFwChains[podFwChain] || len(policyChains) != 1 || len(podFwChains) != 1 {
+		t.Fatalf("expected chains %s and %s, got %v and %v", policyChain, podFwChain, policyChains, podFwChains)
+	}
+
+	targetSet := policyDestinationPodIpSetName("nsA", "allow-client")
+	sourceSet := policyIndexedSourcePodIpSetName("nsA", "allow-client", 0)
+	ipBlockSet := policyIndexedSourceIpBlockIpSetName("nsA", "allow-client", 0)
+	script := r.script()
+	for _, expected := range []string{
+		"add table ip kube-router-netpol\ndelete table ip kube-router-netpol\ntable ip kube-router-netpol {\n",
+		"\tset " + targetSet + " {\n\t\ttype ipv4_addr\n\t\telements = { 1.1.1.1 }\n\t}\n",
+		"\tset " + sourceSet + " {\n\t\ttype ipv4_addr\n\t\telements = { 1.1.2.1 }\n\t}\n",
+		"\tset " + ipBlockSet + " {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\tauto-merge\n\t\telements = { 10.0.0.0/8 }\n",
+		"\tset " + ipBlockSet + "-except {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\tauto-merge\n\t\telements = { 10.1.0.0/16 }\n",
+		"\t\tip saddr @" + sourceSet + " ip daddr @" + targetSet + " tcp dport 80 accept comment",
+		"\t\tip saddr @" + ipBlockSet + " ip saddr != @" + ipBlockSet + "-except ip daddr @" + targetSet +
+			" tcp dport 80 accept comment",
+		"\tchain " + podFwChain + " {\n\t\tct state related,established accept",
+		"\t\tfib saddr type local ip daddr 1.1.1.1 accept",
+		"\t\tjump " + policyChain + " comment \"run through nw policy allow-client\"\n",
+		"\t\tlimit rate 10/minute burst 10 packets log group 100",
+		"\t\treject comment",
+		"\tchain forward {\n\t\ttype filter hook forward priority 0; policy accept;\n\t\tip daddr 1.1.1.1 jump " + podFwChain,
+		"\tchain output {\n\t\ttype filter hook output priority 0; policy accept;\n\t\tip daddr 1.1.1.1 jump " + podFwChain,
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected %q in the rendered nftables script:\n%s", expected, script)
+		}
+	}
+	// only the egress policies jump from input
+	if !strings.Contains(script, "\tchain input {\n\t\ttype filter hook input priority 0; policy accept;\n\t}\n") {
+		t.Errorf("expected an empty input chain in the rendered nftables script:\n%s", script)
+	}
+}
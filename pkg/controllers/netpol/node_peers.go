@@ -0,0 +1,203 @@
+package netpol
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+)
+
+// network policies isolating their pods for egress can be annotated with a JSON list of egress rules to the nodes
+// matching a label selector, on the ports of each rule, all of them when it has none. The rules are appended to the
+// egress rules of the policy, matching the addresses of the nodes like ipBlock peers, e.g. to allow the pods to the
+// exporters of the monitoring nodes:
+//
+//	kube-router.io/egress-node-peers: '[{"nodeSelector": {"matchLabels": {"role": "monitoring"}},
+//	  "ports": [{"protocol": "TCP", "port": 9100}]}]'
+const egressNodePeersAnnotation = "kube-router.io/egress-node-peers"
+
+// nodePeerRule is an egress rule to the nodes of the egress-node-peers annotation
+type nodePeerRule struct {
+	NodeSelector metav1.LabelSelector `json:"nodeSelector"`
+	Ports        []nodePeerPort       `json:"ports,omitempty"`
+}
+
+// nodePeerPort is a port, or a range of ports, of a nodePeerRule
+type nodePeerPort struct {
+	// Protocol is TCP, UDP or SCTP, TCP when empty
+	Protocol api.Protocol `json:"protocol,omitempty"`
+	// Port is the port, all the ports of the protocol when 0
+	Port int32 `json:"port,omitempty"`
+	// EndPort is the last port of the range starting at Port, if any
+	EndPort int32 `json:"endPort,omitempty"`
+}
+
+// parseEgressNodePeers returns the rules of the egress-node-peers annotation of the network policy, nil when the policy
+// has none or the annotation is malformed
+func parseEgressNodePeers(policy *networking.NetworkPolicy) []nodePeerRule {
+	value, ok := policy.Annotations[egressNodePeersAnnotation]
+	if !ok {
+		return nil
+	}
+	var rules []nodePeerRule
+	err := json.Unmarshal([]byte(value), &rules)
+	if err == nil {
+		err = validateNodePeerRules(rules)
+	}
+	if err != nil {
+		glog.Errorf("Ignoring annotation %s of network policy %s/%s: %s", egressNodePeersAnnotation,
+			policy.Namespace, policy.Name, err)
+		return nil
+	}
+	return rules
+}
+
+func validateNodePeerRules(rules []nodePeerRule) error {
+	for _, rule := range rules {
+		if _, err := metav1.LabelSelectorAsSelector(&rule.NodeSelector); err != nil {
+			return fmt.Errorf("invalid node selector: %s", err)
+		}
+		for _, port := range rule.Ports {
+			if port.Port < 0 || port.Port > 65535 {
+				return fmt.Errorf("invalid port %d", port.Port)
+			}
+			if port.EndPort != 0 && (port.Port == 0 || port.EndPort < port.Port || port.EndPort > 65535) {
+				return fmt.Errorf("invalid end port %d of port %d", port.EndPort, port.Port)
+			}
+		}
+	}
+	return nil
+}
+
+// nodeAddresses returns the IPv4 internal and external addresses of the node
+func nodeAddresses(node *api.Node) []string {
+	addresses := make([]string, 0, len(node.Status.Addresses))
+	for _, address := range node.Status.Addresses {
+		if address.Type != api.NodeInternalIP && address.Type != api.NodeExternalIP {
+			continue
+		}
+		if ip := net.ParseIP(address.Address); ip != nil && ip.To4() != nil {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return addresses
+}
+
+// nodePeerEgressRules returns the egress rules of the egress-node-peers annotation of the network policy, matching
+// the addresses of the nodes their selector selects in the ipset of their ipBlock peers
+func (npc *NetworkPolicyController) nodePeerEgressRules(policy *networking.NetworkPolicy) []egressRule {
+	rules := parseEgressNodePeers(policy)
+	if len(rules) == 0 {
+		return nil
+	}
+	if npc.NodeLister == nil {
+		glog.Errorf("Ignoring annotation %s of network policy %s/%s, the nodes are not known",
+			egressNodePeersAnnotation, policy.Namespace, policy.Name)
+		return nil
+	}
+
+	egressRules := make([]egressRule, 0, len(rules))
+	for _, rule := range rules {
+		selector, _ := metav1.LabelSelectorAsSelector(&rule.NodeSelector)
+		seen := make(map[string]bool)
+		addresses := make([]string, 0)
+		for _, obj := range npc.NodeLister.List() {
+			node, ok := obj.(*api.Node)
+			if !ok || !selector.Matches(labels.Set(node.Labels)) {
+				continue
+			}
+			for _, address := range nodeAddresses(node) {
+				if !seen[address] {
+					seen[address] = true
+					addresses = append(addresses, address)
+				}
+			}
+		}
+		sort.Strings(addresses)
+
+		// a rule without node matches no destination rather than all of them
+		egressRule := egressRule{dstPods: make([]podInfo, 0), dstIPBlocks: make([][]string, 0, len(addresses))}
+		for _, address := range addresses {
+			egressRule.dstIPBlocks = append(egressRule.dstIPBlocks, []string{address + "/32", utils.OptionTimeout, "0"})
+		}
+		egressRule.matchAllPorts = len(rule.Ports) == 0
+		ports := make([]networking.NetworkPolicyPort, 0, len(rule.Ports))
+		for i := range rule.Ports {
+			port := networking.NetworkPolicyPort{Protocol: &rule.Ports[i].Protocol}
+			if rule.Ports[i].Protocol == "" {
+				port.Protocol = nil
+			}
+			if rule.Ports[i].Port != 0 {
+				number := intstr.FromInt(int(rule.Ports[i].Port))
+				port.Port = &number
+			}
+			if rule.Ports[i].EndPort != 0 {
+				port.EndPort = &rule.Ports[i].EndPort
+			}
+			ports = append(ports, port)
+		}
+		egressRule.ports, egressRule.namedPorts = npc.processNetworkPolicyPorts(ports, nil)
+		egressRules = append(egressRules, egressRule)
+	}
+	return egressRules
+}
+
+// nodePeersChanged tells whether the update of the node changes the nodes the egress-node-peers annotations select or
+// their addresses
+func nodePeersChanged(oldObj, newObj interface{}) bool {
+	oldNode, ok := oldObj.(*api.Node)
+	if !ok {
+		return true
+	}
+	newNode, ok := newObj.(*api.Node)
+	if !ok {
+		return true
+	}
+	return !reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+		!reflect.DeepEqual(nodeAddresses(oldNode), nodeAddresses(newNode))
+}
+
+// newNodeEventHandler queues a full sync when the nodes change while network policies have egress-node-peers
+// annotations, or when the labels named by the peer-topology annotations of the network policies change
+func (npc *NetworkPolicyController) newNodeEventHandler() cache.ResourceEventHandler {
+	onUpdate := func(oldObj, newObj interface{}) {
+		if !npc.readyForUpdates {
+			return
+		}
+		// written by the syncs building the network policies
+		npc.mu.Lock()
+		nodePeers, topologyLabels := npc.nodePeers, npc.peerTopologyLabels
+		npc.mu.Unlock()
+		switch {
+		case nodePeers && (oldObj == nil || newObj == nil || nodePeersChanged(oldObj, newObj)):
+			glog.V(2).Infof("Received update to the nodes selected by the %s annotations", egressNodePeersAnnotation)
+		case peerTopologyChanged(topologyLabels, oldObj, newObj):
+			glog.V(2).Infof("Received update to the node labels named by the %s annotations", peerTopologyAnnotation)
+		default:
+			return
+		}
+		npc.syncQueue.add(syncFull)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			onUpdate(nil, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			onUpdate(oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			onUpdate(obj, nil)
+		},
+	}
+}
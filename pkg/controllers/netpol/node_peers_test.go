@@ -0,0 +1,92 @@
+package netpol
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNodePeerEgressRules(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.NodeLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i, name := range []string{"monitoring-a", "monitoring-b", "worker"} {
+		node := newFakeNode(name, "10.0.0."+strconv.Itoa(i+1))
+		node.Status.Addresses = append(node.Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP,
+			Address: "192.168.0." + strconv.Itoa(i+1)}, v1.NodeAddress{Type: v1.NodeHostName, Address: name})
+		if name != "worker" {
+			node.Labels = map[string]string{"role": "monitoring"}
+		}
+		if err := krNetPol.NodeLister.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy := &netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "scrape", Namespace: "monitoring", Annotations: map[string]string{
+			egressNodePeersAnnotation: `[{"nodeSelector": {"matchLabels": {"role": "monitoring"}}, "ports": ` +
+				`[{"protocol": "TCP", "port": 9100, "endPort": 9110}, {"protocol": "UDP"}]}, ` +
+				`{"nodeSelector": {"matchLabels": {"role": "storage"}}}]`}},
+		Spec: netv1.NetworkPolicySpec{
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeEgress},
+			Egress:      []netv1.NetworkPolicyEgressRule{{}},
+		},
+	}
+	tAddToInformerStore(t, cluster.netpolInformer, policy)
+
+	policies, err := krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules := (*policies)[0].egressRules
+	if len(rules) != 3 || !rules[0].matchAllDestinations {
+		t.Fatalf("expected the rules of the annotation after the rule of the spec, got %+v", rules)
+	}
+	want := [][]string{{"10.0.0.1/32", utils.OptionTimeout, "0"}, {"10.0.0.2/32", utils.OptionTimeout, "0"},
+		{"192.168.0.1/32", utils.OptionTimeout, "0"}, {"192.168.0.2/32", utils.OptionTimeout, "0"}}
+	if !reflect.DeepEqual(rules[1].dstIPBlocks, want) || rules[1].matchAllDestinations {
+		t.Errorf("expected the addresses of the monitoring nodes, got %v", rules[1].dstIPBlocks)
+	}
+	wantPorts := []protocolAndPort{{protocol: "TCP", port: "9100:9110"}, {protocol: "UDP", port: ""}}
+	if !reflect.DeepEqual(rules[1].ports, wantPorts) || rules[1].matchAllPorts {
+		t.Errorf("expected the ports of the rule, got %+v", rules[1].ports)
+	}
+	if len(rules[2].dstIPBlocks) != 0 || rules[2].matchAllDestinations || !rules[2].matchAllPorts {
+		t.Errorf("expected a rule selecting no node to match no destination, got %+v", rules[2])
+	}
+	if !krNetPol.nodePeers {
+		t.Error("expected the changes of the nodes to be watched")
+	}
+
+	policy = policy.DeepCopy()
+	policy.Annotations[egressNodePeersAnnotation] = `[{"nodeSelector": {"matchLabels": {"role": "monitoring"}}, ` +
+		`"ports": [{"port": 70000}]}]`
+	if err := cluster.netpolInformer.GetStore().Update(policy); err != nil {
+		t.Fatalf("error updating network policy in Informer Store: %v", err)
+	}
+	policies, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rules := (*policies)[0].egressRules; len(rules) != 1 {
+		t.Errorf("expected a malformed annotation to be ignored, got %+v", rules)
+	}
+
+	node := newFakeNode("worker", "10.0.0.3")
+	heartbeat := node.DeepCopy()
+	heartbeat.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	if nodePeersChanged(node, heartbeat) {
+		t.Error("expected a status update keeping the addresses not to change the node peers")
+	}
+	relabeled := node.DeepCopy()
+	relabeled.Labels = map[string]string{"role": "monitoring"}
+	if !nodePeersChanged(node, relabeled) {
+		t.Error("expected a label update to change the node peers")
+	}
+}
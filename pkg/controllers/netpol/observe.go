@@ -0,0 +1,419 @@
+package netpol
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+)
+
+// errObserveOnly is returned by the operations needing the dataplane in observe-only mode
+var errObserveOnly = errors.New("the network policies are not programmed in observe-only mode")
+
+// Flow is a connection whose verdict under the network policies is asked for
+type Flow struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Protocol is TCP, UDP or SCTP, or any other protocol of a network policy port
+	Protocol string `json:"protocol"`
+	// Port is the destination port, 0 for protocols without ports
+	Port int `json:"port,omitempty"`
+}
+
+// Verdict is the verdict of the network policies for a flow, and the policies it comes from
+type Verdict struct {
+	Flow
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+// observe renders the state the sync would program, without programming it
+func (npc *NetworkPolicyController) observe() error {
+	state, err := npc.renderState()
+	if err != nil {
+		return errors.New("Aborting sync. Failed to render the desired state: " + err.Error())
+	}
+	npc.recordRenderedState(state)
+	glog.V(1).Infof("Observe-only mode: rendered %d ipsets and %d rules jumping to the dispatch and pod firewall "+
+		"chains, not programmed", len(npc.desiredIPSets()), len(state.Lines(nodestate.IPTables)))
+	return nil
+}
+
+// recordRenderedState keeps the state the last sync programmed, or would have programmed in observe-only mode, for
+// the introspection of the admin socket, and exports its size
+func (npc *NetworkPolicyController) recordRenderedState(state nodestate.State) {
+	npc.renderedState = state
+	if !npc.MetricsEnabled {
+		return
+	}
+	entries := 0
+	sets := npc.desiredIPSets()
+	for _, set := range sets {
+		entries += len(set.entries)
+	}
+	metrics.ControllerPolicyDesiredState.WithLabelValues("ipsets").Set(float64(len(sets)))
+	metrics.ControllerPolicyDesiredState.WithLabelValues("ipset_entries").Set(float64(entries))
+	metrics.ControllerPolicyDesiredState.WithLabelValues("pod_firewall_jumps").Set(float64(podFirewallJumps(state)))
+}
+
+// podFirewallJumps returns the number of rules of the state jumping to a pod firewall chain, the rules of the
+// dispatch chains, not those of the built-in chains jumping to them
+func podFirewallJumps(state nodestate.State) int {
+	jumps := 0
+	for _, line := range state.Lines(nodestate.IPTables) {
+		// the rules are rendered as their table followed by their chain, their target and their comment
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		rule, ok, err := utils.ParseIPTablesRule("-A " + fields[1])
+		if err == nil && ok && strings.HasPrefix(rule.Target, kubePodFirewallChainPrefix) {
+			jumps++
+		}
+	}
+	return jumps
+}
+
+// DesiredState returns the network policy ipsets and the rules jumping to the pod firewall chains the last sync
+// programmed, or would have programmed in observe-only mode. It is nil until a sync succeeded.
+func (npc *NetworkPolicyController) DesiredState() nodestate.State {
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	return npc.renderedState
+}
+
+// Verdicts evaluates the flows against the network policies of the last sync, the way the pod firewall chains
+// evaluate their first packet: the critical flows, the cluster network policies, the cluster allow lists or the
+// cluster DNS, then the network policies, the FQDNs of their egress included, and the audit mode of the pods. The
+// exempt pods and the pods of the excluded namespaces have no pod firewall chain. The traffic the node itself sends to
+// its pods, which the chains always accept, is not singled out.
+func (npc *NetworkPolicyController) Verdicts(flows []Flow) ([]Verdict, error) {
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	if npc.networkPoliciesInfo == nil {
+		return nil, errors.New("no network policy synced yet")
+	}
+	pods := npc.podsByIP()
+	verdicts := make([]Verdict, 0, len(flows))
+	for _, flow := range flows {
+		src, dst := net.ParseIP(flow.Source), net.ParseIP(flow.Destination)
+		if src == nil || dst == nil {
+			return nil, errors.New("invalid addresses in flow " + flow.Source + " -> " + flow.Destination)
+		}
+		verdicts = append(verdicts, npc.evaluateFlow(pods, flow))
+	}
+	return verdicts, nil
+}
+
+// podsByIP returns the pods of the pod lister by their IP, the host-network pods left out
+func (npc *NetworkPolicyController) podsByIP() map[string]*api.Pod {
+	pods := make(map[string]*api.Pod)
+	if npc.podLister == nil {
+		return pods
+	}
+	for _, obj := range npc.podLister.List() {
+		pod := obj.(*api.Pod)
+		if pod.Status.PodIP != "" && !pod.Spec.HostNetwork {
+			pods[pod.Status.PodIP] = pod
+		}
+	}
+	return pods
+}
+
+// evaluateFlow returns the verdict of the pod firewall chains for the flow: the egress of the source pod, then the
+// ingress of the destination pod
+func (npc *NetworkPolicyController) evaluateFlow(pods map[string]*api.Pod, flow Flow) Verdict {
+	verdict := Verdict{Flow: flow, Allowed: true}
+	reasons := make([]string, 0, 2)
+	for _, direction := range []string{"egress", "ingress"} {
+		firewalled, allowed, reason := npc.evaluateFlowDirection(pods, flow, direction)
+		if !firewalled {
+			continue
+		}
+		verdict.Allowed = verdict.Allowed && allowed
+		reasons = append(reasons, reason)
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "no network policy isolates the pods")
+	}
+	verdict.Reason = strings.Join(reasons, ", ")
+	return verdict
+}
+
+// evaluateFlowDirection returns whether the pod of the flow in the direction, its source for the egress and its
+// destination for the ingress, has a pod firewall chain for it, and whether the chain accepts the flow and why
+func (npc *NetworkPolicyController) evaluateFlowDirection(pods map[string]*api.Pod, flow Flow,
+	direction string) (bool, bool, string) {
+	ip := flow.Destination
+	if direction == "egress" {
+		ip = flow.Source
+	}
+	if pod, ok := pods[ip]; ok && (exemptsPod(pod) || npc.excludesNamespace(pod.Namespace)) {
+		return false, true, ""
+	}
+	pod, isolated := podInfo{}, false
+	for _, policy := range *npc.networkPoliciesInfo {
+		if target, ok := policy.targetPods[ip]; ok && (policy.policyType == "both" || policy.policyType == direction) {
+			pod, isolated = target, true
+			break
+		}
+	}
+	selected := npc.clusterNetworkPoliciesSelectPod(ip, direction)
+	if !isolated && !selected {
+		return false, true, ""
+	}
+	name := ip
+	if p, ok := pods[ip]; ok {
+		name, pod.priorityClass = p.Namespace+"/"+p.Name, p.Spec.PriorityClassName
+	} else if isolated {
+		name = pod.namespace + "/" + pod.name
+	}
+
+	if flow, ok := npc.matchingCriticalFlow(pod.priorityClass, flow, direction); ok {
+		return true, true, direction + " allowed by the critical flow " + flow
+	}
+	if target, policy, ok := npc.matchingClusterNetworkPolicy(ip, flow, direction); ok {
+		switch target {
+		case "ACCEPT":
+			return true, true, direction + " allowed by cluster network policy " + policy
+		case "REJECT":
+			return true, false, direction + " denied by cluster network policy " + policy
+		}
+	}
+	if direction == "ingress" && clusterAllowListMatches(npc.clusterAllowList, flow) {
+		return true, true, "ingress allowed by the cluster allow lists"
+	}
+	if direction == "egress" && clusterDNSMatches(npc.clusterDNS, flow) {
+		return true, true, "egress allowed by the cluster DNS"
+	}
+
+	audit := npc.podAudit(ip)
+	for _, policy := range *npc.networkPoliciesInfo {
+		if _, ok := policy.targetPods[ip]; !ok || !audit.runsThrough(policy) {
+			continue
+		}
+		if npc.policyAllowsFlow(policy, flow, direction) {
+			return true, true, direction + " allowed by " + policy.namespace + "/" + policy.name
+		}
+	}
+
+	switch {
+	case !isolated:
+		return true, true, direction + " of pod " + name + " not isolated by network policies"
+	case direction == "ingress" && audit.ingress, direction == "egress" && audit.egress:
+		return true, true, "no " + direction + " policy of pod " + name + " allows it, accepted in audit mode"
+	}
+	return true, false, "no " + direction + " policy of pod " + name + " allows it"
+}
+
+// policyAllowsFlow tells whether a rule of the network policy in the direction matches the flow, or for the egress,
+// whether the cluster DNS resolved a domain name of the policy to its destination
+func (npc *NetworkPolicyController) policyAllowsFlow(policy networkPolicyInfo, flow Flow, direction string) bool {
+	if direction == "ingress" {
+		for _, rule := range policy.ingressRules {
+			if ingressRuleMatches(rule, flow) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range policy.egressRules {
+		if egressRuleMatches(rule, flow) {
+			return true
+		}
+	}
+	if npc.fqdnSnooper == nil || len(policy.egressFQDNs) == 0 {
+		return false
+	}
+	name, ok := npc.fqdnSnooper.cache.name(flow.Destination, time.Now())
+	if !ok {
+		return false
+	}
+	for _, fqdn := range policy.egressFQDNs {
+		if fqdnMatches(fqdn, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingCriticalFlow returns the first critical flow of the pods of the priority class in the direction matching the
+// flow
+func (npc *NetworkPolicyController) matchingCriticalFlow(priorityClass string, flow Flow, direction string) (string,
+	bool) {
+	for _, critical := range npc.criticalFlows {
+		if critical.direction != direction || (critical.priorityClass != "" && critical.priorityClass != priorityClass) {
+			continue
+		}
+		// the CIDR of the egress flows is the one of their destination
+		matched := flow
+		if direction == "egress" {
+			matched.Source = flow.Destination
+		}
+		if clusterAllowListMatches([]allowListEntry{critical.entry}, matched) {
+			return critical.String(), true
+		}
+	}
+	return "", false
+}
+
+// matchingClusterNetworkPolicy returns the target, ACCEPT or REJECT, and the name of the first ClusterNetworkPolicy
+// deciding the flow of the pod with the IP in the direction, in the order of the tiers. A Pass policy matching the
+// flow skips the rest of its tier.
+func (npc *NetworkPolicyController) matchingClusterNetworkPolicy(ip string, flow Flow, direction string) (string,
+	string, bool) {
+	peer := flow.Source
+	if direction == "egress" {
+		peer = flow.Destination
+	}
+	for _, tier := range clusterNetworkPolicyTiers {
+	policies:
+		for _, policy := range npc.clusterNetworkPolicies {
+			if policy.tier != tier {
+				continue
+			}
+			if i := sort.SearchStrings(policy.pods, ip); i == len(policy.pods) || policy.pods[i] != ip {
+				continue
+			}
+			rules := policy.ingressRules
+			if direction == "egress" {
+				rules = policy.egressRules
+			}
+			for _, rule := range rules {
+				if !clusterNetworkPolicyPortsMatch(rule.ports, flow) || !ipBlocksMatch(rule.ipBlocks, peer) {
+					continue
+				}
+				if policy.target == "RETURN" {
+					break policies
+				}
+				return policy.target, policy.name, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// clusterNetworkPolicyPortsMatch tells whether the flow goes to one of the ports of a rule of a ClusterNetworkPolicy,
+// all the ports when it has none
+func clusterNetworkPolicyPortsMatch(ports []protocolAndPort, flow Flow) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, port := range ports {
+		if strings.EqualFold(port.protocol, flow.Protocol) && portMatches(port.port, flow.Port) {
+			return true
+		}
+	}
+	return false
+}
+
+func ingressRuleMatches(rule ingressRule, flow Flow) bool {
+	if !portsMatch(rule.matchAllPorts, rule.ports, rule.namedPorts, flow) {
+		return false
+	}
+	if rule.matchAllSource {
+		return true
+	}
+	for _, pod := range rule.srcPods {
+		if pod.ip == flow.Source {
+			return true
+		}
+	}
+	return ipBlocksMatch(rule.srcIPBlocks, flow.Source)
+}
+
+func egressRuleMatches(rule egressRule, flow Flow) bool {
+	if !portsMatch(rule.matchAllPorts, rule.ports, rule.namedPorts, flow) {
+		return false
+	}
+	if rule.matchAllDestinations {
+		return true
+	}
+	for _, pod := range rule.dstPods {
+		if pod.ip == flow.Destination {
+			return true
+		}
+	}
+	return ipBlocksMatch(rule.dstIPBlocks, flow.Destination)
+}
+
+// portsMatch tells whether the flow goes to one of the ports of a rule. Named ports only match the pods they resolve
+// to.
+func portsMatch(matchAllPorts bool, ports []protocolAndPort, namedPorts []endPoints, flow Flow) bool {
+	if matchAllPorts {
+		return true
+	}
+	for _, port := range ports {
+		if strings.EqualFold(port.protocol, flow.Protocol) && portMatches(port.port, flow.Port) {
+			return true
+		}
+	}
+	for _, endPoints := range namedPorts {
+		if !strings.EqualFold(endPoints.protocol, flow.Protocol) || !portMatches(endPoints.port, flow.Port) {
+			continue
+		}
+		for _, ip := range endPoints.ips {
+			if ip == flow.Destination {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// portMatches tells whether the port is the port of a rule, all ports when empty or a first:last range
+func portMatches(rulePort string, port int) bool {
+	if rulePort == "" {
+		return true
+	}
+	first, last := rulePort, rulePort
+	if i := strings.Index(rulePort, ":"); i >= 0 {
+		first, last = rulePort[:i], rulePort[i+1:]
+	}
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return false
+	}
+	to, err := strconv.Atoi(last)
+	if err != nil {
+		return false
+	}
+	return port >= from && port <= to
+}
+
+// ipBlocksMatch tells whether the address is in the ipBlocks of a rule. Like the hash:net ipsets they are programmed
+// in, the most specific CIDR containing the address decides, except entries excluding it.
+func ipBlocksMatch(ipBlocks [][]string, address string) bool {
+	ip := net.ParseIP(address)
+	matched, longest := false, -1
+	for _, entry := range ipBlocks {
+		if len(entry) == 0 {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(entry[0])
+		if err != nil || !cidr.Contains(ip) {
+			continue
+		}
+		ones, _ := cidr.Mask.Size()
+		if ones <= longest {
+			continue
+		}
+		longest = ones
+		matched = true
+		for _, option := range entry[1:] {
+			if option == utils.OptionNoMatch {
+				matched = false
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,155 @@
+package netpol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvaluateFlow(t *testing.T) {
+	web := podInfo{ip: "10.1.0.2", name: "web", namespace: "nsA"}
+	client := podInfo{ip: "10.1.0.3", name: "client", namespace: "nsA"}
+	policies := []networkPolicyInfo{
+		{
+			name: "allow-client", namespace: "nsA", policyType: "ingress",
+			targetPods: map[string]podInfo{web.ip: web},
+			ingressRules: []ingressRule{
+				{ports: []protocolAndPort{{protocol: "TCP", port: "8080:8090"}}, srcPods: []podInfo{client}},
+				{ports: []protocolAndPort{{protocol: "TCP", port: "443"}},
+					srcIPBlocks: [][]string{{"192.168.0.0/16", "timeout", "0"}, {"192.168.1.0/24", "timeout", "0", "nomatch"}}},
+			},
+		},
+		{
+			name: "egress-to-web", namespace: "nsA", policyType: "egress",
+			targetPods: map[string]podInfo{client.ip: client},
+			egressRules: []egressRule{
+				{matchAllPorts: true, dstPods: []podInfo{web}},
+			},
+		},
+	}
+
+	testCases := []struct {
+		flow    Flow
+		allowed bool
+		reason  string
+	}{
+		{Flow{Source: client.ip, Destination: web.ip, Protocol: "TCP", Port: 8085}, true,
+			"egress allowed by nsA/egress-to-web, ingress allowed by nsA/allow-client"},
+		{Flow{Source: client.ip, Destination: web.ip, Protocol: "UDP", Port: 8085}, false,
+			"egress allowed by nsA/egress-to-web, no ingress policy of pod nsA/web allows it"},
+		{Flow{Source: client.ip, Destination: "10.1.0.9", Protocol: "TCP", Port: 80}, false,
+			"no egress policy of pod nsA/client allows it"},
+		{Flow{Source: "192.168.2.1", Destination: web.ip, Protocol: "TCP", Port: 443}, true,
+			"ingress allowed by nsA/allow-client"},
+		{Flow{Source: "192.168.1.1", Destination: web.ip, Protocol: "TCP", Port: 443}, false,
+			"no ingress policy of pod nsA/web allows it"},
+		{Flow{Source: "10.1.0.9", Destination: "10.1.0.8", Protocol: "TCP", Port: 80}, true,
+			"no network policy isolates the pods"},
+	}
+	npc := &NetworkPolicyController{networkPoliciesInfo: &policies}
+	for _, test := range testCases {
+		verdict := npc.evaluateFlow(nil, test.flow)
+		if verdict.Allowed != test.allowed || verdict.Reason != test.reason {
+			t.Errorf("flow %v: expected allowed %t (%s), got %t (%s)", test.flow, test.allowed, test.reason,
+				verdict.Allowed, verdict.Reason)
+		}
+	}
+}
+
+func TestEvaluateFlowClusterWideRules(t *testing.T) {
+	web := podInfo{ip: "10.1.0.2", name: "web", namespace: "nsA"}
+	client := podInfo{ip: "10.1.0.3", name: "client", namespace: "nsA"}
+	policies := []networkPolicyInfo{
+		{
+			name: "deny-all", namespace: "nsA", policyType: "both",
+			targetPods: map[string]podInfo{web.ip: web},
+		},
+		{
+			name: "allow-api", namespace: "nsA", policyType: "egress", audit: true,
+			targetPods:  map[string]podInfo{client.ip: client},
+			egressFQDNs: []string{"api.example.com"},
+		},
+	}
+	snooper := &fqdnSnooper{cache: newFQDNCache()}
+	snooper.cache.records["api.example.com"] = map[string]time.Time{"203.0.113.10": time.Now().Add(time.Minute)}
+	npc := &NetworkPolicyController{
+		networkPoliciesInfo: &policies,
+		fqdnSnooper:         snooper,
+		criticalFlows: []criticalFlow{{direction: "egress", priorityClass: "system-node-critical",
+			entry: allowListEntry{cidr: "10.0.0.10/32", protocol: "tcp", port: 6443}, source: criticalFlowsFlagSource}},
+		clusterNetworkPolicies: []clusterNetworkPolicyInfo{
+			{name: "pass-dns", tier: "ClusterAdmin", target: "RETURN", pods: []string{web.ip, client.ip},
+				egressRules: []clusterNetworkPolicyRuleInfo{{ipBlocks: [][]string{{"10.96.0.10/32"}},
+					ports: []protocolAndPort{{protocol: "UDP", port: "53"}}}}},
+			{name: "deny-metadata", tier: "ClusterAdmin", target: "REJECT", pods: []string{web.ip, client.ip},
+				egressRules: []clusterNetworkPolicyRuleInfo{{ipBlocks: [][]string{{"169.254.169.254/32"}}}}},
+			{name: "deny-dns", tier: "Platform", target: "REJECT", pods: []string{web.ip, client.ip},
+				egressRules: []clusterNetworkPolicyRuleInfo{{ipBlocks: [][]string{{"10.96.0.0/12"}}}}},
+		},
+		clusterDNS: []clusterDNSEntry{{ip: "10.96.0.10", protocol: "UDP", port: 53}},
+	}
+	pods := map[string]*api.Pod{
+		web.ip: {ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA"},
+			Spec: api.PodSpec{PriorityClassName: "system-node-critical"}},
+		client.ip: {ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "nsA"}},
+		"10.1.0.4": {ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "nsA",
+			Annotations: map[string]string{podNetpolAnnotation: podNetpolDisabled}}},
+	}
+
+	testCases := []struct {
+		flow    Flow
+		allowed bool
+		reason  string
+	}{
+		{Flow{Source: web.ip, Destination: "10.0.0.10", Protocol: "TCP", Port: 6443}, true,
+			"egress allowed by the critical flow egress 10.0.0.10/32 tcp/6443 of priority class system-node-critical " +
+				"from --critical-flows"},
+		{Flow{Source: client.ip, Destination: "169.254.169.254", Protocol: "TCP", Port: 80}, false,
+			"egress denied by cluster network policy deny-metadata"},
+		{Flow{Source: client.ip, Destination: "10.96.0.10", Protocol: "UDP", Port: 53}, false,
+			"egress denied by cluster network policy deny-dns"},
+		{Flow{Source: web.ip, Destination: "10.96.0.10", Protocol: "UDP", Port: 53}, false,
+			"egress denied by cluster network policy deny-dns"},
+		{Flow{Source: client.ip, Destination: "203.0.113.10", Protocol: "TCP", Port: 443}, true,
+			"egress allowed by nsA/allow-api"},
+		{Flow{Source: client.ip, Destination: "203.0.113.11", Protocol: "TCP", Port: 443}, true,
+			"no egress policy of pod nsA/client allows it, accepted in audit mode"},
+		{Flow{Source: "10.1.0.4", Destination: web.ip, Protocol: "TCP", Port: 80}, false,
+			"no ingress policy of pod nsA/web allows it"},
+		{Flow{Source: web.ip, Destination: "10.1.0.4", Protocol: "TCP", Port: 80}, false,
+			"no egress policy of pod nsA/web allows it"},
+		{Flow{Source: "10.1.0.4", Destination: "10.1.0.9", Protocol: "TCP", Port: 80}, true,
+			"no network policy isolates the pods"},
+	}
+	for _, test := range testCases {
+		verdict := npc.evaluateFlow(pods, test.flow)
+		if verdict.Allowed != test.allowed || verdict.Reason != test.reason {
+			t.Errorf("flow %v: expected allowed %t (%s), got %t (%s)", test.flow, test.allowed, test.reason,
+				verdict.Allowed, verdict.Reason)
+		}
+	}
+
+	// the pods only the cluster network policies apply to accept the rest of the traffic
+	policies = nil
+	verdict := npc.evaluateFlow(pods, Flow{Source: client.ip, Destination: "10.1.0.9", Protocol: "TCP", Port: 80})
+	if !verdict.Allowed || verdict.Reason != "egress of pod nsA/client not isolated by network policies" {
+		t.Errorf("expected the egress of the pod not isolated by network policies allowed, got %+v", verdict)
+	}
+}
+
+func TestPodFirewallJumps(t *testing.T) {
+	state := make(nodestate.State)
+	state.Add(nodestate.IPTables,
+		dispatchJumpRule("FORWARD", dispatchJumpComment),
+		podFirewallJumpRule("FORWARD", "rule to jump traffic destined to POD name:web namespace: nsA",
+			podFirewallChainName("nsA", "web", "1")),
+		nodestate.IPTablesRule("filter", "FORWARD", "ACCEPT", "rule not matching -j "+kubePodFirewallChainPrefix+"*"))
+	if jumps := podFirewallJumps(state); jumps != 1 {
+		t.Errorf("expected the jump to the pod firewall chain only, got %d jumps in %v", jumps,
+			state.Lines(nodestate.IPTables))
+	}
+}
@@ -0,0 +1,79 @@
+package netpol
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// peerPodIPSet returns the name, type and entries of the ipset of the pods of a peer: a hash:ip set of their
+// addresses, or once there are more pods than the aggregation threshold a hash:net set of the CIDRs covering exactly
+// their addresses. The pods of a node share its pod CIDR, so the addresses of large peers are mostly contiguous and
+// few CIDRs cover them. The sets of both types are named differently, as ipset can't change the type of a set.
+func (npc *NetworkPolicyController) peerPodIPSet(ipSetName, netIPSetName string, pods []podInfo) (string, string, []string) {
+	ips := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		ips = append(ips, pod.ip)
+	}
+	if npc.peerIPSetAggregationThreshold <= 0 || len(ips) <= npc.peerIPSetAggregationThreshold {
+		return ipSetName, utils.TypeHashIP, ips
+	}
+	return netIPSetName, utils.TypeHashNet, aggregateIPs(ips)
+}
+
+// aggregateIPs returns the fewest CIDRs covering exactly the IPv4 addresses, a single address being kept as such.
+// Other addresses are returned unchanged.
+func aggregateIPs(ips []string) []string {
+	addresses := make([]uint32, 0, len(ips))
+	entries := make([]string, 0)
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip).To4()
+		if parsed == nil {
+			entries = append(entries, ip)
+			continue
+		}
+		addresses = append(addresses, binary.BigEndian.Uint32(parsed))
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	for i := 0; i < len(addresses); {
+		// range of contiguous addresses, duplicates included
+		first, last := addresses[i], addresses[i]
+		for i++; i < len(addresses) && addresses[i]-last <= 1; i++ {
+			last = addresses[i]
+		}
+		entries = append(entries, rangeCIDRs(first, last)...)
+	}
+	return entries
+}
+
+// rangeCIDRs splits the range of addresses into the largest aligned CIDRs
+func rangeCIDRs(first, last uint32) []string {
+	cidrs := make([]string, 0)
+	for {
+		// the largest block aligned on first and within the range
+		size := 32
+		if first != 0 {
+			size = bits.TrailingZeros32(first)
+		}
+		for size > 0 && uint64(first)+(uint64(1)<<uint(size))-1 > uint64(last) {
+			size--
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, first)
+		if size == 0 {
+			cidrs = append(cidrs, ip.String())
+		} else {
+			cidrs = append(cidrs, ip.String()+"/"+strconv.Itoa(32-size))
+		}
+		end := uint64(first) + (uint64(1) << uint(size)) - 1
+		if end >= uint64(last) {
+			return cidrs
+		}
+		first = uint32(end + 1)
+	}
+}
@@ -0,0 +1,47 @@
+package netpol
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func TestAggregateIPs(t *testing.T) {
+	testCases := []struct {
+		ips  []string
+		want []string
+	}{
+		{[]string{"10.1.0.1"}, []string{"10.1.0.1"}},
+		{[]string{"10.1.0.3", "10.1.0.0", "10.1.0.2", "10.1.0.1", "10.1.0.2"}, []string{"10.1.0.0/30"}},
+		{[]string{"10.1.0.1", "10.1.0.2", "10.1.0.3", "10.1.0.4", "10.1.0.9"},
+			[]string{"10.1.0.1", "10.1.0.2/31", "10.1.0.4", "10.1.0.9"}},
+		{[]string{"10.1.1.255", "10.1.2.0"}, []string{"10.1.1.255", "10.1.2.0"}},
+		{[]string{"255.255.255.254", "255.255.255.255", "255.255.255.255"}, []string{"255.255.255.254/31"}},
+		{[]string{"fd00::1", "10.1.0.0", "10.1.0.1"}, []string{"fd00::1", "10.1.0.0/31"}},
+	}
+	for _, tc := range testCases {
+		if got := aggregateIPs(tc.ips); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("expected %v aggregated into %v, got %v", tc.ips, tc.want, got)
+		}
+	}
+
+	pods := make([]podInfo, 0, 256)
+	for i := 0; i < 256; i++ {
+		pods = append(pods, podInfo{ip: "10.1.3." + strconv.Itoa(i)})
+	}
+	npc := &NetworkPolicyController{}
+	if name, setType, entries := npc.peerPodIPSet("ip", "net", pods); name != "ip" || setType != utils.TypeHashIP ||
+		len(entries) != 256 {
+		t.Errorf("expected no aggregation when disabled, got %s %s with %d entries", name, setType, len(entries))
+	}
+	npc.peerIPSetAggregationThreshold = 100
+	name, setType, entries := npc.peerPodIPSet("ip", "net", pods)
+	if name != "net" || setType != utils.TypeHashNet || !reflect.DeepEqual(entries, []string{"10.1.3.0/24"}) {
+		t.Errorf("expected the pods aggregated into the pod CIDR, got %s %s %v", name, setType, entries)
+	}
+	if name, _, _ := npc.peerPodIPSet("ip", "net", pods[:100]); name != "ip" {
+		t.Errorf("expected no aggregation up to the threshold, got %s", name)
+	}
+}
@@ -0,0 +1,73 @@
+package netpol
+
+import (
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	listers "k8s.io/client-go/listers/core/v1"
+)
+
+// network policies annotated with a label selector exclude the pods it selects, in any namespace, from their
+// namespace selector and ipBlock peers, e.g. to allow traffic from all the namespaces but the scanner pods
+const peerExceptAnnotation = "kube-router.io/peer-except"
+
+// peerExcept is the exclusion of the pods selected by the peer-except annotation of a network policy
+type peerExcept struct {
+	selector labels.Selector
+	// nomatch entries of the excluded pods for the ipBlock peer ipsets, built on first use
+	ipBlocks [][]string
+}
+
+// newPeerExcept returns the exclusion of the network policy, nil when the policy excludes no pod
+func newPeerExcept(policy *networking.NetworkPolicy) *peerExcept {
+	value, ok := policy.Annotations[peerExceptAnnotation]
+	if !ok {
+		return nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil || selector.Empty() {
+		glog.Errorf("Ignoring annotation %s of network policy %s/%s, it is not a non-empty label selector: %q",
+			peerExceptAnnotation, policy.Namespace, policy.Name, value)
+		return nil
+	}
+	return &peerExcept{selector: selector}
+}
+
+// excludes tells whether the pod of a peer is excluded. Only the peers selecting pods by namespace selector
+// exclude pods, a pod selector alone can already leave pods out.
+func (e *peerExcept) excludes(peer networking.NetworkPolicyPeer, pod *api.Pod) bool {
+	return e != nil && peer.NamespaceSelector != nil && e.selector.Matches(labels.Set(pod.Labels))
+}
+
+// ipBlockEntries returns the nomatch entries of the excluded pods to add to the ipset of the ipBlock peers of a
+// rule, so the pods within the CIDRs of the peers are not matched
+func (npc *NetworkPolicyController) ipBlockEntries(e *peerExcept, peers []networking.NetworkPolicyPeer) [][]string {
+	if e == nil {
+		return nil
+	}
+	ipBlockPeers := false
+	for _, peer := range peers {
+		ipBlockPeers = ipBlockPeers || (peer.IPBlock != nil && peer.PodSelector == nil && peer.NamespaceSelector == nil)
+	}
+	if !ipBlockPeers {
+		return nil
+	}
+	if e.ipBlocks == nil {
+		e.ipBlocks = make([][]string, 0)
+		pods, err := listers.NewPodLister(npc.podLister).List(e.selector)
+		if err != nil {
+			glog.Errorf("Failed to list the pods excluded from network policy peers: %s", err)
+			return nil
+		}
+		for _, pod := range pods {
+			if pod.Status.PodIP == "" || pod.Spec.HostNetwork {
+				continue
+			}
+			e.ipBlocks = append(e.ipBlocks, []string{pod.Status.PodIP, utils.OptionTimeout, "0", utils.OptionNoMatch})
+		}
+	}
+	return e.ipBlocks
+}
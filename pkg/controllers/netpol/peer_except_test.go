@@ -0,0 +1,77 @@
+package netpol
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPeerExcept(t *testing.T) {
+	krNetPol, cluster := newTestController(t,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "backend"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "security"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "backend"},
+			Status: v1.PodStatus{PodIP: "1.1.1.1"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "scanner", Namespace: "security",
+			Labels: map[string]string{"role": "scanner"}}, Status: v1.PodStatus{PodIP: "1.1.2.1"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: "security"},
+			Status: v1.PodStatus{PodIP: "1.1.2.2"}})
+
+	policy := &netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "all-but-scanners", Namespace: "backend",
+			Annotations: map[string]string{peerExceptAnnotation: "role=scanner"}},
+		Spec: netv1.NetworkPolicySpec{
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress},
+			Ingress: []netv1.NetworkPolicyIngressRule{
+				{From: []netv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}}},
+			},
+			Egress: []netv1.NetworkPolicyEgressRule{
+				{To: []netv1.NetworkPolicyPeer{{IPBlock: &netv1.IPBlock{CIDR: "1.1.0.0/16"}},
+					{IPBlock: &netv1.IPBlock{CIDR: "1.2.0.0/16"}}}},
+			},
+		},
+	}
+	tAddToInformerStore(t, cluster.netpolInformer, policy)
+
+	build := func() networkPolicyInfo {
+		policies, err := krNetPol.buildNetworkPoliciesInfo()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(*policies) != 1 {
+			t.Fatalf("expected a single policy, got %+v", *policies)
+		}
+		return (*policies)[0]
+	}
+
+	built := build()
+	srcPods := built.ingressRules[0].srcPods
+	if len(srcPods) != 2 {
+		t.Fatalf("expected the pods of all namespaces but the scanner as sources, got %+v", srcPods)
+	}
+	for _, pod := range srcPods {
+		if pod.name == "scanner" {
+			t.Errorf("expected the scanner pod to be excluded from the sources")
+		}
+	}
+	want := [][]string{{"1.1.0.0/16", utils.OptionTimeout, "0"}, {"1.2.0.0/16", utils.OptionTimeout, "0"},
+		{"1.1.2.1", utils.OptionTimeout, "0", utils.OptionNoMatch}}
+	if !reflect.DeepEqual(built.egressRules[0].dstIPBlocks, want) {
+		t.Errorf("expected a single nomatch entry for the scanner pod, got %v", built.egressRules[0].dstIPBlocks)
+	}
+
+	policy = policy.DeepCopy()
+	policy.Annotations[peerExceptAnnotation] = "role in (scanner"
+	if err := cluster.netpolInformer.GetStore().Update(policy); err != nil {
+		t.Fatalf("error updating network policy in Informer Store: %v", err)
+	}
+	built = build()
+	if len(built.ingressRules[0].srcPods) != 3 || len(built.egressRules[0].dstIPBlocks) != 2 {
+		t.Errorf("expected an invalid annotation to be ignored, got %+v", built)
+	}
+}
@@ -0,0 +1,91 @@
+package netpol
+
+import (
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// network policies annotated with the key of a node label restrict their pod peers to the pods running on the nodes
+// with the same value of the label as the node, e.g. topology.kubernetes.io/zone to allow the traffic of the peers
+// within the zone of the node only
+const peerTopologyAnnotation = "kube-router.io/peer-topology"
+
+// peerTopology is the set of nodes sharing the value of a node label with the node
+type peerTopology struct {
+	nodes map[string]bool
+}
+
+// newPeerTopology returns the topology the pod peers of the network policy are restricted to, nil when they are not
+// restricted. The topologies are shared by the policies of a sync through the cache, by label key.
+func (npc *NetworkPolicyController) newPeerTopology(policy *networking.NetworkPolicy,
+	cache map[string]*peerTopology) *peerTopology {
+	key, ok := policy.Annotations[peerTopologyAnnotation]
+	if !ok {
+		return nil
+	}
+	if topology, ok := cache[key]; ok {
+		return topology
+	}
+	if key == "" || npc.NodeLister == nil {
+		glog.Errorf("Ignoring annotation %s of network policy %s/%s: %q is not a node label or nodes are not known",
+			peerTopologyAnnotation, policy.Namespace, policy.Name, key)
+		return nil
+	}
+
+	// with the node unknown or not labeled, the peers match no pod rather than pods of other topologies
+	topology := &peerTopology{nodes: make(map[string]bool)}
+	cache[key] = topology
+	obj, exists, err := npc.NodeLister.GetByKey(npc.nodeHostName)
+	if err != nil || !exists {
+		glog.Errorf("Failed to find node %s for the topology of network policy peers: %v", npc.nodeHostName, err)
+		return topology
+	}
+	value, ok := obj.(*api.Node).Labels[key]
+	if !ok {
+		glog.Errorf("Node %s has no label %s, the pod peers of the network policies annotated with %s match no pod",
+			npc.nodeHostName, key, peerTopologyAnnotation)
+		return topology
+	}
+	for _, obj := range npc.NodeLister.List() {
+		node, ok := obj.(*api.Node)
+		if !ok {
+			continue
+		}
+		if nodeValue, ok := node.Labels[key]; ok && nodeValue == value {
+			topology.nodes[node.Name] = true
+		}
+	}
+	return topology
+}
+
+// excludes tells whether the pod of a peer runs outside the topology of the node
+func (t *peerTopology) excludes(pod *api.Pod) bool {
+	return t != nil && !t.nodes[pod.Spec.NodeName]
+}
+
+// peerTopologyChanged tells whether the node event changes the value of one of the labels, a nil object standing for
+// the node before its addition or after its deletion
+func peerTopologyChanged(labels map[string]bool, oldObj, newObj interface{}) bool {
+	oldLabels, newLabels := peerTopologyNodeLabels(oldObj), peerTopologyNodeLabels(newObj)
+	for key := range labels {
+		oldValue, oldOk := oldLabels[key]
+		newValue, newOk := newLabels[key]
+		if oldOk != newOk || oldValue != newValue {
+			return true
+		}
+	}
+	return false
+}
+
+func peerTopologyNodeLabels(obj interface{}) map[string]string {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if node, ok := obj.(*api.Node); ok {
+		return node.Labels
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package netpol
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPeerTopology(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.NodeLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	const zoneLabel = "topology.kubernetes.io/zone"
+	for name, zone := range map[string]string{"node": "a", "node-2": "a", "node-3": "b", "node-4": ""} {
+		node := newFakeNode(name, "")
+		if zone != "" {
+			node.Labels = map[string]string{zoneLabel: zone}
+		}
+		if err := krNetPol.NodeLister.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "backend"}})
+	for name, node := range map[string]string{"api": "node", "web-a": "node-2", "web-b": "node-3", "web": "node-4"} {
+		tAddToInformerStore(t, cluster.podInformer, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "backend"},
+			Spec: v1.PodSpec{NodeName: node}, Status: v1.PodStatus{PodIP: "1.1.1." + strconv.Itoa(len(name))}})
+	}
+	policy := &netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "same-zone", Namespace: "backend",
+			Annotations: map[string]string{peerTopologyAnnotation: zoneLabel}},
+		Spec: netv1.NetworkPolicySpec{
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+			Ingress: []netv1.NetworkPolicyIngressRule{
+				{From: []netv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+			},
+		},
+	}
+	tAddToInformerStore(t, cluster.netpolInformer, policy)
+
+	sources := func() []string {
+		policies, err := krNetPol.buildNetworkPoliciesInfo()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names := make([]string, 0)
+		for _, pod := range (*policies)[0].ingressRules[0].srcPods {
+			names = append(names, pod.name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if got := sources(); !reflect.DeepEqual(got, []string{"api", "web-a"}) {
+		t.Errorf("expected the pods of the zone of the node as sources, got %v", got)
+	}
+	krNetPol.nodeHostName = "node-4"
+	if got := sources(); len(got) != 0 {
+		t.Errorf("expected no source on a node without zone, got %v", got)
+	}
+
+	// the zone of the nodes is resolved by the syncs, the changes of the label queue one
+	krNetPol.syncQueue = newSyncQueue()
+	krNetPol.readyForUpdates = true
+	handler := krNetPol.newNodeEventHandler()
+	node := newFakeNode("node-3", "")
+	node.Labels = map[string]string{zoneLabel: "b"}
+	heartbeat := node.DeepCopy()
+	heartbeat.Labels["kubernetes.io/hostname"] = "node-3"
+	handler.OnUpdate(node, heartbeat)
+	if kind, _ := krNetPol.syncQueue.take(); kind != syncNone {
+		t.Errorf("expected an update keeping the zone of the node not to queue a sync, got %v", kind)
+	}
+	rezoned := node.DeepCopy()
+	rezoned.Labels[zoneLabel] = "a"
+	handler.OnUpdate(node, rezoned)
+	if kind, _ := krNetPol.syncQueue.take(); kind != syncFull {
+		t.Errorf("expected a change of the zone of the node to queue a full sync, got %v", kind)
+	}
+	handler.OnAdd(newFakeNode("node-5", ""))
+	if kind, _ := krNetPol.syncQueue.take(); kind != syncNone {
+		t.Errorf("expected a node without zone not to queue a sync, got %v", kind)
+	}
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "node-3", Obj: node})
+	if kind, _ := krNetPol.syncQueue.take(); kind != syncFull {
+		t.Errorf("expected the deletion of a node with a zone to queue a full sync, got %v", kind)
+	}
+
+	// the labels are read once the sync writing them is done
+	krNetPol.mu.Lock()
+	handled := make(chan struct{})
+	go func() {
+		handler.OnUpdate(rezoned, node)
+		close(handled)
+	}()
+	select {
+	case <-handled:
+		t.Errorf("expected the event to wait for the sync")
+	case <-time.After(50 * time.Millisecond):
+	}
+	krNetPol.mu.Unlock()
+	<-handled
+	if kind, _ := krNetPol.syncQueue.take(); kind != syncFull {
+		t.Errorf("expected the change of the zone of the node to queue a full sync, got %v", kind)
+	}
+}
@@ -0,0 +1,36 @@
+package netpol
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+)
+
+// pods annotated with kube-router.io/netpol=disabled get no pod firewall chain, so no network policy is enforced on
+// their traffic, e.g. while debugging or for system pods that must never be firewalled. They are still peers of the
+// network policies of the other pods.
+const (
+	podNetpolAnnotation = "kube-router.io/netpol"
+	podNetpolDisabled   = "disabled"
+)
+
+// exemptsPod tells whether the pod is annotated to be exempt from the network policies
+func exemptsPod(pod *api.Pod) bool {
+	value, ok := pod.Annotations[podNetpolAnnotation]
+	if !ok {
+		return false
+	}
+	if strings.EqualFold(strings.TrimSpace(value), podNetpolDisabled) {
+		return true
+	}
+	glog.Errorf("Ignoring annotation %s of pod %s/%s, %q is not %s", podNetpolAnnotation, pod.Namespace, pod.Name,
+		value, podNetpolDisabled)
+	return false
+}
+
+// podNetpolAnnotationChanged tells whether the update of the pod changed its netpol annotation
+func podNetpolAnnotationChanged(oldPod, newPod *api.Pod) bool {
+	return oldPod.Annotations[podNetpolAnnotation] != newPod.Annotations[podNetpolAnnotation]
+}
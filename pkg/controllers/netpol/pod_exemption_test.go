@@ -0,0 +1,66 @@
+package netpol
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodNetpolAnnotation(t *testing.T) {
+	krNetPol, cluster := newTestController(t, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsA"}})
+
+	for i, annotations := range []map[string]string{
+		nil,
+		{podNetpolAnnotation: "disabled"},
+		{podNetpolAnnotation: "off"},
+	} {
+		tAddToInformerStore(t, cluster.podInformer,
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-" + strconv.Itoa(i), Namespace: "nsA",
+				Labels: map[string]string{"app": "web"}, Annotations: annotations},
+				Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1." + strconv.Itoa(i+1)}})
+	}
+	netpol := tNetpol{
+		name:        "deny-all",
+		namespace:   "nsA",
+		podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		ingress:     []netv1.NetworkPolicyIngressRule{},
+		egress:      []netv1.NetworkPolicyEgressRule{},
+	}
+	netpol.createFakeNetpol(t, cluster.netpolInformer)
+
+	var err error
+	krNetPol.networkPoliciesInfo, err = krNetPol.buildNetworkPoliciesInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filterTable := utils.NewIPTablesRestore("filter")
+	chains, _, err := krNetPol.syncPodFirewallChains(filterTable, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a malformed annotation is ignored rather than exempting the pod
+	expected := map[string]bool{
+		podFirewallChainName("nsA", "web-0", "1"): true,
+		podFirewallChainName("nsA", "web-2", "1"): true,
+	}
+	if !reflect.DeepEqual(chains, expected) {
+		t.Errorf("expected the pod firewall chains %v, got %v", expected, chains)
+	}
+	if strings.Contains(string(filterTable.Bytes()), "1.1.1.2") {
+		t.Errorf("expected no rule for the exempt pod:\n%s", filterTable.Bytes())
+	}
+
+	oldPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "nsA"}}
+	newPod := oldPod.DeepCopy()
+	newPod.Annotations = map[string]string{podNetpolAnnotation: "disabled"}
+	if !podNetpolAnnotationChanged(oldPod, newPod) || podNetpolAnnotationChanged(newPod, newPod) {
+		t.Errorf("expected only the change of the annotation to be detected")
+	}
+}
@@ -0,0 +1,56 @@
+package netpol
+
+import (
+	"net"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/vishvananda/netlink"
+)
+
+// podInterfacesFromRoutes maps the pod IPs to the host side interfaces of the pods, from the host routes to the pods
+// through the interfaces named after prefix
+func podInterfacesFromRoutes(routes []netlink.Route, linkNames map[int]string, prefix string) map[string]string {
+	ifaces := make(map[string]string)
+	for _, route := range routes {
+		if route.Dst == nil {
+			continue
+		}
+		if ones, bits := route.Dst.Mask.Size(); ones != bits {
+			continue
+		}
+		name, ok := linkNames[route.LinkIndex]
+		if !ok || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ifaces[route.Dst.IP.String()] = name
+	}
+	return ifaces
+}
+
+// podInterfaces returns the host side interfaces of the pods of the node by pod IP, as routed by the node
+func (npc *NetworkPolicyController) podInterfaces() map[string]string {
+	links, err := netlink.LinkList()
+	if err != nil {
+		glog.Errorf("Failed to list the interfaces to discover the pod interfaces: %s", err)
+		return nil
+	}
+	linkNames := make(map[int]string, len(links))
+	for _, link := range links {
+		linkNames[link.Attrs().Index] = link.Attrs().Name
+	}
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		glog.Errorf("Failed to list the routes to discover the pod interfaces: %s", err)
+		return nil
+	}
+	return podInterfacesFromRoutes(routes, linkNames, npc.podInterfacePrefix)
+}
+
+// withInterface returns the pod with its host side interface set from ifaces, if it was discovered
+func (pod podInfo) withInterface(ifaces map[string]string) podInfo {
+	if ip := net.ParseIP(pod.ip); ip != nil {
+		pod.iface = ifaces[ip.String()]
+	}
+	return pod
+}
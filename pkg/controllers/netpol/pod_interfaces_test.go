@@ -0,0 +1,31 @@
+package netpol
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestPodInterfacesFromRoutes(t *testing.T) {
+	_, podNet, _ := net.ParseCIDR("10.1.0.0/24")
+	route := func(dst string, linkIndex int) netlink.Route {
+		return netlink.Route{Dst: &net.IPNet{IP: net.ParseIP(dst), Mask: net.CIDRMask(32, 32)}, LinkIndex: linkIndex}
+	}
+	routes := []netlink.Route{
+		route("10.1.0.2", 2),
+		route("10.1.0.3", 3),
+		route("10.1.0.4", 4),
+		{Dst: podNet, LinkIndex: 2},
+		{LinkIndex: 1},
+	}
+	ifaces := podInterfacesFromRoutes(routes, map[int]string{1: "eth0", 2: "veth1234", 3: "veth5678", 4: "tunl0"}, "veth")
+	expected := map[string]string{"10.1.0.2": "veth1234", "10.1.0.3": "veth5678"}
+	if !reflect.DeepEqual(ifaces, expected) {
+		t.Errorf("expected pod interfaces %v, got %v", expected, ifaces)
+	}
+	if pod := (podInfo{ip: "10.1.0.3"}).withInterface(ifaces); pod.iface != "veth5678" {
+		t.Errorf("expected the pod interface to be veth5678, got %s", pod.iface)
+	}
+}
@@ -0,0 +1,253 @@
+package netpol
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The packet and byte counters of the rules of the policy and pod firewall chains are read every
+// --policy-counters-period and exported as metrics: the counters of the ACCEPT rules of the policy chains as
+// policy_packets_total and policy_bytes_total, labeled by the namespace and the name of the policy and by the rule,
+// and the counters of the default rule of the pod firewall chains as pod_rejected_packets_total, labeled by the
+// namespace and the name of the pod. The chains are replaced on every sync, so the counters of the replaced chains are
+// read once more before they are deleted and added to the totals of their rules.
+
+// counterKey identifies the counters of a rule in the metrics. For the policy chains, the rule is the direction of
+// the rule and its position among the ACCEPT rules of the policy chain in that direction, e.g. ingress-0, which stays
+// the same across syncs as long as the policy does. It is empty for the pod firewall chains.
+type counterKey struct {
+	namespace string
+	name      string
+	rule      string
+}
+
+type ruleCount struct {
+	packets uint64
+	bytes   uint64
+}
+
+// chainCounters is the prometheus collector of the counters of rules of the chains of the owners
+type chainCounters struct {
+	mu sync.Mutex
+	// counters of the chains replaced by the syncs
+	totals map[counterKey]ruleCount
+	// counters of the active chains read by the last pass, by chain
+	active map[string]map[counterKey]ruleCount
+
+	// key returns the key of the counters of the rule of the chain of the owner, and whether they are exported.
+	// positions is the number of rules of the chain keyed so far, by direction.
+	key func(rule utils.IPTablesSaveRule, owner chainOwner, positions map[string]int) (counterKey, bool)
+	// descriptions of the metrics, bytes is nil when the byte counters are not exported
+	packets *prometheus.Desc
+	bytes   *prometheus.Desc
+}
+
+func newPolicyCounters() *chainCounters {
+	return newChainCounters(policyRuleCounterKey, metrics.PolicyPacketsTotal, metrics.PolicyBytesTotal)
+}
+
+func newPodRejectCounters() *chainCounters {
+	return newChainCounters(podRejectCounterKey, metrics.PodRejectedPacketsTotal, nil)
+}
+
+func newChainCounters(key func(utils.IPTablesSaveRule, chainOwner, map[string]int) (counterKey, bool),
+	packets, bytes *prometheus.Desc) *chainCounters {
+	return &chainCounters{
+		totals:  make(map[counterKey]ruleCount),
+		active:  make(map[string]map[counterKey]ruleCount),
+		key:     key,
+		packets: packets,
+		bytes:   bytes,
+	}
+}
+
+// policyRuleDirection returns the direction of the ACCEPT rule of a policy chain from its comment
+func policyRuleDirection(comment string) string {
+	if strings.Contains(comment, " to all destinations ") || strings.Contains(comment, " to specified ipBlocks ") {
+		return "egress"
+	}
+	return "ingress"
+}
+
+// policyRuleCounterKey keys the ACCEPT rules of the policy chains by their direction and position
+func policyRuleCounterKey(rule utils.IPTablesSaveRule, owner chainOwner, positions map[string]int) (counterKey,
+	bool) {
+	if rule.Target != "ACCEPT" {
+		return counterKey{}, false
+	}
+	direction := policyRuleDirection(rule.Comment)
+	position := positions[direction]
+	positions[direction]++
+	return counterKey{namespace: owner.namespace, name: owner.name, rule: direction + "-" + strconv.Itoa(position)},
+		true
+}
+
+// podRejectCounterKey keys the default rules of the pod firewall chains, rejecting or dropping the traffic no network
+// policy accepted, by pod
+func podRejectCounterKey(rule utils.IPTablesSaveRule, owner chainOwner, _ map[string]int) (counterKey, bool) {
+	if (rule.Target != "REJECT" && rule.Target != "DROP") || !strings.HasPrefix(rule.Comment, "default rule to ") {
+		return counterKey{}, false
+	}
+	return counterKey{namespace: owner.namespace, name: owner.name}, true
+}
+
+// ruleCounts returns the counters of the rules of the chains of the owners accepted by the filter, by chain
+func (c *chainCounters) ruleCounts(rules []utils.IPTablesSaveRule, owners map[string]chainOwner,
+	filter func(chain string) bool) map[string]map[counterKey]ruleCount {
+	counts := make(map[string]map[counterKey]ruleCount)
+	positions := make(map[string]map[string]int)
+	for _, rule := range rules {
+		owner, ok := owners[rule.Chain]
+		if !ok || !filter(rule.Chain) {
+			continue
+		}
+		if positions[rule.Chain] == nil {
+			positions[rule.Chain] = make(map[string]int)
+		}
+		key, ok := c.key(rule, owner, positions[rule.Chain])
+		if !ok {
+			continue
+		}
+		if counts[rule.Chain] == nil {
+			counts[rule.Chain] = make(map[counterKey]ruleCount)
+		}
+		count := counts[rule.Chain][key]
+		count.packets += rule.Packets
+		count.bytes += rule.Bytes
+		counts[rule.Chain][key] = count
+	}
+	return counts
+}
+
+// update records the counters of the rules of the active chains, the chains of the owners
+func (c *chainCounters) update(rules []utils.IPTablesSaveRule, owners map[string]chainOwner) {
+	active := c.ruleCounts(rules, owners, func(string) bool { return true })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = active
+}
+
+// retire adds the counters of the rules of the chains replaced by a sync to the totals of their rules. The totals of
+// the owners no active chain belongs to, which were deleted, are dropped.
+func (c *chainCounters) retire(rules []utils.IPTablesSaveRule, owners map[string]chainOwner,
+	activeChains map[string]bool) {
+	stale := c.ruleCounts(rules, owners, func(chain string) bool { return !activeChains[chain] })
+	alive := make(map[chainOwner]bool)
+	for chain := range activeChains {
+		if owner, ok := owners[chain]; ok {
+			alive[owner] = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, counts := range stale {
+		for key, count := range counts {
+			total := c.totals[key]
+			total.packets += count.packets
+			total.bytes += count.bytes
+			c.totals[key] = total
+		}
+	}
+	for chain := range c.active {
+		if !activeChains[chain] {
+			delete(c.active, chain)
+		}
+	}
+	for key := range c.totals {
+		if !alive[chainOwner{namespace: key.namespace, name: key.name}] {
+			delete(c.totals, key)
+		}
+	}
+}
+
+// counts returns the counters of the rules, the totals of the replaced chains and the counters of the active ones
+func (c *chainCounters) counts() map[counterKey]ruleCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[counterKey]ruleCount, len(c.totals))
+	for key, count := range c.totals {
+		counts[key] = count
+	}
+	for _, chainCounts := range c.active {
+		for key, count := range chainCounts {
+			total := counts[key]
+			total.packets += count.packets
+			total.bytes += count.bytes
+			counts[key] = total
+		}
+	}
+	return counts
+}
+
+func (c *chainCounters) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.packets
+	if c.bytes != nil {
+		ch <- c.bytes
+	}
+}
+
+func (c *chainCounters) Collect(ch chan<- prometheus.Metric) {
+	for key, count := range c.counts() {
+		labels := []string{key.namespace, key.name}
+		if key.rule != "" {
+			labels = append(labels, key.rule)
+		}
+		ch <- prometheus.MustNewConstMetric(c.packets, prometheus.CounterValue, float64(count.packets), labels...)
+		if c.bytes != nil {
+			ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.CounterValue, float64(count.bytes), labels...)
+		}
+	}
+}
+
+// runPolicyCounters reads the counters of the rules of the policy and pod firewall chains every policyCountersPeriod
+// until stopCh is closed
+func (npc *NetworkPolicyController) runPolicyCounters(stopCh <-chan struct{}) {
+	t := time.NewTicker(npc.policyCountersPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+		if !npc.readyForUpdates {
+			continue
+		}
+		if err := npc.collectPolicyCounters(); err != nil {
+			glog.Errorf("Failed to read the counters of the network policy and pod firewall chains: %s", err)
+		}
+	}
+}
+
+// collectPolicyCounters reads the counters of the rules of the active policy and pod firewall chains. The controller
+// is locked, so no sync replaces the chains in between.
+func (npc *NetworkPolicyController) collectPolicyCounters() error {
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	rules, err := nodestate.ReadIPTablesSaveWithCounters("filter")
+	if err != nil {
+		return err
+	}
+	npc.policyCounters.update(rules, npc.policyChainOwners)
+	npc.podRejectCounters.update(rules, npc.podFwChainOwners)
+	return nil
+}
+
+// retireChainCounters adds the counters of the chains replaced by the sync to the totals of their rules
+func (npc *NetworkPolicyController) retireChainCounters(rules []utils.IPTablesSaveRule, activePolicyChains,
+	activePodFwChains map[string]bool) {
+	if npc.policyCounters == nil {
+		return
+	}
+	npc.policyCounters.retire(rules, npc.policyChainOwners, activePolicyChains)
+	npc.podRejectCounters.retire(rules, npc.podFwChainOwners, activePodFwChains)
+}
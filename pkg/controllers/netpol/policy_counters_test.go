@@ -0,0 +1,88 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func TestPolicyCounters(t *testing.T) {
+	comments := newPolicyRuleComments(networkPolicyInfo{namespace: "default", name: "web"})
+	owners := map[string]chainOwner{
+		"KUBE-NWPLCY-OLD": {namespace: "default", name: "web"},
+		"KUBE-NWPLCY-NEW": {namespace: "default", name: "web"},
+	}
+	rules := func(chain string, packets uint64) []utils.IPTablesSaveRule {
+		return []utils.IPTablesSaveRule{
+			{Chain: chain, Comment: comments.sourcePods, Target: "ACCEPT", Packets: packets, Bytes: 100 * packets},
+			{Chain: chain, Comment: comments.allSources, Target: "ACCEPT", Packets: 2 * packets, Bytes: 200 * packets},
+			{Chain: chain, Comment: comments.allDestinations, Target: "ACCEPT", Packets: 3 * packets},
+			{Chain: chain, Comment: "sample", Target: "NFLOG", Packets: 50},
+		}
+	}
+	ingress0 := counterKey{namespace: "default", name: "web", rule: "ingress-0"}
+	ingress1 := counterKey{namespace: "default", name: "web", rule: "ingress-1"}
+	egress0 := counterKey{namespace: "default", name: "web", rule: "egress-0"}
+
+	c := newPolicyCounters()
+	c.update(rules("KUBE-NWPLCY-OLD", 1), map[string]chainOwner{"KUBE-NWPLCY-OLD": owners["KUBE-NWPLCY-OLD"]})
+	counts := c.counts()
+	if len(counts) != 3 || counts[ingress0] != (ruleCount{packets: 1, bytes: 100}) ||
+		counts[ingress1].packets != 2 || counts[egress0].packets != 3 {
+		t.Fatalf("expected the counters of the rules of the active chain, got %v", counts)
+	}
+
+	// a sync replaces the chain, its counters went on until it was deleted
+	c.retire(append(rules("KUBE-NWPLCY-OLD", 4), rules("KUBE-NWPLCY-NEW", 0)...), owners,
+		map[string]bool{"KUBE-NWPLCY-NEW": true})
+	if counts := c.counts(); counts[ingress0].packets != 4 || counts[egress0].packets != 12 {
+		t.Errorf("expected the final counters of the replaced chain, got %v", counts)
+	}
+	c.update(rules("KUBE-NWPLCY-NEW", 1), map[string]chainOwner{"KUBE-NWPLCY-NEW": owners["KUBE-NWPLCY-NEW"]})
+	if counts := c.counts(); counts[ingress0] != (ruleCount{packets: 5, bytes: 500}) {
+		t.Errorf("expected the counters of the replaced and active chains to add up, got %v", counts)
+	}
+
+	// the policy is deleted
+	c.retire(rules("KUBE-NWPLCY-NEW", 1), owners, map[string]bool{})
+	if counts := c.counts(); len(counts) != 0 {
+		t.Errorf("expected the counters of the deleted policy to be dropped, got %v", counts)
+	}
+}
+
+func TestPodRejectCounters(t *testing.T) {
+	owners := map[string]chainOwner{
+		"KUBE-POD-FW-OLD": {namespace: "nsA", name: "web"},
+		"KUBE-POD-FW-NEW": {namespace: "nsA", name: "web"},
+	}
+	rules := func(chain, target string, packets uint64) []utils.IPTablesSaveRule {
+		return []utils.IPTablesSaveRule{
+			{Chain: chain, Comment: "rule for stateful firewall for pod", Target: "ACCEPT", Packets: 100},
+			{Chain: chain, Comment: "rule to log dropped traffic POD name:web namespace: nsA", Target: "NFLOG",
+				Packets: packets},
+			{Chain: chain, Comment: "default rule to " + target + " traffic destined for POD name:web namespace: nsA",
+				Target: target, Packets: packets, Bytes: 60 * packets},
+		}
+	}
+	web := counterKey{namespace: "nsA", name: "web"}
+
+	c := newPodRejectCounters()
+	c.update(rules("KUBE-POD-FW-OLD", "REJECT", 2), map[string]chainOwner{"KUBE-POD-FW-OLD": owners["KUBE-POD-FW-OLD"]})
+	if counts := c.counts(); len(counts) != 1 || counts[web].packets != 2 {
+		t.Fatalf("expected the counters of the default rule of the pod firewall chain, got %v", counts)
+	}
+
+	// the namespace switches to the drop verdict, the sync replaces the chain
+	c.retire(append(rules("KUBE-POD-FW-OLD", "REJECT", 3), rules("KUBE-POD-FW-NEW", "DROP", 0)...), owners,
+		map[string]bool{"KUBE-POD-FW-NEW": true})
+	c.update(rules("KUBE-POD-FW-NEW", "DROP", 4), map[string]chainOwner{"KUBE-POD-FW-NEW": owners["KUBE-POD-FW-NEW"]})
+	if counts := c.counts(); counts[web].packets != 7 {
+		t.Errorf("expected the counters of the replaced and active chains to add up, got %v", counts)
+	}
+
+	// the pod is deleted
+	c.retire(rules("KUBE-POD-FW-NEW", "DROP", 4), owners, map[string]bool{})
+	if counts := c.counts(); len(counts) != 0 {
+		t.Errorf("expected the counters of the deleted pod to be dropped, got %v", counts)
+	}
+}
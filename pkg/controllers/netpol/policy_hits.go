@@ -0,0 +1,159 @@
+package netpol
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// UnusedPolicy is a network policy selecting pods of the node whose allow rules matched no packet over a window
+type UnusedPolicy struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Since is when the policy started selecting pods of the node, or kube-router started
+	Since time.Time `json:"since"`
+	// LastHit is the last time the allow rules of the policy matched a packet, nil if they never did
+	LastHit *time.Time `json:"lastHit,omitempty"`
+}
+
+// policyHitRecord tracks when the allow rules of a network policy last matched a packet
+type policyHitRecord struct {
+	namespace string
+	name      string
+	since     time.Time
+	lastHit   time.Time
+}
+
+// policyHits tracks the hits of the allow rules of the network policies selecting pods of the node. The policy
+// chains are replaced on every sync, so their counters are read once the chains are stale, and when a report is
+// asked for.
+type policyHits struct {
+	// by namespace/name of the policies
+	policies map[string]*policyHitRecord
+}
+
+func newPolicyHits() *policyHits {
+	return &policyHits{policies: make(map[string]*policyHitRecord)}
+}
+
+// track starts tracking the policies selecting pods of the node and stops tracking the others
+func (h *policyHits) track(policies []networkPolicyInfo, localPodIPs map[string]bool, now time.Time) {
+	tracked := make(map[string]bool)
+	for _, policy := range policies {
+		local := false
+		for ip := range policy.targetPods {
+			if localPodIPs[ip] {
+				local = true
+				break
+			}
+		}
+		if !local {
+			continue
+		}
+		key := policy.namespace + "/" + policy.name
+		tracked[key] = true
+		if _, ok := h.policies[key]; !ok {
+			h.policies[key] = &policyHitRecord{namespace: policy.namespace, name: policy.name, since: now}
+		}
+	}
+	for key := range h.policies {
+		if !tracked[key] {
+			delete(h.policies, key)
+		}
+	}
+}
+
+// record records the hits of the ACCEPT rules of the given policy chains
+func (h *policyHits) record(rules []utils.IPTablesSaveRule, owners map[string]chainOwner, chains map[string]bool,
+	now time.Time) {
+	for _, rule := range rules {
+		owner, ok := owners[rule.Chain]
+		if !ok || !chains[rule.Chain] || rule.Target != "ACCEPT" || rule.Packets == 0 {
+			continue
+		}
+		if record, ok := h.policies[owner.namespace+"/"+owner.name]; ok {
+			record.lastHit = now
+		}
+	}
+}
+
+// unused returns the policies tracked for the whole window whose allow rules matched no packet in it
+func (h *policyHits) unused(window time.Duration, now time.Time) []UnusedPolicy {
+	start := now.Add(-window)
+	unused := make([]UnusedPolicy, 0)
+	for _, record := range h.policies {
+		if record.since.After(start) || record.lastHit.After(start) {
+			continue
+		}
+		policy := UnusedPolicy{Namespace: record.namespace, Name: record.name, Since: record.since}
+		if !record.lastHit.IsZero() {
+			lastHit := record.lastHit
+			policy.LastHit = &lastHit
+		}
+		unused = append(unused, policy)
+	}
+	sort.Slice(unused, func(i, j int) bool {
+		if unused[i].Namespace != unused[j].Namespace {
+			return unused[i].Namespace < unused[j].Namespace
+		}
+		return unused[i].Name < unused[j].Name
+	})
+	return unused
+}
+
+// trackPolicyHits starts tracking the policies of the sync, and records the hits of the stale policy chains
+func (npc *NetworkPolicyController) trackPolicyHits(rules []utils.IPTablesSaveRule,
+	activePolicyChains map[string]bool) error {
+	ingressPods, err := npc.getIngressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		return err
+	}
+	egressPods, err := npc.getEgressNetworkPolicyEnabledPods(npc.nodeIP.String())
+	if err != nil {
+		return err
+	}
+	localPodIPs := make(map[string]bool)
+	for _, pods := range []map[string]podInfo{*ingressPods, *egressPods} {
+		for ip := range pods {
+			localPodIPs[ip] = true
+		}
+	}
+
+	now := time.Now()
+	npc.policyHits.track(*npc.networkPoliciesInfo, localPodIPs, now)
+	staleChains := make(map[string]bool)
+	for chain := range npc.policyChainOwners {
+		if !activePolicyChains[chain] {
+			staleChains[chain] = true
+		}
+	}
+	npc.policyHits.record(rules, npc.policyChainOwners, staleChains, now)
+	return nil
+}
+
+// UnusedPolicies returns the network policies selecting pods of the node whose allow rules matched no packet over
+// the window. The policies are only tracked with --policy-hit-tracking, since they started selecting pods of the
+// node or kube-router started.
+func (npc *NetworkPolicyController) UnusedPolicies(window time.Duration) ([]UnusedPolicy, error) {
+	if npc.policyHits == nil {
+		return nil, errors.New("the hits of the network policies are only tracked with --policy-hit-tracking")
+	}
+	rules, err := nodestate.ReadIPTablesSaveWithCounters("filter")
+	if err != nil {
+		return nil, err
+	}
+
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	now := time.Now()
+	// the policy chains of the last sync hold the hits since then
+	activeChains := make(map[string]bool, len(npc.policyChainOwners))
+	for chain := range npc.policyChainOwners {
+		activeChains[chain] = true
+	}
+	npc.policyHits.record(rules, npc.policyChainOwners, activeChains, now)
+	return npc.policyHits.unused(window, now), nil
+}
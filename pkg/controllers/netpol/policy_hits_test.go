@@ -0,0 +1,50 @@
+package netpol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func TestPolicyHits(t *testing.T) {
+	policies := []networkPolicyInfo{
+		{name: "used", namespace: "nsA", targetPods: map[string]podInfo{"1.1.1.1": {}}},
+		{name: "unused", namespace: "nsA", targetPods: map[string]podInfo{"1.1.1.1": {}}},
+		{name: "remote", namespace: "nsA", targetPods: map[string]podInfo{"2.2.2.2": {}}},
+	}
+	owners := map[string]chainOwner{
+		"KUBE-NWPLCY-USED":   {namespace: "nsA", name: "used"},
+		"KUBE-NWPLCY-UNUSED": {namespace: "nsA", name: "unused"},
+	}
+	rules := []utils.IPTablesSaveRule{
+		{Chain: "KUBE-NWPLCY-USED", Target: "ACCEPT", Packets: 10},
+		{Chain: "KUBE-NWPLCY-UNUSED", Target: "ACCEPT"},
+		// only the allow rules count
+		{Chain: "KUBE-NWPLCY-UNUSED", Target: "NFLOG", Packets: 10},
+	}
+	chains := map[string]bool{"KUBE-NWPLCY-USED": true, "KUBE-NWPLCY-UNUSED": true}
+
+	start := time.Now()
+	hits := newPolicyHits()
+	hits.track(policies, map[string]bool{"1.1.1.1": true}, start)
+	hits.record(rules, owners, chains, start.Add(90*time.Minute))
+
+	if unused := hits.unused(time.Hour, start.Add(30*time.Minute)); len(unused) != 0 {
+		t.Errorf("expected no policy tracked over the whole window, got %v", unused)
+	}
+	unused := hits.unused(time.Hour, start.Add(2*time.Hour))
+	if len(unused) != 1 || unused[0].Name != "unused" || unused[0].LastHit != nil {
+		t.Errorf("expected only nsA/unused to be unused without hits, got %v", unused)
+	}
+	unused = hits.unused(30*time.Minute, start.Add(2*time.Hour))
+	if len(unused) != 2 || unused[1].Name != "used" || unused[1].LastHit == nil {
+		t.Errorf("expected nsA/unused and nsA/used to be unused over the last 30 minutes, got %v", unused)
+	}
+
+	// a policy no longer selecting pods of the node is no longer tracked
+	hits.track(policies[:1], map[string]bool{"1.1.1.1": true}, start.Add(2*time.Hour))
+	if unused := hits.unused(time.Minute, start.Add(3*time.Hour)); len(unused) != 1 || unused[0].Name != "used" {
+		t.Errorf("expected only nsA/used to be tracked, got %v", unused)
+	}
+}
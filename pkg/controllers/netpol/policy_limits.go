@@ -0,0 +1,146 @@
+package netpol
+
+import (
+	"fmt"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+)
+
+const (
+	policyLimitRules        = "rules"
+	policyLimitIPSetEntries = "ipset_entries"
+
+	policyLimitExceededReason = "PolicyLimitExceeded"
+)
+
+// policyFootprint returns the number of iptables rules and ipset entries the sync programs for the network policy,
+// counted the way syncNetworkPolicyChains programs them, the peer pods once aggregated
+func (npc *NetworkPolicyController) policyFootprint(policy networkPolicyInfo) (int, int) {
+	rules, entries := 0, 0
+	if policy.policyType == "both" || policy.policyType == "ingress" {
+		entries += len(policy.targetPods)
+		for _, rule := range policy.ingressRules {
+			peers := npc.keepPeerPodIPSet(rule.srcPods, rule.namespaceSelectorPeers)
+			ipBlocks := len(rule.srcIPBlocks) != 0
+			if peers {
+				_, _, ips := npc.peerPodIPSet("", "", rule.srcPods)
+				entries += len(ips)
+				rules += portRules(rule.ports, rule.namedPorts)
+			}
+			if rule.matchAllSource {
+				if rule.matchAllPorts {
+					rules++
+				} else {
+					rules += len(rule.ports) + len(rule.namedPorts)
+				}
+			}
+			if ipBlocks {
+				entries += len(rule.srcIPBlocks)
+				if rule.matchAllPorts {
+					rules++
+				} else {
+					rules += len(rule.ports) + len(rule.namedPorts)
+				}
+			}
+			// the named port ipsets are shared by the peers, all sources and ipBlocks rules
+			if peers || (rule.matchAllSource && !rule.matchAllPorts) || (ipBlocks && !rule.matchAllPorts) {
+				entries += namedPortEntries(rule.namedPorts)
+			}
+		}
+	}
+	if policy.policyType == "both" || policy.policyType == "egress" {
+		entries += len(policy.targetPods)
+		for _, rule := range policy.egressRules {
+			if npc.keepPeerPodIPSet(rule.dstPods, rule.namespaceSelectorPeers) {
+				_, _, ips := npc.peerPodIPSet("", "", rule.dstPods)
+				entries += len(ips) + namedPortEntries(rule.namedPorts)
+				rules += portRules(rule.ports, rule.namedPorts)
+			}
+			if rule.matchAllDestinations {
+				if rule.matchAllPorts {
+					rules++
+				} else {
+					rules += len(rule.ports)
+				}
+			}
+			if len(rule.dstIPBlocks) != 0 {
+				entries += len(rule.dstIPBlocks)
+				if rule.matchAllPorts {
+					rules++
+				} else {
+					rules += len(rule.ports)
+				}
+			}
+		}
+	}
+	return rules, entries
+}
+
+// portRules is the number of rules of a peer ipset, one per port and named port or one for all ports
+func portRules(ports []protocolAndPort, namedPorts []endPoints) int {
+	if len(ports) == 0 && len(namedPorts) == 0 {
+		return 1
+	}
+	return len(ports) + len(namedPorts)
+}
+
+func namedPortEntries(namedPorts []endPoints) int {
+	entries := 0
+	for _, endPoints := range namedPorts {
+		entries += len(endPoints.ips)
+	}
+	return entries
+}
+
+// enforcePolicyLimits refuses to program the rules of the network policies exceeding the maximum number of rules or
+// ipset entries per policy. A refused policy keeps isolating the pods it selects but allows no traffic, so a runaway
+// policy can't open traffic either. The refusal is recorded as an event on the policy and in the metrics.
+func (npc *NetworkPolicyController) enforcePolicyLimits() {
+	if npc.MetricsEnabled {
+		metrics.ControllerPolicyLimitExceeded.Reset()
+	}
+	if npc.maxPolicyRules <= 0 && npc.maxPolicyIPSetEntries <= 0 {
+		return
+	}
+	policies := *npc.networkPoliciesInfo
+	for i, policy := range policies {
+		rules, entries := npc.policyFootprint(policy)
+		var limit, message string
+		switch {
+		case npc.maxPolicyRules > 0 && rules > npc.maxPolicyRules:
+			limit = policyLimitRules
+			message = fmt.Sprintf("network policy expands into %d iptables rules on node %s, more than the limit "+
+				"of %d, its rules are not programmed and the pods it selects are denied the traffic it allows",
+				rules, npc.nodeHostName, npc.maxPolicyRules)
+		case npc.maxPolicyIPSetEntries > 0 && entries > npc.maxPolicyIPSetEntries:
+			limit = policyLimitIPSetEntries
+			message = fmt.Sprintf("network policy expands into %d ipset entries on node %s, more than the limit "+
+				"of %d, its rules are not programmed and the pods it selects are denied the traffic it allows",
+				entries, npc.nodeHostName, npc.maxPolicyIPSetEntries)
+		default:
+			continue
+		}
+		glog.Errorf("Network policy %s/%s is not programmed: %s", policy.namespace, policy.name, message)
+		policies[i].ingressRules, policies[i].egressRules = nil, nil
+		if npc.MetricsEnabled {
+			namespace, name := npc.tenantLabels.Values(policy.namespace, policy.name)
+			metrics.ControllerPolicyLimitExceeded.WithLabelValues(namespace, name, limit).Inc()
+		}
+		ref := api.ObjectReference{
+			Kind:       "NetworkPolicy",
+			APIVersion: "networking.k8s.io/v1",
+			Namespace:  policy.namespace,
+			Name:       policy.name,
+		}
+		if obj, exists, err := npc.npLister.GetByKey(policy.namespace + "/" + policy.name); err == nil && exists {
+			if np, ok := obj.(*networking.NetworkPolicy); ok {
+				ref.UID = np.UID
+			}
+		}
+		npc.Events.RecordEvent(ref, api.EventTypeWarning, policyLimitExceededReason, message)
+	}
+}
@@ -0,0 +1,74 @@
+package netpol
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestEnforcePolicyLimits(t *testing.T) {
+	pods := func(n int) []podInfo {
+		infos := make([]podInfo, 0, n)
+		for i := 0; i < n; i++ {
+			infos = append(infos, podInfo{ip: "10.1.0." + strconv.Itoa(i+1)})
+		}
+		return infos
+	}
+	ports := []protocolAndPort{{protocol: "tcp", port: "80"}, {protocol: "tcp", port: "443"}, {protocol: "udp", port: "53"}}
+	runaway := networkPolicyInfo{
+		namespace:  "tenant-a",
+		name:       "runaway",
+		policyType: "both",
+		targetPods: map[string]podInfo{"10.1.1.1": {ip: "10.1.1.1"}},
+		ingressRules: []ingressRule{
+			{ports: ports, srcPods: pods(20)},
+			{matchAllPorts: true, srcIPBlocks: [][]string{{"192.0.2.0/24"}, {"198.51.100.0/24"}}},
+		},
+		egressRules: []egressRule{{matchAllDestinations: true, ports: ports}},
+	}
+	small := networkPolicyInfo{
+		namespace:    "tenant-b",
+		name:         "allow-web",
+		policyType:   "ingress",
+		targetPods:   map[string]podInfo{"10.1.2.1": {ip: "10.1.2.1"}},
+		ingressRules: []ingressRule{{matchAllSource: true, matchAllPorts: true}},
+	}
+
+	npc := &NetworkPolicyController{}
+	if rules, entries := npc.policyFootprint(runaway); rules != 7 || entries != 24 {
+		t.Errorf("expected 7 rules and 24 ipset entries, got %d and %d", rules, entries)
+	}
+	if rules, entries := npc.policyFootprint(small); rules != 1 || entries != 1 {
+		t.Errorf("expected 1 rule and 1 ipset entry, got %d and %d", rules, entries)
+	}
+
+	client := fake.NewSimpleClientset()
+	npc.npLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	npc.Events = utils.NewEventSink(client, "node", time.Minute)
+	npc.maxPolicyIPSetEntries = 20
+	npc.networkPoliciesInfo = &[]networkPolicyInfo{runaway, small}
+	npc.enforcePolicyLimits()
+
+	policies := *npc.networkPoliciesInfo
+	if policies[0].ingressRules != nil || policies[0].egressRules != nil || len(policies[0].targetPods) != 1 {
+		t.Errorf("expected the rules of the runaway policy to be dropped but its pods kept isolated, got %+v", policies[0])
+	}
+	if len(policies[1].ingressRules) != 1 {
+		t.Errorf("expected the policy within the limits to be kept, got %+v", policies[1])
+	}
+	events, err := client.CoreV1().Events("tenant-a").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 || events.Items[0].Reason != policyLimitExceededReason ||
+		events.Items[0].InvolvedObject.Name != "runaway" || events.Items[0].InvolvedObject.Kind != "NetworkPolicy" {
+		t.Errorf("expected an event on the runaway policy, got %+v", events.Items)
+	}
+}
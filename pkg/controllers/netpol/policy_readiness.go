@@ -0,0 +1,273 @@
+package netpol
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// namespaces annotated with this annotation set to "true" have the networking of their pods delayed by
+	// the CNI plugin until the network policies apply to them
+	waitForPolicyAnnotation = "kube-router.io/wait-for-policy"
+
+	policyReadinessPathPrefix = "/v1/pods/"
+	// pod addresses reported by the CNI plugin are forgotten when the pod does not show up in time
+	pendingPodIPTTL = time.Minute
+)
+
+// podPolicyStatus is the response of the policy readiness endpoint
+type podPolicyStatus struct {
+	// Required is set when the namespace of the pod waits for network policies to apply
+	Required bool `json:"required"`
+	// Ready is set once a sync of the network policies accounted for the pod
+	Ready bool `json:"ready"`
+}
+
+type pendingPodIP struct {
+	ip    string
+	added time.Time
+}
+
+// policyReadiness tracks the pods accounted for by the last sync of the network policies, and the addresses
+// of the pods reported by the CNI plugin before they show up in the pod status
+type policyReadiness struct {
+	mu            sync.Mutex
+	maxWait       time.Duration
+	pendingPodIPs map[string]pendingPodIP
+	syncedPods    map[string]bool
+	// closed and replaced on each sync to wake up the requests waiting for a pod
+	syncCh chan struct{}
+}
+
+func newPolicyReadiness(maxWait time.Duration) *policyReadiness {
+	return &policyReadiness{
+		maxWait:       maxWait,
+		pendingPodIPs: make(map[string]pendingPodIP),
+		syncedPods:    make(map[string]bool),
+		syncCh:        make(chan struct{}),
+	}
+}
+
+// setPendingIP records the address of a pod reported by the CNI plugin, returns true when it changed
+func (r *policyReadiness) setPendingIP(key, ip string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pending, ok := r.pendingPodIPs[key]; ok && pending.ip == ip {
+		return false
+	}
+	r.pendingPodIPs[key] = pendingPodIP{ip: ip, added: time.Now()}
+	return true
+}
+
+func (r *policyReadiness) pendingIP(key string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pendingPodIPs[key].ip
+}
+
+// localPods returns the pods of the node with an address, which the sync about to start accounts for. The
+// addresses reported by the CNI plugin are forgotten once the pod status carries one.
+func (r *policyReadiness) localPods(podLister cache.Indexer, nodeIP string) map[string]bool {
+	if r == nil {
+		return nil
+	}
+	pods := make(map[string]bool)
+	for _, obj := range podLister.List() {
+		pod := obj.(*api.Pod)
+		if pod.Status.HostIP == nodeIP && pod.Status.PodIP != "" {
+			pods[pod.Namespace+"/"+pod.Name] = true
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, pending := range r.pendingPodIPs {
+		obj, exists, err := podLister.GetByKey(key)
+		if err != nil {
+			continue
+		}
+		if exists && obj.(*api.Pod).Status.PodIP != "" || !exists && time.Since(pending.added) > pendingPodIPTTL {
+			delete(r.pendingPodIPs, key)
+		}
+	}
+	return pods
+}
+
+// synced records the pods accounted for by a successful sync and wakes up the waiting requests
+func (r *policyReadiness) synced(pods map[string]bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.syncedPods = pods
+	close(r.syncCh)
+	r.syncCh = make(chan struct{})
+}
+
+// waitSynced waits at most the given duration for a sync to account for the pod
+func (r *policyReadiness) waitSynced(key string, wait time.Duration) bool {
+	if wait > r.maxWait {
+		wait = r.maxWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		ready, syncCh := r.syncedPods[key], r.syncCh
+		r.mu.Unlock()
+		if ready {
+			return true
+		}
+		select {
+		case <-syncCh:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// pendingPodIPIndexer is the pod indexer of the network policy controller when the policy readiness endpoint
+// is enabled. Pods without address get the address reported by the CNI plugin, so policies apply to the pods
+// before the kubelet reports their address, which it only does after the CNI plugin returns.
+type pendingPodIPIndexer struct {
+	cache.Indexer
+	readiness *policyReadiness
+	nodeIP    string
+}
+
+func (i *pendingPodIPIndexer) List() []interface{} {
+	return i.withPendingIPs(i.Indexer.List())
+}
+
+func (i *pendingPodIPIndexer) Index(indexName string, obj interface{}) ([]interface{}, error) {
+	objs, err := i.Indexer.Index(indexName, obj)
+	if err != nil {
+		return nil, err
+	}
+	return i.withPendingIPs(objs), nil
+}
+
+func (i *pendingPodIPIndexer) withPendingIPs(objs []interface{}) []interface{} {
+	for n, obj := range objs {
+		pod, ok := obj.(*api.Pod)
+		if !ok || pod.Status.PodIP != "" {
+			continue
+		}
+		ip := i.readiness.pendingIP(pod.Namespace + "/" + pod.Name)
+		if ip == "" {
+			continue
+		}
+		// objects of the informer cache must not be modified
+		pod = pod.DeepCopy()
+		pod.Status.PodIP = ip
+		if pod.Status.HostIP == "" {
+			pod.Status.HostIP = i.nodeIP
+		}
+		objs[n] = pod
+	}
+	return objs
+}
+
+// policyReadinessRequired tells whether the namespace waits for network policies to apply to its pods
+func (npc *NetworkPolicyController) policyReadinessRequired(namespace string) (bool, error) {
+	obj, exists, err := npc.nsLister.GetByKey(namespace)
+	if err != nil || !exists {
+		return false, err
+	}
+	required, _ := strconv.ParseBool(obj.(*api.Namespace).Annotations[waitForPolicyAnnotation])
+	return required, nil
+}
+
+// servePolicyReadinessRequest answers GET /v1/pods/<namespace>/<name>?ip=<pod ip>&wait=<duration> with the
+// policy status of the pod, waiting at most the given duration (bounded by the maximum wait) for the pod to
+// become ready. The status code is 200 when the pod is ready and 503 otherwise.
+func (npc *NetworkPolicyController) servePolicyReadinessRequest(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, policyReadinessPathPrefix), "/")
+	if req.Method != http.MethodGet || !strings.HasPrefix(req.URL.Path, policyReadinessPathPrefix) ||
+		len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, req)
+		return
+	}
+	key := parts[0] + "/" + parts[1]
+
+	var wait time.Duration
+	if value := req.URL.Query().Get("wait"); value != "" {
+		var err error
+		if wait, err = time.ParseDuration(value); err != nil {
+			http.Error(w, "invalid wait duration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	ip := req.URL.Query().Get("ip")
+	if ip != "" && net.ParseIP(ip) == nil {
+		http.Error(w, "invalid pod address: "+ip, http.StatusBadRequest)
+		return
+	}
+
+	required, err := npc.policyReadinessRequired(parts[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := podPolicyStatus{Required: required, Ready: true}
+	if required {
+		if ip != "" && npc.policyReadiness.setPendingIP(key, ip) && npc.readyForUpdates {
+			glog.V(2).Infof("Syncing network policies for pod %s with address %s reported by the CNI plugin", key, ip)
+			npc.syncQueue.add(syncFull)
+		}
+		status.Ready = npc.policyReadiness.waitSynced(key, wait)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		glog.Errorf("Failed to write policy readiness of pod %s: %s", key, err)
+	}
+}
+
+// servePolicyReadiness serves the policy readiness endpoint on the unix socket until stopCh is closed
+func (npc *NetworkPolicyController) servePolicyReadiness(socketPath string, stopCh <-chan struct{}) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		glog.Errorf("Failed to create the directory of the policy readiness socket: %s", err)
+		return
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Failed to remove stale policy readiness socket: %s", err)
+		return
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		glog.Errorf("Failed to listen on policy readiness socket: %s", err)
+		return
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(npc.servePolicyReadinessRequest)}
+	go func() {
+		<-stopCh
+		if err := server.Close(); err != nil {
+			glog.Errorf("Failed to stop policy readiness server: %s", err)
+		}
+	}()
+	glog.Infof("Serving pod policy readiness on %s", socketPath)
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		glog.Errorf("Policy readiness server failed: %s", err)
+	}
+}
@@ -0,0 +1,77 @@
+package netpol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestPolicyReadiness(t *testing.T) {
+	krNetPol, cluster := newTestController(t)
+	krNetPol.policyReadiness = newPolicyReadiness(time.Second)
+	krNetPol.podLister = &pendingPodIPIndexer{Indexer: krNetPol.podLister, readiness: krNetPol.policyReadiness,
+		nodeIP: "10.10.10.10"}
+
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "relaxed"}})
+	tAddToInformerStore(t, cluster.nsInformer, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "strict",
+		Annotations: map[string]string{waitForPolicyAnnotation: "true"}}})
+	tAddToInformerStore(t, cluster.podInformer, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "strict"}})
+
+	query := func(path string) (int, podPolicyStatus) {
+		recorder := httptest.NewRecorder()
+		krNetPol.servePolicyReadinessRequest(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		status := podPolicyStatus{}
+		if recorder.Code == http.StatusOK || recorder.Code == http.StatusServiceUnavailable {
+			if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+				t.Fatalf("unexpected response body: %v", err)
+			}
+		}
+		return recorder.Code, status
+	}
+
+	if code, status := query("/v1/pods/relaxed/api"); code != http.StatusOK || status.Required || !status.Ready {
+		t.Errorf("expected pods of namespaces not waiting for policies to be ready, got %d %+v", code, status)
+	}
+	if code, _ := query("/v1/pods/strict"); code != http.StatusNotFound {
+		t.Errorf("expected not found for an incomplete path, got %d", code)
+	}
+	if code, _ := query("/v1/pods/strict/web?ip=not-an-ip"); code != http.StatusBadRequest {
+		t.Errorf("expected bad request for an invalid address, got %d", code)
+	}
+
+	code, status := query("/v1/pods/strict/web?ip=1.1.1.1")
+	if code != http.StatusServiceUnavailable || !status.Required || status.Ready {
+		t.Errorf("expected the pod not to be ready before a sync, got %d %+v", code, status)
+	}
+
+	// the next sync sees the pod with the address reported by the CNI plugin
+	pods, err := krNetPol.ListPodsByNamespaceAndLabels("strict", labels.Everything())
+	if err != nil || len(pods) != 1 || pods[0].Status.PodIP != "1.1.1.1" || pods[0].Status.HostIP != "10.10.10.10" {
+		t.Fatalf("expected the pod with the reported address, got %v (%v)", pods, err)
+	}
+	localPods := krNetPol.policyReadiness.localPods(krNetPol.podLister, "10.10.10.10")
+
+	done := make(chan podPolicyStatus)
+	go func() {
+		_, status := query("/v1/pods/strict/web?wait=1s")
+		done <- status
+	}()
+	krNetPol.policyReadiness.synced(localPods)
+	if status := <-done; !status.Ready {
+		t.Errorf("expected the waiting request to report the pod ready once synced, got %+v", status)
+	}
+
+	// the address is forgotten once the pod status carries one
+	tAddToInformerStore(t, cluster.podInformer, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "strict"},
+		Status: v1.PodStatus{HostIP: "10.10.10.10", PodIP: "1.1.1.2"}})
+	krNetPol.policyReadiness.localPods(krNetPol.podLister, "10.10.10.10")
+	if ip := krNetPol.policyReadiness.pendingIP("strict/web"); ip != "" {
+		t.Errorf("expected the reported address to be forgotten, got %s", ip)
+	}
+}
@@ -0,0 +1,142 @@
+package netpol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+)
+
+const (
+	// NFLOG group the packets sampled from a network policy chain are logged to
+	policySampleNFLogGroup = 101
+	// MaxPolicySamplePackets is the maximum number of packets sampled at once
+	MaxPolicySamplePackets = 10000
+)
+
+// ErrPolicySampleInProgress is returned when a network policy is already being sampled, on this node a single
+// sampling runs at a time
+var ErrPolicySampleInProgress = errors.New("a network policy is already being sampled")
+
+// policySample is the network policy whose chain logs the packets going through it to the sample NFLOG group
+type policySample struct {
+	namespace string
+	name      string
+	// whether the last sync found the policy and attached the sampler to its chain
+	attached bool
+}
+
+// sampleRuleArgs returns the rule of the policy chain logging the packets to the sample NFLOG group, or nil if the
+// policy is not sampled. The rule does not terminate, the packets go on through the rules of the chain.
+func (npc *NetworkPolicyController) sampleRuleArgs(policy networkPolicyInfo) []string {
+	if npc.sample == nil || npc.sample.namespace != policy.namespace || npc.sample.name != policy.name {
+		return nil
+	}
+	npc.sample.attached = true
+	return []string{"-m", "comment", "--comment", "sample packets of policy " + policy.namespace + "/" + policy.name,
+		"-j", "NFLOG", "--nflog-group", strconv.Itoa(policySampleNFLogGroup)}
+}
+
+// nftSampleRule is the counterpart of sampleRuleArgs for the nftables backend
+func (npc *NetworkPolicyController) nftSampleRule(policy networkPolicyInfo) string {
+	if npc.sampleRuleArgs(policy) == nil {
+		return ""
+	}
+	return "log group " + strconv.Itoa(policySampleNFLogGroup) + " " +
+		nftComment("sample packets of policy "+policy.namespace+"/"+policy.name)
+}
+
+// SamplePolicy attaches a sampler to the chain of the network policy and writes the packets going through it, as
+// pcap, until the given number of packets were written or ctx is done. The sampler is detached before it returns.
+// Only the packets of the pods of the node go through the chain. It returns the number of packets written.
+func (npc *NetworkPolicyController) SamplePolicy(ctx context.Context, namespace, name string, packets int,
+	w io.Writer) (int, error) {
+	if packets <= 0 || packets > MaxPolicySamplePackets {
+		return 0, fmt.Errorf("the number of packets must be between 1 and %d", MaxPolicySamplePackets)
+	}
+	if npc.observeOnly {
+		return 0, errObserveOnly
+	}
+	if npc.acceptedFlowLogGroup == policySampleNFLogGroup {
+		return 0, fmt.Errorf("NFLOG group %d for sampling is already used for logging accepted flows",
+			policySampleNFLogGroup)
+	}
+	if npc.dropLogGroup == policySampleNFLogGroup {
+		return 0, fmt.Errorf("NFLOG group %d for sampling is already used for logging dropped traffic",
+			policySampleNFLogGroup)
+	}
+
+	npc.mu.Lock()
+	if npc.sample != nil {
+		npc.mu.Unlock()
+		return 0, ErrPolicySampleInProgress
+	}
+	sample := &policySample{namespace: namespace, name: name}
+	npc.sample = sample
+	npc.mu.Unlock()
+	defer npc.detachSample()
+
+	// the group is read before the sampler is attached so no packet is missed
+	reader, err := utils.NewNFLogReader(policySampleNFLogGroup)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	if err := npc.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to attach the sampler: %s", err)
+	}
+	npc.mu.Lock()
+	attached := sample.attached
+	npc.mu.Unlock()
+	if !attached {
+		return 0, fmt.Errorf("network policy %s/%s not found", namespace, name)
+	}
+	glog.Infof("Sampling %d packets of network policy %s/%s", packets, namespace, name)
+
+	pcap, err := utils.NewPCAPWriter(w, utils.NFLogCopyRange)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for written < packets {
+		select {
+		case <-ctx.Done():
+			return written, nil
+		default:
+		}
+		logged, err := reader.Read()
+		if err != nil {
+			glog.Errorf("Failed to read the sampled packets: %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, packet := range logged {
+			if written == packets {
+				break
+			}
+			if err := pcap.WritePacket(time.Now(), packet.Payload); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if f, ok := w.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+	}
+	return written, nil
+}
+
+// detachSample removes the sampler from the policy chain
+func (npc *NetworkPolicyController) detachSample() {
+	npc.mu.Lock()
+	npc.sample = nil
+	npc.mu.Unlock()
+	if err := npc.Sync(); err != nil {
+		glog.Errorf("Failed to detach the sampler, it is detached by the next sync: %s", err)
+	}
+}
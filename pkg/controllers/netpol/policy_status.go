@@ -0,0 +1,281 @@
+package netpol
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// nodes failing the sync of a policy listed in the ClusterPolicyStatus, the others are only counted
+	maxListedFailingNodes = 10
+	// the NodePolicyStatus is rewritten after this many periods even when unchanged, so it is restored when it was
+	// garbage collected along with a previous node of the same name
+	policyStatusRefreshPeriods = 10
+)
+
+// policyStatusReporter publishes the outcome of the syncs of the network policies in the NodePolicyStatus of the
+// node. The status is written at most once per period, and only when the policies or the error changed or the
+// status was not written for policyStatusRefreshPeriods periods.
+type policyStatusReporter struct {
+	clientset kubernetes.Interface
+	nodeName  string
+	nodeUID   types.UID
+	period    time.Duration
+
+	mu      sync.Mutex
+	status  crd.NodePolicyStatusStatus
+	changed bool
+}
+
+func newPolicyStatusReporter(clientset kubernetes.Interface, node *api.Node, period time.Duration) *policyStatusReporter {
+	return &policyStatusReporter{clientset: clientset, nodeName: node.Name, nodeUID: node.UID, period: period}
+}
+
+// recordSync records the outcome of a sync of the network policies
+func (r *policyStatusReporter) recordSync(policies *[]networkPolicyInfo, syncErr error) {
+	if r == nil {
+		return
+	}
+	status := crd.NodePolicyStatusStatus{SyncTime: metav1.Now()}
+	if policies != nil {
+		for _, policy := range *policies {
+			status.Policies = append(status.Policies, policy.namespace+"/"+policy.name)
+		}
+		sort.Strings(status.Policies)
+	}
+	if syncErr != nil {
+		status.Error = syncErr.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if status.Error == r.status.Error && reflect.DeepEqual(status.Policies, r.status.Policies) {
+		return
+	}
+	r.status = status
+	r.changed = true
+}
+
+// run writes the recorded status once per period until stopCh is closed
+func (r *policyStatusReporter) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+	unchanged := 0
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		changed, status := r.changed, r.status
+		r.changed = false
+		r.mu.Unlock()
+		if !changed && status.SyncTime.IsZero() {
+			continue
+		}
+		if unchanged++; !changed && unchanged < policyStatusRefreshPeriods {
+			continue
+		}
+		unchanged = 0
+		nps := crd.NewNodePolicyStatus(r.nodeName, r.nodeUID, status)
+		if err := crd.Apply(r.clientset, crd.NodePolicyStatusResource, nps); err != nil {
+			glog.Errorf("Failed to report the network policy status of the node: %s", err)
+			// retried on the next tick, with the status recorded meanwhile if any
+			r.mu.Lock()
+			r.changed = true
+			r.mu.Unlock()
+		}
+	}
+}
+
+// PolicyStatusAggregator merges the NodePolicyStatuses reported by the nodes into the ClusterPolicyStatus, and deletes
+// the NodePolicyStatuses of the nodes no longer in the cluster the garbage collection of their owner missed, e.g.
+// created before they were owned by their node. It is a cluster-scope task, run by the leader only.
+type PolicyStatusAggregator struct {
+	clientset  kubernetes.Interface
+	npLister   cache.Indexer
+	nodeLister cache.Indexer
+	period     time.Duration
+}
+
+// NewPolicyStatusAggregator returns an aggregator updating the ClusterPolicyStatus once per period
+func NewPolicyStatusAggregator(clientset kubernetes.Interface, npLister, nodeLister cache.Indexer,
+	period time.Duration) *PolicyStatusAggregator {
+	return &PolicyStatusAggregator{clientset: clientset, npLister: npLister, nodeLister: nodeLister, period: period}
+}
+
+// Run aggregates the status once per period until stopCh is closed
+func (a *PolicyStatusAggregator) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(a.period)
+	defer ticker.Stop()
+	var last *crd.ClusterPolicyStatusStatus
+	for {
+		statuses, err := crd.ListNodePolicyStatuses(a.clientset)
+		if err != nil {
+			glog.Errorf("Failed to read the network policy statuses of the nodes: %s", err)
+		} else {
+			nodes := a.nodes()
+			a.sweep(orphanedPolicyStatuses(statuses, nodes))
+			status := aggregatePolicyStatus(a.policies(), nodes, statuses)
+			// the update time alone is not worth a write
+			if last == nil || !reflect.DeepEqual(last.Policies, status.Policies) || last.Nodes != status.Nodes ||
+				last.ReportingNodes != status.ReportingNodes {
+				status.UpdateTime = metav1.Now()
+				if err := crd.Apply(a.clientset, crd.ClusterPolicyStatusResource, crd.NewClusterPolicyStatus(status)); err != nil {
+					glog.Errorf("Failed to update the cluster network policy status: %s", err)
+				} else {
+					last = &status
+				}
+			}
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep deletes the NodePolicyStatuses of the given nodes once the API server confirms the nodes are gone, as the
+// node informer may not know of a node that just joined yet
+func (a *PolicyStatusAggregator) sweep(nodes []string) {
+	for _, node := range nodes {
+		_, err := a.clientset.CoreV1().Nodes().Get(context.Background(), node, metav1.GetOptions{})
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			glog.Errorf("Failed to check node %s of the network policy status still exists: %s", node, err)
+			continue
+		}
+		if err := crd.Delete(a.clientset, crd.NodePolicyStatusResource, node); err != nil && !apierrors.IsNotFound(err) {
+			glog.Errorf("Failed to delete the network policy status of deleted node %s: %s", node, err)
+			continue
+		}
+		glog.Infof("Deleted the network policy status of deleted node %s", node)
+	}
+}
+
+func (a *PolicyStatusAggregator) policies() []*networking.NetworkPolicy {
+	objs := a.npLister.List()
+	policies := make([]*networking.NetworkPolicy, 0, len(objs))
+	for _, obj := range objs {
+		if policy, ok := obj.(*networking.NetworkPolicy); ok {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+func (a *PolicyStatusAggregator) nodes() []string {
+	objs := a.nodeLister.List()
+	nodes := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		if node, ok := obj.(*api.Node); ok {
+			nodes = append(nodes, node.Name)
+		}
+	}
+	return nodes
+}
+
+// aggregatePolicyStatus counts for each network policy the nodes whose last sync enforced it, and lists the nodes
+// whose last sync failed. Statuses of nodes no longer in the cluster are ignored.
+func aggregatePolicyStatus(policies []*networking.NetworkPolicy, nodes []string,
+	statuses []crd.NodePolicyStatus) crd.ClusterPolicyStatusStatus {
+	isNode := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		isNode[node] = true
+	}
+	reporting := 0
+	enforced := make(map[string]int)
+	// a failed sync leaves all the policies of the node not enforced as desired
+	failing := make([]string, 0)
+	failures := make([]crd.NodeFailure, 0)
+	for _, status := range statuses {
+		if !isNode[status.Name] {
+			continue
+		}
+		reporting++
+		if status.Status.Error != "" {
+			failing = append(failing, status.Name)
+			failures = append(failures, crd.NodeFailure{Node: status.Name, Error: status.Status.Error})
+			continue
+		}
+		for _, policy := range status.Status.Policies {
+			enforced[policy]++
+		}
+	}
+	sort.Strings(failing)
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Node < failures[j].Node })
+	if len(failures) > maxListedFailingNodes {
+		failures = failures[:maxListedFailingNodes]
+	}
+
+	result := crd.ClusterPolicyStatusStatus{Nodes: len(nodes), ReportingNodes: reporting}
+	for _, policy := range policies {
+		key := policy.Namespace + "/" + policy.Name
+		enforcement := crd.PolicyEnforcement{
+			Namespace:     policy.Namespace,
+			Name:          policy.Name,
+			EnforcedNodes: enforced[key],
+			Summary:       enforcementSummary(enforced[key], len(nodes), failing),
+		}
+		if len(failures) > 0 {
+			enforcement.FailingNodes = failures
+		}
+		result.Policies = append(result.Policies, enforcement)
+	}
+	sort.Slice(result.Policies, func(i, j int) bool {
+		if result.Policies[i].Namespace != result.Policies[j].Namespace {
+			return result.Policies[i].Namespace < result.Policies[j].Namespace
+		}
+		return result.Policies[i].Name < result.Policies[j].Name
+	})
+	return result
+}
+
+// orphanedPolicyStatuses returns the nodes of the NodePolicyStatuses not in the cluster
+func orphanedPolicyStatuses(statuses []crd.NodePolicyStatus, nodes []string) []string {
+	isNode := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		isNode[node] = true
+	}
+	orphaned := make([]string, 0)
+	for _, status := range statuses {
+		if !isNode[status.Name] {
+			orphaned = append(orphaned, status.Name)
+		}
+	}
+	sort.Strings(orphaned)
+	return orphaned
+}
+
+// enforcementSummary sums up the enforcement of a policy, e.g. "enforced on 98/100 nodes, failing on node-7"
+func enforcementSummary(enforced, nodes int, failing []string) string {
+	summary := fmt.Sprintf("enforced on %d/%d nodes", enforced, nodes)
+	switch {
+	case len(failing) == 0:
+		return summary
+	case len(failing) <= 3:
+		return summary + ", failing on " + strings.Join(failing, ", ")
+	default:
+		return fmt.Sprintf("%s, failing on %s and %d more", summary, strings.Join(failing[:3], ", "), len(failing)-3)
+	}
+}
@@ -0,0 +1,80 @@
+package netpol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAggregatePolicyStatus(t *testing.T) {
+	policies := []*netv1.NetworkPolicy{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "frontend"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "db", Name: "postgres"}},
+	}
+	nodes := []string{"node-1", "node-2", "node-3", "node-4"}
+	statuses := []crd.NodePolicyStatus{
+		*crd.NewNodePolicyStatus("node-1", "", crd.NodePolicyStatusStatus{Policies: []string{"db/postgres", "web/frontend"}}),
+		*crd.NewNodePolicyStatus("node-2", "", crd.NodePolicyStatusStatus{Policies: []string{"db/postgres"}}),
+		*crd.NewNodePolicyStatus("node-3", "", crd.NodePolicyStatusStatus{Policies: []string{"db/postgres", "web/frontend"},
+			Error: "Failed to run iptables command"}),
+		// deleted node
+		*crd.NewNodePolicyStatus("node-9", "", crd.NodePolicyStatusStatus{Policies: []string{"db/postgres", "web/frontend"}}),
+	}
+
+	status := aggregatePolicyStatus(policies, nodes, statuses)
+	if status.Nodes != 4 || status.ReportingNodes != 3 {
+		t.Errorf("expected 3 of 4 nodes reporting, got %d of %d", status.ReportingNodes, status.Nodes)
+	}
+	if len(status.Policies) != 2 {
+		t.Fatalf("expected the enforcement of 2 policies, got %+v", status.Policies)
+	}
+	postgres, frontend := status.Policies[0], status.Policies[1]
+	if postgres.Name != "postgres" || postgres.EnforcedNodes != 2 ||
+		postgres.Summary != "enforced on 2/4 nodes, failing on node-3" {
+		t.Errorf("unexpected enforcement of db/postgres %+v", postgres)
+	}
+	if frontend.Name != "frontend" || frontend.EnforcedNodes != 1 {
+		t.Errorf("unexpected enforcement of web/frontend %+v", frontend)
+	}
+	if len(frontend.FailingNodes) != 1 || frontend.FailingNodes[0].Node != "node-3" ||
+		frontend.FailingNodes[0].Error != "Failed to run iptables command" {
+		t.Errorf("expected web/frontend to fail on node-3, got %+v", frontend.FailingNodes)
+	}
+
+	if orphaned := orphanedPolicyStatuses(statuses, nodes); !reflect.DeepEqual(orphaned, []string{"node-9"}) {
+		t.Errorf("expected the status of node-9 to be orphaned, got %v", orphaned)
+	}
+
+	summary := enforcementSummary(95, 100, []string{"node-1", "node-2", "node-3", "node-4", "node-5"})
+	if summary != "enforced on 95/100 nodes, failing on node-1, node-2, node-3 and 2 more" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+func TestPolicyStatusReporterRecordSync(t *testing.T) {
+	r := newPolicyStatusReporter(nil, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}, time.Minute)
+	policies := &[]networkPolicyInfo{{namespace: "web", name: "frontend"}, {namespace: "db", name: "postgres"}}
+	r.recordSync(policies, nil)
+	if !r.changed || !reflect.DeepEqual(r.status.Policies, []string{"db/postgres", "web/frontend"}) {
+		t.Fatalf("expected the sorted policies to be recorded, got %+v", r.status)
+	}
+	r.changed = false
+	r.recordSync(policies, nil)
+	if r.changed {
+		t.Errorf("expected a sync of the same policies not to change the status")
+	}
+	r.recordSync(policies, errors.New("Failed to sync"))
+	if !r.changed || r.status.Error != "Failed to sync" {
+		t.Errorf("expected a failed sync to change the status, got %+v", r.status)
+	}
+
+	var disabled *policyStatusReporter
+	disabled.recordSync(policies, nil)
+}
@@ -0,0 +1,185 @@
+package netpol
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// label of the namespaces holding their name, set by the API server
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+	// annotation of the suggested network policies holding the start of the connections they allow
+	suggestedSinceAnnotation = "kube-router.io/suggested-from-connections-since"
+)
+
+// suggestedPeerPort is a peer of a workload and a port of the connections to the destination
+type suggestedPeerPort struct {
+	peer     GraphNode
+	protocol string
+	port     int
+}
+
+// suggestion collects the connections of a workload of the namespace
+type suggestion struct {
+	selector map[string]string
+	ingress  map[suggestedPeerPort]bool
+	egress   map[suggestedPeerPort]bool
+}
+
+// isWorkload tells whether the vertex is a workload, whose pods network policies select
+func (n GraphNode) isWorkload() bool {
+	return n.Kind != "Service" && n.Kind != "Node" && n.Kind != "External"
+}
+
+// SuggestPolicies generates a network policy for each workload of the namespace the service graph has connections
+// of since the given time, allowing these connections only. The graphs of several nodes can be passed together,
+// a node only seeing the connections from its pods and the connections from outside the cluster to them. The
+// directions of the workloads without any connection are left unrestricted. The peers network policies can not
+// select are returned as warnings.
+func SuggestPolicies(graph []GraphAdjacency, namespace string, since time.Time) ([]*networking.NetworkPolicy, []string) {
+	suggestions := make(map[GraphNode]*suggestion)
+	selectors := make(map[GraphNode]map[string]string)
+	workload := func(vertex GraphNode, selector map[string]string) *suggestion {
+		if len(selector) > 0 {
+			selectors[vertex] = selector
+		}
+		if vertex.Namespace != namespace || !vertex.isWorkload() {
+			return nil
+		}
+		s, ok := suggestions[vertex]
+		if !ok {
+			s = &suggestion{ingress: make(map[suggestedPeerPort]bool), egress: make(map[suggestedPeerPort]bool)}
+			suggestions[vertex] = s
+		}
+		if len(selector) > 0 {
+			s.selector = selector
+		}
+		return s
+	}
+	for _, adjacency := range graph {
+		for _, edge := range adjacency.Destinations {
+			if edge.LastSeen.Before(since) {
+				continue
+			}
+			if s := workload(adjacency.Source, adjacency.SourceSelector); s != nil {
+				s.egress[suggestedPeerPort{peer: edge.Destination, protocol: edge.Protocol, port: edge.Port}] = true
+			}
+			if s := workload(edge.Destination, edge.DestinationSelector); s != nil {
+				s.ingress[suggestedPeerPort{peer: adjacency.Source, protocol: edge.Protocol, port: edge.Port}] = true
+			}
+		}
+	}
+
+	policies := make([]*networking.NetworkPolicy, 0, len(suggestions))
+	warnings := make([]string, 0)
+	for vertex, s := range suggestions {
+		if len(s.selector) == 0 {
+			warnings = append(warnings, "no label selects the pods of "+vertex.String()+" alone, no policy suggested")
+			continue
+		}
+		policy := &networking.NetworkPolicy{
+			TypeMeta: metav1.TypeMeta{Kind: "NetworkPolicy", APIVersion: "networking.k8s.io/v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "suggested-" + strings.ToLower(vertex.Kind) + "-" + vertex.Name,
+				Namespace:   namespace,
+				Annotations: map[string]string{suggestedSinceAnnotation: since.UTC().Format(time.RFC3339)},
+			},
+			Spec: networking.NetworkPolicySpec{PodSelector: metav1.LabelSelector{MatchLabels: s.selector}},
+		}
+		if len(s.ingress) > 0 {
+			policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, networking.PolicyTypeIngress)
+			for _, rule := range suggestedRules(s.ingress, namespace, selectors, vertex, "from", &warnings) {
+				policy.Spec.Ingress = append(policy.Spec.Ingress,
+					networking.NetworkPolicyIngressRule{From: []networking.NetworkPolicyPeer{rule.peer}, Ports: rule.ports})
+			}
+		}
+		if len(s.egress) > 0 {
+			policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, networking.PolicyTypeEgress)
+			for _, rule := range suggestedRules(s.egress, namespace, selectors, vertex, "to", &warnings) {
+				policy.Spec.Egress = append(policy.Spec.Egress,
+					networking.NetworkPolicyEgressRule{To: []networking.NetworkPolicyPeer{rule.peer}, Ports: rule.ports})
+			}
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	sort.Strings(warnings)
+	return policies, warnings
+}
+
+// suggestedRule is a peer and the ports of the connections with it
+type suggestedRule struct {
+	key   string
+	peer  networking.NetworkPolicyPeer
+	ports []networking.NetworkPolicyPort
+}
+
+// suggestedRules groups the connections of a direction of a workload by peer, sorted
+func suggestedRules(peerPorts map[suggestedPeerPort]bool, namespace string, selectors map[GraphNode]map[string]string,
+	vertex GraphNode, direction string, warnings *[]string) []*suggestedRule {
+	byPeer := make(map[GraphNode]*suggestedRule)
+	for peerPort := range peerPorts {
+		rule, ok := byPeer[peerPort.peer]
+		if !ok {
+			peer, selectable := suggestedPeer(peerPort.peer, namespace, selectors)
+			if !selectable {
+				*warnings = append(*warnings, "connections of "+vertex.String()+" "+direction+" "+
+					peerPort.peer.String()+" can not be selected by a network policy, not allowed")
+				byPeer[peerPort.peer] = nil
+				continue
+			}
+			rule = &suggestedRule{key: peerPort.peer.String(), peer: peer}
+			byPeer[peerPort.peer] = rule
+		}
+		if rule == nil {
+			continue
+		}
+		protocol := api.Protocol(peerPort.protocol)
+		port := intstr.FromInt(peerPort.port)
+		rule.ports = append(rule.ports, networking.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+	}
+	rules := make([]*suggestedRule, 0, len(byPeer))
+	for _, rule := range byPeer {
+		if rule == nil {
+			continue
+		}
+		sort.Slice(rule.ports, func(i, j int) bool {
+			if *rule.ports[i].Protocol != *rule.ports[j].Protocol {
+				return *rule.ports[i].Protocol < *rule.ports[j].Protocol
+			}
+			return rule.ports[i].Port.IntValue() < rule.ports[j].Port.IntValue()
+		})
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].key < rules[j].key })
+	return rules
+}
+
+// suggestedPeer returns the network policy peer selecting the vertex, and false when none can: the pods of the
+// workloads are selected by their labels, the addresses outside the cluster by their address. The host network
+// traffic of the nodes is left out, as the graph does not hold their addresses.
+func suggestedPeer(vertex GraphNode, namespace string, selectors map[GraphNode]map[string]string) (
+	networking.NetworkPolicyPeer, bool) {
+	switch {
+	case vertex.Kind == "External":
+		return networking.NetworkPolicyPeer{IPBlock: &networking.IPBlock{CIDR: vertex.Name + "/32"}}, true
+	case !vertex.isWorkload():
+		return networking.NetworkPolicyPeer{}, false
+	}
+	selector, ok := selectors[vertex]
+	if !ok {
+		return networking.NetworkPolicyPeer{}, false
+	}
+	peer := networking.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{MatchLabels: selector}}
+	if vertex.Namespace != namespace {
+		peer.NamespaceSelector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{namespaceNameLabel: vertex.Namespace}}
+	}
+	return peer, true
+}
@@ -0,0 +1,70 @@
+package netpol
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+)
+
+func TestSuggestPolicies(t *testing.T) {
+	now := time.Now()
+	frontend := GraphNode{Kind: "Deployment", Namespace: "web", Name: "frontend"}
+	backend := GraphNode{Kind: "StatefulSet", Namespace: "web", Name: "backend"}
+	dns := GraphNode{Kind: "Deployment", Namespace: "kube-system", Name: "coredns"}
+	frontendSelector, backendSelector := map[string]string{"app": "frontend"}, map[string]string{"app": "backend"}
+	graph := []GraphAdjacency{
+		{Source: frontend, SourceSelector: frontendSelector, Destinations: []GraphEdge{
+			{Destination: backend, DestinationSelector: backendSelector, Protocol: "TCP", Port: 8080, LastSeen: now},
+			{Destination: backend, DestinationSelector: backendSelector, Protocol: "TCP", Port: 8443,
+				LastSeen: now.Add(-48 * time.Hour)},
+			{Destination: dns, DestinationSelector: map[string]string{"k8s-app": "kube-dns"}, Protocol: "UDP", Port: 53,
+				LastSeen: now},
+			{Destination: GraphNode{Kind: "Node", Name: "node-1"}, Protocol: "TCP", Port: 10250, LastSeen: now},
+		}},
+		// the graph of another node
+		{Source: GraphNode{Kind: "External", Name: "203.0.113.9"}, Destinations: []GraphEdge{
+			{Destination: frontend, DestinationSelector: frontendSelector, Protocol: "TCP", Port: 80, LastSeen: now},
+		}},
+	}
+	policies, warnings := SuggestPolicies(graph, "web", now.Add(-24*time.Hour))
+	if len(policies) != 2 || policies[0].Name != "suggested-deployment-frontend" ||
+		policies[1].Name != "suggested-statefulset-backend" {
+		t.Fatalf("expected a policy for the frontend and the backend, got %+v", policies)
+	}
+	if !reflect.DeepEqual(warnings, []string{"connections of Deployment web/frontend to Node node-1 can not be " +
+		"selected by a network policy, not allowed"}) {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+
+	frontendPolicy := policies[0]
+	if !reflect.DeepEqual(frontendPolicy.Spec.PodSelector.MatchLabels, frontendSelector) ||
+		!reflect.DeepEqual(frontendPolicy.Spec.PolicyTypes, []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress}) {
+		t.Errorf("unexpected frontend policy %+v", frontendPolicy.Spec)
+	}
+	if len(frontendPolicy.Spec.Ingress) != 1 || frontendPolicy.Spec.Ingress[0].From[0].IPBlock.CIDR != "203.0.113.9/32" {
+		t.Errorf("expected ingress from the external address only, got %+v", frontendPolicy.Spec.Ingress)
+	}
+	egress := frontendPolicy.Spec.Egress
+	if len(egress) != 2 {
+		t.Fatalf("expected egress to the backend and the DNS pods, got %+v", egress)
+	}
+	// the rules are sorted by peer, the connections to the backend older than the window are left out
+	if !reflect.DeepEqual(egress[0].To[0].NamespaceSelector.MatchLabels, map[string]string{namespaceNameLabel: "kube-system"}) ||
+		*egress[0].Ports[0].Protocol != v1.ProtocolUDP {
+		t.Errorf("unexpected egress rule to the DNS pods %+v", egress[0])
+	}
+	if !reflect.DeepEqual(egress[1].To[0].PodSelector.MatchLabels, backendSelector) || egress[1].To[0].NamespaceSelector != nil ||
+		len(egress[1].Ports) != 1 || egress[1].Ports[0].Port.IntValue() != 8080 {
+		t.Errorf("unexpected egress rule to the backend %+v", egress[1])
+	}
+
+	backendPolicy := policies[1]
+	if !reflect.DeepEqual(backendPolicy.Spec.PolicyTypes, []netv1.PolicyType{netv1.PolicyTypeIngress}) ||
+		len(backendPolicy.Spec.Ingress) != 1 || !reflect.DeepEqual(backendPolicy.Spec.Ingress[0].From[0].PodSelector.MatchLabels,
+		frontendSelector) {
+		t.Errorf("unexpected backend policy %+v", backendPolicy.Spec)
+	}
+}
@@ -0,0 +1,13 @@
+package netpol
+
+import (
+	"strconv"
+)
+
+// portRange returns the destination ports of a port of a network policy, first:last for a range
+func portRange(port string, endPort int32) string {
+	if endPort == 0 || port == strconv.Itoa(int(endPort)) {
+		return port
+	}
+	return port + ":" + strconv.Itoa(int(endPort))
+}
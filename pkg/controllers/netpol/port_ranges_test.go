@@ -0,0 +1,32 @@
+package netpol
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestPolicyPortRanges(t *testing.T) {
+	npc := &NetworkPolicyController{}
+	tcp := v1.ProtocolTCP
+	https, low, single := intstr.FromInt(443), intstr.FromInt(8000), intstr.FromInt(9000)
+	high, same := int32(8100), int32(9000)
+	ports, _ := npc.processNetworkPolicyPorts([]netv1.NetworkPolicyPort{{Protocol: &tcp, Port: &https},
+		{Protocol: &tcp, Port: &low, EndPort: &high}, {Protocol: &tcp, Port: &single, EndPort: &same}}, namedPort2eps{})
+	expected := []protocolAndPort{{protocol: "TCP", port: "443"}, {protocol: "TCP", port: "8000:8100"},
+		{protocol: "TCP", port: "9000"}}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("expected ports %v, got %v", expected, ports)
+	}
+	args := policyRuleArgs(nil, "", "", "KUBE-DST-B", ports[1].protocol, ports[1].port)
+	if !reflect.DeepEqual(args, []string{"-m", "set", "--match-set", "KUBE-DST-B", "dst", "-p", "TCP", "--dport",
+		"8000:8100", "-j", "ACCEPT"}) {
+		t.Errorf("unexpected rule of the range %v", args)
+	}
+	if !portMatches(ports[1].port, 8050) || portMatches(ports[1].port, 8101) {
+		t.Errorf("expected the verdicts to match the range of ports")
+	}
+}
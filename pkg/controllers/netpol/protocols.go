@@ -0,0 +1,37 @@
+package netpol
+
+import (
+	"fmt"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+)
+
+// policyPortProtocol returns the protocol of a port of a network policy or of a container, TCP when unset like the
+// API server defaults it, or an error for the protocols whose destination ports the rules can not match
+func policyPortProtocol(protocol *api.Protocol) (string, error) {
+	if protocol == nil || *protocol == "" {
+		return string(api.ProtocolTCP), nil
+	}
+	switch p := api.Protocol(strings.ToUpper(string(*protocol))); p {
+	case api.ProtocolTCP, api.ProtocolUDP, api.ProtocolSCTP:
+		return string(p), nil
+	}
+	return "", fmt.Errorf("unsupported protocol %q", *protocol)
+}
+
+// protocolMatchArgs appends the iptables arguments matching the protocol and the destination port to args, the empty
+// matches are left out. The sctp match is named explicitly rather than left to the implicit loading of the match of
+// the protocol the tcp and udp rules rely on.
+func protocolMatchArgs(args []string, protocol, dPort string) []string {
+	if protocol != "" {
+		args = append(args, "-p", protocol)
+		if dPort != "" && strings.EqualFold(protocol, string(api.ProtocolSCTP)) {
+			args = append(args, "-m", "sctp")
+		}
+	}
+	if dPort != "" {
+		args = append(args, "--dport", dPort)
+	}
+	return args
+}
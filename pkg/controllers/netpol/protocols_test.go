@@ -0,0 +1,57 @@
+package netpol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestPolicyPortProtocols(t *testing.T) {
+	npc := &NetworkPolicyController{}
+	tcp, sctp, lowerSCTP, icmp := v1.ProtocolTCP, v1.Protocol("SCTP"), v1.Protocol("sctp"), v1.Protocol("ICMP")
+	port, diameter := intstr.FromInt(80), intstr.FromString("diameter")
+	namedPorts := namedPort2eps{}
+	npc.grabNamedPortFromPod(&v1.Pod{
+		Spec: v1.PodSpec{Containers: []v1.Container{{Ports: []v1.ContainerPort{
+			{Name: "diameter", Protocol: sctp, ContainerPort: 3868}}}}},
+		Status: v1.PodStatus{PodIP: "10.1.0.5"},
+	}, &namedPorts)
+
+	numeric, named := npc.processNetworkPolicyPorts([]netv1.NetworkPolicyPort{
+		{Port: &port},
+		{Protocol: &tcp, Port: &port},
+		{Protocol: &lowerSCTP, Port: &port},
+		{Protocol: &sctp, Port: &diameter},
+		{Protocol: &icmp},
+	}, namedPorts)
+	expected := []protocolAndPort{{protocol: "TCP", port: "80"}, {protocol: "TCP", port: "80"},
+		{protocol: "SCTP", port: "80"}}
+	if !reflect.DeepEqual(numeric, expected) {
+		t.Errorf("expected numeric ports %v, got %v", expected, numeric)
+	}
+	expectedNamed := []endPoints{{ips: []string{"10.1.0.5"}, protocolAndPort: protocolAndPort{protocol: "SCTP", port: "3868"}}}
+	if !reflect.DeepEqual(named, expectedNamed) {
+		t.Errorf("expected named ports %v, got %v", expectedNamed, named)
+	}
+
+	args := policyRuleArgs(nil, "rule to ACCEPT traffic from source pods to dest pods selected by policy name allow-diameter namespace telco",
+		"KUBE-SRC-A", "KUBE-DST-B", "SCTP", "3868")
+	if !reflect.DeepEqual(args[len(args)-8:], []string{"-p", "SCTP", "-m", "sctp", "--dport", "3868", "-j", "ACCEPT"}) {
+		t.Errorf("unexpected SCTP rule %v", args)
+	}
+	if len(args) > maxPolicyRuleArgs {
+		t.Errorf("expected at most %d arguments, got %d", maxPolicyRuleArgs, len(args))
+	}
+	if args := policyRuleArgs(nil, "", "", "KUBE-DST-B", "SCTP", ""); !reflect.DeepEqual(args,
+		[]string{"-m", "set", "--match-set", "KUBE-DST-B", "dst", "-p", "SCTP", "-j", "ACCEPT"}) {
+		t.Errorf("unexpected SCTP rule without port %v", args)
+	}
+	if rule := (&nftRuleset{}).policyRule("", "", "KUBE-DST-B", "SCTP", "3868"); !strings.Contains(rule,
+		"ip daddr @KUBE-DST-B sctp dport 3868 accept") {
+		t.Errorf("unexpected nftables SCTP rule %q", rule)
+	}
+}
@@ -0,0 +1,54 @@
+package netpol
+
+import (
+	"sync"
+)
+
+// maximum number of arguments of an ACCEPT rule of a policy chain: comment, source and destination sets, protocol,
+// port and target
+const maxPolicyRuleArgs = 22
+
+// ruleArgsPool holds the argument buffers of the rules of the policy chains, reused across rules and syncs as a
+// policy with many peers and ports expands into as many rules
+var ruleArgsPool = sync.Pool{
+	New: func() interface{} {
+		args := make([]string, 0, maxPolicyRuleArgs)
+		return &args
+	},
+}
+
+// policyRuleComments are the comments of the rules of a policy chain, built once per policy and sync rather than
+// for each rule
+type policyRuleComments struct {
+	sourcePods          string
+	allSources          string
+	sourceIPBlocks      string
+	allDestinations     string
+	destinationIPBlocks string
+}
+
+func newPolicyRuleComments(policy networkPolicyInfo) policyRuleComments {
+	policyName := policy.name + " namespace " + policy.namespace
+	return policyRuleComments{
+		sourcePods:          "rule to ACCEPT traffic from source pods to dest pods selected by policy name " + policyName,
+		allSources:          "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " + policyName,
+		sourceIPBlocks:      "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " + policyName,
+		allDestinations:     "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " + policyName,
+		destinationIPBlocks: "rule to ACCEPT traffic from source pods to specified ipBlocks selected by policy name: " + policyName,
+	}
+}
+
+// policyRuleArgs appends the arguments of an ACCEPT rule of a policy chain to args, the empty matches are left out
+func policyRuleArgs(args []string, comment, srcIpSetName, dstIpSetName, protocol, dPort string) []string {
+	if comment != "" {
+		args = append(args, "-m", "comment", "--comment", comment)
+	}
+	if srcIpSetName != "" {
+		args = append(args, "-m", "set", "--match-set", srcIpSetName, "src")
+	}
+	if dstIpSetName != "" {
+		args = append(args, "-m", "set", "--match-set", dstIpSetName, "dst")
+	}
+	args = protocolMatchArgs(args, protocol, dPort)
+	return append(args, "-j", "ACCEPT")
+}
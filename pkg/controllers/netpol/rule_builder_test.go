@@ -0,0 +1,37 @@
+package netpol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPolicyRuleArgs(t *testing.T) {
+	comments := newPolicyRuleComments(networkPolicyInfo{namespace: "tenant-a", name: "allow-web"})
+	if comments.allSources != "rule to ACCEPT traffic from all sources to dest pods selected by policy name: allow-web namespace tenant-a" {
+		t.Errorf("unexpected comment %q", comments.allSources)
+	}
+
+	args := policyRuleArgs(nil, comments.sourcePods, "KUBE-SRC-A", "KUBE-DST-B", "tcp", "80")
+	expected := []string{"-m", "comment", "--comment", comments.sourcePods, "-m", "set", "--match-set", "KUBE-SRC-A", "src",
+		"-m", "set", "--match-set", "KUBE-DST-B", "dst", "-p", "tcp", "--dport", "80", "-j", "ACCEPT"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v, got %v", expected, args)
+	}
+	if len(args) > maxPolicyRuleArgs {
+		t.Errorf("expected at most %d arguments, got %d", maxPolicyRuleArgs, len(args))
+	}
+	if args := policyRuleArgs(nil, "", "", "KUBE-DST-B", "", ""); !reflect.DeepEqual(args,
+		[]string{"-m", "set", "--match-set", "KUBE-DST-B", "dst", "-j", "ACCEPT"}) {
+		t.Errorf("unexpected arguments without comment, source, protocol and port %v", args)
+	}
+
+	// the arguments of the rules are built without allocation once the buffers are pooled
+	allocs := testing.AllocsPerRun(100, func() {
+		buf := ruleArgsPool.Get().(*[]string)
+		*buf = policyRuleArgs((*buf)[:0], comments.sourcePods, "KUBE-SRC-A", "KUBE-DST-B", "tcp", "80")
+		ruleArgsPool.Put(buf)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocation per rule, got %v", allocs)
+	}
+}
@@ -0,0 +1,409 @@
+package netpol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// edges of the service graph not seen in the samples of the last serviceGraphRetention are dropped
+const serviceGraphRetention = 24 * time.Hour
+
+// GraphNode is a vertex of the service graph
+type GraphNode struct {
+	// Kind is the kind of the controller of the pods, e.g. Deployment, StatefulSet or DaemonSet, Pod for the pods
+	// without controller, or Service, Node and External
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	// Name is the address of the External vertices
+	Name string `json:"name"`
+}
+
+func (n GraphNode) String() string {
+	if n.Namespace == "" {
+		return n.Kind + " " + n.Name
+	}
+	return n.Kind + " " + n.Namespace + "/" + n.Name
+}
+
+// GraphEdge is a destination of the connections of a source of the service graph
+type GraphEdge struct {
+	Destination GraphNode `json:"destination"`
+	// DestinationSelector is the labels of the pods of the destination workload, see GraphAdjacency
+	DestinationSelector map[string]string `json:"destinationSelector,omitempty"`
+	// Service is the service the connections were addressed to, if any
+	Service  *GraphNode `json:"service,omitempty"`
+	Protocol string     `json:"protocol"`
+	// Port is the port of the destination, after the translation of the service port
+	Port int `json:"port"`
+	// Connections is the number of distinct connections the samples saw
+	Connections int       `json:"connections"`
+	LastSeen    time.Time `json:"lastSeen"`
+	// Allowed is the verdict of the network policies for the connections when they were last seen. Connections the
+	// network policies deny are the ones established before the policies denied them.
+	Allowed bool `json:"allowed"`
+}
+
+// GraphAdjacency is a source of the service graph and the destinations of its connections
+type GraphAdjacency struct {
+	Source GraphNode `json:"source"`
+	// SourceSelector is the labels shared by the pods of the source workload, leaving out the labels the controllers
+	// set to tell the pods apart. Empty for the vertices other than workloads.
+	SourceSelector map[string]string `json:"sourceSelector,omitempty"`
+	Destinations   []GraphEdge       `json:"destinations"`
+}
+
+// conntrackFlow is a connection tracked by conntrack: the original direction, and the source of the reply, the
+// destination after DNAT
+type conntrackFlow struct {
+	protocol string
+	src      string
+	dst      string
+	sport    int
+	dport    int
+	replySrc string
+	// source port of the reply, the destination port after DNAT
+	replySport int
+}
+
+func (f conntrackFlow) key() string {
+	return f.protocol + " " + f.src + ":" + strconv.Itoa(f.sport) + " " + f.dst + ":" + strconv.Itoa(f.dport)
+}
+
+// parseConntrackFlows parses the TCP, UDP and SCTP connections listed by conntrack -L
+func parseConntrackFlows(out []byte) []conntrackFlow {
+	flows := make([]conntrackFlow, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		flow := conntrackFlow{protocol: strings.ToUpper(fields[0])}
+		if flow.protocol != "TCP" && flow.protocol != "UDP" && flow.protocol != string(api.ProtocolSCTP) {
+			continue
+		}
+		// the first src, dst, sport and dport are of the original direction, the second src and sport of the reply
+		srcs, sports := 0, 0
+		for _, field := range fields {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "src":
+				if srcs == 0 {
+					flow.src = kv[1]
+				} else if srcs == 1 {
+					flow.replySrc = kv[1]
+				}
+				srcs++
+			case "dst":
+				if flow.dst == "" {
+					flow.dst = kv[1]
+				}
+			case "sport":
+				port, _ := strconv.Atoi(kv[1])
+				if sports == 0 {
+					flow.sport = port
+				} else if sports == 1 {
+					flow.replySport = port
+				}
+				sports++
+			case "dport":
+				if flow.dport == 0 {
+					flow.dport, _ = strconv.Atoi(kv[1])
+				}
+			}
+		}
+		if flow.src == "" || flow.dst == "" || flow.replySrc == "" {
+			continue
+		}
+		flows = append(flows, flow)
+	}
+	return flows
+}
+
+// graphIndex maps the addresses of the cluster to the vertices of the service graph
+type graphIndex struct {
+	pods      map[string]GraphNode
+	localPods map[string]bool
+	services  map[string]GraphNode
+	nodes     map[string]GraphNode
+	// labels shared by the pods of the workloads
+	selectors map[GraphNode]map[string]string
+}
+
+// podInstanceLabels are set by the controllers on their pods to tell them, or their revisions, apart
+var podInstanceLabels = map[string]bool{
+	"pod-template-hash":                  true,
+	"controller-revision-hash":           true,
+	"pod-template-generation":            true,
+	"statefulset.kubernetes.io/pod-name": true,
+	"controller-uid":                     true,
+	"job-name":                           true,
+}
+
+// addSelector narrows the selector of the workload to the labels it shares with the pod
+func (index *graphIndex) addSelector(workload GraphNode, podLabels map[string]string) {
+	selector, ok := index.selectors[workload]
+	if !ok {
+		selector = make(map[string]string)
+		for key, value := range podLabels {
+			if !podInstanceLabels[key] {
+				selector[key] = value
+			}
+		}
+		index.selectors[workload] = selector
+		return
+	}
+	for key, value := range selector {
+		if podLabels[key] != value {
+			delete(selector, key)
+		}
+	}
+}
+
+// podWorkload returns the workload of the pod, the controller owning it. The ReplicaSets of Deployments are named
+// after the Deployment and the hash of the pod template, the Deployment is the workload then.
+func podWorkload(pod *api.Pod) GraphNode {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		kind, name := owner.Kind, owner.Name
+		if hash := pod.Labels["pod-template-hash"]; kind == "ReplicaSet" && hash != "" &&
+			strings.HasSuffix(name, "-"+hash) {
+			kind, name = "Deployment", strings.TrimSuffix(name, "-"+hash)
+		}
+		return GraphNode{Kind: kind, Namespace: pod.Namespace, Name: name}
+	}
+	return GraphNode{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name}
+}
+
+// newGraphIndex indexes the pods, services and nodes of the informer caches. Host network pods are reported as
+// their node.
+func newGraphIndex(nodeIP string, podLister, serviceLister, nodeLister cache.Indexer) *graphIndex {
+	index := &graphIndex{pods: make(map[string]GraphNode), localPods: make(map[string]bool),
+		services: make(map[string]GraphNode), nodes: make(map[string]GraphNode),
+		selectors: make(map[GraphNode]map[string]string)}
+	for _, obj := range podLister.List() {
+		pod, ok := obj.(*api.Pod)
+		if !ok || pod.Spec.HostNetwork || pod.Status.PodIP == "" {
+			continue
+		}
+		workload := podWorkload(pod)
+		index.pods[pod.Status.PodIP] = workload
+		index.addSelector(workload, pod.Labels)
+		if pod.Status.HostIP == nodeIP {
+			index.localPods[pod.Status.PodIP] = true
+		}
+	}
+	for _, obj := range serviceLister.List() {
+		svc, ok := obj.(*api.Service)
+		if !ok {
+			continue
+		}
+		vertex := GraphNode{Kind: "Service", Namespace: svc.Namespace, Name: svc.Name}
+		if svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != api.ClusterIPNone {
+			index.services[svc.Spec.ClusterIP] = vertex
+		}
+		for _, ip := range svc.Spec.ExternalIPs {
+			index.services[ip] = vertex
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				index.services[ingress.IP] = vertex
+			}
+		}
+	}
+	for _, obj := range nodeLister.List() {
+		node, ok := obj.(*api.Node)
+		if !ok {
+			continue
+		}
+		for _, address := range node.Status.Addresses {
+			if address.Type == api.NodeInternalIP || address.Type == api.NodeExternalIP {
+				index.nodes[address.Address] = GraphNode{Kind: "Node", Name: node.Name}
+			}
+		}
+	}
+	return index
+}
+
+// vertex returns the vertex of a source or destination address, after DNAT
+func (index *graphIndex) vertex(ip string) GraphNode {
+	if vertex, ok := index.pods[ip]; ok {
+		return vertex
+	}
+	if vertex, ok := index.nodes[ip]; ok {
+		return vertex
+	}
+	return GraphNode{Kind: "External", Name: ip}
+}
+
+type graphEdgeKey struct {
+	source      GraphNode
+	destination GraphNode
+	service     GraphNode
+	protocol    string
+	port        int
+}
+
+// serviceGraph accumulates the connections of the conntrack samples into the edges of the service graph
+type serviceGraph struct {
+	mu    sync.Mutex
+	edges map[graphEdgeKey]*GraphEdge
+	// selectors of the workloads of the edges, from the last sample seeing their pods
+	selectors map[GraphNode]map[string]string
+	// connections of the previous sample, so the connections living across samples are counted once
+	previous map[string]bool
+}
+
+func newServiceGraph() *serviceGraph {
+	return &serviceGraph{edges: make(map[graphEdgeKey]*GraphEdge),
+		selectors: make(map[GraphNode]map[string]string), previous: make(map[string]bool)}
+}
+
+// record adds the connections of a sample. Each node records the connections from its pods, and the connections to
+// its pods from outside the pods of the cluster, so the graphs of the nodes add up to the graph of the cluster.
+func (g *serviceGraph) record(flows []conntrackFlow, index *graphIndex, allowed func(Flow) bool, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	seen := make(map[string]bool, len(flows))
+	for _, flow := range flows {
+		_, srcIsPod := index.pods[flow.src]
+		if !index.localPods[flow.src] && (srcIsPod || !index.localPods[flow.replySrc]) {
+			continue
+		}
+		key := graphEdgeKey{source: index.vertex(flow.src), destination: index.vertex(flow.replySrc),
+			protocol: flow.protocol, port: flow.replySport}
+		if service, ok := index.services[flow.dst]; ok {
+			key.service = service
+		}
+		edge, ok := g.edges[key]
+		if !ok {
+			edge = &GraphEdge{Destination: key.destination, Protocol: key.protocol, Port: key.port}
+			if key.service.Kind != "" {
+				service := key.service
+				edge.Service = &service
+			}
+			g.edges[key] = edge
+		}
+		flowKey := flow.key()
+		seen[flowKey] = true
+		if !g.previous[flowKey] {
+			edge.Connections++
+		}
+		edge.LastSeen = now
+		edge.Allowed = allowed(Flow{Source: flow.src, Destination: flow.replySrc, Protocol: flow.protocol,
+			Port: flow.replySport})
+	}
+	g.previous = seen
+	vertices := make(map[GraphNode]bool)
+	for key, edge := range g.edges {
+		if now.Sub(edge.LastSeen) > serviceGraphRetention {
+			delete(g.edges, key)
+			continue
+		}
+		vertices[key.source], vertices[key.destination] = true, true
+	}
+	for workload := range vertices {
+		if selector := index.selectors[workload]; len(selector) > 0 {
+			g.selectors[workload] = selector
+		}
+	}
+	for workload := range g.selectors {
+		if !vertices[workload] {
+			delete(g.selectors, workload)
+		}
+	}
+}
+
+// adjacency returns the edges by source, sorted
+func (g *serviceGraph) adjacency() []GraphAdjacency {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	bySource := make(map[GraphNode][]GraphEdge)
+	for key, edge := range g.edges {
+		destination := *edge
+		destination.DestinationSelector = g.selectors[key.destination]
+		bySource[key.source] = append(bySource[key.source], destination)
+	}
+	adjacency := make([]GraphAdjacency, 0, len(bySource))
+	for source, edges := range bySource {
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].Destination != edges[j].Destination {
+				return edges[i].Destination.String() < edges[j].Destination.String()
+			}
+			if edges[i].Protocol != edges[j].Protocol {
+				return edges[i].Protocol < edges[j].Protocol
+			}
+			return edges[i].Port < edges[j].Port
+		})
+		adjacency = append(adjacency, GraphAdjacency{Source: source, SourceSelector: g.selectors[source],
+			Destinations: edges})
+	}
+	sort.Slice(adjacency, func(i, j int) bool {
+		return adjacency[i].Source.String() < adjacency[j].Source.String()
+	})
+	return adjacency
+}
+
+// sampleServiceGraph lists the connections tracked by conntrack and records them in the service graph
+func (npc *NetworkPolicyController) sampleServiceGraph() error {
+	out, err := utils.Exec("conntrack", "-L", "-f", "ipv4")
+	if err != nil {
+		return err
+	}
+	flows := parseConntrackFlows(out)
+	index := newGraphIndex(npc.nodeIP.String(), npc.podLister, npc.ServiceLister, npc.NodeLister)
+
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	pods := npc.podsByIP()
+	allowed := func(flow Flow) bool {
+		if npc.networkPoliciesInfo == nil {
+			return true
+		}
+		return npc.evaluateFlow(pods, flow).Allowed
+	}
+	npc.serviceGraph.record(flows, index, allowed, time.Now())
+	return nil
+}
+
+// runServiceGraph samples the connections into the service graph every sample period until stopCh is closed
+func (npc *NetworkPolicyController) runServiceGraph(stopCh <-chan struct{}) {
+	t := time.NewTicker(npc.serviceGraphSamplePeriod)
+	defer t.Stop()
+	for {
+		if err := npc.sampleServiceGraph(); err != nil {
+			glog.Errorf("Failed to sample the connections of the service graph: %s", err)
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// ServiceGraph returns the sources of the connections seen on the node over the last day, the pods of the node and
+// the addresses outside the cluster connecting to them, and the destinations of their connections
+func (npc *NetworkPolicyController) ServiceGraph() ([]GraphAdjacency, error) {
+	if npc.serviceGraph == nil {
+		return nil, errors.New("the service graph is only sampled with --service-graph-sample-period")
+	}
+	return npc.serviceGraph.adjacency(), nil
+}
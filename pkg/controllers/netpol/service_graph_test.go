@@ -0,0 +1,77 @@
+package netpol
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestServiceGraph(t *testing.T) {
+	out := []byte(`tcp      6 86399 ESTABLISHED src=10.1.0.5 dst=10.96.0.20 sport=41000 dport=80 src=10.1.1.7 dst=10.1.0.5 sport=8080 dport=41000 [ASSURED] mark=0 use=1
+udp      17 29 src=10.1.0.5 dst=8.8.8.8 sport=5353 dport=53 [UNREPLIED] src=8.8.8.8 dst=192.168.1.10 sport=53 dport=5353 mark=0 use=1
+icmp     1 29 src=10.1.0.5 dst=10.1.1.7 type=8 code=0 id=1 src=10.1.1.7 dst=10.1.0.5 type=0 code=0 id=1 mark=0 use=1
+tcp      6 86399 ESTABLISHED src=10.1.1.7 dst=10.1.0.5 sport=42000 dport=9090 src=10.1.0.5 dst=10.1.1.7 sport=9090 dport=42000 [ASSURED] mark=0 use=1
+tcp      6 86399 ESTABLISHED src=203.0.113.9 dst=10.1.0.5 sport=43000 dport=9090 src=10.1.0.5 dst=203.0.113.9 sport=9090 dport=43000 [ASSURED] mark=0 use=1
+`)
+	flows := parseConntrackFlows(out)
+	if len(flows) != 4 {
+		t.Fatalf("expected 4 TCP and UDP flows, got %v", flows)
+	}
+	if flows[0].replySrc != "10.1.1.7" || flows[0].replySport != 8080 || flows[0].dport != 80 {
+		t.Errorf("unexpected reply of the DNATed flow %+v", flows[0])
+	}
+
+	controller := true
+	podLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	serviceLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	podLister.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "frontend-7d9f-x2x", Namespace: "web",
+			Labels:          map[string]string{"app": "frontend", "pod-template-hash": "7d9f"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "frontend-7d9f", Controller: &controller}}},
+		Status: v1.PodStatus{PodIP: "10.1.0.5", HostIP: "192.168.1.10"},
+	})
+	podLister.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend-0", Namespace: "web",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "backend", Controller: &controller}}},
+		Status: v1.PodStatus{PodIP: "10.1.1.7", HostIP: "192.168.1.11"},
+	})
+	serviceLister.Add(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "web"},
+		Spec: v1.ServiceSpec{ClusterIP: "10.96.0.20"}})
+	index := newGraphIndex("192.168.1.10", podLister, serviceLister, nodeLister)
+
+	graph := newServiceGraph()
+	now := time.Now()
+	allowed := func(flow Flow) bool { return flow.Source != "203.0.113.9" }
+	graph.record(flows, index, allowed, now)
+	graph.record(flows, index, allowed, now.Add(time.Minute))
+
+	frontend := GraphNode{Kind: "Deployment", Namespace: "web", Name: "frontend"}
+	backend := GraphNode{Kind: "StatefulSet", Namespace: "web", Name: "backend"}
+	service := GraphNode{Kind: "Service", Namespace: "web", Name: "backend"}
+	expected := []GraphAdjacency{
+		{Source: frontend, SourceSelector: map[string]string{"app": "frontend"}, Destinations: []GraphEdge{
+			{Destination: GraphNode{Kind: "External", Name: "8.8.8.8"}, Protocol: "UDP", Port: 53, Connections: 1,
+				LastSeen: now.Add(time.Minute), Allowed: true},
+			{Destination: backend, Service: &service, Protocol: "TCP", Port: 8080, Connections: 1,
+				LastSeen: now.Add(time.Minute), Allowed: true},
+		}},
+		// the connection from the remote pod is reported by its node, the one from outside the cluster by this one
+		{Source: GraphNode{Kind: "External", Name: "203.0.113.9"}, Destinations: []GraphEdge{
+			{Destination: frontend, DestinationSelector: map[string]string{"app": "frontend"}, Protocol: "TCP", Port: 9090,
+				Connections: 1, LastSeen: now.Add(time.Minute)},
+		}},
+	}
+	if adjacency := graph.adjacency(); !reflect.DeepEqual(adjacency, expected) {
+		t.Errorf("expected service graph %+v, got %+v", expected, adjacency)
+	}
+
+	graph.record(nil, index, allowed, now.Add(serviceGraphRetention+2*time.Minute))
+	if adjacency := graph.adjacency(); len(adjacency) != 0 {
+		t.Errorf("expected the edges to expire, got %+v", adjacency)
+	}
+}
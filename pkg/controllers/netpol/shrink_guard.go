@@ -0,0 +1,132 @@
+package netpol
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+)
+
+// The stale chains and ipsets are not removed when the desired state of a sync shrank by more than
+// --policy-shrink-threshold percent of the state of the last sync that removed them, e.g. when an informer restarted
+// and its lister came back empty, until the shrink is confirmed: by a sync --policy-shrink-confirm-period after the
+// shrink was first seen, or by the operator with kube-routerctl confirm-cleanup. Until then the rules of the chains of
+// the last sync, and the jumps to them, keep enforcing the network policies.
+
+// HeldCleanup describes the removal of the stale chains and ipsets held as the desired state shrank
+type HeldCleanup struct {
+	// Since is when the shrink was first seen
+	Since time.Time `json:"since"`
+	// Synced is the number of chains and ipsets of the last sync that removed the stale ones
+	Synced int `json:"synced"`
+	// Desired is the number of chains and ipsets of the last sync
+	Desired int `json:"desired"`
+}
+
+// shrinkGuard holds the removal of the stale chains and ipsets when the desired state shrank beyond the threshold
+type shrinkGuard struct {
+	// percentage of the state the desired state may shrink by in a sync, 0 disables the guard
+	threshold int
+	// the shrink is confirmed by a sync this long after it was first seen, only by the operator when 0
+	confirmPeriod time.Duration
+
+	// number of chains and ipsets of the last sync that removed the stale ones
+	synced int
+	// the held cleanup, nil when none is
+	held *HeldCleanup
+	// whether the operator confirmed the held cleanup
+	confirmed bool
+}
+
+func validateShrinkThreshold(threshold int) error {
+	if threshold < 0 || threshold > 100 {
+		return errors.New("--policy-shrink-threshold must be a percentage between 0 and 100")
+	}
+	return nil
+}
+
+// hold tells whether the removal of the stale chains and ipsets must be held as the desired state of the sync, of
+// size chains and ipsets, shrank beyond the threshold and the shrink is not confirmed yet. confirm is called after the
+// confirm period when the shrink is first seen, to run the confirmation sync.
+func (g *shrinkGuard) hold(size int, now time.Time, confirm func()) bool {
+	if g == nil || g.threshold == 0 {
+		return false
+	}
+	if (g.synced-size)*100 <= g.threshold*g.synced {
+		if g.held != nil {
+			glog.Infof("The desired state is back to %d chains and ipsets, removing the stale ones", size)
+		}
+		g.synced, g.held, g.confirmed = size, nil, false
+		return false
+	}
+	if g.held != nil {
+		g.held.Desired = size
+		confirmed := g.confirmed || (g.confirmPeriod > 0 && now.Sub(g.held.Since) >= g.confirmPeriod)
+		if confirmed {
+			glog.Infof("Shrink of the desired state from %d to %d chains and ipsets confirmed, removing the "+
+				"stale ones", g.synced, size)
+			g.synced, g.held, g.confirmed = size, nil, false
+			return false
+		}
+		return true
+	}
+
+	g.held = &HeldCleanup{Since: now, Synced: g.synced, Desired: size}
+	metrics.ControllerPolicyShrinksHeld.Inc()
+	if g.confirmPeriod > 0 {
+		glog.Warningf("The desired state shrank from %d to %d chains and ipsets, beyond --policy-shrink-threshold "+
+			"of %d%%. Keeping the stale ones until a sync confirms the shrink in %s, or kube-routerctl "+
+			"confirm-cleanup", g.synced, size, g.threshold, g.confirmPeriod)
+		time.AfterFunc(g.confirmPeriod, confirm)
+	} else {
+		glog.Warningf("The desired state shrank from %d to %d chains and ipsets, beyond --policy-shrink-threshold "+
+			"of %d%%. Keeping the stale ones until kube-routerctl confirm-cleanup", g.synced, size, g.threshold)
+	}
+	return true
+}
+
+// holdStaleRules tells whether the removal of the stale chains and ipsets is held as the desired state of the sync
+// shrank. When the hold starts, the chains and ipsets of the last sync, and the rules of its dispatch chains jumping to
+// its pod firewall chains, are kept until the shrink is confirmed: the rules are appended to filterTable after the
+// ones of each held sync, so the stale chains keep enforcing the network policies.
+func (npc *NetworkPolicyController) holdStaleRules(filterTable *utils.IPTablesRestore, activePolicyChains,
+	activePodFwChains, activePolicyIpSets map[string]bool) bool {
+	desired := len(activePolicyChains) + len(activePodFwChains) + len(activePolicyIpSets)
+	if !npc.shrinkGuard.hold(desired, time.Now(), func() { npc.syncQueue.add(syncFull) }) {
+		npc.heldRules, npc.heldJumps = nil, nil
+		return false
+	}
+	if npc.heldRules == nil {
+		npc.heldRules, npc.heldJumps = npc.syncedRules, nil
+		for _, jump := range npc.dispatchJumps {
+			if !activePodFwChains[jump.target()] {
+				npc.heldJumps = append(npc.heldJumps, jump)
+			}
+		}
+	}
+	for _, jump := range npc.heldJumps {
+		filterTable.AppendUnique(jump.chain, jump.args...)
+	}
+	return true
+}
+
+// ConfirmCleanup confirms the removal of the stale chains and ipsets held as the desired state shrank, which the sync
+// it runs performs, and returns the confirmed cleanup
+func (npc *NetworkPolicyController) ConfirmCleanup() (*HeldCleanup, error) {
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	if npc.shrinkGuard == nil || npc.shrinkGuard.threshold == 0 {
+		return nil, errors.New("the shrinks of the desired state are only held with --policy-shrink-threshold")
+	}
+	if npc.shrinkGuard.held == nil {
+		return nil, errors.New("no removal of stale chains and ipsets is held")
+	}
+	npc.shrinkGuard.confirmed = true
+	held := *npc.shrinkGuard.held
+	glog.Infof("The operator confirmed the shrink of the desired state from %d to %d chains and ipsets",
+		held.Synced, held.Desired)
+	npc.syncQueue.add(syncFull)
+	return &held, nil
+}
@@ -0,0 +1,60 @@
+package netpol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShrinkGuard(t *testing.T) {
+	now := time.Now()
+	confirmations := 0
+	confirm := func() { confirmations++ }
+
+	var disabled *shrinkGuard
+	if disabled.hold(0, now, confirm) {
+		t.Fatalf("expected no cleanup to be held without --policy-shrink-threshold")
+	}
+
+	g := &shrinkGuard{threshold: 50, confirmPeriod: time.Hour}
+	if g.hold(100, now, confirm) || g.hold(60, now, confirm) {
+		t.Fatalf("expected no cleanup to be held for a shrink within the threshold")
+	}
+	// the desired state shrinks by more than half of the 60 chains and ipsets of the last cleanup
+	if !g.hold(20, now, confirm) || g.held == nil || g.held.Synced != 60 {
+		t.Fatalf("expected the cleanup to be held, got %+v", g.held)
+	}
+	if !g.hold(10, now.Add(time.Minute), confirm) || g.held.Desired != 10 {
+		t.Errorf("expected the cleanup to be held until the shrink is confirmed, got %+v", g.held)
+	}
+	if g.hold(10, now.Add(time.Hour), confirm) || g.held != nil || g.synced != 10 {
+		t.Errorf("expected a sync after the confirm period to confirm the shrink, got %+v", g.held)
+	}
+
+	// the desired state grows back before the confirmation
+	if !g.hold(0, now, confirm) || g.hold(10, now.Add(time.Minute), confirm) || g.held != nil {
+		t.Errorf("expected the held cleanup to be dropped as the desired state grew back, got %+v", g.held)
+	}
+
+	// only the operator confirms the shrinks
+	g = &shrinkGuard{threshold: 10, synced: 100}
+	if !g.hold(50, now, confirm) || !g.hold(50, now.Add(24*time.Hour), confirm) {
+		t.Fatalf("expected the cleanup to be held until the operator confirms it")
+	}
+	npc := NetworkPolicyController{shrinkGuard: g, syncQueue: newSyncQueue()}
+	held, err := npc.ConfirmCleanup()
+	if err != nil || held.Synced != 100 || held.Desired != 50 {
+		t.Fatalf("expected the held cleanup to be confirmed, got %+v, %v", held, err)
+	}
+	if kind, _ := npc.syncQueue.take(); kind != syncFull {
+		t.Errorf("expected the confirmation to request a full sync")
+	}
+	if g.hold(50, now, confirm) {
+		t.Errorf("expected the sync to remove the stale chains once confirmed")
+	}
+	if _, err := npc.ConfirmCleanup(); err == nil {
+		t.Errorf("expected an error confirming while no cleanup is held")
+	}
+	if confirmations != 0 {
+		t.Errorf("expected the confirmation syncs to be scheduled after the confirm period")
+	}
+}
@@ -0,0 +1,114 @@
+package netpol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+// With --policy-gc-period, the syncs no longer remove the stale pod firewall and network policy chains and ipsets,
+// which lists and deletes the rules referencing them one by one while the syncs hold the lock of the controller.
+// Each successful sync records the chains and ipsets it programmed instead, unless the shrink guard holds the cleanup,
+// and a separate loop removes the ones that are not in the last snapshot every period. The chains are versioned by
+// sync, so a stale chain never becomes active again and is deleted without the lock. The ipsets are not versioned, a
+// sync may program a stale one again, so they are destroyed with the lock held, once checked against the snapshot of
+// the last sync. Only the chains listed along with the snapshot are deleted, the chains of a sync run meanwhile are
+// not in the snapshot.
+
+// activeRules is the snapshot of the chains and ipsets a sync programmed
+type activeRules struct {
+	policyChains map[string]bool
+	podFwChains  map[string]bool
+	policyIPSets map[string]bool
+}
+
+// union returns the chains and ipsets of both the rules and the active ones given
+func (rules *activeRules) union(policyChains, podFwChains, policyIPSets map[string]bool) (map[string]bool,
+	map[string]bool, map[string]bool) {
+	merge := func(a, b map[string]bool) map[string]bool {
+		merged := make(map[string]bool, len(a)+len(b))
+		for name := range a {
+			merged[name] = true
+		}
+		for name := range b {
+			merged[name] = true
+		}
+		return merged
+	}
+	return merge(rules.policyChains, policyChains), merge(rules.podFwChains, podFwChains),
+		merge(rules.policyIPSets, policyIPSets)
+}
+
+// runStaleRulesGC removes the stale chains and ipsets every --policy-gc-period until stopCh is closed
+func (npc *NetworkPolicyController) runStaleRulesGC(stopCh <-chan struct{}) {
+	t := time.NewTicker(npc.policyGCPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+		if !npc.readyForUpdates {
+			continue
+		}
+		if err := npc.collectStaleRules(); err != nil {
+			glog.Errorf("Failed to cleanup the stale network policy chains and ipsets: %s", err)
+		}
+	}
+}
+
+// collectStaleRules removes the chains and ipsets that are not in the snapshot of the last sync
+func (npc *NetworkPolicyController) collectStaleRules() error {
+	start := time.Now()
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return fmt.Errorf("Failed to initialize iptables executor: %s", err.Error())
+	}
+	npc.mu.Lock()
+	active := npc.activeRules
+	var chains []string
+	if active != nil {
+		chains, err = iptablesCmdHandler.ListChains("filter")
+		if err == nil && (npc.MetricsEnabled || npc.policyHits != nil) {
+			if err := npc.exportStaleChainCounters(active.policyChains, active.podFwChains); err != nil {
+				glog.Errorf("Failed to export the counters of network policy chains: %s", err)
+			}
+		}
+	}
+	npc.mu.Unlock()
+	if active == nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to list chains in filter table: %s", err)
+	}
+	listedChains := make(map[string]bool, len(chains))
+	for _, chain := range chains {
+		listedChains[chain] = true
+	}
+
+	staleIPSets, referencedIPSets, err := npc.cleanupStaleChains(active.policyChains, active.podFwChains,
+		active.policyIPSets, listedChains)
+	if err != nil {
+		return err
+	}
+
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+	// the ipsets programmed again by the syncs run meanwhile are kept
+	stillStale := make([]*utils.Set, 0, len(staleIPSets))
+	for _, set := range staleIPSets {
+		if npc.activeRules != nil && !npc.activeRules.policyIPSets[set.Name] {
+			stillStale = append(stillStale, set)
+		}
+	}
+	if err := npc.destroyStaleIPSets(stillStale, referencedIPSets); err != nil {
+		return err
+	}
+	glog.V(1).Infof("Cleanup of the stale network policy chains and ipsets took %v", time.Since(start))
+	return nil
+}
@@ -0,0 +1,76 @@
+package netpol
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The rules of the last sync are kept when the caches of the network policies or of the pods empty while the API
+// server is unreachable, or while it still has some of them, so an empty cache is never taken for the deletion of all
+// the network policies or pods of the cluster.
+
+// emptyCacheGuards guards the rules of the node against the caches of the network policies and of the pods emptying
+type emptyCacheGuards struct {
+	policies *utils.EmptyCacheGuard
+	pods     *utils.EmptyCacheGuard
+	// network policies and pods of the last successful sync
+	syncedPolicies int
+	syncedPods     int
+}
+
+func newEmptyCacheGuards(clientset kubernetes.Interface, v1NetworkPolicy bool) *emptyCacheGuards {
+	guards := &emptyCacheGuards{
+		policies: &utils.EmptyCacheGuard{Resource: "networkpolicies"},
+		pods: &utils.EmptyCacheGuard{
+			Resource: "pods",
+			Count: func(limit int64) (int, error) {
+				pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{Limit: limit})
+				if err != nil {
+					return 0, err
+				}
+				return len(pods.Items), nil
+			},
+		},
+	}
+	if v1NetworkPolicy {
+		guards.policies.Count = func(limit int64) (int, error) {
+			policies, err := clientset.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(context.Background(),
+				metav1.ListOptions{Limit: limit})
+			if err != nil {
+				return 0, err
+			}
+			return len(policies.Items), nil
+		}
+	}
+	return guards
+}
+
+// checkEmptyCaches returns an error wrapping utils.ErrAPIUnavailable when the sync would remove the rules of all the
+// network policies or of all the pods of the last sync as their cache emptied, and they are not confirmed deleted
+func (npc *NetworkPolicyController) checkEmptyCaches() error {
+	if npc.emptyCacheGuards == nil {
+		return nil
+	}
+	guards := npc.emptyCacheGuards
+	if err := guards.policies.Check(len(*npc.networkPoliciesInfo), guards.syncedPolicies); err != nil {
+		return fmt.Errorf("Aborting sync. %w", err)
+	}
+	if err := guards.pods.Check(len(npc.podLister.List()), guards.syncedPods); err != nil {
+		return fmt.Errorf("Aborting sync. %w", err)
+	}
+	return nil
+}
+
+// recordSyncedCaches records the number of network policies and pods of the successful sync
+func (npc *NetworkPolicyController) recordSyncedCaches() {
+	if npc.emptyCacheGuards == nil {
+		return
+	}
+	npc.emptyCacheGuards.syncedPolicies = len(*npc.networkPoliciesInfo)
+	npc.emptyCacheGuards.syncedPods = len(npc.podLister.List())
+}
@@ -0,0 +1,40 @@
+package netpol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestEmptyCacheGuards(t *testing.T) {
+	podLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	podLister.Add(pod)
+	npc := &NetworkPolicyController{podLister: podLister, networkPoliciesInfo: &[]networkPolicyInfo{{name: "deny"}},
+		emptyCacheGuards: newEmptyCacheGuards(fake.NewSimpleClientset(pod), true)}
+	npc.recordSyncedCaches()
+
+	// the pod is still known to the API server while the cache emptied
+	podLister.Delete(pod)
+	if err := npc.checkEmptyCaches(); !errors.Is(err, utils.ErrAPIUnavailable) {
+		t.Errorf("expected the sync to be aborted as the cache of the pods emptied, got %v", err)
+	}
+
+	npc.emptyCacheGuards.pods = &utils.EmptyCacheGuard{Resource: "pods",
+		Count: func(limit int64) (int, error) { return 0, nil }}
+	if err := npc.checkEmptyCaches(); err != nil {
+		t.Errorf("expected the sync to go on once the API server confirmed the pods were deleted: %s", err)
+	}
+
+	// no network policy is left in the API server
+	npc.networkPoliciesInfo = &[]networkPolicyInfo{}
+	if err := npc.checkEmptyCaches(); err != nil {
+		t.Errorf("expected the sync to go on as the API server has no network policy: %s", err)
+	}
+}
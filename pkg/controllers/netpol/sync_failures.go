@@ -0,0 +1,87 @@
+package netpol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+)
+
+// A network policy failing to sync does not abort the sync of the others. The policy is given up for the sync: its
+// chain is programmed without rules, so its pods are denied the traffic it allows, and the other policies are
+// enforced. iptables-restore rejecting a rule of a policy chain is handled the same way, the input is applied again
+// without the rules of the chain. The sync then returns an error summarizing the failed policies, and the sync queue
+// retries it with its backoff.
+
+// policyFailures are the network policies that failed to sync, by namespace/name
+type policyFailures struct {
+	failed map[string]error
+}
+
+func newPolicyFailures() *policyFailures {
+	return &policyFailures{failed: make(map[string]error)}
+}
+
+func (f *policyFailures) add(policy networkPolicyInfo, err error) {
+	glog.Errorf("Failed to sync network policy %s/%s, its pods are denied the traffic it allows: %s",
+		policy.namespace, policy.name, err)
+	f.failed[policy.namespace+"/"+policy.name] = err
+}
+
+func (f *policyFailures) has(policy networkPolicyInfo) bool {
+	_, ok := f.failed[policy.namespace+"/"+policy.name]
+	return ok
+}
+
+// err returns the error summarizing the failed policies, nil if none failed
+func (f *policyFailures) err() error {
+	if len(f.failed) == 0 {
+		return nil
+	}
+	policies := make([]string, 0, len(f.failed))
+	for policy := range f.failed {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+	errs := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		errs = append(errs, policy+": "+f.failed[policy].Error())
+	}
+	return fmt.Errorf("Failed to sync %d network policies, the others are enforced: %s", len(policies),
+		strings.Join(errs, "; "))
+}
+
+// applyPolicyFilterTable applies filterTable, and when iptables-restore rejects a rule of a network policy chain,
+// records the failure of the policy and applies filterTable again without the rules of its chain
+func (npc *NetworkPolicyController) applyPolicyFilterTable(filterTable *utils.IPTablesRestore, version string,
+	failures *policyFailures) error {
+	for {
+		err := npc.applyFilterTable(filterTable)
+		if err == nil {
+			return nil
+		}
+		policy, ok := npc.policyOfChain(filterTable.FailedChain(err), version)
+		if !ok || !filterTable.FlushChain(networkPolicyChainName(policy.namespace, policy.name, version)) {
+			return err
+		}
+		if npc.policyChainRules != nil {
+			delete(npc.policyChainRules, networkPolicyChainName(policy.namespace, policy.name, version))
+		}
+		failures.add(policy, err)
+	}
+}
+
+// policyOfChain returns the network policy of the chain of the sync version
+func (npc *NetworkPolicyController) policyOfChain(chain, version string) (networkPolicyInfo, bool) {
+	if !strings.HasPrefix(chain, kubeNetworkPolicyChainPrefix) {
+		return networkPolicyInfo{}, false
+	}
+	for _, policy := range *npc.networkPoliciesInfo {
+		if networkPolicyChainName(policy.namespace, policy.name, version) == chain {
+			return policy, true
+		}
+	}
+	return networkPolicyInfo{}, false
+}
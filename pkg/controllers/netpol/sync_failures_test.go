@@ -0,0 +1,33 @@
+package netpol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicySyncFailures(t *testing.T) {
+	policies := []networkPolicyInfo{{namespace: "default", name: "web"}, {namespace: "default", name: "db"}}
+	npc := &NetworkPolicyController{networkPoliciesInfo: &policies}
+	failures := newPolicyFailures()
+	if failures.err() != nil {
+		t.Errorf("expected no error without failed policies")
+	}
+	chain := networkPolicyChainName("default", "db", "1")
+	policy, ok := npc.policyOfChain(chain, "1")
+	if !ok || policy.name != "db" {
+		t.Fatalf("expected policy default/db for chain %s, got %+v", chain, policy)
+	}
+	if _, ok := npc.policyOfChain(networkPolicyChainName("default", "db", "2"), "1"); ok {
+		t.Errorf("expected no policy for the chain of another sync")
+	}
+	failures.add(policy, errors.New("iptables-restore: line 5 failed"))
+	if !failures.has(policy) || failures.has(policies[0]) {
+		t.Errorf("expected only default/db to have failed")
+	}
+	failures.add(policies[0], errors.New("failed to create ipset"))
+	expected := "Failed to sync 2 network policies, the others are enforced: default/db: iptables-restore: " +
+		"line 5 failed; default/web: failed to create ipset"
+	if err := failures.err(); err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
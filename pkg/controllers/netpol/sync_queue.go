@@ -0,0 +1,120 @@
+package netpol
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+)
+
+// syncKind is the sync an event needs, a full sync covers the pod syncs
+type syncKind int
+
+const (
+	syncNone syncKind = iota
+	// refresh the ipsets when the rules are unchanged, see syncPodEvent
+	syncPods
+	syncFull
+)
+
+// syncQueue coalesces the syncs requested by the events into a single pending sync, so a burst of events, e.g. the
+// pods of a rollout, triggers one sync rather than one per event
+type syncQueue struct {
+	mu      sync.Mutex
+	pending syncKind
+	// events coalesced into the pending sync
+	events int
+	// signaled when a sync becomes pending
+	ready chan struct{}
+}
+
+func newSyncQueue() *syncQueue {
+	return &syncQueue{ready: make(chan struct{}, 1)}
+}
+
+// add requests a sync of the kind, merged with the pending one
+func (q *syncQueue) add(kind syncKind) {
+	q.mu.Lock()
+	if kind > q.pending {
+		q.pending = kind
+	}
+	q.events++
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending sync and the number of events it coalesces, and clears it
+func (q *syncQueue) take() (syncKind, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	kind, events := q.pending, q.events
+	q.pending, q.events = syncNone, 0
+	return kind, events
+}
+
+// runSyncQueue runs the syncs requested by the events until stopCh is closed, at most one per minimum sync period.
+// A failed sync is retried with an exponential backoff, up to the period of the periodic syncs.
+func (npc *NetworkPolicyController) runSyncQueue(stopCh <-chan struct{}) {
+	var last time.Time
+	wait := npc.minSyncPeriod
+	failures := 0
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-npc.syncQueue.ready:
+		}
+		if delay := wait - time.Since(last); delay > 0 {
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		kind, events := npc.syncQueue.take()
+		if kind == syncNone {
+			continue
+		}
+		last = time.Now()
+		glog.V(2).Infof("Syncing network policies for %d events", events)
+		var err error
+		release := npc.Coordinator.Acquire("NPC", utils.SyncEvent)
+		if kind == syncPods {
+			err = npc.syncPodEvent()
+		} else {
+			err = npc.Sync()
+		}
+		release()
+		if err == nil {
+			failures = 0
+			wait = npc.minSyncPeriod
+			continue
+		}
+
+		failures++
+		wait = syncRetryDelay(npc.minSyncPeriod, npc.syncPeriod, failures)
+		glog.Errorf("Error syncing network policies for %d events, retrying in %s: %s", events, wait, err)
+		npc.syncQueue.add(kind)
+	}
+}
+
+// syncRetryDelay returns the delay before the retry following the given number of consecutive failures, doubling
+// from the minimum sync period, or a second, up to the period of the periodic syncs
+func syncRetryDelay(minSyncPeriod, syncPeriod time.Duration, failures int) time.Duration {
+	delay := minSyncPeriod
+	if delay < time.Second {
+		delay = time.Second
+	}
+	for i := 1; i < failures && delay < syncPeriod; i++ {
+		delay *= 2
+	}
+	if syncPeriod > 0 && delay > syncPeriod {
+		delay = syncPeriod
+	}
+	return delay
+}
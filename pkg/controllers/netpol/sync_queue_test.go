@@ -0,0 +1,46 @@
+package netpol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncQueue(t *testing.T) {
+	q := newSyncQueue()
+	if kind, events := q.take(); kind != syncNone || events != 0 {
+		t.Errorf("expected no pending sync, got %d for %d events", kind, events)
+	}
+
+	// a full sync covers the pod syncs, whatever their order
+	q.add(syncPods)
+	q.add(syncFull)
+	q.add(syncPods)
+	select {
+	case <-q.ready:
+	default:
+		t.Fatalf("expected the queue to signal the pending sync")
+	}
+	if kind, events := q.take(); kind != syncFull || events != 3 {
+		t.Errorf("expected a full sync for 3 events, got %d for %d events", kind, events)
+	}
+	q.add(syncPods)
+	if kind, events := q.take(); kind != syncPods || events != 1 {
+		t.Errorf("expected a pod sync for 1 event, got %d for %d events", kind, events)
+	}
+
+	for _, test := range []struct {
+		minSyncPeriod time.Duration
+		failures      int
+		expected      time.Duration
+	}{
+		{0, 1, time.Second},
+		{2 * time.Second, 1, 2 * time.Second},
+		{2 * time.Second, 3, 8 * time.Second},
+		{2 * time.Second, 20, 5 * time.Minute},
+	} {
+		if delay := syncRetryDelay(test.minSyncPeriod, 5*time.Minute, test.failures); delay != test.expected {
+			t.Errorf("expected a retry delay of %s after %d failures from %s, got %s", test.expected, test.failures,
+				test.minSyncPeriod, delay)
+		}
+	}
+}
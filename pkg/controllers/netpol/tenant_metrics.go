@@ -0,0 +1,88 @@
+package netpol
+
+import (
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// chainOwner is the network policy or pod a chain was created for
+type chainOwner struct {
+	namespace string
+	name      string
+}
+
+// exportStaleChainCounters adds the counters of the policy and pod firewall chains replaced by the current
+// sync to the dataplane metrics, and to the hits of the policies when tracked. Chains are versioned and replaced on
+// every sync, so the traffic they accounted for is exported once they are about to be deleted.
+func (npc *NetworkPolicyController) exportStaleChainCounters(activePolicyChains, activePodFwChains map[string]bool) error {
+	rules, err := nodestate.ReadIPTablesSaveWithCounters("filter")
+	if err != nil {
+		return err
+	}
+	if npc.MetricsEnabled {
+		exportChainCounters(rules, npc.policyChainOwners, npc.podFwChainOwners, activePolicyChains, activePodFwChains,
+			npc.tenantLabels)
+	}
+	npc.retireChainCounters(rules, activePolicyChains, activePodFwChains)
+	if npc.policyHits != nil {
+		if err := npc.trackPolicyHits(rules, activePolicyChains); err != nil {
+			return err
+		}
+	}
+
+	for chain := range npc.policyChainOwners {
+		if !activePolicyChains[chain] {
+			delete(npc.policyChainOwners, chain)
+		}
+	}
+	for chain := range npc.podFwChainOwners {
+		if !activePodFwChains[chain] {
+			delete(npc.podFwChainOwners, chain)
+		}
+	}
+	return nil
+}
+
+func exportChainCounters(rules []utils.IPTablesSaveRule, policyChainOwners, podFwChainOwners map[string]chainOwner,
+	activePolicyChains, activePodFwChains map[string]bool, tenantLabels *metrics.TenantLabels) {
+	for _, rule := range rules {
+		if owner, ok := policyChainOwners[rule.Chain]; ok && !activePolicyChains[rule.Chain] && rule.Target == "ACCEPT" {
+			namespace, policy := tenantLabels.Values(owner.namespace, owner.name)
+			metrics.ControllerPolicyAcceptedPackets.WithLabelValues(namespace, policy).Add(float64(rule.Packets))
+			metrics.ControllerPolicyAcceptedBytes.WithLabelValues(namespace, policy).Add(float64(rule.Bytes))
+		}
+		if owner, ok := podFwChainOwners[rule.Chain]; ok && !activePodFwChains[rule.Chain] &&
+			(rule.Target == "REJECT" || rule.Target == "DROP") {
+			namespace, _ := tenantLabels.Values(owner.namespace, "")
+			metrics.ControllerPolicyRejectedPackets.WithLabelValues(namespace).Add(float64(rule.Packets))
+		}
+		if owner, ok := podFwChainOwners[rule.Chain]; ok && !activePodFwChains[rule.Chain] && rule.Target == "ACCEPT" &&
+			strings.HasPrefix(rule.Comment, auditAcceptComment) {
+			namespace, _ := tenantLabels.Values(owner.namespace, "")
+			metrics.ControllerPolicyAuditedPackets.WithLabelValues(namespace).Add(float64(rule.Packets))
+		}
+	}
+}
+
+// exportPolicyRuleCounts sets the number of rules of the policy chains of each namespace, so the namespaces whose
+// policies expand into many rules, e.g. with hundreds of peers or ports, stand out
+func exportPolicyRuleCounts(policyChainRules map[string]int, policyChainOwners map[string]chainOwner,
+	tenantLabels *metrics.TenantLabels) {
+	counts := make(map[string]int)
+	for chain, rules := range policyChainRules {
+		owner, ok := policyChainOwners[chain]
+		if !ok {
+			continue
+		}
+		namespace, _ := tenantLabels.Values(owner.namespace, "")
+		counts[namespace] += rules
+	}
+	// the namespaces whose policies were deleted are removed
+	metrics.ControllerPolicyRules.Reset()
+	for namespace, rules := range counts {
+		metrics.ControllerPolicyRules.WithLabelValues(namespace).Set(float64(rules))
+	}
+}
@@ -0,0 +1,90 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestExportChainCounters(t *testing.T) {
+	counterValue := func(c interface {
+		Write(*dto.Metric) error
+	}) float64 {
+		m := &dto.Metric{}
+		if err := c.Write(m); err != nil {
+			t.Fatalf("unexpected error reading counter: %v", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	policyChainOwners := map[string]chainOwner{
+		"KUBE-NWPLCY-OLDA": {namespace: "tenant-a", name: "allow-web"},
+		"KUBE-NWPLCY-OLDB": {namespace: "tenant-b", name: "allow-web"},
+		"KUBE-NWPLCY-NEW":  {namespace: "tenant-a", name: "allow-web"},
+	}
+	podFwChainOwners := map[string]chainOwner{
+		"KUBE-POD-FW-OLD": {namespace: "tenant-a", name: "web"},
+	}
+	rules := []utils.IPTablesSaveRule{
+		{Chain: "KUBE-NWPLCY-OLDA", Target: "ACCEPT", Packets: 10, Bytes: 1000},
+		{Chain: "KUBE-NWPLCY-OLDA", Target: "ACCEPT", Packets: 5, Bytes: 500},
+		{Chain: "KUBE-NWPLCY-OLDB", Target: "ACCEPT", Packets: 7, Bytes: 700},
+		{Chain: "KUBE-NWPLCY-NEW", Target: "ACCEPT", Packets: 3, Bytes: 300},
+		{Chain: "KUBE-POD-FW-OLD", Target: "NFLOG", Packets: 2, Bytes: 120},
+		{Chain: "KUBE-POD-FW-OLD", Target: "REJECT", Packets: 2, Bytes: 120},
+	}
+	active := map[string]bool{"KUBE-NWPLCY-NEW": true}
+
+	tenantLabels := metrics.NewTenantLabels(true, []string{"tenant-a"}, 10)
+	acceptedA := metrics.ControllerPolicyAcceptedPackets.WithLabelValues("tenant-a", "allow-web")
+	acceptedOther := metrics.ControllerPolicyAcceptedBytes.WithLabelValues(metrics.TenantLabelOther, metrics.TenantLabelOther)
+	rejectedA := metrics.ControllerPolicyRejectedPackets.WithLabelValues("tenant-a")
+	before := [3]float64{counterValue(acceptedA), counterValue(acceptedOther), counterValue(rejectedA)}
+
+	exportChainCounters(rules, policyChainOwners, podFwChainOwners, active, map[string]bool{}, tenantLabels)
+
+	if got := counterValue(acceptedA) - before[0]; got != 15 {
+		t.Errorf("expected 15 packets accepted by the stale chain of tenant-a/allow-web but got %v", got)
+	}
+	if got := counterValue(acceptedOther) - before[1]; got != 700 {
+		t.Errorf("expected 700 bytes accounted to the namespace not in the allowlist but got %v", got)
+	}
+	if got := counterValue(rejectedA) - before[2]; got != 2 {
+		t.Errorf("expected 2 packets rejected in tenant-a but got %v", got)
+	}
+}
+
+func TestExportPolicyRuleCounts(t *testing.T) {
+	gaugeValue := func(namespace string) float64 {
+		m := &dto.Metric{}
+		if err := metrics.ControllerPolicyRules.WithLabelValues(namespace).Write(m); err != nil {
+			t.Fatalf("unexpected error reading gauge: %v", err)
+		}
+		return m.GetGauge().GetValue()
+	}
+	policyChainOwners := map[string]chainOwner{
+		"KUBE-NWPLCY-A1": {namespace: "tenant-a", name: "allow-web"},
+		"KUBE-NWPLCY-A2": {namespace: "tenant-a", name: "allow-db"},
+		"KUBE-NWPLCY-B":  {namespace: "tenant-b", name: "allow-web"},
+		"KUBE-NWPLCY-C":  {namespace: "tenant-c", name: "allow-web"},
+	}
+	policyChainRules := map[string]int{"KUBE-NWPLCY-A1": 300, "KUBE-NWPLCY-A2": 2, "KUBE-NWPLCY-B": 4,
+		"KUBE-NWPLCY-C": 1, "KUBE-NWPLCY-UNKNOWN": 7}
+	tenantLabels := metrics.NewTenantLabels(true, []string{"tenant-a", "tenant-b"}, 10)
+
+	exportPolicyRuleCounts(policyChainRules, policyChainOwners, tenantLabels)
+	if got := gaugeValue("tenant-a"); got != 302 {
+		t.Errorf("expected 302 rules in tenant-a, got %v", got)
+	}
+	if got := gaugeValue(metrics.TenantLabelOther); got != 1 {
+		t.Errorf("expected 1 rule of the namespaces not in the allowlist, got %v", got)
+	}
+
+	delete(policyChainRules, "KUBE-NWPLCY-B")
+	exportPolicyRuleCounts(policyChainRules, policyChainOwners, tenantLabels)
+	if got := gaugeValue("tenant-b"); got != 0 {
+		t.Errorf("expected the namespace without policy chains to be reset, got %v", got)
+	}
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"errors"
+	"strconv"
+	"syscall"
+
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/docker/libnetwork/ipvs"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// RenderDesiredState renders the IPVS services and destinations the controller would program for the
+// current services and endpoints, without modifying the node.
+func (nsc *NetworkServicesController) RenderDesiredState() (nodestate.State, error) {
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+
+	nodeIPs, err := nsc.getNodePortIPs()
+	if err != nil {
+		return nil, err
+	}
+	return renderIpvsState(nsc.buildServicesInfo(), nsc.buildEndpointsInfo(), nodeIPs), nil
+}
+
+// getNodePortIPs returns the addresses node port services are bound to
+func (nsc *NetworkServicesController) getNodePortIPs() ([]string, error) {
+	if !nsc.nodeportBindOnAllIp {
+		return []string{nsc.nodeIP.String()}, nil
+	}
+	addrs, err := nsc.getNodePortAddrs()
+	if err != nil {
+		return nil, errors.New("Failed to get list of system addresses: " + err.Error())
+	}
+	nodeIPs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		nodeIPs = append(nodeIPs, addr.IP.String())
+	}
+	return nodeIPs, nil
+}
+
+func renderIpvsState(serviceInfoMap serviceInfoMap, endpointsInfoMap endpointsInfoMap, nodeIPs []string) nodestate.State {
+	state := make(nodestate.State)
+
+	addService := func(service string, endpoints []endpointsInfo, include func(endpointsInfo) bool) {
+		state.Add(nodestate.IPVS, service)
+		for _, endpoint := range endpoints {
+			if include(endpoint) {
+				state.Add(nodestate.IPVS, nodestate.IPVSDestination(service, endpoint.ip, endpoint.port))
+			}
+		}
+	}
+
+	for k, svc := range serviceInfoMap {
+		endpoints := endpointsInfoMap[k]
+		hasLocalEndpoints := hasActiveEndpoints(svc, endpoints)
+
+		// cluster IP services use the local endpoints for local services if there are any
+		addService(nodestate.IPVSService(svc.protocol, svc.clusterIP.String(), svc.port), endpoints,
+			func(endpoint endpointsInfo) bool {
+				return !svc.local || !hasLocalEndpoints || endpoint.isLocal
+			})
+
+		// node port and external IP services of local services only exist when there are local endpoints
+		if svc.local && !hasLocalEndpoints {
+			continue
+		}
+		includeEndpoint := func(endpoint endpointsInfo) bool {
+			return !svc.local || endpoint.isLocal
+		}
+
+		if svc.nodePort != 0 {
+			for _, nodeIP := range nodeIPs {
+				addService(nodestate.IPVSService(svc.protocol, nodeIP, svc.nodePort), endpoints, includeEndpoint)
+			}
+		}
+
+		extIPSet := sets.NewString(svc.externalIPs...)
+		if !svc.skipLbIps {
+			extIPSet = extIPSet.Union(sets.NewString(svc.loadBalancerIPs...))
+		}
+		for _, externalIP := range extIPSet.List() {
+			if svc.directServerReturn && svc.directServerReturnMethod == "tunnel" {
+				fwMark := generateFwmark(externalIP, svc.protocol, strconv.Itoa(svc.port))
+				addService(nodestate.IPVSFWMarkService(fwMark), endpoints, includeEndpoint)
+			} else {
+				addService(nodestate.IPVSService(svc.protocol, externalIP, svc.port), endpoints, includeEndpoint)
+			}
+		}
+	}
+
+	return state
+}
+
+// ReadActualState reads the IPVS services and destinations found on the node, rendered the same way as
+// RenderDesiredState.
+func ReadActualState() (nodestate.State, error) {
+	state := make(nodestate.State)
+
+	handle, err := ipvs.New("")
+	if err != nil {
+		return nil, errors.New("Failed to initialize ipvs handle: " + err.Error())
+	}
+	defer handle.Close()
+
+	ipvsSvcs, err := handle.GetServices()
+	if err != nil {
+		return nil, errors.New("Failed to list IPVS services: " + err.Error())
+	}
+	for _, ipvsSvc := range ipvsSvcs {
+		var service string
+		if ipvsSvc.FWMark != 0 {
+			service = nodestate.IPVSFWMarkService(ipvsSvc.FWMark)
+		} else {
+			protocol := "unknown"
+			switch ipvsSvc.Protocol {
+			case syscall.IPPROTO_TCP:
+				protocol = "tcp"
+			case syscall.IPPROTO_UDP:
+				protocol = "udp"
+			}
+			service = nodestate.IPVSService(protocol, ipvsSvc.Address.String(), int(ipvsSvc.Port))
+		}
+		state.Add(nodestate.IPVS, service)
+
+		dsts, err := handle.GetDestinations(ipvsSvc)
+		if err != nil {
+			return nil, errors.New("Failed to list destinations of IPVS service " + service + ": " + err.Error())
+		}
+		for _, dst := range dsts {
+			state.Add(nodestate.IPVS, nodestate.IPVSDestination(service, dst.Address.String(), int(dst.Port)))
+		}
+	}
+
+	return state, nil
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+)
+
+func Test_renderIpvsState(t *testing.T) {
+	serviceInfoMap := serviceInfoMap{
+		"default-web-http": &serviceInfo{
+			name: "web", namespace: "default", clusterIP: net.ParseIP("10.96.0.10"),
+			port: 80, protocol: "tcp", nodePort: 30080, externalIPs: []string{"1.1.1.1"},
+		},
+		"default-local-http": &serviceInfo{
+			name: "local", namespace: "default", clusterIP: net.ParseIP("10.96.0.20"),
+			port: 80, protocol: "tcp", nodePort: 30081, local: true,
+		},
+	}
+	endpointsInfoMap := endpointsInfoMap{
+		"default-web-http": {
+			{ip: "172.20.1.5", port: 8080, isLocal: true},
+			{ip: "172.20.2.5", port: 8080},
+		},
+		"default-local-http": {
+			{ip: "172.20.2.6", port: 8080},
+		},
+	}
+
+	expected := []string{
+		"tcp 1.1.1.1:80",
+		"tcp 1.1.1.1:80 -> 172.20.1.5:8080",
+		"tcp 1.1.1.1:80 -> 172.20.2.5:8080",
+		"tcp 10.0.0.1:30080",
+		"tcp 10.0.0.1:30080 -> 172.20.1.5:8080",
+		"tcp 10.0.0.1:30080 -> 172.20.2.5:8080",
+		"tcp 10.96.0.10:80",
+		"tcp 10.96.0.10:80 -> 172.20.1.5:8080",
+		"tcp 10.96.0.10:80 -> 172.20.2.5:8080",
+		"tcp 10.96.0.20:80",
+		"tcp 10.96.0.20:80 -> 172.20.2.6:8080",
+	}
+
+	state := renderIpvsState(serviceInfoMap, endpointsInfoMap, []string{"10.0.0.1"})
+	if lines := state.Lines(nodestate.IPVS); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected IPVS state %v but got %v", expected, lines)
+	}
+}
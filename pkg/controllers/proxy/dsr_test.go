@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_mangleTableRuleArgs(t *testing.T) {
+	args, legacyArgs, err := mangleTableRuleArgs("1.1.1.1", "tcp", "80", "4660")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// only the DSR bits of the mark are set, with the default allocation of the bits
+	if got := strings.Join(args, " "); got != "-d 1.1.1.1 -m tcp -p tcp --dport 80 -j MARK --set-xmark 0x1234/0x1fff" {
+		t.Errorf("unexpected rule %q", got)
+	}
+	// the previous versions set 14 bits of the hash on the whole mark
+	legacy := fmt.Sprint(legacyFwmark("1.1.1.1", "tcp", "80"))
+	if got := strings.Join(legacyArgs, " "); got != "-d 1.1.1.1 -m tcp -p tcp --dport 80 -j MARK --set-mark "+legacy {
+		t.Errorf("unexpected legacy rule %q", got)
+	}
+	if _, _, err := mangleTableRuleArgs("1.1.1.1", "tcp", "80", "mark"); err == nil {
+		t.Errorf("expected an invalid fwmark to be rejected")
+	}
+}
+
+func Test_legacyDSRRuleFwmarks(t *testing.T) {
+	rules := `0:	from all lookup local
+32764:	from all fwmark 0x1234/0x1fff lookup kube-router-dsr
+32764:	from all fwmark 0x2345 lookup kube-router-dsr
+32764:	from all fwmark 0x3456 lookup 78
+32765:	from 10.1.0.0/24 lookup kube-router
+32766:	from all fwmark 0x4567 lookup main
+32766:	from all lookup main
+`
+	if got, expected := legacyDSRRuleFwmarks(rules), []string{"0x2345", "0x3456"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the legacy fwmarks %v, got %v", expected, got)
+	}
+}
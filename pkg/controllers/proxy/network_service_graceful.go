@@ -2,13 +2,13 @@ package proxy
 
 import (
 	"fmt"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/docker/libnetwork/ipvs"
 	"github.com/golang/glog"
 )
@@ -151,9 +151,9 @@ func (nsc *NetworkServicesController) flushConntrackUDP(svc *ipvs.Service) error
 	re := regexp.MustCompile("([[:space:]]0 flow entries have been deleted.)")
 
 	// Shell out and flush conntrack records
-	out, err := exec.Command("conntrack", "-D", "--orig-dst", svc.Address.String(), "-p", "udp", "--dport", strconv.Itoa(int(svc.Port))).CombinedOutput()
+	_, err := utils.Exec("conntrack", "-D", "--orig-dst", svc.Address.String(), "-p", "udp", "--dport", strconv.Itoa(int(svc.Port)))
 	if err != nil {
-		if matched := re.MatchString(string(out)); !matched {
+		if matched := re.MatchString(err.Error()); !matched {
 			return fmt.Errorf("Failed to delete conntrack entry for endpoint: %s:%d due to %s", svc.Address.String(), svc.Port, err.Error())
 		}
 	}
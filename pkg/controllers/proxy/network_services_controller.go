@@ -8,7 +8,6 @@ import (
 	"math/rand"
 	"net"
 	"os"
-	"os/exec"
 	"reflect"
 	"runtime"
 	"strconv"
@@ -19,6 +18,7 @@ import (
 
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/coreos/go-iptables/iptables"
@@ -111,10 +111,10 @@ func (ln *linuxNetworking) ipAddrDel(iface netlink.Link, ip string) error {
 	}
 	// Delete VIP addition to "local" rt table also, fail silently if not found (DSR special case)
 	if err == nil {
-		out, err := exec.Command("ip", "route", "delete", "local", ip, "dev", KUBE_DUMMY_IF, "table", "local", "proto", "kernel", "scope", "host", "src",
-			NodeIP.String(), "table", "local").CombinedOutput()
-		if err != nil && !strings.Contains(string(out), "No such process") {
-			glog.Errorf("Failed to delete route to service VIP %s configured on %s. Error: %v, Output: %s", ip, KUBE_DUMMY_IF, err, out)
+		_, err := utils.Exec("ip", "route", "delete", "local", ip, "dev", KUBE_DUMMY_IF, "table", "local", "proto", "kernel", "scope", "host", "src",
+			NodeIP.String(), "table", "local")
+		if err != nil && !strings.Contains(err.Error(), "No such process") {
+			glog.Errorf("Failed to delete route to service VIP %s configured on %s. Error: %v", ip, KUBE_DUMMY_IF, err)
 		}
 	}
 	return err
@@ -143,10 +143,10 @@ func (ln *linuxNetworking) ipAddrAdd(iface netlink.Link, ip string, addRoute boo
 
 	// TODO: netlink.RouteReplace which is replacement for below command is not working as expected. Call succeeds but
 	// route is not replaced. For now do it with command.
-	out, err := exec.Command("ip", "route", "replace", "local", ip, "dev", KUBE_DUMMY_IF, "table", "local", "proto", "kernel", "scope", "host", "src",
-		NodeIP.String(), "table", "local").CombinedOutput()
+	_, err = utils.Exec("ip", "route", "replace", "local", ip, "dev", KUBE_DUMMY_IF, "table", "local", "proto", "kernel", "scope", "host", "src",
+		NodeIP.String(), "table", "local")
 	if err != nil {
-		glog.Errorf("Failed to replace route to service VIP %s configured on %s. Error: %v, Output: %s", ip, KUBE_DUMMY_IF, err, out)
+		glog.Errorf("Failed to replace route to service VIP %s configured on %s. Error: %v", ip, KUBE_DUMMY_IF, err)
 	}
 	return nil
 }
@@ -202,29 +202,48 @@ func newLinuxNetworking() (*linuxNetworking, error) {
 
 // NetworkServicesController struct stores information needed by the controller
 type NetworkServicesController struct {
-	nodeIP              net.IP
-	nodeHostName        string
-	syncPeriod          time.Duration
-	mu                  sync.Mutex
-	serviceMap          serviceInfoMap
-	endpointsMap        endpointsInfoMap
-	podCidr             string
-	excludedCidrs       []net.IPNet
-	masqueradeAll       bool
-	globalHairpin       bool
-	ipvsPermitAll       bool
+	nodeIP           net.IP
+	nodeHostName     string
+	syncPeriod       time.Duration
+	mu               sync.Mutex
+	serviceMap       serviceInfoMap
+	endpointsMap     endpointsInfoMap
+	podCidr          string
+	excludedCidrs    []net.IPNet
+	masqueradeAll    bool
+	globalHairpin    bool
+	ipvsPermitAll    bool
+	nodePortRange    nodePortRange
+	nodePortFirewall string
+	// node ports of the services outside of nodePortRange, already logged
+	nodePortsOutOfRange map[string]bool
+	// keep the IPVS services of the last sync when the caches empty, nil in the tests
+	emptyCacheGuards    *emptyCacheGuards
 	client              kubernetes.Interface
 	nodeportBindOnAllIp bool
 	MetricsEnabled      bool
 	ln                  LinuxNetworking
 	readyForUpdates     bool
+	postSyncHook        *utils.PostSyncHook
+	appliedStateCache   *nodestate.AppliedStateCache
 
 	// Map of ipsets that we use.
 	ipsetMap map[string]*utils.Set
 
+	// stretches the periodic sync period while the node is overloaded, nil if disabled
+	Governor *utils.LoadGovernor
+	// runs the syncs of the controllers one at a time and staggers their periodic syncs, nil if disabled
+	Coordinator *utils.SyncCoordinator
+
+	// IPv6 addresses node port services are bound to along with the IPv4 ones with nodeportBindOnAllIp
+	nodePortIPv6Addresses string
+	nodePortIPv6CIDRs     []*net.IPNet
+
 	svcLister cache.Indexer
 	epLister  cache.Indexer
 	podLister cache.Indexer
+	// syncs only read the informer caches, and are skipped until the caches completed their initial listing
+	cachesSynced []cache.InformerSynced
 
 	ServiceEventHandler   cache.ResourceEventHandler
 	EndpointsEventHandler cache.ResourceEventHandler
@@ -279,7 +298,7 @@ type endpointsInfoMap map[string][]endpointsInfo
 
 // Run periodically sync ipvs configuration to reflect desired state of services and endpoints
 func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.ControllerHeartbeat, stopCh <-chan struct{}, wg *sync.WaitGroup) {
-	t := time.NewTicker(nsc.syncPeriod)
+	t := nsc.Coordinator.NewTicker("NSC", nsc.syncPeriod)
 	defer t.Stop()
 	defer wg.Done()
 	defer close(nsc.syncChan)
@@ -346,6 +365,14 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 		glog.Error("Error setting up ipvs firewall: " + err.Error())
 	}
 
+	// After the ipvs firewall, so the rule is evaluated ahead of the jump to its chain
+	err = nsc.setupNodePortFirewall()
+	if err != nil {
+		glog.Error("Error setting up node port firewall: " + err.Error())
+	}
+
+	nsc.appliedStateCache.ReportDrift(ReadActualState)
+
 	gracefulTicker := time.NewTicker(5 * time.Second)
 	defer gracefulTicker.Stop()
 
@@ -354,13 +381,17 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 		glog.Info("Shutting down network services controller")
 		return
 	default:
+		release := nsc.Coordinator.Acquire("NSC", utils.SyncPeriodic)
 		err := nsc.doSync()
+		release()
 		if err != nil {
 			glog.Fatalf("Failed to perform initial full sync %s", err.Error())
 		}
 		nsc.readyForUpdates = true
 	}
 
+	// ticks of the periodic sync skipped while the node is overloaded
+	periodicTicks := 0
 	// loop forever until notified to stop on stopCh
 	for {
 		select {
@@ -376,12 +407,14 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 
 		case perform := <-nsc.syncChan:
 			healthcheck.SendHeartBeat(healthChan, "NSC")
+			release := nsc.Coordinator.Acquire("NSC", utils.SyncEvent)
 			switch perform {
 			case synctypeAll:
 				glog.V(1).Info("Performing requested full sync of services")
 				err := nsc.doSync()
 				if err != nil {
 					glog.Errorf("Error during full sync in network service controller. Error: " + err.Error())
+					healthcheck.SendError(healthChan, "NSC", utils.CountError("NSC", err), err)
 				}
 			case synctypeIpvs:
 				glog.V(1).Info("Performing requested sync of ipvs services")
@@ -390,19 +423,27 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 				nsc.mu.Unlock()
 				if err != nil {
 					glog.Errorf("Error during ipvs sync in network service controller. Error: " + err.Error())
+					healthcheck.SendError(healthChan, "NSC", utils.CountError("NSC", err), err)
 				}
 			}
+			release()
 			if err == nil {
 				healthcheck.SendHeartBeat(healthChan, "NSC")
 			}
 
 		case <-t.C:
+			if !nsc.Governor.Due(&periodicTicks) {
+				continue
+			}
 			glog.V(1).Info("Performing periodic sync of ipvs services")
 			healthcheck.SendHeartBeat(healthChan, "NSC")
+			release := nsc.Coordinator.Acquire("NSC", utils.SyncPeriodic)
 			err := nsc.doSync()
+			release()
 			if err != nil {
 				glog.Errorf("Error during periodic ipvs sync in network service controller. Error: " + err.Error())
 				glog.Errorf("Skipping sending heartbeat from network service controller as periodic sync failed.")
+				healthcheck.SendError(healthChan, "NSC", utils.CountError("NSC", err), err)
 			} else {
 				healthcheck.SendHeartBeat(healthChan, "NSC")
 			}
@@ -422,6 +463,11 @@ func (nsc *NetworkServicesController) doSync() error {
 	var err error
 	nsc.mu.Lock()
 	defer nsc.mu.Unlock()
+	start := time.Now()
+
+	if !utils.CachesSynced(nsc.cachesSynced...) {
+		return errors.New("informer caches are not synced yet")
+	}
 
 	// enable masquerade rule
 	err = nsc.ensureMasqueradeIptablesRule()
@@ -429,6 +475,11 @@ func (nsc *NetworkServicesController) doSync() error {
 		glog.Errorf("Failed to do add masquerade rule in POSTROUTING chain of nat table due to: %s", err.Error())
 	}
 
+	// the IPVS services of the last sync are kept in place, as are the maps they were built from
+	err = nsc.checkEmptyCaches()
+	if err != nil {
+		return err
+	}
 	nsc.serviceMap = nsc.buildServicesInfo()
 	nsc.endpointsMap = nsc.buildEndpointsInfo()
 	err = nsc.syncHairpinIptablesRules()
@@ -445,6 +496,25 @@ func (nsc *NetworkServicesController) doSync() error {
 	if nsc.MetricsEnabled {
 		nsc.publishMetrics(nsc.serviceMap)
 	}
+
+	nodeIPs, err := nsc.getNodePortIPs()
+	if err == nil {
+		err = nsc.appliedStateCache.Save(renderIpvsState(nsc.serviceMap, nsc.endpointsMap, nodeIPs))
+	}
+	if err != nil {
+		glog.Errorf("Failed to persist the applied state: %s", err.Error())
+	}
+
+	nsc.postSyncHook.Run(utils.SyncSummary{
+		Controller: "NSC",
+		Node:       nsc.nodeHostName,
+		Timestamp:  time.Now(),
+		Duration:   time.Since(start).Seconds(),
+		Details: map[string]interface{}{
+			"services":  len(nsc.serviceMap),
+			"endpoints": len(nsc.endpointsMap),
+		},
+	})
 	return nil
 }
 
@@ -469,6 +539,60 @@ func getIpvsFirewallInputChainRule() []string {
 		"-j", ipvsFirewallChainName}
 }
 
+func getServiceTrafficMarkRule() []string {
+	// The iptables rule for use in {setup,cleanup}ServiceTrafficMark. Rule is added to the INPUT chain of
+	// the mangle table so it is evaluated before the network policy chains in the INPUT chain of the filter
+	// table. Packets already carrying a FWMARK of a DSR service are left untouched, as IPVS matches FWMARK
+	// services on the exact mark value.
+	dsrMark := utils.GetFwMark(utils.FwMarkDSR)
+	return []string{
+		"-m", "comment", "--comment", "mark traffic to IPVS services for network policy interop",
+		"-m", "set", "--match-set", ipvsServicesIPSetName, "dst,dst",
+		"-m", "mark", "--mark", fmt.Sprintf("0x0/0x%x", dsrMark.Mask),
+		"-j", "MARK", "--set-xmark", utils.GetFwMark(utils.FwMarkServiceTraffic).String()}
+}
+
+// setupServiceTrafficMark marks the packets destined to IPVS services so that network policy controller
+// can defer the evaluation of egress network policies till IPVS has picked the endpoint
+func (nsc *NetworkServicesController) setupServiceTrafficMark() error {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("Failed to initialize iptables executor" + err.Error())
+	}
+	args := getServiceTrafficMarkRule()
+	exists, err := iptablesCmdHandler.Exists("mangle", "INPUT", args...)
+	if err != nil {
+		return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+	}
+	if !exists {
+		err = iptablesCmdHandler.Insert("mangle", "INPUT", 1, args...)
+		if err != nil {
+			return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (nsc *NetworkServicesController) cleanupServiceTrafficMark() {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		glog.Errorf("Failed to initialize iptables executor: %s", err.Error())
+		return
+	}
+	args := getServiceTrafficMarkRule()
+	exists, err := iptablesCmdHandler.Exists("mangle", "INPUT", args...)
+	if err != nil {
+		glog.Errorf("Failed to run iptables command: %s", err.Error())
+		return
+	}
+	if exists {
+		err = iptablesCmdHandler.Delete("mangle", "INPUT", args...)
+		if err != nil {
+			glog.Errorf("Failed to run iptables command: %s", err.Error())
+		}
+	}
+}
+
 func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 	/*
 	   - create ipsets
@@ -506,6 +630,23 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 	}
 	nsc.ipsetMap[ipvsServicesIPSetName] = ipset
 
+	// Create ipset for the ports of the node port range.
+	ipset, err = ipSetHandler.Create(nodePortsIPSetName, utils.TypeBitmapPort, utils.OptionRange,
+		nsc.nodePortRange.String(), utils.OptionTimeout, "0")
+	if err != nil {
+		return fmt.Errorf("failed to create ipset: %s", err.Error())
+	}
+	nsc.ipsetMap[nodePortsIPSetName] = ipset
+	err = ipset.Refresh([]string{nsc.nodePortRange.String()})
+	if err != nil {
+		return fmt.Errorf("failed to sync ipset: %s", err.Error())
+	}
+
+	err = nsc.setupServiceTrafficMark()
+	if err != nil {
+		return err
+	}
+
 	// Setup a custom iptables chain to explicitly allow input traffic to
 	// ipvs services only.
 	iptablesCmdHandler, err := iptables.New()
@@ -586,6 +727,8 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 	*/
 	var err error
 
+	nsc.cleanupServiceTrafficMark()
+
 	// Clear iptables rules.
 	iptablesCmdHandler, err := iptables.New()
 	if err != nil {
@@ -597,6 +740,8 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 			glog.Errorf("Failed to run iptables command: %s", err.Error())
 		}
 
+		nsc.cleanupNodePortFirewall(iptablesCmdHandler)
+
 		err = iptablesCmdHandler.ClearChain("filter", ipvsFirewallChainName)
 		if err != nil {
 			glog.Errorf("Failed to run iptables command: %s", err.Error())
@@ -627,6 +772,11 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 		if err != nil {
 			glog.Errorf("failed to destroy ipset: %s", err.Error())
 		}
+
+		err = ipSetHandler.Destroy(nodePortsIPSetName)
+		if err != nil {
+			glog.Errorf("failed to destroy ipset: %s", err.Error())
+		}
 	}
 }
 
@@ -655,6 +805,28 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 		return errors.New("Failed to list IPVS services: " + err.Error())
 	}
 
+	serviceIPsSets, ipvsServicesSets := nsc.ipvsFirewallIPSetEntries(ipvsServices)
+
+	serviceIPsIPSet := nsc.ipsetMap[serviceIPsIPSetName]
+	err = serviceIPsIPSet.Refresh(serviceIPsSets, utils.OptionTimeout, "0")
+	if err != nil {
+		return fmt.Errorf("failed to sync ipset: %s", err.Error())
+	}
+
+	ipvsServicesIPSet := nsc.ipsetMap[ipvsServicesIPSetName]
+	err = ipvsServicesIPSet.Refresh(ipvsServicesSets, utils.OptionTimeout, "0")
+	if err != nil {
+		return fmt.Errorf("failed to sync ipset: %s", err.Error())
+	}
+
+	nsc.warnNodePortsOutOfRange(nsc.serviceMap)
+
+	return nil
+}
+
+// ipvsFirewallIPSetEntries returns the entries of the ipsets of the service IPs and of the IPVS services, whose
+// traffic is marked for the network policies, from the IPVS services
+func (nsc *NetworkServicesController) ipvsFirewallIPSetEntries(ipvsServices []*ipvs.Service) ([]string, []string) {
 	serviceIPsSets := make([]string, 0, len(ipvsServices))
 	ipvsServicesSets := make([]string, 0, len(ipvsServices))
 
@@ -683,20 +855,7 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 		ipvsServicesSets = append(ipvsServicesSets, ipvsServicesSet)
 
 	}
-
-	serviceIPsIPSet := nsc.ipsetMap[serviceIPsIPSetName]
-	err = serviceIPsIPSet.Refresh(serviceIPsSets, utils.OptionTimeout, "0")
-	if err != nil {
-		return fmt.Errorf("failed to sync ipset: %s", err.Error())
-	}
-
-	ipvsServicesIPSet := nsc.ipsetMap[ipvsServicesIPSetName]
-	err = ipvsServicesIPSet.Refresh(ipvsServicesSets, utils.OptionTimeout, "0")
-	if err != nil {
-		return fmt.Errorf("failed to sync ipset: %s", err.Error())
-	}
-
-	return nil
+	return serviceIPsSets, ipvsServicesSets
 }
 
 func (nsc *NetworkServicesController) publishMetrics(serviceInfoMap serviceInfoMap) error {
@@ -784,6 +943,11 @@ func (nsc *NetworkServicesController) OnEndpointsUpdate(ep *api.Endpoints) {
 	nsc.mu.Lock()
 	defer nsc.mu.Unlock()
 
+	if err := nsc.checkEmptyCaches(); err != nil {
+		glog.Errorf("Skipping IPVS services sync: %s", err)
+		return
+	}
+
 	// build new service and endpoints map to reflect the change
 	newServiceMap := nsc.buildServicesInfo()
 	newEndpointsMap := nsc.buildEndpointsInfo()
@@ -808,6 +972,11 @@ func (nsc *NetworkServicesController) OnServiceUpdate(svc *api.Service) {
 	nsc.mu.Lock()
 	defer nsc.mu.Unlock()
 
+	if err := nsc.checkEmptyCaches(); err != nil {
+		glog.Errorf("Skipping IPVS services sync: %s", err)
+		return
+	}
+
 	// build new service and endpoints map to reflect the change
 	newServiceMap := nsc.buildServicesInfo()
 	newEndpointsMap := nsc.buildEndpointsInfo()
@@ -1424,19 +1593,22 @@ func deleteMasqueradeIptablesRule() error {
 	if err != nil {
 		return errors.New("Failed to initialize iptables executor" + err.Error())
 	}
-	postRoutingChainRules, err := iptablesCmdHandler.List("nat", "POSTROUTING")
-	if err != nil {
-		return errors.New("Failed to list iptables rules in POSTROUTING chain in nat table" + err.Error())
-	}
-	for i, rule := range postRoutingChainRules {
-		if strings.Contains(rule, "ipvs") && strings.Contains(rule, "SNAT") {
-			err = iptablesCmdHandler.Delete("nat", "POSTROUTING", strconv.Itoa(i))
-			if err != nil {
-				return errors.New("Failed to run iptables command" + err.Error())
+	deleted, err := utils.DeleteIPTablesRules(iptablesCmdHandler, "nat", "POSTROUTING", func(rule utils.IPTablesSaveRule) bool {
+		if rule.Target != "SNAT" {
+			return false
+		}
+		for _, arg := range rule.Args {
+			if arg == "--ipvs" {
+				return true
 			}
-			glog.V(2).Infof("Deleted iptables masquerade rule: %s", rule)
-			break
 		}
+		return false
+	})
+	for _, rule := range deleted {
+		glog.V(2).Infof("Deleted iptables masquerade rule: %s", rule)
+	}
+	if err != nil {
+		return errors.New("Failed to delete iptables masquerade rule: " + err.Error())
 	}
 	return nil
 }
@@ -1587,7 +1759,7 @@ func (ln *linuxNetworking) ipvsAddService(svcs []*ipvs.Service, vip net.IP, prot
 
 	svc := ipvs.Service{
 		Address:       vip,
-		AddressFamily: syscall.AF_INET,
+		AddressFamily: ipvsAddressFamily(vip),
 		Protocol:      protocol,
 		Port:          port,
 		SchedName:     scheduler,
@@ -1604,14 +1776,15 @@ func (ln *linuxNetworking) ipvsAddService(svcs []*ipvs.Service, vip net.IP, prot
 	return &svc, nil
 }
 
-// generateFwmark: generate a uint32 hash value using the IP address, port, protocol information
+// generateFwmark: generate a uint32 hash value using the IP address, port, protocol information. Value is
+// placed in the bits of the packet mark allocated for DSR in the fwmark registry.
 // TODO: collision can rarely happen but still need to be ruled out
-// TODO: I ran into issues with FWMARK for any value above 2^15. Either policy
-// routing and IPVS FWMARK service was not functioning with value above 2^15
+// I ran into issues with FWMARK for any value above 2^15. Either policy routing and IPVS FWMARK service was not
+// functioning with value above 2^15, so the registry allocates the DSR bits below it.
 func generateFwmark(ip, protocol, port string) uint32 {
 	h := fnv.New32a()
 	h.Write([]byte(ip + "-" + protocol + "-" + port))
-	return h.Sum32() & 0x3FFF
+	return utils.GetFwMark(utils.FwMarkDSR).FieldValue(h.Sum32()).Value
 }
 
 // ipvsAddFWMarkService: creates a IPVS service using FWMARK
@@ -1726,13 +1899,48 @@ const (
 	externalIPRouteTableName = "external_ip"
 )
 
+// dsrFwMark returns the packet mark of the fwmark of an external IP service, restricted to the bits allocated for
+// DSR so that the bits of the mark owned by the other features and by other software are left as is
+func dsrFwMark(fwmark string) (utils.FwMark, error) {
+	value, err := strconv.ParseUint(fwmark, 0, 32)
+	if err != nil {
+		return utils.FwMark{}, fmt.Errorf("invalid fwmark %q: %s", fwmark, err.Error())
+	}
+	mask := utils.GetFwMark(utils.FwMarkDSR).Mask
+	return utils.FwMark{Value: uint32(value) & mask, Mask: mask}, nil
+}
+
+// legacyFwmark returns the fwmark the previous versions set on the whole packet mark of the traffic to the external
+// IP, 14 bits of the hash of the external IP, protocol and port of the service
+func legacyFwmark(ip, protocol, port string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(ip + "-" + protocol + "-" + port))
+	return h.Sum32() & 0x3FFF
+}
+
+// mangleTableRuleArgs returns the rule FWMARKing the traffic to the external IP, and the rule setting the whole
+// packet mark of the previous versions
+func mangleTableRuleArgs(ip string, protocol string, port string, fwmark string) (args, legacyArgs []string, err error) {
+	mark, err := dsrFwMark(fwmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	match := []string{"-d", ip, "-m", protocol, "-p", protocol, "--dport", port, "-j", "MARK"}
+	args = append(append([]string{}, match...), "--set-xmark", mark.String())
+	legacyArgs = append(append([]string{}, match...), "--set-mark", fmt.Sprint(legacyFwmark(ip, protocol, port)))
+	return args, legacyArgs, nil
+}
+
 // setupMangleTableRule: setsup iptables rule to FWMARK the traffic to exteranl IP vip
 func setupMangleTableRule(ip string, protocol string, port string, fwmark string) error {
 	iptablesCmdHandler, err := iptables.New()
 	if err != nil {
 		return errors.New("Failed to initialize iptables executor" + err.Error())
 	}
-	args := []string{"-d", ip, "-m", protocol, "-p", protocol, "--dport", port, "-j", "MARK", "--set-mark", fwmark}
+	args, legacyArgs, err := mangleTableRuleArgs(ip, protocol, port, fwmark)
+	if err != nil {
+		return errors.New("Failed to set up FWMARK due to " + err.Error())
+	}
 	err = iptablesCmdHandler.AppendUnique("mangle", "PREROUTING", args...)
 	if err != nil {
 		return errors.New("Failed to run iptables command to set up FWMARK due to " + err.Error())
@@ -1741,7 +1949,7 @@ func setupMangleTableRule(ip string, protocol string, port string, fwmark string
 	if err != nil {
 		return errors.New("Failed to run iptables command to set up FWMARK due to " + err.Error())
 	}
-	return nil
+	return deleteMangleTableRule(iptablesCmdHandler, legacyArgs)
 }
 
 func (ln *linuxNetworking) cleanupMangleTableRule(ip string, protocol string, port string, fwmark string) error {
@@ -1749,41 +1957,50 @@ func (ln *linuxNetworking) cleanupMangleTableRule(ip string, protocol string, po
 	if err != nil {
 		return errors.New("Failed to initialize iptables executor" + err.Error())
 	}
-	args := []string{"-d", ip, "-m", protocol, "-p", protocol, "--dport", port, "-j", "MARK", "--set-mark", fwmark}
-	exists, err := iptablesCmdHandler.Exists("mangle", "PREROUTING", args...)
+	args, legacyArgs, err := mangleTableRuleArgs(ip, protocol, port, fwmark)
 	if err != nil {
-		return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
-	}
-	if exists {
-		err = iptablesCmdHandler.Delete("mangle", "PREROUTING", args...)
-		if err != nil {
-			return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
-		}
+		return errors.New("Failed to cleanup FWMARK due to " + err.Error())
 	}
-	exists, err = iptablesCmdHandler.Exists("mangle", "OUTPUT", args...)
+	err = deleteMangleTableRule(iptablesCmdHandler, args)
 	if err != nil {
-		return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
+		return err
 	}
-	if exists {
-		err = iptablesCmdHandler.Delete("mangle", "OUTPUT", args...)
+	return deleteMangleTableRule(iptablesCmdHandler, legacyArgs)
+}
+
+// deleteMangleTableRule deletes the rule FWMARKing the traffic to an external IP from the PREROUTING and OUTPUT
+// chains
+func deleteMangleTableRule(iptablesCmdHandler *iptables.IPTables, args []string) error {
+	for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+		exists, err := iptablesCmdHandler.Exists("mangle", chain, args...)
 		if err != nil {
 			return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
 		}
+		if exists {
+			err = iptablesCmdHandler.Delete("mangle", chain, args...)
+			if err != nil {
+				return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
+			}
+		}
 	}
-
 	return nil
 }
 
 // For DSR it is required that we dont assign the VIP to any interface to avoid martian packets
 // http://www.austintek.com/LVS/LVS-HOWTO/HOWTO/LVS-HOWTO.routing_to_VIP-less_director.html
-// routeVIPTrafficToDirector: setups policy routing so that FWMARKed packets are deliverd locally
+// routeVIPTrafficToDirector: setups policy routing so that FWMARKed packets are deliverd locally. Only the bits of
+// the mark allocated for DSR are matched.
 func routeVIPTrafficToDirector(fwmark string) error {
-	out, err := exec.Command("ip", "rule", "list").Output()
+	mark, err := dsrFwMark(fwmark)
+	if err != nil {
+		return errors.New("Failed to add policy rule to lookup traffic to VIP due to " + err.Error())
+	}
+	out, err := utils.Exec("ip", "rule", "list")
 	if err != nil {
 		return errors.New("Failed to verify if `ip rule` exists due to: " + err.Error())
 	}
-	if !strings.Contains(string(out), fwmark) {
-		err = exec.Command("ip", "rule", "add", "prio", "32764", "fwmark", fwmark, "table", customDSRRouteTableID).Run()
+	if !strings.Contains(string(out), "fwmark "+mark.String()+" ") {
+		_, err = utils.Exec("ip", "rule", "add", "prio", "32764", "fwmark", mark.String(), "table", customDSRRouteTableID)
 		if err != nil {
 			return errors.New("Failed to add policy rule to lookup traffic to VIP through the custom " +
 				" routing table due to " + err.Error())
@@ -1794,7 +2011,8 @@ func routeVIPTrafficToDirector(fwmark string) error {
 
 // For DSR it is required that we dont assign the VIP to any interface to avoid martian packets
 // http://www.austintek.com/LVS/LVS-HOWTO/HOWTO/LVS-HOWTO.routing_to_VIP-less_director.html
-// setupPolicyRoutingForDSR: setups policy routing so that FWMARKed packets are deliverd locally
+// setupPolicyRoutingForDSR: setups policy routing so that FWMARKed packets are deliverd locally, and deletes the
+// policy rules of the previous versions matching the whole packet mark
 func (ln *linuxNetworking) setupPolicyRoutingForDSR() error {
 	b, err := ioutil.ReadFile("/etc/iproute2/rt_tables")
 	if err != nil {
@@ -1811,16 +2029,50 @@ func (ln *linuxNetworking) setupPolicyRoutingForDSR() error {
 			return errors.New("Failed to setup policy routing required for DSR due to " + err.Error())
 		}
 	}
-	out, err := exec.Command("ip", "route", "list", "table", customDSRRouteTableID).Output()
+	out, err := utils.Exec("ip", "route", "list", "table", customDSRRouteTableID)
 	if err != nil || !strings.Contains(string(out), " lo ") {
-		if err = exec.Command("ip", "route", "add", "local", "default", "dev", "lo", "table",
-			customDSRRouteTableID).Run(); err != nil {
+		if _, err = utils.Exec("ip", "route", "add", "local", "default", "dev", "lo", "table",
+			customDSRRouteTableID); err != nil {
 			return errors.New("Failed to add route in custom route table due to: " + err.Error())
 		}
 	}
+	out, err = utils.Exec("ip", "rule", "list")
+	if err != nil {
+		return errors.New("Failed to verify if `ip rule` exists due to: " + err.Error())
+	}
+	for _, fwmark := range legacyDSRRuleFwmarks(string(out)) {
+		if _, err = utils.Exec("ip", "rule", "del", "prio", "32764", "fwmark", fwmark, "table",
+			customDSRRouteTableID); err != nil {
+			return errors.New("Failed to delete policy rule of the previous versions to lookup traffic to VIP " +
+				"due to " + err.Error())
+		}
+	}
 	return nil
 }
 
+// legacyDSRRuleFwmarks returns the fwmarks of the policy rules of the previous versions looking up the traffic to
+// the VIPs in the custom routing table, which matched the whole packet mark rather than the bits allocated for DSR
+func legacyDSRRuleFwmarks(rules string) []string {
+	fwmarks := make([]string, 0)
+	for _, line := range strings.Split(rules, "\n") {
+		fields := strings.Fields(line)
+		var fwmark, table string
+		for i := 0; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "fwmark":
+				fwmark = fields[i+1]
+			case "lookup", "table":
+				table = fields[i+1]
+			}
+		}
+		if fwmark != "" && !strings.Contains(fwmark, "/") &&
+			(table == customDSRRouteTableID || table == customDSRRouteTableName) {
+			fwmarks = append(fwmarks, fwmark)
+		}
+	}
+	return fwmarks
+}
+
 // For DSR it is required that node needs to know how to route exteranl IP. Otherwise when endpoint
 // directly responds back with source IP as external IP kernel will treat as martian packet.
 // To prevent martian packets add route to exteranl IP through the `kube-bridge` interface
@@ -1843,20 +2095,20 @@ func (ln *linuxNetworking) setupRoutesForExternalIPForDSR(serviceInfoMap service
 		}
 	}
 
-	out, err := exec.Command("ip", "rule", "list").Output()
+	out, err := utils.Exec("ip", "rule", "list")
 	if err != nil {
 		return errors.New("Failed to verify if `ip rule add prio 32765 from all lookup external_ip` exists due to: " + err.Error())
 	}
 
 	if !(strings.Contains(string(out), externalIPRouteTableName) || strings.Contains(string(out), externalIPRouteTableId)) {
-		err = exec.Command("ip", "rule", "add", "prio", "32765", "from", "all", "lookup", externalIPRouteTableId).Run()
+		_, err = utils.Exec("ip", "rule", "add", "prio", "32765", "from", "all", "lookup", externalIPRouteTableId)
 		if err != nil {
 			glog.Infof("Failed to add policy rule `ip rule add prio 32765 from all lookup external_ip` due to " + err.Error())
 			return errors.New("Failed to add policy rule `ip rule add prio 32765 from all lookup external_ip` due to " + err.Error())
 		}
 	}
 
-	out, _ = exec.Command("ip", "route", "list", "table", externalIPRouteTableId).Output()
+	out, _ = utils.Exec("ip", "route", "list", "table", externalIPRouteTableId)
 	outStr := string(out)
 	activeExternalIPs := make(map[string]bool)
 	for _, svc := range serviceInfoMap {
@@ -1869,8 +2121,8 @@ func (ln *linuxNetworking) setupRoutesForExternalIPForDSR(serviceInfoMap service
 			}
 
 			if !strings.Contains(outStr, externalIP) {
-				if err = exec.Command("ip", "route", "add", externalIP, "dev", "kube-bridge", "table",
-					externalIPRouteTableId).Run(); err != nil {
+				if _, err = utils.Exec("ip", "route", "add", externalIP, "dev", "kube-bridge", "table",
+					externalIPRouteTableId); err != nil {
 					glog.Error("Failed to add route for " + externalIP + " in custom route table for external IP's due to: " + err.Error())
 					continue
 				}
@@ -1887,7 +2139,7 @@ func (ln *linuxNetworking) setupRoutesForExternalIPForDSR(serviceInfoMap service
 			if !activeExternalIPs[ip] {
 				args := []string{"route", "del", "table", externalIPRouteTableId}
 				args = append(args, route...)
-				if err = exec.Command("ip", args...).Run(); err != nil {
+				if _, err = utils.Exec("ip", args...); err != nil {
 					glog.Errorf("Failed to del route for %v in custom route table for external IP's due to: %s", ip, err)
 					continue
 				}
@@ -1919,6 +2171,11 @@ func generateEndpointId(ip, port string) string {
 
 // returns all IP addresses found on any network address in the system, excluding dummy and docker interfaces
 func getAllLocalIPs() ([]netlink.Addr, error) {
+	return getLocalAddrs(netlink.FAMILY_V4)
+}
+
+// returns the addresses of family found on any network address in the system, excluding dummy and docker interfaces
+func getLocalAddrs(family int) ([]netlink.Addr, error) {
 	links, err := netlink.LinkList()
 	if err != nil {
 		return nil, errors.New("Could not load list of net interfaces: " + err.Error())
@@ -1936,7 +2193,7 @@ func getAllLocalIPs() ([]netlink.Addr, error) {
 			continue
 		}
 
-		linkAddrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		linkAddrs, err := netlink.AddrList(link, family)
 		if err != nil {
 			return nil, errors.New("Failed to get IPs for interface: " + err.Error())
 		}
@@ -1965,31 +2222,32 @@ func (ln *linuxNetworking) getKubeDummyInterface() (netlink.Link, error) {
 	return dummyVipInterface, nil
 }
 
-// Cleanup cleans all the configurations (IPVS, iptables, links) done
-func (nsc *NetworkServicesController) Cleanup() {
+// Cleanup cleans all the configurations (IPVS, iptables, links) done, and returns the error it stopped on
+func (nsc *NetworkServicesController) Cleanup() error {
 	// cleanup ipvs rules by flush
 	glog.Infof("Cleaning up IPVS configuration permanently")
 
 	handle, err := ipvs.New("")
 	if err != nil {
-		glog.Errorf("Failed to cleanup ipvs rules: %s", err.Error())
-		return
+		return fmt.Errorf("failed to cleanup ipvs rules: %s", err.Error())
 	}
 
+	err = handle.Flush()
 	handle.Close()
+	if err != nil {
+		return fmt.Errorf("failed to cleanup ipvs rules: %s", err.Error())
+	}
 
 	// cleanup iptables masquerade rule
 	err = deleteMasqueradeIptablesRule()
 	if err != nil {
-		glog.Errorf("Failed to cleanup iptablesmasquerade rule due to: %s", err.Error())
-		return
+		return fmt.Errorf("failed to cleanup iptables masquerade rule due to: %s", err.Error())
 	}
 
 	// cleanup iptables hairpin rules
 	err = deleteHairpinIptablesRules()
 	if err != nil {
-		glog.Errorf("Failed to cleanup iptables hairpin rules: %s", err.Error())
-		return
+		return fmt.Errorf("failed to cleanup iptables hairpin rules: %s", err.Error())
 	}
 
 	nsc.cleanupIpvsFirewall()
@@ -1997,17 +2255,18 @@ func (nsc *NetworkServicesController) Cleanup() {
 	// delete dummy interface used to assign cluster IP's
 	dummyVipInterface, err := netlink.LinkByName(KUBE_DUMMY_IF)
 	if err != nil {
-		if err.Error() != IFACE_NOT_FOUND {
-			glog.Infof("Dummy interface: " + KUBE_DUMMY_IF + " does not exist")
+		if _, ok := err.(netlink.LinkNotFoundError); !ok {
+			return fmt.Errorf("could not find dummy interface %s due to %s", KUBE_DUMMY_IF, err.Error())
 		}
+		glog.Infof("Dummy interface: " + KUBE_DUMMY_IF + " does not exist")
 	} else {
 		err = netlink.LinkDel(dummyVipInterface)
 		if err != nil {
-			glog.Errorf("Could not delete dummy interface " + KUBE_DUMMY_IF + " due to " + err.Error())
-			return
+			return fmt.Errorf("could not delete dummy interface %s due to %s", KUBE_DUMMY_IF, err.Error())
 		}
 	}
 	glog.Infof("Successfully cleaned the ipvs configuration done by kube-router")
+	return nil
 }
 
 func (nsc *NetworkServicesController) newEndpointsEventHandler() cache.ResourceEventHandler {
@@ -2149,6 +2408,11 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 
 	nsc.syncPeriod = config.IpvsSyncPeriod
 	nsc.syncChan = make(chan int, 2)
+
+	nsc.postSyncHook, err = utils.NewPostSyncHook(config.PostSyncHook, config.PostSyncHookTimeout)
+	if err != nil {
+		return nil, err
+	}
 	nsc.gracefulPeriod = config.IpvsGracefulPeriod
 	nsc.gracefulTermination = config.IpvsGracefulTermination
 	nsc.globalHairpin = config.GlobalHairpinMode
@@ -2156,6 +2420,7 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	nsc.serviceMap = make(serviceInfoMap)
 	nsc.endpointsMap = make(endpointsInfoMap)
 	nsc.client = clientset
+	nsc.emptyCacheGuards = newEmptyCacheGuards(clientset)
 
 	nsc.masqueradeAll = false
 	if config.MasqueradeAll {
@@ -2165,6 +2430,11 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	if config.NodePortBindOnAllIp {
 		nsc.nodeportBindOnAllIp = true
 	}
+	nsc.nodePortIPv6Addresses = config.NodePortIPv6Addresses
+	nsc.nodePortIPv6CIDRs, err = parseNodePortIPv6Config(config.NodePortIPv6Addresses, config.NodePortIPv6CIDRs)
+	if err != nil {
+		return nil, err
+	}
 
 	if config.RunRouter {
 		cidr, err := utils.GetPodCidrFromNodeSpec(nsc.client, config.HostnameOverride)
@@ -2189,6 +2459,7 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	}
 
 	nsc.nodeHostName = node.Name
+	nsc.appliedStateCache = nodestate.NewAppliedStateCache(config.AppliedStateDir, "proxy", nsc.nodeHostName)
 	NodeIP, err = utils.GetNodeIP(node)
 	if err != nil {
 		return nil, err
@@ -2201,9 +2472,18 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	nsc.ServiceEventHandler = nsc.newSvcEventHandler()
 
 	nsc.ipvsPermitAll = config.IpvsPermitAll
+	nsc.nodePortRange, err = parseNodePortRange(config.ServiceNodePortRange)
+	if err != nil {
+		return nil, utils.NewConfigError(err)
+	}
+	if err = validateNodePortFirewall(config.NodePortFirewall); err != nil {
+		return nil, utils.NewConfigError(err)
+	}
+	nsc.nodePortFirewall = config.NodePortFirewall
 
 	nsc.epLister = epInformer.GetIndexer()
 	nsc.EndpointsEventHandler = nsc.newEndpointsEventHandler()
+	nsc.cachesSynced = []cache.InformerSynced{svcInformer.HasSynced, epInformer.HasSynced, podInformer.HasSynced}
 
 	rand.Seed(time.Now().UnixNano())
 
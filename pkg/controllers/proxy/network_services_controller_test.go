@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -134,12 +135,12 @@ var _ = Describe("NetworkServicesController", func() {
 	JustBeforeEach(func() {
 		clientset := fake.NewSimpleClientset()
 
-		_, err := clientset.CoreV1().Endpoints("default").Create(testcase.existingEndpoint)
+		_, err := clientset.CoreV1().Endpoints("default").Create(context.Background(), testcase.existingEndpoint, metav1.CreateOptions{})
 		if err != nil {
 			fatalf("failed to create existing endpoints: %v", err)
 		}
 
-		_, err = clientset.CoreV1().Services("default").Create(testcase.existingService)
+		_, err = clientset.CoreV1().Services("default").Create(context.Background(), testcase.existingService, metav1.CreateOptions{})
 		if err != nil {
 			fatalf("failed to create existing services: %v", err)
 		}
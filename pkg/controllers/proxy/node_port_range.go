@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+)
+
+// The ports of the --service-node-port-range of the cluster are kept in the kube-router-node-ports ipset, so the
+// traffic to NodePort services can be matched as a class, by the optional --nodeport-firewall rule and by the host
+// firewall of the node.
+
+const (
+	nodePortsIPSetName = "kube-router-node-ports"
+
+	// values of --nodeport-firewall, what is done with the traffic to the node ports of the node
+	// no rule, the traffic is left to the other rules of the node
+	nodePortFirewallNone = "none"
+	// the traffic is accepted ahead of the other rules of the INPUT chain
+	nodePortFirewallAllow = "allow"
+	// the traffic is rejected ahead of the other rules of the INPUT chain
+	nodePortFirewallDeny = "deny"
+)
+
+// nodePortRange is the range of ports NodePort services are allocated from
+type nodePortRange struct {
+	min int
+	max int
+}
+
+// parseNodePortRange parses --service-node-port-range, in the min-max format of the API server, or base+size
+func parseNodePortRange(value string) (nodePortRange, error) {
+	invalid := fmt.Errorf("invalid --service-node-port-range %q, must be min-max or base+size", value)
+	var first, second string
+	var isSize bool
+	if i := strings.Index(value, "-"); i >= 0 {
+		first, second = value[:i], value[i+1:]
+	} else if i := strings.Index(value, "+"); i >= 0 {
+		first, second, isSize = value[:i], value[i+1:], true
+	} else {
+		return nodePortRange{}, invalid
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return nodePortRange{}, invalid
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(second))
+	if err != nil {
+		return nodePortRange{}, invalid
+	}
+	if isSize {
+		max = min + max - 1
+	}
+	if min < 1 || max > 65535 || max < min {
+		return nodePortRange{}, invalid
+	}
+	return nodePortRange{min: min, max: max}, nil
+}
+
+func (r nodePortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.min, r.max)
+}
+
+func (r nodePortRange) contains(port int) bool {
+	return port >= r.min && port <= r.max
+}
+
+func validateNodePortFirewall(value string) error {
+	switch value {
+	case nodePortFirewallNone, nodePortFirewallAllow, nodePortFirewallDeny:
+		return nil
+	}
+	return fmt.Errorf("invalid --nodeport-firewall %q, must be one of %s, %s or %s", value, nodePortFirewallNone,
+		nodePortFirewallAllow, nodePortFirewallDeny)
+}
+
+// warnNodePortsOutOfRange logs the node ports of the services outside of the node port range, which are missing
+// from the kube-router-node-ports ipset, e.g. when --service-node-port-range differs from the one of the API server.
+// Each of them is logged once.
+func (nsc *NetworkServicesController) warnNodePortsOutOfRange(serviceInfoMap serviceInfoMap) {
+	outOfRange := make(map[string]bool)
+	for _, svc := range serviceInfoMap {
+		if svc.nodePort == 0 || nsc.nodePortRange.contains(svc.nodePort) {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s:%d", svc.namespace, svc.name, svc.nodePort)
+		outOfRange[key] = true
+		if nsc.nodePortsOutOfRange[key] {
+			continue
+		}
+		glog.Warningf("Node port %d of service %s/%s is outside of --service-node-port-range %s, it is missing "+
+			"from the %s ipset", svc.nodePort, svc.namespace, svc.name, nsc.nodePortRange, nodePortsIPSetName)
+	}
+	nsc.nodePortsOutOfRange = outOfRange
+}
+
+func getNodePortFirewallInputChainRule(action string) []string {
+	// The iptables rule for use in {setup,cleanup}NodePortFirewall.
+	target := []string{"-j", "ACCEPT"}
+	if action == nodePortFirewallDeny {
+		target = []string{"-j", "REJECT", "--reject-with", "icmp-port-unreachable"}
+	}
+	return append([]string{
+		"-m", "comment", "--comment", action + " traffic to the node ports of the node",
+		"-m", "addrtype", "--dst-type", "LOCAL",
+		"-m", "set", "--match-set", nodePortsIPSetName, "dst"},
+		target...)
+}
+
+// setupNodePortFirewall inserts the rule of --nodeport-firewall at the top of the INPUT chain, and deletes the rule
+// of the other action, left by a previous configuration
+func (nsc *NetworkServicesController) setupNodePortFirewall() error {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("Failed to initialize iptables executor" + err.Error())
+	}
+	for _, action := range []string{nodePortFirewallAllow, nodePortFirewallDeny} {
+		args := getNodePortFirewallInputChainRule(action)
+		exists, err := iptablesCmdHandler.Exists("filter", "INPUT", args...)
+		if err != nil {
+			return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+		}
+		if action != nsc.nodePortFirewall {
+			if exists {
+				if err = iptablesCmdHandler.Delete("filter", "INPUT", args...); err != nil {
+					return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+				}
+			}
+			continue
+		}
+		if !exists {
+			if err = iptablesCmdHandler.Insert("filter", "INPUT", 1, args...); err != nil {
+				return fmt.Errorf("Failed to run iptables command: %s", err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func (nsc *NetworkServicesController) cleanupNodePortFirewall(iptablesCmdHandler *iptables.IPTables) {
+	for _, action := range []string{nodePortFirewallAllow, nodePortFirewallDeny} {
+		args := getNodePortFirewallInputChainRule(action)
+		exists, err := iptablesCmdHandler.Exists("filter", "INPUT", args...)
+		if err != nil {
+			glog.Errorf("Failed to run iptables command: %s", err.Error())
+			continue
+		}
+		if !exists {
+			continue
+		}
+		if err = iptablesCmdHandler.Delete("filter", "INPUT", args...); err != nil {
+			glog.Errorf("Failed to run iptables command: %s", err.Error())
+		}
+	}
+}
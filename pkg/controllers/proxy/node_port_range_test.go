@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseNodePortRange(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected nodePortRange
+	}{
+		{"30000-32767", nodePortRange{min: 30000, max: 32767}},
+		{"30000+2768", nodePortRange{min: 30000, max: 32767}},
+		{"80-80", nodePortRange{min: 80, max: 80}},
+	} {
+		r, err := parseNodePortRange(test.value)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s: %s", test.value, err)
+		}
+		if r != test.expected {
+			t.Errorf("expected %s to be parsed as %v, got %v", test.value, test.expected, r)
+		}
+	}
+
+	for _, invalid := range []string{"", "30000", "32767-30000", "0-100", "30000-70000", "a-b", "30000+0"} {
+		if _, err := parseNodePortRange(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+
+	r := nodePortRange{min: 30000, max: 32767}
+	if r.String() != "30000-32767" {
+		t.Errorf("expected the range to be rendered as 30000-32767, got %s", r)
+	}
+	if !r.contains(30000) || !r.contains(32767) || r.contains(29999) || r.contains(32768) {
+		t.Errorf("expected the range to contain exactly the ports from 30000 to 32767")
+	}
+}
+
+func Test_getNodePortFirewallInputChainRule(t *testing.T) {
+	for _, test := range []struct {
+		action string
+		target []string
+	}{
+		{nodePortFirewallAllow, []string{"-j", "ACCEPT"}},
+		{nodePortFirewallDeny, []string{"-j", "REJECT", "--reject-with", "icmp-port-unreachable"}},
+	} {
+		rule := getNodePortFirewallInputChainRule(test.action)
+		if target := rule[len(rule)-len(test.target):]; !reflect.DeepEqual(target, test.target) {
+			t.Errorf("expected the %s rule to end with %v, got %v", test.action, test.target, rule)
+		}
+	}
+	if err := validateNodePortFirewall("reject"); err == nil {
+		t.Errorf("expected --nodeport-firewall=reject to be rejected")
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// values of --nodeport-ipv6-addresses, the IPv6 addresses of the node node port services are bound to with
+// --nodeport-bindon-all-ip
+const (
+	// no IPv6 address
+	nodePortIPv6None = "none"
+	// global addresses that are neither temporary (SLAAC privacy addresses) nor deprecated
+	nodePortIPv6Stable = "stable"
+	// all global addresses
+	nodePortIPv6All = "all"
+)
+
+// addresses still being verified or found duplicate by DAD cannot be bound to
+const unusableIPv6AddrFlags = unix.IFA_F_TENTATIVE | unix.IFA_F_DADFAILED
+
+// addresses the kernel replaces over time, bindings on them would come and go
+const unstableIPv6AddrFlags = unix.IFA_F_TEMPORARY | unix.IFA_F_DEPRECATED
+
+// parseNodePortIPv6Config validates --nodeport-ipv6-addresses and parses --nodeport-ipv6-cidrs
+func parseNodePortIPv6Config(mode string, cidrs []string) ([]*net.IPNet, error) {
+	switch mode {
+	case nodePortIPv6None, nodePortIPv6Stable, nodePortIPv6All:
+	default:
+		return nil, fmt.Errorf("invalid --nodeport-ipv6-addresses %q, must be one of %s, %s or %s", mode,
+			nodePortIPv6None, nodePortIPv6Stable, nodePortIPv6All)
+	}
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || ipNet.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid --nodeport-ipv6-cidrs %q, must be an IPv6 CIDR", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	if len(nets) > 0 && mode == nodePortIPv6None {
+		return nil, errors.New("--nodeport-ipv6-cidrs requires --nodeport-ipv6-addresses to be stable or all")
+	}
+	return nets, nil
+}
+
+// selectNodePortIPv6Addrs returns the IPv6 addresses of addrs node port services are bound to. Link local addresses
+// are never selected, and when cidrs are given only the addresses within them are.
+func selectNodePortIPv6Addrs(addrs []netlink.Addr, mode string, cidrs []*net.IPNet) []netlink.Addr {
+	if mode == nodePortIPv6None {
+		return nil
+	}
+	selected := make([]netlink.Addr, 0)
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil || !addr.IP.IsGlobalUnicast() {
+			continue
+		}
+		if addr.Flags&unusableIPv6AddrFlags != 0 {
+			continue
+		}
+		if mode == nodePortIPv6Stable && addr.Flags&unstableIPv6AddrFlags != 0 {
+			continue
+		}
+		if len(cidrs) > 0 && !containsIP(cidrs, addr.IP) {
+			continue
+		}
+		selected = append(selected, addr)
+	}
+	return selected
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getNodePortAddrs returns the addresses node port services are bound to with --nodeport-bindon-all-ip: all the
+// local IPv4 addresses and the IPv6 ones selected by --nodeport-ipv6-addresses and --nodeport-ipv6-cidrs
+func (nsc *NetworkServicesController) getNodePortAddrs() ([]netlink.Addr, error) {
+	addrs, err := getAllLocalIPs()
+	if err != nil {
+		return nil, err
+	}
+	if nsc.nodePortIPv6Addresses == nodePortIPv6None {
+		return addrs, nil
+	}
+	ipv6Addrs, err := getLocalAddrs(netlink.FAMILY_V6)
+	if err != nil {
+		return nil, err
+	}
+	return append(addrs, selectNodePortIPv6Addrs(ipv6Addrs, nsc.nodePortIPv6Addresses, nsc.nodePortIPv6CIDRs)...), nil
+}
+
+// ipvsAddressFamily returns the address family of the IPVS services and destinations for ip
+func ipvsAddressFamily(ip net.IP) uint16 {
+	if ip.To4() == nil {
+		return unix.AF_INET6
+	}
+	return unix.AF_INET
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func Test_selectNodePortIPv6Addrs(t *testing.T) {
+	addr := func(ip string, flags int) netlink.Addr {
+		return netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(64, 128)}, Flags: flags}
+	}
+	addrs := []netlink.Addr{
+		addr("10.0.0.1", 0),
+		addr("fe80::1", unix.IFA_F_PERMANENT),
+		addr("2001:db8::1", unix.IFA_F_PERMANENT),
+		addr("2001:db8::2", unix.IFA_F_TEMPORARY),
+		addr("2001:db8::3", unix.IFA_F_DEPRECATED),
+		addr("2001:db8::4", unix.IFA_F_TENTATIVE),
+		addr("2001:db8:1::1", 0),
+	}
+	selected := func(mode string, cidrs ...string) []string {
+		nets, err := parseNodePortIPv6Config(mode, cidrs)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ips := make([]string, 0)
+		for _, addr := range selectNodePortIPv6Addrs(addrs, mode, nets) {
+			ips = append(ips, addr.IP.String())
+		}
+		return ips
+	}
+
+	for _, test := range []struct {
+		mode     string
+		cidrs    []string
+		expected []string
+	}{
+		{nodePortIPv6None, nil, []string{}},
+		{nodePortIPv6Stable, nil, []string{"2001:db8::1", "2001:db8:1::1"}},
+		{nodePortIPv6All, nil, []string{"2001:db8::1", "2001:db8::2", "2001:db8::3", "2001:db8:1::1"}},
+		{nodePortIPv6Stable, []string{"2001:db8:1::/64"}, []string{"2001:db8:1::1"}},
+	} {
+		if got := selected(test.mode, test.cidrs...); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("expected %v to be selected with %s %v, got %v", test.expected, test.mode, test.cidrs, got)
+		}
+	}
+
+	for _, invalid := range []struct {
+		mode  string
+		cidrs []string
+	}{
+		{"temporary", nil},
+		{nodePortIPv6All, []string{"10.0.0.0/8"}},
+		{nodePortIPv6None, []string{"2001:db8::/64"}},
+	} {
+		if _, err := parseNodePortIPv6Config(invalid.mode, invalid.cidrs); err == nil {
+			t.Errorf("expected %s %v to be rejected", invalid.mode, invalid.cidrs)
+		}
+	}
+}
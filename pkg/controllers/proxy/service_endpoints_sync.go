@@ -180,7 +180,7 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 
 		if nsc.nodeportBindOnAllIp {
 			// bind on all interfaces instead
-			addrs, err := getAllLocalIPs()
+			addrs, err := nsc.getNodePortAddrs()
 
 			if err != nil {
 				glog.Errorf("Could not get list of system addresses for ipvs services: %s", err.Error())
@@ -226,6 +226,11 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 				Weight:        1,
 			}
 			for i := 0; i < len(ipvsNodeportSvcs); i++ {
+				// IPVS only masquerades to destinations of the address family of the service
+				if ipvsNodeportSvcs[i] != nil && ipvsNodeportSvcs[i].Address != nil &&
+					ipvsAddressFamily(ipvsNodeportSvcs[i].Address) != ipvsAddressFamily(dst.Address) {
+					continue
+				}
 				if !svc.local || (svc.local && endpoint.isLocal) {
 					err := nsc.ln.ipvsAddServer(ipvsNodeportSvcs[i], &dst)
 					if err != nil {
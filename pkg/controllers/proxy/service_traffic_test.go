@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// setupExternalTrafficPolicyServices returns a service proxy with the IPVS services of the cluster IP and the node
+// port of a service with the external traffic policy, whose endpoints run on the node and on another node
+func setupExternalTrafficPolicyServices(t *testing.T,
+	policy v1core.ServiceExternalTrafficPolicyType) (*NetworkServicesController, *LinuxNetworkingMock) {
+	clientset := fake.NewSimpleClientset(
+		&v1core.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default"},
+			Spec: v1core.ServiceSpec{
+				Type:                  "NodePort",
+				ClusterIP:             "10.0.0.1",
+				ExternalTrafficPolicy: policy,
+				Ports: []v1core.ServicePort{
+					{Name: "port-1", Protocol: "TCP", Port: 8080, NodePort: 30001},
+				},
+			},
+		},
+		&v1core.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default"},
+			Subsets: []v1core.EndpointSubset{{
+				Addresses: []v1core.EndpointAddress{
+					{IP: "172.20.1.1", NodeName: ptrToString("node-1")},
+					{IP: "172.20.1.2", NodeName: ptrToString("node-2")},
+				},
+				Ports: []v1core.EndpointPort{{Name: "port-1", Port: 80, Protocol: "TCP"}},
+			}},
+		})
+	lnm := NewLinuxNetworkMock()
+	mockedLinuxNetworking := &LinuxNetworkingMock{
+		cleanupMangleTableRuleFunc:         lnm.cleanupMangleTableRule,
+		getKubeDummyInterfaceFunc:          lnm.getKubeDummyInterface,
+		ipAddrAddFunc:                      lnm.ipAddrAdd,
+		ipvsAddServerFunc:                  lnm.ipvsAddServer,
+		ipvsAddServiceFunc:                 lnm.ipvsAddService,
+		ipvsDelServiceFunc:                 lnm.ipvsDelService,
+		ipvsGetDestinationsFunc:            lnm.ipvsGetDestinations,
+		ipvsGetServicesFunc:                lnm.ipvsGetServices,
+		setupPolicyRoutingForDSRFunc:       lnm.setupPolicyRoutingForDSR,
+		setupRoutesForExternalIPForDSRFunc: lnm.setupRoutesForExternalIPForDSR,
+	}
+	nsc := &NetworkServicesController{
+		nodeIP:       net.ParseIP("10.0.0.0"),
+		nodeHostName: "node-1",
+		ln:           mockedLinuxNetworking,
+	}
+	startInformersForServiceProxy(nsc, clientset)
+	for start := time.Now(); len(nsc.svcLister.List()) == 0 || len(nsc.epLister.List()) == 0; {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("timeout exceeded waiting for the listers to fill their caches")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// only the IPVS services of the cluster IP and the node port are set up, the firewall of the VIPs needs ipset
+	nsc.serviceMap = nsc.buildServicesInfo()
+	nsc.endpointsMap = nsc.buildEndpointsInfo()
+
+	activeServiceEndpointMap := make(map[string][]string)
+	err := nsc.setupClusterIPServices(nsc.serviceMap, nsc.endpointsMap, activeServiceEndpointMap)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nsc.setupNodePortServices(nsc.serviceMap, nsc.endpointsMap, activeServiceEndpointMap)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return nsc, mockedLinuxNetworking
+}
+
+// Test_externalTrafficPolicy checks the endpoints the cluster IP and the node port of a service are load balanced to:
+// with the Local policy the traffic is not masqueraded to reach the endpoints of other nodes, so the network policies
+// of the endpoints see the client
+func Test_externalTrafficPolicy(t *testing.T) {
+	testcases := []struct {
+		policy   v1core.ServiceExternalTrafficPolicyType
+		expected []string
+	}{
+		{
+			v1core.ServiceExternalTrafficPolicyTypeCluster,
+			[]string{"10.0.0.1:8080->172.20.1.1", "10.0.0.1:8080->172.20.1.2",
+				"10.0.0.0:30001->172.20.1.1", "10.0.0.0:30001->172.20.1.2"},
+		},
+		{
+			v1core.ServiceExternalTrafficPolicyTypeLocal,
+			[]string{"10.0.0.1:8080->172.20.1.1", "10.0.0.0:30001->172.20.1.1"},
+		},
+	}
+	for _, testcase := range testcases {
+		t.Run(string(testcase.policy), func(t *testing.T) {
+			_, mockedLinuxNetworking := setupExternalTrafficPolicyServices(t, testcase.policy)
+			loadBalanced := make([]string, 0)
+			for _, args := range mockedLinuxNetworking.ipvsAddServerCalls() {
+				loadBalanced = append(loadBalanced, fmt.Sprintf("%v:%v->%v", args.IpvsSvc.Address, args.IpvsSvc.Port,
+					args.IpvsDst.Address))
+			}
+			sort.Strings(loadBalanced)
+			sort.Strings(testcase.expected)
+			if !reflect.DeepEqual(loadBalanced, testcase.expected) {
+				t.Errorf("expected the service to be load balanced to %v, got %v", testcase.expected, loadBalanced)
+			}
+		})
+	}
+}
+
+// Test_serviceTrafficMark checks the traffic to the cluster IP and the node port of a service is marked in the INPUT
+// chain of the mangle table, so the network policies evaluate it once DNATed to the endpoint rather than against the
+// VIP, whatever the external traffic policy
+func Test_serviceTrafficMark(t *testing.T) {
+	rule := strings.Join(getServiceTrafficMarkRule(), " ")
+	if !strings.Contains(rule, "-m set --match-set "+ipvsServicesIPSetName+" dst,dst") {
+		t.Errorf("expected the traffic to the IPVS services to be marked: %s", rule)
+	}
+	// IPVS matches the FWMARK services on the exact mark, so the traffic to the DSR services is left unmarked
+	if !strings.Contains(rule, fmt.Sprintf("-m mark --mark 0x0/0x%x", utils.GetFwMark(utils.FwMarkDSR).Mask)) {
+		t.Errorf("expected the traffic marked for DSR not to be marked: %s", rule)
+	}
+	// the mark the network policy controller skips in the INPUT chain
+	if !strings.HasSuffix(rule, "-j MARK --set-xmark "+utils.GetFwMark(utils.FwMarkServiceTraffic).String()) {
+		t.Errorf("expected the service traffic mark to be set: %s", rule)
+	}
+
+	for _, policy := range []v1core.ServiceExternalTrafficPolicyType{v1core.ServiceExternalTrafficPolicyTypeCluster,
+		v1core.ServiceExternalTrafficPolicyTypeLocal} {
+		t.Run(string(policy), func(t *testing.T) {
+			nsc, _ := setupExternalTrafficPolicyServices(t, policy)
+			ipvsServices, err := nsc.ln.ipvsGetServices()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			serviceIPs, marked := nsc.ipvsFirewallIPSetEntries(ipvsServices)
+			sort.Strings(serviceIPs)
+			sort.Strings(marked)
+			if expected := []string{"10.0.0.0", "10.0.0.1"}; !reflect.DeepEqual(serviceIPs, expected) {
+				t.Errorf("expected the service IPs %v, got %v", expected, serviceIPs)
+			}
+			if expected := []string{"10.0.0.0,tcp:30001", "10.0.0.1,tcp:8080"}; !reflect.DeepEqual(marked, expected) {
+				t.Errorf("expected the traffic to %v to be marked, got %v", expected, marked)
+			}
+		})
+	}
+}
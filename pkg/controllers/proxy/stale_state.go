@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The IPVS services of the last sync are kept when the caches of the services or of the endpoints empty while the
+// API server is unreachable, or while it still has some of them, so an empty cache is never taken for the deletion of
+// all the services or endpoints of the cluster.
+
+// emptyCacheGuards guards the IPVS services of the node against the caches of the services and endpoints emptying
+type emptyCacheGuards struct {
+	services  *utils.EmptyCacheGuard
+	endpoints *utils.EmptyCacheGuard
+	// services and endpoints of the last sync
+	syncedServices  int
+	syncedEndpoints int
+}
+
+func newEmptyCacheGuards(clientset kubernetes.Interface) *emptyCacheGuards {
+	return &emptyCacheGuards{
+		services: &utils.EmptyCacheGuard{
+			Resource: "services",
+			Count: func(limit int64) (int, error) {
+				services, err := clientset.CoreV1().Services(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{Limit: limit})
+				if err != nil {
+					return 0, err
+				}
+				return len(services.Items), nil
+			},
+		},
+		endpoints: &utils.EmptyCacheGuard{
+			Resource: "endpoints",
+			Count: func(limit int64) (int, error) {
+				endpoints, err := clientset.CoreV1().Endpoints(metav1.NamespaceAll).List(context.Background(),
+					metav1.ListOptions{Limit: limit})
+				if err != nil {
+					return 0, err
+				}
+				return len(endpoints.Items), nil
+			},
+		},
+	}
+}
+
+// checkEmptyCaches returns an error wrapping utils.ErrAPIUnavailable when syncing would remove the IPVS services or
+// the servers of all the services or endpoints of the last sync as their cache emptied, and they are not confirmed
+// deleted. The cached services and endpoints are recorded as synced otherwise.
+func (nsc *NetworkServicesController) checkEmptyCaches() error {
+	if nsc.emptyCacheGuards == nil {
+		return nil
+	}
+	guards := nsc.emptyCacheGuards
+	services, endpoints := len(nsc.svcLister.List()), len(nsc.epLister.List())
+	if err := guards.services.Check(services, guards.syncedServices); err != nil {
+		return err
+	}
+	if err := guards.endpoints.Check(endpoints, guards.syncedEndpoints); err != nil {
+		return err
+	}
+	guards.syncedServices, guards.syncedEndpoints = services, endpoints
+	return nil
+}
@@ -0,0 +1,34 @@
+package routing
+
+import (
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// With --audit-log-file, the routes advertised to and withdrawn from the BGP peers are recorded in the audit log. The
+// syncs advertise the routes again, and withdraw the routes of the services not advertised, so only the changes are
+// recorded: the first advertisement of a route, and its withdrawal once advertised.
+
+const auditController = "NRC"
+
+// auditRoute records the route advertised or withdrawn, unless it already was
+func (nrc *NetworkRoutingController) auditRoute(prefix string, advertised bool) {
+	if nrc.Audit == nil {
+		return
+	}
+	nrc.auditMu.Lock()
+	defer nrc.auditMu.Unlock()
+	if nrc.auditedRoutes == nil {
+		nrc.auditedRoutes = make(map[string]bool)
+	}
+	if nrc.auditedRoutes[prefix] == advertised {
+		return
+	}
+	action := utils.AuditRouteAdvertised
+	if advertised {
+		nrc.auditedRoutes[prefix] = true
+	} else {
+		delete(nrc.auditedRoutes, prefix)
+		action = utils.AuditRouteWithdrawn
+	}
+	nrc.Audit.Record(auditController, action, prefix, map[string]interface{}{"nextHop": nrc.nodeIP.String()})
+}
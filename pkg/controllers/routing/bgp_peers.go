@@ -13,7 +13,6 @@ import (
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/golang/glog"
 	"github.com/osrg/gobgp/config"
-	gobgp "github.com/osrg/gobgp/server"
 	v1core "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/cache"
 )
@@ -37,6 +36,10 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 
 	// get the current list of the nodes from API server
 	nodes := nrc.nodeLister.List()
+	if err := nrc.checkEmptyNodeCache(len(nodes)); err != nil {
+		glog.Errorf("Skipping the sync of the BGP peers: %s", err)
+		return
+	}
 
 	if nrc.MetricsEnabled {
 		metrics.ControllerBPGpeers.Set(float64(len(nodes)))
@@ -154,7 +157,7 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 		}
 
 		// TODO: check if a node is alredy added as nieighbour in a better way than add and catch error
-		if err := nrc.bgpServer.AddNeighbor(n); err != nil {
+		if err := nrc.bgp().AddNeighbor(n); err != nil {
 			if !strings.Contains(err.Error(), "Can't overwrite the existing peer") {
 				glog.Errorf("Failed to add node %s as peer due to %s", nodeIP.String(), err)
 			}
@@ -184,7 +187,7 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 				PeerAs:          nrc.defaultNodeAsnNumber,
 			},
 		}
-		if err := nrc.bgpServer.DeleteNeighbor(n); err != nil {
+		if err := nrc.bgp().DeleteNeighbor(n); err != nil {
 			glog.Errorf("Failed to remove node %s as peer due to %s", ip, err)
 		}
 		delete(nrc.activeNodes, ip)
@@ -192,7 +195,7 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 }
 
 // connectToExternalBGPPeers adds all the configured eBGP peers (global or node specific) as neighbours
-func connectToExternalBGPPeers(server *gobgp.BgpServer, peerNeighbors []*config.Neighbor, bgpGracefulRestart bool, bgpGracefulRestartDeferralTime time.Duration, peerMultihopTtl uint8) error {
+func connectToExternalBGPPeers(server bgpSpeaker, peerNeighbors []*config.Neighbor, bgpGracefulRestart bool, bgpGracefulRestartDeferralTime time.Duration, peerMultihopTtl uint8) error {
 	for _, n := range peerNeighbors {
 
 		if bgpGracefulRestart {
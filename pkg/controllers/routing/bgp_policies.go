@@ -28,9 +28,9 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 			},
 		},
 	})
-	err = nrc.bgpServer.ReplaceDefinedSet(podCidrPrefixSet)
+	err = nrc.bgp().ReplaceDefinedSet(podCidrPrefixSet)
 	if err != nil {
-		nrc.bgpServer.AddDefinedSet(podCidrPrefixSet)
+		nrc.bgp().AddDefinedSet(podCidrPrefixSet)
 	}
 
 	// creates prefix set to represent all the advertisable IP associated with the services
@@ -43,9 +43,9 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		PrefixSetName: "clusteripprefixset",
 		PrefixList:    advIPPrefixList,
 	})
-	err = nrc.bgpServer.ReplaceDefinedSet(clusterIPPrefixSet)
+	err = nrc.bgp().ReplaceDefinedSet(clusterIPPrefixSet)
 	if err != nil {
-		nrc.bgpServer.AddDefinedSet(clusterIPPrefixSet)
+		nrc.bgp().AddDefinedSet(clusterIPPrefixSet)
 	}
 
 	iBGPPeers := make([]string, 0)
@@ -64,9 +64,9 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 			NeighborSetName:  "iBGPpeerset",
 			NeighborInfoList: iBGPPeers,
 		})
-		err := nrc.bgpServer.ReplaceDefinedSet(iBGPPeerNS)
+		err := nrc.bgp().ReplaceDefinedSet(iBGPPeerNS)
 		if err != nil {
-			nrc.bgpServer.AddDefinedSet(iBGPPeerNS)
+			nrc.bgp().AddDefinedSet(iBGPPeerNS)
 		}
 	}
 
@@ -86,9 +86,9 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 			NeighborSetName:  "externalpeerset",
 			NeighborInfoList: externalBgpPeers,
 		})
-		err := nrc.bgpServer.ReplaceDefinedSet(ns)
+		err := nrc.bgp().ReplaceDefinedSet(ns)
 		if err != nil {
-			nrc.bgpServer.AddDefinedSet(ns)
+			nrc.bgp().AddDefinedSet(ns)
 		}
 	}
 
@@ -98,9 +98,9 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		NeighborSetName:  "allpeerset",
 		NeighborInfoList: allBgpPeers,
 	})
-	err = nrc.bgpServer.ReplaceDefinedSet(ns)
+	err = nrc.bgp().ReplaceDefinedSet(ns)
 	if err != nil {
-		nrc.bgpServer.AddDefinedSet(ns)
+		nrc.bgp().AddDefinedSet(ns)
 	}
 
 	err = nrc.addExportPolicies()
@@ -217,7 +217,7 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 	}
 
 	policyAlreadyExists := false
-	policyList := nrc.bgpServer.GetPolicy()
+	policyList := nrc.bgp().GetPolicy()
 	for _, existingPolicy := range policyList {
 		if existingPolicy.Name == "kube_router_export" {
 			policyAlreadyExists = true
@@ -225,14 +225,14 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 	}
 
 	if !policyAlreadyExists {
-		err = nrc.bgpServer.AddPolicy(policy, false)
+		err = nrc.bgp().AddPolicy(policy, false)
 		if err != nil {
 			return errors.New("Failed to add policy: " + err.Error())
 		}
 	}
 
 	policyAssignmentExists := false
-	_, existingPolicyAssignments, err := nrc.bgpServer.GetPolicyAssignment("", table.POLICY_DIRECTION_EXPORT)
+	_, existingPolicyAssignments, err := nrc.bgp().GetPolicyAssignment("", table.POLICY_DIRECTION_EXPORT)
 	if err == nil {
 		for _, existingPolicyAssignment := range existingPolicyAssignments {
 			if existingPolicyAssignment.Name == "kube_router_export" {
@@ -242,7 +242,7 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 	}
 
 	if !policyAssignmentExists {
-		err = nrc.bgpServer.AddPolicyAssignment("",
+		err = nrc.bgp().AddPolicyAssignment("",
 			table.POLICY_DIRECTION_EXPORT,
 			[]*config.PolicyDefinition{&definition},
 			table.ROUTE_TYPE_REJECT)
@@ -251,7 +251,7 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 		}
 	} else {
 		// configure default BGP export policy to reject
-		err = nrc.bgpServer.ReplacePolicyAssignment("",
+		err = nrc.bgp().ReplacePolicyAssignment("",
 			table.POLICY_DIRECTION_EXPORT,
 			[]*config.PolicyDefinition{&definition},
 			table.ROUTE_TYPE_REJECT)
@@ -293,7 +293,7 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 	}
 
 	policyAlreadyExists := false
-	policyList := nrc.bgpServer.GetPolicy()
+	policyList := nrc.bgp().GetPolicy()
 	for _, existingPolicy := range policyList {
 		if existingPolicy.Name == "kube_router_import" {
 			policyAlreadyExists = true
@@ -301,14 +301,14 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 	}
 
 	if !policyAlreadyExists {
-		err = nrc.bgpServer.AddPolicy(policy, false)
+		err = nrc.bgp().AddPolicy(policy, false)
 		if err != nil {
 			return errors.New("Failed to add policy: " + err.Error())
 		}
 	}
 
 	policyAssignmentExists := false
-	_, existingPolicyAssignments, err := nrc.bgpServer.GetPolicyAssignment("", table.POLICY_DIRECTION_IMPORT)
+	_, existingPolicyAssignments, err := nrc.bgp().GetPolicyAssignment("", table.POLICY_DIRECTION_IMPORT)
 	if err == nil {
 		for _, existingPolicyAssignment := range existingPolicyAssignments {
 			if existingPolicyAssignment.Name == "kube_router_import" {
@@ -319,7 +319,7 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 
 	// Default policy is to accept
 	if !policyAssignmentExists {
-		err = nrc.bgpServer.AddPolicyAssignment("",
+		err = nrc.bgp().AddPolicyAssignment("",
 			table.POLICY_DIRECTION_IMPORT,
 			[]*config.PolicyDefinition{&definition},
 			table.ROUTE_TYPE_ACCEPT)
@@ -327,7 +327,7 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 			return errors.New("Failed to add policy assignment: " + err.Error())
 		}
 	} else {
-		err = nrc.bgpServer.ReplacePolicyAssignment("",
+		err = nrc.bgp().ReplacePolicyAssignment("",
 			table.POLICY_DIRECTION_IMPORT,
 			[]*config.PolicyDefinition{&definition},
 			table.ROUTE_TYPE_ACCEPT)
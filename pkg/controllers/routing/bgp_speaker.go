@@ -0,0 +1,75 @@
+package routing
+
+import (
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+	"github.com/osrg/gobgp/config"
+	"github.com/osrg/gobgp/packet/bgp"
+	gobgp "github.com/osrg/gobgp/server"
+	"github.com/osrg/gobgp/table"
+)
+
+// bgpSpeaker is the part of the gobgp server the controller configures. It is the gobgp server of the controller, or
+// with --bgp-speaker-process the gobgp server of the BGP speaker process, reached through its gRPC API.
+type bgpSpeaker interface {
+	Start(global *config.Global) error
+	Stop() error
+	Shutdown()
+	AddPath(vrfID string, pathList []*table.Path) ([]byte, error)
+	DeletePath(uuid []byte, family bgp.RouteFamily, vrfID string, pathList []*table.Path) error
+	AddNeighbor(neighbor *config.Neighbor) error
+	DeleteNeighbor(neighbor *config.Neighbor) error
+	AddDefinedSet(set table.DefinedSet) error
+	ReplaceDefinedSet(set table.DefinedSet) error
+	GetPolicy() []*config.PolicyDefinition
+	AddPolicy(policy *table.Policy, refer bool) error
+	GetPolicyAssignment(name string, dir table.PolicyDirection) (table.RouteType, []*config.PolicyDefinition, error)
+	AddPolicyAssignment(name string, dir table.PolicyDirection, policies []*config.PolicyDefinition,
+		def table.RouteType) error
+	ReplacePolicyAssignment(name string, dir table.PolicyDirection, policies []*config.PolicyDefinition,
+		def table.RouteType) error
+}
+
+var _ bgpSpeaker = &gobgp.BgpServer{}
+
+// bgp returns the BGP speaker of the node
+func (nrc *NetworkRoutingController) bgp() bgpSpeaker {
+	if nrc.remoteSpeaker != nil {
+		return nrc.remoteSpeaker
+	}
+	return nrc.bgpServer
+}
+
+// watchBgpUpdates injects the routes of the best paths the BGP speaker learns from its peers
+func (nrc *NetworkRoutingController) watchBgpUpdates() {
+	if nrc.remoteSpeaker != nil {
+		nrc.remoteSpeaker.watchBestPaths(nrc.injectBestPaths)
+		return
+	}
+	watcher := nrc.bgpServer.Watch(gobgp.WatchBestPath(false))
+	for {
+		select {
+		case ev := <-watcher.Event():
+			switch msg := ev.(type) {
+			case *gobgp.WatchEventBestPath:
+				nrc.injectBestPaths(msg.PathList)
+			}
+		}
+	}
+}
+
+func (nrc *NetworkRoutingController) injectBestPaths(paths []*table.Path) {
+	glog.V(3).Info("Processing bgp route advertisement from peer")
+	if nrc.MetricsEnabled {
+		metrics.ControllerBGPadvertisementsReceived.Inc()
+	}
+	for _, path := range paths {
+		if path.IsLocal() {
+			continue
+		}
+		if err := nrc.injectRoute(path); err != nil {
+			glog.Errorf("Failed to inject routes due to: %s", err)
+			continue
+		}
+	}
+}
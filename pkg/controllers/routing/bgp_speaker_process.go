@@ -0,0 +1,333 @@
+package routing
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+	bgpapi "github.com/osrg/gobgp/api"
+	gobgpclient "github.com/osrg/gobgp/client"
+	"github.com/osrg/gobgp/config"
+	"github.com/osrg/gobgp/packet/bgp"
+	gobgp "github.com/osrg/gobgp/server"
+	"github.com/osrg/gobgp/table"
+	"google.golang.org/grpc"
+)
+
+// With --bgp-speaker-process the gobgp server of the node runs in a child process of kube-router, the BGP speaker
+// process, so a panic or a memory spike in the BGP handling takes down the BGP sessions of the node only, not the
+// enforcement of the network policies and the services. kube-router configures the speaker through its gRPC API, the
+// one the gobgp CLI uses, and restarts it whenever it exits. The speaker starts without any state, so once restarted
+// the routing controller configures it again: its global configuration, peers, policies and advertised routes.
+const (
+	// BgpSpeakerCommand is the kube-router command running the BGP speaker process
+	BgpSpeakerCommand = "bgp-speaker"
+
+	bgpSpeakerReadyTimeout = 30 * time.Second
+	bgpSpeakerRestartDelay = 5 * time.Second
+	bgpSpeakerStopTimeout  = 10 * time.Second
+)
+
+// RunBgpSpeaker runs the gobgp server of the BGP speaker process, serving its gRPC API on the comma separated hosts,
+// until it is signaled to stop
+func RunBgpSpeaker(grpcHosts string) error {
+	server := gobgp.NewBgpServer()
+	go server.Serve()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- bgpapi.NewGrpcServer(server, grpcHosts).Serve()
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("BGP speaker gRPC server failed: %s", err)
+	case sig := <-signals:
+		glog.Infof("Shutting down the BGP speaker on %s", sig)
+		server.Shutdown()
+		return nil
+	}
+}
+
+// bgpSpeakerGrpcAddress returns the address kube-router connects to the gRPC API of the BGP speaker process on,
+// the loopback one of the comma separated hosts the API is served on, or the first one
+func bgpSpeakerGrpcAddress(grpcHosts string) string {
+	hosts := strings.Split(grpcHosts, ",")
+	for _, host := range hosts {
+		if ip, _, err := net.SplitHostPort(host); err == nil && net.ParseIP(ip).IsLoopback() {
+			return host
+		}
+	}
+	return hosts[0]
+}
+
+// remoteBgpSpeaker is the BGP speaker process, supervised by kube-router and configured through its gRPC API
+type remoteBgpSpeaker struct {
+	client    *gobgpclient.Client
+	grpcHosts string
+	// configures the speaker again once it is restarted
+	restore func()
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	stopped bool
+}
+
+// startBgpSpeakerProcess starts the BGP speaker process serving its gRPC API on the comma separated hosts, and the
+// supervision restarting it whenever it exits
+func startBgpSpeakerProcess(grpcHosts string, restore func()) (*remoteBgpSpeaker, error) {
+	client, err := gobgpclient.New(bgpSpeakerGrpcAddress(grpcHosts), grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the BGP speaker process: %s", err)
+	}
+	s := &remoteBgpSpeaker{client: client, grpcHosts: grpcHosts, restore: restore}
+	if err := s.start(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	go s.supervise()
+	return s, nil
+}
+
+// start runs the BGP speaker process and waits for its gRPC API to be served
+func (s *remoteBgpSpeaker) start() error {
+	args := []string{BgpSpeakerCommand}
+	if v := flag.Lookup("v"); v != nil {
+		args = append(args, "--v="+v.Value.String())
+	}
+	cmd := exec.Command("/proc/self/exe", append(args, s.grpcHosts)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// the speaker dies with kube-router, like the gobgp server running in kube-router would, which leaves the routes
+	// to the graceful restart of the peers
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the BGP speaker process: %s", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	s.mu.Lock()
+	s.cmd = cmd
+	s.exited = exited
+	s.mu.Unlock()
+
+	deadline := time.Now().Add(bgpSpeakerReadyTimeout)
+	for {
+		_, err := s.client.GetServer()
+		if err == nil {
+			glog.Infof("Started the BGP speaker process, pid %d", cmd.Process.Pid)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			<-exited
+			return fmt.Errorf("BGP speaker process not serving its gRPC API after %s: %s", bgpSpeakerReadyTimeout, err)
+		}
+		select {
+		case <-exited:
+			return errors.New("BGP speaker process exited on start, " + cmd.ProcessState.String())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// supervise restarts the BGP speaker process whenever it exits, until it is shut down
+func (s *remoteBgpSpeaker) supervise() {
+	for {
+		s.mu.Lock()
+		cmd, exited := s.cmd, s.exited
+		s.mu.Unlock()
+		<-exited
+		if s.isStopped() {
+			return
+		}
+		glog.Errorf("BGP speaker process exited, %s. Restarting it", cmd.ProcessState.String())
+		metrics.ControllerBGPSpeakerRestarts.Inc()
+		for {
+			time.Sleep(bgpSpeakerRestartDelay)
+			if s.isStopped() {
+				return
+			}
+			err := s.start()
+			if err == nil {
+				break
+			}
+			glog.Errorf("Failed to restart the BGP speaker process: %s", err)
+		}
+		s.restore()
+	}
+}
+
+func (s *remoteBgpSpeaker) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// watchBestPaths passes the best paths the BGP speaker process selects to inject, watching them again whenever the
+// process restarts
+func (s *remoteBgpSpeaker) watchBestPaths(inject func([]*table.Path)) {
+	for !s.isStopped() {
+		rib, err := s.client.MonitorRIB(0, false)
+		for err == nil {
+			var destination *table.Destination
+			destination, err = rib.Recv()
+			if err == nil {
+				inject(destination.GetAllKnownPathList())
+			}
+		}
+		if !s.isStopped() {
+			glog.Errorf("Failed to watch the best paths of the BGP speaker process, retrying: %s", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func (s *remoteBgpSpeaker) Start(global *config.Global) error {
+	return s.client.StartServer(global)
+}
+
+func (s *remoteBgpSpeaker) Stop() error {
+	return s.client.StopServer()
+}
+
+// Shutdown shuts the BGP speaker process down, once it closed its BGP sessions
+func (s *remoteBgpSpeaker) Shutdown() {
+	s.mu.Lock()
+	s.stopped = true
+	cmd, exited := s.cmd, s.exited
+	s.mu.Unlock()
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(bgpSpeakerStopTimeout):
+		glog.Errorf("BGP speaker process not stopped after %s, killing it", bgpSpeakerStopTimeout)
+		cmd.Process.Kill()
+	}
+	s.client.Close()
+}
+
+func (s *remoteBgpSpeaker) AddPath(vrfID string, pathList []*table.Path) ([]byte, error) {
+	if vrfID != "" {
+		return s.client.AddVRFPath(vrfID, pathList)
+	}
+	return s.client.AddPath(pathList)
+}
+
+func (s *remoteBgpSpeaker) DeletePath(uuid []byte, family bgp.RouteFamily, vrfID string, pathList []*table.Path) error {
+	switch {
+	case len(uuid) > 0:
+		return s.client.DeletePathByUUID(uuid)
+	case len(pathList) == 0:
+		return s.client.DeletePathByFamily(family)
+	case vrfID != "":
+		return s.client.DeleteVRFPath(vrfID, pathList)
+	default:
+		return s.client.DeletePath(pathList)
+	}
+}
+
+func (s *remoteBgpSpeaker) AddNeighbor(neighbor *config.Neighbor) error {
+	return s.client.AddNeighbor(neighbor)
+}
+
+func (s *remoteBgpSpeaker) DeleteNeighbor(neighbor *config.Neighbor) error {
+	return s.client.DeleteNeighbor(neighbor)
+}
+
+func (s *remoteBgpSpeaker) AddDefinedSet(set table.DefinedSet) error {
+	return s.client.AddDefinedSet(set)
+}
+
+func (s *remoteBgpSpeaker) ReplaceDefinedSet(set table.DefinedSet) error {
+	return s.client.ReplaceDefinedSet(set)
+}
+
+func (s *remoteBgpSpeaker) GetPolicy() []*config.PolicyDefinition {
+	policies, err := s.client.GetPolicy()
+	if err != nil {
+		glog.Errorf("Failed to get the policies of the BGP speaker process: %s", err)
+		return nil
+	}
+	return policyDefinitions(policies)
+}
+
+func (s *remoteBgpSpeaker) AddPolicy(policy *table.Policy, refer bool) error {
+	return s.client.AddPolicy(policy, refer)
+}
+
+func (s *remoteBgpSpeaker) GetPolicyAssignment(name string, dir table.PolicyDirection) (table.RouteType,
+	[]*config.PolicyDefinition, error) {
+	var assignment *table.PolicyAssignment
+	var err error
+	switch {
+	case dir == table.POLICY_DIRECTION_IMPORT && name == "":
+		assignment, err = s.client.GetImportPolicy()
+	case dir == table.POLICY_DIRECTION_IMPORT:
+		assignment, err = s.client.GetRouteServerImportPolicy(name)
+	case name == "":
+		assignment, err = s.client.GetExportPolicy()
+	default:
+		assignment, err = s.client.GetRouteServerExportPolicy(name)
+	}
+	if err != nil {
+		return table.ROUTE_TYPE_NONE, nil, err
+	}
+	return assignment.Default, policyDefinitions(assignment.Policies), nil
+}
+
+func (s *remoteBgpSpeaker) AddPolicyAssignment(name string, dir table.PolicyDirection,
+	policies []*config.PolicyDefinition, def table.RouteType) error {
+	assignment, err := newPolicyAssignment(name, dir, policies, def)
+	if err != nil {
+		return err
+	}
+	return s.client.AddPolicyAssignment(assignment)
+}
+
+func (s *remoteBgpSpeaker) ReplacePolicyAssignment(name string, dir table.PolicyDirection,
+	policies []*config.PolicyDefinition, def table.RouteType) error {
+	assignment, err := newPolicyAssignment(name, dir, policies, def)
+	if err != nil {
+		return err
+	}
+	return s.client.ReplacePolicyAssignment(assignment)
+}
+
+func policyDefinitions(policies []*table.Policy) []*config.PolicyDefinition {
+	definitions := make([]*config.PolicyDefinition, 0, len(policies))
+	for _, policy := range policies {
+		definitions = append(definitions, policy.ToConfig())
+	}
+	return definitions
+}
+
+func newPolicyAssignment(name string, dir table.PolicyDirection, definitions []*config.PolicyDefinition,
+	def table.RouteType) (*table.PolicyAssignment, error) {
+	policies := make([]*table.Policy, 0, len(definitions))
+	for _, definition := range definitions {
+		policy, err := table.NewPolicy(*definition)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return &table.PolicyAssignment{Name: name, Type: dir, Policies: policies, Default: def}, nil
+}
@@ -0,0 +1,116 @@
+package routing
+
+import (
+	"net"
+	"testing"
+
+	bgpapi "github.com/osrg/gobgp/api"
+	gobgpclient "github.com/osrg/gobgp/client"
+	"github.com/osrg/gobgp/config"
+	gobgp "github.com/osrg/gobgp/server"
+	"github.com/osrg/gobgp/table"
+	"google.golang.org/grpc"
+)
+
+func Test_remoteBgpSpeakerPolicies(t *testing.T) {
+	server := gobgp.NewBgpServer()
+	go server.Serve()
+	defer server.Stop()
+	// the gRPC API listens on a free port, served here so the listener is closed along with it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen for the gRPC API of the BGP server: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	bgpapi.NewServer(server, grpcServer, listener.Addr().String())
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	client, err := gobgpclient.New(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to the gRPC API of the BGP server: %v", err)
+	}
+	speaker := &remoteBgpSpeaker{client: client}
+	defer client.Close()
+
+	err = speaker.Start(&config.Global{
+		Config: config.GlobalConfig{
+			As:       1,
+			RouterId: "10.0.0.0",
+			// no BGP listener, the speaker is configured through the gRPC API only
+			Port: -1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer speaker.Stop()
+
+	prefixSet, err := table.NewPrefixSet(config.PrefixSet{
+		PrefixSetName: "podcidrprefixset",
+		PrefixList:    []config.Prefix{{IpPrefix: "172.20.0.0/24", MasklengthRange: "24..24"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the prefix set: %v", err)
+	}
+	if err := speaker.AddDefinedSet(prefixSet); err != nil {
+		t.Fatalf("failed to add the prefix set: %v", err)
+	}
+
+	definition := config.PolicyDefinition{
+		Name: "kube_router_export",
+		Statements: []config.Statement{
+			{
+				Name: "kube_router_export_stmt0",
+				Conditions: config.Conditions{
+					MatchPrefixSet: config.MatchPrefixSet{PrefixSet: "podcidrprefixset"},
+				},
+				Actions: config.Actions{RouteDisposition: config.ROUTE_DISPOSITION_ACCEPT_ROUTE},
+			},
+		},
+	}
+	policy, err := table.NewPolicy(definition)
+	if err != nil {
+		t.Fatalf("failed to create the policy: %v", err)
+	}
+	if err := speaker.AddPolicy(policy, false); err != nil {
+		t.Fatalf("failed to add the policy: %v", err)
+	}
+	policies := speaker.GetPolicy()
+	if len(policies) != 1 || policies[0].Name != definition.Name {
+		t.Fatalf("expected the policy %s but got %v", definition.Name, policies)
+	}
+
+	err = speaker.AddPolicyAssignment("", table.POLICY_DIRECTION_EXPORT, policies, table.ROUTE_TYPE_REJECT)
+	if err != nil {
+		t.Fatalf("failed to assign the policy: %v", err)
+	}
+	def, assigned, err := speaker.GetPolicyAssignment("", table.POLICY_DIRECTION_EXPORT)
+	if err != nil {
+		t.Fatalf("failed to get the policy assignment: %v", err)
+	}
+	if def != table.ROUTE_TYPE_REJECT || len(assigned) != 1 || assigned[0].Name != definition.Name {
+		t.Errorf("expected the policy %s assigned with reject by default but got %v with %v", definition.Name,
+			assigned, def)
+	}
+	if _, assigned, _ = speaker.GetPolicyAssignment("", table.POLICY_DIRECTION_IMPORT); len(assigned) != 0 {
+		t.Errorf("expected no import policy but got %v", assigned)
+	}
+}
+
+func Test_bgpSpeakerGrpcAddress(t *testing.T) {
+	testcases := []struct {
+		grpcHosts string
+		address   string
+	}{
+		{"10.0.0.1:50051,127.0.0.1:50051", "127.0.0.1:50051"},
+		{"[fd00::1]:50052,[::1]:50052", "[::1]:50052"},
+		{"10.0.0.1:50053", "10.0.0.1:50053"},
+	}
+	for _, testcase := range testcases {
+		if address := bgpSpeakerGrpcAddress(testcase.grpcHosts); address != testcase.address {
+			t.Errorf("expected the address %s for the hosts %s, got %s", testcase.address, testcase.grpcHosts,
+				address)
+		}
+	}
+}
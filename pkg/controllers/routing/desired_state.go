@@ -0,0 +1,133 @@
+package routing
+
+import (
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// RenderDesiredState renders the routes to the pod CIDRs of the other nodes in the cluster, and to the remote
+// clusters when federation is enabled, the controller would inject, without modifying the node. Unlike the
+// other controllers it does not need a controller instance, since creating one already sets up ipsets on
+// the node.
+func RenderDesiredState(clientset kubernetes.Interface, config *options.KubeRouterConfig,
+	nodeInformer cache.SharedIndexInformer) (nodestate.State, error) {
+	node, err := utils.GetNodeObject(clientset, config.HostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+	nodeIP, err := utils.GetNodeIP(node)
+	if err != nil {
+		return nil, err
+	}
+	nodeSubnet, _, err := getNodeSubnet(nodeIP)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*v1core.Node, 0)
+	for _, obj := range nodeInformer.GetIndexer().List() {
+		nodes = append(nodes, obj.(*v1core.Node))
+	}
+
+	state := make(nodestate.State)
+	state.Add(nodestate.Routes, renderPodCIDRRoutes(nodes, node.Name, nodeSubnet,
+		config.EnableOverlay, config.OverlayType, config.OverrideNextHop)...)
+
+	if config.EnableClusterFederation {
+		clusters, err := crd.ListRemoteClusters(clientset)
+		if err != nil {
+			return nil, err
+		}
+		valid := make([]crd.RemoteCluster, 0, len(clusters))
+		for i := range clusters {
+			if clusters[i].Validate() == nil {
+				valid = append(valid, clusters[i])
+			}
+		}
+		state.Add(nodestate.Routes, renderRemoteClusterRoutes(valid, nodeSubnet,
+			config.EnableOverlay, config.OverlayType, config.OverrideNextHop)...)
+	}
+	return state, nil
+}
+
+func (nrc *NetworkRoutingController) renderState() nodestate.State {
+	nodes := make([]*v1core.Node, 0)
+	for _, obj := range nrc.nodeLister.List() {
+		nodes = append(nodes, obj.(*v1core.Node))
+	}
+
+	state := make(nodestate.State)
+	state.Add(nodestate.Routes, renderPodCIDRRoutes(nodes, nrc.nodeName, nrc.nodeSubnet,
+		nrc.enableOverlays, nrc.overlayType, nrc.overrideNextHop)...)
+	state.Add(nodestate.Routes, renderRemoteClusterRoutes(nrc.remoteClusters, nrc.nodeSubnet,
+		nrc.enableOverlays, nrc.overlayType, nrc.overrideNextHop)...)
+	return state
+}
+
+// renderPodCIDRRoutes mirrors the decisions taken by injectRoute for the pod CIDR advertised by each node
+func renderPodCIDRRoutes(nodes []*v1core.Node, localNodeName string, nodeSubnet net.IPNet,
+	enableOverlays bool, overlayType string, overrideNextHop bool) []string {
+	routes := make([]string, 0)
+	for _, node := range nodes {
+		if node.Name == localNodeName || node.Spec.PodCIDR == "" {
+			continue
+		}
+		nexthop, err := utils.GetNodeIP(node)
+		if err != nil {
+			continue
+		}
+		if route, ok := renderRoute(node.Spec.PodCIDR, nexthop, nodeSubnet, enableOverlays, overlayType, overrideNextHop); ok {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// renderRoute mirrors the decisions taken by injectRouteVia for the route to dst via nexthop. It returns
+// false when no route is injected.
+func renderRoute(dst string, nexthop net.IP, nodeSubnet net.IPNet, enableOverlays bool, overlayType string,
+	overrideNextHop bool) (string, bool) {
+	sameSubnet := nodeSubnet.Contains(nexthop)
+	if (!sameSubnet || overlayType == "full") && !overrideNextHop && enableOverlays {
+		return nodestate.Route(dst, "", generateTunnelName(nexthop.String())), true
+	} else if sameSubnet {
+		return nodestate.Route(dst, nexthop.String(), ""), true
+	}
+	return "", false
+}
+
+// ReadActualState reads the routes injected by kube-router found on the node, rendered the same way as
+// RenderDesiredState.
+func ReadActualState() (nodestate.State, error) {
+	state := make(nodestate.State)
+
+	routes, err := netlink.RouteListFiltered(nl.FAMILY_V4, &netlink.Route{Protocol: 0x11}, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		if route.Dst == nil {
+			continue
+		}
+		if route.Gw != nil {
+			state.Add(nodestate.Routes, nodestate.Route(route.Dst.String(), route.Gw.String(), ""))
+			continue
+		}
+		dev := ""
+		if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil {
+			dev = link.Attrs().Name
+		}
+		state.Add(nodestate.Routes, nodestate.Route(route.Dst.String(), "", dev))
+	}
+
+	return state, nil
+}
@@ -0,0 +1,68 @@
+package routing
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_renderPodCIDRRoutes(t *testing.T) {
+	newNode := func(name, ip, podCIDR string) *v1core.Node {
+		return &v1core.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1core.NodeSpec{PodCIDR: podCIDR},
+			Status: v1core.NodeStatus{
+				Addresses: []v1core.NodeAddress{{Type: v1core.NodeInternalIP, Address: ip}},
+			},
+		}
+	}
+	nodes := []*v1core.Node{
+		newNode("node-1", "10.0.0.1", "172.20.1.0/24"),
+		newNode("node-2", "10.0.0.2", "172.20.2.0/24"),
+		newNode("node-3", "10.1.0.3", "172.20.3.0/24"),
+		newNode("node-4", "10.0.0.4", ""),
+	}
+	_, nodeSubnet, _ := net.ParseCIDR("10.0.0.0/24")
+
+	testcases := []struct {
+		name            string
+		enableOverlays  bool
+		overlayType     string
+		overrideNextHop bool
+		expected        []string
+	}{
+		{
+			"overlay disabled",
+			false, "subnet", false,
+			[]string{"172.20.2.0/24 via 10.0.0.2"},
+		},
+		{
+			"subnet overlay",
+			true, "subnet", false,
+			[]string{"172.20.2.0/24 via 10.0.0.2", "172.20.3.0/24 dev tun-10103"},
+		},
+		{
+			"full overlay",
+			true, "full", false,
+			[]string{"172.20.2.0/24 dev tun-10002", "172.20.3.0/24 dev tun-10103"},
+		},
+		{
+			"override next hop",
+			true, "full", true,
+			[]string{"172.20.2.0/24 via 10.0.0.2"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			routes := renderPodCIDRRoutes(nodes, "node-1", *nodeSubnet,
+				testcase.enableOverlays, testcase.overlayType, testcase.overrideNextHop)
+			if !reflect.DeepEqual(routes, testcase.expected) {
+				t.Errorf("expected routes %v but got %v", testcase.expected, routes)
+			}
+		})
+	}
+}
@@ -25,8 +25,11 @@ func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string) error {
 
 	glog.V(2).Infof("Advertising route: '%s/%s via %s' to peers", vip, strconv.Itoa(32), nrc.nodeIP.String())
 
-	_, err := nrc.bgpServer.AddPath("", []*table.Path{table.NewPath(nil, bgp.NewIPAddrPrefix(uint8(32),
+	_, err := nrc.bgp().AddPath("", []*table.Path{table.NewPath(nil, bgp.NewIPAddrPrefix(uint8(32),
 		vip), false, attrs, time.Now(), false)})
+	if err == nil {
+		nrc.auditRoute(vip+"/32", true)
+	}
 
 	return err
 }
@@ -38,7 +41,10 @@ func (nrc *NetworkRoutingController) bgpWithdrawVIP(vip string) error {
 	pathList := []*table.Path{table.NewPath(nil, bgp.NewIPAddrPrefix(uint8(32),
 		vip), true, nil, time.Now(), false)}
 
-	err := nrc.bgpServer.DeletePath([]byte(nil), 0, "", pathList)
+	err := nrc.bgp().DeletePath([]byte(nil), 0, "", pathList)
+	if err == nil {
+		nrc.auditRoute(vip+"/32", false)
+	}
 
 	return err
 }
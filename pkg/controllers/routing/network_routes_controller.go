@@ -6,15 +6,16 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/nodestate"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/coreos/go-iptables/iptables"
@@ -55,6 +56,7 @@ const (
 	svcAdvertiseClusterAnnotation      = "kube-router.io/service.advertise.clusterip"
 	svcAdvertiseExternalAnnotation     = "kube-router.io/service.advertise.externalip"
 	svcAdvertiseLoadBalancerAnnotation = "kube-router.io/service.advertise.loadbalancerip"
+	podEgressSNATPortRangeAnnotation   = "kube-router.io/pod-egress.snat-port-range"
 	LeaderElectionRecordAnnotationKey  = "control-plane.alpha.kubernetes.io/leader"
 
 	// Deprecated: use kube-router.io/service.advertise.loadbalancer instead
@@ -72,10 +74,15 @@ type NetworkRoutingController struct {
 	activeNodes                    map[string]bool
 	mu                             sync.Mutex
 	clientset                      kubernetes.Interface
+	emptyNodeCacheGuard            *emptyNodeCacheGuard
 	bgpServer                      *gobgp.BgpServer
+	bgpSpeakerProcess              bool
+	remoteSpeaker                  *remoteBgpSpeaker
+	bgpGlobal                      *config.Global
 	syncPeriod                     time.Duration
 	clusterCIDR                    string
 	enablePodEgress                bool
+	podEgressSNATPortRange         string
 	hostnameOverride               string
 	advertiseClusterIP             bool
 	advertiseExternalIP            bool
@@ -110,6 +117,25 @@ type NetworkRoutingController struct {
 	localAddressList               []string
 	overrideNextHop                bool
 	podCidr                        string
+	postSyncHook                   *utils.PostSyncHook
+	appliedStateCache              *nodestate.AppliedStateCache
+	enableClusterFederation        bool
+	remoteClusterInformer          cache.SharedIndexInformer
+	remoteClusters                 []crd.RemoteCluster
+	remoteClusterRoutes            map[string]net.IP
+
+	// stretches the periodic sync period while the node is overloaded, nil if disabled
+	Governor *utils.LoadGovernor
+	// runs the syncs of the controllers one at a time and staggers their periodic syncs, nil if disabled
+	Coordinator *utils.SyncCoordinator
+	// serializes the periodic syncs with the restore of the restarted BGP speaker process, which are not serialized
+	// without the sync coordinator
+	syncMu sync.Mutex
+	// records the routes advertised and withdrawn, nil if disabled
+	Audit *utils.AuditLog
+	// routes recorded as advertised
+	auditMu       sync.Mutex
+	auditedRoutes map[string]bool
 
 	nodeLister cache.Indexer
 	svcLister  cache.Indexer
@@ -205,7 +231,7 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 	}
 
 	// enable netfilter for the bridge
-	if _, err := exec.Command("modprobe", "br_netfilter").CombinedOutput(); err != nil {
+	if _, err := utils.Exec("modprobe", "br_netfilter"); err != nil {
 		glog.Errorf("Failed to enable netfilter for bridge. Network policies and service proxy may not work: %s", err.Error())
 	}
 	if err = ioutil.WriteFile("/proc/sys/net/bridge/bridge-nf-call-iptables", []byte(strconv.Itoa(1)), 0640); err != nil {
@@ -218,12 +244,24 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 
 	}
 
-	t := time.NewTicker(nrc.syncPeriod)
+	t := nrc.Coordinator.NewTicker("NRC", nrc.syncPeriod)
 	defer t.Stop()
 	defer wg.Done()
+	var informers sync.WaitGroup
+	defer informers.Wait()
 
 	glog.Infof("Starting network route controller")
 
+	nrc.appliedStateCache.ReportDrift(ReadActualState)
+
+	if nrc.remoteClusterInformer != nil {
+		informers.Add(1)
+		go func() {
+			defer informers.Done()
+			nrc.remoteClusterInformer.Run(stopCh)
+		}()
+	}
+
 	// Wait till we are ready to launch BGP server
 	for {
 		err := nrc.startBgpServer()
@@ -244,7 +282,7 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 
 	nrc.bgpServerStarted = true
 	if !nrc.bgpGracefulRestart {
-		defer nrc.bgpServer.Shutdown()
+		defer nrc.bgp().Shutdown()
 	}
 
 	// loop forever till notified to stop on stopCh
@@ -256,6 +294,16 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 			return
 		default:
 		}
+		release := nrc.Coordinator.Acquire("NRC", utils.SyncPeriodic)
+		nrc.syncMu.Lock()
+		syncStart := time.Now()
+
+		if nrc.enableClusterFederation {
+			glog.V(1).Info("Syncing routes to remote clusters")
+			if err := nrc.syncRemoteClusters(); err != nil {
+				glog.Errorf("Error syncing remote clusters: %s", err.Error())
+			}
+		}
 
 		// Update ipset entries
 		if nrc.enablePodEgress || nrc.enableOverlays {
@@ -299,16 +347,31 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 
 		if err == nil {
 			healthcheck.SendHeartBeat(healthChan, "NRC")
+			nrc.postSyncHook.Run(utils.SyncSummary{
+				Controller: "NRC",
+				Node:       nrc.nodeName,
+				Timestamp:  time.Now(),
+				Duration:   time.Since(syncStart).Seconds(),
+				Details: map[string]interface{}{
+					"activeNodes":    len(nrc.activeNodes),
+					"advertisedVIPs": len(toAdvertise),
+					"withdrawnVIPs":  len(toWithdraw),
+				},
+			})
+			if err := nrc.appliedStateCache.Save(nrc.renderState()); err != nil {
+				glog.Errorf("Failed to persist the applied state: %s", err.Error())
+			}
 		} else {
 			glog.Errorf("Error during periodic sync in network routing controller. Error: " + err.Error())
 			glog.Errorf("Skipping sending heartbeat from network routing controller as periodic sync failed.")
+			healthcheck.SendError(healthChan, "NRC", utils.CountError("NRC", err), err)
 		}
+		nrc.syncMu.Unlock()
+		release()
 
-		select {
-		case <-stopCh:
+		if !nrc.Governor.WaitTick(t.C, stopCh) {
 			glog.Infof("Shutting down network routes controller")
 			return
-		case <-t.C:
 		}
 	}
 }
@@ -335,31 +398,6 @@ func (nrc *NetworkRoutingController) updateCNIConfig() {
 	}
 }
 
-func (nrc *NetworkRoutingController) watchBgpUpdates() {
-	watcher := nrc.bgpServer.Watch(gobgp.WatchBestPath(false))
-	for {
-		select {
-		case ev := <-watcher.Event():
-			switch msg := ev.(type) {
-			case *gobgp.WatchEventBestPath:
-				glog.V(3).Info("Processing bgp route advertisement from peer")
-				if nrc.MetricsEnabled {
-					metrics.ControllerBGPadvertisementsReceived.Inc()
-				}
-				for _, path := range msg.PathList {
-					if path.IsLocal() {
-						continue
-					}
-					if err := nrc.injectRoute(path); err != nil {
-						glog.Errorf("Failed to inject routes due to: " + err.Error())
-						continue
-					}
-				}
-			}
-		}
-	}
-}
-
 func (nrc *NetworkRoutingController) advertisePodRoute() error {
 	if nrc.MetricsEnabled {
 		metrics.ControllerBGPadvertisementsSent.Inc()
@@ -380,10 +418,11 @@ func (nrc *NetworkRoutingController) advertisePodRoute() error {
 
 		glog.V(2).Infof("Advertising route: '%s/%s via %s' to peers using attribute: %+q", subnet, strconv.Itoa(cidrLen), nrc.nodeIP.String(), attrs)
 
-		if _, err := nrc.bgpServer.AddPath("", []*table.Path{table.NewPath(nil, bgp.NewIPv6AddrPrefix(uint8(cidrLen),
+		if _, err := nrc.bgp().AddPath("", []*table.Path{table.NewPath(nil, bgp.NewIPv6AddrPrefix(uint8(cidrLen),
 			subnet), false, attrs, time.Now(), false)}); err != nil {
 			return fmt.Errorf(err.Error())
 		}
+		nrc.auditRoute(nrc.podCidr, true)
 	} else {
 		attrs := []bgp.PathAttributeInterface{
 			bgp.NewPathAttributeOrigin(0),
@@ -392,18 +431,23 @@ func (nrc *NetworkRoutingController) advertisePodRoute() error {
 
 		glog.V(2).Infof("Advertising route: '%s/%s via %s' to peers", subnet, strconv.Itoa(cidrLen), nrc.nodeIP.String())
 
-		if _, err := nrc.bgpServer.AddPath("", []*table.Path{table.NewPath(nil, bgp.NewIPAddrPrefix(uint8(cidrLen),
+		if _, err := nrc.bgp().AddPath("", []*table.Path{table.NewPath(nil, bgp.NewIPAddrPrefix(uint8(cidrLen),
 			subnet), false, attrs, time.Now(), false)}); err != nil {
 			return fmt.Errorf(err.Error())
 		}
+		nrc.auditRoute(nrc.podCidr, true)
 	}
 	return nil
 }
 
 func (nrc *NetworkRoutingController) injectRoute(path *table.Path) error {
-	nexthop := path.GetNexthop()
-	nlri := path.GetNlri()
-	dst, _ := netlink.ParseIPNet(nlri.String())
+	dst, _ := netlink.ParseIPNet(path.GetNlri().String())
+	return nrc.injectRouteVia(dst, path.GetNexthop(), path.IsWithdraw)
+}
+
+// injectRouteVia injects, or removes when withdraw is set, the route to dst via nexthop, through an ip-in-ip
+// tunnel when overlays are enabled and nexthop is not in the subnet of the node
+func (nrc *NetworkRoutingController) injectRouteVia(dst *net.IPNet, nexthop net.IP, withdraw bool) error {
 	var route *netlink.Route
 
 	tunnelName := generateTunnelName(nexthop.String())
@@ -442,12 +486,12 @@ func (nrc *NetworkRoutingController) injectRoute(path *table.Path) error {
 		var err error
 		link, err = netlink.LinkByName(tunnelName)
 		if err != nil {
-			out, err := exec.Command("ip", "tunnel", "add", tunnelName, "mode", "ipip", "local", nrc.nodeIP.String(),
-				"remote", nexthop.String(), "dev", nrc.nodeInterface).CombinedOutput()
+			_, err := utils.Exec("ip", "tunnel", "add", tunnelName, "mode", "ipip", "local", nrc.nodeIP.String(),
+				"remote", nexthop.String(), "dev", nrc.nodeInterface)
 			if err != nil {
 				return fmt.Errorf("Route not injected for the route advertised by the node %s "+
-					"Failed to create tunnel interface %s. error: %s, output: %s",
-					nexthop.String(), tunnelName, err, string(out))
+					"Failed to create tunnel interface %s. error: %s",
+					nexthop.String(), tunnelName, err)
 			}
 
 			link, err = netlink.LinkByName(tunnelName)
@@ -466,11 +510,11 @@ func (nrc *NetworkRoutingController) injectRoute(path *table.Path) error {
 			glog.Infof("Tunnel interface: " + tunnelName + " for the node " + nexthop.String() + " already exists.")
 		}
 
-		out, err := exec.Command("ip", "route", "list", "table", customRouteTableID).CombinedOutput()
+		out, err := utils.Exec("ip", "route", "list", "table", customRouteTableID)
 		if err != nil || !strings.Contains(string(out), "dev "+tunnelName+" scope") {
-			if out, err = exec.Command("ip", "route", "add", nexthop.String(), "dev", tunnelName, "table",
-				customRouteTableID).CombinedOutput(); err != nil {
-				return fmt.Errorf("failed to add route in custom route table, err: %s, output: %s", err, string(out))
+			if _, err = utils.Exec("ip", "route", "add", nexthop.String(), "dev", tunnelName, "table",
+				customRouteTableID); err != nil {
+				return fmt.Errorf("failed to add route in custom route table, err: %s", err)
 			}
 		}
 
@@ -490,7 +534,7 @@ func (nrc *NetworkRoutingController) injectRoute(path *table.Path) error {
 		return nil
 	}
 
-	if path.IsWithdraw {
+	if withdraw {
 		glog.V(2).Infof("Removing route: '%s via %s' from peer in the routing table", dst, nexthop)
 		return netlink.RouteDel(route)
 	}
@@ -498,32 +542,88 @@ func (nrc *NetworkRoutingController) injectRoute(path *table.Path) error {
 	return netlink.RouteReplace(route)
 }
 
-// Cleanup performs the cleanup of configurations done
-func (nrc *NetworkRoutingController) Cleanup() {
+// StopBgpServer closes the BGP sessions and listeners of the node BGP server once the controller is stopped, so
+// another kube-router instance can start its own without the process exiting. The routes injected in the kernel
+// are left in place.
+func (nrc *NetworkRoutingController) StopBgpServer() {
+	if !nrc.bgpServerStarted {
+		return
+	}
+	if err := nrc.bgp().Stop(); err != nil {
+		glog.Errorf("Failed to stop BGP server: %s", err)
+	}
+	nrc.bgpServerStarted = false
+}
+
+// restoreBgpSpeaker configures the BGP speaker process again once it is restarted, as it starts without any state:
+// its global configuration, peers, policies and advertised routes
+func (nrc *NetworkRoutingController) restoreBgpSpeaker() {
+	if !nrc.bgpServerStarted {
+		return
+	}
+	release := nrc.Coordinator.Acquire("NRC", utils.SyncEvent)
+	defer release()
+	nrc.syncMu.Lock()
+	defer nrc.syncMu.Unlock()
+
+	glog.Infof("Configuring the restarted BGP speaker process")
+	if err := nrc.bgp().Start(nrc.bgpGlobal); err != nil {
+		glog.Errorf("Failed to start the BGP server of the BGP speaker process: %s", err)
+		return
+	}
+	if len(nrc.globalPeerRouters) != 0 {
+		err := connectToExternalBGPPeers(nrc.bgp(), nrc.globalPeerRouters, nrc.bgpGracefulRestart,
+			nrc.bgpGracefulRestartDeferralTime, nrc.peerMultihopTTL)
+		if err != nil {
+			glog.Errorf("Failed to peer with Global Peer Router(s): %s", err)
+		}
+	}
+	if err := nrc.AddPolicies(); err != nil {
+		glog.Errorf("Error adding BGP policies: %s", err.Error())
+	}
+	if err := nrc.advertisePodRoute(); err != nil {
+		glog.Errorf("Error advertising route: %s", err.Error())
+	}
+	toAdvertise, _, err := nrc.getActiveVIPs()
+	if err != nil {
+		glog.Errorf("failed to get routes to advertise/withdraw %s", err)
+	}
+	nrc.advertiseVIPs(toAdvertise)
+	if nrc.bgpEnableInternal {
+		nrc.syncInternalPeers()
+	}
+}
+
+// Cleanup performs the cleanup of configurations done, and returns the last error of its steps, which it goes on
+// past
+func (nrc *NetworkRoutingController) Cleanup() error {
+	var cleanupErr error
 	// Pod egress cleanup
 	err := nrc.deletePodEgressRule()
 	if err != nil {
 		glog.Warningf("Error deleting Pod egress iptables rule: %s", err.Error())
+		cleanupErr = fmt.Errorf("error deleting Pod egress iptables rule: %s", err.Error())
 	}
 
 	err = nrc.deleteBadPodEgressRules()
 	if err != nil {
 		glog.Warningf("Error deleting Pod egress iptables rule: %s", err.Error())
+		cleanupErr = fmt.Errorf("error deleting Pod egress iptables rule: %s", err.Error())
 	}
 
 	// delete all ipsets created by kube-router
 	ipset, err := utils.NewIPSet(nrc.isIpv6)
-	if err != nil {
-		glog.Errorf("Failed to clean up ipsets: " + err.Error())
+	if err == nil {
+		err = ipset.Save()
 	}
-	err = ipset.Save()
-	if err != nil {
-		glog.Errorf("Failed to clean up ipsets: " + err.Error())
+	if err == nil {
+		err = ipset.DestroyAllWithin()
 	}
-	err = ipset.DestroyAllWithin()
 	if err != nil {
 		glog.Warningf("Error deleting ipset: %s", err.Error())
+		cleanupErr = fmt.Errorf("error deleting ipset: %s", err.Error())
 	}
+	return cleanupErr
 }
 
 func (nrc *NetworkRoutingController) syncNodeIPSets() error {
@@ -536,6 +636,9 @@ func (nrc *NetworkRoutingController) syncNodeIPSets() error {
 	}()
 
 	nodes := nrc.nodeLister.List()
+	if err = nrc.checkEmptyNodeCache(len(nodes)); err != nil {
+		return err
+	}
 
 	// Collect active PodCIDR(s) and NodeIPs from nodes
 	currentPodCidrs := make([]string, 0)
@@ -558,6 +661,9 @@ func (nrc *NetworkRoutingController) syncNodeIPSets() error {
 		currentNodeIPs = append(currentNodeIPs, nodeIP.String())
 	}
 
+	// traffic from pods to pods of remote clusters is not masqueraded either
+	currentPodCidrs = append(currentPodCidrs, remoteClusterPodCIDRs(nrc.remoteClusters)...)
+
 	// Syncing Pod subnet ipset entries
 	psSet := nrc.ipSetHandler.Get(podSubnetsIPSetName)
 	if psSet == nil {
@@ -712,11 +818,21 @@ func (nrc *NetworkRoutingController) startBgpServer() error {
 		nrc.pathPrependCount = uint8(repeatN)
 	}
 
-	nrc.bgpServer = gobgp.NewBgpServer()
-	go nrc.bgpServer.Serve()
+	grpcHosts := nrc.nodeIP.String() + ":50051" + "," + "127.0.0.1:50051"
+	if nrc.bgpSpeakerProcess {
+		if nrc.remoteSpeaker == nil {
+			nrc.remoteSpeaker, err = startBgpSpeakerProcess(grpcHosts, nrc.restoreBgpSpeaker)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		nrc.bgpServer = gobgp.NewBgpServer()
+		go nrc.bgpServer.Serve()
 
-	g := bgpapi.NewGrpcServer(nrc.bgpServer, nrc.nodeIP.String()+":50051"+","+"127.0.0.1:50051")
-	go g.Serve()
+		g := bgpapi.NewGrpcServer(nrc.bgpServer, grpcHosts)
+		go g.Serve()
+	}
 
 	var localAddressList []string
 
@@ -737,9 +853,10 @@ func (nrc *NetworkRoutingController) startBgpServer() error {
 		},
 	}
 
-	if err := nrc.bgpServer.Start(global); err != nil {
+	if err := nrc.bgp().Start(global); err != nil {
 		return errors.New("Failed to start BGP server due to : " + err.Error())
 	}
+	nrc.bgpGlobal = global
 
 	go nrc.watchBgpUpdates()
 
@@ -756,7 +873,7 @@ func (nrc *NetworkRoutingController) startBgpServer() error {
 		asnStrings := stringToSlice(nodeBgpPeerAsnsAnnotation, ",")
 		peerASNs, err := stringSliceToUInt32(asnStrings)
 		if err != nil {
-			nrc.bgpServer.Stop()
+			nrc.bgp().Stop()
 			return fmt.Errorf("Failed to parse node's Peer ASN Numbers Annotation: %s", err)
 		}
 
@@ -769,7 +886,7 @@ func (nrc *NetworkRoutingController) startBgpServer() error {
 		ipStrings := stringToSlice(nodeBgpPeersAnnotation, ",")
 		peerIPs, err := stringSliceToIPs(ipStrings)
 		if err != nil {
-			nrc.bgpServer.Stop()
+			nrc.bgp().Stop()
 			return fmt.Errorf("Failed to parse node's Peer Addresses Annotation: %s", err)
 		}
 
@@ -781,7 +898,7 @@ func (nrc *NetworkRoutingController) startBgpServer() error {
 			portStrings := stringToSlice(nodeBgpPeerPortsAnnotation, ",")
 			peerPorts, err = stringSliceToUInt16(portStrings)
 			if err != nil {
-				nrc.bgpServer.Stop()
+				nrc.bgp().Stop()
 				return fmt.Errorf("Failed to parse node's Peer Port Numbers Annotation: %s", err)
 			}
 		}
@@ -795,7 +912,7 @@ func (nrc *NetworkRoutingController) startBgpServer() error {
 			passStrings := stringToSlice(nodeBGPPasswordsAnnotation, ",")
 			peerPasswords, err = stringSliceB64Decode(passStrings)
 			if err != nil {
-				nrc.bgpServer.Stop()
+				nrc.bgp().Stop()
 				return fmt.Errorf("Failed to parse node's Peer Passwords Annotation: %s", err)
 			}
 		}
@@ -803,7 +920,7 @@ func (nrc *NetworkRoutingController) startBgpServer() error {
 		// Create and set Global Peer Router complete configs
 		nrc.globalPeerRouters, err = newGlobalPeers(peerIPs, peerPorts, peerASNs, peerPasswords)
 		if err != nil {
-			nrc.bgpServer.Stop()
+			nrc.bgp().Stop()
 			return fmt.Errorf("Failed to process Global Peer Router configs: %s", err)
 		}
 
@@ -811,9 +928,9 @@ func (nrc *NetworkRoutingController) startBgpServer() error {
 	}
 
 	if len(nrc.globalPeerRouters) != 0 {
-		err := connectToExternalBGPPeers(nrc.bgpServer, nrc.globalPeerRouters, nrc.bgpGracefulRestart, nrc.bgpGracefulRestartDeferralTime, nrc.peerMultihopTTL)
+		err := connectToExternalBGPPeers(nrc.bgp(), nrc.globalPeerRouters, nrc.bgpGracefulRestart, nrc.bgpGracefulRestartDeferralTime, nrc.peerMultihopTTL)
 		if err != nil {
-			nrc.bgpServer.Stop()
+			nrc.bgp().Stop()
 			return fmt.Errorf("Failed to peer with Global Peer Router(s): %s",
 				err)
 		}
@@ -840,7 +957,13 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 		prometheus.MustRegister(metrics.ControllerBGPadvertisementsReceived)
 		prometheus.MustRegister(metrics.ControllerBGPInternalPeersSyncTime)
 		prometheus.MustRegister(metrics.ControllerBPGpeers)
+		if kubeRouterConfig.BGPSpeakerProcess {
+			prometheus.MustRegister(metrics.ControllerBGPSpeakerRestarts)
+		}
 		prometheus.MustRegister(metrics.ControllerRoutesSyncTime)
+		if kubeRouterConfig.EnablePodEgress {
+			prometheus.MustRegister(metrics.ControllerConntrackInsertFailed)
+		}
 		nrc.MetricsEnabled = true
 	}
 
@@ -849,11 +972,17 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.bgpEnableInternal = kubeRouterConfig.EnableiBGP
 	nrc.bgpGracefulRestart = kubeRouterConfig.BGPGracefulRestart
 	nrc.bgpGracefulRestartDeferralTime = kubeRouterConfig.BGPGracefulRestartDeferralTime
+	nrc.bgpSpeakerProcess = kubeRouterConfig.BGPSpeakerProcess
 	nrc.peerMultihopTTL = kubeRouterConfig.PeerMultihopTtl
 	nrc.enablePodEgress = kubeRouterConfig.EnablePodEgress
 	nrc.syncPeriod = kubeRouterConfig.RoutesSyncPeriod
+	nrc.postSyncHook, err = utils.NewPostSyncHook(kubeRouterConfig.PostSyncHook, kubeRouterConfig.PostSyncHookTimeout)
+	if err != nil {
+		return nil, err
+	}
 	nrc.overrideNextHop = kubeRouterConfig.OverrideNextHop
 	nrc.clientset = clientset
+	nrc.emptyNodeCacheGuard = newEmptyNodeCacheGuard(clientset)
 	nrc.activeNodes = make(map[string]bool)
 	nrc.bgpRRClient = false
 	nrc.bgpRRServer = false
@@ -868,6 +997,7 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	}
 
 	nrc.nodeName = node.Name
+	nrc.appliedStateCache = nodestate.NewAppliedStateCache(kubeRouterConfig.AppliedStateDir, "routing", nrc.nodeName)
 
 	nodeIP, err := utils.GetNodeIP(node)
 	if err != nil {
@@ -876,6 +1006,17 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.nodeIP = nodeIP
 	nrc.isIpv6 = nodeIP.To4() == nil
 
+	// a per node SNAT port range can be set through annotation so that nodes sharing a public address
+	// (e.g. behind a NAT gateway) can be given disjoint partitions of the port space
+	snatPortRange := kubeRouterConfig.PodEgressSNATPortRange
+	if portRange, ok := node.ObjectMeta.Annotations[podEgressSNATPortRangeAnnotation]; ok {
+		snatPortRange = portRange
+	}
+	nrc.podEgressSNATPortRange, err = parseSNATPortRange(snatPortRange)
+	if err != nil {
+		return nil, err
+	}
+
 	if kubeRouterConfig.RouterId != "" {
 		nrc.routerId = kubeRouterConfig.RouterId
 	} else {
@@ -940,6 +1081,11 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.advertisePodCidr = kubeRouterConfig.AdvertiseNodePodCidr
 	nrc.enableOverlays = kubeRouterConfig.EnableOverlay
 	nrc.overlayType = kubeRouterConfig.OverlayType
+	nrc.enableClusterFederation = kubeRouterConfig.EnableClusterFederation
+	nrc.remoteClusterRoutes = make(map[string]net.IP)
+	if nrc.enableClusterFederation {
+		nrc.remoteClusterInformer = newRemoteClusterInformer(clientset, kubeRouterConfig.InformerResyncPeriod)
+	}
 
 	nrc.bgpPort = kubeRouterConfig.BGPPort
 
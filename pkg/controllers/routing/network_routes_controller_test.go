@@ -1,6 +1,7 @@
 package routing
 
 import (
+	"context"
 	"net"
 	"os"
 	"reflect"
@@ -959,12 +960,12 @@ func Test_nodeHasEndpointsForService(t *testing.T) {
 			clientset := fake.NewSimpleClientset()
 			startInformersForRoutes(testcase.nrc, clientset)
 
-			_, err := clientset.CoreV1().Endpoints("default").Create(testcase.existingEndpoint)
+			_, err := clientset.CoreV1().Endpoints("default").Create(context.Background(), testcase.existingEndpoint, metav1.CreateOptions{})
 			if err != nil {
 				t.Fatalf("failed to create existing endpoints: %v", err)
 			}
 
-			_, err = clientset.CoreV1().Services("default").Create(testcase.existingService)
+			_, err = clientset.CoreV1().Services("default").Create(context.Background(), testcase.existingService, metav1.CreateOptions{})
 			if err != nil {
 				t.Fatalf("failed to create existing services: %v", err)
 			}
@@ -1095,7 +1096,7 @@ func Test_advertisePodRoute(t *testing.T) {
 			w := testcase.nrc.bgpServer.Watch(gobgp.WatchBestPath(false))
 
 			clientset := fake.NewSimpleClientset()
-			_, err = clientset.CoreV1().Nodes().Create(testcase.node)
+			_, err = clientset.CoreV1().Nodes().Create(context.Background(), testcase.node, metav1.CreateOptions{})
 			if err != nil {
 				t.Fatalf("failed to create node: %v", err)
 			}
@@ -2464,7 +2465,7 @@ func Test_generateTunnelName(t *testing.T) {
 
 func createServices(clientset kubernetes.Interface, svcs []*v1core.Service) error {
 	for _, svc := range svcs {
-		_, err := clientset.CoreV1().Services("default").Create(svc)
+		_, err := clientset.CoreV1().Services("default").Create(context.Background(), svc, metav1.CreateOptions{})
 		if err != nil {
 			return err
 		}
@@ -2475,7 +2476,7 @@ func createServices(clientset kubernetes.Interface, svcs []*v1core.Service) erro
 
 func createNodes(clientset kubernetes.Interface, nodes []*v1core.Node) error {
 	for _, node := range nodes {
-		_, err := clientset.CoreV1().Nodes().Create(node)
+		_, err := clientset.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
 		if err != nil {
 			return err
 		}
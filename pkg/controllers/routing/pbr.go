@@ -4,8 +4,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 )
 
 // setup a custom routing table that will be used for policy based routing to ensure traffic originating
@@ -16,13 +17,13 @@ func (nrc *NetworkRoutingController) enablePolicyBasedRouting() error {
 		return fmt.Errorf("Failed to update rt_tables file: %s", err)
 	}
 
-	out, err := exec.Command("ip", "rule", "list").Output()
+	out, err := utils.Exec("ip", "rule", "list")
 	if err != nil {
 		return fmt.Errorf("Failed to verify if `ip rule` exists: %s", err.Error())
 	}
 
 	if !strings.Contains(string(out), nrc.podCidr) {
-		err = exec.Command("ip", "rule", "add", "from", nrc.podCidr, "lookup", customRouteTableID).Run()
+		_, err = utils.Exec("ip", "rule", "add", "from", nrc.podCidr, "lookup", customRouteTableID)
 		if err != nil {
 			return fmt.Errorf("Failed to add ip rule due to: %s", err.Error())
 		}
@@ -37,14 +38,14 @@ func (nrc *NetworkRoutingController) disablePolicyBasedRouting() error {
 		return fmt.Errorf("Failed to update rt_tables file: %s", err)
 	}
 
-	out, err := exec.Command("ip", "rule", "list").Output()
+	out, err := utils.Exec("ip", "rule", "list")
 	if err != nil {
 		return fmt.Errorf("Failed to verify if `ip rule` exists: %s",
 			err.Error())
 	}
 
 	if strings.Contains(string(out), nrc.podCidr) {
-		err = exec.Command("ip", "rule", "del", "from", nrc.podCidr, "table", customRouteTableID).Run()
+		_, err = utils.Exec("ip", "rule", "del", "from", nrc.podCidr, "table", customRouteTableID)
 		if err != nil {
 			return fmt.Errorf("Failed to delete ip rule: %s", err.Error())
 		}
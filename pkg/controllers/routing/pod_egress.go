@@ -3,7 +3,10 @@ package routing
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/golang/glog"
 )
 
@@ -26,6 +29,53 @@ var (
 		"-j", "MASQUERADE"}}
 )
 
+// snatPortRangeProtocols are the protocols for which MASQUERADE accepts --to-ports
+var snatPortRangeProtocols = []string{"tcp", "udp"}
+
+// parseSNATPortRange validates a port range of the form "min-max" (or a single port) and returns
+// it normalised to the format expected by the MASQUERADE --to-ports option
+func parseSNATPortRange(portRange string) (string, error) {
+	portRange = strings.TrimSpace(portRange)
+	if portRange == "" {
+		return "", nil
+	}
+	bounds := strings.SplitN(portRange, "-", 2)
+	ports := make([]int, 0, 2)
+	for _, bound := range bounds {
+		port, err := strconv.Atoi(strings.TrimSpace(bound))
+		if err != nil || port < 1 || port > 65535 {
+			return "", fmt.Errorf("invalid SNAT port range %q: ports must be between 1 and 65535", portRange)
+		}
+		ports = append(ports, port)
+	}
+	if len(ports) == 1 {
+		return strconv.Itoa(ports[0]), nil
+	}
+	if ports[0] > ports[1] {
+		return "", fmt.Errorf("invalid SNAT port range %q: min port is greater than max port", portRange)
+	}
+	return strconv.Itoa(ports[0]) + "-" + strconv.Itoa(ports[1]), nil
+}
+
+// podEgressPortRangeArgs returns the per protocol MASQUERADE rules restricting the source ports to the
+// configured SNAT port range. Returns nil if no range is configured.
+func (nrc *NetworkRoutingController) podEgressPortRangeArgs() [][]string {
+	if nrc.podEgressSNATPortRange == "" {
+		return nil
+	}
+	podEgressArgs := podEgressArgs4
+	if nrc.isIpv6 {
+		podEgressArgs = podEgressArgs6
+	}
+	rules := make([][]string, 0, len(snatPortRangeProtocols))
+	for _, protocol := range snatPortRangeProtocols {
+		args := append([]string{"-p", protocol}, podEgressArgs...)
+		args = append(args, "--to-ports", nrc.podEgressSNATPortRange)
+		rules = append(rules, args)
+	}
+	return rules
+}
+
 func (nrc *NetworkRoutingController) createPodEgressRule() error {
 	iptablesCmdHandler, err := nrc.newIptablesCmdHandler()
 	if err != nil {
@@ -36,6 +86,40 @@ func (nrc *NetworkRoutingController) createPodEgressRule() error {
 	if nrc.isIpv6 {
 		podEgressArgs = podEgressArgs6
 	}
+
+	err = nrc.deleteStalePodEgressPortRangeRules()
+	if err != nil {
+		return err
+	}
+
+	// the port restricted rules must be evaluated before the catch-all rule, so if any of them is missing
+	// the catch-all rule is removed and re-appended after them
+	portRangeRules := nrc.podEgressPortRangeArgs()
+	for _, args := range portRangeRules {
+		exists, err := iptablesCmdHandler.Exists("nat", "POSTROUTING", args...)
+		if err != nil {
+			return errors.New("Failed to lookup iptables rule to masquerade outbound traffic from pods: " + err.Error())
+		}
+		if exists {
+			continue
+		}
+		exists, err = iptablesCmdHandler.Exists("nat", "POSTROUTING", podEgressArgs...)
+		if err != nil {
+			return errors.New("Failed to lookup iptables rule to masquerade outbound traffic from pods: " + err.Error())
+		}
+		if exists {
+			err = iptablesCmdHandler.Delete("nat", "POSTROUTING", podEgressArgs...)
+			if err != nil {
+				return errors.New("Failed to delete iptables rule to masquerade outbound traffic from pods: " + err.Error())
+			}
+		}
+		err = iptablesCmdHandler.AppendUnique("nat", "POSTROUTING", args...)
+		if err != nil {
+			return errors.New("Failed to add iptables rule to masquerade outbound traffic from pods: " +
+				err.Error() + "External connectivity will not work.")
+		}
+	}
+
 	err = iptablesCmdHandler.AppendUnique("nat", "POSTROUTING", podEgressArgs...)
 	if err != nil {
 		return errors.New("Failed to add iptables rule to masquerade outbound traffic from pods: " +
@@ -43,10 +127,64 @@ func (nrc *NetworkRoutingController) createPodEgressRule() error {
 
 	}
 
-	glog.V(1).Infof("Added iptables rule to masquerade outbound traffic from pods.")
+	if nrc.podEgressSNATPortRange != "" {
+		glog.V(1).Infof("Added iptables rules to masquerade outbound traffic from pods using SNAT port range %s.",
+			nrc.podEgressSNATPortRange)
+	} else {
+		glog.V(1).Infof("Added iptables rule to masquerade outbound traffic from pods.")
+	}
 	return nil
 }
 
+// deleteStalePodEgressPortRangeRules removes port restricted MASQUERADE rules for pod egress that do not
+// match the currently configured SNAT port range, e.g. after the range was changed or removed
+func (nrc *NetworkRoutingController) deleteStalePodEgressPortRangeRules() error {
+	iptablesCmdHandler, err := nrc.newIptablesCmdHandler()
+	if err != nil {
+		return errors.New("Failed create iptables handler:" + err.Error())
+	}
+
+	podSubnetsSetName := podSubnetsIPSetName
+	if nrc.isIpv6 {
+		podSubnetsSetName = "inet6:" + podSubnetsIPSetName
+	}
+	deleted, err := utils.DeleteIPTablesRules(iptablesCmdHandler, "nat", "POSTROUTING",
+		func(rule utils.IPTablesSaveRule) bool {
+			return isPodEgressPortRangeRule(rule, podSubnetsSetName) &&
+				ruleArg(rule, "--to-ports") != nrc.podEgressSNATPortRange
+		})
+	for _, rule := range deleted {
+		glog.Infof("Deleted stale iptables rule to masquerade outbound traffic from pods: %s", rule)
+	}
+	if err != nil {
+		return errors.New("Failed to delete stale iptables rule to masquerade outbound traffic from pods: " + err.Error())
+	}
+	return nil
+}
+
+// isPodEgressPortRangeRule tells whether the rule masquerades the traffic from the pod subnets to a port range
+func isPodEgressPortRangeRule(rule utils.IPTablesSaveRule, podSubnetsSetName string) bool {
+	if rule.Target != "MASQUERADE" || ruleArg(rule, "--to-ports") == "" {
+		return false
+	}
+	for i := 0; i+2 < len(rule.Args); i++ {
+		if rule.Args[i] == "--match-set" && rule.Args[i+1] == podSubnetsSetName && rule.Args[i+2] == "src" {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleArg returns the value of the option of the rule, empty when the rule has none
+func ruleArg(rule utils.IPTablesSaveRule, option string) string {
+	for i := 0; i+1 < len(rule.Args); i++ {
+		if rule.Args[i] == option {
+			return rule.Args[i+1]
+		}
+	}
+	return ""
+}
+
 func (nrc *NetworkRoutingController) deletePodEgressRule() error {
 	iptablesCmdHandler, err := nrc.newIptablesCmdHandler()
 	if err != nil {
@@ -71,6 +209,20 @@ func (nrc *NetworkRoutingController) deletePodEgressRule() error {
 		glog.Infof("Deleted iptables rule to masquerade outbound traffic from pods.")
 	}
 
+	for _, args := range nrc.podEgressPortRangeArgs() {
+		exists, err := iptablesCmdHandler.Exists("nat", "POSTROUTING", args...)
+		if err != nil {
+			return errors.New("Failed to lookup iptables rule to masquerade outbound traffic from pods: " + err.Error())
+		}
+		if exists {
+			err = iptablesCmdHandler.Delete("nat", "POSTROUTING", args...)
+			if err != nil {
+				return errors.New("Failed to delete iptables rule to masquerade outbound traffic from pods: " +
+					err.Error() + ". Pod egress might still work...")
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,63 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+func Test_parseSNATPortRange(t *testing.T) {
+	testcases := []struct {
+		name      string
+		portRange string
+		expected  string
+		expectErr bool
+	}{
+		{"empty range", "", "", false},
+		{"valid range", "32768-60999", "32768-60999", false},
+		{"valid range with spaces", " 1024 - 2048 ", "1024-2048", false},
+		{"single port", "5000", "5000", false},
+		{"inverted range", "2000-1000", "", true},
+		{"port out of range", "1024-70000", "", true},
+		{"zero port", "0-1024", "", true},
+		{"not a number", "low-high", "", true},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			portRange, err := parseSNATPortRange(testcase.portRange)
+			if testcase.expectErr && err == nil {
+				t.Fatalf("expected error for port range %q", testcase.portRange)
+			}
+			if !testcase.expectErr && err != nil {
+				t.Fatalf("unexpected error for port range %q: %v", testcase.portRange, err)
+			}
+			if portRange != testcase.expected {
+				t.Errorf("expected port range %q but got %q", testcase.expected, portRange)
+			}
+		})
+	}
+}
+
+func Test_isPodEgressPortRangeRule(t *testing.T) {
+	for line, expected := range map[string]bool{
+		"-A POSTROUTING -m set --match-set kube-router-pod-subnets src -m set ! --match-set kube-router-pod-subnets dst " +
+			"-m set ! --match-set kube-router-node-ips dst -j MASQUERADE --to-ports 32768-60999": true,
+		"-A POSTROUTING -m set --match-set kube-router-pod-subnets src -m set ! --match-set kube-router-pod-subnets dst " +
+			"-m set ! --match-set kube-router-node-ips dst -j MASQUERADE": false,
+		`-A POSTROUTING -m comment --comment "--match-set kube-router-pod-subnets src --to-ports 1024" -j MASQUERADE`: false,
+		"-A POSTROUTING -m set --match-set kube-router-pod-subnets src -j SNAT --to-source 10.0.0.1":                  false,
+	} {
+		rule, ok, err := utils.ParseIPTablesRule(line)
+		if err != nil || !ok {
+			t.Fatalf("unexpected error parsing %q: %v", line, err)
+		}
+		if got := isPodEgressPortRangeRule(rule, podSubnetsIPSetName); got != expected {
+			t.Errorf("expected isPodEgressPortRangeRule(%q) to be %v", line, expected)
+		}
+	}
+	rule, _, _ := utils.ParseIPTablesRule("-A POSTROUTING -j MASQUERADE --to-ports 32768-60999")
+	if portRange := ruleArg(rule, "--to-ports"); portRange != "32768-60999" {
+		t.Errorf("expected the port range 32768-60999, got %q", portRange)
+	}
+}
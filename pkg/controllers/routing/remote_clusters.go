@@ -0,0 +1,159 @@
+package routing
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newRemoteClusterInformer returns the informer of the RemoteCluster custom resources, read on each periodic sync
+func newRemoteClusterInformer(clientset kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+	lw := crd.NewListWatch(clientset, crd.RemoteClusterResource,
+		func() runtime.Object { return &crd.RemoteClusterList{} },
+		func() runtime.Object { return &crd.RemoteCluster{} })
+	return cache.NewSharedIndexInformer(utils.NewInstrumentedListWatch(crd.RemoteClusterResource, lw),
+		&crd.RemoteCluster{}, resync, cache.Indexers{})
+}
+
+// syncRemoteClusters reads the RemoteCluster custom resources and routes the pod and service CIDRs of the
+// remote clusters toward their BGP endpoints. It also maintains an ipset per remote namespace holding the
+// CIDRs of the namespace, so iptables rules can match traffic from and to the namespace.
+func (nrc *NetworkRoutingController) syncRemoteClusters() error {
+	// the routes to the remote clusters would be withdrawn while the informer has not listed them yet
+	if !nrc.remoteClusterInformer.HasSynced() {
+		return errors.New("the remote clusters are not synced yet")
+	}
+
+	objs := nrc.remoteClusterInformer.GetIndexer().List()
+	valid := make([]crd.RemoteCluster, 0, len(objs))
+	for _, obj := range objs {
+		cluster := obj.(*crd.RemoteCluster)
+		if err := cluster.Validate(); err != nil {
+			glog.Errorf("Ignoring remote cluster %s: %s", cluster.Name, err)
+			continue
+		}
+		valid = append(valid, *cluster)
+	}
+	// the indexer lists the clusters in no particular order
+	sort.Slice(valid, func(i, j int) bool { return valid[i].Name < valid[j].Name })
+	nrc.remoteClusters = valid
+
+	routes := remoteClusterRoutes(valid)
+	for dst, nexthop := range nrc.remoteClusterRoutes {
+		if desired, ok := routes[dst]; ok && desired.Equal(nexthop) {
+			continue
+		}
+		_, ipNet, _ := net.ParseCIDR(dst)
+		if err := nrc.injectRouteVia(ipNet, nexthop, true); err != nil {
+			glog.Errorf("Failed to remove route to remote cluster CIDR %s: %s", dst, err)
+		}
+	}
+	injected := make(map[string]net.IP)
+	for dst, nexthop := range routes {
+		// like routes learned from BGP peers, routes are only injected when they change
+		if previous, ok := nrc.remoteClusterRoutes[dst]; ok && previous.Equal(nexthop) {
+			injected[dst] = nexthop
+			continue
+		}
+		_, ipNet, _ := net.ParseCIDR(dst)
+		if err := nrc.injectRouteVia(ipNet, nexthop, false); err != nil {
+			glog.Errorf("Failed to inject route to remote cluster CIDR %s: %s", dst, err)
+			continue
+		}
+		injected[dst] = nexthop
+	}
+	nrc.remoteClusterRoutes = injected
+
+	return nrc.syncRemoteNamespaceIPSets(remoteNamespaceIPSets(valid))
+}
+
+func (nrc *NetworkRoutingController) syncRemoteNamespaceIPSets(ipsets map[string][]string) error {
+	for name, cidrs := range ipsets {
+		set, err := nrc.ipSetHandler.Create(name, utils.TypeHashNet, utils.OptionTimeout, "0")
+		if err != nil {
+			return err
+		}
+		if err := set.Refresh(cidrs); err != nil {
+			return err
+		}
+	}
+
+	if err := nrc.ipSetHandler.Save(); err != nil {
+		return err
+	}
+	for _, set := range nrc.ipSetHandler.List() {
+		if _, ok := ipsets[set.Name]; ok || !strings.HasPrefix(set.Name, crd.RemoteNamespaceIPSetPrefix) {
+			continue
+		}
+		if err := set.Destroy(); err != nil {
+			glog.Errorf("Failed to delete ipset %s of removed remote namespace: %s", set.Name, err)
+		}
+	}
+	return nil
+}
+
+// remoteClusterPodCIDRs returns the pod CIDRs of the remote clusters, which traffic from pods must not be
+// masqueraded to
+func remoteClusterPodCIDRs(clusters []crd.RemoteCluster) []string {
+	cidrs := make([]string, 0)
+	for _, cluster := range clusters {
+		cidrs = append(cidrs, cluster.Spec.PodCIDRs...)
+	}
+	return cidrs
+}
+
+// remoteClusterRoutes returns the next hop of the route to each pod and service CIDR of the remote clusters
+func remoteClusterRoutes(clusters []crd.RemoteCluster) map[string]net.IP {
+	routes := make(map[string]net.IP)
+	for i := range clusters {
+		nexthop := clusters[i].NextHop()
+		for _, cidr := range append(append([]string{}, clusters[i].Spec.PodCIDRs...), clusters[i].Spec.ServiceCIDRs...) {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue
+			}
+			routes[ipNet.String()] = nexthop
+		}
+	}
+	return routes
+}
+
+// remoteNamespaceIPSets returns the CIDRs of each ipset representing a namespace of a remote cluster
+func remoteNamespaceIPSets(clusters []crd.RemoteCluster) map[string][]string {
+	ipsets := make(map[string][]string)
+	names := make(utils.IPSetNames)
+	for _, cluster := range clusters {
+		for _, namespace := range cluster.Spec.Namespaces {
+			name := crd.RemoteNamespaceIPSetName(cluster.Name, namespace.Name)
+			if err := names.Register(name, cluster.Name+"/"+namespace.Name); err != nil {
+				glog.Errorf("Ignoring namespace %s of remote cluster %s: %s", namespace.Name, cluster.Name, err)
+				continue
+			}
+			ipsets[name] = append(ipsets[name], namespace.CIDRs...)
+			glog.V(2).Infof("Namespace %s of remote cluster %s is represented by ipset %s", namespace.Name, cluster.Name, name)
+		}
+	}
+	return ipsets
+}
+
+// renderRemoteClusterRoutes mirrors the decisions taken by injectRouteVia for the routes to the remote clusters
+func renderRemoteClusterRoutes(clusters []crd.RemoteCluster, nodeSubnet net.IPNet, enableOverlays bool,
+	overlayType string, overrideNextHop bool) []string {
+	routes := make([]string, 0)
+	for dst, nexthop := range remoteClusterRoutes(clusters) {
+		if route, ok := renderRoute(dst, nexthop, nodeSubnet, enableOverlays, overlayType, overrideNextHop); ok {
+			routes = append(routes, route)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
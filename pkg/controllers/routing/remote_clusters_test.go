@@ -0,0 +1,77 @@
+package routing
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/crd"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_renderRemoteClusterRoutes(t *testing.T) {
+	clusters := []crd.RemoteCluster{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "east"},
+			Spec: crd.RemoteClusterSpec{
+				PodCIDRs:     []string{"10.32.0.0/16"},
+				ServiceCIDRs: []string{"10.33.0.0/16"},
+				BGPEndpoints: []crd.RemoteClusterBGPEndpoint{{Address: "10.0.0.10"}, {Address: "10.0.0.11"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "west"},
+			Spec: crd.RemoteClusterSpec{
+				PodCIDRs:     []string{"10.48.0.1/16"},
+				BGPEndpoints: []crd.RemoteClusterBGPEndpoint{{Address: "10.1.0.10"}},
+			},
+		},
+	}
+	_, nodeSubnet, _ := net.ParseCIDR("10.0.0.0/24")
+
+	routes := renderRemoteClusterRoutes(clusters, *nodeSubnet, true, "subnet", false)
+	expected := []string{
+		"10.32.0.0/16 via 10.0.0.10",
+		"10.33.0.0/16 via 10.0.0.10",
+		"10.48.0.0/16 dev tun-101010",
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected routes %v but got %v", expected, routes)
+	}
+
+	routes = renderRemoteClusterRoutes(clusters, *nodeSubnet, false, "subnet", false)
+	expected = []string{
+		"10.32.0.0/16 via 10.0.0.10",
+		"10.33.0.0/16 via 10.0.0.10",
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected routes %v with overlays disabled but got %v", expected, routes)
+	}
+}
+
+func Test_remoteNamespaceIPSets(t *testing.T) {
+	clusters := []crd.RemoteCluster{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "east"},
+			Spec: crd.RemoteClusterSpec{
+				PodCIDRs:     []string{"10.32.0.0/16"},
+				BGPEndpoints: []crd.RemoteClusterBGPEndpoint{{Address: "10.0.0.10"}},
+				Namespaces: []crd.RemoteClusterNamespace{
+					{Name: "frontend", CIDRs: []string{"10.32.4.0/22"}},
+					{Name: "backend", CIDRs: []string{"10.32.8.0/22", "10.32.12.0/22"}},
+				},
+			},
+		},
+	}
+
+	expected := map[string][]string{
+		crd.RemoteNamespaceIPSetName("east", "frontend"): {"10.32.4.0/22"},
+		crd.RemoteNamespaceIPSetName("east", "backend"):  {"10.32.8.0/22", "10.32.12.0/22"},
+	}
+	if ipsets := remoteNamespaceIPSets(clusters); !reflect.DeepEqual(ipsets, expected) {
+		t.Errorf("expected ipsets %v but got %v", expected, ipsets)
+	}
+	if cidrs := remoteClusterPodCIDRs(clusters); !reflect.DeepEqual(cidrs, []string{"10.32.0.0/16"}) {
+		t.Errorf("expected remote pod CIDRs [10.32.0.0/16] but got %v", cidrs)
+	}
+}
@@ -0,0 +1,51 @@
+package routing
+
+import (
+	"context"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The BGP peers and the ipsets of the nodes of the last sync are kept when the cache of the nodes empties while the
+// API server is unreachable, or while it still has some, so an empty cache is never taken for the deletion of all the
+// nodes of the cluster.
+
+// emptyNodeCacheGuard guards the BGP peers and the ipsets of the node against the cache of the nodes emptying
+type emptyNodeCacheGuard struct {
+	nodes *utils.EmptyCacheGuard
+	// nodes of the last sync
+	syncedNodes int
+}
+
+func newEmptyNodeCacheGuard(clientset kubernetes.Interface) *emptyNodeCacheGuard {
+	return &emptyNodeCacheGuard{
+		nodes: &utils.EmptyCacheGuard{
+			Resource: "nodes",
+			Count: func(limit int64) (int, error) {
+				nodes, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{Limit: limit})
+				if err != nil {
+					return 0, err
+				}
+				return len(nodes.Items), nil
+			},
+		},
+	}
+}
+
+// checkEmptyNodeCache returns an error wrapping utils.ErrAPIUnavailable when syncing the nodes would remove all the
+// nodes of the last sync as their cache emptied, and they are not confirmed deleted. The cached nodes are recorded as
+// synced otherwise.
+func (nrc *NetworkRoutingController) checkEmptyNodeCache(nodes int) error {
+	if nrc.emptyNodeCacheGuard == nil {
+		return nil
+	}
+	guard := nrc.emptyNodeCacheGuard
+	if err := guard.nodes.Check(nodes, guard.syncedNodes); err != nil {
+		return err
+	}
+	guard.syncedNodes = nodes
+	return nil
+}
@@ -0,0 +1,122 @@
+package crd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterAllowListResource is the plural name of the ClusterAllowList custom resource
+const ClusterAllowListResource = "clusterallowlists"
+
+// ClusterAllowList allows the traffic from its CIDRs to its ports of all the pods the network policies isolate,
+// whatever the network policies of their namespaces, e.g. for monitoring scrapers or backup agents
+type ClusterAllowList struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterAllowListSpec `json:"spec"`
+}
+
+// ClusterAllowListSpec is the specification of a ClusterAllowList
+type ClusterAllowListSpec struct {
+	// CIDRs are the IPv4 source addresses allowed
+	CIDRs []string `json:"cidrs"`
+	// Ports are the destination ports of the pods the CIDRs are allowed to
+	Ports []ClusterAllowListPort `json:"ports"`
+}
+
+// ClusterAllowListPort is a port, or a range of ports, of a ClusterAllowList
+type ClusterAllowListPort struct {
+	// Protocol is TCP, UDP or SCTP, TCP when empty
+	Protocol api.Protocol `json:"protocol,omitempty"`
+	Port     int32        `json:"port"`
+	// EndPort is the last port of the range starting at Port, if any
+	EndPort int32 `json:"endPort,omitempty"`
+}
+
+// ClusterAllowListList is a list of ClusterAllowList
+type ClusterAllowListList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ClusterAllowList `json:"items"`
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *ClusterAllowList) DeepCopyInto(out *ClusterAllowList) {
+	*out = *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Spec.CIDRs != nil {
+		out.Spec.CIDRs = append([]string(nil), in.Spec.CIDRs...)
+	}
+	if in.Spec.Ports != nil {
+		out.Spec.Ports = append([]ClusterAllowListPort(nil), in.Spec.Ports...)
+	}
+}
+
+// DeepCopyObject returns a copy of the receiver, the informers of the custom resource hand out copies
+func (in *ClusterAllowList) DeepCopyObject() runtime.Object {
+	out := &ClusterAllowList{}
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the receiver
+func (in *ClusterAllowListList) DeepCopyObject() runtime.Object {
+	out := &ClusterAllowListList{TypeMeta: in.TypeMeta}
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ClusterAllowList, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// ListClusterAllowLists returns the ClusterAllowLists of the cluster, or none when the custom resource definition is
+// not installed
+func ListClusterAllowLists(clientset kubernetes.Interface) ([]ClusterAllowList, error) {
+	list := &ClusterAllowListList{}
+	if _, err := List(clientset, ClusterAllowListResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Validate checks the CIDRs and ports of the ClusterAllowList are well formed
+func (l *ClusterAllowList) Validate() error {
+	if len(l.Spec.CIDRs) == 0 || len(l.Spec.Ports) == 0 {
+		return errors.New("at least a CIDR and a port are required")
+	}
+	for _, cidr := range l.Spec.CIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || ipNet.IP.To4() == nil {
+			return fmt.Errorf("invalid IPv4 CIDR %q", cidr)
+		}
+		// the hash:net ipsets do not store networks of zero prefix size
+		if ones, _ := ipNet.Mask.Size(); ones == 0 {
+			return fmt.Errorf("CIDR %q must have a non zero prefix", cidr)
+		}
+	}
+	for _, port := range l.Spec.Ports {
+		switch port.Protocol {
+		case "", api.ProtocolTCP, api.ProtocolUDP, api.ProtocolSCTP:
+		default:
+			return fmt.Errorf("invalid protocol %q", port.Protocol)
+		}
+		if port.Port < 1 || port.Port > 65535 {
+			return fmt.Errorf("invalid port %d", port.Port)
+		}
+		if port.EndPort != 0 && (port.EndPort < port.Port || port.EndPort > 65535) {
+			return fmt.Errorf("invalid end port %d of port %d", port.EndPort, port.Port)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package crd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_ClusterAllowListValidate(t *testing.T) {
+	testcases := []struct {
+		name  string
+		spec  string
+		valid bool
+	}{
+		{
+			"valid",
+			`{"cidrs": ["192.168.10.0/24"], "ports": [{"port": 9100}, {"protocol": "UDP", "port": 8125, "endPort": 8126}]}`,
+			true,
+		},
+		{
+			"SCTP port",
+			`{"cidrs": ["192.168.10.0/24"], "ports": [{"protocol": "SCTP", "port": 3868}]}`,
+			true,
+		},
+		{
+			"no port",
+			`{"cidrs": ["192.168.10.0/24"]}`,
+			false,
+		},
+		{
+			"IPv6 CIDR",
+			`{"cidrs": ["fd00::/64"], "ports": [{"port": 9100}]}`,
+			false,
+		},
+		{
+			"zero prefix",
+			`{"cidrs": ["0.0.0.0/0"], "ports": [{"port": 9100}]}`,
+			false,
+		},
+		{
+			"invalid protocol",
+			`{"cidrs": ["192.168.10.0/24"], "ports": [{"protocol": "ICMP", "port": 9100}]}`,
+			false,
+		},
+		{
+			"end port before port",
+			`{"cidrs": ["192.168.10.0/24"], "ports": [{"port": 9100, "endPort": 9000}]}`,
+			false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			l := &ClusterAllowList{}
+			if err := json.Unmarshal([]byte(`{"metadata": {"name": "monitoring"}, "spec": `+testcase.spec+`}`), l); err != nil {
+				t.Fatalf("unexpected error decoding cluster allow list: %v", err)
+			}
+			err := l.Validate()
+			if testcase.valid && err != nil {
+				t.Errorf("expected cluster allow list to be valid but got %v", err)
+			}
+			if !testcase.valid && err == nil {
+				t.Errorf("expected cluster allow list to be invalid")
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package crd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ClusterNetworkPolicyResource is the plural name of the ClusterNetworkPolicy custom resource
+	ClusterNetworkPolicyResource = "clusternetworkpolicies"
+
+	// ClusterNetworkPolicyActionAllow accepts the traffic of the rules whatever the network policies
+	ClusterNetworkPolicyActionAllow = "Allow"
+	// ClusterNetworkPolicyActionDeny rejects the traffic of the rules whatever the network policies
+	ClusterNetworkPolicyActionDeny = "Deny"
+	// ClusterNetworkPolicyActionPass skips the rest of the tier of the policy for the traffic of the rules, which goes
+	// on to the next tier
+	ClusterNetworkPolicyActionPass = "Pass"
+
+	// ClusterNetworkPolicyTierClusterAdmin is the first tier of the ClusterNetworkPolicies, the default one
+	ClusterNetworkPolicyTierClusterAdmin = "ClusterAdmin"
+	// ClusterNetworkPolicyTierPlatform is the tier of the ClusterNetworkPolicies run through after the ClusterAdmin
+	// tier, ahead of the network policies of the namespaces, the tenant tier
+	ClusterNetworkPolicyTierPlatform = "Platform"
+)
+
+// ClusterNetworkPolicy allows or denies traffic of the pods of all the namespaces, or of the namespaces matching its
+// namespace selector, ahead of their network policies, e.g. to deny the egress to the metadata service of the cloud
+// provider from every namespace
+type ClusterNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterNetworkPolicySpec `json:"spec"`
+}
+
+// ClusterNetworkPolicySpec is the specification of a ClusterNetworkPolicy
+type ClusterNetworkPolicySpec struct {
+	// Tier is ClusterAdmin or Platform, ClusterAdmin when empty
+	Tier string `json:"tier,omitempty"`
+	// Priority orders the ClusterNetworkPolicies of a tier, the lowest first, the ones of the same priority by name
+	Priority int32 `json:"priority,omitempty"`
+	// Action is Allow, Deny or Pass
+	Action string `json:"action"`
+	// NamespaceSelector selects the namespaces of the pods the policy applies to, all when nil
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Ingress are the rules of the traffic to the pods
+	Ingress []ClusterNetworkPolicyRule `json:"ingress,omitempty"`
+	// Egress are the rules of the traffic from the pods
+	Egress []ClusterNetworkPolicyRule `json:"egress,omitempty"`
+}
+
+// ClusterNetworkPolicyRule matches the traffic from or to its IP blocks and the pods of its service accounts, on its
+// ports
+type ClusterNetworkPolicyRule struct {
+	// IPBlocks are the sources of the ingress rules and the destinations of the egress rules
+	IPBlocks []networking.IPBlock `json:"ipBlocks,omitempty"`
+	// ServiceAccounts select the pods running as them as the sources of the ingress rules and the destinations of the
+	// egress rules, whatever their labels
+	ServiceAccounts []ClusterNetworkPolicyServiceAccount `json:"serviceAccounts,omitempty"`
+	// Ports are the destination ports of the rule, all when empty
+	Ports []ClusterNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// ClusterNetworkPolicyServiceAccount is a service account of a namespace, whose pods are peers of a
+// ClusterNetworkPolicyRule
+type ClusterNetworkPolicyServiceAccount struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// ClusterNetworkPolicyPort is a port, or a range of ports, of a ClusterNetworkPolicyRule
+type ClusterNetworkPolicyPort struct {
+	// Protocol is TCP, UDP or SCTP, TCP when empty
+	Protocol api.Protocol `json:"protocol,omitempty"`
+	// Port is the port, all the ports of the protocol when 0
+	Port int32 `json:"port,omitempty"`
+	// EndPort is the last port of the range starting at Port, if any
+	EndPort int32 `json:"endPort,omitempty"`
+}
+
+// ClusterNetworkPolicyList is a list of ClusterNetworkPolicy
+type ClusterNetworkPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ClusterNetworkPolicy `json:"items"`
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *ClusterNetworkPolicy) DeepCopyInto(out *ClusterNetworkPolicy) {
+	*out = *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Spec.NamespaceSelector != nil {
+		out.Spec.NamespaceSelector = in.Spec.NamespaceSelector.DeepCopy()
+	}
+	copyRules := func(rules []ClusterNetworkPolicyRule) []ClusterNetworkPolicyRule {
+		if rules == nil {
+			return nil
+		}
+		copied := make([]ClusterNetworkPolicyRule, len(rules))
+		for i := range rules {
+			if rules[i].IPBlocks != nil {
+				copied[i].IPBlocks = make([]networking.IPBlock, len(rules[i].IPBlocks))
+				for j := range rules[i].IPBlocks {
+					rules[i].IPBlocks[j].DeepCopyInto(&copied[i].IPBlocks[j])
+				}
+			}
+			if rules[i].ServiceAccounts != nil {
+				copied[i].ServiceAccounts = append([]ClusterNetworkPolicyServiceAccount(nil),
+					rules[i].ServiceAccounts...)
+			}
+			if rules[i].Ports != nil {
+				copied[i].Ports = append([]ClusterNetworkPolicyPort(nil), rules[i].Ports...)
+			}
+		}
+		return copied
+	}
+	out.Spec.Ingress = copyRules(in.Spec.Ingress)
+	out.Spec.Egress = copyRules(in.Spec.Egress)
+}
+
+// DeepCopyObject returns a copy of the receiver, the informers of the custom resource hand out copies
+func (in *ClusterNetworkPolicy) DeepCopyObject() runtime.Object {
+	out := &ClusterNetworkPolicy{}
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the receiver
+func (in *ClusterNetworkPolicyList) DeepCopyObject() runtime.Object {
+	out := &ClusterNetworkPolicyList{TypeMeta: in.TypeMeta}
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ClusterNetworkPolicy, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// ListClusterNetworkPolicies returns the ClusterNetworkPolicies of the cluster, or none when the custom resource
+// definition is not installed
+func ListClusterNetworkPolicies(clientset kubernetes.Interface) ([]ClusterNetworkPolicy, error) {
+	list := &ClusterNetworkPolicyList{}
+	if _, err := List(clientset, ClusterNetworkPolicyResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Validate checks the action, tier, namespace selector, peers and ports of the ClusterNetworkPolicy are well formed
+func (p *ClusterNetworkPolicy) Validate() error {
+	switch p.Spec.Action {
+	case ClusterNetworkPolicyActionAllow, ClusterNetworkPolicyActionDeny, ClusterNetworkPolicyActionPass:
+	default:
+		return fmt.Errorf("invalid action %q, must be %s, %s or %s", p.Spec.Action, ClusterNetworkPolicyActionAllow,
+			ClusterNetworkPolicyActionDeny, ClusterNetworkPolicyActionPass)
+	}
+	switch p.Spec.Tier {
+	case "", ClusterNetworkPolicyTierClusterAdmin, ClusterNetworkPolicyTierPlatform:
+	default:
+		return fmt.Errorf("invalid tier %q, must be %s or %s", p.Spec.Tier, ClusterNetworkPolicyTierClusterAdmin,
+			ClusterNetworkPolicyTierPlatform)
+	}
+	if p.Spec.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(p.Spec.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespace selector: %s", err)
+		}
+	}
+	if len(p.Spec.Ingress) == 0 && len(p.Spec.Egress) == 0 {
+		return errors.New("at least an ingress or egress rule is required")
+	}
+	for _, rule := range append(append([]ClusterNetworkPolicyRule(nil), p.Spec.Ingress...), p.Spec.Egress...) {
+		if len(rule.IPBlocks) == 0 && len(rule.ServiceAccounts) == 0 {
+			return errors.New("at least an IP block or a service account is required per rule")
+		}
+		for _, account := range rule.ServiceAccounts {
+			if account.Namespace == "" || account.Name == "" {
+				return fmt.Errorf("the namespace and the name of service account %s/%s are required",
+					account.Namespace, account.Name)
+			}
+		}
+		for _, ipBlock := range rule.IPBlocks {
+			for _, cidr := range append([]string{ipBlock.CIDR}, ipBlock.Except...) {
+				_, ipNet, err := net.ParseCIDR(cidr)
+				if err != nil || ipNet.IP.To4() == nil {
+					return fmt.Errorf("invalid IPv4 CIDR %q", cidr)
+				}
+			}
+		}
+		for _, port := range rule.Ports {
+			switch port.Protocol {
+			case "", api.ProtocolTCP, api.ProtocolUDP, api.ProtocolSCTP:
+			default:
+				return fmt.Errorf("invalid protocol %q", port.Protocol)
+			}
+			if port.Port < 0 || port.Port > 65535 {
+				return fmt.Errorf("invalid port %d", port.Port)
+			}
+			if port.EndPort != 0 && (port.Port == 0 || port.EndPort < port.Port || port.EndPort > 65535) {
+				return fmt.Errorf("invalid end port %d of port %d", port.EndPort, port.Port)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package crd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func Test_ClusterNetworkPolicyValidate(t *testing.T) {
+	testcases := []struct {
+		name  string
+		spec  string
+		valid bool
+	}{
+		{
+			"valid",
+			`{"priority": 10, "action": "Deny", "egress": [{"ipBlocks": [{"cidr": "169.254.169.254/32"}]}]}`,
+			true,
+		},
+		{
+			"ports and namespace selector",
+			`{"action": "Allow", "namespaceSelector": {"matchLabels": {"team": "a"}}, "ingress": [{"ipBlocks": ` +
+				`[{"cidr": "10.0.0.0/8", "except": ["10.1.0.0/16"]}], "ports": [{"port": 443}, ` +
+				`{"protocol": "UDP", "port": 8125, "endPort": 8126}]}]}`,
+			true,
+		},
+		{
+			"invalid action",
+			`{"action": "Log", "egress": [{"ipBlocks": [{"cidr": "169.254.169.254/32"}]}]}`,
+			false,
+		},
+		{
+			"pass in the platform tier",
+			`{"tier": "Platform", "action": "Pass", "ingress": [{"ipBlocks": [{"cidr": "10.0.0.0/8"}]}]}`,
+			true,
+		},
+		{
+			"invalid tier",
+			`{"tier": "Tenant", "action": "Allow", "ingress": [{"ipBlocks": [{"cidr": "10.0.0.0/8"}]}]}`,
+			false,
+		},
+		{
+			"no rule",
+			`{"action": "Deny"}`,
+			false,
+		},
+		{
+			"no peer",
+			`{"action": "Deny", "egress": [{"ports": [{"port": 80}]}]}`,
+			false,
+		},
+		{
+			"service accounts",
+			`{"action": "Allow", "ingress": [{"serviceAccounts": [{"namespace": "monitoring", "name": "prometheus"}], ` +
+				`"ports": [{"port": 9100}]}]}`,
+			true,
+		},
+		{
+			"service account without namespace",
+			`{"action": "Allow", "ingress": [{"serviceAccounts": [{"name": "prometheus"}]}]}`,
+			false,
+		},
+		{
+			"IPv6 CIDR",
+			`{"action": "Deny", "egress": [{"ipBlocks": [{"cidr": "fd00::/64"}]}]}`,
+			false,
+		},
+		{
+			"invalid except",
+			`{"action": "Deny", "egress": [{"ipBlocks": [{"cidr": "10.0.0.0/8", "except": ["10.1.0.0"]}]}]}`,
+			false,
+		},
+		{
+			"invalid protocol",
+			`{"action": "Deny", "egress": [{"ipBlocks": [{"cidr": "10.0.0.0/8"}], "ports": [{"protocol": "ICMP"}]}]}`,
+			false,
+		},
+		{
+			"end port without port",
+			`{"action": "Deny", "egress": [{"ipBlocks": [{"cidr": "10.0.0.0/8"}], "ports": [{"endPort": 80}]}]}`,
+			false,
+		},
+		{
+			"invalid namespace selector",
+			`{"action": "Deny", "namespaceSelector": {"matchExpressions": [{"key": "team", "operator": "Near"}]}, ` +
+				`"egress": [{"ipBlocks": [{"cidr": "10.0.0.0/8"}]}]}`,
+			false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			p := &ClusterNetworkPolicy{}
+			if err := json.Unmarshal([]byte(`{"metadata": {"name": "policy"}, "spec": `+testcase.spec+`}`), p); err != nil {
+				t.Fatalf("unexpected error decoding cluster network policy: %v", err)
+			}
+			err := p.Validate()
+			if testcase.valid && err != nil {
+				t.Errorf("expected cluster network policy to be valid but got %v", err)
+			}
+			if !testcase.valid && err == nil {
+				t.Errorf("expected cluster network policy to be invalid")
+			}
+		})
+	}
+}
+
+func Test_WatchDecoder(t *testing.T) {
+	stream := ioutil.NopCloser(strings.NewReader(
+		`{"type": "ADDED", "object": {"metadata": {"name": "deny-metadata", "resourceVersion": "2"}, ` +
+			`"spec": {"action": "Deny", "egress": [{"ipBlocks": [{"cidr": "169.254.169.254/32"}]}]}}}` +
+			`{"type": "ERROR", "object": {"status": "Failure", "reason": "Expired", "code": 410}}` +
+			`{"type": "BOOKMARKED", "object": {}}`))
+	decoder := newWatchDecoder(stream, func() runtime.Object { return &ClusterNetworkPolicy{} })
+	defer decoder.Close()
+
+	eventType, object, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	policy, ok := object.(*ClusterNetworkPolicy)
+	if eventType != watch.Added || !ok || policy.Name != "deny-metadata" || policy.ResourceVersion != "2" ||
+		policy.Spec.Egress[0].IPBlocks[0].CIDR != "169.254.169.254/32" {
+		t.Errorf("expected the added cluster network policy deny-metadata, got %s %+v", eventType, object)
+	}
+
+	eventType, object, err = decoder.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status, ok := object.(*metav1.Status); eventType != watch.Error || !ok || status.Code != 410 {
+		t.Errorf("expected an error event with status code 410, got %s %+v", eventType, object)
+	}
+
+	if _, _, err = decoder.Decode(); err == nil {
+		t.Errorf("expected an error decoding an unknown event type")
+	}
+}
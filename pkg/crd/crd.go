@@ -0,0 +1,76 @@
+// Package crd contains the types of the custom resources consumed by kube-router and helpers to read them
+// from the API server.
+package crd
+
+import (
+	"context"
+	"encoding/json"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Group is the API group of the kube-router custom resources
+	Group = "kube-router.io"
+	// Version is the API version of the kube-router custom resources
+	Version = "v1alpha1"
+)
+
+// List lists the cluster scoped custom resources with the plural name resource and decodes them into list,
+// which must be a pointer to the list type of the resource. The custom resources are read through the REST
+// client of the clientset, so no generated client is needed. It returns false when the custom resource
+// definition is not installed in the cluster.
+func List(clientset kubernetes.Interface, resource string, list interface{}) (bool, error) {
+	data, err := clientset.Discovery().RESTClient().Get().AbsPath("/apis", Group, Version, resource).DoRaw(context.Background())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, list)
+}
+
+// Apply creates the cluster scoped custom resource obj with the plural name resource, or replaces it when it
+// exists. obj must carry its API version and kind.
+func Apply(clientset kubernetes.Interface, resource string, obj metav1.Object) error {
+	client := clientset.Discovery().RESTClient()
+	data, err := client.Get().AbsPath("/apis", Group, Version, resource, obj.GetName()).DoRaw(context.Background())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if apierrors.IsNotFound(err) {
+		obj.SetResourceVersion("")
+		body, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		_, err = client.Post().AbsPath("/apis", Group, Version, resource).
+			SetHeader("Content-Type", "application/json").Body(body).DoRaw(context.Background())
+		return err
+	}
+
+	// the update fails on a conflict when the custom resource changed since it was read
+	existing := metav1.ObjectMeta{}
+	if err := json.Unmarshal(data, &struct {
+		Metadata *metav1.ObjectMeta `json:"metadata"`
+	}{&existing}); err != nil {
+		return err
+	}
+	obj.SetResourceVersion(existing.ResourceVersion)
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = client.Put().AbsPath("/apis", Group, Version, resource, obj.GetName()).
+		SetHeader("Content-Type", "application/json").Body(body).DoRaw(context.Background())
+	return err
+}
+
+// Delete deletes the cluster scoped custom resource with the plural name resource and the given name
+func Delete(clientset kubernetes.Interface, resource, name string) error {
+	_, err := clientset.Discovery().RESTClient().Delete().AbsPath("/apis", Group, Version, resource, name).DoRaw(context.Background())
+	return err
+}
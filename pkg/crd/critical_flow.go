@@ -0,0 +1,148 @@
+package crd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CriticalFlowResource is the plural name of the CriticalFlow custom resource
+	CriticalFlowResource = "criticalflows"
+
+	// CriticalFlowDirectionIngress allows the traffic from the CIDRs to the ports of the pods
+	CriticalFlowDirectionIngress = "Ingress"
+	// CriticalFlowDirectionEgress allows the traffic of the pods to the ports of the CIDRs
+	CriticalFlowDirectionEgress = "Egress"
+)
+
+// CriticalFlow allows traffic the nodes need to operate, e.g. of the pods to the API server or to the etcd of a self
+// hosted control plane, ahead of the cluster network policies and of the network policies, which cannot deny it
+type CriticalFlow struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec CriticalFlowSpec `json:"spec"`
+}
+
+// CriticalFlowSpec is the specification of a CriticalFlow
+type CriticalFlowSpec struct {
+	// Direction is Ingress or Egress
+	Direction string `json:"direction"`
+	// CIDRs are the IPv4 sources of the ingress flows and destinations of the egress flows
+	CIDRs []string `json:"cidrs"`
+	// Ports are the destination ports of the flows
+	Ports []CriticalFlowPort `json:"ports"`
+	// PriorityClassNames restrict the flows to the pods of these priority classes, e.g. system-node-critical, all
+	// the pods when empty
+	PriorityClassNames []string `json:"priorityClassNames,omitempty"`
+}
+
+// CriticalFlowPort is a port, or a range of ports, of a CriticalFlow
+type CriticalFlowPort struct {
+	// Protocol is TCP, UDP or SCTP, TCP when empty
+	Protocol api.Protocol `json:"protocol,omitempty"`
+	Port     int32        `json:"port"`
+	// EndPort is the last port of the range starting at Port, if any
+	EndPort int32 `json:"endPort,omitempty"`
+}
+
+// CriticalFlowList is a list of CriticalFlow
+type CriticalFlowList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []CriticalFlow `json:"items"`
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *CriticalFlow) DeepCopyInto(out *CriticalFlow) {
+	*out = *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Spec.CIDRs != nil {
+		out.Spec.CIDRs = append([]string(nil), in.Spec.CIDRs...)
+	}
+	if in.Spec.Ports != nil {
+		out.Spec.Ports = append([]CriticalFlowPort(nil), in.Spec.Ports...)
+	}
+	if in.Spec.PriorityClassNames != nil {
+		out.Spec.PriorityClassNames = append([]string(nil), in.Spec.PriorityClassNames...)
+	}
+}
+
+// DeepCopyObject returns a copy of the receiver, the informers of the custom resource hand out copies
+func (in *CriticalFlow) DeepCopyObject() runtime.Object {
+	out := &CriticalFlow{}
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the receiver
+func (in *CriticalFlowList) DeepCopyObject() runtime.Object {
+	out := &CriticalFlowList{TypeMeta: in.TypeMeta}
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]CriticalFlow, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// ListCriticalFlows returns the CriticalFlows of the cluster, or none when the custom resource definition is not
+// installed
+func ListCriticalFlows(clientset kubernetes.Interface) ([]CriticalFlow, error) {
+	list := &CriticalFlowList{}
+	if _, err := List(clientset, CriticalFlowResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Validate checks the direction, CIDRs, ports and priority classes of the CriticalFlow are well formed
+func (f *CriticalFlow) Validate() error {
+	switch f.Spec.Direction {
+	case CriticalFlowDirectionIngress, CriticalFlowDirectionEgress:
+	default:
+		return fmt.Errorf("invalid direction %q, must be %s or %s", f.Spec.Direction, CriticalFlowDirectionIngress,
+			CriticalFlowDirectionEgress)
+	}
+	if len(f.Spec.CIDRs) == 0 || len(f.Spec.Ports) == 0 {
+		return errors.New("at least a CIDR and a port are required")
+	}
+	for _, cidr := range f.Spec.CIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || ipNet.IP.To4() == nil {
+			return fmt.Errorf("invalid IPv4 CIDR %q", cidr)
+		}
+		// the hash:net ipsets do not store networks of zero prefix size
+		if ones, _ := ipNet.Mask.Size(); ones == 0 {
+			return fmt.Errorf("CIDR %q must have a non zero prefix", cidr)
+		}
+	}
+	for _, port := range f.Spec.Ports {
+		switch port.Protocol {
+		case "", api.ProtocolTCP, api.ProtocolUDP, api.ProtocolSCTP:
+		default:
+			return fmt.Errorf("invalid protocol %q", port.Protocol)
+		}
+		if port.Port < 1 || port.Port > 65535 {
+			return fmt.Errorf("invalid port %d", port.Port)
+		}
+		if port.EndPort != 0 && (port.EndPort < port.Port || port.EndPort > 65535) {
+			return fmt.Errorf("invalid end port %d of port %d", port.EndPort, port.Port)
+		}
+	}
+	for _, name := range f.Spec.PriorityClassNames {
+		if name == "" {
+			return errors.New("empty priority class name")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package crd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_CriticalFlowValidate(t *testing.T) {
+	testcases := []struct {
+		name  string
+		spec  string
+		valid bool
+	}{
+		{
+			"valid",
+			`{"direction": "Egress", "cidrs": ["10.0.0.10/32"], "ports": [{"port": 6443}, ` +
+				`{"port": 2379, "endPort": 2380}]}`,
+			true,
+		},
+		{
+			"priority classes",
+			`{"direction": "Ingress", "cidrs": ["192.168.0.0/16"], "ports": [{"protocol": "UDP", "port": 8472}], ` +
+				`"priorityClassNames": ["system-node-critical"]}`,
+			true,
+		},
+		{
+			"invalid direction",
+			`{"direction": "Both", "cidrs": ["10.0.0.10/32"], "ports": [{"port": 6443}]}`,
+			false,
+		},
+		{
+			"no port",
+			`{"direction": "Egress", "cidrs": ["10.0.0.10/32"]}`,
+			false,
+		},
+		{
+			"zero prefix",
+			`{"direction": "Egress", "cidrs": ["0.0.0.0/0"], "ports": [{"port": 6443}]}`,
+			false,
+		},
+		{
+			"IPv6 CIDR",
+			`{"direction": "Egress", "cidrs": ["fd00::1/128"], "ports": [{"port": 6443}]}`,
+			false,
+		},
+		{
+			"end port before port",
+			`{"direction": "Egress", "cidrs": ["10.0.0.10/32"], "ports": [{"port": 2380, "endPort": 2379}]}`,
+			false,
+		},
+		{
+			"empty priority class",
+			`{"direction": "Egress", "cidrs": ["10.0.0.10/32"], "ports": [{"port": 6443}], "priorityClassNames": [""]}`,
+			false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			f := &CriticalFlow{}
+			if err := json.Unmarshal([]byte(`{"metadata": {"name": "apiserver"}, "spec": `+testcase.spec+`}`), f); err != nil {
+				t.Fatalf("unexpected error decoding critical flow: %v", err)
+			}
+			err := f.Validate()
+			if testcase.valid && err != nil {
+				t.Errorf("expected critical flow to be valid but got %v", err)
+			}
+			if !testcase.valid && err == nil {
+				t.Errorf("expected critical flow to be invalid")
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package crd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// time a watch of a custom resource whose definition is not installed waits before the informer lists it again
+const missingDefinitionRelistPeriod = 5 * time.Minute
+
+// NewListWatch returns the list and watch functions of the informer of the cluster scoped custom resources with the
+// plural name resource. newList returns an empty list of the resource and newObject an empty object of it. Like
+// List, it reads the custom resources through the REST client of the clientset, and lists none when the custom
+// resource definition is not installed, in which case the watch only waits for the next list.
+func NewListWatch(clientset kubernetes.Interface, resource string, newList, newObject func() runtime.Object) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			request := clientset.Discovery().RESTClient().Get().AbsPath("/apis", Group, Version, resource)
+			if options.ResourceVersion != "" {
+				request = request.Param("resourceVersion", options.ResourceVersion)
+			}
+			if options.Limit > 0 {
+				request = request.Param("limit", strconv.FormatInt(options.Limit, 10))
+			}
+			if options.Continue != "" {
+				request = request.Param("continue", options.Continue)
+			}
+			list := newList()
+			data, err := request.DoRaw(context.Background())
+			if apierrors.IsNotFound(err) {
+				return list, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return list, json.Unmarshal(data, list)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			request := clientset.Discovery().RESTClient().Get().AbsPath("/apis", Group, Version, resource).
+				Param("watch", "true")
+			if options.ResourceVersion != "" {
+				request = request.Param("resourceVersion", options.ResourceVersion)
+			}
+			if options.TimeoutSeconds != nil {
+				request = request.Param("timeoutSeconds", strconv.FormatInt(*options.TimeoutSeconds, 10))
+			}
+			stream, err := request.Stream(context.Background())
+			if apierrors.IsNotFound(err) {
+				return newIdleWatch(missingDefinitionRelistPeriod), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return watch.NewStreamWatcher(newWatchDecoder(stream, newObject),
+				apierrors.NewClientErrorReporter(http.StatusInternalServerError, "GET", "ClientWatchDecoding")), nil
+		},
+	}
+}
+
+// watchDecoder decodes the JSON events of the watch of a custom resource
+type watchDecoder struct {
+	stream    io.ReadCloser
+	decoder   *json.Decoder
+	newObject func() runtime.Object
+}
+
+func newWatchDecoder(stream io.ReadCloser, newObject func() runtime.Object) *watchDecoder {
+	return &watchDecoder{stream: stream, decoder: json.NewDecoder(stream), newObject: newObject}
+}
+
+// Decode returns the next event of the watch, the errors carry a Status
+func (d *watchDecoder) Decode() (watch.EventType, runtime.Object, error) {
+	var event struct {
+		Type   watch.EventType `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := d.decoder.Decode(&event); err != nil {
+		return "", nil, err
+	}
+	var object runtime.Object
+	switch event.Type {
+	case watch.Added, watch.Modified, watch.Deleted:
+		object = d.newObject()
+	case watch.Error:
+		object = &metav1.Status{}
+	default:
+		return "", nil, fmt.Errorf("unknown watch event type %q", event.Type)
+	}
+	if err := json.Unmarshal(event.Object, object); err != nil {
+		return "", nil, err
+	}
+	return event.Type, object, nil
+}
+
+func (d *watchDecoder) Close() {
+	d.stream.Close()
+}
+
+// idleWatch is a watch receiving no event, closed after its period
+type idleWatch struct {
+	result chan watch.Event
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func newIdleWatch(period time.Duration) *idleWatch {
+	w := &idleWatch{result: make(chan watch.Event), stop: make(chan struct{})}
+	go func() {
+		defer close(w.result)
+		select {
+		case <-time.After(period):
+		case <-w.stop:
+		}
+	}()
+	return w
+}
+
+func (w *idleWatch) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+func (w *idleWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
@@ -0,0 +1,96 @@
+package crd
+
+import (
+	"fmt"
+
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NamespaceIsolationProfileResource is the plural name of the NamespaceIsolationProfile custom resource
+const NamespaceIsolationProfileResource = "namespaceisolationprofiles"
+
+// NamespaceIsolationProfile describes the isolation of the namespaces matching its namespace selector. The
+// network policy controller expands it into a standard set of network policies in each of the namespaces.
+type NamespaceIsolationProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NamespaceIsolationProfileSpec `json:"spec"`
+}
+
+// NamespaceIsolationProfileSpec is the specification of a NamespaceIsolationProfile
+type NamespaceIsolationProfileSpec struct {
+	// NamespaceSelector selects the namespaces the profile applies to
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+	// PolicyTypes are the directions traffic of the pods in the namespaces is denied by default, both when
+	// empty
+	PolicyTypes []networking.PolicyType `json:"policyTypes,omitempty"`
+	// AllowSameNamespace allows the traffic between pods of the same namespace
+	AllowSameNamespace bool `json:"allowSameNamespace,omitempty"`
+	// AllowKubeSystemDNS allows the DNS queries to the cluster DNS pods in the kube-system namespace
+	AllowKubeSystemDNS bool `json:"allowKubeSystemDNS,omitempty"`
+}
+
+// NamespaceIsolationProfileList is a list of NamespaceIsolationProfile
+type NamespaceIsolationProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NamespaceIsolationProfile `json:"items"`
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *NamespaceIsolationProfile) DeepCopyInto(out *NamespaceIsolationProfile) {
+	*out = *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.NamespaceSelector.DeepCopyInto(&out.Spec.NamespaceSelector)
+	if in.Spec.PolicyTypes != nil {
+		out.Spec.PolicyTypes = append([]networking.PolicyType(nil), in.Spec.PolicyTypes...)
+	}
+}
+
+// DeepCopyObject returns a copy of the receiver, the informers of the custom resource hand out copies
+func (in *NamespaceIsolationProfile) DeepCopyObject() runtime.Object {
+	out := &NamespaceIsolationProfile{}
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the receiver
+func (in *NamespaceIsolationProfileList) DeepCopyObject() runtime.Object {
+	out := &NamespaceIsolationProfileList{TypeMeta: in.TypeMeta}
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]NamespaceIsolationProfile, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// ListNamespaceIsolationProfiles returns the NamespaceIsolationProfiles of the cluster, or none when the
+// custom resource definition is not installed
+func ListNamespaceIsolationProfiles(clientset kubernetes.Interface) ([]NamespaceIsolationProfile, error) {
+	list := &NamespaceIsolationProfileList{}
+	if _, err := List(clientset, NamespaceIsolationProfileResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Validate checks the namespace selector and policy types of the NamespaceIsolationProfile are well formed
+func (p *NamespaceIsolationProfile) Validate() error {
+	if _, err := metav1.LabelSelectorAsSelector(&p.Spec.NamespaceSelector); err != nil {
+		return fmt.Errorf("invalid namespace selector: %s", err)
+	}
+	for _, policyType := range p.Spec.PolicyTypes {
+		if policyType != networking.PolicyTypeIngress && policyType != networking.PolicyTypeEgress {
+			return fmt.Errorf("invalid policy type %q", policyType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package crd
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// NodePolicyStatusResource is the plural name of the NodePolicyStatus custom resource
+	NodePolicyStatusResource = "nodepolicystatuses"
+	// NodePolicyStatusKind is the kind of the NodePolicyStatus custom resource
+	NodePolicyStatusKind = "NodePolicyStatus"
+	// ClusterPolicyStatusResource is the plural name of the ClusterPolicyStatus custom resource
+	ClusterPolicyStatusResource = "clusterpolicystatuses"
+	// ClusterPolicyStatusKind is the kind of the ClusterPolicyStatus custom resource
+	ClusterPolicyStatusKind = "ClusterPolicyStatus"
+	// ClusterPolicyStatusName is the name of the single ClusterPolicyStatus of the cluster
+	ClusterPolicyStatusName = "cluster"
+)
+
+// NodePolicyStatus reports the enforcement of the network policies by the kube-router of a node, it is named
+// after the node and owned by it so it is garbage collected along with the node
+type NodePolicyStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status NodePolicyStatusStatus `json:"status"`
+}
+
+// NodePolicyStatusStatus is the outcome of the last sync of the network policies on a node
+type NodePolicyStatusStatus struct {
+	// SyncTime is the time of the sync the status was reported from
+	SyncTime metav1.Time `json:"syncTime"`
+	// Policies are the network policies accounted for by the sync, as namespace/name
+	Policies []string `json:"policies,omitempty"`
+	// Error is the error the sync failed with, the policies are not enforced as desired when it is set
+	Error string `json:"error,omitempty"`
+}
+
+// NodePolicyStatusList is a list of NodePolicyStatus
+type NodePolicyStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NodePolicyStatus `json:"items"`
+}
+
+// ClusterPolicyStatus summarizes the enforcement of each network policy over the nodes of the cluster
+type ClusterPolicyStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status ClusterPolicyStatusStatus `json:"status"`
+}
+
+// ClusterPolicyStatusStatus is the enforcement of the network policies aggregated from the NodePolicyStatuses
+type ClusterPolicyStatusStatus struct {
+	// UpdateTime is the time the status was aggregated
+	UpdateTime metav1.Time `json:"updateTime"`
+	// Nodes is the number of nodes of the cluster
+	Nodes int `json:"nodes"`
+	// ReportingNodes is the number of nodes reporting a NodePolicyStatus
+	ReportingNodes int                 `json:"reportingNodes"`
+	Policies       []PolicyEnforcement `json:"policies,omitempty"`
+}
+
+// PolicyEnforcement is the enforcement of a network policy over the nodes of the cluster
+type PolicyEnforcement struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// EnforcedNodes is the number of nodes whose last sync enforced the policy
+	EnforcedNodes int `json:"enforcedNodes"`
+	// FailingNodes are the nodes whose last sync of the policy failed, up to a limit
+	FailingNodes []NodeFailure `json:"failingNodes,omitempty"`
+	// Summary sums up the enforcement, e.g. "enforced on 98/100 nodes, failing on node-7"
+	Summary string `json:"summary"`
+}
+
+// NodeFailure is the error the sync of the network policies failed with on a node
+type NodeFailure struct {
+	Node  string `json:"node"`
+	Error string `json:"error"`
+}
+
+// ListNodePolicyStatuses returns the NodePolicyStatuses of the cluster, or none when the custom resource
+// definition is not installed
+func ListNodePolicyStatuses(clientset kubernetes.Interface) ([]NodePolicyStatus, error) {
+	list := &NodePolicyStatusList{}
+	if _, err := List(clientset, NodePolicyStatusResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// NewNodePolicyStatus returns the NodePolicyStatus of a node, owned by the node when its UID is given
+func NewNodePolicyStatus(node string, uid types.UID, status NodePolicyStatusStatus) *NodePolicyStatus {
+	nps := &NodePolicyStatus{
+		TypeMeta:   metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: NodePolicyStatusKind},
+		ObjectMeta: metav1.ObjectMeta{Name: node},
+		Status:     status,
+	}
+	if uid != "" {
+		nps.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Node", Name: node, UID: uid}}
+	}
+	return nps
+}
+
+// NewClusterPolicyStatus returns the ClusterPolicyStatus of the cluster
+func NewClusterPolicyStatus(status ClusterPolicyStatusStatus) *ClusterPolicyStatus {
+	return &ClusterPolicyStatus{
+		TypeMeta:   metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: ClusterPolicyStatusKind},
+		ObjectMeta: metav1.ObjectMeta{Name: ClusterPolicyStatusName},
+		Status:     status,
+	}
+}
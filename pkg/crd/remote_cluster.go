@@ -0,0 +1,153 @@
+package crd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// RemoteClusterResource is the plural name of the RemoteCluster custom resource
+	RemoteClusterResource = "remoteclusters"
+	// RemoteNamespaceIPSetPrefix is the prefix of the names of the ipsets holding the CIDRs of remote namespaces
+	RemoteNamespaceIPSetPrefix = "KUBE-RMT-"
+)
+
+// RemoteCluster describes another cluster whose pods and services are reachable from this cluster. The
+// routing controller routes the pod and service CIDRs of the remote cluster toward its BGP endpoints, and
+// creates an ipset per listed remote namespace that can be referenced from iptables rules.
+type RemoteCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RemoteClusterSpec `json:"spec"`
+}
+
+// RemoteClusterSpec is the specification of a RemoteCluster
+type RemoteClusterSpec struct {
+	// PodCIDRs are the CIDRs pods of the remote cluster get their addresses from
+	PodCIDRs []string `json:"podCIDRs,omitempty"`
+	// ServiceCIDRs are the CIDRs cluster IPs of the remote cluster are allocated from
+	ServiceCIDRs []string `json:"serviceCIDRs,omitempty"`
+	// BGPEndpoints are the BGP speakers of the remote cluster, the first one is used as next hop toward
+	// the remote cluster
+	BGPEndpoints []RemoteClusterBGPEndpoint `json:"bgpEndpoints"`
+	// Namespaces optionally lists the CIDRs used by pods of namespaces of the remote cluster
+	Namespaces []RemoteClusterNamespace `json:"namespaces,omitempty"`
+}
+
+// RemoteClusterBGPEndpoint is a BGP speaker of a remote cluster
+type RemoteClusterBGPEndpoint struct {
+	Address string `json:"address"`
+}
+
+// RemoteClusterNamespace lists the CIDRs used by the pods of a namespace of a remote cluster
+type RemoteClusterNamespace struct {
+	Name  string   `json:"name"`
+	CIDRs []string `json:"cidrs"`
+}
+
+// RemoteClusterList is a list of RemoteCluster
+type RemoteClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []RemoteCluster `json:"items"`
+}
+
+// DeepCopyInto copies the receiver into out
+func (in *RemoteCluster) DeepCopyInto(out *RemoteCluster) {
+	*out = *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Spec.PodCIDRs != nil {
+		out.Spec.PodCIDRs = append([]string(nil), in.Spec.PodCIDRs...)
+	}
+	if in.Spec.ServiceCIDRs != nil {
+		out.Spec.ServiceCIDRs = append([]string(nil), in.Spec.ServiceCIDRs...)
+	}
+	if in.Spec.BGPEndpoints != nil {
+		out.Spec.BGPEndpoints = append([]RemoteClusterBGPEndpoint(nil), in.Spec.BGPEndpoints...)
+	}
+	if in.Spec.Namespaces != nil {
+		out.Spec.Namespaces = make([]RemoteClusterNamespace, len(in.Spec.Namespaces))
+		for i := range in.Spec.Namespaces {
+			out.Spec.Namespaces[i].Name = in.Spec.Namespaces[i].Name
+			if in.Spec.Namespaces[i].CIDRs != nil {
+				out.Spec.Namespaces[i].CIDRs = append([]string(nil), in.Spec.Namespaces[i].CIDRs...)
+			}
+		}
+	}
+}
+
+// DeepCopyObject returns a copy of the receiver, the informers of the custom resource hand out copies
+func (in *RemoteCluster) DeepCopyObject() runtime.Object {
+	out := &RemoteCluster{}
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject returns a copy of the receiver
+func (in *RemoteClusterList) DeepCopyObject() runtime.Object {
+	out := &RemoteClusterList{TypeMeta: in.TypeMeta}
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]RemoteCluster, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// ListRemoteClusters returns the RemoteClusters of the cluster, or none when the custom resource definition
+// is not installed
+func ListRemoteClusters(clientset kubernetes.Interface) ([]RemoteCluster, error) {
+	list := &RemoteClusterList{}
+	if _, err := List(clientset, RemoteClusterResource, list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// Validate checks the addresses and CIDRs of the RemoteCluster are well formed
+func (rc *RemoteCluster) Validate() error {
+	if len(rc.Spec.BGPEndpoints) == 0 {
+		return errors.New("at least one BGP endpoint is required")
+	}
+	for _, endpoint := range rc.Spec.BGPEndpoints {
+		if net.ParseIP(endpoint.Address) == nil {
+			return fmt.Errorf("invalid BGP endpoint address %q", endpoint.Address)
+		}
+	}
+	cidrs := append(append([]string{}, rc.Spec.PodCIDRs...), rc.Spec.ServiceCIDRs...)
+	for _, namespace := range rc.Spec.Namespaces {
+		if namespace.Name == "" {
+			return errors.New("namespace without name")
+		}
+		cidrs = append(cidrs, namespace.CIDRs...)
+	}
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+	}
+	return nil
+}
+
+// NextHop returns the address traffic toward the remote cluster is routed to
+func (rc *RemoteCluster) NextHop() net.IP {
+	if len(rc.Spec.BGPEndpoints) == 0 {
+		return nil
+	}
+	return net.ParseIP(rc.Spec.BGPEndpoints[0].Address)
+}
+
+// RemoteNamespaceIPSetName returns the name of the ipset holding the CIDRs of a namespace of a remote cluster
+func RemoteNamespaceIPSetName(cluster, namespace string) string {
+	return utils.HashedIPSetName(RemoteNamespaceIPSetPrefix, cluster+"/"+namespace, false)
+}
@@ -0,0 +1,72 @@
+package crd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_RemoteClusterValidate(t *testing.T) {
+	testcases := []struct {
+		name  string
+		spec  string
+		valid bool
+	}{
+		{
+			"valid",
+			`{"podCIDRs": ["10.32.0.0/16"], "serviceCIDRs": ["10.33.0.0/16"], "bgpEndpoints": [{"address": "192.168.10.11"}],
+			  "namespaces": [{"name": "frontend", "cidrs": ["10.32.4.0/22"]}]}`,
+			true,
+		},
+		{
+			"no BGP endpoint",
+			`{"podCIDRs": ["10.32.0.0/16"]}`,
+			false,
+		},
+		{
+			"invalid BGP endpoint",
+			`{"podCIDRs": ["10.32.0.0/16"], "bgpEndpoints": [{"address": "east-gw"}]}`,
+			false,
+		},
+		{
+			"invalid pod CIDR",
+			`{"podCIDRs": ["10.32.0.0"], "bgpEndpoints": [{"address": "192.168.10.11"}]}`,
+			false,
+		},
+		{
+			"invalid namespace CIDR",
+			`{"bgpEndpoints": [{"address": "192.168.10.11"}], "namespaces": [{"name": "frontend", "cidrs": ["frontend"]}]}`,
+			false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			rc := &RemoteCluster{}
+			if err := json.Unmarshal([]byte(`{"metadata": {"name": "east"}, "spec": `+testcase.spec+`}`), rc); err != nil {
+				t.Fatalf("unexpected error decoding remote cluster: %v", err)
+			}
+			err := rc.Validate()
+			if testcase.valid && err != nil {
+				t.Errorf("expected remote cluster to be valid but got %v", err)
+			}
+			if !testcase.valid && err == nil {
+				t.Errorf("expected remote cluster to be invalid")
+			}
+		})
+	}
+}
+
+func Test_RemoteNamespaceIPSetName(t *testing.T) {
+	name := RemoteNamespaceIPSetName("east", "frontend")
+	if !strings.HasPrefix(name, RemoteNamespaceIPSetPrefix) {
+		t.Errorf("expected ipset name %s to start with %s", name, RemoteNamespaceIPSetPrefix)
+	}
+	// ipset names are limited to 31 characters, including the suffix of the temporary set used to refresh it
+	if len(name+"-") > 31 {
+		t.Errorf("ipset name %s is too long", name)
+	}
+	if name == RemoteNamespaceIPSetName("west", "frontend") {
+		t.Errorf("expected namespaces of different clusters to have different ipsets")
+	}
+}
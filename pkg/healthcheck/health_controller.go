@@ -2,6 +2,7 @@ package healthcheck
 
 import (
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -15,6 +16,9 @@ import (
 type ControllerHeartbeat struct {
 	Component     string
 	LastHeartBeat time.Time
+	// type and message of the error of a failed sync, empty for the heartbeats of the successful ones
+	ErrorType string
+	Error     string
 }
 
 //HealthController reports the health of the controller loops as a http endpoint
@@ -23,6 +27,12 @@ type HealthController struct {
 	HTTPEnabled bool
 	Status      HealthStats
 	Config      *options.KubeRouterConfig
+	// failed preflight checks, reported along the health
+	PreflightFailures []string
+	// APIStaleSince returns since when the informer caches are stale, the zero time while the API server is
+	// reachable. The controllers keep enforcing the stale state, which is reported along the health.
+	APIStaleSince func() time.Time
+	watchdog      *watchdog
 }
 
 //HealthStats is holds the latest heartbeats
@@ -36,10 +46,16 @@ type HealthStats struct {
 	NetworkRoutingControllerAliveTTL  time.Duration
 	NetworkServicesControllerAlive    time.Time
 	NetworkServicesControllerAliveTTL time.Duration
+	// last error of the controllers whose last sync failed, by component
+	ControllerErrors map[string]*ControllerHeartbeat
 }
 
-//SendHeartBeat sends a heartbeat on the passed channel
+//SendHeartBeat sends a heartbeat on the passed channel, if any. Controllers run outside of kube-router, e.g. by
+//kube-routerctl, have none.
 func SendHeartBeat(channel chan<- *ControllerHeartbeat, controller string) {
+	if channel == nil {
+		return
+	}
 	heartbeat := ControllerHeartbeat{
 		Component:     controller,
 		LastHeartBeat: time.Now(),
@@ -47,11 +63,28 @@ func SendHeartBeat(channel chan<- *ControllerHeartbeat, controller string) {
 	channel <- &heartbeat
 }
 
+//SendError reports the failed sync of a controller and the type of its error on the passed channel, if any. The
+//error is reported by the health endpoint until the controller sends a heartbeat again.
+func SendError(channel chan<- *ControllerHeartbeat, controller, errorType string, err error) {
+	if channel == nil {
+		return
+	}
+	channel <- &ControllerHeartbeat{
+		Component:     controller,
+		LastHeartBeat: time.Now(),
+		ErrorType:     errorType,
+		Error:         err.Error(),
+	}
+}
+
 //Handler writes HTTP responses to the health path
 func (hc *HealthController) Handler(w http.ResponseWriter, req *http.Request) {
 	if hc.Status.Healthy {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK\n"))
+		hc.writePreflightFailures(w)
+		hc.writeAPIStaleness(w)
+		hc.writeControllerErrors(w)
 	} else {
 		w.WriteHeader(http.StatusInternalServerError)
 		/*
@@ -66,6 +99,45 @@ func (hc *HealthController) Handler(w http.ResponseWriter, req *http.Request) {
 			w.Write([]byte(statusText))
 		*/
 		w.Write([]byte("Unhealthy"))
+		hc.writePreflightFailures(w)
+		hc.writeAPIStaleness(w)
+		hc.writeControllerErrors(w)
+	}
+}
+
+// writePreflightFailures lists the failed preflight checks in the health response
+func (hc *HealthController) writePreflightFailures(w http.ResponseWriter) {
+	for _, failure := range hc.PreflightFailures {
+		w.Write([]byte("\npreflight " + failure))
+	}
+}
+
+// writeAPIStaleness reports for how long the informer caches have been stale in the health response
+func (hc *HealthController) writeAPIStaleness(w http.ResponseWriter) {
+	if hc.APIStaleSince == nil {
+		return
+	}
+	since := hc.APIStaleSince()
+	if since.IsZero() {
+		return
+	}
+	w.Write([]byte("\nstale API server unreachable for " + time.Since(since).Round(time.Second).String() +
+		", enforcing the last known state"))
+}
+
+// writeControllerErrors lists the errors of the controllers whose last sync failed in the health response, e.g.
+// "error NPC iptables_lock: ..."
+func (hc *HealthController) writeControllerErrors(w http.ResponseWriter) {
+	hc.Status.Lock()
+	defer hc.Status.Unlock()
+	components := make([]string, 0, len(hc.Status.ControllerErrors))
+	for component := range hc.Status.ControllerErrors {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		beat := hc.Status.ControllerErrors[component]
+		w.Write([]byte("\nerror " + component + " " + beat.ErrorType + ": " + beat.Error))
 	}
 }
 
@@ -76,6 +148,16 @@ func (hc *HealthController) HandleHeartbeat(beat *ControllerHeartbeat) {
 	hc.Status.Lock()
 	defer hc.Status.Unlock()
 
+	// errors are no heartbeats, the controller is still considered alive until its heartbeat is missed
+	if beat.ErrorType != "" {
+		if hc.Status.ControllerErrors == nil {
+			hc.Status.ControllerErrors = make(map[string]*ControllerHeartbeat)
+		}
+		hc.Status.ControllerErrors[beat.Component] = beat
+		return
+	}
+	delete(hc.Status.ControllerErrors, beat.Component)
+
 	switch {
 	// The first heartbeat will set the initial gracetime the controller has to report in, A static time is added as well when checking to allow for load variation in sync time
 	case beat.Component == "NSC":
@@ -101,27 +183,40 @@ func (hc *HealthController) HandleHeartbeat(beat *ControllerHeartbeat) {
 	}
 }
 
+// syncPeriod returns the period of the periodic syncs of a controller, stretched as much as the load governor can,
+// plus the delay of up to a period the sync coordinator staggers them by
+func (hc *HealthController) syncPeriod(period time.Duration) time.Duration {
+	stretched := period
+	if hc.Config.LoadGovernor && hc.Config.LoadGovernorMaxStretch > 1 {
+		stretched = period * time.Duration(hc.Config.LoadGovernorMaxStretch)
+	}
+	if hc.Config.SyncCoordinator {
+		stretched += period
+	}
+	return stretched
+}
+
 // CheckHealth evaluates the time since last heartbeat to decide if the controller is running or not
 func (hc *HealthController) CheckHealth() bool {
 	health := true
 	graceTime := time.Duration(1500 * time.Millisecond)
 
 	if hc.Config.RunFirewall {
-		if time.Since(hc.Status.NetworkPolicyControllerAlive) > hc.Config.IPTablesSyncPeriod+hc.Status.NetworkPolicyControllerAliveTTL+graceTime {
+		if time.Since(hc.Status.NetworkPolicyControllerAlive) > hc.syncPeriod(hc.Config.IPTablesSyncPeriod)+hc.Status.NetworkPolicyControllerAliveTTL+graceTime {
 			glog.Error("Network Policy Controller heartbeat missed")
 			health = false
 		}
 	}
 
 	if hc.Config.RunRouter {
-		if time.Since(hc.Status.NetworkRoutingControllerAlive) > hc.Config.RoutesSyncPeriod+hc.Status.NetworkRoutingControllerAliveTTL+graceTime {
+		if time.Since(hc.Status.NetworkRoutingControllerAlive) > hc.syncPeriod(hc.Config.RoutesSyncPeriod)+hc.Status.NetworkRoutingControllerAliveTTL+graceTime {
 			glog.Error("Network Routing Controller heartbeat missed")
 			health = false
 		}
 	}
 
 	if hc.Config.RunServiceProxy {
-		if time.Since(hc.Status.NetworkServicesControllerAlive) > hc.Config.IpvsSyncPeriod+hc.Status.NetworkServicesControllerAliveTTL+graceTime {
+		if time.Since(hc.Status.NetworkServicesControllerAlive) > hc.syncPeriod(hc.Config.IpvsSyncPeriod)+hc.Status.NetworkServicesControllerAliveTTL+graceTime {
 			glog.Error("NetworkService Controller heartbeat missed")
 			health = false
 		}
@@ -170,12 +265,13 @@ func (hc *HealthController) RunServer(stopCh <-chan struct{}, wg *sync.WaitGroup
 
 //RunCheck starts the HealthController's check
 func (hc *HealthController) RunCheck(healthChan <-chan *ControllerHeartbeat, stopCh <-chan struct{}, wg *sync.WaitGroup) error {
-	t := time.NewTicker(5000 * time.Millisecond)
+	t := time.NewTicker(hc.watchdog.tickInterval())
 	defer wg.Done()
 	for {
 		select {
 		case <-stopCh:
 			glog.Infof("Shutting down HealthController RunCheck")
+			hc.watchdog.stop()
 			return nil
 		case heartbeat := <-healthChan:
 			hc.HandleHeartbeat(heartbeat)
@@ -183,6 +279,7 @@ func (hc *HealthController) RunCheck(healthChan <-chan *ControllerHeartbeat, sto
 			glog.V(4).Info("Health controller tick")
 		}
 		hc.Status.Healthy = hc.CheckHealth()
+		hc.watchdog.notify(hc.Status.Healthy)
 	}
 }
 
@@ -204,6 +301,7 @@ func NewHealthController(config *options.KubeRouterConfig) (*HealthController, e
 		Status: HealthStats{
 			Healthy: true,
 		},
+		watchdog: newWatchdog(config.LivenessFile),
 	}
 	return &hc, nil
 }
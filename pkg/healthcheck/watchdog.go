@@ -0,0 +1,142 @@
+package healthcheck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The health check notifies the supervisor of kube-router while the controllers are healthy, so a kube-router whose
+// controllers or health check are hung gets restarted even when the health port still answers. Under systemd, the
+// service manager is told kube-router is ready, then watchdog keep-alives are sent on $NOTIFY_SOCKET at half the
+// WatchdogSec of the unit. In containers, the modification time of the liveness file is updated for an exec liveness
+// probe to check. The notifications stop as soon as a check fails or the check loop stops running.
+
+const (
+	// checkInterval is the interval of the health checks, unless the systemd watchdog needs shorter ones
+	checkInterval = 5 * time.Second
+	// livenessFileMode is the mode of the liveness file, when kube-router creates it
+	livenessFileMode = 0644
+)
+
+// watchdog notifies systemd and updates the liveness file on the health checks
+type watchdog struct {
+	// unix datagram socket of the service manager, empty when not run by systemd
+	notifySocket string
+	// interval of the keep-alives expected by the service manager, 0 when its watchdog is disabled
+	interval     time.Duration
+	livenessFile string
+	ready        bool
+}
+
+// newWatchdog returns the watchdog of the environment kube-router is run in, updating the liveness file if not empty
+func newWatchdog(livenessFile string) *watchdog {
+	w := &watchdog{notifySocket: os.Getenv("NOTIFY_SOCKET"), livenessFile: livenessFile}
+	if w.notifySocket == "" {
+		return w
+	}
+	interval, err := systemdWatchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"), os.Getpid())
+	if err != nil {
+		glog.Errorf("Ignoring the systemd watchdog: %s", err)
+		return w
+	}
+	w.interval = interval
+	if interval > 0 {
+		glog.Infof("Sending keep-alives to the systemd watchdog every %s", interval/2)
+	}
+	return w
+}
+
+// systemdWatchdogInterval returns the interval of the systemd watchdog from the environment of the service, 0 when
+// the watchdog is disabled or supervises another process
+func systemdWatchdogInterval(usec, pid string, ownPid int) (time.Duration, error) {
+	if usec == "" {
+		return 0, nil
+	}
+	if pid != "" {
+		p, err := strconv.Atoi(pid)
+		if err != nil {
+			return 0, fmt.Errorf("WATCHDOG_PID %q is not a pid", pid)
+		}
+		if p != ownPid {
+			return 0, nil
+		}
+	}
+	interval, err := strconv.ParseUint(usec, 10, 63)
+	if err != nil || interval == 0 {
+		return 0, fmt.Errorf("WATCHDOG_USEC %q is not a positive number of microseconds", usec)
+	}
+	return time.Duration(interval) * time.Microsecond, nil
+}
+
+// tickInterval returns the interval of the health checks, short enough for the keep-alives of the systemd watchdog
+func (w *watchdog) tickInterval() time.Duration {
+	if w.interval > 0 && w.interval/2 < checkInterval {
+		return w.interval / 2
+	}
+	return checkInterval
+}
+
+// notify notifies the service manager and updates the liveness file when the health check passed
+func (w *watchdog) notify(healthy bool) {
+	if !healthy {
+		return
+	}
+	if w.notifySocket != "" && !w.ready {
+		if err := w.send("READY=1"); err != nil {
+			glog.Errorf("Failed to notify systemd that kube-router is ready: %s", err)
+		} else {
+			w.ready = true
+		}
+	}
+	if w.interval > 0 {
+		if err := w.send("WATCHDOG=1"); err != nil {
+			glog.Errorf("Failed to send a keep-alive to the systemd watchdog: %s", err)
+		}
+	}
+	if w.livenessFile != "" {
+		if err := touch(w.livenessFile); err != nil {
+			glog.Errorf("Failed to update the liveness file %s: %s", w.livenessFile, err)
+		}
+	}
+}
+
+// stop notifies the service manager that kube-router is stopping
+func (w *watchdog) stop() {
+	if w.notifySocket == "" {
+		return
+	}
+	if err := w.send("STOPPING=1"); err != nil {
+		glog.Errorf("Failed to notify systemd that kube-router is stopping: %s", err)
+	}
+}
+
+// send sends the state to the notification socket of the service manager, see sd_notify(3)
+func (w *watchdog) send(state string) error {
+	addr := &net.UnixAddr{Name: w.notifySocket, Net: "unixgram"}
+	// abstract sockets are given with a leading @
+	if addr.Name[0] == '@' {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// touch updates the modification time of the file, creating it if missing
+func touch(path string) error {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(path, nil, livenessFileMode)
+}
@@ -0,0 +1,101 @@
+package healthcheck
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSystemdWatchdogInterval(t *testing.T) {
+	testcases := []struct {
+		name     string
+		usec     string
+		pid      string
+		expected time.Duration
+		err      bool
+	}{
+		{"disabled", "", "", 0, false},
+		{"enabled", "20000000", "", 20 * time.Second, false},
+		{"enabled for kube-router", "4000000", "42", 4 * time.Second, false},
+		{"enabled for another process", "4000000", "43", 0, false},
+		{"malformed interval", "20s", "", 0, true},
+		{"zero interval", "0", "", 0, true},
+		{"malformed pid", "4000000", "kube-router", 0, true},
+	}
+	for _, testcase := range testcases {
+		interval, err := systemdWatchdogInterval(testcase.usec, testcase.pid, 42)
+		if (err != nil) != testcase.err || interval != testcase.expected {
+			t.Errorf("%s: expected interval %s and error %t but got %s and %v", testcase.name, testcase.expected,
+				testcase.err, interval, err)
+		}
+	}
+}
+
+func TestWatchdogNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-router-watchdog")
+	if err != nil {
+		t.Fatalf("failed to create the temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %s", socket, err)
+	}
+	defer conn.Close()
+	received := func() []string {
+		states := make([]string, 0)
+		buf := make([]byte, 64)
+		for {
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return states
+			}
+			states = append(states, string(buf[:n]))
+		}
+	}
+
+	livenessFile := filepath.Join(dir, "alive")
+	w := &watchdog{notifySocket: socket, interval: 2 * time.Second, livenessFile: livenessFile}
+	if w.tickInterval() != time.Second {
+		t.Errorf("expected the checks every second but got every %s", w.tickInterval())
+	}
+
+	w.notify(false)
+	if states := received(); len(states) != 0 {
+		t.Errorf("expected no notification while unhealthy but got %v", states)
+	}
+	if _, err := os.Stat(livenessFile); !os.IsNotExist(err) {
+		t.Errorf("expected no liveness file while unhealthy but got %v", err)
+	}
+
+	w.notify(true)
+	w.notify(true)
+	if states := strings.Join(received(), ","); states != "READY=1,WATCHDOG=1,WATCHDOG=1" {
+		t.Errorf("expected readiness then keep-alives but got %s", states)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(livenessFile, old, old); err != nil {
+		t.Fatalf("failed to age the liveness file: %s", err)
+	}
+	w.notify(true)
+	received()
+	info, err := os.Stat(livenessFile)
+	if err != nil {
+		t.Fatalf("expected the liveness file but got %s", err)
+	}
+	if time.Since(info.ModTime()) > time.Minute {
+		t.Errorf("expected the liveness file to be updated but it was modified at %s", info.ModTime())
+	}
+
+	w.stop()
+	if states := strings.Join(received(), ","); states != "STOPPING=1" {
+		t.Errorf("expected the stopping notification but got %s", states)
+	}
+}
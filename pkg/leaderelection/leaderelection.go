@@ -0,0 +1,312 @@
+// Package leaderelection elects one kube-router instance of the cluster to run the cluster-scope tasks, such as
+// allocating IPs or aggregating the status of custom resources, while the node-scope controllers keep running on
+// every node. The leadership is held by renewing a record in an annotation of a ConfigMap.
+package leaderelection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+
+	v1core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LeaderAnnotation is the annotation of the lock ConfigMap holding the leader election record
+const LeaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+// Record is the leader election record stored in the lock
+type Record struct {
+	HolderIdentity       string      `json:"holderIdentity"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+	LeaderTransitions    int         `json:"leaderTransitions"`
+}
+
+// Config identifies the lock and the candidate, and sets the timings of the election
+type Config struct {
+	// namespace and name of the lock ConfigMap
+	Namespace string
+	Name      string
+	// identity of the candidate, unique in the cluster
+	Identity string
+	// duration the other candidates wait for the leader to renew before taking over
+	LeaseDuration time.Duration
+	// duration the leader retries to renew before it gives up the leadership
+	RenewDeadline time.Duration
+	// interval between the attempts to acquire or renew
+	RetryPeriod time.Duration
+}
+
+// Task is a cluster-scope task, run while the instance leads until stopCh is closed
+type Task func(stopCh <-chan struct{})
+
+type namedTask struct {
+	name string
+	run  Task
+}
+
+// Elector campaigns for the leadership and runs the cluster-scope tasks while it leads. When the leadership is
+// lost the tasks are stopped and the elector campaigns again.
+type Elector struct {
+	config    Config
+	clientset kubernetes.Interface
+	tasks     []namedTask
+
+	mu     sync.Mutex
+	leader bool
+	// last record observed in the lock and when, the lease of other candidates is timed by the local clock
+	observedRecord string
+	observedTime   time.Time
+	// time of the last successful renewal of the leadership
+	renewTime time.Time
+
+	now func() time.Time
+}
+
+// NewElector returns an elector campaigning with the given configuration
+func NewElector(config Config, clientset kubernetes.Interface) (*Elector, error) {
+	if config.Namespace == "" || config.Name == "" || config.Identity == "" {
+		return nil, fmt.Errorf("the leader election lock and identity must be set")
+	}
+	if config.RetryPeriod <= 0 || config.RenewDeadline <= config.RetryPeriod || config.LeaseDuration <= config.RenewDeadline {
+		return nil, fmt.Errorf("invalid leader election timings, the lease duration must be greater than the renew " +
+			"deadline, itself greater than the retry period")
+	}
+	return &Elector{config: config, clientset: clientset, now: time.Now}, nil
+}
+
+// AddTask adds a cluster-scope task, all the tasks must be added before the elector runs
+func (e *Elector) AddTask(name string, task Task) {
+	e.tasks = append(e.tasks, namedTask{name: name, run: task})
+}
+
+// IsLeader returns whether the instance currently leads
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Run campaigns for the leadership until stopCh is closed, and releases it on the way out. Nothing is done when no
+// task was added, so the lock is only taken once a cluster-scope feature is enabled.
+func (e *Elector) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if len(e.tasks) == 0 {
+		glog.V(1).Info("No cluster-scope task enabled, not campaigning for the leadership")
+		return
+	}
+	glog.Infof("Campaigning for the leadership of the cluster-scope tasks as %s", e.config.Identity)
+	for {
+		if !e.acquire(stopCh) {
+			return
+		}
+		e.lead(stopCh)
+		select {
+		case <-stopCh:
+			e.release()
+			return
+		default:
+		}
+	}
+}
+
+// acquire retries to acquire the leadership until it succeeds, returns false when stopCh is closed first
+func (e *Elector) acquire(stopCh <-chan struct{}) bool {
+	ticker := time.NewTicker(e.config.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		if e.tryAcquireOrRenew() {
+			return true
+		}
+		select {
+		case <-stopCh:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead runs the tasks and renews the leadership until it is lost or stopCh is closed, then waits for the tasks to
+// return
+func (e *Elector) lead(stopCh <-chan struct{}) {
+	e.setLeader(true)
+	glog.Infof("Acquired the leadership, starting %d cluster-scope tasks", len(e.tasks))
+	termCh := make(chan struct{})
+	var tasks sync.WaitGroup
+	for _, task := range e.tasks {
+		tasks.Add(1)
+		go func(task namedTask) {
+			defer tasks.Done()
+			glog.V(1).Infof("Starting cluster-scope task %s", task.name)
+			task.run(termCh)
+		}(task)
+	}
+
+	ticker := time.NewTicker(e.config.RetryPeriod)
+	defer ticker.Stop()
+renew:
+	for {
+		select {
+		case <-stopCh:
+			break renew
+		case <-ticker.C:
+		}
+		if e.tryAcquireOrRenew() {
+			continue
+		}
+		e.mu.Lock()
+		expired := e.now().Sub(e.renewTime) > e.config.RenewDeadline
+		e.mu.Unlock()
+		if expired {
+			glog.Errorf("Failed to renew the leadership within %s, stopping the cluster-scope tasks",
+				e.config.RenewDeadline)
+			break renew
+		}
+	}
+	close(termCh)
+	tasks.Wait()
+	e.setLeader(false)
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	e.leader = leader
+	e.mu.Unlock()
+	if leader {
+		metrics.ControllerLeader.Set(1)
+	} else {
+		metrics.ControllerLeader.Set(0)
+	}
+}
+
+// tryAcquireOrRenew takes the lock when it is free, expired or already held, and returns whether it is held
+func (e *Elector) tryAcquireOrRenew() bool {
+	now := e.now()
+	record := Record{
+		HolderIdentity:       e.config.Identity,
+		LeaseDurationSeconds: int(e.config.LeaseDuration / time.Second),
+		AcquireTime:          metav1.NewTime(now),
+		RenewTime:            metav1.NewTime(now),
+	}
+
+	cms := e.clientset.CoreV1().ConfigMaps(e.config.Namespace)
+	cm, err := cms.Get(context.Background(), e.config.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1core.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: e.config.Namespace, Name: e.config.Name}}
+		if err := setRecord(cm, record); err != nil {
+			glog.Errorf("Failed to encode the leader election record: %s", err)
+			return false
+		}
+		if _, err := cms.Create(context.Background(), cm, metav1.CreateOptions{}); err != nil {
+			glog.Errorf("Failed to create the leader election lock %s/%s: %s", e.config.Namespace, e.config.Name, err)
+			return false
+		}
+		e.renewed(cm.Annotations[LeaderAnnotation], now)
+		return true
+	}
+	if err != nil {
+		glog.Errorf("Failed to read the leader election lock %s/%s: %s", e.config.Namespace, e.config.Name, err)
+		return false
+	}
+
+	raw := cm.Annotations[LeaderAnnotation]
+	var observed Record
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &observed); err != nil {
+			glog.Errorf("Failed to decode the leader election record, overwriting it: %s", err)
+			observed = Record{}
+		}
+	}
+	e.mu.Lock()
+	if raw != e.observedRecord {
+		e.observedRecord = raw
+		e.observedTime = now
+	}
+	lease := time.Duration(observed.LeaseDurationSeconds) * time.Second
+	held := observed.HolderIdentity != "" && observed.HolderIdentity != e.config.Identity &&
+		e.observedTime.Add(lease).After(now)
+	e.mu.Unlock()
+	if held {
+		return false
+	}
+
+	if observed.HolderIdentity == e.config.Identity {
+		record.AcquireTime = observed.AcquireTime
+		record.LeaderTransitions = observed.LeaderTransitions
+	} else {
+		record.LeaderTransitions = observed.LeaderTransitions + 1
+	}
+	cm = cm.DeepCopy()
+	if err := setRecord(cm, record); err != nil {
+		glog.Errorf("Failed to encode the leader election record: %s", err)
+		return false
+	}
+	// the update fails on a conflict when another candidate updated the lock since it was read
+	if _, err := cms.Update(context.Background(), cm, metav1.UpdateOptions{}); err != nil {
+		glog.Errorf("Failed to update the leader election lock %s/%s: %s", e.config.Namespace, e.config.Name, err)
+		return false
+	}
+	e.renewed(cm.Annotations[LeaderAnnotation], now)
+	return true
+}
+
+// renewed records the record written when the leadership was acquired or renewed
+func (e *Elector) renewed(raw string, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.observedRecord = raw
+	e.observedTime = now
+	e.renewTime = now
+}
+
+// release gives up the leadership so another candidate takes over without waiting for the lease to expire
+func (e *Elector) release() {
+	cms := e.clientset.CoreV1().ConfigMaps(e.config.Namespace)
+	cm, err := cms.Get(context.Background(), e.config.Name, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("Failed to read the leader election lock to release it: %s", err)
+		return
+	}
+	var observed Record
+	if err := json.Unmarshal([]byte(cm.Annotations[LeaderAnnotation]), &observed); err != nil ||
+		observed.HolderIdentity != e.config.Identity {
+		return
+	}
+	now := metav1.NewTime(e.now())
+	cm = cm.DeepCopy()
+	err = setRecord(cm, Record{
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaderTransitions:    observed.LeaderTransitions,
+	})
+	if err == nil {
+		_, err = cms.Update(context.Background(), cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		glog.Errorf("Failed to release the leadership: %s", err)
+		return
+	}
+	glog.Info("Released the leadership of the cluster-scope tasks")
+}
+
+func setRecord(cm *v1core.ConfigMap, record Record) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = make(map[string]string)
+	}
+	cm.Annotations[LeaderAnnotation] = string(raw)
+	return nil
+}
@@ -0,0 +1,162 @@
+package leaderelection
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testConfig(identity string) Config {
+	return Config{
+		Namespace:     "kube-system",
+		Name:          "kube-router-leader",
+		Identity:      identity,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+}
+
+func readRecord(t *testing.T, clientset kubernetes.Interface) Record {
+	cm, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "kube-router-leader", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to read the lock: %s", err)
+	}
+	var record Record
+	if err := json.Unmarshal([]byte(cm.Annotations[LeaderAnnotation]), &record); err != nil {
+		t.Fatalf("failed to decode the record: %s", err)
+	}
+	return record
+}
+
+func TestTryAcquireOrRenew(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	a, err := NewElector(testConfig("a"), clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewElector(testConfig("b"), clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now, b.now = now, now
+
+	if !a.tryAcquireOrRenew() {
+		t.Fatal("expected a to acquire the free lock")
+	}
+	if b.tryAcquireOrRenew() {
+		t.Fatal("expected b not to acquire the lock held by a")
+	}
+
+	// a renews, b times the lease from when it observes the renewal
+	clock = clock.Add(10 * time.Second)
+	if !a.tryAcquireOrRenew() {
+		t.Fatal("expected a to renew")
+	}
+	clock = clock.Add(5 * time.Second)
+	if b.tryAcquireOrRenew() {
+		t.Fatal("expected b not to acquire the lock renewed by a")
+	}
+	clock = clock.Add(14 * time.Second)
+	if b.tryAcquireOrRenew() {
+		t.Fatal("expected b not to acquire the lock before the lease observed by b expired")
+	}
+
+	// a stopped renewing
+	clock = clock.Add(2 * time.Second)
+	if !b.tryAcquireOrRenew() {
+		t.Fatal("expected b to acquire the expired lock")
+	}
+	record := readRecord(t, clientset)
+	if record.HolderIdentity != "b" || record.LeaderTransitions != 1 {
+		t.Errorf("expected b to hold the lock after 1 transition, got %+v", record)
+	}
+	if a.tryAcquireOrRenew() {
+		t.Error("expected a not to take the lock back from b")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	a, _ := NewElector(testConfig("a"), clientset)
+	b, _ := NewElector(testConfig("b"), clientset)
+	if !a.tryAcquireOrRenew() {
+		t.Fatal("expected a to acquire the free lock")
+	}
+	if b.tryAcquireOrRenew() {
+		t.Fatal("expected b not to acquire the lock held by a")
+	}
+	// releasing a lock held by another candidate does nothing
+	b.release()
+	if record := readRecord(t, clientset); record.HolderIdentity != "a" {
+		t.Fatalf("expected a to still hold the lock, got %+v", record)
+	}
+	a.release()
+	if !b.tryAcquireOrRenew() {
+		t.Fatal("expected b to acquire the lock released by a without waiting for the lease")
+	}
+}
+
+func TestRunTasks(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	config := testConfig("a")
+	config.LeaseDuration, config.RenewDeadline, config.RetryPeriod = 3*time.Second, 2*time.Second, 10*time.Millisecond
+	e, err := NewElector(config, clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	e.AddTask("test", func(stopCh <-chan struct{}) {
+		close(started)
+		<-stopCh
+		close(stopped)
+	})
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go e.Run(stopCh, &wg)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the task to start once the leadership is acquired")
+	}
+	if !e.IsLeader() {
+		t.Error("expected the elector to lead while the task runs")
+	}
+
+	close(stopCh)
+	wg.Wait()
+	select {
+	case <-stopped:
+	default:
+		t.Error("expected the task to be stopped")
+	}
+	if e.IsLeader() {
+		t.Error("expected the elector not to lead once stopped")
+	}
+	if record := readRecord(t, clientset); record.HolderIdentity != "" {
+		t.Errorf("expected the lock to be released, got %+v", record)
+	}
+}
+
+func TestNewElectorValidation(t *testing.T) {
+	config := testConfig("")
+	if _, err := NewElector(config, nil); err == nil {
+		t.Error("expected an empty identity to be rejected")
+	}
+	config = testConfig("a")
+	config.RenewDeadline = config.LeaseDuration
+	if _, err := NewElector(config, nil); err == nil {
+		t.Error("expected a renew deadline not less than the lease duration to be rejected")
+	}
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+const (
+	conntrackStatPath = "/proc/net/stat/nf_conntrack"
+)
+
+// conntrackInsertFailedReader reads the insert_failed counter of the conntrack statistics of the node and keeps the
+// last value read, so a failed read does not look like a counter reset
+type conntrackInsertFailedReader struct {
+	path string
+	mu   sync.Mutex
+	last float64
+}
+
+var conntrackInsertFailures = &conntrackInsertFailedReader{path: conntrackStatPath}
+
+// conntrackInsertFailed returns the insert_failed counter of the conntrack statistics of the node, the last value
+// read if they can not be read
+func conntrackInsertFailed() float64 {
+	return conntrackInsertFailures.read()
+}
+
+func (r *conntrackInsertFailedReader) read() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.Open(r.path)
+	if err != nil {
+		glog.V(2).Infof("Failed to read conntrack statistics: %s", err.Error())
+		return r.last
+	}
+	defer f.Close()
+	failures, err := parseConntrackInsertFailed(f)
+	if err != nil {
+		glog.V(2).Infof("Failed to read conntrack statistics: %s", err.Error())
+		return r.last
+	}
+	r.last = float64(failures)
+	return r.last
+}
+
+// parseConntrackInsertFailed returns the sum over all CPUs of the insert_failed counter of the conntrack
+// statistics. Connections that could not be assigned a free SNAT source port are accounted there.
+func parseConntrackInsertFailed(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return 0, errors.New("conntrack statistics are empty")
+	}
+	column := -1
+	for i, name := range strings.Fields(scanner.Text()) {
+		if name == "insert_failed" {
+			column = i
+			break
+		}
+	}
+	if column < 0 {
+		return 0, errors.New("conntrack statistics do not contain an insert_failed column")
+	}
+	var total uint64
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) <= column {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[column], 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse conntrack statistics: %s", err.Error())
+		}
+		total += value
+	}
+	return total, scanner.Err()
+}
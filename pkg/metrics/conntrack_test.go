@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_parseConntrackInsertFailed(t *testing.T) {
+	stats := `entries  searched found new invalid ignore delete delete_list insert insert_failed drop early_drop icmp_error  expect_new expect_create expect_delete search_restart
+00000069  00000000 00000000 00000000 00000012 000000c0 00000000 00000000 00000000 00000002 00000000 00000000 00000000  00000000 00000000 00000000 00000000
+00000069  00000000 00000000 00000000 00000003 00000002 00000000 00000000 00000000 0000000a 00000000 00000000 00000000  00000000 00000000 00000000 00000001
+`
+	failures, err := parseConntrackInsertFailed(strings.NewReader(stats))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if failures != 12 {
+		t.Errorf("expected 12 insert failures but got %d", failures)
+	}
+
+	_, err = parseConntrackInsertFailed(strings.NewReader("entries searched found\n00000001 00000000 00000000\n"))
+	if err == nil {
+		t.Errorf("expected error when insert_failed column is missing")
+	}
+}
+
+func Test_conntrackInsertFailedReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conntrack")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	reader := &conntrackInsertFailedReader{path: filepath.Join(dir, "nf_conntrack")}
+
+	stats := "entries insert_failed\n00000001 00000003\n"
+	if err := ioutil.WriteFile(reader.path, []byte(stats), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if failures := reader.read(); failures != 3 {
+		t.Errorf("expected 3 insert failures but got %v", failures)
+	}
+
+	// a failed read keeps the last value rather than resetting the counter
+	if err := os.Remove(reader.path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if failures := reader.read(); failures != 3 {
+		t.Errorf("expected the last 3 insert failures after a failed read but got %v", failures)
+	}
+}
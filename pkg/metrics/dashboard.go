@@ -0,0 +1,366 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DashboardPath is the path the Grafana dashboard of the metrics is served on, next to the metrics
+const DashboardPath = "/dashboard"
+
+// The Grafana dashboard is generated from the definitions of the metrics below, so its panels query the names and
+// labels of the metrics of the running version. Each metric gets a panel in the row of its subsystem: the gauges are
+// plotted as they are, the rates of the counters are summed by their labels and the histograms are plotted as their
+// 50th and 99th percentiles. The panels are filtered by the instance variable of the dashboard.
+
+// dashboardCollectors are the metrics of kube-router, in the order of their panels
+var dashboardCollectors = []prometheus.Collector{
+	ServiceTotalConn,
+	ServicePacketsIn,
+	ServicePacketsOut,
+	ServiceBytesIn,
+	ServiceBytesOut,
+	ServicePpsIn,
+	ServicePpsOut,
+	ServiceCPS,
+	ServiceBpsIn,
+	ServiceBpsOut,
+	ControllerIpvsServices,
+	ControllerIptablesSyncTime,
+	ControllerIpvsServicesSyncTime,
+	ControllerRoutesSyncTime,
+	ControllerBPGpeers,
+	ControllerBGPInternalPeersSyncTime,
+	ControllerBGPadvertisementsReceived,
+	ControllerBGPadvertisementsSent,
+	ControllerBGPSpeakerRestarts,
+	ControllerConntrackInsertFailed,
+	ControllerStartupDrift,
+	ControllerStartupOutOfSyncSeconds,
+	ControllerIpvsMetricsExportTime,
+	ControllerPolicyChainsSyncTime,
+	ControllerPolicyAcceptedPackets,
+	ControllerPolicyAcceptedBytes,
+	ControllerPolicyRejectedPackets,
+	ControllerPolicyAuditedPackets,
+	ControllerPolicyDropEvents,
+	ControllerPolicySyncTime,
+	ControllerPolicyRules,
+	ControllerPolicyLimitExceeded,
+	ControllerPolicyIncrementalSyncs,
+	ControllerPolicyDesiredState,
+	ControllerPolicyJumpPositionDrift,
+	ControllerPolicyShrinksHeld,
+	ControllerPolicyConntrackFlushed,
+	ControllerPolicyCriticalFlows,
+	ControllerPolicyExternalFlushes,
+	ControllerExecTime,
+	ControllerExecFailures,
+	ControllerCacheAuditObjects,
+	ControllerCacheAuditDifferences,
+	ControllerErrors,
+	ControllerInformerWatchErrors,
+	ControllerInformerWatchHealthy,
+	ControllerInformerObjects,
+	ControllerInformerCacheBytes,
+	ControllerAPIStaleness,
+	ControllerEmptyCacheGuards,
+	ControllerSyncStretchFactor,
+	ControllerLoadGovernorOverloaded,
+	ControllerSyncQueueLength,
+	ControllerSyncWaitTime,
+	ControllerSyncContention,
+	ControllerLeader,
+	ControllerIPSetEntries,
+	ControllerIPSetRefreshTime,
+	ControllerEventsAggregated,
+	BuildInfo,
+	Capabilities,
+}
+
+// dashboardCounterDescs are the counters of kube-router exported by the collectors of the controllers
+var dashboardCounterDescs = []*prometheus.Desc{
+	PolicyPacketsTotal,
+	PolicyBytesTotal,
+	PodRejectedPacketsTotal,
+}
+
+// dashboardRows are the rows of the dashboard, by the prefix of the names of their metrics. The metrics matching no
+// prefix go into the last row.
+var dashboardRows = []struct {
+	title    string
+	prefixes []string
+}{
+	{"Services", []string{"service_", "controller_ipvs_"}},
+	{"Network policies", []string{"controller_policy_", "policy_", "pod_", "controller_iptables_"}},
+	{"Routing", []string{"controller_bgp_", "controller_routes_", "controller_conntrack_"}},
+	{"Controllers", nil},
+}
+
+const (
+	metricGauge     = "gauge"
+	metricCounter   = "counter"
+	metricHistogram = "histogram"
+)
+
+// metricInfo is the name, help, labels and type of a metric
+type metricInfo struct {
+	name   string
+	help   string
+	labels []string
+	kind   string
+}
+
+// descPattern matches the string of a prometheus.Desc, which only exposes the name and the labels of the metric
+// through it
+var descPattern = regexp.MustCompile(`^Desc\{fqName: ("(?:[^"\\]|\\.)*"), help: ("(?:[^"\\]|\\.)*"), ` +
+	`constLabels: \{.*\}, variableLabels: \[(.*)\]\}$`)
+
+// parseDesc returns the metric of the description
+func parseDesc(desc *prometheus.Desc, kind string) (metricInfo, error) {
+	match := descPattern.FindStringSubmatch(desc.String())
+	if match == nil {
+		return metricInfo{}, fmt.Errorf("failed to parse the description of metric: %s", desc)
+	}
+	name, err := strconv.Unquote(match[1])
+	if err != nil {
+		return metricInfo{}, fmt.Errorf("failed to parse the name of metric %s: %s", match[1], err)
+	}
+	help, err := strconv.Unquote(match[2])
+	if err != nil {
+		return metricInfo{}, fmt.Errorf("failed to parse the help of metric %s: %s", name, err)
+	}
+	return metricInfo{name: name, help: help, labels: strings.Fields(match[3]), kind: kind}, nil
+}
+
+// collectorKind returns the type of the metrics of the collector
+func collectorKind(collector prometheus.Collector) (string, error) {
+	switch c := collector.(type) {
+	case *prometheus.GaugeVec:
+		return metricGauge, nil
+	case *prometheus.CounterVec:
+		return metricCounter, nil
+	case *prometheus.HistogramVec:
+		return metricHistogram, nil
+	case prometheus.Metric:
+		// counters have the methods of gauges, so the metrics are told apart by what they write
+		var m dto.Metric
+		if err := c.Write(&m); err != nil {
+			return "", err
+		}
+		switch {
+		case m.Gauge != nil:
+			return metricGauge, nil
+		case m.Counter != nil:
+			return metricCounter, nil
+		case m.Histogram != nil:
+			return metricHistogram, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported collector %T", collector)
+}
+
+// dashboardMetrics returns the metrics of the dashboard, in the order of their panels
+func dashboardMetrics() ([]metricInfo, error) {
+	metrics := make([]metricInfo, 0, len(dashboardCollectors)+len(dashboardCounterDescs))
+	for _, collector := range dashboardCollectors {
+		kind, err := collectorKind(collector)
+		if err != nil {
+			return nil, err
+		}
+		descs := make(chan *prometheus.Desc, 1)
+		go func() {
+			collector.Describe(descs)
+			close(descs)
+		}()
+		for desc := range descs {
+			metric, err := parseDesc(desc, kind)
+			if err != nil {
+				for range descs {
+				}
+				return nil, err
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	for _, desc := range dashboardCounterDescs {
+		metric, err := parseDesc(desc, metricCounter)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// dashboardRow returns the index in dashboardRows of the row of the metric
+func dashboardRow(name string) int {
+	name = strings.TrimPrefix(name, namespace+"_")
+	for i, row := range dashboardRows {
+		for _, prefix := range row.prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return i
+			}
+		}
+	}
+	return len(dashboardRows) - 1
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type panelTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+type panel struct {
+	ID          int           `json:"id"`
+	Type        string        `json:"type"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Datasource  string        `json:"datasource,omitempty"`
+	GridPos     gridPos       `json:"gridPos"`
+	Targets     []panelTarget `json:"targets,omitempty"`
+	Collapsed   *bool         `json:"collapsed,omitempty"`
+	Panels      []panel       `json:"panels,omitempty"`
+}
+
+// panelTargets returns the queries of the panel of the metric
+func panelTargets(metric metricInfo) []panelTarget {
+	selector := `{instance=~"$instance"}`
+	by := strings.Join(append([]string{"instance"}, metric.labels...), ", ")
+	legend := make([]string, 0, len(metric.labels)+1)
+	for _, label := range append([]string{"instance"}, metric.labels...) {
+		legend = append(legend, "{{"+label+"}}")
+	}
+	legendFormat := strings.Join(legend, " ")
+
+	switch metric.kind {
+	case metricCounter:
+		return []panelTarget{{
+			Expr:         fmt.Sprintf("sum by (%s) (rate(%s%s[$__rate_interval]))", by, metric.name, selector),
+			LegendFormat: legendFormat,
+			RefID:        "A",
+		}}
+	case metricHistogram:
+		targets := make([]panelTarget, 0, 2)
+		for i, quantile := range []string{"0.5", "0.99"} {
+			targets = append(targets, panelTarget{
+				Expr: fmt.Sprintf("histogram_quantile(%s, sum by (le, %s) (rate(%s_bucket%s[$__rate_interval])))",
+					quantile, by, metric.name, selector),
+				LegendFormat: "p" + strings.TrimPrefix(quantile, "0.") + " " + legendFormat,
+				RefID:        string(rune('A' + i)),
+			})
+		}
+		return targets
+	}
+	return []panelTarget{{Expr: metric.name + selector, LegendFormat: legendFormat, RefID: "A"}}
+}
+
+// Dashboard returns the JSON model of the Grafana dashboard of the metrics of kube-router
+func Dashboard() ([]byte, error) {
+	metrics, err := dashboardMetrics()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]metricInfo, len(dashboardRows))
+	for _, metric := range metrics {
+		row := dashboardRow(metric.name)
+		rows[row] = append(rows[row], metric)
+	}
+
+	panels := make([]panel, 0, len(metrics)+len(rows))
+	id, y := 1, 0
+	collapsed := false
+	for i, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		panels = append(panels, panel{ID: id, Type: "row", Title: dashboardRows[i].title, Collapsed: &collapsed,
+			GridPos: gridPos{H: 1, W: 24, Y: y}, Panels: []panel{}})
+		id++
+		y++
+		for j, metric := range row {
+			panels = append(panels, panel{
+				ID:          id,
+				Type:        "timeseries",
+				Title:       strings.TrimPrefix(metric.name, namespace+"_"),
+				Description: metric.help,
+				Datasource:  "$datasource",
+				GridPos:     gridPos{H: 8, W: 12, X: 12 * (j % 2), Y: y + 8*(j/2)},
+				Targets:     panelTargets(metric),
+			})
+			id++
+		}
+		y += 8 * ((len(row) + 1) / 2)
+	}
+
+	dashboard := map[string]interface{}{
+		"title":         "kube-router",
+		"uid":           "kube-router",
+		"tags":          []string{"kube-router"},
+		"editable":      true,
+		"schemaVersion": 27,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-1h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				{
+					"name":       "instance",
+					"label":      "Instance",
+					"type":       "query",
+					"datasource": "$datasource",
+					// the leadership gauge is exported by every instance running the metrics controller
+					"query":      fmt.Sprintf("label_values(%s, instance)", metricName(ControllerLeader)),
+					"refresh":    2,
+					"multi":      true,
+					"includeAll": true,
+					"allValue":   ".*",
+					"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+				},
+			},
+		},
+		"panels": panels,
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+// metricName returns the name of the metric of the collector
+func metricName(collector prometheus.Collector) string {
+	descs := make(chan *prometheus.Desc, 1)
+	collector.Describe(descs)
+	metric, _ := parseDesc(<-descs, "")
+	return metric.name
+}
+
+// serveDashboard serves the JSON model of the Grafana dashboard of the metrics
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	dashboard, err := Dashboard()
+	if err != nil {
+		glog.Errorf("Failed to generate the Grafana dashboard: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(dashboard)
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func Test_DashboardMetrics(t *testing.T) {
+	// every metric declared by the package gets a panel
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "metrics_controller.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse metrics_controller.go: %s", err)
+	}
+	declared := 0
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.ValueSpec)
+		if !ok || len(spec.Values) != 1 {
+			return true
+		}
+		if call, ok := spec.Values[0].(*ast.CallExpr); ok {
+			if fun, ok := call.Fun.(*ast.SelectorExpr); ok && strings.HasPrefix(fun.Sel.Name, "New") {
+				declared++
+			}
+		}
+		return true
+	})
+
+	metrics, err := dashboardMetrics()
+	if err != nil {
+		t.Fatalf("failed to get the metrics of the dashboard: %s", err)
+	}
+	if len(metrics) != declared {
+		t.Errorf("expected the %d metrics declared in the dashboard but got %d", declared, len(metrics))
+	}
+	names := make(map[string]metricInfo)
+	for _, metric := range metrics {
+		if _, ok := names[metric.name]; ok {
+			t.Errorf("expected metric %s once in the dashboard", metric.name)
+		}
+		names[metric.name] = metric
+	}
+
+	testcases := []struct {
+		name   string
+		kind   string
+		labels []string
+	}{
+		{"kube_router_service_cps", metricGauge, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port"}},
+		{"kube_router_controller_leader", metricGauge, nil},
+		{"kube_router_controller_bgp_advertisements_received", metricCounter, nil},
+		{"kube_router_controller_iptables_sync_time", metricHistogram, nil},
+		{"kube_router_policy_packets_total", metricCounter, []string{"namespace", "policy", "rule"}},
+	}
+	for _, testcase := range testcases {
+		metric, ok := names[testcase.name]
+		if !ok {
+			t.Errorf("expected metric %s in the dashboard", testcase.name)
+			continue
+		}
+		if metric.kind != testcase.kind || strings.Join(metric.labels, ",") != strings.Join(testcase.labels, ",") {
+			t.Errorf("expected metric %s to be a %s labeled by %v but got a %s labeled by %v", testcase.name,
+				testcase.kind, testcase.labels, metric.kind, metric.labels)
+		}
+	}
+}
+
+func Test_Dashboard(t *testing.T) {
+	data, err := Dashboard()
+	if err != nil {
+		t.Fatalf("failed to generate the dashboard: %s", err)
+	}
+	var dashboard struct {
+		Panels []panel `json:"panels"`
+	}
+	if err := json.Unmarshal(data, &dashboard); err != nil {
+		t.Fatalf("failed to unmarshal the dashboard: %s", err)
+	}
+
+	exprs := make(map[string][]string)
+	ids := make(map[int]bool)
+	rows := 0
+	for _, panel := range dashboard.Panels {
+		if ids[panel.ID] {
+			t.Errorf("expected panel id %d once", panel.ID)
+		}
+		ids[panel.ID] = true
+		if panel.Type == "row" {
+			rows++
+			continue
+		}
+		for _, target := range panel.Targets {
+			exprs[panel.Title] = append(exprs[panel.Title], target.Expr)
+		}
+	}
+	if rows != len(dashboardRows) {
+		t.Errorf("expected %d rows but got %d", len(dashboardRows), rows)
+	}
+
+	expected := map[string][]string{
+		"controller_leader": {`kube_router_controller_leader{instance=~"$instance"}`},
+		"controller_errors": {`sum by (instance, controller, error_type) ` +
+			`(rate(kube_router_controller_errors{instance=~"$instance"}[$__rate_interval]))`},
+		"controller_routes_sync_time": {
+			`histogram_quantile(0.5, sum by (le, instance) ` +
+				`(rate(kube_router_controller_routes_sync_time_bucket{instance=~"$instance"}[$__rate_interval])))`,
+			`histogram_quantile(0.99, sum by (le, instance) ` +
+				`(rate(kube_router_controller_routes_sync_time_bucket{instance=~"$instance"}[$__rate_interval])))`,
+		},
+	}
+	for title, expectedExprs := range expected {
+		if strings.Join(exprs[title], "\n") != strings.Join(expectedExprs, "\n") {
+			t.Errorf("expected the queries of panel %s to be %v but got %v", title, expectedExprs, exprs[title])
+		}
+	}
+}
@@ -129,6 +129,31 @@ var (
 		Name:      "controller_bgp_advertisements_sent",
 		Help:      "BGP advertisements sent",
 	})
+	// ControllerBGPSpeakerRestarts Number of restarts of the BGP speaker process
+	ControllerBGPSpeakerRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_speaker_restarts",
+		Help:      "Number of restarts of the BGP speaker process",
+	})
+	// ControllerConntrackInsertFailed Number of connections conntrack failed to insert, read from the kernel on
+	// each collection
+	ControllerConntrackInsertFailed = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_conntrack_insert_failed",
+		Help:      "Number of connections conntrack failed to insert on the node (insert_failed of its statistics), e.g. due to SNAT source port exhaustion",
+	}, conntrackInsertFailed)
+	// ControllerStartupDrift Number of objects on the node that differed at startup from the state last applied
+	ControllerStartupDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_startup_drift",
+		Help:      "Number of objects on the node that differed at startup from the state last applied by the previous instance",
+	}, []string{"controller"})
+	// ControllerStartupOutOfSyncSeconds Time the node may have been out of sync when drift was found at startup
+	ControllerStartupOutOfSyncSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_startup_out_of_sync_seconds",
+		Help:      "Time since the previous instance last applied the state, when drift was found at startup",
+	}, []string{"controller"})
 	// ControllerIpvsMetricsExportTime Time it took to export metrics
 	ControllerIpvsMetricsExportTime = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: namespace,
@@ -141,6 +166,241 @@ var (
 		Name:      "controller_policy_chains_sync_time",
 		Help:      "Time it took for controller to sync policy chains",
 	})
+	// ControllerPolicyAcceptedPackets Packets accepted by network policies
+	ControllerPolicyAcceptedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_accepted_packets",
+		Help:      "Packets accepted by network policies, labeled by namespace and policy when tenant labels are enabled",
+	}, []string{"namespace", "policy"})
+	// ControllerPolicyAcceptedBytes Bytes accepted by network policies
+	ControllerPolicyAcceptedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_accepted_bytes",
+		Help:      "Bytes accepted by network policies, labeled by namespace and policy when tenant labels are enabled",
+	}, []string{"namespace", "policy"})
+	// ControllerPolicyRejectedPackets Packets rejected by the default rule of the pod firewalls
+	ControllerPolicyRejectedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_rejected_packets",
+		Help:      "Packets to or from pods not accepted by any network policy, labeled by namespace when tenant labels are enabled",
+	}, []string{"namespace"})
+	// ControllerPolicyAuditedPackets Packets the pod firewalls accepted in audit mode
+	ControllerPolicyAuditedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_audited_packets",
+		Help:      "Packets to or from pods not accepted by any network policy, accepted as the policies are in audit mode, labeled by namespace when tenant labels are enabled",
+	}, []string{"namespace"})
+	// PolicyPacketsTotal Packets accepted by the rules of the network policies
+	PolicyPacketsTotal = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "policy_packets_total"),
+		"Packets accepted by the rules of the network policies, labeled by namespace, policy and rule",
+		[]string{"namespace", "policy", "rule"}, nil)
+	// PolicyBytesTotal Bytes accepted by the rules of the network policies
+	PolicyBytesTotal = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "policy_bytes_total"),
+		"Bytes accepted by the rules of the network policies, labeled by namespace, policy and rule",
+		[]string{"namespace", "policy", "rule"}, nil)
+	// PodRejectedPacketsTotal Packets rejected or dropped by the default rule of the pod firewall chains
+	PodRejectedPacketsTotal = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "pod_rejected_packets_total"),
+		"Packets rejected or dropped by the default rule of the firewall chains of the pods, as no network policy "+
+			"accepted them, labeled by namespace and pod",
+		[]string{"namespace", "pod"}, nil)
+	// ControllerPolicyDropEvents Packets of the drop log exported as drop events
+	ControllerPolicyDropEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_drop_events",
+		Help:      "Packets dropped by network policies read from the drop log, which is rate limited per pod, labeled by direction and the namespaces of the source and destination",
+	}, []string{"direction", "source_namespace", "destination_namespace"})
+	// ControllerPolicySyncTime Time it took to program the chain and ipsets of each network policy
+	ControllerPolicySyncTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_sync_time",
+		Help:      "Time it took to program the chain and ipsets of a network policy, labeled by namespace and policy when tenant labels are enabled",
+	}, []string{"namespace", "policy"})
+	// ControllerPolicyRules Number of rules of the network policy chains
+	ControllerPolicyRules = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_rules",
+		Help:      "Number of iptables rules of the network policy chains, labeled by namespace when tenant labels are enabled",
+	}, []string{"namespace"})
+	// ControllerPolicyLimitExceeded Network policies not programmed as they exceed a limit
+	ControllerPolicyLimitExceeded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_limit_exceeded",
+		Help:      "Network policies whose rules are not programmed as they exceed the maximum number of rules or ipset entries, labeled by limit, and by namespace and policy when tenant labels are enabled",
+	}, []string{"namespace", "policy", "limit"})
+	// ControllerPolicyIncrementalSyncs Number of pod events the network policy controller only refreshed ipsets for
+	ControllerPolicyIncrementalSyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_incremental_syncs",
+		Help:      "Number of pod events handled by refreshing the ipsets of the network policies, labeled by result (applied, or full_sync when the rules changed and a full sync ran instead)",
+	}, []string{"result"})
+	// ControllerPolicyDesiredState Size of the state the last network policy sync programmed
+	ControllerPolicyDesiredState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_desired_state",
+		Help:      "Size of the state the last network policy sync programmed, or would have programmed in observe-only mode, labeled by kind (ipsets, ipset_entries or pod_firewall_jumps)",
+	}, []string{"kind"})
+	// ControllerPolicyJumpPositionDrift Number of times the jumps to the pod firewall chains were found moved
+	ControllerPolicyJumpPositionDrift = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_jump_position_drift",
+		Help:      "Number of times the rules jumping to the dispatch chains were found away from the position set by --netpol-jump-position, labeled by chain",
+	}, []string{"chain"})
+	// ControllerPolicyShrinksHeld Number of times the removal of the stale chains and ipsets was held
+	ControllerPolicyShrinksHeld = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_shrinks_held",
+		Help:      "Number of times the removal of the stale network policy chains and ipsets was held as the desired state shrank beyond --policy-shrink-threshold",
+	})
+	// ControllerPolicyConntrackFlushed Number of connections deleted as a sync revoked their addresses
+	ControllerPolicyConntrackFlushed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_conntrack_flushed",
+		Help:      "Number of connections tracked by conntrack deleted with --policy-flush-revoked-conntrack as a sync removed their addresses from the ipsets of the network policies",
+	})
+	// ControllerPolicyCriticalFlows Number of critical flows accepted ahead of the network policies
+	ControllerPolicyCriticalFlows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_critical_flows",
+		Help:      "Number of critical flows of --critical-flows and of the CriticalFlows accepted ahead of the network policies, labeled by direction",
+	}, []string{"direction"})
+	// ControllerPolicyExternalFlushes Number of times the rules of the last sync were found changed outside of kube-router
+	ControllerPolicyExternalFlushes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_policy_external_flushes",
+		Help:      "Number of times --policy-flush-check-period found the network policy and pod firewall chains of the last sync flushed or changed outside of kube-router",
+	})
+	// ControllerExecTime Time it took to run the utilities executed by kube-router
+	ControllerExecTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "controller_exec_time",
+		Help:      "Time it took to run the utilities executed by kube-router, labeled by command",
+	}, []string{"command"})
+	// ControllerExecFailures Number of failed executions of utilities
+	ControllerExecFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_exec_failures",
+		Help:      "Number of failed executions of utilities, labeled by command and reason (exit, timeout or start)",
+	}, []string{"command", "reason"})
+	// ControllerCacheAuditObjects Number of informer cache objects compared with the API server
+	ControllerCacheAuditObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_cache_audit_objects",
+		Help:      "Number of informer cache objects compared with the API server, labeled by resource",
+	}, []string{"resource"})
+	// ControllerCacheAuditDifferences Number of informer cache objects found to differ from the API server
+	ControllerCacheAuditDifferences = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_cache_audit_differences",
+		Help:      "Number of informer cache objects found to differ from the API server, labeled by resource and kind",
+	}, []string{"resource", "kind"})
+	// ControllerErrors Number of failed syncs of the controllers by type of error
+	ControllerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_errors",
+		Help:      "Number of failed syncs of the controllers, labeled by controller and error_type (iptables_lock, ipset_missing, api_unavailable, config, dataplane or unknown)",
+	}, []string{"controller", "error_type"})
+	// ControllerInformerWatchErrors Number of failed lists and watches of the informers
+	ControllerInformerWatchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_informer_watch_errors",
+		Help:      "Number of failed lists and watches of the informers, labeled by resource and operation",
+	}, []string{"resource", "operation"})
+	// ControllerInformerWatchHealthy Whether the last list or watch of the informers succeeded
+	ControllerInformerWatchHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_informer_watch_healthy",
+		Help:      "Whether the last list or watch of the informers succeeded, labeled by resource",
+	}, []string{"resource"})
+	// ControllerInformerObjects Number of objects cached by the informers
+	ControllerInformerObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_informer_objects",
+		Help:      "Number of objects cached by the informers, labeled by resource",
+	}, []string{"resource"})
+	// ControllerInformerCacheBytes Estimated memory held by the objects cached by the informers
+	ControllerInformerCacheBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_informer_cache_bytes",
+		Help:      "Size of the protobuf encoding of the objects cached by the informers, an estimate of the memory they hold, labeled by resource",
+	}, []string{"resource"})
+	// ControllerAPIStaleness Time the informer caches have been stale for
+	ControllerAPIStaleness = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_api_staleness_seconds",
+		Help:      "Time since the first failed list or watch of the informers that have not recovered yet, 0 while the API server is reachable. Updated on each retry.",
+	})
+	// ControllerEmptyCacheGuards Number of syncs aborted as the cache of a resource emptied
+	ControllerEmptyCacheGuards = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_empty_cache_guards",
+		Help:      "Number of syncs aborted to keep the state of the node as the cache of a resource emptied while its objects were not confirmed deleted, labeled by resource",
+	}, []string{"resource"})
+	// ControllerSyncStretchFactor Factor the periodic sync periods are stretched by on the overloaded node
+	ControllerSyncStretchFactor = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_sync_stretch_factor",
+		Help:      "Factor the periodic sync periods are stretched by while the node is overloaded, 1 otherwise",
+	})
+	// ControllerLoadGovernorOverloaded Whether the last window of the load governor found the node overloaded
+	ControllerLoadGovernorOverloaded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_load_governor_overloaded",
+		Help:      "Whether the last window of the load governor found the node overloaded, labeled by signal",
+	}, []string{"signal"})
+	// ControllerSyncQueueLength Number of syncs waiting for the sync coordinator
+	ControllerSyncQueueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_sync_queue_length",
+		Help:      "Number of syncs of the controllers waiting for their turn at the sync coordinator, labeled by priority",
+	}, []string{"priority"})
+	// ControllerSyncWaitTime Time a sync waited for the sync of another controller
+	ControllerSyncWaitTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "controller_sync_wait_time",
+		Help:      "Time a sync waited at the sync coordinator for the syncs of the other controllers, labeled by controller and priority",
+	}, []string{"controller", "priority"})
+	// ControllerSyncContention Number of syncs that waited for the sync of another controller
+	ControllerSyncContention = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_sync_contention",
+		Help:      "Number of syncs that found the sync coordinator busy with the sync of another controller, labeled by controller and priority",
+	}, []string{"controller", "priority"})
+	// ControllerLeader Whether the instance leads the cluster-scope tasks
+	ControllerLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_leader",
+		Help:      "Whether the instance holds the leadership and runs the cluster-scope tasks",
+	})
+	// ControllerIPSetEntries Number of entries of the ipsets of kube-router
+	ControllerIPSetEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_ipset_entries",
+		Help:      "Number of entries of the ipsets of kube-router, labeled by set",
+	}, []string{"set"})
+	// ControllerIPSetRefreshTime Time it took to refresh the entries of an ipset
+	ControllerIPSetRefreshTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "controller_ipset_refresh_time",
+		Help:      "Time it took to refresh the entries of an ipset",
+	})
+	// ControllerEventsAggregated Number of event occurrences counted in an event recorded earlier
+	ControllerEventsAggregated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_events_aggregated",
+		Help:      "Number of occurrences of events counted in the event of the same reason recorded earlier instead of recorded anew",
+	})
+	// BuildInfo Version of the running kube-router
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Always 1, labeled by the version and the commit kube-router was built from and the Go version it was built with",
+	}, []string{"version", "commit", "go_version"})
+	// Capabilities Features enabled on the instance and facts of the node they depend on
+	Capabilities = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "capabilities",
+		Help:      "Always 1, labeled by the name and the value of the features enabled on the instance and of the facts of the kernel and the utilities of the node",
+	}, []string{"capability", "value"})
 )
 
 // Controller Holds settings for the metrics controller
@@ -159,11 +419,39 @@ func (mc *Controller) Run(healthChan chan<- *healthcheck.ControllerHeartbeat, st
 
 	// register metrics for this controller
 	prometheus.MustRegister(ControllerIpvsMetricsExportTime)
+	prometheus.MustRegister(ControllerStartupDrift)
+	prometheus.MustRegister(ControllerStartupOutOfSyncSeconds)
+	prometheus.MustRegister(ControllerExecTime)
+	prometheus.MustRegister(ControllerExecFailures)
+	prometheus.MustRegister(ControllerErrors)
+	prometheus.MustRegister(ControllerCacheAuditObjects)
+	prometheus.MustRegister(ControllerCacheAuditDifferences)
+	prometheus.MustRegister(ControllerInformerWatchErrors)
+	prometheus.MustRegister(ControllerInformerWatchHealthy)
+	prometheus.MustRegister(ControllerInformerObjects)
+	prometheus.MustRegister(ControllerInformerCacheBytes)
+	prometheus.MustRegister(ControllerAPIStaleness)
+	prometheus.MustRegister(ControllerEmptyCacheGuards)
+	prometheus.MustRegister(ControllerSyncStretchFactor)
+	prometheus.MustRegister(ControllerLoadGovernorOverloaded)
+	prometheus.MustRegister(ControllerSyncQueueLength)
+	prometheus.MustRegister(ControllerSyncWaitTime)
+	prometheus.MustRegister(ControllerSyncContention)
+	prometheus.MustRegister(ControllerLeader)
+	prometheus.MustRegister(ControllerEventsAggregated)
+	prometheus.MustRegister(ControllerIPSetEntries)
+	prometheus.MustRegister(ControllerIPSetRefreshTime)
+	prometheus.MustRegister(BuildInfo)
+	prometheus.MustRegister(Capabilities)
 
 	srv := &http.Server{Addr: ":" + strconv.Itoa(int(mc.MetricsPort)), Handler: http.DefaultServeMux}
 
 	// add prometheus handler on metrics path
 	http.Handle(mc.MetricsPath, promhttp.Handler())
+	// add the Grafana dashboard of the metrics
+	if mc.MetricsPath != DashboardPath {
+		http.HandleFunc(DashboardPath, serveDashboard)
+	}
 
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// TenantLabelOther is the value of the namespace and policy labels of the metrics of namespaces that are
+// not allowed or beyond the series limit
+const TenantLabelOther = "other"
+
+// TenantLabels decides the values of the namespace and policy labels of dataplane metrics. It keeps the
+// cardinality of the metrics bounded: only namespaces in the allowlist get their own series, and once the
+// limit of series is reached new namespaces and policies are accounted under TenantLabelOther.
+type TenantLabels struct {
+	namespaces map[string]bool
+	maxSeries  int
+
+	mu            sync.Mutex
+	series        map[string]bool
+	limitReported bool
+}
+
+// NewTenantLabels returns the tenant labels for the given allowlist of namespaces, all namespaces being
+// allowed when it is empty, and limit of series. It returns nil, which leaves the labels empty, when
+// tenant labels are not enabled.
+func NewTenantLabels(enabled bool, namespaces []string, maxSeries int) *TenantLabels {
+	if !enabled {
+		return nil
+	}
+	t := &TenantLabels{
+		namespaces: make(map[string]bool),
+		maxSeries:  maxSeries,
+		series:     make(map[string]bool),
+	}
+	for _, namespace := range namespaces {
+		t.namespaces[namespace] = true
+	}
+	return t
+}
+
+// Values returns the values of the namespace and policy labels for traffic of the policy in the namespace
+func (t *TenantLabels) Values(namespace, policy string) (string, string) {
+	if t == nil {
+		return "", ""
+	}
+	if len(t.namespaces) != 0 && !t.namespaces[namespace] {
+		return TenantLabelOther, TenantLabelOther
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := namespace + "/" + policy
+	if t.series[key] {
+		return namespace, policy
+	}
+	if t.maxSeries > 0 && len(t.series) >= t.maxSeries {
+		if !t.limitReported {
+			glog.Warningf("Reached the limit of %d series of tenant labels, traffic of new namespaces and policies is accounted as %q",
+				t.maxSeries, TenantLabelOther)
+			t.limitReported = true
+		}
+		return TenantLabelOther, TenantLabelOther
+	}
+	t.series[key] = true
+	return namespace, policy
+}
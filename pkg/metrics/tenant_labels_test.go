@@ -0,0 +1,39 @@
+package metrics
+
+import "testing"
+
+func Test_TenantLabels(t *testing.T) {
+	var disabled *TenantLabels
+	if namespace, policy := disabled.Values("tenant-a", "allow-web"); namespace != "" || policy != "" {
+		t.Errorf("expected empty labels when tenant labels are disabled but got %q %q", namespace, policy)
+	}
+	if NewTenantLabels(false, nil, 10) != nil {
+		t.Errorf("expected no tenant labels when disabled")
+	}
+
+	labels := NewTenantLabels(true, []string{"tenant-a", "tenant-b"}, 2)
+	testcases := []struct {
+		namespace, policy string
+		expected          [2]string
+	}{
+		{"tenant-a", "allow-web", [2]string{"tenant-a", "allow-web"}},
+		{"kube-system", "allow-dns", [2]string{TenantLabelOther, TenantLabelOther}},
+		{"tenant-b", "allow-web", [2]string{"tenant-b", "allow-web"}},
+		// the limit of 2 series is reached
+		{"tenant-b", "allow-db", [2]string{TenantLabelOther, TenantLabelOther}},
+		{"tenant-a", "allow-web", [2]string{"tenant-a", "allow-web"}},
+	}
+	for _, testcase := range testcases {
+		namespace, policy := labels.Values(testcase.namespace, testcase.policy)
+		if namespace != testcase.expected[0] || policy != testcase.expected[1] {
+			t.Errorf("expected labels %v for %s/%s but got %q %q", testcase.expected, testcase.namespace, testcase.policy, namespace, policy)
+		}
+	}
+
+	unlimited := NewTenantLabels(true, nil, 0)
+	for _, namespace := range []string{"a", "b", "c"} {
+		if got, _ := unlimited.Values(namespace, ""); got != namespace {
+			t.Errorf("expected namespace %s to be allowed without allowlist and limit but got %s", namespace, got)
+		}
+	}
+}
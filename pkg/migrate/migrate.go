@@ -0,0 +1,352 @@
+// Package migrate detects the networking state left on a node by the Calico and flannel CNIs, reports how it
+// conflicts with the state kube-router programs, and removes it, to migrate the node to kube-router.
+package migrate
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/golang/glog"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// CNIs whose state is detected
+const (
+	Calico  = "calico"
+	Flannel = "flannel"
+)
+
+// kinds of residues, in the order they are removed: the rules jumping to the chains before the chains, and the
+// routes before the addresses and interfaces they go through
+const (
+	KindRule      = "rule"
+	KindChain     = "chain"
+	KindRoute     = "route"
+	KindAddress   = "address"
+	KindInterface = "interface"
+)
+
+var kindOrder = map[string]int{KindRule: 0, KindChain: 1, KindRoute: 2, KindAddress: 3, KindInterface: 4}
+
+// routing protocol of the routes learned by the BIRD BGP daemon of Calico, including the blackhole routes to the
+// address blocks of the node
+const birdRouteProtocol = 12
+
+var chainPrefixes = map[string]string{
+	"cali-":    Calico,
+	"felix-":   Calico,
+	"FLANNEL-": Flannel,
+}
+
+var interfacePrefixes = map[string]string{
+	"cali":            Calico,
+	"vxlan.calico":    Calico,
+	"vxlan-v6.calico": Calico,
+	"wireguard.cali":  Calico,
+	"flannel":         Flannel,
+	"cni0":            Flannel,
+}
+
+// the ipip module creates the tunl0 fallback device, which cannot be deleted. Calico assigns it an address,
+// only the address is a residue.
+const ipipFallbackInterface = "tunl0"
+
+// Residue is a piece of networking state left on the node by another CNI
+type Residue struct {
+	CNI  string
+	Kind string
+	// Name identifies the residue in the form used by iptables -S and ip route
+	Name string
+	// Conflict describes how the residue conflicts with the state kube-router programs, it is empty when the
+	// residue is merely unused
+	Conflict string
+
+	// flush is called on every residue before remove is called on any of them, so chains referencing each other
+	// can be deleted
+	flush  func() error
+	remove func() error
+}
+
+func (r Residue) String() string {
+	if r.Conflict == "" {
+		return fmt.Sprintf("%s %s: %s", r.CNI, r.Kind, r.Name)
+	}
+	return fmt.Sprintf("%s %s: %s (%s)", r.CNI, r.Kind, r.Name, r.Conflict)
+}
+
+// PodCIDRs maps the names of the nodes of the cluster to the pod CIDR kube-router routes to them
+type PodCIDRs map[string]*net.IPNet
+
+// conflict returns a description of the pod CIDR the network overlaps, or an empty string
+func (p PodCIDRs) conflict(network *net.IPNet) string {
+	nodes := make([]string, 0, len(p))
+	for node := range p {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		cidr := p[node]
+		if cidr.Contains(network.IP) || network.Contains(cidr.IP) {
+			return fmt.Sprintf("overlaps the pod CIDR %s of node %s", cidr, node)
+		}
+	}
+	return ""
+}
+
+// Detect returns the residues of Calico and flannel found on the node, in the order they must be removed. The
+// routes and addresses overlapping podCIDRs, which may be empty when the cluster cannot be reached, are reported
+// as conflicts.
+func Detect(podCIDRs PodCIDRs) ([]Residue, error) {
+	residues := make([]Residue, 0)
+	for _, protocol := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		found, err := detectChains(protocol)
+		if err != nil {
+			return nil, err
+		}
+		residues = append(residues, found...)
+	}
+	found, err := detectLinks(podCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	residues = append(residues, found...)
+
+	sort.SliceStable(residues, func(i, j int) bool {
+		return kindOrder[residues[i].Kind] < kindOrder[residues[j].Kind]
+	})
+	return residues, nil
+}
+
+// Clean removes the residues returned by Detect. Every residue is removed even when some fail, the returned
+// error lists the residues that could not be removed.
+func Clean(residues []Residue) error {
+	failed := make([]string, 0)
+	flushFailed := make(map[int]bool)
+	for i, r := range residues {
+		if r.flush == nil {
+			continue
+		}
+		if err := r.flush(); err != nil {
+			glog.Errorf("Failed to flush %s: %s", r, err)
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, err))
+			flushFailed[i] = true
+		}
+	}
+	for i, r := range residues {
+		if flushFailed[i] {
+			continue
+		}
+		if err := r.remove(); err != nil {
+			glog.Errorf("Failed to remove %s: %s", r, err)
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, err))
+			continue
+		}
+		glog.V(1).Infof("Removed %s", r)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func chainCNI(chain string) string {
+	for prefix, cni := range chainPrefixes {
+		if strings.HasPrefix(chain, prefix) {
+			return cni
+		}
+	}
+	return ""
+}
+
+// jumpCNI returns the CNI owning the chain the rule jumps to, or an empty string
+func jumpCNI(rule string) string {
+	parsed, ok, err := utils.ParseIPTablesRule(rule)
+	if err != nil || !ok {
+		return ""
+	}
+	return chainCNI(parsed.Target)
+}
+
+func interfaceCNI(name string) string {
+	for prefix, cni := range interfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return cni
+		}
+	}
+	return ""
+}
+
+// detectChains returns the chains of the other CNIs and the rules jumping to them from the other chains
+func detectChains(protocol iptables.Protocol) ([]Residue, error) {
+	iptablesCmdHandler, err := iptables.NewWithProtocol(protocol)
+	if err != nil {
+		if protocol == iptables.ProtocolIPv6 {
+			glog.Infof("Skipping ip6tables: %s", err)
+			return nil, nil
+		}
+		return nil, err
+	}
+	command := "iptables"
+	if protocol == iptables.ProtocolIPv6 {
+		command = "ip6tables"
+	}
+
+	residues := make([]Residue, 0)
+	for _, table := range []string{"filter", "nat", "mangle", "raw"} {
+		chains, err := iptablesCmdHandler.ListChains(table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list chains of the %s table: %s", table, err)
+		}
+		for _, chain := range chains {
+			table, chain := table, chain
+			if cni := chainCNI(chain); cni != "" {
+				residues = append(residues, Residue{
+					CNI:  cni,
+					Kind: KindChain,
+					Name: fmt.Sprintf("%s -t %s -N %s", command, table, chain),
+					flush: func() error {
+						return iptablesCmdHandler.ClearChain(table, chain)
+					},
+					remove: func() error {
+						return iptablesCmdHandler.DeleteChain(table, chain)
+					},
+				})
+				continue
+			}
+			rules, err := iptablesCmdHandler.List(table, chain)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list rules of the %s chain of the %s table: %s", chain, table, err)
+			}
+			for _, rule := range rules {
+				cni := jumpCNI(rule)
+				if cni == "" {
+					continue
+				}
+				spec, _ := utils.IPTablesRuleSpec(rule)
+				residues = append(residues, Residue{
+					CNI:      cni,
+					Kind:     KindRule,
+					Name:     fmt.Sprintf("%s -t %s %s", command, table, rule),
+					Conflict: fmt.Sprintf("sends the traffic of the %s chain through the chains of %s", chain, cni),
+					remove: func() error {
+						return iptablesCmdHandler.Delete(table, chain, spec...)
+					},
+				})
+			}
+		}
+	}
+	return residues, nil
+}
+
+// detectLinks returns the interfaces of the other CNIs, the addresses assigned to the ipip fallback device and
+// the routes installed by the other CNIs
+func detectLinks(podCIDRs PodCIDRs) ([]Residue, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %s", err)
+	}
+	residues := make([]Residue, 0)
+	linkCNIs := make(map[int]string)
+	linkNames := make(map[int]string)
+	for _, link := range links {
+		link := link
+		name := link.Attrs().Name
+		linkNames[link.Attrs().Index] = name
+
+		if name == ipipFallbackInterface {
+			addrs, err := netlink.AddrList(link, nl.FAMILY_ALL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list addresses of %s: %s", name, err)
+			}
+			for i := range addrs {
+				addr := addrs[i]
+				residues = append(residues, Residue{
+					CNI:      Calico,
+					Kind:     KindAddress,
+					Name:     fmt.Sprintf("%s dev %s", addr.IPNet, name),
+					Conflict: podCIDRs.conflict(addr.IPNet),
+					remove: func() error {
+						return netlink.AddrDel(link, &addr)
+					},
+				})
+			}
+			continue
+		}
+
+		cni := interfaceCNI(name)
+		if cni == "" {
+			continue
+		}
+		linkCNIs[link.Attrs().Index] = cni
+		r := Residue{
+			CNI:  cni,
+			Kind: KindInterface,
+			Name: name,
+			remove: func() error {
+				return netlink.LinkDel(link)
+			},
+		}
+		addrs, err := netlink.AddrList(link, nl.FAMILY_ALL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %s", name, err)
+		}
+		for _, addr := range addrs {
+			if conflict := podCIDRs.conflict(addr.IPNet); conflict != "" {
+				r.Conflict = fmt.Sprintf("address %s %s", addr.IPNet, conflict)
+				break
+			}
+		}
+		residues = append(residues, r)
+	}
+
+	for _, family := range []int{nl.FAMILY_V4, nl.FAMILY_V6} {
+		routes, err := netlink.RouteList(nil, family)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list routes: %s", err)
+		}
+		for i := range routes {
+			route := routes[i]
+			if route.Dst == nil {
+				continue
+			}
+			cni := linkCNIs[route.LinkIndex]
+			if route.Protocol == birdRouteProtocol {
+				cni = Calico
+			}
+			if cni == "" {
+				continue
+			}
+			residues = append(residues, Residue{
+				CNI:      cni,
+				Kind:     KindRoute,
+				Name:     routeName(route, linkNames[route.LinkIndex]),
+				Conflict: podCIDRs.conflict(route.Dst),
+				remove: func() error {
+					return netlink.RouteDel(&route)
+				},
+			})
+		}
+	}
+	return residues, nil
+}
+
+// routeName renders the route the way ip route does
+func routeName(route netlink.Route, dev string) string {
+	if route.Type == unix.RTN_BLACKHOLE {
+		return "blackhole " + route.Dst.String()
+	}
+	name := route.Dst.String()
+	if route.Gw != nil {
+		name += " via " + route.Gw.String()
+	}
+	if dev != "" {
+		name += " dev " + dev
+	}
+	return name
+}
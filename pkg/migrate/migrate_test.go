@@ -0,0 +1,72 @@
+package migrate
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestJumpCNI(t *testing.T) {
+	for rule, expected := range map[string]string{
+		`-A FORWARD -m comment --comment "cali:wUHhoiAYhphO9Mso" -j cali-FORWARD`:           Calico,
+		"-A POSTROUTING -m comment --comment \"flanneld masq\" -j FLANNEL-POSTRTG":          Flannel,
+		"-A cali-FORWARD -g felix-FORWARD":                                                  Calico,
+		`-A FORWARD -m comment --comment "rule to jump traffic to POD" -j KUBE-POD-FW-AAAA`: "",
+		`-A FORWARD -m comment --comment "cali-FORWARD" -j ACCEPT`:                          "",
+		"-N cali-FORWARD": "",
+	} {
+		if got := jumpCNI(rule); got != expected {
+			t.Errorf("expected jumpCNI(%q) to be %q, got %q", rule, expected, got)
+		}
+	}
+}
+
+func TestInterfaceCNI(t *testing.T) {
+	for name, expected := range map[string]string{
+		"cali12d4a061371": Calico,
+		"vxlan.calico":    Calico,
+		"flannel.1":       Flannel,
+		"cni0":            Flannel,
+		"tunl0":           "",
+		"kube-bridge":     "",
+		"tun-1921681":     "",
+	} {
+		if got := interfaceCNI(name); got != expected {
+			t.Errorf("expected interfaceCNI(%q) to be %q, got %q", name, expected, got)
+		}
+	}
+}
+
+func TestPodCIDRsConflict(t *testing.T) {
+	parse := func(cidr string) *net.IPNet {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("invalid CIDR %s: %s", cidr, err)
+		}
+		return network
+	}
+	podCIDRs := PodCIDRs{"node-a": parse("10.244.1.0/24"), "node-b": parse("10.244.2.0/24")}
+
+	if conflict := podCIDRs.conflict(parse("10.244.2.0/26")); conflict != "overlaps the pod CIDR 10.244.2.0/24 of node node-b" {
+		t.Errorf("expected a conflict with the pod CIDR of node-b, got %q", conflict)
+	}
+	if conflict := podCIDRs.conflict(parse("10.244.0.0/16")); conflict != "overlaps the pod CIDR 10.244.1.0/24 of node node-a" {
+		t.Errorf("expected a conflict with the pod CIDR of node-a, got %q", conflict)
+	}
+	if conflict := podCIDRs.conflict(parse("192.168.0.0/16")); conflict != "" {
+		t.Errorf("expected no conflict, got %q", conflict)
+	}
+}
+
+func TestRouteName(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.244.2.0/26")
+	if name := routeName(netlink.Route{Dst: dst, Type: unix.RTN_BLACKHOLE}, ""); name != "blackhole 10.244.2.0/26" {
+		t.Errorf("unexpected blackhole route name %q", name)
+	}
+	route := netlink.Route{Dst: dst, Gw: net.ParseIP("192.168.1.2"), Type: unix.RTN_UNICAST}
+	if name := routeName(route, "eth0"); name != "10.244.2.0/26 via 192.168.1.2 dev eth0" {
+		t.Errorf("unexpected route name %q", name)
+	}
+}
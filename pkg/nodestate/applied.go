@@ -0,0 +1,141 @@
+package nodestate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+)
+
+// AppliedState is the state a controller last applied successfully on the node
+type AppliedState struct {
+	Controller string    `json:"controller"`
+	Node       string    `json:"node"`
+	Applied    time.Time `json:"applied"`
+	State      State     `json:"state"`
+}
+
+// AppliedStateCache persists the state last applied by a controller in a file on the node, so that the
+// next instance of the controller can tell whether the node drifted while it was not running.
+type AppliedStateCache struct {
+	path       string
+	controller string
+	node       string
+}
+
+// NewAppliedStateCache returns a cache persisting the applied state of the controller in the directory
+// dir. It returns nil, which disables persisting the state, when dir is empty.
+func NewAppliedStateCache(dir, controller, node string) *AppliedStateCache {
+	if dir == "" {
+		return nil
+	}
+	return &AppliedStateCache{
+		path:       filepath.Join(dir, controller+"-applied-state.json"),
+		controller: controller,
+		node:       node,
+	}
+}
+
+// Save persists the state as the state last applied by the controller. The file is replaced atomically
+// so a crash never leaves a truncated file behind.
+func (c *AppliedStateCache) Save(state State) error {
+	if c == nil {
+		return nil
+	}
+	normalized := make(State)
+	for section := range state {
+		normalized[section] = state.Lines(section)
+	}
+	data, err := json.Marshal(AppliedState{
+		Controller: c.controller,
+		Node:       c.node,
+		Applied:    time.Now(),
+		State:      normalized,
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(c.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// Load returns the state last applied by the controller, or nil when no state was persisted yet
+func (c *AppliedStateCache) Load() (*AppliedState, error) {
+	if c == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	applied := &AppliedState{}
+	if err := json.Unmarshal(data, applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// ReportDrift compares the state found on the node with the state last applied by a previous instance of
+// the controller. When they differ it logs the changes and for how long the node may have been out of sync,
+// and exports both as metrics. It is meant to be called once on startup, before the first sync.
+func (c *AppliedStateCache) ReportDrift(readActualState func() (State, error)) {
+	if c == nil {
+		return
+	}
+	applied, err := c.Load()
+	if err != nil {
+		glog.Errorf("Failed to load the state last applied by %s from %s: %s", c.controller, c.path, err)
+		return
+	}
+	if applied == nil {
+		glog.V(1).Infof("No state last applied by %s found in %s", c.controller, c.path)
+		return
+	}
+	actual, err := readActualState()
+	if err != nil {
+		glog.Errorf("Failed to read the state of the node to compare with the state last applied by %s: %s", c.controller, err)
+		return
+	}
+
+	changes := Diff(applied.State, actual)
+	var outOfSync time.Duration
+	if len(changes) != 0 {
+		outOfSync = time.Since(applied.Applied)
+		glog.Warningf("%d objects on the node differ from the state last applied by %s at %s, the node has been out of sync for up to %s",
+			len(changes), c.controller, applied.Applied.Format(time.RFC3339), outOfSync)
+		for _, change := range changes {
+			if change.Missing {
+				glog.V(1).Infof("Missing %s: %s", change.Section, change.Line)
+			} else {
+				glog.V(1).Infof("Unexpected %s: %s", change.Section, change.Line)
+			}
+		}
+	} else {
+		glog.Infof("Node is in the state last applied by %s at %s", c.controller, applied.Applied.Format(time.RFC3339))
+	}
+	metrics.ControllerStartupDrift.WithLabelValues(c.controller).Set(float64(len(changes)))
+	metrics.ControllerStartupOutOfSyncSeconds.WithLabelValues(c.controller).Set(outOfSync.Seconds())
+}
@@ -0,0 +1,65 @@
+package nodestate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_AppliedStateCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "applied-state")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewAppliedStateCache(filepath.Join(dir, "state"), "netpol", "node-1")
+	applied, err := cache.Load()
+	if err != nil || applied != nil {
+		t.Fatalf("expected no applied state before first save, got %v, %v", applied, err)
+	}
+
+	state := make(State)
+	state.Add(IPSets, "KUBE-DST-B", "KUBE-DST-A", "KUBE-DST-A")
+	before := time.Now()
+	if err := cache.Save(state); err != nil {
+		t.Fatalf("unexpected error saving state: %v", err)
+	}
+
+	applied, err = cache.Load()
+	if err != nil {
+		t.Fatalf("unexpected error loading state: %v", err)
+	}
+	if applied.Controller != "netpol" || applied.Node != "node-1" || applied.Applied.Before(before) {
+		t.Errorf("unexpected applied state metadata: %+v", applied)
+	}
+	expected := State{IPSets: {"KUBE-DST-A", "KUBE-DST-B"}}
+	if !reflect.DeepEqual(applied.State, expected) {
+		t.Errorf("expected applied state %v but got %v", expected, applied.State)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "state"))
+	if len(files) != 1 {
+		t.Errorf("expected only the state file to be left behind, got %d files", len(files))
+	}
+}
+
+func Test_AppliedStateCacheDisabled(t *testing.T) {
+	cache := NewAppliedStateCache("", "netpol", "node-1")
+	if cache != nil {
+		t.Fatalf("expected cache to be disabled without a directory")
+	}
+	if err := cache.Save(make(State)); err != nil {
+		t.Errorf("unexpected error saving to disabled cache: %v", err)
+	}
+	if applied, err := cache.Load(); applied != nil || err != nil {
+		t.Errorf("expected nothing loaded from disabled cache, got %v, %v", applied, err)
+	}
+	cache.ReportDrift(func() (State, error) {
+		t.Fatalf("disabled cache must not read the node state")
+		return nil, nil
+	})
+}
@@ -0,0 +1,39 @@
+package nodestate
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// ReadIPTablesSave runs iptables-save for the given table and returns the rules found in it
+func ReadIPTablesSave(table string) ([]utils.IPTablesSaveRule, error) {
+	return readIPTablesSave(table, false)
+}
+
+// ReadIPTablesSaveWithCounters runs iptables-save for the given table and returns the rules found in it
+// along with their packet and byte counters
+func ReadIPTablesSaveWithCounters(table string) ([]utils.IPTablesSaveRule, error) {
+	return readIPTablesSave(table, true)
+}
+
+func readIPTablesSave(table string, counters bool) ([]utils.IPTablesSaveRule, error) {
+	args := []string{"-t", table}
+	if counters {
+		args = append(args, "-c")
+	}
+	out, err := utils.Exec("iptables-save", args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run iptables-save for table %s: %s", table, err.Error())
+	}
+	tables, err := utils.ParseIPTablesSave(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]utils.IPTablesSaveRule, 0)
+	for _, t := range tables {
+		rules = append(rules, t.Rules...)
+	}
+	return rules, nil
+}
@@ -0,0 +1,182 @@
+// Package nodestate provides a normalized, line oriented rendering of the networking state kube-router
+// programs on a node (iptables rules, ipsets, IPVS services and routes), so the state desired by the
+// controllers can be compared with the state actually found on the node.
+package nodestate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Section identifies a kind of networking state on the node
+type Section string
+
+const (
+	// IPTables holds iptables rules, rendered with IPTablesRule
+	IPTables Section = "iptables"
+	// IPSets holds ipsets and their entries, rendered with IPSetName and IPSetEntry
+	IPSets Section = "ipset"
+	// IPVS holds IPVS services and destinations, rendered with IPVSService and IPVSDestination
+	IPVS Section = "ipvs"
+	// Routes holds routes, rendered with Route
+	Routes Section = "route"
+)
+
+// Sections lists all the sections in the order they are reported
+var Sections = []Section{IPTables, IPSets, IPVS, Routes}
+
+const (
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorBold  = "\x1b[1m"
+	colorReset = "\x1b[0m"
+)
+
+// State is the rendered networking state of a node grouped by section. Each line identifies one object,
+// lines are compared verbatim so both sides of a comparison must be rendered with the helpers of this package.
+type State map[Section][]string
+
+// Add adds lines to the given section of the state
+func (s State) Add(section Section, lines ...string) {
+	s[section] = append(s[section], lines...)
+}
+
+// Merge adds all the lines of other to the state
+func (s State) Merge(other State) {
+	for section, lines := range other {
+		s.Add(section, lines...)
+	}
+}
+
+// Lines returns the sorted, de-duplicated lines of the given section
+func (s State) Lines(section Section) []string {
+	seen := make(map[string]bool)
+	lines := make([]string, 0, len(s[section]))
+	for _, line := range s[section] {
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// Change is a line that differs between the desired and the actual state
+type Change struct {
+	Section Section
+	Line    string
+	// Missing is true when the line is desired but not found on the node, false when the line is
+	// found on the node but is not desired
+	Missing bool
+}
+
+// Diff returns the changes needed to bring the actual state to the desired state, ordered by section
+// and line
+func Diff(desired, actual State) []Change {
+	changes := make([]Change, 0)
+	for _, section := range Sections {
+		desiredLines := desired.Lines(section)
+		actualLines := actual.Lines(section)
+		i, j := 0, 0
+		for i < len(desiredLines) || j < len(actualLines) {
+			switch {
+			case j == len(actualLines) || (i < len(desiredLines) && desiredLines[i] < actualLines[j]):
+				changes = append(changes, Change{Section: section, Line: desiredLines[i], Missing: true})
+				i++
+			case i == len(desiredLines) || actualLines[j] < desiredLines[i]:
+				changes = append(changes, Change{Section: section, Line: actualLines[j], Missing: false})
+				j++
+			default:
+				i++
+				j++
+			}
+		}
+	}
+	return changes
+}
+
+// WriteDiff writes the changes in a unified diff like format: lines prefixed with '+' are missing on the
+// node, lines prefixed with '-' are on the node but not desired. Output is colorized when color is true.
+func WriteDiff(w io.Writer, changes []Change, color bool) error {
+	var section Section
+	for _, change := range changes {
+		if change.Section != section {
+			section = change.Section
+			header := "--- " + string(section)
+			if color {
+				header = colorBold + header + colorReset
+			}
+			if _, err := fmt.Fprintln(w, header); err != nil {
+				return err
+			}
+		}
+		line := "- " + change.Line
+		lineColor := colorRed
+		if change.Missing {
+			line = "+ " + change.Line
+			lineColor = colorGreen
+		}
+		if color {
+			line = lineColor + line + colorReset
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IPTablesRule renders an iptables rule identified by its table, chain, target and comment. Rules
+// programmed by kube-router always carry a comment, which is what tells them apart.
+func IPTablesRule(table, chain, target, comment string) string {
+	return fmt.Sprintf("%s %s -j %s %q", table, chain, target, comment)
+}
+
+// IPSetName renders the existence of an ipset
+func IPSetName(set string) string {
+	return set
+}
+
+// IPSetEntry renders an entry of an ipset. Only the element and the nomatch flag are kept from the entry
+// options, timeouts are ignored.
+func IPSetEntry(set string, options ...string) string {
+	if len(options) == 0 {
+		return set
+	}
+	entry := set + " " + options[0]
+	for _, option := range options[1:] {
+		if option == "nomatch" {
+			entry += " nomatch"
+		}
+	}
+	return entry
+}
+
+// IPVSService renders an IPVS virtual service
+func IPVSService(protocol, ip string, port int) string {
+	return fmt.Sprintf("%s %s:%d", strings.ToLower(protocol), ip, port)
+}
+
+// IPVSFWMarkService renders an IPVS virtual service matching on a firewall mark
+func IPVSFWMarkService(fwMark uint32) string {
+	return fmt.Sprintf("fwmark 0x%x", fwMark)
+}
+
+// IPVSDestination renders a real server of an IPVS virtual service rendered with IPVSService or
+// IPVSFWMarkService
+func IPVSDestination(service, ip string, port int) string {
+	return fmt.Sprintf("%s -> %s:%d", service, ip, port)
+}
+
+// Route renders a route to dst, either through the gateway via or, when via is empty, out of the
+// interface dev
+func Route(dst, via, dev string) string {
+	if via != "" {
+		return dst + " via " + via
+	}
+	return dst + " dev " + dev
+}
@@ -0,0 +1,78 @@
+package nodestate
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Diff(t *testing.T) {
+	desired := make(State)
+	desired.Add(IPSets, IPSetName("KUBE-DST-A"), IPSetEntry("KUBE-DST-A", "10.0.0.1"), IPSetEntry("KUBE-DST-A", "10.0.0.2"))
+	desired.Add(Routes, Route("10.1.0.0/24", "192.168.1.2", ""))
+	desired.Add(Routes, Route("10.1.0.0/24", "192.168.1.2", ""))
+
+	actual := make(State)
+	actual.Add(IPSets, IPSetName("KUBE-DST-A"), IPSetEntry("KUBE-DST-A", "10.0.0.2", "timeout", "0"), IPSetEntry("KUBE-DST-A", "10.0.0.3", "timeout", "0"))
+	actual.Add(Routes, Route("10.1.0.0/24", "192.168.1.2", ""))
+
+	expected := []Change{
+		{IPSets, "KUBE-DST-A 10.0.0.1", true},
+		{IPSets, "KUBE-DST-A 10.0.0.3", false},
+	}
+	changes := Diff(desired, actual)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v but got %v", expected, changes)
+	}
+
+	if changes := Diff(desired, desired); len(changes) != 0 {
+		t.Errorf("expected no changes comparing a state with itself but got %v", changes)
+	}
+}
+
+func Test_WriteDiff(t *testing.T) {
+	changes := []Change{
+		{IPSets, "KUBE-DST-A 10.0.0.1", true},
+		{IPVS, "tcp 10.96.0.1:443", false},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDiff(&buf, changes, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "--- ipset\n+ KUBE-DST-A 10.0.0.1\n--- ipvs\n- tcp 10.96.0.1:443\n"
+	if buf.String() != expected {
+		t.Errorf("expected diff %q but got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteDiff(&buf, changes, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), colorGreen+"+ KUBE-DST-A 10.0.0.1"+colorReset) ||
+		!strings.Contains(buf.String(), colorRed+"- tcp 10.96.0.1:443"+colorReset) {
+		t.Errorf("expected colorized diff but got %q", buf.String())
+	}
+}
+
+func Test_IPSetEntry(t *testing.T) {
+	testcases := []struct {
+		name     string
+		options  []string
+		expected string
+	}{
+		{"set only", nil, "KUBE-SRC-A"},
+		{"element", []string{"10.0.0.1"}, "KUBE-SRC-A 10.0.0.1"},
+		{"timeout ignored", []string{"10.0.0.0/8", "timeout", "0"}, "KUBE-SRC-A 10.0.0.0/8"},
+		{"nomatch kept", []string{"10.1.0.0/16", "timeout", "0", "nomatch"}, "KUBE-SRC-A 10.1.0.0/16 nomatch"},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if entry := IPSetEntry("KUBE-SRC-A", testcase.options...); entry != testcase.expected {
+				t.Errorf("expected %q but got %q", testcase.expected, entry)
+			}
+		})
+	}
+}
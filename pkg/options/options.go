@@ -12,53 +12,144 @@ import (
 const DEFAULT_BGP_PORT = 179
 
 type KubeRouterConfig struct {
+	AcceptedFlowLogBurst           int
+	AcceptedFlowLogGroup           uint16
+	AcceptedFlowLogLimit           string
+	AdminSocket                    string
 	AdvertiseClusterIp             bool
+	AppliedStateDir                string
+	AuditLogCompress               bool
+	AuditLogFile                   string
+	AuditLogMaxFiles               int
+	AuditLogMaxSize                int
 	AdvertiseExternalIp            bool
 	AdvertiseNodePodCidr           bool
 	AdvertiseLoadBalancerIp        bool
 	BGPGracefulRestart             bool
 	BGPGracefulRestartDeferralTime time.Duration
 	BGPPort                        uint16
+	BGPSpeakerProcess              bool
+	CacheAuditPeriod               time.Duration
+	CacheAuditSampleSize           int
 	CacheSyncTimeout               time.Duration
 	CleanupConfig                  bool
 	ClusterAsn                     uint
 	ClusterCIDR                    string
+	CriticalFlows                  []string
 	DisableSrcDstCheck             bool
+	DropFlowExport                 string
+	DropFlowMetrics                bool
 	EnableCNI                      bool
+	EnableClusterAllowLists        bool
+	EnableClusterNetworkPolicies   bool
+	EnableClusterFederation        bool
+	EnableCriticalFlows            bool
+	EnableFQDNPolicies             bool
 	EnableiBGP                     bool
+	EnableIsolationProfiles        bool
 	EnableOverlay                  bool
 	EnablePodEgress                bool
+	EnablePolicyStatus             bool
 	EnablePprof                    bool
+	EnforceHostNetworkPods         bool
+	EventAggregationWindow         time.Duration
 	ExcludedCidrs                  []string
+	FQDNPolicyMinTTL               time.Duration
+	FQDNPolicyQueue                uint16
 	FullMeshMode                   bool
+	FwMarkExcludeMask              string
 	OverlayType                    string
 	GlobalHairpinMode              bool
 	HealthPort                     uint16
 	HelpRequested                  bool
 	HostnameOverride               string
+	InformerPageSize               int64
+	InformerResyncPeriod           time.Duration
+	InformerResyncPeriods          []string
+	InformerStripPods              bool
 	IPTablesSyncPeriod             time.Duration
+	IPSetManifestConfigMap         string
 	IpvsSyncPeriod                 time.Duration
 	IpvsGracefulPeriod             time.Duration
 	IpvsGracefulTermination        bool
 	IpvsPermitAll                  bool
 	Kubeconfig                     string
+	LeaderElectionLeaseDuration    time.Duration
+	LeaderElectionNamespace        string
+	LeaderElectionRenewDeadline    time.Duration
+	LeaderElectionRetryPeriod      time.Duration
+	LivenessFile                   string
+	LoadGovernor                   bool
+	LoadGovernorLoadThreshold      float64
+	LoadGovernorLockThreshold      float64
+	LoadGovernorMaxStretch         int
+	LogDroppedTraffic              bool
 	MasqueradeAll                  bool
 	Master                         string
+	MaxPolicyIPSetEntries          int
+	MaxPolicyRules                 int
 	MetricsEnabled                 bool
 	MetricsPath                    string
 	MetricsPort                    uint16
+	MetricsTenantLabels            bool
+	MetricsTenantMaxSeries         int
+	MetricsTenantNamespaces        []string
+	NamespacePlaceholderIPSets     bool
+	NetpolAllowClusterDNS          bool
+	NetpolAuditMode                bool
+	NetpolClusterDNSService        string
+	NetpolDefaultVerdict           string
+	NetpolDenyEvents               bool
+	NetpolExcludeNamespaces        []string
+	NetpolJumpMarkerComment        string
+	NetpolJumpPosition             string
+	NetpolLogLimit                 string
+	NetpolMinSyncPeriod            time.Duration
+	NetpolNFLogGroup               uint16
+	NetpolNamespaceSelector        string
 	NodePortBindOnAllIp            bool
+	NodePortFirewall               string
+	NodePortIPv6Addresses          string
+	NodePortIPv6CIDRs              []string
 	OverrideNextHop                bool
 	PeerASNs                       []uint
+	PeerIPSetAggregationThreshold  int
 	PeerMultihopTtl                uint8
 	PeerPasswords                  []string
 	PeerPorts                      []uint
 	PeerRouters                    []net.IP
+	PodEgressSNATPortRange         string
+	PodInterfacePrefix             string
+	PodInterfaceRules              bool
+	PodsRoutedMode                 bool
+	PolicyBackend                  string
+	PolicyConntrackMode            string
+	PolicyCountersPeriod           time.Duration
+	PolicyFlushCheckPeriod         time.Duration
+	PolicyFlushRevokedConntrack    bool
+	PolicyGCPeriod                 time.Duration
+	PolicyHitTracking              bool
+	PolicyIncrementalSync          bool
+	PolicyObserveOnly              bool
+	PolicyReadinessMaxWait         time.Duration
+	PolicyReadinessSocket          string
+	PolicyShrinkConfirmPeriod      time.Duration
+	PolicyShrinkThreshold          int
+	PolicyStatusPeriod             time.Duration
+	PostSyncHook                   string
+	PostSyncHookTimeout            time.Duration
+	PreflightLoadModules           bool
 	RouterId                       string
 	RoutesSyncPeriod               time.Duration
 	RunFirewall                    bool
 	RunRouter                      bool
 	RunServiceProxy                bool
+	ServiceGraphSamplePeriod       time.Duration
+	ServiceNodePortRange           string
+	Shadow                         bool
+	ShadowTimeout                  time.Duration
+	StaleChainQuarantine           time.Duration
+	SyncCoordinator                bool
 	Version                        bool
 	VLevel                         string
 	// FullMeshPassword    string
@@ -66,14 +157,54 @@ type KubeRouterConfig struct {
 
 func NewKubeRouterConfig() *KubeRouterConfig {
 	return &KubeRouterConfig{
+		AcceptedFlowLogBurst:           10,
+		AcceptedFlowLogLimit:           "10/second",
+		AppliedStateDir:                "/var/lib/kube-router",
+		AuditLogCompress:               true,
+		AuditLogMaxFiles:               5,
+		AuditLogMaxSize:                100,
+		CacheAuditPeriod:               10 * time.Minute,
+		CacheAuditSampleSize:           20,
 		CacheSyncTimeout:               1 * time.Minute,
 		IpvsSyncPeriod:                 5 * time.Minute,
 		IPTablesSyncPeriod:             5 * time.Minute,
+		NetpolMinSyncPeriod:            time.Second,
+		NetpolNFLogGroup:               100,
+		NetpolLogLimit:                 "10/minute",
+		NetpolClusterDNSService:        "kube-system/kube-dns",
+		NetpolJumpPosition:             "top",
+		NetpolDefaultVerdict:           "reject",
 		IpvsGracefulPeriod:             30 * time.Second,
 		RoutesSyncPeriod:               5 * time.Minute,
 		BGPGracefulRestartDeferralTime: 360 * time.Second,
 		EnableOverlay:                  true,
+		EventAggregationWindow:         5 * time.Minute,
+		FQDNPolicyMinTTL:               1 * time.Minute,
+		FQDNPolicyQueue:                100,
 		OverlayType:                    "subnet",
+		LeaderElectionLeaseDuration:    15 * time.Second,
+		LeaderElectionNamespace:        "kube-system",
+		LeaderElectionRenewDeadline:    10 * time.Second,
+		LeaderElectionRetryPeriod:      2 * time.Second,
+		LoadGovernorLoadThreshold:      2,
+		LoadGovernorLockThreshold:      0.5,
+		LoadGovernorMaxStretch:         4,
+		MetricsTenantMaxSeries:         1000,
+		NamespacePlaceholderIPSets:     true,
+		NodePortFirewall:               "none",
+		NodePortIPv6Addresses:          "none",
+		PeerIPSetAggregationThreshold:  1000,
+		PodInterfacePrefix:             "veth",
+		PolicyBackend:                  "iptables",
+		PolicyConntrackMode:            "permissive",
+		PolicyIncrementalSync:          true,
+		PolicyReadinessMaxWait:         10 * time.Second,
+		PolicyShrinkConfirmPeriod:      1 * time.Minute,
+		PolicyStatusPeriod:             1 * time.Minute,
+		PostSyncHookTimeout:            30 * time.Second,
+		ServiceNodePortRange:           "30000-32767",
+		ShadowTimeout:                  10 * time.Minute,
+		StaleChainQuarantine:           5 * time.Minute,
 	}
 }
 
@@ -84,6 +215,21 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"Print version information.")
 	fs.DurationVar(&s.CacheSyncTimeout, "cache-sync-timeout", s.CacheSyncTimeout,
 		"The timeout for cache synchronization (e.g. '5s', '1m'). Must be greater than 0.")
+	fs.DurationVar(&s.CacheAuditPeriod, "cache-audit-period", s.CacheAuditPeriod,
+		"Period of the audits comparing a sample of the informer caches with the API server (e.g. '10m'). 0 disables the audits.")
+	fs.IntVar(&s.CacheAuditSampleSize, "cache-audit-sample-size", s.CacheAuditSampleSize,
+		"Number of objects of each informer cache compared with the API server on each audit.")
+	fs.DurationVar(&s.InformerResyncPeriod, "informer-resync-period", s.InformerResyncPeriod,
+		"Period at which the informers replay all the cached objects to the controllers as updates (e.g. '30m'). 0 disables resyncs.")
+	fs.StringSliceVar(&s.InformerResyncPeriods, "informer-resync-periods", s.InformerResyncPeriods,
+		"Resync periods of the informers of given resources overriding --informer-resync-period, as resource=period pairs "+
+			"(e.g. 'endpoints=0,pods=1h'). Resources are pods, namespaces, networkpolicies, services, endpoints and nodes.")
+	fs.Int64Var(&s.InformerPageSize, "informer-page-size", 0,
+		"Maximum number of objects of each page the informers list from the API server, read from etcd rather than "+
+			"from the watch cache of the API server. 0 lists all the objects in a single response from the watch cache.")
+	fs.BoolVar(&s.InformerStripPods, "informer-strip-pods", true,
+		"Strip the fields the controllers do not read, such as the volumes, environment and probes of the containers, "+
+			"from the pods before the informer caches them.")
 	fs.BoolVar(&s.RunServiceProxy, "run-service-proxy", true,
 		"Enables Service Proxy -- sets up IPVS for Kubernetes Services.")
 	fs.BoolVar(&s.RunFirewall, "run-firewall", true,
@@ -104,8 +250,209 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"Excluded CIDRs are used to exclude IPVS rules from deletion.")
 	fs.BoolVar(&s.EnablePodEgress, "enable-pod-egress", true,
 		"SNAT traffic from Pods to destinations outside the cluster.")
+	fs.StringVar(&s.PodEgressSNATPortRange, "pod-egress-snat-port-range", s.PodEgressSNATPortRange,
+		"Source port range (e.g. '32768-60999') used when masquerading TCP and UDP traffic from Pods to destinations outside the cluster. "+
+			"Can be overridden per node with the kube-router.io/pod-egress.snat-port-range annotation, read at startup, to give nodes sharing a public address disjoint ranges. "+
+			"Defaults to the kernel's choice.")
+	fs.Uint16Var(&s.AcceptedFlowLogGroup, "accepted-flow-log-nflog-group", s.AcceptedFlowLogGroup,
+		"NFLOG group to log the first packet of accepted connections of pods labeled with kube-router.io/audit-accepted-flows=true. "+
+			"Must be different from the group used for dropped traffic (--netpol-nflog-group). 0 disables accepted flow logging.")
+	fs.StringVar(&s.AcceptedFlowLogLimit, "accepted-flow-log-limit", s.AcceptedFlowLogLimit,
+		"Maximum average rate of accepted connections logged per pod and direction (e.g. '10/second', '100/minute').")
+	fs.IntVar(&s.AcceptedFlowLogBurst, "accepted-flow-log-burst", s.AcceptedFlowLogBurst,
+		"Maximum burst of accepted connections logged per pod and direction before the rate limit applies.")
+	fs.BoolVar(&s.EnableIsolationProfiles, "enable-namespace-isolation-profiles", false,
+		"Expand NamespaceIsolationProfile custom resources into network policies for the namespaces they select.")
+	fs.BoolVar(&s.EnableClusterAllowLists, "enable-cluster-allow-lists", false,
+		"Allow the CIDRs of the ClusterAllowList custom resources to their ports of all the pods isolated by network "+
+			"policies, whatever their network policies.")
+	fs.BoolVar(&s.EnableClusterNetworkPolicies, "enable-cluster-network-policies", false,
+		"Apply the Allow and Deny rules of the ClusterNetworkPolicy custom resources to the pods of the namespaces "+
+			"they select ahead of their network policies, whether network policies isolate the pods or not. Only "+
+			"supported with --policy-backend=iptables.")
+	fs.StringSliceVar(&s.CriticalFlows, "critical-flows", s.CriticalFlows,
+		"Flows of the pods essential to the nodes, accepted ahead of the cluster network policies and of the network "+
+			"policies, as direction:cidr:protocol:port[-endport] (e.g. 'egress:10.0.0.10/32:tcp:6443'). Only "+
+			"supported with --policy-backend=iptables.")
+	fs.BoolVar(&s.EnableCriticalFlows, "enable-critical-flows", false,
+		"Accept the flows of the CriticalFlow custom resources, like those of --critical-flows, for all the pods or "+
+			"the pods of their priority classes. Only supported with --policy-backend=iptables.")
+	fs.BoolVar(&s.EnableFQDNPolicies, "enable-fqdn-policies", false,
+		"Allow the egress of the pods of the network policies annotated with kube-router.io/egress-fqdns to the "+
+			"addresses the cluster DNS resolves the domain names to, snooped from its responses. Requires "+
+			"--netpol-allow-cluster-dns and --policy-backend=iptables.")
+	fs.DurationVar(&s.FQDNPolicyMinTTL, "fqdn-policy-min-ttl", s.FQDNPolicyMinTTL,
+		"Minimum time the addresses resolved for the domain names of the FQDN network policies are allowed, "+
+			"whatever the TTL of their DNS records.")
+	fs.Uint16Var(&s.FQDNPolicyQueue, "fqdn-policy-nfqueue", s.FQDNPolicyQueue,
+		"NFQUEUE queue the responses of the cluster DNS are snooped from with --enable-fqdn-policies.")
+	fs.StringVar(&s.IPSetManifestConfigMap, "ipset-manifest-configmap", s.IPSetManifestConfigMap,
+		"ConfigMap, as namespace/name, the leader writes the ipsets of the network policies to, by namespace, for host "+
+			"firewalls to reference them. Empty disables the manifest.")
+	fs.BoolVar(&s.LogDroppedTraffic, "log-dropped-traffic", false,
+		"Read the traffic dropped by network policies from the NFLOG group of --netpol-nflog-group and log it, naming the pods, services and "+
+			"nodes of its addresses. No other process, e.g. ulogd, can read the group then.")
+	fs.StringVar(&s.DropFlowExport, "drop-flow-export", "",
+		"File path, or unix:// socket path, to write the traffic dropped by network policies to as JSON lines, naming the "+
+			"pods, services and nodes of its addresses and the policies isolating the pod. Reads the NFLOG group of "+
+			"--netpol-nflog-group, which no other process can read then.")
+	fs.BoolVar(&s.DropFlowMetrics, "drop-flow-metrics", false,
+		"Count the traffic dropped by network policies by direction and namespaces of its addresses. Needs --metrics-port "+
+			"and reads the NFLOG group of --netpol-nflog-group, which no other process can read then.")
+	fs.BoolVar(&s.NetpolAllowClusterDNS, "netpol-allow-cluster-dns", false,
+		"Allow the pods to query the cluster DNS, its service address and endpoints on the ports and protocols of the "+
+			"service, whatever their egress network policies.")
+	fs.BoolVar(&s.NetpolAuditMode, "netpol-audit-mode", false,
+		"Put all the network policies in audit mode: the traffic they would drop is logged to --netpol-nflog-group and "+
+			"accepted. Single policies are put in audit mode with the kube-router.io/netpol-audit=true annotation.")
+	fs.StringVar(&s.NetpolClusterDNSService, "netpol-cluster-dns-service", s.NetpolClusterDNSService,
+		"namespace/name of the cluster DNS service the pods are allowed to with --netpol-allow-cluster-dns.")
+	fs.StringVar(&s.NetpolDefaultVerdict, "netpol-default-verdict", s.NetpolDefaultVerdict,
+		"Verdict of the traffic to or from pods no network policy accepted, reject or drop. Overridden for the pods of "+
+			"a namespace by its kube-router.io/netpol-default-verdict annotation.")
+	fs.BoolVar(&s.NetpolDenyEvents, "netpol-deny-events", false,
+		"Record a PacketDeniedByNetworkPolicy event on the pods whose network policies drop traffic, aggregated per pod "+
+			"over --event-aggregation-window. Reads the NFLOG group of --netpol-nflog-group, which no other process can "+
+			"read then.")
+	fs.StringVar(&s.NetpolJumpPosition, "netpol-jump-position", s.NetpolJumpPosition,
+		"Where the rules jumping to the KUBE-ROUTER-FORWARD, KUBE-ROUTER-OUTPUT and KUBE-ROUTER-INPUT chains, which "+
+			"jump to the pod firewall chains, are inserted in the FORWARD, OUTPUT and INPUT chains: top, bottom, or "+
+			"after-marker-comment to insert them after the last rule with the comment of --netpol-jump-marker-comment, "+
+			"at the top when the chain has none. Moved jumps are put back in place.")
+	fs.StringVar(&s.NetpolJumpMarkerComment, "netpol-jump-marker-comment", "",
+		"Comment of the rule the jumps to the dispatch chains follow with --netpol-jump-position=after-marker-comment.")
+	fs.Uint16Var(&s.NetpolNFLogGroup, "netpol-nflog-group", s.NetpolNFLogGroup,
+		"NFLOG group the pod firewall chains log the traffic dropped by the network policies to, e.g. for ulogd or a "+
+			"flow collector. 0 disables the logging of the dropped traffic.")
+	fs.StringSliceVar(&s.NetpolExcludeNamespaces, "netpol-exclude-namespaces", s.NetpolExcludeNamespaces,
+		"Namespaces whose pods get no pod firewall chain and whose network policies are not enforced, e.g. "+
+			"'kube-system'. Their pods remain peers of the network policies of the other namespaces.")
+	fs.StringVar(&s.NetpolNamespaceSelector, "netpol-namespace-selector", s.NetpolNamespaceSelector,
+		"Label selector of the namespaces whose network policies are enforced, e.g. 'netpol.kube-router.io/enforced=true'. "+
+			"The pods of the other namespaces are left unisolated whatever their network policies. All the namespaces "+
+			"when empty.")
+	fs.StringVar(&s.NetpolLogLimit, "netpol-log-limit", s.NetpolLogLimit,
+		"Maximum average rate of dropped packets logged per pod (e.g. '10/minute', '5/second'), with bursts of 10 packets.")
+	fs.StringVar(&s.PolicyBackend, "policy-backend", s.PolicyBackend,
+		"Dataplane enforcing the network policies, iptables or nftables. The nftables backend programs the pod "+
+			"firewall and network policy chains and their sets in the nftables table ip kube-router-netpol.")
+	fs.StringVar(&s.PolicyConntrackMode, "policy-conntrack-mode", s.PolicyConntrackMode,
+		"Connections the pod firewall chains accept without evaluating the network policies, permissive or strict. "+
+			"permissive accepts all the connections conntrack tracks as established or related, strict only the "+
+			"established ones and the ICMP errors related to them, and drops the TCP packets opening connections without SYN.")
+	fs.BoolVar(&s.PolicyFlushRevokedConntrack, "policy-flush-revoked-conntrack", false,
+		"Delete the connections conntrack tracks between the pods of the node and the addresses a sync removes from the "+
+			"ipsets of their network policies, so the revoked connections are evaluated again. Only applies to the "+
+			"iptables backend.")
+	fs.BoolVar(&s.PolicyHitTracking, "policy-hit-tracking", false,
+		"Track when the allow rules of the network policies selecting pods of the node last matched a packet, for "+
+			"kube-routerctl unused to report the policies unused over a window. Only applies to the iptables backend.")
+	fs.DurationVar(&s.PolicyCountersPeriod, "policy-counters-period", 0,
+		"Period of the reads of the packet and byte counters of the rules of the network policies, exported as the "+
+			"policy_packets_total and policy_bytes_total metrics labeled by namespace, policy and rule, and of the "+
+			"default rules of the pod firewall chains, exported as the pod_rejected_packets_total metric labeled by "+
+			"namespace and pod. 0 disables them. Only applies to the iptables backend.")
+	fs.DurationVar(&s.ServiceGraphSamplePeriod, "service-graph-sample-period", 0,
+		"Interval between the samples of the connections tracked by conntrack that build the graph of the workloads, "+
+			"services and addresses the pods of the node connect to, served on the admin socket for kube-routerctl graph. "+
+			"0 disables the service graph.")
+	fs.BoolVar(&s.PolicyIncrementalSync, "policy-incremental-sync", s.PolicyIncrementalSync,
+		"On pod events that only change the pods matched by the network policies, refresh the ipsets of the pods "+
+			"instead of a full sync of the network policy chains. The periodic syncs are always full. Only applies to the iptables backend.")
+	fs.BoolVar(&s.PolicyObserveOnly, "policy-observe-only", false,
+		"Build the model of the network policies without programming the node, to run alongside another network "+
+			"policy engine. The state that would be programmed and the verdicts for flows are served on the admin socket.")
+	fs.IntVar(&s.MaxPolicyRules, "max-policy-rules", 0,
+		"Maximum number of iptables rules a network policy may expand into on the node. The rules of the policies "+
+			"exceeding it are not programmed, the pods they select are denied the traffic they allow. 0 for no limit.")
+	fs.IntVar(&s.MaxPolicyIPSetEntries, "max-policy-ipset-entries", 0,
+		"Maximum number of ipset entries a network policy may expand into on the node. The rules of the policies "+
+			"exceeding it are not programmed, the pods they select are denied the traffic they allow. 0 for no limit.")
+	fs.IntVar(&s.PeerIPSetAggregationThreshold, "peer-ipset-aggregation-threshold", s.PeerIPSetAggregationThreshold,
+		"Number of pods of a network policy peer past which their addresses are aggregated into the CIDRs covering "+
+			"exactly them, in a hash:net ipset. 0 disables the aggregation.")
+	fs.BoolVar(&s.EnablePolicyStatus, "enable-policy-status", false,
+		"Report the enforcement of the network policies on the node in a NodePolicyStatus custom resource, "+
+			"aggregated by the leader into the ClusterPolicyStatus custom resource.")
+	fs.DurationVar(&s.PolicyStatusPeriod, "policy-status-period", s.PolicyStatusPeriod,
+		"Minimum interval between the updates of the NodePolicyStatus of the node and of the ClusterPolicyStatus.")
+	fs.BoolVar(&s.PreflightLoadModules, "preflight-load-modules", false,
+		"Load the kernel modules required by the enabled controllers with modprobe when the startup preflight checks find them missing.")
+	fs.StringVar(&s.PolicyReadinessSocket, "policy-readiness-socket", s.PolicyReadinessSocket,
+		"Path of a unix socket (e.g. '/var/run/kube-router/policy-readiness.sock') on which the CNI plugin can query "+
+			"whether the network policies apply to a pod. Pods of namespaces annotated with kube-router.io/wait-for-policy=true "+
+			"are only reported ready once a sync accounted for them. Disabled when empty.")
+	fs.IntVar(&s.PolicyShrinkThreshold, "policy-shrink-threshold", 0,
+		"Percentage of the network policy chains and ipsets the desired state of a sync may shrink by before the "+
+			"removal of the stale ones is held until confirmed, e.g. when an informer restarted with an empty lister. "+
+			"0 disables the guard. Only applies to the iptables backend.")
+	fs.DurationVar(&s.PolicyShrinkConfirmPeriod, "policy-shrink-confirm-period", s.PolicyShrinkConfirmPeriod,
+		"Time after which a sync confirms a shrink of the desired state beyond --policy-shrink-threshold and removes "+
+			"the stale chains and ipsets. 0 leaves the confirmation to the operator, with kube-routerctl confirm-cleanup.")
+	fs.DurationVar(&s.PolicyGCPeriod, "policy-gc-period", 0,
+		"Period of the removal of the stale network policy and pod firewall chains and ipsets, outside of the syncs "+
+			"and of the lock they hold. 0 removes them at the end of each sync. Only applies to the iptables backend.")
+	fs.DurationVar(&s.PolicyFlushCheckPeriod, "policy-flush-check-period", 0,
+		"Period of the check of the network policy and pod firewall chains, and of the jumps to them, against the rules "+
+			"of the last sync. A sync is queued right away when they were flushed or changed outside of kube-router. "+
+			"0 disables the check. Only applies to the iptables backend.")
+	fs.StringVar(&s.AdminSocket, "admin-socket", s.AdminSocket,
+		"Path of a unix socket (e.g. '/var/run/kube-router/admin.sock') on which an instance started with --shadow asks the running "+
+			"instance to hand over the dataplane, and kube-routerctl samples, evaluates flows against and reports on the "+
+			"network policies. Disabled when empty.")
+	fs.BoolVar(&s.Shadow, "shadow", false,
+		"Start without programming the node until the desired state matches the dataplane programmed by the running instance, "+
+			"then take over from it through --admin-socket.")
+	fs.DurationVar(&s.ShadowTimeout, "shadow-timeout", s.ShadowTimeout,
+		"Maximum time an instance started with --shadow waits for the desired state to match the dataplane before exiting (e.g. '10m').")
+	fs.DurationVar(&s.PolicyReadinessMaxWait, "policy-readiness-max-wait", s.PolicyReadinessMaxWait,
+		"Maximum duration a policy readiness request waits for the pod to become ready.")
+	fs.BoolVar(&s.PodsRoutedMode, "pods-routed-mode", false,
+		"Pods are routed by the node rather than attached to a bridge (e.g. with the ptp CNI plugin). "+
+			"Traffic between pods on the node is matched by the host side interfaces of the pods (see --pod-interface-prefix) "+
+			"instead of the physdev match, and the bridge netfilter preflight check is skipped.")
+	fs.BoolVar(&s.PodsRoutedMode, "routed-pods", false, "Same as --pods-routed-mode.")
+	fs.MarkDeprecated("routed-pods", "use --pods-routed-mode instead")
+	fs.StringVar(&s.PodInterfacePrefix, "pod-interface-prefix", s.PodInterfacePrefix,
+		"Prefix of the names of the host side interfaces of the pods, matched with --pods-routed-mode.")
+	fs.BoolVar(&s.EnforceHostNetworkPods, "enforce-host-network-pods", false,
+		"Enforce the ingress network policies selecting host-network pods on the traffic to the ports their containers declare.")
+	fs.BoolVar(&s.PodInterfaceRules, "pod-interface-rules", false,
+		"Match the traffic between the pods of the node by the host side interface of each pod, found from the route "+
+			"to the pod, rather than by its IP. Requires --pods-routed-mode.")
+	fs.BoolVar(&s.NamespacePlaceholderIPSets, "namespace-selector-placeholder-ipsets", s.NamespacePlaceholderIPSets,
+		"Keep the ipsets and rules of network policy peers whose namespace selector matches no namespace yet, "+
+			"so namespaces gaining matching labels are allowed as soon as the labels change.")
 	fs.DurationVar(&s.IPTablesSyncPeriod, "iptables-sync-period", s.IPTablesSyncPeriod,
 		"The delay between iptables rule synchronizations (e.g. '5s', '1m'). Must be greater than 0.")
+	fs.DurationVar(&s.NetpolMinSyncPeriod, "netpol-min-sync-period", s.NetpolMinSyncPeriod,
+		"Minimum delay between the network policy syncs triggered by pod, namespace and network policy events. The "+
+			"events received in between are coalesced into a single sync. 0 syncs as soon as the previous sync completed.")
+	fs.StringVar(&s.LeaderElectionNamespace, "leader-election-namespace", s.LeaderElectionNamespace,
+		"Namespace of the ConfigMap locking the leadership of the cluster-scope tasks, run by a single kube-router of the cluster.")
+	fs.DurationVar(&s.LeaderElectionLeaseDuration, "leader-election-lease-duration", s.LeaderElectionLeaseDuration,
+		"Time the other kube-routers wait for the leader to renew its leadership before taking over.")
+	fs.DurationVar(&s.LeaderElectionRenewDeadline, "leader-election-renew-deadline", s.LeaderElectionRenewDeadline,
+		"Time the leader retries to renew its leadership before stopping the cluster-scope tasks. Must be less than the lease duration.")
+	fs.DurationVar(&s.LeaderElectionRetryPeriod, "leader-election-retry-period", s.LeaderElectionRetryPeriod,
+		"Time between the attempts to acquire or renew the leadership. Must be less than the renew deadline.")
+	fs.DurationVar(&s.EventAggregationWindow, "event-aggregation-window", s.EventAggregationWindow,
+		"Events of the same reason about the node recorded within this window are counted in the event recorded "+
+			"first, updated once per window, instead of recorded anew.")
+	fs.BoolVar(&s.LoadGovernor, "load-governor", false,
+		"Stretch the periodic sync periods of the controllers, up to --load-governor-max-stretch times, while the node "+
+			"is overloaded, as shown by the load average or the contention on the iptables lock.")
+	fs.Float64Var(&s.LoadGovernorLoadThreshold, "load-governor-load-threshold", s.LoadGovernorLoadThreshold,
+		"1 minute load average per CPU above which the load governor finds the node overloaded.")
+	fs.Float64Var(&s.LoadGovernorLockThreshold, "load-governor-lock-threshold", s.LoadGovernorLockThreshold,
+		"Fraction of the time the iptables lock is held above which the load governor finds the node overloaded.")
+	fs.IntVar(&s.LoadGovernorMaxStretch, "load-governor-max-stretch", s.LoadGovernorMaxStretch,
+		"Maximum factor the load governor stretches the periodic sync periods by.")
+	fs.BoolVar(&s.SyncCoordinator, "sync-coordinator", false,
+		"Run the syncs of the enabled controllers one at a time, the syncs requested by events ahead of the periodic "+
+			"ones, and stagger their periodic syncs, so they do not contend for the iptables lock.")
+	fs.DurationVar(&s.StaleChainQuarantine, "stale-chain-quarantine", s.StaleChainQuarantine,
+		"Time stale pod firewall and network policy chains are kept, renamed with the KUBE-QRNT- prefix and no longer referenced, before they are deleted (e.g. '5m'). 0 deletes them right away.")
 	fs.DurationVar(&s.IpvsSyncPeriod, "ipvs-sync-period", s.IpvsSyncPeriod,
 		"The delay between ipvs config synchronizations (e.g. '5s', '1m', '2h22m'). Must be greater than 0.")
 	fs.DurationVar(&s.IpvsGracefulPeriod, "ipvs-graceful-period", s.IpvsGracefulPeriod,
@@ -142,9 +489,14 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"BGP Graceful restart deferral time according to RFC4724 4.1, maximum 18h.")
 	fs.Uint16Var(&s.BGPPort, "bgp-port", DEFAULT_BGP_PORT,
 		"The port open for incoming BGP connections and to use for connecting with other BGP peers.")
+	fs.BoolVar(&s.BGPSpeakerProcess, "bgp-speaker-process", false,
+		"Run the BGP speaker in a separate process supervised by kube-router, so a failure of the BGP handling does not affect the other controllers.")
 	fs.StringVar(&s.RouterId, "router-id", "", "BGP router-id. Must be specified in a ipv6 only cluster.")
 	fs.BoolVar(&s.EnableCNI, "enable-cni", true,
 		"Enable CNI plugin. Disable if you want to use kube-router features alongside another CNI plugin.")
+	fs.BoolVar(&s.EnableClusterFederation, "enable-cluster-federation", false,
+		"Route the pod and service CIDRs of the remote clusters described by RemoteCluster custom resources toward their BGP endpoints, "+
+			"and create an ipset for each listed remote namespace.")
 	fs.BoolVar(&s.EnableiBGP, "enable-ibgp", true,
 		"Enables peering with nodes with the same ASN, if disabled will only peer with external BGP peers")
 	fs.StringVar(&s.HostnameOverride, "hostname-override", s.HostnameOverride,
@@ -153,6 +505,18 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"Add iptables rules for every Service Endpoint to support hairpin traffic.")
 	fs.BoolVar(&s.NodePortBindOnAllIp, "nodeport-bindon-all-ip", false,
 		"For service of NodePort type create IPVS service that listens on all IP's of the node.")
+	fs.StringVar(&s.NodePortIPv6Addresses, "nodeport-ipv6-addresses", s.NodePortIPv6Addresses,
+		"IPv6 addresses of the node NodePort services also listen on with --nodeport-bindon-all-ip: none, stable "+
+			"(global addresses that are neither temporary privacy addresses nor deprecated) or all (all global addresses). "+
+			"Link-local addresses are never used.")
+	fs.StringSliceVar(&s.NodePortIPv6CIDRs, "nodeport-ipv6-cidrs", s.NodePortIPv6CIDRs,
+		"Only the IPv6 addresses selected by --nodeport-ipv6-addresses within these CIDRs get NodePort services.")
+	fs.StringVar(&s.ServiceNodePortRange, "service-node-port-range", s.ServiceNodePortRange,
+		"Range of the node ports of the services, the --service-node-port-range of the API server (min-max or base+size). "+
+			"Its ports are kept in the kube-router-node-ports ipset.")
+	fs.StringVar(&s.NodePortFirewall, "nodeport-firewall", s.NodePortFirewall,
+		"Traffic to the node ports of the node, matched with the kube-router-node-ports ipset: none (left to the other "+
+			"rules of the node), allow (accepted) or deny (rejected) ahead of the other rules of the INPUT chain.")
 	fs.BoolVar(&s.EnableOverlay, "enable-overlay", true,
 		"When enable-overlay is set to true, IP-in-IP tunneling is used for pod-to-pod networking across nodes in different subnets. "+
 			"When set to false no tunneling is used and routing infrastructure is expected to route traffic for pod-to-pod networking across nodes in different subnets")
@@ -162,14 +526,42 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 			"When set to \"full\", it changes \"--enable-overlay=true\" default behavior so that IP-in-IP tunneling is used for pod-to-pod networking across nodes regardless of the subnet the nodes are in.")
 	fs.StringSliceVar(&s.PeerPasswords, "peer-router-passwords", s.PeerPasswords,
 		"Password for authenticating against the BGP peer defined with \"--peer-router-ips\".")
+	fs.StringVar(&s.FwMarkExcludeMask, "fwmark-exclude-mask", "0x0",
+		"Bits of the packet mark (fwmark) kube-router must not use, e.g. '0xffff0000' when migrating from or running alongside Calico. "+
+			"Bits for DSR (below 0x8000) and network policy/service proxy interop are allocated from the remaining bits, besides the masquerade (0x4000) and drop (0x8000) bits of the kubelet.")
+	fs.StringVar(&s.PostSyncHook, "post-sync-hook", s.PostSyncHook,
+		"Program to execute, or http(s) URL to POST to, with a JSON summary after each successful sync of the network policy, service proxy and routing controllers. "+
+			"Programs get the summary on standard input.")
+	fs.DurationVar(&s.PostSyncHookTimeout, "post-sync-hook-timeout", s.PostSyncHookTimeout,
+		"The timeout for a single invocation of the post sync hook (e.g. '5s', '1m'). Must be greater than 0.")
+	fs.StringVar(&s.AuditLogFile, "audit-log-file", s.AuditLogFile,
+		"File (e.g. '/var/log/kube-router/audit.log') the network policy and routing controllers record the chains, rules, ipsets and BGP routes they change to, as JSON lines. "+
+			"Set to empty string, the default, to disable.")
+	fs.IntVar(&s.AuditLogMaxSize, "audit-log-max-size", s.AuditLogMaxSize,
+		"Size in megabytes of the audit log past which it is rotated.")
+	fs.IntVar(&s.AuditLogMaxFiles, "audit-log-max-files", s.AuditLogMaxFiles,
+		"Number of rotated audit logs kept, the oldest are removed.")
+	fs.BoolVar(&s.AuditLogCompress, "audit-log-compress", s.AuditLogCompress,
+		"Compress the rotated audit logs with gzip.")
+	fs.StringVar(&s.AppliedStateDir, "applied-state-dir", s.AppliedStateDir,
+		"Directory where the controllers persist the state they last applied, used on startup to detect drift of the node. "+
+			"Set to empty string to disable.")
 	fs.BoolVar(&s.EnablePprof, "enable-pprof", false,
 		"Enables pprof for debugging performance and memory leak issues.")
 	fs.Uint16Var(&s.MetricsPort, "metrics-port", 0, "Prometheus metrics port, (Default 0, Disabled)")
 	fs.StringVar(&s.MetricsPath, "metrics-path", "/metrics", "Prometheus metrics path")
+	fs.BoolVar(&s.MetricsTenantLabels, "metrics-tenant-labels", false,
+		"Label the network policy dataplane metrics with the namespace and network policy of the traffic.")
+	fs.StringSliceVar(&s.MetricsTenantNamespaces, "metrics-tenant-namespaces", s.MetricsTenantNamespaces,
+		"Namespaces whose traffic gets its own tenant labels, the traffic of other namespaces is labeled \"other\". All namespaces when empty.")
+	fs.IntVar(&s.MetricsTenantMaxSeries, "metrics-tenant-max-series", s.MetricsTenantMaxSeries,
+		"Maximum number of namespace and network policy label pairs, traffic of further pairs is labeled \"other\". 0 for no limit.")
 	// fs.StringVar(&s.FullMeshPassword, "nodes-full-mesh-password", s.FullMeshPassword,
 	// 	"Password that cluster-node BGP servers will use to authenticate one another when \"--nodes-full-mesh\" is set.")
 	fs.StringVarP(&s.VLevel, "v", "v", "0", "log level for V logs")
 	fs.Uint16Var(&s.HealthPort, "health-port", 20244, "Health check port, 0 = Disabled")
+	fs.StringVar(&s.LivenessFile, "liveness-file", s.LivenessFile,
+		"File whose modification time is updated every few seconds while kube-router is healthy, for the exec liveness probes of containers. Disabled when empty.")
 	fs.BoolVar(&s.OverrideNextHop, "override-nexthop", false, "Override the next-hop in bgp routes sent to peers with the local ip.")
 	fs.BoolVar(&s.DisableSrcDstCheck, "disable-source-dest-check", true,
 		"Disable the source-dest-check attribute for AWS EC2 instances. When this option is false, it must be set some other way.")
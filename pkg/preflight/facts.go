@@ -0,0 +1,76 @@
+package preflight
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// FactUnknown is the value of the facts that could not be found out
+const FactUnknown = "unknown"
+
+// runCommand runs the utility and returns its output, overridden by the tests
+var runCommand = func(name string, args ...string) (string, error) {
+	out, err := utils.Exec(name, args...)
+	return string(out), err
+}
+
+// Facts returns the facts of the kernel and of the utilities of the node the features of kube-router depend on, by
+// name: the kernel release, whether IPVS and IPv6 are available, the versions of ipset and iptables, and the backend of
+// iptables, legacy or nf_tables
+func Facts() map[string]string {
+	facts := map[string]string{
+		"kernel_release":   FactUnknown,
+		"ipvs":             strconv.FormatBool(moduleAvailable("ip_vs")),
+		"ipv6":             strconv.FormatBool(ipv6Enabled()),
+		"ipset_version":    FactUnknown,
+		"iptables_version": FactUnknown,
+		"iptables_backend": FactUnknown,
+	}
+	if release, err := ioutil.ReadFile(filepath.Join(procSysPath, "kernel/osrelease")); err == nil {
+		facts["kernel_release"] = strings.TrimSpace(string(release))
+	}
+	if out, err := runCommand("ipset", "--version"); err == nil {
+		if version, ok := parseIPSetVersion(out); ok {
+			facts["ipset_version"] = version
+		}
+	}
+	if out, err := runCommand("iptables", "--version"); err == nil {
+		if version, backend, ok := parseIPTablesVersion(out); ok {
+			facts["iptables_version"], facts["iptables_backend"] = version, backend
+		}
+	}
+	return facts
+}
+
+// ipv6Enabled tells whether the kernel has IPv6 and it is not disabled
+func ipv6Enabled() bool {
+	value, err := ioutil.ReadFile(filepath.Join(procSysPath, "net/ipv6/conf/all/disable_ipv6"))
+	return err == nil && strings.TrimSpace(string(value)) == "0"
+}
+
+// parseIPSetVersion parses the output of ipset --version, e.g. "ipset v7.1, protocol version: 7"
+func parseIPSetVersion(out string) (string, bool) {
+	fields := strings.Fields(out)
+	if len(fields) < 2 || fields[0] != "ipset" || !strings.HasPrefix(fields[1], "v") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(fields[1], "v"), ","), true
+}
+
+// parseIPTablesVersion parses the output of iptables --version, e.g. "iptables v1.8.4 (nf_tables)". The versions
+// before 1.8 only have the legacy backend and do not report it.
+func parseIPTablesVersion(out string) (string, string, bool) {
+	fields := strings.Fields(out)
+	if len(fields) < 2 || fields[0] != "iptables" || !strings.HasPrefix(fields[1], "v") {
+		return "", "", false
+	}
+	backend := "legacy"
+	if len(fields) > 2 {
+		backend = strings.Trim(fields[2], "()")
+	}
+	return strings.TrimPrefix(fields[1], "v"), backend, true
+}
@@ -0,0 +1,214 @@
+package preflight
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/golang/glog"
+
+	v1core "k8s.io/api/core/v1"
+)
+
+const (
+	checkModule = "module"
+	checkSysctl = "sysctl"
+
+	eventReason = "PreflightCheckFailed"
+)
+
+// paths read by the checks, overridden by the tests
+var (
+	procModulesPath = "/proc/modules"
+	sysModulePath   = "/sys/module"
+	procSysPath     = "/proc/sys"
+	// modules built into the kernel are listed in modules.builtin of the kernel release
+	modulesBuiltinPath = func() string {
+		release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+		if err != nil {
+			return ""
+		}
+		return filepath.Join("/lib/modules", strings.TrimSpace(string(release)), "modules.builtin")
+	}
+	loadModule = func(module string) error {
+		_, err := utils.Exec("modprobe", module)
+		return err
+	}
+)
+
+// Check is a kernel module or sysctl a controller depends on
+type Check struct {
+	Kind string
+	// Name of the kernel module, or path of the sysctl under /proc/sys
+	Name string
+	// Value expected for a sysctl
+	Value string
+	// Controllers depending on the check
+	Controllers []string
+}
+
+// Result is the outcome of a Check
+type Result struct {
+	Check
+	Passed  bool
+	Message string
+}
+
+func (r Result) String() string {
+	status := "passed"
+	if !r.Passed {
+		status = "failed"
+	}
+	return fmt.Sprintf("%s %s %s (%s): %s", r.Kind, r.Name, status, strings.Join(r.Controllers, ", "), r.Message)
+}
+
+// RequiredChecks returns the checks of the controllers enabled by the configuration
+func RequiredChecks(config *options.KubeRouterConfig) []Check {
+	checks := make([]Check, 0)
+	add := func(kind, name, value, controller string) {
+		for i := range checks {
+			if checks[i].Kind == kind && checks[i].Name == name {
+				checks[i].Controllers = append(checks[i].Controllers, controller)
+				return
+			}
+		}
+		checks = append(checks, Check{Kind: kind, Name: name, Value: value, Controllers: []string{controller}})
+	}
+
+	if config.RunFirewall {
+		add(checkModule, "ip_set", "", "firewall")
+		add(checkModule, "xt_set", "", "firewall")
+		add(checkModule, "nf_conntrack", "", "firewall")
+		// the physdev and addrtype matches are loaded by iptables on first use, their support is probed by the
+		// firewall on startup
+		if !config.PodsRoutedMode {
+			// traffic between pods on the same bridge must go through iptables to be filtered
+			add(checkSysctl, "net/bridge/bridge-nf-call-iptables", "1", "firewall")
+		}
+	}
+	if config.RunRouter {
+		add(checkModule, "ip_set", "", "router")
+		if config.EnableOverlay {
+			add(checkModule, "ipip", "", "router")
+		}
+	}
+	if config.RunServiceProxy {
+		add(checkModule, "ip_set", "", "service-proxy")
+		add(checkModule, "ip_vs", "", "service-proxy")
+		add(checkModule, "nf_conntrack", "", "service-proxy")
+	}
+	return checks
+}
+
+// Run runs the checks, loading the missing kernel modules first when loadModules is set
+func Run(checks []Check, loadModules bool) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		var result Result
+		switch check.Kind {
+		case checkModule:
+			result = checkKernelModule(check, loadModules)
+		case checkSysctl:
+			result = checkSysctlValue(check)
+		default:
+			result = Result{Check: check, Message: "unknown check"}
+		}
+		if result.Passed {
+			glog.V(1).Infof("Preflight check %s", result)
+		} else {
+			glog.Warningf("Preflight check %s", result)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Failed returns the results of the failed checks
+func Failed(results []Result) []Result {
+	failed := make([]Result, 0)
+	for _, result := range results {
+		if !result.Passed {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func checkKernelModule(check Check, loadModules bool) Result {
+	if moduleAvailable(check.Name) {
+		return Result{Check: check, Passed: true, Message: "available"}
+	}
+	if !loadModules {
+		return Result{Check: check, Message: "kernel module is not loaded"}
+	}
+	if err := loadModule(check.Name); err != nil {
+		return Result{Check: check, Message: "failed to load kernel module: " + err.Error()}
+	}
+	return Result{Check: check, Passed: true, Message: "loaded"}
+}
+
+// moduleAvailable tells whether the kernel module is loaded or built into the kernel
+func moduleAvailable(module string) bool {
+	if _, err := os.Stat(filepath.Join(sysModulePath, module)); err == nil {
+		return true
+	}
+	if fileHasLine(procModulesPath, func(line string) bool {
+		return strings.HasPrefix(line, module+" ")
+	}) {
+		return true
+	}
+	return fileHasLine(modulesBuiltinPath(), func(line string) bool {
+		return strings.HasSuffix(line, "/"+module+".ko")
+	})
+}
+
+func fileHasLine(path string, match func(line string) bool) bool {
+	if path == "" {
+		return false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if match(scanner.Text()) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkSysctlValue(check Check) Result {
+	value, err := ioutil.ReadFile(filepath.Join(procSysPath, check.Name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Result{Check: check, Message: "sysctl not found, the kernel module providing it may not be loaded"}
+		}
+		return Result{Check: check, Message: "failed to read sysctl: " + err.Error()}
+	}
+	if actual := strings.TrimSpace(string(value)); actual != check.Value {
+		return Result{Check: check, Message: fmt.Sprintf("sysctl is %s, expected %s", actual, check.Value)}
+	}
+	return Result{Check: check, Passed: true, Message: "set to " + check.Value}
+}
+
+// RecordEvents records a warning event on the node listing the failed checks
+func RecordEvents(events *utils.EventSink, results []Result) {
+	failed := Failed(results)
+	if len(failed) == 0 {
+		return
+	}
+	// a single event, as the events of the same reason are aggregated by the sink
+	messages := make([]string, 0, len(failed))
+	for _, result := range failed {
+		messages = append(messages, result.String())
+	}
+	events.RecordNodeEvent(v1core.EventTypeWarning, eventReason, "kube-router "+strings.Join(messages, "; "))
+}
@@ -0,0 +1,162 @@
+package preflight
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+)
+
+func TestRequiredChecks(t *testing.T) {
+	config := options.NewKubeRouterConfig()
+	config.RunFirewall = true
+	config.RunServiceProxy = true
+	checks := RequiredChecks(config)
+
+	names := make(map[string][]string)
+	for _, check := range checks {
+		names[check.Name] = check.Controllers
+	}
+	for _, name := range []string{"ip_set", "xt_set", "nf_conntrack", "ip_vs", "net/bridge/bridge-nf-call-iptables"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("expected check %s for the firewall and service proxy, got %v", name, names)
+		}
+	}
+	if _, ok := names["ipip"]; ok {
+		t.Errorf("expected no ipip check without the router")
+	}
+	if len(names["ip_set"]) != 2 {
+		t.Errorf("expected ip_set to be required by both controllers, got %v", names["ip_set"])
+	}
+
+	config.PodsRoutedMode = true
+	for _, check := range RequiredChecks(config) {
+		if check.Name == "net/bridge/bridge-nf-call-iptables" {
+			t.Errorf("expected no bridge netfilter check with routed pods")
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	procModulesPath = filepath.Join(dir, "modules")
+	sysModulePath = filepath.Join(dir, "sys")
+	procSysPath = filepath.Join(dir, "proc")
+	builtin := filepath.Join(dir, "modules.builtin")
+	modulesBuiltinPath = func() string { return builtin }
+	writeFile(procModulesPath, "ip_set 40960 1 xt_set, Live 0x0000000000000000\n")
+	writeFile(filepath.Join(sysModulePath, "xt_set", "refcnt"), "0\n")
+	writeFile(builtin, "kernel/net/netfilter/nf_conntrack.ko\n")
+	writeFile(filepath.Join(procSysPath, "net/bridge/bridge-nf-call-iptables"), "0\n")
+
+	loaded := make([]string, 0)
+	loadModule = func(module string) error {
+		loaded = append(loaded, module)
+		if module == "ipip" {
+			return errors.New("module not found")
+		}
+		return nil
+	}
+
+	checks := []Check{
+		{Kind: checkModule, Name: "ip_set"},
+		{Kind: checkModule, Name: "xt_set"},
+		{Kind: checkModule, Name: "nf_conntrack"},
+		{Kind: checkModule, Name: "ip_vs"},
+		{Kind: checkModule, Name: "ipip"},
+		{Kind: checkSysctl, Name: "net/bridge/bridge-nf-call-iptables", Value: "1"},
+	}
+
+	failed := Failed(Run(checks, false))
+	if len(failed) != 3 || failed[0].Name != "ip_vs" || failed[1].Name != "ipip" ||
+		failed[2].Name != "net/bridge/bridge-nf-call-iptables" {
+		t.Errorf("expected ip_vs, ipip and the sysctl to fail, got %v", failed)
+	}
+	if len(loaded) != 0 {
+		t.Errorf("expected no module to be loaded, got %v", loaded)
+	}
+
+	failed = Failed(Run(checks, true))
+	if len(failed) != 2 || failed[0].Name != "ipip" {
+		t.Errorf("expected ipip failing to load and the sysctl to fail, got %v", failed)
+	}
+	if len(loaded) != 2 || loaded[0] != "ip_vs" || loaded[1] != "ipip" {
+		t.Errorf("expected the missing modules to be loaded, got %v", loaded)
+	}
+}
+
+func TestFacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	procModulesPath = filepath.Join(dir, "modules")
+	sysModulePath = filepath.Join(dir, "sys")
+	procSysPath = filepath.Join(dir, "proc")
+	modulesBuiltinPath = func() string { return "" }
+	if err := os.MkdirAll(filepath.Join(procSysPath, "kernel"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(procSysPath, "kernel/osrelease"), []byte("5.4.0-42-generic\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := ioutil.WriteFile(procModulesPath, []byte("ip_vs 155648 6 ip_vs_rr, Live 0x0000000000000000\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	outputs := map[string]string{
+		"ipset":    "ipset v7.1, protocol version: 7\n",
+		"iptables": "iptables v1.8.4 (nf_tables)\n",
+	}
+	runCommand = func(name string, args ...string) (string, error) {
+		out, ok := outputs[name]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return out, nil
+	}
+
+	expected := map[string]string{
+		"kernel_release":   "5.4.0-42-generic",
+		"ipvs":             "true",
+		"ipv6":             "false",
+		"ipset_version":    "7.1",
+		"iptables_version": "1.8.4",
+		"iptables_backend": "nf_tables",
+	}
+	facts := Facts()
+	for name, value := range expected {
+		if facts[name] != value {
+			t.Errorf("expected fact %s to be %q, got %q", name, value, facts[name])
+		}
+	}
+
+	// iptables before 1.8 only has the legacy backend
+	outputs["iptables"] = "iptables v1.6.1\n"
+	delete(outputs, "ipset")
+	facts = Facts()
+	if facts["iptables_backend"] != "legacy" || facts["iptables_version"] != "1.6.1" {
+		t.Errorf("expected the legacy backend of iptables 1.6.1, got %q %q", facts["iptables_backend"],
+			facts["iptables_version"])
+	}
+	if facts["ipset_version"] != FactUnknown {
+		t.Errorf("expected an unknown ipset version, got %q", facts["ipset_version"])
+	}
+}
@@ -0,0 +1,235 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/proxy"
+	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Cluster is a fake API server holding the node the test process plays, the controllers run against it
+type Cluster struct {
+	Client   kubernetes.Interface
+	Config   *options.KubeRouterConfig
+	NodeName string
+	NodeIP   net.IP
+
+	informerFactory informers.SharedInformerFactory
+	stopCh          chan struct{}
+	wg              sync.WaitGroup
+}
+
+// NewCluster returns a fake API server with the node, and the configuration the controllers are run with. The
+// pods are routed by the node.
+func NewCluster(nodeName string, nodeIP net.IP) (*Cluster, error) {
+	node := &v1core.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Status: v1core.NodeStatus{Addresses: []v1core.NodeAddress{
+			{Type: v1core.NodeInternalIP, Address: nodeIP.String()},
+		}},
+	}
+	config := options.NewKubeRouterConfig()
+	config.HostnameOverride = nodeName
+	config.PodsRoutedMode = true
+	config.IPTablesSyncPeriod = time.Hour
+	dir, err := ioutil.TempDir("", "kube-router-e2e")
+	if err != nil {
+		return nil, err
+	}
+	config.AppliedStateDir = dir
+	client := fake.NewSimpleClientset(node)
+	return &Cluster{
+		Client:          client,
+		Config:          config,
+		NodeName:        nodeName,
+		NodeIP:          nodeIP,
+		informerFactory: informers.NewSharedInformerFactory(client, 0),
+		stopCh:          make(chan struct{}),
+	}, nil
+}
+
+// AddPod adds a running pod of the node with the IP of the fixture to the API server
+func (c *Cluster) AddPod(namespace string, pod *Pod, labels map[string]string) error {
+	_, err := c.Client.CoreV1().Pods(namespace).Create(context.Background(), &v1core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: namespace, Labels: labels},
+		Spec:       v1core.PodSpec{NodeName: c.NodeName},
+		Status: v1core.PodStatus{
+			Phase:  v1core.PodRunning,
+			PodIP:  pod.IP.String(),
+			HostIP: c.NodeIP.String(),
+		},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// AddNamespace adds the namespace to the API server
+func (c *Cluster) AddNamespace(name string, labels map[string]string) error {
+	_, err := c.Client.CoreV1().Namespaces().Create(context.Background(), &v1core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// AddNetworkPolicy adds the network policy to the API server
+func (c *Cluster) AddNetworkPolicy(policy *networking.NetworkPolicy) error {
+	_, err := c.Client.NetworkingV1().NetworkPolicies(policy.Namespace).Create(context.Background(), policy, metav1.CreateOptions{})
+	return err
+}
+
+// AddService adds the service to the API server, along with its endpoints on the pods of the node. The endpoints
+// listen on the target port of each port of the service.
+func (c *Cluster) AddService(service *v1core.Service, pods ...*Pod) error {
+	if _, err := c.Client.CoreV1().Services(service.Namespace).Create(context.Background(), service, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	subset := v1core.EndpointSubset{}
+	for _, pod := range pods {
+		subset.Addresses = append(subset.Addresses, v1core.EndpointAddress{IP: pod.IP.String(), NodeName: &c.NodeName})
+	}
+	for _, port := range service.Spec.Ports {
+		subset.Ports = append(subset.Ports, v1core.EndpointPort{
+			Name:     port.Name,
+			Port:     port.TargetPort.IntVal,
+			Protocol: port.Protocol,
+		})
+	}
+	_, err := c.Client.CoreV1().Endpoints(service.Namespace).Create(context.Background(), &v1core.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: service.Name, Namespace: service.Namespace},
+		Subsets:    []v1core.EndpointSubset{subset},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// NewFirewall returns a network policy controller reading the objects of the cluster, once its informer caches
+// synced. Its event handlers are only registered when events is set.
+func (c *Cluster) NewFirewall(events bool) (*netpol.NetworkPolicyController, error) {
+	podInformer := c.informerFactory.Core().V1().Pods().Informer()
+	nsInformer := c.informerFactory.Core().V1().Namespaces().Informer()
+	npInformer := c.informerFactory.Networking().V1().NetworkPolicies().Informer()
+	npc, err := netpol.NewNetworkPolicyController(c.Client, c.Config, podInformer, npInformer, nsInformer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network policy controller: %s", err)
+	}
+	if events {
+		podInformer.AddEventHandler(npc.PodEventHandler)
+		nsInformer.AddEventHandler(npc.NamespaceEventHandler)
+		npInformer.AddEventHandler(npc.NetworkPolicyEventHandler)
+	}
+	c.informerFactory.Start(c.stopCh)
+	if !cache.WaitForCacheSync(c.stopCh, podInformer.HasSynced, nsInformer.HasSynced, npInformer.HasSynced) {
+		return nil, fmt.Errorf("failed to sync the informer caches")
+	}
+	return npc, nil
+}
+
+// RunFirewall runs the network policy controller until Stop is called, and returns it once its initial sync is
+// done
+func (c *Cluster) RunFirewall() (*netpol.NetworkPolicyController, error) {
+	npc, err := c.NewFirewall(true)
+	if err != nil {
+		return nil, err
+	}
+
+	healthChan := make(chan *healthcheck.ControllerHeartbeat, 10)
+	synced := make(chan struct{})
+	go func() {
+		// the first heartbeat follows the initial sync, the others are discarded
+		<-healthChan
+		close(synced)
+		for {
+			select {
+			case <-healthChan:
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+	c.wg.Add(1)
+	go npc.Run(healthChan, c.stopCh, &c.wg)
+	select {
+	case <-synced:
+	case <-time.After(time.Minute):
+		return nil, fmt.Errorf("network policy controller did not sync within a minute")
+	}
+	return npc, nil
+}
+
+// RunServiceProxy runs the network services controller until Stop is called. It returns once its informer caches
+// synced, the IPVS services are set up by its initial sync right after.
+func (c *Cluster) RunServiceProxy() (*proxy.NetworkServicesController, error) {
+	svcInformer := c.informerFactory.Core().V1().Services().Informer()
+	epInformer := c.informerFactory.Core().V1().Endpoints().Informer()
+	podInformer := c.informerFactory.Core().V1().Pods().Informer()
+	nsc, err := proxy.NewNetworkServicesController(c.Client, c.Config, svcInformer, epInformer, podInformer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network services controller: %s", err)
+	}
+	svcInformer.AddEventHandler(nsc.ServiceEventHandler)
+	epInformer.AddEventHandler(nsc.EndpointsEventHandler)
+	c.informerFactory.Start(c.stopCh)
+	if !cache.WaitForCacheSync(c.stopCh, svcInformer.HasSynced, epInformer.HasSynced, podInformer.HasSynced) {
+		return nil, fmt.Errorf("failed to sync the informer caches")
+	}
+
+	// the proxy only sends heartbeats once it handles events, none is waited for
+	healthChan := make(chan *healthcheck.ControllerHeartbeat, 10)
+	go func() {
+		for {
+			select {
+			case <-healthChan:
+			case <-c.stopCh:
+				return
+			}
+		}
+	}()
+	c.wg.Add(1)
+	go nsc.Run(healthChan, c.stopCh, &c.wg)
+	return nsc, nil
+}
+
+// Stop stops the controllers and removes the state they store
+func (c *Cluster) Stop() {
+	close(c.stopCh)
+	c.wg.Wait()
+	os.RemoveAll(c.Config.AppliedStateDir)
+}
+
+// RulePackets returns the number of packets matched by the rules of the table whose comment contains comment
+func RulePackets(table, comment string) (int, error) {
+	out, err := utils.Exec("iptables-save", "-t", table, "-c")
+	if err != nil {
+		return 0, err
+	}
+	tables, err := utils.ParseIPTablesSave(bytes.NewReader(out))
+	if err != nil {
+		return 0, err
+	}
+	packets := 0
+	for _, t := range tables {
+		for _, rule := range t.Rules {
+			if strings.Contains(rule.Comment, comment) {
+				packets += int(rule.Packets)
+			}
+		}
+	}
+	return packets, nil
+}
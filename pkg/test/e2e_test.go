@@ -0,0 +1,318 @@
+//go:build e2e
+// +build e2e
+
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+
+	v1core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	testNamespace = "default"
+	serverPort    = 8080
+)
+
+var nodeIP = net.ParseIP("10.0.0.1")
+
+func TestMain(m *testing.M) {
+	for _, command := range []string{"ip", "iptables", "ipset"} {
+		if _, err := exec.LookPath(command); err != nil || os.Geteuid() != 0 {
+			// the tests need root and the utilities the controllers run
+			fmt.Println("skipping the end-to-end tests, they must run as root with ip, iptables and ipset installed")
+			os.Exit(0)
+		}
+	}
+	if err := setupNode(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// setupNode gives the node its IP and makes it route the traffic of the pods
+func setupNode() error {
+	for _, args := range [][]string{
+		{"link", "add", "e2e0", "type", "dummy"},
+		{"addr", "add", nodeIP.String() + "/32", "dev", "e2e0"},
+		{"link", "set", "e2e0", "up"},
+	} {
+		if _, err := utils.Exec("ip", args...); err != nil {
+			return err
+		}
+	}
+	if err := utils.SetSysctl("net/ipv4/ip_forward", 1); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newPods returns a client and a server pod, the server isolated by a policy only permitting the client to connect
+func newPods(t *testing.T, cluster *Cluster) (client, server *Pod) {
+	client, err := NewPod("client", net.ParseIP("10.1.0.2"), "vethclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = NewPod("server", net.ParseIP("10.1.0.3"), "vethserver")
+	if err != nil {
+		client.Delete()
+		t.Fatal(err)
+	}
+	for _, pod := range []struct {
+		pod  *Pod
+		role string
+	}{{client, "client"}, {server, "server"}} {
+		if err := cluster.AddPod(testNamespace, pod.pod, map[string]string{"role": pod.role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cluster.AddNamespace(testNamespace, nil); err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func isolateServer(t *testing.T, cluster *Cluster, allowedRole string) {
+	err := cluster.AddNetworkPolicy(&networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: testNamespace},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "server"}},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress},
+			Ingress: []networking.NetworkPolicyIngressRule{{
+				From: []networking.NetworkPolicyPeer{{
+					PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": allowedRole}},
+				}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newCluster(t *testing.T) *Cluster {
+	cluster, err := NewCluster("node", nodeIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cluster
+}
+
+func TestStatefulReturnTraffic(t *testing.T) {
+	cluster := newCluster(t)
+	defer cluster.Stop()
+	client, server := newPods(t, cluster)
+	defer client.Delete()
+	defer server.Delete()
+	// nothing is permitted to connect to the server, its own connections must still get their replies
+	isolateServer(t, cluster, "nobody")
+	if _, err := cluster.RunFirewall(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := client.ListenTCP(serverPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if answer, err := server.ConnectTCP(client.IP, serverPort); err != nil || answer != "client" {
+		t.Errorf("expected the reply to the connection of the isolated pod to be delivered, got %q: %v", answer, err)
+	}
+	if err := client.Ping(server.IP); err == nil {
+		t.Errorf("expected the isolated pod not to be reachable")
+	}
+}
+
+func TestDropLog(t *testing.T) {
+	cluster := newCluster(t)
+	defer cluster.Stop()
+	client, server := newPods(t, cluster)
+	defer client.Delete()
+	defer server.Delete()
+	isolateServer(t, cluster, "nobody")
+	if _, err := cluster.RunFirewall(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := server.ListenTCP(serverPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if _, err := client.ConnectTCP(server.IP, serverPort); err == nil {
+		t.Fatalf("expected the connection to the isolated pod to be rejected")
+	}
+	err = Eventually(5*time.Second, func() error {
+		packets, err := RulePackets("filter", "rule to log dropped traffic POD name:server")
+		if err != nil {
+			return err
+		}
+		if packets == 0 {
+			return errors.New("no packet logged yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected the rejected connection to be logged: %s", err)
+	}
+}
+
+func TestPermittedTraffic(t *testing.T) {
+	cluster := newCluster(t)
+	defer cluster.Stop()
+	client, server := newPods(t, cluster)
+	defer client.Delete()
+	defer server.Delete()
+	isolateServer(t, cluster, "client")
+	if _, err := cluster.RunFirewall(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := server.ListenTCP(serverPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if answer, err := client.ConnectTCP(server.IP, serverPort); err != nil || answer != "server" {
+		t.Errorf("expected the permitted connection to be delivered, got %q: %v", answer, err)
+	}
+}
+
+// newService returns a node port service of the server pods, with the external traffic policy
+func newService(name, clusterIP string, nodePort int32, policy v1core.ServiceExternalTrafficPolicyType) *v1core.Service {
+	return &v1core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec: v1core.ServiceSpec{
+			Type:      v1core.ServiceTypeNodePort,
+			ClusterIP: clusterIP,
+			Ports: []v1core.ServicePort{{
+				Name:       "http",
+				Protocol:   v1core.ProtocolTCP,
+				Port:       80,
+				TargetPort: intstr.FromInt(serverPort),
+				NodePort:   nodePort,
+			}},
+			Selector:              map[string]string{"role": "server"},
+			ExternalTrafficPolicy: policy,
+		},
+	}
+}
+
+// TestServiceTrafficPolicy checks the service traffic DNATed by IPVS to the server is evaluated against the ingress
+// policy of the server, with the source of the client, for the cluster IP and the node port of the services of both
+// external traffic policies. The endpoint runs on the node, the traffic sent to the endpoints of other nodes is
+// masqueraded by the node, see docs/how-it-works.md.
+func TestServiceTrafficPolicy(t *testing.T) {
+	if _, err := os.Stat("/proc/net/ip_vs"); err != nil {
+		t.Skip("the service proxy needs the ip_vs kernel module loaded")
+	}
+	cluster := newCluster(t)
+	defer cluster.Stop()
+	client, server := newPods(t, cluster)
+	defer client.Delete()
+	defer server.Delete()
+	other, err := NewPod("other", net.ParseIP("10.1.0.4"), "vethother")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Delete()
+	if err := cluster.AddPod(testNamespace, other, map[string]string{"role": "other"}); err != nil {
+		t.Fatal(err)
+	}
+	// the external clients are not pods, they are routed by the node all the same
+	external, err := NewPod("external", net.ParseIP("192.168.100.2"), "vethexternal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer external.Delete()
+	stranger, err := NewPod("stranger", net.ParseIP("192.168.100.3"), "vethstranger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Delete()
+
+	err = cluster.AddNetworkPolicy(&networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: testNamespace},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"role": "server"}},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress},
+			Ingress: []networking.NetworkPolicyIngressRule{{
+				From: []networking.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "client"}}},
+					{IPBlock: &networking.IPBlock{CIDR: external.IP.String() + "/32"}},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := []*v1core.Service{
+		newService("cluster", "10.96.0.10", 30080, v1core.ServiceExternalTrafficPolicyTypeCluster),
+		newService("local", "10.96.0.11", 30081, v1core.ServiceExternalTrafficPolicyTypeLocal),
+	}
+	for _, service := range services {
+		if err := cluster.AddService(service, server); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cluster.RunServiceProxy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cluster.RunFirewall(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := server.ListenTCP(serverPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	for _, service := range services {
+		clusterIP := net.ParseIP(service.Spec.ClusterIP)
+		nodePort := int(service.Spec.Ports[0].NodePort)
+		for _, tc := range []struct {
+			name      string
+			from      *Pod
+			ip        net.IP
+			port      int
+			permitted bool
+		}{
+			{"cluster IP from a permitted pod", client, clusterIP, 80, true},
+			{"cluster IP from another pod", other, clusterIP, 80, false},
+			{"node port from a permitted client", external, nodeIP, nodePort, true},
+			{"node port from another client", stranger, nodeIP, nodePort, false},
+		} {
+			t.Run(service.Name+" policy "+tc.name, func(t *testing.T) {
+				if !tc.permitted {
+					if answer, err := tc.from.ConnectTCP(tc.ip, tc.port); err == nil {
+						t.Errorf("expected the connection to be rejected by the policy of the server, got %q", answer)
+					}
+					return
+				}
+				// the IPVS services are set up by the initial sync of the proxy, which may still be running
+				err := Eventually(10*time.Second, func() error {
+					answer, err := tc.from.ConnectTCP(tc.ip, tc.port)
+					if err == nil && answer != "server" {
+						return fmt.Errorf("answered by %q", answer)
+					}
+					return err
+				})
+				if err != nil {
+					t.Errorf("expected the permitted connection to be delivered to the server: %s", err)
+				}
+			})
+		}
+	}
+}
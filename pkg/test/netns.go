@@ -0,0 +1,78 @@
+// Package test provides the fixtures of the end-to-end tests of the dataplane: network namespaces attached to the
+// node as pods, a fake API server the real controllers run against, and probes asserting the delivery of packets.
+//
+// The controllers program the network namespace of the test process, run the tests in a throwaway one, e.g.
+// unshare -n go test -tags e2e ./pkg/test/
+package test
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netns"
+)
+
+// Namespace is a named network namespace
+type Namespace struct {
+	Name string
+}
+
+// NewNamespace creates the named network namespace, with its loopback interface up
+func NewNamespace(name string) (*Namespace, error) {
+	if _, err := utils.Exec("ip", "netns", "add", name); err != nil {
+		return nil, fmt.Errorf("failed to create network namespace %s: %s", name, err)
+	}
+	ns := &Namespace{Name: name}
+	if err := ns.Exec("ip", "link", "set", "lo", "up"); err != nil {
+		ns.Delete()
+		return nil, err
+	}
+	return ns, nil
+}
+
+// Exec runs the command in the network namespace
+func (ns *Namespace) Exec(name string, args ...string) error {
+	args = append([]string{"netns", "exec", ns.Name, name}, args...)
+	if _, err := utils.Exec("ip", args...); err != nil {
+		return fmt.Errorf("failed to run %s in network namespace %s: %s", name, ns.Name, err)
+	}
+	return nil
+}
+
+// Do runs fn with the calling goroutine in the network namespace. Sockets opened by fn stay in the namespace once
+// it returns.
+func (ns *Namespace) Do(fn func() error) error {
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to get the current network namespace: %s", err)
+	}
+	defer origin.Close()
+	target, err := netns.GetFromName(ns.Name)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to open network namespace %s: %s", ns.Name, err)
+	}
+	defer target.Close()
+	// the thread is only released once back in the original namespace, so other goroutines never run in the wrong
+	// one
+	defer func() {
+		if err := netns.Set(origin); err == nil {
+			runtime.UnlockOSThread()
+		}
+	}()
+	if err := netns.Set(target); err != nil {
+		return fmt.Errorf("failed to enter network namespace %s: %s", ns.Name, err)
+	}
+	return fn()
+}
+
+// Delete deletes the network namespace, and the interfaces moved to it
+func (ns *Namespace) Delete() error {
+	if _, err := utils.Exec("ip", "netns", "del", ns.Name); err != nil {
+		return fmt.Errorf("failed to delete network namespace %s: %s", ns.Name, err)
+	}
+	return nil
+}
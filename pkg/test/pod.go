@@ -0,0 +1,61 @@
+package test
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+)
+
+// gateway the pods route through, answered by proxy ARP on the host side of their veth pair
+const podGateway = "169.254.1.1"
+
+// Pod is a network namespace routed by the node through a veth pair, like with the ptp CNI plugin, as run by the
+// controllers with --pods-routed-mode
+type Pod struct {
+	*Namespace
+	IP net.IP
+	// host side interface of the veth pair
+	HostInterface string
+}
+
+// NewPod creates the network namespace of the pod and its veth pair, and routes ip to it. The interface of the pod is
+// eth0, the host side one hostInterface.
+func NewPod(name string, ip net.IP, hostInterface string) (*Pod, error) {
+	ns, err := NewNamespace(name)
+	if err != nil {
+		return nil, err
+	}
+	pod := &Pod{Namespace: ns, IP: ip, HostInterface: hostInterface}
+	if err := pod.attach(); err != nil {
+		ns.Delete()
+		return nil, err
+	}
+	return pod, nil
+}
+
+func (pod *Pod) attach() error {
+	for _, args := range [][]string{
+		{"link", "add", pod.HostInterface, "type", "veth", "peer", "name", "eth0", "netns", pod.Name},
+		{"link", "set", pod.HostInterface, "up"},
+		{"route", "add", pod.IP.String() + "/32", "dev", pod.HostInterface},
+	} {
+		if _, err := utils.Exec("ip", args...); err != nil {
+			return fmt.Errorf("failed to attach pod %s: %s", pod.Name, err)
+		}
+	}
+	if err := utils.SetSysctl("net/ipv4/conf/"+pod.HostInterface+"/proxy_arp", 1); err != nil {
+		return fmt.Errorf("failed to attach pod %s: %s", pod.Name, err)
+	}
+	for _, args := range [][]string{
+		{"addr", "add", pod.IP.String() + "/32", "dev", "eth0"},
+		{"link", "set", "eth0", "up"},
+		{"route", "add", podGateway, "dev", "eth0", "scope", "link"},
+		{"route", "add", "default", "via", podGateway, "dev", "eth0"},
+	} {
+		if err := pod.Exec("ip", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// time a probe waits for its answer
+var probeTimeout = 2 * time.Second
+
+// ListenTCP accepts connections on the port of the pod until the returned listener is closed, answering each with
+// the name of the pod
+func (pod *Pod) ListenTCP(port int) (net.Listener, error) {
+	var listener net.Listener
+	err := pod.Do(func() error {
+		var err error
+		listener, err = net.Listen("tcp", net.JoinHostPort(pod.IP.String(), strconv.Itoa(port)))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen in pod %s: %s", pod.Name, err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(pod.Name))
+			conn.Close()
+		}
+	}()
+	return listener, nil
+}
+
+// ConnectTCP connects from the pod to the port of ip, and returns the answer of the listener
+func (pod *Pod) ConnectTCP(ip net.IP, port int) (string, error) {
+	var answer string
+	err := pod.Do(func() error {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), probeTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(probeTimeout))
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		answer = string(buf[:n])
+		return nil
+	})
+	return answer, err
+}
+
+// Ping sends an ICMP echo request from the pod to ip through a raw socket, and waits for the reply
+func (pod *Pod) Ping(ip net.IP) error {
+	return pod.Do(func() error {
+		fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW, unix.IPPROTO_ICMP)
+		if err != nil {
+			return fmt.Errorf("failed to open raw socket: %s", err)
+		}
+		defer unix.Close(fd)
+		timeout := unix.NsecToTimeval(probeTimeout.Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+			return err
+		}
+		to := &unix.SockaddrInet4{}
+		copy(to.Addr[:], ip.To4())
+		id, seq := uint16(unix.Getpid()), uint16(time.Now().UnixNano())
+		if err := unix.Sendto(fd, icmpEcho(id, seq), 0, to); err != nil {
+			return fmt.Errorf("failed to send echo request: %s", err)
+		}
+		deadline := time.Now().Add(probeTimeout)
+		buf := make([]byte, 1500)
+		for time.Now().Before(deadline) {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				return fmt.Errorf("no echo reply from %s: %s", ip, err)
+			}
+			if isEchoReply(buf[:n], ip, id, seq) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no echo reply from %s", ip)
+	})
+}
+
+// icmpEcho returns an ICMP echo request
+func icmpEcho(id, seq uint16) []byte {
+	msg := make([]byte, 16)
+	msg[0] = 8 // echo request
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[8:], "kube-rtr")
+	binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+	return msg
+}
+
+// isEchoReply tells whether the packet received on a raw ICMP socket, IP header included, is the reply from ip to
+// the echo request
+func isEchoReply(packet []byte, ip net.IP, id, seq uint16) bool {
+	if len(packet) < 20 {
+		return false
+	}
+	headerLen := int(packet[0]&0x0f) * 4
+	if len(packet) < headerLen+8 || !net.IP(packet[12:16]).Equal(ip) {
+		return false
+	}
+	msg := packet[headerLen:]
+	return msg[0] == 0 && binary.BigEndian.Uint16(msg[4:]) == id && binary.BigEndian.Uint16(msg[6:]) == seq
+}
+
+func checksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(msg[i:]))
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// Eventually retries condition until it succeeds or timeout elapses, the controllers apply changes asynchronously
+func Eventually(timeout time.Duration, condition func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := condition()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("condition still not met after %s: %s", timeout, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	v1core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// pods of the synthetic load not on the node run on this one
+var remoteNodeIP = net.ParseIP("192.0.2.1")
+
+// first pod IP of the synthetic load
+var syntheticPodIPBase = binary.BigEndian.Uint32(net.ParseIP("10.0.0.1").To4())
+
+// SyntheticLoad describes the namespaces, pods and network policies synthesized to measure the controllers at scale.
+// Pods are spread over the namespaces and labeled with one app out of one for every ten pods. Each policy isolates
+// an app of its namespace, permitting ingress from the next app of the namespace and from the next namespace on a
+// port, and egress to the pod network.
+type SyntheticLoad struct {
+	Namespaces int
+	Pods       int
+	// pods running on the node, the first ones
+	LocalPods int
+	Policies  int
+}
+
+// Validate checks the load can be synthesized
+func (l SyntheticLoad) Validate() error {
+	if l.Namespaces < 1 || l.Pods < 0 || l.Policies < 0 {
+		return fmt.Errorf("the synthetic load needs at least a namespace and no negative number of pods or policies")
+	}
+	if l.LocalPods > l.Pods {
+		return fmt.Errorf("%d pods on the node out of %d pods", l.LocalPods, l.Pods)
+	}
+	if l.Pods >= 1<<24-1 {
+		return fmt.Errorf("%d pods do not fit in 10.0.0.0/8", l.Pods)
+	}
+	return nil
+}
+
+func (l SyntheticLoad) apps() int {
+	if apps := l.Pods / 10; apps > 1 {
+		return apps
+	}
+	return 1
+}
+
+func syntheticNamespace(i int) string {
+	return "ns-" + strconv.Itoa(i)
+}
+
+func syntheticApp(i int) map[string]string {
+	return map[string]string{"app": "app-" + strconv.Itoa(i)}
+}
+
+// SyntheticPodIP returns the IP of the i-th pod of the synthetic load
+func SyntheticPodIP(i int) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, syntheticPodIPBase+uint32(i))
+	return ip
+}
+
+// Create creates the objects of the load in the API server of the cluster
+func (l SyntheticLoad) Create(c *Cluster) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	for i := 0; i < l.Namespaces; i++ {
+		if err := c.AddNamespace(syntheticNamespace(i), map[string]string{"name": syntheticNamespace(i)}); err != nil {
+			return err
+		}
+	}
+	apps := l.apps()
+	for i := 0; i < l.Pods; i++ {
+		hostIP := remoteNodeIP
+		if i < l.LocalPods {
+			hostIP = c.NodeIP
+		}
+		_, err := c.Client.CoreV1().Pods(syntheticNamespace(i%l.Namespaces)).Create(context.Background(), &v1core.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "pod-" + strconv.Itoa(i),
+				Namespace: syntheticNamespace(i % l.Namespaces),
+				Labels:    syntheticApp((i / l.Namespaces) % apps),
+			},
+			Status: v1core.PodStatus{
+				Phase:  v1core.PodRunning,
+				PodIP:  SyntheticPodIP(i).String(),
+				HostIP: hostIP.String(),
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < l.Policies; i++ {
+		if err := c.AddNetworkPolicy(l.policy(i, apps)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l SyntheticLoad) policy(i, apps int) *networking.NetworkPolicy {
+	app := (i / l.Namespaces) % apps
+	protocol := v1core.ProtocolTCP
+	port := intstr.FromInt(8000 + i%100)
+	return &networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy-" + strconv.Itoa(i), Namespace: syntheticNamespace(i % l.Namespaces)},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: syntheticApp(app)},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress},
+			Ingress: []networking.NetworkPolicyIngressRule{{
+				Ports: []networking.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+				From: []networking.NetworkPolicyPeer{
+					{PodSelector: &metav1.LabelSelector{MatchLabels: syntheticApp((app + 1) % apps)}},
+					{NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"name": syntheticNamespace((i + 1) % l.Namespaces)},
+					}},
+				},
+			}},
+			Egress: []networking.NetworkPolicyEgressRule{{
+				To: []networking.NetworkPolicyPeer{{IPBlock: &networking.IPBlock{CIDR: "10.0.0.0/8"}}},
+			}},
+		},
+	}
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyntheticLoad(t *testing.T) {
+	c, err := NewCluster("node", net.ParseIP("192.0.2.254"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	load := SyntheticLoad{Namespaces: 3, Pods: 40, LocalPods: 5, Policies: 7}
+	if err := load.Create(c); err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces, err := c.Client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces.Items) != load.Namespaces {
+		t.Errorf("expected %d namespaces, got %d", load.Namespaces, len(namespaces.Items))
+	}
+	pods, err := c.Client.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != load.Pods {
+		t.Errorf("expected %d pods, got %d", load.Pods, len(pods.Items))
+	}
+	local := 0
+	for _, pod := range pods.Items {
+		if pod.Status.HostIP == c.NodeIP.String() {
+			local++
+		}
+	}
+	if local != load.LocalPods {
+		t.Errorf("expected %d pods on the node, got %d", load.LocalPods, local)
+	}
+	policies, err := c.Client.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies.Items) != load.Policies {
+		t.Errorf("expected %d network policies, got %d", load.Policies, len(policies.Items))
+	}
+	if ip := SyntheticPodIP(255).String(); ip != "10.0.1.0" {
+		t.Errorf("expected 10.0.1.0 as IP of pod 255, got %s", ip)
+	}
+}
+
+func TestSyntheticLoadValidate(t *testing.T) {
+	for _, load := range []SyntheticLoad{
+		{Namespaces: 0, Pods: 1},
+		{Namespaces: 1, Pods: 1, LocalPods: 2},
+		{Namespaces: 1, Pods: -1},
+		{Namespaces: 1, Pods: 1 << 24},
+	} {
+		if err := load.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", load)
+		}
+	}
+	if err := (SyntheticLoad{Namespaces: 1}).Validate(); err != nil {
+		t.Errorf("expected an empty load to be valid: %s", err)
+	}
+}
@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// actions of the audit records
+const (
+	AuditChainCreated    = "chain_created"
+	AuditChainDeleted    = "chain_deleted"
+	AuditRuleAdded       = "rule_added"
+	AuditRuleRemoved     = "rule_removed"
+	AuditIPSetCreated    = "ipset_created"
+	AuditIPSetRefreshed  = "ipset_refreshed"
+	AuditIPSetDestroyed  = "ipset_destroyed"
+	AuditRouteAdvertised = "route_advertised"
+	AuditRouteWithdrawn  = "route_withdrawn"
+)
+
+// layout of the time suffix of the rotated audit logs, sorting in the order they were rotated
+const auditLogRotatedLayout = "20060102-150405.000000"
+
+// AuditRecord is a JSON line of the audit log, a change a controller made to the dataplane of the node
+type AuditRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Node       string    `json:"node"`
+	Controller string    `json:"controller"`
+	Action     string    `json:"action"`
+	// Object is the chain, ipset or route prefix changed
+	Object  string                 `json:"object"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// AuditLog writes the changes the controllers make to the dataplane as JSON lines to a file. The file is rotated once
+// it grows past maxSize, the rotated files get the time of the rotation as suffix, are compressed with gzip unless
+// disabled, and the oldest are removed past maxFiles.
+type AuditLog struct {
+	path     string
+	node     string
+	maxSize  int64
+	maxFiles int
+	compress bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+
+	// compression and removal of the rotated files, one at a time
+	rotateMu sync.Mutex
+	rotated  sync.WaitGroup
+}
+
+// NewAuditLog returns new AuditLog appending to the file at path, nil if path is empty
+func NewAuditLog(path, node string, maxSize int64, maxFiles int, compress bool) (*AuditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if maxSize <= 0 {
+		return nil, errors.New("audit log max size must be greater than 0")
+	}
+	if maxFiles < 1 {
+		return nil, errors.New("audit log max files must be at least 1")
+	}
+	l := &AuditLog{path: path, node: node, maxSize: maxSize, maxFiles: maxFiles, compress: compress}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %s", err.Error())
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %s", err.Error())
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Record appends the change of the controller to the audit log. Safe to call on a nil AuditLog.
+func (l *AuditLog) Record(controller, action, object string, details map[string]interface{}) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(AuditRecord{Timestamp: time.Now(), Node: l.node, Controller: controller,
+		Action: action, Object: object, Details: details})
+	if err != nil {
+		glog.Errorf("Failed to marshal audit record: %s", err.Error())
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if l.file == nil {
+		// the previous rotation failed to open the file again
+		if err := l.open(); err != nil {
+			glog.Errorf("Failed to write audit record: %s", err.Error())
+			return
+		}
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			glog.Errorf("Failed to rotate audit log: %s", err.Error())
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		glog.Errorf("Failed to write audit record: %s", err.Error())
+	}
+}
+
+// rotate renames the file with the time as suffix and opens a new one, the rotated file is compressed, and the
+// oldest removed, in the background
+func (l *AuditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		glog.Errorf("Failed to close audit log: %s", err.Error())
+	}
+	l.file = nil
+	rotated := l.path + "." + time.Now().UTC().Format(auditLogRotatedLayout)
+	if err := os.Rename(l.path, rotated); err != nil {
+		// keep appending to the file rather than losing records
+		if openErr := l.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	l.rotated.Add(1)
+	go func() {
+		defer l.rotated.Done()
+		l.rotateMu.Lock()
+		defer l.rotateMu.Unlock()
+		if l.compress {
+			if err := compressFile(rotated); err != nil {
+				glog.Errorf("Failed to compress rotated audit log %s: %s", rotated, err.Error())
+			}
+		}
+		if err := l.removeOldest(); err != nil {
+			glog.Errorf("Failed to remove the oldest rotated audit logs: %s", err.Error())
+		}
+	}()
+	return nil
+}
+
+// removeOldest removes the oldest rotated files past maxFiles
+func (l *AuditLog) removeOldest() error {
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return err
+	}
+	rotated := make([]string, 0, len(matches))
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(match, l.path+"."), ".gz")
+		if _, err := time.Parse(auditLogRotatedLayout, suffix); err == nil {
+			rotated = append(rotated, match)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > l.maxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// compressFile replaces the file with its gzip compressed copy, suffixed with .gz
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close closes the file, once the rotated files are compressed. Safe to call on a nil AuditLog.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rotated.Wait()
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
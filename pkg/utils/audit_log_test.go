@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_AuditLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-log")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := NewAuditLog(path, "node", 1024, 2, true)
+	if err != nil {
+		t.Fatalf("failed to create audit log: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		l.Record("NPC", AuditRuleAdded, "KUBE-NWPLCY-7ZX6YNNXL3JRSQFN",
+			map[string]interface{}{"rule": "-p tcp --dport 80 -j ACCEPT"})
+		// the rotated files are named after the time of the rotation
+		l.rotated.Wait()
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close audit log: %v", err)
+	}
+	// must be safe to call once closed
+	l.Record("NPC", AuditRuleAdded, "KUBE-NWPLCY-7ZX6YNNXL3JRSQFN", nil)
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 2 {
+		t.Fatalf("expected the 2 newest rotated audit logs to be kept, got %v", rotated)
+	}
+	for _, name := range rotated {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("expected rotated audit log %s to be compressed", name)
+		}
+	}
+
+	f, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatalf("failed to open rotated audit log: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to decompress rotated audit log: %v", err)
+	}
+	scanner := bufio.NewScanner(gz)
+	lines := 0
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("failed to decode audit record %q: %v", scanner.Text(), err)
+		}
+		if record.Node != "node" || record.Controller != "NPC" || record.Action != AuditRuleAdded ||
+			record.Details["rule"] != "-p tcp --dport 80 -j ACCEPT" {
+			t.Errorf("unexpected audit record: %+v", record)
+		}
+		lines++
+	}
+	if lines == 0 {
+		t.Errorf("expected audit records in the rotated audit log")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > 1024 {
+		t.Errorf("expected the audit log to stay under its max size, got %v, %v", info, err)
+	}
+}
+
+func Test_NewAuditLog(t *testing.T) {
+	l, err := NewAuditLog("", "node", 1024, 1, false)
+	if l != nil || err != nil {
+		t.Errorf("expected no audit log without path")
+	}
+	// must be safe to call on a disabled audit log
+	l.Record("NPC", AuditChainCreated, "KUBE-POD-FW-4SDRLFXJXMQ2TJCI", nil)
+
+	if _, err = NewAuditLog("/tmp/audit.log", "node", 0, 1, false); err == nil {
+		t.Errorf("expected error for zero max size")
+	}
+}
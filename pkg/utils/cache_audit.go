@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+// kinds of differences found between an informer cache and the API server
+const (
+	cacheAuditMissingInAPI   = "missing_in_api"
+	cacheAuditMissingInCache = "missing_in_cache"
+	cacheAuditStale          = "stale"
+)
+
+var cacheAuditDescriptions = map[string]string{
+	cacheAuditMissingInAPI:   "deleted from the API server but still cached",
+	cacheAuditMissingInCache: "missing from the cache",
+	cacheAuditStale:          "outdated in the cache",
+}
+
+// differences are checked again after this delay, as the watch of the informer may not have delivered an
+// update made right before the audit yet
+var cacheAuditRecheckDelay = 5 * time.Second
+
+// CacheAudit compares a sample of the objects of an informer cache with the API server. The API server is
+// only queried for the sampled objects and a single page of objects, so the audit stays cheap on large clusters.
+type CacheAudit struct {
+	// Resource names the audited resource in logs and metrics
+	Resource string
+	Indexer  cache.Indexer
+	// Get reads an object from the API server
+	Get func(namespace, name string) (interface{}, error)
+	// List reads at most limit objects from the API server
+	List func(limit int64) ([]interface{}, error)
+}
+
+// RunCacheAudits audits the caches every period, sampling sampleSize objects of each, until stopCh is closed
+func RunCacheAudits(audits []CacheAudit, period time.Duration, sampleSize int, stopCh <-chan struct{}) {
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+		for _, audit := range audits {
+			audit.Run(sampleSize)
+		}
+	}
+}
+
+// Run audits the cache once and returns the number of objects checked and of differences found
+func (a CacheAudit) Run(sampleSize int) (int, int) {
+	checked, differences := 0, 0
+	record := func(key, kind string) {
+		differences++
+		metrics.ControllerCacheAuditDifferences.WithLabelValues(a.Resource, kind).Inc()
+		glog.Warningf("Informer cache of %s differs from the API server, %s is %s", a.Resource, key,
+			cacheAuditDescriptions[kind])
+	}
+
+	for _, key := range sampleKeys(a.Indexer.ListKeys(), sampleSize) {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			continue
+		}
+		obj, err := a.Get(namespace, name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			glog.Errorf("Failed to audit the informer cache of %s: %s", a.Resource, err)
+			return checked, differences
+		}
+		checked++
+		if kind := a.compare(key, obj, err == nil); kind != "" {
+			record(key, kind)
+		}
+	}
+
+	objs, err := a.List(int64(sampleSize))
+	if err != nil {
+		glog.Errorf("Failed to audit the informer cache of %s: %s", a.Resource, err)
+		return checked, differences
+	}
+	for _, obj := range objs {
+		key, err := cache.MetaNamespaceKeyFunc(obj)
+		if err != nil {
+			continue
+		}
+		checked++
+		if kind := a.compare(key, obj, true); kind != "" {
+			record(key, kind)
+		}
+	}
+
+	metrics.ControllerCacheAuditObjects.WithLabelValues(a.Resource).Add(float64(checked))
+	glog.V(2).Infof("Audited %d objects of the informer cache of %s, found %d differences", checked, a.Resource, differences)
+	return checked, differences
+}
+
+// compare returns the kind of difference between the cached object and the object read from the API
+// server, or an empty string when they match. A cached object updated while the difference is checked
+// again is not reported, the watch of the informer is delivering updates for it.
+func (a CacheAudit) compare(key string, obj interface{}, exists bool) string {
+	kind, version := a.difference(key, obj, exists)
+	if kind == "" {
+		return ""
+	}
+	time.Sleep(cacheAuditRecheckDelay)
+	kind, recheckedVersion := a.difference(key, obj, exists)
+	if recheckedVersion != version {
+		return ""
+	}
+	return kind
+}
+
+// difference returns the kind of difference and the resource version of the cached object
+func (a CacheAudit) difference(key string, obj interface{}, exists bool) (string, string) {
+	cached, cachedExists, err := a.Indexer.GetByKey(key)
+	if err != nil {
+		return "", ""
+	}
+	version := ""
+	if cachedExists {
+		if cachedMeta, err := meta.Accessor(cached); err == nil {
+			version = cachedMeta.GetResourceVersion()
+		}
+	}
+	switch {
+	case !exists && cachedExists:
+		return cacheAuditMissingInAPI, version
+	case exists && !cachedExists:
+		return cacheAuditMissingInCache, version
+	case !exists:
+		return "", version
+	}
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return "", version
+	}
+	if version != objMeta.GetResourceVersion() {
+		return cacheAuditStale, version
+	}
+	return "", version
+}
+
+func sampleKeys(keys []string, size int) []string {
+	if len(keys) <= size {
+		return keys
+	}
+	rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	return keys[:size]
+}
+
+// CachesSynced tells whether all the informer caches completed their initial listing
+func CachesSynced(synced ...cache.InformerSynced) bool {
+	for _, hasSynced := range synced {
+		if !hasSynced() {
+			return false
+		}
+	}
+	return true
+}
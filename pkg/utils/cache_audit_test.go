@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCacheAudit(t *testing.T) {
+	cacheAuditRecheckDelay = 0
+	pod := func(name, version string) *v1core.Pod {
+		return &v1core.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: version}}
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, p := range []*v1core.Pod{pod("current", "1"), pod("stale", "2"), pod("deleted", "3")} {
+		if err := indexer.Add(p); err != nil {
+			t.Fatalf("failed to add pod to the cache: %s", err)
+		}
+	}
+	api := map[string]*v1core.Pod{
+		"current": pod("current", "1"),
+		"stale":   pod("stale", "5"),
+		"missing": pod("missing", "4"),
+	}
+
+	audit := CacheAudit{
+		Resource: "pods",
+		Indexer:  indexer,
+		Get: func(namespace, name string) (interface{}, error) {
+			if p, ok := api[name]; ok {
+				return p, nil
+			}
+			return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, name)
+		},
+		List: func(limit int64) ([]interface{}, error) {
+			return []interface{}{api["current"], api["missing"]}, nil
+		},
+	}
+
+	checked, differences := audit.Run(10)
+	if checked != 5 {
+		t.Errorf("expected 3 sampled and 2 listed objects to be checked, got %d", checked)
+	}
+	// the stale pod, the pod deleted from the API server and the pod missing from the cache
+	if differences != 3 {
+		t.Errorf("expected 3 differences, got %d", differences)
+	}
+
+	if err := indexer.Update(pod("stale", "5")); err != nil {
+		t.Fatalf("failed to update pod in the cache: %s", err)
+	}
+	if err := indexer.Delete(pod("deleted", "3")); err != nil {
+		t.Fatalf("failed to delete pod from the cache: %s", err)
+	}
+	if err := indexer.Add(pod("missing", "4")); err != nil {
+		t.Fatalf("failed to add pod to the cache: %s", err)
+	}
+	if _, differences := audit.Run(1); differences != 0 {
+		t.Errorf("expected no difference once the cache caught up, got %d", differences)
+	}
+}
+
+func TestCachesSynced(t *testing.T) {
+	synced := func() bool { return true }
+	notSynced := func() bool { return false }
+	if !CachesSynced() || !CachesSynced(synced, synced) {
+		t.Errorf("expected synced caches")
+	}
+	if CachesSynced(synced, notSynced) {
+		t.Errorf("expected caches not to be synced")
+	}
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capability is a Linux capability, numbered as in linux/capability.h
+type Capability uint
+
+// Capabilities kube-router depends on
+const (
+	CapDACOverride    Capability = 1
+	CapNetBindService Capability = 10
+	CapNetAdmin       Capability = 12
+	CapNetRaw         Capability = 13
+	CapSysModule      Capability = 16
+)
+
+const linuxCapabilityVersion3 = 0x20080522
+
+var capabilityNames = map[Capability]string{
+	CapDACOverride:    "CAP_DAC_OVERRIDE",
+	CapNetBindService: "CAP_NET_BIND_SERVICE",
+	CapNetAdmin:       "CAP_NET_ADMIN",
+	CapNetRaw:         "CAP_NET_RAW",
+	CapSysModule:      "CAP_SYS_MODULE",
+}
+
+func (c Capability) String() string {
+	if name, ok := capabilityNames[c]; ok {
+		return name
+	}
+	return "CAP_" + strconv.Itoa(int(c))
+}
+
+// CapabilitySets are the capability sets of a process
+type CapabilitySets struct {
+	Inheritable uint64
+	Permitted   uint64
+	Effective   uint64
+}
+
+// ReadCapabilities returns the capability sets of the current process
+func ReadCapabilities() (CapabilitySets, error) {
+	status, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return CapabilitySets{}, err
+	}
+	return parseCapabilities(string(status))
+}
+
+func parseCapabilities(status string) (CapabilitySets, error) {
+	sets := CapabilitySets{}
+	found := 0
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		var set *uint64
+		switch fields[0] {
+		case "CapInh:":
+			set = &sets.Inheritable
+		case "CapPrm:":
+			set = &sets.Permitted
+		case "CapEff:":
+			set = &sets.Effective
+		default:
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			return sets, fmt.Errorf("invalid capability set %s: %s", fields[1], err)
+		}
+		*set = value
+		found++
+	}
+	if found != 3 {
+		return sets, fmt.Errorf("capability sets not found in process status")
+	}
+	return sets, nil
+}
+
+// MissingCapabilities returns the required capabilities missing from the set
+func MissingCapabilities(set uint64, required []Capability) []Capability {
+	missing := make([]Capability, 0)
+	for _, capability := range required {
+		if set&(1<<uint(capability)) == 0 {
+			missing = append(missing, capability)
+		}
+	}
+	return missing
+}
+
+// CheckCapabilities returns an error naming the required capabilities missing from the effective set
+func CheckCapabilities(required []Capability) error {
+	sets, err := ReadCapabilities()
+	if err != nil {
+		return fmt.Errorf("failed to read the capabilities of the process: %s", err)
+	}
+	missing := MissingCapabilities(sets.Effective, required)
+	if len(missing) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(missing))
+	for _, capability := range missing {
+		names = append(names, capability.String())
+	}
+	return fmt.Errorf("missing capabilities %s", strings.Join(names, ", "))
+}
+
+type capUserHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capUserData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// InheritCapabilities adds the capabilities to the inheritable set, the utilities executed by kube-router can
+// then be given them as ambient capabilities. Capabilities are per thread, so the change is applied to all the
+// threads of the process.
+func InheritCapabilities(capabilities []Capability) error {
+	if os.Geteuid() == 0 || len(capabilities) == 0 {
+		return nil
+	}
+
+	header := capUserHeader{version: linuxCapabilityVersion3}
+	data := [2]capUserData{}
+	if _, _, errno := syscall.RawSyscall(unix.SYS_CAPGET, uintptr(unsafe.Pointer(&header)),
+		uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("failed to get capabilities: %s", errno)
+	}
+	for _, capability := range capabilities {
+		data[capability/32].inheritable |= 1 << (uint(capability) % 32)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&header)),
+		uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("failed to make capabilities inheritable: %s", errno)
+	}
+	return nil
+}
+
+// RaiseAmbientCapabilities makes inheritable capabilities ambient, so all the utilities executed by kube-router
+// keep them when it does not run as root, including the ones executed by libraries like go-iptables
+func RaiseAmbientCapabilities(capabilities []Capability) error {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	for _, capability := range capabilities {
+		if _, _, errno := syscall.AllThreadsSyscall6(unix.SYS_PRCTL, unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE,
+			uintptr(capability), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("failed to raise ambient capability %s: %s", capability, errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+)
+
+func Test_parseCapabilities(t *testing.T) {
+	status := "Name:\tkube-router\nUid:\t1000\t1000\t1000\t1000\n" +
+		"CapInh:\t0000000000000000\nCapPrm:\t0000000000003000\nCapEff:\t0000000000003000\n" +
+		"CapBnd:\t00000000a80425fb\nCapAmb:\t0000000000000000\n"
+	sets, err := parseCapabilities(status)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if sets.Effective != 0x3000 || sets.Permitted != 0x3000 || sets.Inheritable != 0 {
+		t.Errorf("unexpected capability sets %+v", sets)
+	}
+
+	missing := MissingCapabilities(sets.Effective, []Capability{CapNetAdmin, CapNetRaw, CapDACOverride, CapNetBindService})
+	if len(missing) != 2 || missing[0] != CapDACOverride || missing[1] != CapNetBindService {
+		t.Errorf("expected CAP_DAC_OVERRIDE and CAP_NET_BIND_SERVICE to be missing, got %v", missing)
+	}
+
+	if _, err := parseCapabilities("Name:\tkube-router\n"); err == nil {
+		t.Errorf("expected an error for a status without capability sets")
+	}
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// The errors of kube-router are classified by type, so automation can tell the transient dataplane errors, worth a
+// retry, from the configuration errors, which need an operator. The type labels the error metrics, is reported by the
+// health endpoint, and decides the exit code of kube-router.
+
+var (
+	// ErrIPTablesLock is returned when another process held the xtables lock for too long
+	ErrIPTablesLock = errors.New("xtables lock held by another process")
+	// ErrIPSetMissing is returned when an ipset a rule or an operation refers to does not exist
+	ErrIPSetMissing = errors.New("ipset does not exist")
+	// ErrAPIUnavailable is returned when the Kubernetes API server could not be reached or was overloaded
+	ErrAPIUnavailable = errors.New("Kubernetes API server unavailable")
+	// ErrConfig is returned for an invalid configuration of kube-router
+	ErrConfig = errors.New("invalid configuration")
+)
+
+// the types of errors, the values of the error_type label of the metrics
+const (
+	ErrorTypeIPTablesLock   = "iptables_lock"
+	ErrorTypeIPSetMissing   = "ipset_missing"
+	ErrorTypeAPIUnavailable = "api_unavailable"
+	ErrorTypeConfig         = "config"
+	// errors of the utilities programming the dataplane not classified further
+	ErrorTypeDataplane = "dataplane"
+	ErrorTypeUnknown   = "unknown"
+)
+
+// exit codes of kube-router by type of error, the dataplane errors all exit with the same code
+const (
+	ExitCodeUnknown        = 1
+	ExitCodeConfig         = 2
+	ExitCodeAPIUnavailable = 3
+	ExitCodeDataplane      = 4
+)
+
+// NewConfigError returns the error marked as a configuration error
+func NewConfigError(err error) error {
+	return fmt.Errorf("%w: %w", ErrConfig, err)
+}
+
+// ErrorType returns the type of the error. The errors wrapping one of the typed errors, an ExecError or an error of
+// the API client are classified by their cause. Many errors only carry the message of their cause, so the messages
+// of the utilities and of the flag validations are recognized as well.
+func ErrorType(err error) string {
+	var execErr *ExecError
+	var urlErr *url.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrIPTablesLock):
+		return ErrorTypeIPTablesLock
+	case errors.Is(err, ErrIPSetMissing):
+		return ErrorTypeIPSetMissing
+	case errors.Is(err, ErrAPIUnavailable):
+		return ErrorTypeAPIUnavailable
+	case errors.Is(err, ErrConfig):
+		return ErrorTypeConfig
+	case errors.As(err, &execErr):
+		if t := messageErrorType(execErr.Stderr); t != "" {
+			return t
+		}
+		if strings.HasPrefix(filepath.Base(execErr.Command), "iptables") && execErr.ExitCode == 4 {
+			// iptables exits with 4 when it gave up waiting for the lock
+			return ErrorTypeIPTablesLock
+		}
+		return ErrorTypeDataplane
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err), apierrors.IsServiceUnavailable(err),
+		apierrors.IsTooManyRequests(err), apierrors.IsInternalError(err), errors.As(err, &urlErr):
+		// the client wraps the failures to reach the API server in url errors
+		return ErrorTypeAPIUnavailable
+	}
+	if t := messageErrorType(err.Error()); t != "" {
+		return t
+	}
+	return ErrorTypeUnknown
+}
+
+// messageErrorType classifies an error by its message, empty when not recognized
+func messageErrorType(message string) string {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "xtables lock"):
+		return ErrorTypeIPTablesLock
+	case strings.Contains(lower, "set with the given name does not exist"),
+		strings.Contains(lower, "ipset") && strings.Contains(lower, "does not exist"):
+		return ErrorTypeIPSetMissing
+	case strings.Contains(lower, "connection refused"), strings.Contains(lower, "i/o timeout"),
+		strings.Contains(lower, "the server is currently unable to handle the request"),
+		strings.Contains(lower, "the server was unable to return a response in the time allotted"):
+		return ErrorTypeAPIUnavailable
+	case strings.Contains(lower, "exit status"), strings.Contains(lower, "timed out"):
+		// the messages of the failed utilities hold their arguments, flags included
+		return ErrorTypeDataplane
+	case strings.HasPrefix(message, "--"), strings.Contains(message, " --"):
+		// the validations of the configuration name the flag at fault
+		return ErrorTypeConfig
+	}
+	return ""
+}
+
+// ExitCode returns the exit code of kube-router failing with the error, 0 for nil
+func ExitCode(err error) int {
+	switch ErrorType(err) {
+	case "":
+		return 0
+	case ErrorTypeConfig:
+		return ExitCodeConfig
+	case ErrorTypeAPIUnavailable:
+		return ExitCodeAPIUnavailable
+	case ErrorTypeIPTablesLock, ErrorTypeIPSetMissing, ErrorTypeDataplane:
+		return ExitCodeDataplane
+	}
+	return ExitCodeUnknown
+}
+
+// CountError counts the error of the controller in the metrics by type, and returns the type
+func CountError(controller string, err error) string {
+	errorType := ErrorType(err)
+	metrics.ControllerErrors.WithLabelValues(controller, errorType).Inc()
+	return errorType
+}
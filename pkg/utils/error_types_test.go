@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestErrorType(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		err       error
+		errorType string
+		exitCode  int
+	}{
+		{"no error", nil, "", 0},
+		{"wrapped sentinel", fmt.Errorf("sync failed: %w", ErrIPSetMissing), ErrorTypeIPSetMissing, ExitCodeDataplane},
+		{"config error", NewConfigError(errors.New("invalid packet mark mask")), ErrorTypeConfig, ExitCodeConfig},
+		{"ipset stderr", fmt.Errorf("sync failed: %w", &ExecError{Command: "ipset", ExitCode: 1,
+			Stderr: "ipset v7.1: The set with the given name does not exist"}), ErrorTypeIPSetMissing, ExitCodeDataplane},
+		{"iptables lock exit code", &ExecError{Command: "/sbin/iptables-restore", ExitCode: 4},
+			ErrorTypeIPTablesLock, ExitCodeDataplane},
+		{"iptables lock message", errors.New("Another app is currently holding the xtables lock. Stopped waiting " +
+			"after 5s."), ErrorTypeIPTablesLock, ExitCodeDataplane},
+		{"other utility failure", &ExecError{Command: "ip", Args: []string{"route", "add"}, ExitCode: 2},
+			ErrorTypeDataplane, ExitCodeDataplane},
+		{"flattened utility failure", errors.New("Failed to run iptables -t filter --wait: exit status 1"),
+			ErrorTypeDataplane, ExitCodeDataplane},
+		{"API server unreachable", &url.Error{Op: "Get", URL: "https://10.96.0.1", Err: errors.New("refused")},
+			ErrorTypeAPIUnavailable, ExitCodeAPIUnavailable},
+		{"API server overloaded", apierrors.NewServerTimeout(schema.GroupResource{Resource: "pods"}, "list", 1),
+			ErrorTypeAPIUnavailable, ExitCodeAPIUnavailable},
+		{"flag validation", errors.New("Failed to create network policy controller: invalid --netpol-log-limit"),
+			ErrorTypeConfig, ExitCodeConfig},
+		{"unknown", errors.New("something went wrong"), ErrorTypeUnknown, ExitCodeUnknown},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if errorType := ErrorType(test.err); errorType != test.errorType {
+				t.Errorf("expected the type %q, got %q", test.errorType, errorType)
+			}
+			if exitCode := ExitCode(test.err); exitCode != test.exitCode {
+				t.Errorf("expected the exit code %d, got %d", test.exitCode, exitCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+
+	v1core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// writes of events to the API server, across all reasons, allowed per second and in a burst. Occurrences not
+	// written are counted in the next write.
+	eventWriteQPS   = 1.0 / 6
+	eventWriteBurst = 10
+	// aggregated events are forgotten after this many windows without occurrence
+	eventExpiryWindows = 10
+)
+
+// EventSink records the events of kube-router about the node and the objects it handles, shared by the controllers.
+// An event occurring again with the same reason about the same object within the aggregation window is not recorded
+// anew: its occurrences are counted and the
+// event recorded first is updated with the count and the last message once the window elapsed, so flapping
+// conditions can't flood the API server with events. A nil sink records nothing.
+type EventSink struct {
+	clientset kubernetes.Interface
+	nodeName  string
+	window    time.Duration
+	limiter   flowcontrol.RateLimiter
+	now       func() time.Time
+
+	mu     sync.Mutex
+	events map[string]*aggregatedEvent
+}
+
+// aggregatedEvent is an event and its occurrences not written yet
+type aggregatedEvent struct {
+	object v1core.ObjectReference
+	reason string
+	// the event as last written, nil until written once
+	event     *v1core.Event
+	eventType string
+	message   string
+	pending   int32
+	first     time.Time
+	last      time.Time
+	written   time.Time
+}
+
+// NewEventSink returns a sink recording the events of the node, aggregated over the window
+func NewEventSink(clientset kubernetes.Interface, nodeName string, window time.Duration) *EventSink {
+	return &EventSink{
+		clientset: clientset,
+		nodeName:  nodeName,
+		window:    window,
+		limiter:   flowcontrol.NewTokenBucketRateLimiter(eventWriteQPS, eventWriteBurst),
+		now:       time.Now,
+		events:    make(map[string]*aggregatedEvent),
+	}
+}
+
+// RecordNodeEvent records an event about the node
+func (s *EventSink) RecordNodeEvent(eventType, reason, message string) {
+	if s == nil {
+		return
+	}
+	// like the kubelet, node events refer to the node by name
+	s.RecordEvent(v1core.ObjectReference{Kind: "Node", Name: s.nodeName, UID: types.UID(s.nodeName)}, eventType,
+		reason, message)
+}
+
+// RecordEvent records an event about the object. Failures to write the event are logged, the occurrence is then
+// counted in the next write.
+func (s *EventSink) RecordEvent(object v1core.ObjectReference, eventType, reason, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	key := object.Kind + "/" + object.Namespace + "/" + object.Name + "/" + reason
+	e, ok := s.events[key]
+	if !ok {
+		e = &aggregatedEvent{object: object, reason: reason, first: now}
+		s.events[key] = e
+	} else {
+		metrics.ControllerEventsAggregated.Inc()
+	}
+	e.eventType, e.message, e.last = eventType, message, now
+	e.pending++
+	if e.event == nil || now.Sub(e.written) >= s.window {
+		s.write(e)
+	}
+}
+
+// Run writes the occurrences aggregated over each window until stopCh is closed. A nil sink returns right away.
+func (s *EventSink) Run(stopCh <-chan struct{}) {
+	if s == nil || s.window <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		s.flush()
+	}
+}
+
+// flush writes the events with occurrences not written for a window, and forgets the events idle for
+// eventExpiryWindows windows
+func (s *EventSink) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, e := range s.events {
+		if e.pending > 0 && now.Sub(e.written) >= s.window {
+			s.write(e)
+		}
+		if e.pending == 0 && now.Sub(e.last) >= eventExpiryWindows*s.window {
+			delete(s.events, key)
+		}
+	}
+}
+
+// write creates the event, or updates the event recorded earlier with the pending occurrences
+func (s *EventSink) write(e *aggregatedEvent) {
+	if s.clientset == nil || !s.limiter.TryAccept() {
+		return
+	}
+	now := s.now()
+	namespace := e.object.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	events := s.clientset.CoreV1().Events(namespace)
+	if e.event != nil {
+		event := e.event.DeepCopy()
+		event.Type, event.Message = e.eventType, e.message
+		event.Count += e.pending
+		event.LastTimestamp = metav1.NewTime(e.last)
+		updated, err := events.Update(context.Background(), event, metav1.UpdateOptions{})
+		if err == nil {
+			e.event, e.pending, e.written = updated, 0, now
+			return
+		}
+		if !apierrors.IsNotFound(err) {
+			glog.Errorf("Failed to update event %s of %s %s: %s", e.reason, e.object.Kind, e.object.Name, err)
+			return
+		}
+		// the event expired on the API server, the occurrences are recorded in a new one
+		e.first = e.last
+	}
+	created, err := events.Create(context.Background(), s.newEvent(namespace, e), metav1.CreateOptions{})
+	if err != nil {
+		glog.Errorf("Failed to record event %s of %s %s: %s", e.reason, e.object.Kind, e.object.Name, err)
+		return
+	}
+	e.event, e.pending, e.written = created, 0, now
+}
+
+func (s *EventSink) newEvent(namespace string, e *aggregatedEvent) *v1core.Event {
+	return &v1core.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// named like the events of the kubelet
+			Name:      fmt.Sprintf("%s.%x", e.object.Name, e.last.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: e.object,
+		Reason:         e.reason,
+		Message:        e.message,
+		Type:           e.eventType,
+		Source:         v1core.EventSource{Component: "kube-router", Host: s.nodeName},
+		FirstTimestamp: metav1.NewTime(e.first),
+		LastTimestamp:  metav1.NewTime(e.last),
+		Count:          e.pending,
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestEventSink(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink := NewEventSink(client, "node", time.Minute)
+	sink.now = func() time.Time { return clock }
+	sink.limiter = flowcontrol.NewFakeAlwaysRateLimiter()
+
+	events := func() []v1core.Event {
+		list, err := client.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list events: %s", err)
+		}
+		return list.Items
+	}
+
+	sink.RecordNodeEvent(v1core.EventTypeWarning, "SyncThrottled", "load 3")
+	clock = clock.Add(10 * time.Second)
+	sink.RecordNodeEvent(v1core.EventTypeWarning, "SyncThrottled", "load 4")
+	sink.RecordNodeEvent(v1core.EventTypeNormal, "SyncThrottleLifted", "recovered")
+	got := events()
+	if len(got) != 2 {
+		t.Fatalf("expected an event per reason, got %d events", len(got))
+	}
+	for _, event := range got {
+		if event.Reason == "SyncThrottled" && (event.Count != 1 || event.Message != "load 3") {
+			t.Errorf("expected the repeated event not to be written within the window, got %+v", event)
+		}
+	}
+
+	// the occurrences of the window are written once it elapsed
+	clock = clock.Add(30 * time.Second)
+	sink.flush()
+	if got := events(); got[0].Count+got[1].Count != 2 {
+		t.Errorf("expected no write before the window elapsed, got %+v", got)
+	}
+	clock = clock.Add(30 * time.Second)
+	sink.flush()
+	got = events()
+	if len(got) != 2 {
+		t.Fatalf("expected the event to be updated, got %d events", len(got))
+	}
+	for _, event := range got {
+		if event.Reason == "SyncThrottled" && (event.Count != 2 || event.Message != "load 4" ||
+			!event.LastTimestamp.Time.Equal(clock.Add(-60*time.Second)) ||
+			!event.FirstTimestamp.Time.Equal(clock.Add(-70*time.Second))) {
+			t.Errorf("unexpected aggregated event %+v", event)
+		}
+	}
+
+	// an event expired on the API server is recorded anew
+	for _, event := range got {
+		if err := client.CoreV1().Events(metav1.NamespaceDefault).Delete(context.Background(), event.Name, metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock = clock.Add(time.Minute)
+	sink.RecordNodeEvent(v1core.EventTypeWarning, "SyncThrottled", "load 5")
+	if got := events(); len(got) != 1 || got[0].Count != 1 || got[0].Message != "load 5" {
+		t.Errorf("expected the expired event to be recorded anew, got %+v", got)
+	}
+
+	// idle events are forgotten
+	clock = clock.Add(eventExpiryWindows * time.Minute)
+	sink.flush()
+	if len(sink.events) != 0 {
+		t.Errorf("expected the idle events to be forgotten, got %d", len(sink.events))
+	}
+
+	var nilSink *EventSink
+	nilSink.RecordNodeEvent(v1core.EventTypeNormal, "Test", "ignored")
+}
+
+func TestEventSinkRateLimit(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink := NewEventSink(client, "node", time.Minute)
+	sink.now = func() time.Time { return clock }
+	sink.limiter = flowcontrol.NewFakeNeverRateLimiter()
+
+	sink.RecordNodeEvent(v1core.EventTypeWarning, "SyncThrottled", "load 3")
+	sink.RecordNodeEvent(v1core.EventTypeWarning, "SyncThrottled", "load 4")
+	list, _ := client.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	if len(list.Items) != 0 {
+		t.Fatalf("expected no write beyond the rate limit, got %d events", len(list.Items))
+	}
+
+	sink.limiter = flowcontrol.NewFakeAlwaysRateLimiter()
+	clock = clock.Add(time.Minute)
+	sink.flush()
+	list, _ = client.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	if len(list.Items) != 1 || list.Items[0].Count != 2 {
+		t.Errorf("expected the occurrences held back to be written in one event, got %+v", list.Items)
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+)
+
+// DefaultExecTimeout bounds the duration of the utilities executed by kube-router
+const DefaultExecTimeout = 30 * time.Second
+
+// capabilities needed by every utility executed by kube-router (iptables, ipset, ip and conntrack). They are
+// ambient for the whole process, as go-iptables executes iptables on its own.
+var sharedExecCapabilities = []Capability{CapNetAdmin, CapNetRaw, CapDACOverride}
+
+// capabilities forwarded only to the utilities needing them, in addition to the shared ones
+var execCapabilities = map[string][]Capability{
+	"modprobe": {CapSysModule},
+}
+
+// SharedExecCapabilities returns the capabilities needed by all the executed utilities among the given ones
+func SharedExecCapabilities(capabilities []Capability) []Capability {
+	shared := make([]Capability, 0)
+	for _, capability := range capabilities {
+		for _, sharedCapability := range sharedExecCapabilities {
+			if capability == sharedCapability {
+				shared = append(shared, capability)
+			}
+		}
+	}
+	return shared
+}
+
+// ExecError is returned when an executed utility fails
+type ExecError struct {
+	Command string
+	Args    []string
+	// ExitCode is the exit status of the utility, -1 when it did not exit
+	ExitCode int
+	Stderr   string
+	TimedOut bool
+	Err      error
+}
+
+func (e *ExecError) Error() string {
+	command := strings.Join(append([]string{e.Command}, e.Args...), " ")
+	switch {
+	case e.TimedOut:
+		return fmt.Sprintf("%s timed out", command)
+	case e.ExitCode >= 0 && e.Stderr != "":
+		return fmt.Sprintf("%s failed with exit status %d: %s", command, e.ExitCode, e.Stderr)
+	default:
+		return fmt.Sprintf("%s failed: %s", command, e.Err)
+	}
+}
+
+// ExecOptions are the options of ExecWithOptions
+type ExecOptions struct {
+	Stdin io.Reader
+	// Timeout of the utility, DefaultExecTimeout when zero
+	Timeout time.Duration
+}
+
+// Exec runs the utility with the arguments and returns its standard output
+func Exec(name string, args ...string) ([]byte, error) {
+	return ExecWithOptions(ExecOptions{}, name, args...)
+}
+
+// ExecWithOptions runs the utility with the arguments and options, and returns its standard output. Failures
+// are returned as ExecError holding the standard error of the utility, and recorded in the metrics.
+func ExecWithOptions(options ExecOptions, name string, args ...string) ([]byte, error) {
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = options.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	command := filepath.Base(name)
+	if capabilities, ok := execCapabilities[command]; ok && os.Geteuid() != 0 {
+		ambient := make([]uintptr, 0, len(capabilities))
+		for _, capability := range capabilities {
+			ambient = append(ambient, uintptr(capability))
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{AmbientCaps: ambient}
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	metrics.ControllerExecTime.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	if err == nil {
+		return stdout.Bytes(), nil
+	}
+
+	execErr := &ExecError{Command: name, Args: args, ExitCode: -1, Stderr: strings.TrimSpace(stderr.String()), Err: err}
+	reason := "start"
+	if ctx.Err() == context.DeadlineExceeded {
+		execErr.TimedOut = true
+		reason = "timeout"
+	} else if exitErr, ok := err.(*exec.ExitError); ok {
+		execErr.ExitCode = exitErr.ExitCode()
+		reason = "exit"
+	}
+	metrics.ControllerExecFailures.WithLabelValues(command, reason).Inc()
+	return stdout.Bytes(), execErr
+}
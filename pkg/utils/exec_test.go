@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	out, err := ExecWithOptions(ExecOptions{Stdin: strings.NewReader("payload")}, "cat")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(out) != "payload" {
+		t.Errorf("expected the standard input to be echoed, got %q", out)
+	}
+
+	_, err = Exec("sh", "-c", "echo out; echo 'set does not exist' >&2; exit 3")
+	execErr, ok := err.(*ExecError)
+	if !ok {
+		t.Fatalf("expected an ExecError, got %v", err)
+	}
+	if execErr.ExitCode != 3 || execErr.Stderr != "set does not exist" || execErr.TimedOut {
+		t.Errorf("unexpected error %+v", execErr)
+	}
+	if !strings.Contains(err.Error(), "set does not exist") {
+		t.Errorf("expected the standard error in the error message, got %q", err.Error())
+	}
+
+	_, err = ExecWithOptions(ExecOptions{Timeout: 50 * time.Millisecond}, "sleep", "5")
+	if execErr, ok := err.(*ExecError); !ok || !execErr.TimedOut {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	_, err = Exec("kube-router-missing-utility")
+	if execErr, ok := err.(*ExecError); !ok || execErr.ExitCode != -1 {
+		t.Errorf("expected an ExecError for a missing utility, got %v", err)
+	}
+}
+
+func TestSharedExecCapabilities(t *testing.T) {
+	shared := SharedExecCapabilities([]Capability{CapNetAdmin, CapSysModule, CapNetBindService, CapNetRaw})
+	if len(shared) != 2 || shared[0] != CapNetAdmin || shared[1] != CapNetRaw {
+		t.Errorf("expected CAP_NET_ADMIN and CAP_NET_RAW, got %v", shared)
+	}
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"sync"
+)
+
+// FwMarkFeature identifies a kube-router feature that needs bits of the packet mark (fwmark)
+type FwMarkFeature string
+
+const (
+	// FwMarkDSR is the field of the packet mark used to FWMARK the traffic to external IP's of services
+	// using direct server return, so that IPVS FWMARK based services can match them
+	FwMarkDSR FwMarkFeature = "dsr"
+	// FwMarkServiceTraffic is set by the service proxy on packets destined to an IPVS service before they
+	// are DNATed, so that network policy controller can defer the evaluation of egress policies till IPVS has
+	// selected the endpoint
+	FwMarkServiceTraffic FwMarkFeature = "service-traffic"
+	// FwMarkKubeletMasquerade is the bit of the packet mark set by the KUBE-MARK-MASQ chain of the kubelet on the
+	// traffic to masquerade (--iptables-masquerade-bit of the kubelet)
+	FwMarkKubeletMasquerade FwMarkFeature = "kubelet-masquerade"
+	// FwMarkKubeletDrop is the bit of the packet mark set by the KUBE-MARK-DROP chain of the kubelet on the traffic
+	// to drop (--iptables-drop-bit of the kubelet)
+	FwMarkKubeletDrop FwMarkFeature = "kubelet-drop"
+)
+
+// fwMarkReserved are the bits of the packet mark hard-coded by the firewall of the node, they are registered as is
+// and never handed out to the kube-router features
+var fwMarkReserved = []struct {
+	feature FwMarkFeature
+	mask    uint32
+}{
+	{FwMarkKubeletMasquerade, 0x4000},
+	{FwMarkKubeletDrop, 0x8000},
+}
+
+// fwMarkFeatures is the number of contiguous bits needed by each feature, and the bits they must be allocated
+// from, any bit if zero. Bits are handed out in this order, starting from the least significant bit, so allocation
+// is stable across nodes and restarts as long as the excluded bits are configured the same. DSR stores 13 bits of
+// the hash of the external IP, protocol and port of the service, below 2^15 as IPVS FWMARK services and policy
+// routing do not work with greater marks. The features fit in the lower 16 bits with the reserved ones, so that
+// excluding the upper 16 bits (as used by Calico) still works.
+var fwMarkFeatures = []struct {
+	feature FwMarkFeature
+	width   int
+	limit   uint32
+}{
+	{FwMarkDSR, 13, 0x7fff},
+	{FwMarkServiceTraffic, 1, 0},
+}
+
+// FwMark is the bits of the packet mark owned by a feature
+type FwMark struct {
+	Value uint32
+	Mask  uint32
+}
+
+// IsZero returns true if no bits have been allocated for the mark
+func (m FwMark) IsZero() bool {
+	return m.Mask == 0
+}
+
+// String returns the mark in the value/mask format understood by iptables and iproute2
+func (m FwMark) String() string {
+	return fmt.Sprintf("0x%x/0x%x", m.Value, m.Mask)
+}
+
+// FieldValue returns the mark with given value stored in the field of bits owned by the feature. Value is
+// truncated to the width of the field.
+func (m FwMark) FieldValue(value uint32) FwMark {
+	shift := uint(bits.TrailingZeros32(m.Mask))
+	return FwMark{Value: (value << shift) & m.Mask, Mask: m.Mask}
+}
+
+// FwMarkRegistry hands out non-overlapping bits of the packet mark to the kube-router features
+type FwMarkRegistry struct {
+	mu          sync.Mutex
+	excludeMask uint32
+	marks       map[FwMarkFeature]FwMark
+}
+
+// NewFwMarkRegistry allocates the packet mark bits for all the kube-router features, skipping the reserved bits
+// and the bits in excludeMask, which are typically in use by other software on the node (e.g. 0xffff0000 for Calico)
+func NewFwMarkRegistry(excludeMask uint32) (*FwMarkRegistry, error) {
+	registry := &FwMarkRegistry{excludeMask: excludeMask, marks: make(map[FwMarkFeature]FwMark)}
+	used := excludeMask
+	for _, r := range fwMarkReserved {
+		used |= r.mask
+		registry.marks[r.feature] = FwMark{Value: r.mask, Mask: r.mask}
+	}
+	for _, f := range fwMarkFeatures {
+		mask, err := allocateContiguousBits(used, f.width, f.limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate %d packet mark bit(s) for %s with excluded mask 0x%x: %s",
+				f.width, f.feature, excludeMask, err.Error())
+		}
+		used |= mask
+		registry.marks[f.feature] = FwMark{Value: mask, Mask: mask}
+	}
+	return registry, nil
+}
+
+func allocateContiguousBits(used uint32, width int, limit uint32) (uint32, error) {
+	if limit == 0 {
+		limit = ^uint32(0)
+	}
+	field := uint32(1)<<uint(width) - 1
+	for shift := 0; shift+width <= 32; shift++ {
+		mask := field << uint(shift)
+		if used&mask == 0 && mask&^limit == 0 {
+			return mask, nil
+		}
+	}
+	return 0, errors.New("not enough free bits")
+}
+
+// Get returns the mark bits owned by the feature, zero mark if the feature has no bits allocated
+func (r *FwMarkRegistry) Get(feature FwMarkFeature) FwMark {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.marks[feature]
+}
+
+// ExcludeMask returns the bits of the packet mark kube-router does not use
+func (r *FwMarkRegistry) ExcludeMask() uint32 {
+	return r.excludeMask
+}
+
+var (
+	fwMarkRegistryMu sync.Mutex
+	fwMarkRegistry   *FwMarkRegistry
+)
+
+// ParseFwMarkMask parses a packet mark mask given either in hexadecimal (0x prefixed) or decimal notation
+func ParseFwMarkMask(mask string) (uint32, error) {
+	if mask == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(mask, 0, 32)
+	if err != nil {
+		return 0, NewConfigError(fmt.Errorf("invalid packet mark mask %q: %s", mask, err.Error()))
+	}
+	return uint32(value), nil
+}
+
+// ConfigureFwMarks sets up the registry used by the controllers, excluding the bits in excludeMask. It must
+// be called before any of the controllers are started.
+func ConfigureFwMarks(excludeMask uint32) error {
+	registry, err := NewFwMarkRegistry(excludeMask)
+	if err != nil {
+		return err
+	}
+	fwMarkRegistryMu.Lock()
+	fwMarkRegistry = registry
+	fwMarkRegistryMu.Unlock()
+	return nil
+}
+
+// GetFwMark returns the mark bits owned by the feature in the configured registry. If no registry has been
+// configured, default allocation without any excluded bits is used.
+func GetFwMark(feature FwMarkFeature) FwMark {
+	fwMarkRegistryMu.Lock()
+	if fwMarkRegistry == nil {
+		// allocation without excluded bits can not fail
+		fwMarkRegistry, _ = NewFwMarkRegistry(0)
+	}
+	registry := fwMarkRegistry
+	fwMarkRegistryMu.Unlock()
+	return registry.Get(feature)
+}
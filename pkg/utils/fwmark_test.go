@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"testing"
+)
+
+func Test_NewFwMarkRegistry(t *testing.T) {
+	testcases := []struct {
+		name        string
+		excludeMask uint32
+		expected    map[FwMarkFeature]FwMark
+		expectErr   bool
+	}{
+		{
+			"default allocation",
+			0,
+			map[FwMarkFeature]FwMark{
+				FwMarkDSR:               {0x1fff, 0x1fff},
+				FwMarkServiceTraffic:    {0x2000, 0x2000},
+				FwMarkKubeletMasquerade: {0x4000, 0x4000},
+				FwMarkKubeletDrop:       {0x8000, 0x8000},
+			},
+			false,
+		},
+		{
+			"calico bits excluded",
+			0xffff0000,
+			map[FwMarkFeature]FwMark{
+				FwMarkDSR:            {0x1fff, 0x1fff},
+				FwMarkServiceTraffic: {0x2000, 0x2000},
+			},
+			false,
+		},
+		{
+			"not enough bits",
+			0xffff2000,
+			nil,
+			true,
+		},
+		{
+			"low bits excluded",
+			0x1,
+			map[FwMarkFeature]FwMark{
+				FwMarkDSR:            {0x3ffe, 0x3ffe},
+				FwMarkServiceTraffic: {0x10000, 0x10000},
+			},
+			false,
+		},
+		{
+			"no DSR bits below 2^15",
+			0x0f00,
+			nil,
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			registry, err := NewFwMarkRegistry(testcase.excludeMask)
+			if testcase.expectErr {
+				if err == nil {
+					t.Fatalf("expected allocation to fail with excluded mask 0x%x", testcase.excludeMask)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for feature, mark := range testcase.expected {
+				if got := registry.Get(feature); got != mark {
+					t.Errorf("expected %s mark %s but got %s", feature, mark, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_FwMarkFieldValue(t *testing.T) {
+	mark := FwMark{Value: 0x3fff000, Mask: 0x3fff000}
+	if got := mark.FieldValue(0x1234).String(); got != "0x1234000/0x3fff000" {
+		t.Errorf("unexpected field value %s", got)
+	}
+	if got := mark.FieldValue(0xffff).String(); got != "0x3fff000/0x3fff000" {
+		t.Errorf("expected field value to be truncated, got %s", got)
+	}
+}
@@ -5,7 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
 )
 
 var (
@@ -18,6 +24,8 @@ const (
 	FamillyInet = "inet"
 	// FamillyInet6 IPV6.
 	FamillyInet6 = "inet6"
+	// IPv6SetPrefix prefixes the names of the sets of an IPv6 IPSet on the system.
+	IPv6SetPrefix = "inet6:"
 
 	// DefaultMaxElem Default OptionMaxElem value.
 	DefaultMaxElem = "65536"
@@ -46,6 +54,8 @@ const (
 	TypeHashIPNetPortNet = "hash:net,port,net"
 	// TypeHashNetIface The hash:net,iface set type uses a hash to store different sized IP network address and interface name pairs.
 	TypeHashNetIface = "hash:net,iface"
+	// TypeBitmapPort The bitmap:port set type uses a memory range to store port numbers and such a set can store up to 65536 ports. The range of the set must be given when creating it.
+	TypeBitmapPort = "bitmap:port"
 	// TypeListSet The list:set type uses a simple list in which you can store set names.
 	TypeListSet = "list:set"
 
@@ -75,15 +85,20 @@ const (
 	OptionFamilly = "family"
 	// OptionNoMatch The hash set types which can store net type of data (i.e. hash:*net*) support the optional nomatch option when adding entries. When matching elements in the set, entries marked as nomatch are skipped as if those were not added to the set, which makes possible to build up sets with exceptions. See the example at hash type hash:net below. When elements are tested by ipset, the nomatch flags are taken into account. If one wants to test the existence of an element marked with nomatch in a set, then the flag must be specified too.
 	OptionNoMatch = "nomatch"
+	// OptionRange This parameter is required for the create command of the bitmap type sets. It defines the range of the elements the set can store, e.g. the ports from-to of a bitmap:port set.
+	OptionRange = "range"
 	// OptionForceAdd All hash set types support the optional forceadd parameter when creating a set. When sets created with this option become full the next addition to the set may succeed and evict a random entry from the set.
 	OptionForceAdd = "forceadd"
 )
 
-// IPSet represent ipset sets managed by.
+// IPSet represent ipset sets managed by. It is safe for concurrent use: the IPSet guards its map of sets, and each
+// set serializes the commands changing it.
 type IPSet struct {
 	ipSetPath *string
-	Sets      map[string]*Set
-	isIpv6    bool
+	// Sets must only be read directly while the IPSet is not shared, use Get, Ensure and List otherwise.
+	Sets   map[string]*Set
+	isIpv6 bool
+	mu     sync.RWMutex
 }
 
 // Set reprensent a ipset set entry.
@@ -92,6 +107,9 @@ type Set struct {
 	Name    string
 	Entries []*Entry
 	Options []string
+
+	mu      sync.Mutex
+	version uint64
 }
 
 // Entry of ipset Set.
@@ -111,39 +129,22 @@ func getIPSetPath() (*string, error) {
 
 // Used to run ipset binary with args and return stdout.
 func (ipset *IPSet) run(args ...string) (string, error) {
-	var stderr bytes.Buffer
-	var stdout bytes.Buffer
-	cmd := exec.Cmd{
-		Path:   *ipset.ipSetPath,
-		Args:   append([]string{*ipset.ipSetPath}, args...),
-		Stderr: &stderr,
-		Stdout: &stdout,
-	}
-
-	if err := cmd.Run(); err != nil {
-		return "", errors.New(stderr.String())
+	stdout, err := Exec(*ipset.ipSetPath, args...)
+	if err != nil {
+		return "", err
 	}
 
-	return stdout.String(), nil
+	return string(stdout), nil
 }
 
 // Used to run ipset binary with arg and inject stdin buffer and return stdout.
 func (ipset *IPSet) runWithStdin(stdin *bytes.Buffer, args ...string) (string, error) {
-	var stderr bytes.Buffer
-	var stdout bytes.Buffer
-	cmd := exec.Cmd{
-		Path:   *ipset.ipSetPath,
-		Args:   append([]string{*ipset.ipSetPath}, args...),
-		Stderr: &stderr,
-		Stdout: &stdout,
-		Stdin:  stdin,
-	}
-
-	if err := cmd.Run(); err != nil {
-		return "", errors.New(stderr.String())
+	stdout, err := ExecWithOptions(ExecOptions{Stdin: stdin}, *ipset.ipSetPath, args...)
+	if err != nil {
+		return "", err
 	}
 
-	return stdout.String(), nil
+	return string(stdout), nil
 }
 
 // NewIPSet create a new IPSet with ipSetPath initialized.
@@ -162,19 +163,37 @@ func NewIPSet(isIpv6 bool) (*IPSet, error) {
 
 // Create a set identified with setname and specified type. The type may
 // require type specific options. Does not create set on the system if it
-// already exists by the same name.
+// already exists by the same name. Create is Ensure.
 func (ipset *IPSet) Create(setName string, createOptions ...string) (*Set, error) {
+	return ipset.Ensure(setName, createOptions...)
+}
+
+// Ensure returns the set identified by setName, and creates it on the system
+// with the type and options when it does not exist there. Concurrent calls for
+// the same name return the same Set and create it once.
+func (ipset *IPSet) Ensure(setName string, createOptions ...string) (*Set, error) {
+	// Refuse names the kernel would reject, before the name gets recorded
+	if err := ValidateIPSetName((&Set{Parent: ipset, Name: setName}).name()); err != nil {
+		return nil, err
+	}
+
 	// Populate Set map if needed
-	if ipset.Get(setName) == nil {
-		ipset.Sets[setName] = &Set{
+	ipset.mu.Lock()
+	set, ok := ipset.Sets[setName]
+	if !ok {
+		set = &Set{
 			Name:    setName,
 			Options: createOptions,
 			Parent:  ipset,
 		}
+		ipset.Sets[setName] = set
 	}
+	ipset.mu.Unlock()
 
+	set.mu.Lock()
+	defer set.mu.Unlock()
 	// Determine if set with the same name is already active on the system
-	setIsActive, err := ipset.Sets[setName].IsActive()
+	setIsActive, err := set.isActive()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to determine if ipset set %s exists: %s",
 			setName, err)
@@ -182,34 +201,28 @@ func (ipset *IPSet) Create(setName string, createOptions ...string) (*Set, error
 
 	// Create set if missing from the system
 	if !setIsActive {
+		args := append([]string{"create", "-exist", set.name()}, createOptions...)
 		if ipset.isIpv6 {
 			// Add "family inet6" option and a "inet6:" prefix for IPv6 sets.
-			args := []string{"create", "-exist", ipset.Sets[setName].name()}
-			args = append(args, createOptions...)
 			args = append(args, "family", "inet6")
-			if _, err := ipset.run(args...); err != nil {
-				return nil, fmt.Errorf("Failed to create ipset set on system: %s", err)
-			}
-		} else {
-			_, err := ipset.run(append([]string{"create", "-exist", setName},
-				createOptions...)...)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to create ipset set on system: %s", err)
-			}
 		}
+		if _, err := ipset.run(args...); err != nil {
+			return nil, fmt.Errorf("Failed to create ipset set on system: %s", err)
+		}
+		set.version++
 	}
-	return ipset.Sets[setName], nil
+	return set, nil
 }
 
 // Adds a given Set to an IPSet
 func (ipset *IPSet) Add(set *Set) error {
-	_, err := ipset.Create(set.Name, set.Options...)
+	created, err := ipset.Ensure(set.Name, set.Options...)
 	if err != nil {
 		return err
 	}
 
 	for _, entry := range set.Entries {
-		_, err := ipset.Get(set.Name).Add(entry.Options...)
+		_, err := created.Add(entry.Options...)
 		if err != nil {
 			return err
 		}
@@ -221,11 +234,19 @@ func (ipset *IPSet) Add(set *Set) error {
 // Add a given entry to the set. If the -exist option is specified, ipset
 // ignores if the entry already added to the set.
 func (set *Set) Add(addOptions ...string) (*Entry, error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	defer set.exportEntries()
+	return set.add(addOptions...)
+}
+
+func (set *Set) add(addOptions ...string) (*Entry, error) {
 	entry := &Entry{
 		Set:     set,
 		Options: addOptions,
 	}
 	set.Entries = append(set.Entries, entry)
+	set.version++
 	_, err := set.Parent.run(append([]string{"add", "-exist", entry.Set.name()}, addOptions...)...)
 	if err != nil {
 		return nil, err
@@ -233,14 +254,36 @@ func (set *Set) Add(addOptions ...string) (*Entry, error) {
 	return entry, nil
 }
 
+// AddExpiring adds an entry with a timeout to the set on the system without keeping it in the Entries of the set, the
+// kernel removes it once expired. The -exist option is implied, adding the entry again renews its timeout.
+func (set *Set) AddExpiring(timeout time.Duration, addOptions ...string) error {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.version++
+	seconds := strconv.Itoa(int((timeout + time.Second - 1) / time.Second))
+	args := append([]string{"add", "-exist", set.name()}, addOptions...)
+	_, err := set.Parent.run(append(args, OptionTimeout, seconds)...)
+	return err
+}
+
 // Del an entry from a set. If the -exist option is specified and the entry is
 // not in the set (maybe already expired), then the command is ignored.
 func (entry *Entry) Del() error {
-	_, err := entry.Set.Parent.run(append([]string{"del", entry.Set.name()}, entry.Options...)...)
+	set := entry.Set
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	_, err := set.Parent.run(append([]string{"del", set.name()}, entry.Options...)...)
 	if err != nil {
 		return err
 	}
-	entry.Set.Parent.Save()
+	for i, e := range set.Entries {
+		if e == entry {
+			set.Entries = append(set.Entries[:i:i], set.Entries[i+1:]...)
+			break
+		}
+	}
+	set.version++
+	set.exportEntries()
 	return nil
 }
 
@@ -257,12 +300,22 @@ func (set *Set) Test(testOptions ...string) (bool, error) {
 // Destroy the specified set or all the sets if none is given. If the set has
 // got reference(s), nothing is done and no set destroyed.
 func (set *Set) Destroy() error {
+	set.mu.Lock()
 	_, err := set.Parent.run("destroy", set.name())
+	if err == nil {
+		set.version++
+		metrics.ControllerIPSetEntries.DeleteLabelValues(set.name())
+	}
+	set.mu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	delete(set.Parent.Sets, set.Name)
+	set.Parent.mu.Lock()
+	if set.Parent.Sets[set.Name] == set {
+		delete(set.Parent.Sets, set.Name)
+	}
+	set.Parent.mu.Unlock()
 	return nil
 }
 
@@ -285,7 +338,7 @@ func (ipset *IPSet) Destroy(setName string) error {
 
 // DestroyAllWithin destroys all sets contained within the IPSet's Sets.
 func (ipset *IPSet) DestroyAllWithin() error {
-	for _, v := range ipset.Sets {
+	for _, v := range ipset.List() {
 		err := v.Destroy()
 		if err != nil {
 			return err
@@ -297,6 +350,10 @@ func (ipset *IPSet) DestroyAllWithin() error {
 
 // IsActive checks if a set exists on the system with the same name.
 func (set *Set) IsActive() (bool, error) {
+	return set.isActive()
+}
+
+func (set *Set) isActive() (bool, error) {
 	_, err := set.Parent.run("list", set.name())
 	if err != nil {
 		if strings.Contains(err.Error(), "name does not exist") {
@@ -307,9 +364,23 @@ func (set *Set) IsActive() (bool, error) {
 	return true, nil
 }
 
+// Version counts the changes made to the set through the IPSet. A caller
+// holding on to a set compares it to tell whether another goroutine changed
+// the set meanwhile.
+func (set *Set) Version() uint64 {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return set.version
+}
+
+// exportEntries exports the number of entries of the set, locked by the caller
+func (set *Set) exportEntries() {
+	metrics.ControllerIPSetEntries.WithLabelValues(set.name()).Set(float64(len(set.Entries)))
+}
+
 func (set *Set) name() string {
 	if set.Parent.isIpv6 {
-		return "inet6:" + set.Name
+		return IPv6SetPrefix + set.Name
 	} else {
 		return set.Name
 	}
@@ -349,11 +420,13 @@ func parseIPSetSave(ipset *IPSet, result string) map[string]*Set {
 // add KUBE-DST-3YNVZWWGX3UQQ4VQ 100.96.1.6 timeout 0
 func buildIPSetRestore(ipset *IPSet) string {
 	ipSetRestore := ""
-	for _, set := range ipset.Sets {
+	for _, set := range ipset.List() {
+		set.mu.Lock()
 		ipSetRestore += fmt.Sprintf("create %s %s\n", set.Name, strings.Join(set.Options[:], " "))
 		for _, entry := range set.Entries {
 			ipSetRestore += fmt.Sprintf("add %s %s\n", set.Name, strings.Join(entry.Options[:], " "))
 		}
+		set.mu.Unlock()
 	}
 	return ipSetRestore
 }
@@ -362,12 +435,36 @@ func buildIPSetRestore(ipset *IPSet) string {
 // restore can read. The option -file can be used to specify a filename instead
 // of stdout.
 // save "ipset save" command output to ipset.sets.
+// The sets already known keep their Set, updated with the saved entries, so
+// the callers holding on to them keep their version.
 func (ipset *IPSet) Save() error {
 	stdout, err := ipset.run("save")
 	if err != nil {
 		return err
 	}
-	ipset.Sets = parseIPSetSave(ipset, stdout)
+	saved := parseIPSetSave(ipset, stdout)
+
+	// the IPSet lock is never held while locking a set
+	updated := make(map[*Set]*Set)
+	ipset.mu.Lock()
+	for name, savedSet := range saved {
+		if set, ok := ipset.Sets[name]; ok {
+			updated[set] = savedSet
+			saved[name] = set
+		}
+	}
+	ipset.Sets = saved
+	ipset.mu.Unlock()
+
+	for set, savedSet := range updated {
+		set.mu.Lock()
+		set.Options, set.Entries = savedSet.Options, savedSet.Entries
+		for _, entry := range set.Entries {
+			entry.Set = set
+		}
+		set.exportEntries()
+		set.mu.Unlock()
+	}
 	return nil
 }
 
@@ -388,10 +485,15 @@ func (ipset *IPSet) Restore() error {
 
 // Flush all entries from the specified set or flush all sets if none is given.
 func (set *Set) Flush() error {
+	set.mu.Lock()
+	defer set.mu.Unlock()
 	_, err := set.Parent.run("flush", set.Name)
 	if err != nil {
 		return err
 	}
+	set.Entries = nil
+	set.version++
+	set.exportEntries()
 	return nil
 }
 
@@ -401,11 +503,20 @@ func (ipset *IPSet) Flush() error {
 	if err != nil {
 		return err
 	}
+	for _, set := range ipset.List() {
+		set.mu.Lock()
+		set.Entries = nil
+		set.version++
+		set.exportEntries()
+		set.mu.Unlock()
+	}
 	return nil
 }
 
 // Get Set by Name.
 func (ipset *IPSet) Get(setName string) *Set {
+	ipset.mu.RLock()
+	defer ipset.mu.RUnlock()
 	set, ok := ipset.Sets[setName]
 	if !ok {
 		return nil
@@ -414,15 +525,30 @@ func (ipset *IPSet) Get(setName string) *Set {
 	return set
 }
 
+// List returns the sets of the IPSet, sorted by name.
+func (ipset *IPSet) List() []*Set {
+	ipset.mu.RLock()
+	sets := make([]*Set, 0, len(ipset.Sets))
+	for _, set := range ipset.Sets {
+		sets = append(sets, set)
+	}
+	ipset.mu.RUnlock()
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Name < sets[j].Name })
+	return sets
+}
+
 // Rename a set. Set identified by SETNAME-TO must not exist.
 func (set *Set) Rename(newName string) error {
 	if set.Parent.isIpv6 {
 		newName = "ipv6:" + newName
 	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
 	_, err := set.Parent.run("rename", set.name(), newName)
 	if err != nil {
 		return err
 	}
+	set.version++
 	return nil
 }
 
@@ -430,81 +556,87 @@ func (set *Set) Rename(newName string) error {
 // sets. The referred sets must exist and compatible type of sets can be
 // swapped only.
 func (set *Set) Swap(setTo *Set) error {
+	if set == setTo {
+		return nil
+	}
+	// sets are locked in the order of their names, so concurrent swaps of the same sets can't deadlock
+	first, second := set, setTo
+	if second.Name < first.Name {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	return set.swap(setTo)
+}
+
+// swap exchanges the content of the sets, both locked by the caller
+func (set *Set) swap(setTo *Set) error {
 	_, err := set.Parent.run("swap", set.name(), setTo.name())
 	if err != nil {
 		return err
 	}
+	set.Entries, setTo.Entries = setTo.Entries, set.Entries
+	for _, entry := range set.Entries {
+		entry.Set = set
+	}
+	for _, entry := range setTo.Entries {
+		entry.Set = setTo
+	}
+	set.version++
+	setTo.version++
 	return nil
 }
 
 // Refresh a Set with new entries.
 func (set *Set) Refresh(entries []string, extraOptions ...string) error {
-	var err error
-	// The set-name must be < 32 characters!
-	tempName := set.Name + "-"
-
-	newSet := &Set{
-		Parent:  set.Parent,
-		Name:    tempName,
-		Options: set.Options,
-	}
-
-	err = set.Parent.Add(newSet)
-	if err != nil {
-		return err
-	}
-
+	entryOptions := make([][]string, 0, len(entries))
 	for _, entry := range entries {
-		_, err = newSet.Add(entry)
-		if err != nil {
-			return err
-		}
-	}
-
-	err = set.Swap(newSet)
-	if err != nil {
-		return err
-	}
-
-	err = set.Parent.Destroy(tempName)
-	if err != nil {
-		return err
+		entryOptions = append(entryOptions, []string{entry})
 	}
-
-	return nil
+	// The set-name must be < 32 characters!
+	return set.refresh(set.Name+ipSetRefreshSuffix, entryOptions)
 }
 
 // Refresh a Set with new entries with built-in options.
 func (set *Set) RefreshWithBuiltinOptions(entries [][]string) error {
-	var err error
-	tempName := set.Name + "-temp"
-	newSet := &Set{
-		Parent:  set.Parent,
-		Name:    tempName,
-		Options: set.Options,
-	}
+	return set.refresh(set.Name+ipSetRefreshBuiltinSuffix, entries)
+}
 
-	err = set.Parent.Add(newSet)
+// refresh fills the temporary set with the entries and swaps it with the set.
+// The set stays locked throughout, the temporary set is only used by the
+// refreshes of the set.
+func (set *Set) refresh(tempName string, entries [][]string) error {
+	start := time.Now()
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	newSet, err := set.Parent.Ensure(tempName, set.Options...)
 	if err != nil {
 		return err
 	}
 
+	newSet.mu.Lock()
 	for _, entry := range entries {
-		_, err = newSet.Add(entry...)
+		_, err = newSet.add(entry...)
 		if err != nil {
+			newSet.mu.Unlock()
 			return err
 		}
 	}
-
-	err = set.Swap(newSet)
+	err = set.swap(newSet)
+	newSet.mu.Unlock()
 	if err != nil {
 		return err
 	}
+	set.exportEntries()
 
 	err = set.Parent.Destroy(tempName)
 	if err != nil {
 		return err
 	}
 
+	metrics.ControllerIPSetRefreshTime.Observe(time.Since(start).Seconds())
 	return nil
 }
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+)
+
+const (
+	// IPSetMaxNameLength is the maximum length of ipset names supported by the kernel
+	IPSetMaxNameLength = 31
+
+	ipSetInet6Prefix = "inet6:"
+	// suffixes of the temporary sets created by Refresh and RefreshWithBuiltinOptions
+	ipSetRefreshSuffix        = "-"
+	ipSetRefreshBuiltinSuffix = "-temp"
+	ipSetNameHashLength       = 16
+	ipSetNameMinHashLength    = 8
+)
+
+// IPSetNameCollisionError is returned when two distinct owners are given the same generated ipset name
+type IPSetNameCollisionError struct {
+	Name          string
+	Owner         string
+	ExistingOwner string
+}
+
+func (e *IPSetNameCollisionError) Error() string {
+	return fmt.Sprintf("ipset name %s generated for %s is already used by %s", e.Name, e.Owner, e.ExistingOwner)
+}
+
+// IPSetNameMaxLength returns the maximum length of generated set names, leaving room for the prefix of IPv6
+// sets and the suffix of the temporary sets used to refresh the sets
+func IPSetNameMaxLength(isIpv6 bool) int {
+	max := IPSetMaxNameLength - len(ipSetRefreshBuiltinSuffix)
+	if isIpv6 {
+		max -= len(ipSetInet6Prefix)
+	}
+	return max
+}
+
+// ValidateIPSetName returns an error when the name, as given to the ipset utility, exceeds the kernel limit
+func ValidateIPSetName(name string) error {
+	if len(name) > IPSetMaxNameLength {
+		return fmt.Errorf("ipset name %s is %d characters long, the maximum is %d", name, len(name),
+			IPSetMaxNameLength)
+	}
+	return nil
+}
+
+// HashedIPSetName returns the name made of the prefix and a hash of the key. The hash is shortened so the name
+// fits IPSetNameMaxLength, and the prefix is truncated when it leaves less than 8 characters to the hash.
+func HashedIPSetName(prefix, key string, isIpv6 bool) string {
+	hash := sha256.Sum256([]byte(key))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+
+	max := IPSetNameMaxLength(isIpv6)
+	if len(prefix) > max-ipSetNameMinHashLength {
+		prefix = prefix[:max-ipSetNameMinHashLength]
+	}
+	hashLength := max - len(prefix)
+	if hashLength > ipSetNameHashLength {
+		hashLength = ipSetNameHashLength
+	}
+	return prefix + encoded[:hashLength]
+}
+
+// ShortenIPSetName returns the name unchanged when it fits IPSetNameMaxLength. Longer names are replaced by
+// their beginning followed by a hash of the complete name, so distinct long names remain distinct.
+func ShortenIPSetName(name string, isIpv6 bool) string {
+	if len(name) <= IPSetNameMaxLength(isIpv6) {
+		return name
+	}
+	return HashedIPSetName(name, name, isIpv6)
+}
+
+// IPSetNames records the owners of generated ipset names, to detect distinct owners given the same name
+type IPSetNames map[string]string
+
+// Register records the owner of the name, and returns an IPSetNameCollisionError when the name is already
+// owned by another owner
+func (n IPSetNames) Register(name, owner string) error {
+	if existing, ok := n[name]; ok && existing != owner {
+		return &IPSetNameCollisionError{Name: name, Owner: owner, ExistingOwner: existing}
+	}
+	n[name] = owner
+	return nil
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_HashedIPSetName(t *testing.T) {
+	testcases := []struct {
+		name           string
+		prefix         string
+		isIpv6         bool
+		expectedPrefix string
+		expectedLength int
+	}{
+		{"short prefix keeps the full hash", "KUBE-SRC-", false, "KUBE-SRC-", 25},
+		{"ipv6 shortens the hash", "KUBE-SRC-", true, "KUBE-SRC-", 20},
+		{"long prefix shortens the hash", "kube-router-rmt-", false, "kube-router-rmt-", 26},
+		{"too long prefix is truncated", "kube-router-remote-namespace-", false, "kube-router-remote", 26},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			name := HashedIPSetName(testcase.prefix, "default/policy", testcase.isIpv6)
+			if !strings.HasPrefix(name, testcase.expectedPrefix) || len(name) != testcase.expectedLength {
+				t.Errorf("expected a %d characters name starting with %s, got %s", testcase.expectedLength,
+					testcase.expectedPrefix, name)
+			}
+			if err := ValidateIPSetName(name + ipSetRefreshBuiltinSuffix); err != nil && !testcase.isIpv6 {
+				t.Errorf("expected the temporary set name to fit: %s", err)
+			}
+			if name != HashedIPSetName(testcase.prefix, "default/policy", testcase.isIpv6) {
+				t.Errorf("expected the name to be deterministic")
+			}
+		})
+	}
+}
+
+func Test_ShortenIPSetName(t *testing.T) {
+	if name := ShortenIPSetName("kube-router-pod-subnets", false); name != "kube-router-pod-subnets" {
+		t.Errorf("expected a short name to be unchanged, got %s", name)
+	}
+
+	first := ShortenIPSetName("kube-router-service-ips-tenant-a", false)
+	second := ShortenIPSetName("kube-router-service-ips-tenant-b", false)
+	if len(first) > IPSetNameMaxLength(false) || len(second) > IPSetNameMaxLength(false) {
+		t.Errorf("expected shortened names to fit %d characters, got %s and %s", IPSetNameMaxLength(false), first, second)
+	}
+	if first == second {
+		t.Errorf("expected distinct long names to remain distinct, got %s", first)
+	}
+}
+
+func Test_ValidateIPSetName(t *testing.T) {
+	if err := ValidateIPSetName("inet6:kube-router-pod-subnets-"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := ValidateIPSetName("inet6:kube-router-pod-subnets-temp"); err == nil {
+		t.Errorf("expected an error for a name longer than %d characters", IPSetMaxNameLength)
+	}
+}
+
+func Test_IPSetNamesRegister(t *testing.T) {
+	names := make(IPSetNames)
+	if err := names.Register("KUBE-SRC-A", "ns/policy"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := names.Register("KUBE-SRC-A", "ns/policy"); err != nil {
+		t.Errorf("expected registering the same owner again to succeed, got %s", err)
+	}
+	err := names.Register("KUBE-SRC-A", "ns/other")
+	collision, ok := err.(*IPSetNameCollisionError)
+	if !ok {
+		t.Fatalf("expected a collision error, got %v", err)
+	}
+	if collision.ExistingOwner != "ns/policy" || collision.Owner != "ns/other" {
+		t.Errorf("unexpected owners in collision error: %+v", collision)
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const ipSetProbeName = "kube-router-probe"
+
+// kernel modules providing the ipset types used by kube-router
+var ipSetTypeModules = map[string]string{
+	TypeHashIP:      "ip_set_hash_ip",
+	TypeHashNet:     "ip_set_hash_net",
+	TypeHashIPPort:  "ip_set_hash_ipport",
+	TypeHashNetPort: "ip_set_hash_netport",
+}
+
+// CheckIPSetSupport verifies the ipset utility is installed and the kernel supports the given set types, by
+// creating and deleting a probe set of each type. The returned error lists the missing kernel modules.
+func CheckIPSetSupport(setTypes []string) error {
+	ipset, err := NewIPSet(false)
+	if err != nil {
+		return fmt.Errorf("%s, the ipset package must be installed", err)
+	}
+	return checkIPSetTypes(setTypes, ipset.probe)
+}
+
+func (ipset *IPSet) probe(setType string) error {
+	// a probe set may be left over by a previous run with another type
+	_, _ = ipset.run("destroy", ipSetProbeName)
+	if _, err := ipset.run("create", ipSetProbeName, setType); err != nil {
+		return err
+	}
+	_, err := ipset.run("destroy", ipSetProbeName)
+	return err
+}
+
+func checkIPSetTypes(setTypes []string, probe func(setType string) error) error {
+	missing := make(map[string]bool)
+	failures := make([]string, 0)
+	for _, setType := range setTypes {
+		err := probe(setType)
+		if err == nil {
+			continue
+		}
+		message := strings.TrimSpace(err.Error())
+		failures = append(failures, setType+": "+message)
+		// without the ip_set module the ipset utility cannot talk to the kernel at all
+		if strings.Contains(message, "Cannot open session to kernel") {
+			missing["ip_set"] = true
+		}
+		if module, ok := ipSetTypeModules[setType]; ok {
+			missing[module] = true
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+
+	modules := make([]string, 0, len(missing))
+	for module := range missing {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	return fmt.Errorf("the kernel does not support the ipset types used by kube-router, missing kernel modules: %s (%s)",
+		strings.Join(modules, ", "), strings.Join(failures, "; "))
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_checkIPSetTypes(t *testing.T) {
+	testcases := []struct {
+		name            string
+		failures        map[string]string
+		expectedModules string
+	}{
+		{"all types supported", map[string]string{}, ""},
+		{"set type module missing", map[string]string{
+			TypeHashNet: "ipset v6.38: Kernel error received: set type not supported",
+		}, "missing kernel modules: ip_set_hash_net "},
+		{"ip_set module missing", map[string]string{
+			TypeHashIP:  "ipset v6.38: Cannot open session to kernel.",
+			TypeHashNet: "ipset v6.38: Cannot open session to kernel.",
+		}, "missing kernel modules: ip_set, ip_set_hash_ip, ip_set_hash_net "},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			err := checkIPSetTypes([]string{TypeHashIP, TypeHashNet}, func(setType string) error {
+				if message, ok := testcase.failures[setType]; ok {
+					return errors.New(message)
+				}
+				return nil
+			})
+			if testcase.expectedModules == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), testcase.expectedModules) {
+				t.Errorf("expected error listing %q, got %v", testcase.expectedModules, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newFakeIPSet returns an IPSet running a fake ipset utility, which reports every set as existing and prints the
+// given output on save
+func newFakeIPSet(t *testing.T, saved string) *IPSet {
+	dir, err := ioutil.TempDir("", "ipset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "ipset")
+	script := "#!/bin/sh\nif [ \"$1\" = save ]; then printf '" + saved + "'; fi\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &IPSet{ipSetPath: &path, Sets: make(map[string]*Set)}
+}
+
+func TestIPSetConcurrentEnsure(t *testing.T) {
+	ipset := newFakeIPSet(t, "")
+	const goroutines = 10
+	sets := make([]*Set, goroutines)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			set, err := ipset.Ensure("KUBE-DST-TEST", TypeHashIP, OptionTimeout, "0")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := set.Add("10.0.0.1"); err != nil {
+				t.Error(err)
+			}
+			sets[i] = set
+		}(i)
+	}
+	wg.Wait()
+
+	for _, set := range sets {
+		if set != sets[0] {
+			t.Fatalf("expected all the callers to get the same set")
+		}
+	}
+	if len(sets[0].Entries) != goroutines || sets[0].Version() != goroutines {
+		t.Errorf("expected %d entries and changes, got %d entries and version %d", goroutines, len(sets[0].Entries),
+			sets[0].Version())
+	}
+}
+
+func TestIPSetSaveKeepsSets(t *testing.T) {
+	ipset := newFakeIPSet(t, `create KUBE-SRC-B hash:ip family inet hashsize 1024 maxelem 65536 timeout 0\n`+
+		`add KUBE-SRC-B 10.0.0.2 timeout 0\ncreate KUBE-DST-A hash:ip family inet hashsize 1024 maxelem 65536 `+
+		`timeout 0\nadd KUBE-DST-A 10.0.0.1 timeout 0\nadd KUBE-DST-A 10.0.0.3 timeout 0\n`)
+	set, err := ipset.Ensure("KUBE-DST-A", TypeHashIP, OptionTimeout, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.Add("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipset.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ipset.Get("KUBE-DST-A") != set {
+		t.Fatalf("expected the saved set to be the one already known")
+	}
+	if set.Version() != 1 || len(set.Entries) != 2 {
+		t.Errorf("expected the version to be kept and the saved entries, got version %d and %d entries",
+			set.Version(), len(set.Entries))
+	}
+	for _, entry := range set.Entries {
+		if entry.Set != set {
+			t.Errorf("expected the saved entries to belong to the known set")
+		}
+	}
+	if sets := ipset.List(); len(sets) != 2 || sets[0].Name != "KUBE-DST-A" || sets[1].Name != "KUBE-SRC-B" {
+		t.Errorf("expected the sets sorted by name, got %v", sets)
+	}
+
+	if err := set.Refresh([]string{"10.0.0.4"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Entries) != 1 || set.Entries[0].Options[0] != "10.0.0.4" || set.Version() != 2 {
+		t.Errorf("expected the refreshed entries, got %d entries and version %d", len(set.Entries), set.Version())
+	}
+	if ipset.Get("KUBE-DST-A"+ipSetRefreshSuffix) != nil {
+		t.Errorf("expected the temporary set to be destroyed")
+	}
+	m := &dto.Metric{}
+	if err := metrics.ControllerIPSetEntries.WithLabelValues("KUBE-DST-A").Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetGauge().GetValue() != 1 {
+		t.Errorf("expected the entries of the refreshed set to be exported, got %v", m.GetGauge().GetValue())
+	}
+}
+
+func TestSetAddExpiring(t *testing.T) {
+	ipset := newFakeIPSet(t, "")
+	set, err := ipset.Ensure("KUBE-FQDN-TEST", TypeHashNet, OptionTimeout, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the timeout is rounded up to the second
+	if err := set.AddExpiring(1500*time.Millisecond, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Entries) != 0 || set.Version() != 1 {
+		t.Errorf("expected the expiring entry not to be kept, got %d entries and version %d", len(set.Entries),
+			set.Version())
+	}
+}
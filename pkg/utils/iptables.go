@@ -0,0 +1,331 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// SplitIPTablesArgs splits a rule listed by iptables -S or iptables-save in to arguments, honouring the double
+// quotes and escapes used for arguments containing spaces
+func SplitIPTablesArgs(line string) ([]string, error) {
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg, quoted, escaped := false, false, false
+	for _, c := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+			inArg = true
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case c == ' ' && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quoted || escaped {
+		return nil, fmt.Errorf("Failed to parse iptables-save line: %s", line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// IPTablesRuleSpec returns the specification of a rule listed by iptables -S, as accepted by the Delete of
+// go-iptables, or nil for the lines defining the chain or its policy
+func IPTablesRuleSpec(rule string) ([]string, error) {
+	if !strings.HasPrefix(rule, "-A ") {
+		return nil, nil
+	}
+	args, err := SplitIPTablesArgs(rule)
+	if err != nil {
+		return nil, err
+	}
+	return args[2:], nil
+}
+
+// DeleteIPTablesRules deletes the rules of the chain matching, parsed, by their specification, which unlike rule
+// numbers do not shift as rules are deleted. It returns the deleted rules.
+func DeleteIPTablesRules(iptablesCmdHandler *iptables.IPTables, table, chain string,
+	match func(rule IPTablesSaveRule) bool) ([]string, error) {
+	rules, err := iptablesCmdHandler.List(table, chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules in %s chain of %s table due to %s", chain, table, err)
+	}
+	deleted := make([]string, 0)
+	for _, rule := range rules {
+		parsed, ok, err := ParseIPTablesRule(rule)
+		if err != nil {
+			return deleted, err
+		}
+		if !ok || !match(parsed) {
+			continue
+		}
+		if err := iptablesCmdHandler.Delete(table, chain, parsed.Args...); err != nil {
+			return deleted, fmt.Errorf("failed to delete rule: %s from the %s chain of %s table due to %s",
+				rule, chain, table, err)
+		}
+		deleted = append(deleted, rule)
+	}
+	return deleted, nil
+}
+
+// JoinIPTablesArgs joins the arguments of a rule into a line of iptables-restore input, quoting the arguments
+// containing spaces or quotes, the reverse of SplitIPTablesArgs
+func JoinIPTablesArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\"\\") {
+			quoted = append(quoted, arg)
+			continue
+		}
+		arg = strings.Replace(arg, `\`, `\\`, -1)
+		quoted = append(quoted, `"`+strings.Replace(arg, `"`, `\"`, -1)+`"`)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// IPTablesRestore is the input of iptables-restore for a table, built rule by rule and applied in a single
+// transaction. It is applied with --noflush: the chains it declares are created, or flushed when they exist, the
+// rules deleted from the other chains are deleted, their rules are inserted at their top, or at the position set for
+// the chain, and the rest of the table is left as is.
+type IPTablesRestore struct {
+	table    string
+	declared map[string]bool
+	// position the rules of the chains not declared are inserted at, 0 to append them, 1 when not set
+	positions map[string]int
+	// chains with rules, in the order they were first used
+	chains []string
+	rules  map[string][]string
+	// rules of each chain, to add each once
+	unique map[string]map[string]bool
+	// rules deleted from the chains not declared, in order
+	deletes []string
+	// inputs of the other tables applied along
+	tables []*IPTablesRestore
+}
+
+// NewIPTablesRestore returns an empty input for the table
+func NewIPTablesRestore(table string) *IPTablesRestore {
+	return &IPTablesRestore{table: table, declared: make(map[string]bool), positions: make(map[string]int),
+		rules: make(map[string][]string), unique: make(map[string]map[string]bool)}
+}
+
+// NewChain declares the chain, created or flushed when the input is applied
+func (r *IPTablesRestore) NewChain(chain string) {
+	if !r.declared[chain] {
+		r.declared[chain] = true
+		r.use(chain)
+	}
+}
+
+// SetRulePosition sets the position, from 1, the rules of a chain not declared are inserted at, in the order they
+// were added, or appends them to the chain with 0
+func (r *IPTablesRestore) SetRulePosition(chain string, position int) {
+	r.positions[chain] = position
+}
+
+// AppendUnique appends the rule to the chain, unless the input already has it
+func (r *IPTablesRestore) AppendUnique(chain string, rulespec ...string) {
+	if rule, ok := r.uniqueRule(chain, rulespec); ok {
+		r.rules[chain] = append(r.rules[chain], rule)
+	}
+}
+
+// InsertUnique inserts the rule at the top of the chain, unless the input already has it
+func (r *IPTablesRestore) InsertUnique(chain string, rulespec ...string) {
+	if rule, ok := r.uniqueRule(chain, rulespec); ok {
+		r.rules[chain] = append([]string{rule}, r.rules[chain]...)
+	}
+}
+
+func (r *IPTablesRestore) uniqueRule(chain string, rulespec []string) (string, bool) {
+	r.use(chain)
+	rule := JoinIPTablesArgs(rulespec)
+	if r.unique[chain][rule] {
+		return "", false
+	}
+	r.unique[chain][rule] = true
+	return rule, true
+}
+
+func (r *IPTablesRestore) use(chain string) {
+	if _, ok := r.unique[chain]; !ok {
+		r.unique[chain] = make(map[string]bool)
+		r.chains = append(r.chains, chain)
+	}
+}
+
+// DeleteRule deletes the first rule of the chain matching the rule when the input is applied, before any rule is
+// added. The input fails when the chain has no such rule.
+func (r *IPTablesRestore) DeleteRule(chain string, rulespec ...string) {
+	r.deletes = append(r.deletes, "-D "+chain+" "+JoinIPTablesArgs(rulespec))
+}
+
+// AddTable adds the input of another table, applied after the table by the same iptables-restore
+func (r *IPTablesRestore) AddTable(table *IPTablesRestore) {
+	r.tables = append(r.tables, table)
+}
+
+// FlushChain removes the rules of the chain from the input, and tells whether it had any. A declared chain stays
+// declared, it is created, or flushed, empty.
+func (r *IPTablesRestore) FlushChain(chain string) bool {
+	flushed := len(r.rules[chain]) != 0
+	delete(r.rules, chain)
+	if _, ok := r.unique[chain]; ok {
+		r.unique[chain] = make(map[string]bool)
+	}
+	return flushed
+}
+
+// iptables-restore reports the line it failed on as "line 12 failed", or "Error occurred at line: 12" with the
+// nf_tables variant
+var iptablesRestoreFailedLine = regexp.MustCompile(`line:? (\d+)`)
+
+// FailedChain returns the chain of the line of the input an error of Apply reports, empty when the error names no
+// line of the input
+func (r *IPTablesRestore) FailedChain(err error) string {
+	execErr, ok := err.(*ExecError)
+	if !ok {
+		return ""
+	}
+	match := iptablesRestoreFailedLine.FindStringSubmatch(execErr.Stderr)
+	if match == nil {
+		return ""
+	}
+	line, _ := strconv.Atoi(match[1])
+	lines := strings.Split(string(r.Bytes()), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	fields := strings.Fields(lines[line-1])
+	switch {
+	case len(fields) > 1 && (fields[0] == "-A" || fields[0] == "-I" || fields[0] == "-D"):
+		return fields[1]
+	case len(fields) > 0 && strings.HasPrefix(fields[0], ":"):
+		return strings.TrimPrefix(fields[0], ":")
+	}
+	return ""
+}
+
+// Rules returns the number of rules of the input, including the added tables
+func (r *IPTablesRestore) Rules() int {
+	rules := 0
+	for _, chainRules := range r.rules {
+		rules += len(chainRules)
+	}
+	for _, table := range r.tables {
+		rules += table.Rules()
+	}
+	return rules
+}
+
+// ChainRules returns the rules of the input by chain, in the order they were added. The added tables are left out.
+func (r *IPTablesRestore) ChainRules() map[string][]string {
+	rules := make(map[string][]string, len(r.chains))
+	for _, chain := range r.chains {
+		rules[chain] = append([]string(nil), r.rules[chain]...)
+	}
+	return rules
+}
+
+// Bytes renders the input. The rules of the chains not declared are inserted in reverse order so they end up at the
+// top of the chain, or at their position, in the order they were added. The added tables follow the table.
+func (r *IPTablesRestore) Bytes() []byte {
+	var b bytes.Buffer
+	b.WriteString("*" + r.table + "\n")
+	for _, chain := range r.chains {
+		if r.declared[chain] {
+			b.WriteString(":" + chain + " - [0:0]\n")
+		}
+	}
+	for _, rule := range r.deletes {
+		b.WriteString(rule + "\n")
+	}
+	for _, chain := range r.chains {
+		rules := r.rules[chain]
+		if r.declared[chain] {
+			for _, rule := range rules {
+				b.WriteString("-A " + chain + " " + rule + "\n")
+			}
+			continue
+		}
+		position, ok := r.positions[chain]
+		if !ok {
+			position = 1
+		}
+		if position == 0 {
+			for _, rule := range rules {
+				b.WriteString("-A " + chain + " " + rule + "\n")
+			}
+			continue
+		}
+		for i := len(rules) - 1; i >= 0; i-- {
+			b.WriteString("-I " + chain + " " + strconv.Itoa(position) + " " + rules[i] + "\n")
+		}
+	}
+	b.WriteString("COMMIT\n")
+	for _, table := range r.tables {
+		b.Write(table.Bytes())
+	}
+	return b.Bytes()
+}
+
+// Apply applies the input with iptables-restore, waiting for the xtables lock when iptables-restore supports it
+func (r *IPTablesRestore) Apply() error {
+	args := []string{"--noflush"}
+	if iptablesRestoreWaits() {
+		args = append(args, "--wait")
+	}
+	_, err := ExecWithOptions(ExecOptions{Stdin: bytes.NewReader(r.Bytes())}, "iptables-restore", args...)
+	return err
+}
+
+var (
+	iptablesRestoreWaitOnce sync.Once
+	iptablesRestoreWait     bool
+)
+
+// iptablesRestoreWaits tells whether iptables-restore takes the --wait option, added in iptables 1.6.2
+func iptablesRestoreWaits() bool {
+	iptablesRestoreWaitOnce.Do(func() {
+		out, err := Exec("iptables", "--version")
+		if err != nil {
+			return
+		}
+		iptablesRestoreWait = iptablesVersionAtLeast(string(out), 1, 6, 2)
+	})
+	return iptablesRestoreWait
+}
+
+// iptablesVersionAtLeast parses the output of iptables --version, e.g. "iptables v1.8.4 (legacy)", and tells
+// whether the version is at least the given one
+func iptablesVersionAtLeast(version string, major, minor, patch int) bool {
+	var v [3]int
+	if _, err := fmt.Sscanf(strings.TrimSpace(version), "iptables v%d.%d.%d", &v[0], &v[1], &v[2]); err != nil {
+		return false
+	}
+	for i, want := range []int{major, minor, patch} {
+		if v[i] != want {
+			return v[i] > want
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// IPTablesSaveChain is a chain declared by iptables-save, with its policy, "-" for the chains not built in, and its
+// packet and byte counters
+type IPTablesSaveChain struct {
+	Name    string
+	Policy  string
+	Packets uint64
+	Bytes   uint64
+}
+
+// IPTablesSaveRule is a rule appended to a chain as reported by iptables-save or iptables -S
+type IPTablesSaveRule struct {
+	Chain   string
+	Comment string
+	// Target is the chain the rule jumps or goes to, or its built-in target, empty when the rule has none
+	Target string
+	Args   []string
+
+	// packet and byte counters of the rule, only reported by iptables-save -c
+	Packets uint64
+	Bytes   uint64
+}
+
+// IPTablesSaveTable is a table of the output of iptables-save, its chains and its rules in their order
+type IPTablesSaveTable struct {
+	Name   string
+	Chains []IPTablesSaveChain
+	Rules  []IPTablesSaveRule
+}
+
+// ParseIPTablesRule parses a rule line of iptables-save, with or without its counters, or of iptables -S. It returns
+// false for the other lines.
+func ParseIPTablesRule(line string) (IPTablesSaveRule, bool, error) {
+	var rule IPTablesSaveRule
+	if strings.HasPrefix(line, "[") {
+		end := strings.Index(line, "] ")
+		if end < 0 {
+			return rule, false, fmt.Errorf("Failed to parse iptables-save line: %s", line)
+		}
+		if _, err := fmt.Sscanf(line[1:end], "%d:%d", &rule.Packets, &rule.Bytes); err != nil {
+			return rule, false, fmt.Errorf("Failed to parse counters of iptables-save line: %s", line)
+		}
+		line = line[end+2:]
+	}
+	if !strings.HasPrefix(line, "-A ") {
+		return rule, false, nil
+	}
+	args, err := SplitIPTablesArgs(line)
+	if err != nil {
+		return rule, false, err
+	}
+	if len(args) < 2 {
+		return rule, false, fmt.Errorf("Failed to parse iptables-save line: %s", line)
+	}
+	rule.Chain, rule.Args = args[1], args[2:]
+	for i := 0; i < len(rule.Args)-1; i++ {
+		switch rule.Args[i] {
+		case "--comment":
+			rule.Comment = rule.Args[i+1]
+		case "-j", "-g":
+			rule.Target = rule.Args[i+1]
+		}
+	}
+	return rule, true, nil
+}
+
+// ParseIPTablesSave parses the output of iptables-save, with or without counters, and returns its tables in the order
+// they are found. The rules found outside of a table go into a table with no name.
+func ParseIPTablesSave(r io.Reader) ([]IPTablesSaveTable, error) {
+	tables := make([]IPTablesSaveTable, 0)
+	var table *IPTablesSaveTable
+	current := func() *IPTablesSaveTable {
+		if table == nil {
+			tables = append(tables, IPTablesSaveTable{})
+			table = &tables[len(tables)-1]
+		}
+		return table
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "*"):
+			tables = append(tables, IPTablesSaveTable{Name: line[1:]})
+			table = &tables[len(tables)-1]
+		case line == "COMMIT":
+			table = nil
+		case strings.HasPrefix(line, ":"):
+			chain := IPTablesSaveChain{}
+			fields := strings.Fields(line[1:])
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("Failed to parse iptables-save line: %s", line)
+			}
+			chain.Name, chain.Policy = fields[0], fields[1]
+			if _, err := fmt.Sscanf(fields[2], "[%d:%d]", &chain.Packets, &chain.Bytes); err != nil {
+				return nil, fmt.Errorf("Failed to parse counters of iptables-save line: %s", line)
+			}
+			current().Chains = append(current().Chains, chain)
+		default:
+			rule, ok, err := ParseIPTablesRule(line)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("Failed to parse iptables-save line: %s", line)
+			}
+			current().Rules = append(current().Rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// String returns the rule as listed by iptables -S, without its counters
+func (r IPTablesSaveRule) String() string {
+	return JoinIPTablesArgs(append([]string{"-A", r.Chain}, r.Args...))
+}
+
+// ChainRules returns the rules of the chain of the table, in their order
+func (t IPTablesSaveTable) ChainRules(chain string) []IPTablesSaveRule {
+	rules := make([]IPTablesSaveRule, 0)
+	for _, rule := range t.Rules {
+		if rule.Chain == chain {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// WriteIPTablesSave writes the tables in the format of iptables-save, the counters of the rules only when counters
+// is set, like iptables-save -c. The output is accepted by iptables-restore.
+func WriteIPTablesSave(w io.Writer, tables []IPTablesSaveTable, counters bool) error {
+	bw := bufio.NewWriter(w)
+	for _, table := range tables {
+		fmt.Fprintf(bw, "*%s\n", table.Name)
+		for _, chain := range table.Chains {
+			fmt.Fprintf(bw, ":%s %s [%d:%d]\n", chain.Name, chain.Policy, chain.Packets, chain.Bytes)
+		}
+		for _, rule := range table.Rules {
+			if counters {
+				fmt.Fprintf(bw, "[%d:%d] ", rule.Packets, rule.Bytes)
+			}
+			fmt.Fprintf(bw, "%s\n", rule)
+		}
+		fmt.Fprintf(bw, "COMMIT\n")
+	}
+	return bw.Flush()
+}
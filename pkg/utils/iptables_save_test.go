@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIPTablesSave(t *testing.T) {
+	save := `# Generated by iptables-save v1.6.2
+*filter
+:INPUT ACCEPT [0:0]
+:KUBE-POD-FW-ABCDEFGHIJKLMNOP - [0:0]
+-A INPUT -s 10.1.0.5/32 -m comment --comment "rule to jump traffic from POD name:web namespace: default to chain KUBE-POD-FW-ABCDEFGHIJKLMNOP" -m mark ! --mark 0x4000/0x4000 -j KUBE-POD-FW-ABCDEFGHIJKLMNOP
+-A KUBE-POD-FW-ABCDEFGHIJKLMNOP -m comment --comment "say \"hi\"" -j REJECT --reject-with icmp-port-unreachable
+-A FORWARD -j ACCEPT
+COMMIT
+`
+	tables, err := ParseIPTablesSave(strings.NewReader(save))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tables) != 1 || tables[0].Name != "filter" || len(tables[0].Rules) != 3 {
+		t.Fatalf("expected the filter table with 3 rules but got %+v", tables)
+	}
+	expectedChains := []IPTablesSaveChain{{Name: "INPUT", Policy: "ACCEPT"}, {Name: "KUBE-POD-FW-ABCDEFGHIJKLMNOP", Policy: "-"}}
+	if !reflect.DeepEqual(tables[0].Chains, expectedChains) {
+		t.Errorf("expected chains %+v but got %+v", expectedChains, tables[0].Chains)
+	}
+
+	expected := []IPTablesSaveRule{
+		{Chain: "INPUT", Comment: "rule to jump traffic from POD name:web namespace: default to chain KUBE-POD-FW-ABCDEFGHIJKLMNOP", Target: "KUBE-POD-FW-ABCDEFGHIJKLMNOP"},
+		{Chain: "KUBE-POD-FW-ABCDEFGHIJKLMNOP", Comment: `say "hi"`, Target: "REJECT"},
+		{Chain: "FORWARD", Target: "ACCEPT"},
+	}
+	for i, rule := range tables[0].Rules {
+		rule.Args = nil
+		if !reflect.DeepEqual(rule, expected[i]) {
+			t.Errorf("expected rule %+v but got %+v", expected[i], rule)
+		}
+	}
+	if rules := tables[0].ChainRules("FORWARD"); len(rules) != 1 || rules[0].Target != "ACCEPT" {
+		t.Errorf("expected the rule of the FORWARD chain but got %+v", rules)
+	}
+
+	tables, err = ParseIPTablesSave(strings.NewReader("*filter\n:INPUT ACCEPT [10:800]\n[42:3360] -A KUBE-NWPLCY-ABCDEFGHIJKLMNOP -j ACCEPT\nCOMMIT\n"))
+	if err != nil {
+		t.Fatalf("unexpected error parsing counters: %v", err)
+	}
+	if len(tables) != 1 || tables[0].Chains[0].Packets != 10 || tables[0].Chains[0].Bytes != 800 {
+		t.Fatalf("expected a chain with 10 packets and 800 bytes but got %+v", tables)
+	}
+	rules := tables[0].Rules
+	if len(rules) != 1 || rules[0].Chain != "KUBE-NWPLCY-ABCDEFGHIJKLMNOP" || rules[0].Packets != 42 || rules[0].Bytes != 3360 {
+		t.Errorf("expected a rule with 42 packets and 3360 bytes but got %+v", rules)
+	}
+
+	for _, invalid := range []string{
+		`-A INPUT -m comment --comment "unterminated`,
+		"*filter\n:INPUT ACCEPT\nCOMMIT\n",
+		"*filter\n-N KUBE-POD-FW-ABCDEFGHIJKLMNOP\nCOMMIT\n",
+	} {
+		if _, err := ParseIPTablesSave(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestWriteIPTablesSave(t *testing.T) {
+	save := `*filter
+:FORWARD ACCEPT [7:420]
+:KUBE-POD-FW-ABCDEFGHIJKLMNOP - [0:0]
+[3:180] -A FORWARD -d 10.1.0.5/32 -m comment --comment "rule to jump traffic destined to POD name:web namespace: default" -j KUBE-POD-FW-ABCDEFGHIJKLMNOP
+[0:0] -A KUBE-POD-FW-ABCDEFGHIJKLMNOP -m comment --comment "say \"hi\"" -j REJECT --reject-with icmp-port-unreachable
+COMMIT
+*nat
+:POSTROUTING ACCEPT [0:0]
+[1:60] -A POSTROUTING -m ipvs --ipvs --vdir ORIGINAL --vmethod MASQ -j SNAT --to-source 10.10.10.10
+COMMIT
+`
+	tables, err := ParseIPTablesSave(strings.NewReader(save))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteIPTablesSave(&buf, tables, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != save {
+		t.Errorf("expected the tables written as parsed:\n%s\ngot:\n%s", save, buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteIPTablesSave(&buf, tables[1:], false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "*nat\n:POSTROUTING ACCEPT [0:0]\n-A POSTROUTING -m ipvs --ipvs --vdir ORIGINAL --vmethod MASQ -j SNAT --to-source 10.10.10.10\nCOMMIT\n"
+	if buf.String() != expected {
+		t.Errorf("expected the rules written without their counters:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestParseIPTablesRule(t *testing.T) {
+	// the comment names a chain the rule does not jump to
+	rule, ok, err := ParseIPTablesRule(`-A FORWARD -m comment --comment "KUBE-POD-FW-AAAA -j KUBE-NWPLCY-BBBB" -j ACCEPT`)
+	if err != nil || !ok {
+		t.Fatalf("unexpected error parsing the rule: %v", err)
+	}
+	if rule.Chain != "FORWARD" || rule.Target != "ACCEPT" || rule.Comment != "KUBE-POD-FW-AAAA -j KUBE-NWPLCY-BBBB" {
+		t.Errorf("expected a rule of the FORWARD chain accepting the traffic, got %+v", rule)
+	}
+	if rule.String() != `-A FORWARD -m comment --comment "KUBE-POD-FW-AAAA -j KUBE-NWPLCY-BBBB" -j ACCEPT` {
+		t.Errorf("unexpected rule %s", rule)
+	}
+
+	if rule, ok, err = ParseIPTablesRule("-A KUBE-QRNT-AAAA -g KUBE-NWPLCY-BBBB"); err != nil || !ok || rule.Target != "KUBE-NWPLCY-BBBB" {
+		t.Errorf("expected a rule going to KUBE-NWPLCY-BBBB, got %+v", rule)
+	}
+	for _, line := range []string{"-P FORWARD ACCEPT", "-N KUBE-POD-FW-AAAA", ""} {
+		if _, ok, err := ParseIPTablesRule(line); ok || err != nil {
+			t.Errorf("expected %q not to be a rule", line)
+		}
+	}
+}
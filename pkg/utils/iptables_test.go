@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPTablesRuleSpec(t *testing.T) {
+	spec, err := IPTablesRuleSpec(`-A FORWARD -m comment --comment "rule to jump traffic destined to POD name:web namespace: default to chain KUBE-POD-FW-AAAA" -d 10.1.0.5/32 -j KUBE-POD-FW-AAAA`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"-m", "comment", "--comment",
+		"rule to jump traffic destined to POD name:web namespace: default to chain KUBE-POD-FW-AAAA",
+		"-d", "10.1.0.5/32", "-j", "KUBE-POD-FW-AAAA"}
+	if !reflect.DeepEqual(spec, expected) {
+		t.Errorf("expected %q, got %q", expected, spec)
+	}
+
+	for _, line := range []string{"-P FORWARD ACCEPT", "-N KUBE-POD-FW-AAAA"} {
+		if spec, err := IPTablesRuleSpec(line); err != nil || spec != nil {
+			t.Errorf("expected no rule specification for %s, got %q, %v", line, spec, err)
+		}
+	}
+
+	if _, err := IPTablesRuleSpec(`-A FORWARD -m comment --comment "unterminated`); err == nil {
+		t.Errorf("expected an error for an unterminated quote")
+	}
+}
+
+func TestIPTablesRestore(t *testing.T) {
+	r := NewIPTablesRestore("filter")
+	r.NewChain("KUBE-POD-FW-AAAA")
+	r.AppendUnique("KUBE-POD-FW-AAAA", "-m", "comment", "--comment", "default rule", "-j", "REJECT")
+	r.InsertUnique("KUBE-POD-FW-AAAA", "-j", "KUBE-NWPLCY-BBBB")
+	r.InsertUnique("KUBE-POD-FW-AAAA", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT")
+	r.InsertUnique("KUBE-POD-FW-AAAA", "-j", "KUBE-NWPLCY-BBBB")
+	r.InsertUnique("FORWARD", "-d", "10.1.0.5", "-j", "KUBE-POD-FW-AAAA")
+	r.InsertUnique("FORWARD", "-s", "10.1.0.5", "-j", "KUBE-POD-FW-AAAA")
+
+	expected := `*filter
+:KUBE-POD-FW-AAAA - [0:0]
+-A KUBE-POD-FW-AAAA -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A KUBE-POD-FW-AAAA -j KUBE-NWPLCY-BBBB
+-A KUBE-POD-FW-AAAA -m comment --comment "default rule" -j REJECT
+-I FORWARD 1 -d 10.1.0.5 -j KUBE-POD-FW-AAAA
+-I FORWARD 1 -s 10.1.0.5 -j KUBE-POD-FW-AAAA
+COMMIT
+`
+	if got := string(r.Bytes()); got != expected {
+		t.Errorf("expected iptables-restore input:\n%s\ngot:\n%s", expected, got)
+	}
+	if r.Rules() != 5 {
+		t.Errorf("expected 5 rules, got %d", r.Rules())
+	}
+
+	r = NewIPTablesRestore("filter")
+	r.SetRulePosition("FORWARD", 3)
+	r.SetRulePosition("OUTPUT", 0)
+	for _, chain := range []string{"FORWARD", "OUTPUT"} {
+		r.InsertUnique(chain, "-d", "10.1.0.5", "-j", "KUBE-POD-FW-AAAA")
+		r.InsertUnique(chain, "-s", "10.1.0.5", "-j", "KUBE-POD-FW-AAAA")
+	}
+	r.DeleteRule("FORWARD", "-m", "comment", "--comment", "moved jump", "-j", "KUBE-ROUTER-FORWARD")
+	mangle := NewIPTablesRestore("mangle")
+	mangle.NewChain("KUBE-ROUTER-ACCEPT-LOG")
+	mangle.AppendUnique("KUBE-ROUTER-ACCEPT-LOG", "-d", "10.1.0.5", "-j", "NFLOG")
+	r.AddTable(mangle)
+	expected = `*filter
+-D FORWARD -m comment --comment "moved jump" -j KUBE-ROUTER-FORWARD
+-I FORWARD 3 -d 10.1.0.5 -j KUBE-POD-FW-AAAA
+-I FORWARD 3 -s 10.1.0.5 -j KUBE-POD-FW-AAAA
+-A OUTPUT -s 10.1.0.5 -j KUBE-POD-FW-AAAA
+-A OUTPUT -d 10.1.0.5 -j KUBE-POD-FW-AAAA
+COMMIT
+*mangle
+:KUBE-ROUTER-ACCEPT-LOG - [0:0]
+-A KUBE-ROUTER-ACCEPT-LOG -d 10.1.0.5 -j NFLOG
+COMMIT
+`
+	if got := string(r.Bytes()); got != expected {
+		t.Errorf("expected iptables-restore input:\n%s\ngot:\n%s", expected, got)
+	}
+	if r.Rules() != 5 {
+		t.Errorf("expected 5 rules, got %d", r.Rules())
+	}
+
+	args := []string{"-m", "comment", "--comment", `pod "web" \ default`, "--comment", "", "-j", "ACCEPT"}
+	if split, err := SplitIPTablesArgs(JoinIPTablesArgs(args)); err != nil || !reflect.DeepEqual(split, args) {
+		t.Errorf("expected %q back from the joined arguments, got %q, %v", args, split, err)
+	}
+}
+
+func TestIPTablesRestoreFailedChain(t *testing.T) {
+	r := NewIPTablesRestore("filter")
+	r.NewChain("KUBE-NWPLCY-AAAA")
+	r.NewChain("KUBE-NWPLCY-BBBB")
+	r.AppendUnique("KUBE-NWPLCY-AAAA", "-j", "ACCEPT")
+	r.AppendUnique("KUBE-NWPLCY-BBBB", "-m", "bogus", "-j", "ACCEPT")
+	r.InsertUnique("FORWARD", "-j", "KUBE-NWPLCY-AAAA")
+
+	for stderr, chain := range map[string]string{
+		"iptables-restore: line 5 failed": "KUBE-NWPLCY-BBBB",
+		"iptables-restore v1.8.7 (nf_tables): Couldn't load match `bogus'\n\nError occurred at line: 6": "FORWARD",
+		"iptables-restore: line 2 failed":                   "KUBE-NWPLCY-AAAA",
+		"iptables-restore: line 42 failed":                  "",
+		"Another app is currently holding the xtables lock": "",
+	} {
+		err := &ExecError{Command: "iptables-restore", ExitCode: 1, Stderr: stderr}
+		if got := r.FailedChain(err); got != chain {
+			t.Errorf("expected chain %q for %q, got %q", chain, stderr, got)
+		}
+	}
+
+	if !r.FlushChain("KUBE-NWPLCY-BBBB") || r.FlushChain("KUBE-NWPLCY-BBBB") {
+		t.Errorf("expected the rules of the chain flushed once")
+	}
+	r.AppendUnique("KUBE-NWPLCY-BBBB", "-j", "RETURN")
+	expected := `*filter
+:KUBE-NWPLCY-AAAA - [0:0]
+:KUBE-NWPLCY-BBBB - [0:0]
+-A KUBE-NWPLCY-AAAA -j ACCEPT
+-A KUBE-NWPLCY-BBBB -j RETURN
+-I FORWARD 1 -j KUBE-NWPLCY-AAAA
+COMMIT
+`
+	if got := string(r.Bytes()); got != expected {
+		t.Errorf("expected iptables-restore input:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestIPTablesVersionAtLeast(t *testing.T) {
+	for version, expected := range map[string]bool{
+		"iptables v1.6.1\n":             false,
+		"iptables v1.6.2\n":             true,
+		"iptables v1.8.4 (nf_tables)\n": true,
+		"iptables v1.4.21":              false,
+		"not iptables":                  false,
+	} {
+		if got := iptablesVersionAtLeast(version, 1, 6, 2); got != expected {
+			t.Errorf("expected %v for %q, got %v", expected, version, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/pager"
+)
+
+// period of the updates of the informer cache size metrics
+var informerMetricsPeriod = 1 * time.Minute
+
+// boundedListWatch wraps the ListerWatcher of an informer to list in pages and transform the objects before the
+// informer caches them
+type boundedListWatch struct {
+	lw        cache.ListerWatcher
+	pageSize  int64
+	transform func(runtime.Object)
+}
+
+// NewBoundedListWatch wraps the ListerWatcher of an informer. With a pageSize, the lists are read from the API server
+// in pages of at most pageSize objects rather than in a single response. The lists of the informers are otherwise
+// served from the watch cache of the API server, which ignores the page size, so the paged lists are read from etcd.
+// The objects of the lists and of the watch events are passed to transform, when not nil, which may strip the fields
+// the controllers do not read before the informer caches them.
+func NewBoundedListWatch(lw cache.ListerWatcher, pageSize int64, transform func(runtime.Object)) cache.ListerWatcher {
+	return &boundedListWatch{lw: lw, pageSize: pageSize, transform: transform}
+}
+
+func (blw *boundedListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	if blw.pageSize <= 0 {
+		return blw.list(options)
+	}
+	if options.ResourceVersion == "0" {
+		options.ResourceVersion = ""
+	}
+	// each page is transformed before the next one is read, so only one page holds the fields stripped
+	p := pager.New(pager.SimplePageFunc(blw.list))
+	p.PageSize = blw.pageSize
+	list, _, err := p.List(context.Background(), options)
+	return list, err
+}
+
+// list reads a list, or a page of it, and transforms its objects
+func (blw *boundedListWatch) list(options metav1.ListOptions) (runtime.Object, error) {
+	list, err := blw.lw.List(options)
+	if err != nil || blw.transform == nil {
+		return list, err
+	}
+	err = meta.EachListItem(list, func(obj runtime.Object) error {
+		blw.transform(obj)
+		return nil
+	})
+	return list, err
+}
+
+func (blw *boundedListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := blw.lw.Watch(options)
+	if err != nil || blw.transform == nil {
+		return w, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		if event.Type != watch.Error {
+			blw.transform(event.Object)
+		}
+		return event, true
+	}), nil
+}
+
+// RunInformerMetrics exports the number of objects cached by the informers, by resource, and the size of their
+// protobuf encoding, an estimate of the memory they hold, every minute until stopCh is closed
+func RunInformerMetrics(informers map[string]cache.SharedIndexInformer, stopCh <-chan struct{}) {
+	t := time.NewTicker(informerMetricsPeriod)
+	defer t.Stop()
+	for {
+		for resource, informer := range informers {
+			objects, size := informerCacheSize(informer.GetStore())
+			metrics.ControllerInformerObjects.WithLabelValues(resource).Set(float64(objects))
+			metrics.ControllerInformerCacheBytes.WithLabelValues(resource).Set(float64(size))
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// informerCacheSize returns the number of objects of the store and the size of their protobuf encoding
+func informerCacheSize(store cache.Store) (int, int) {
+	objects := store.List()
+	size := 0
+	for _, obj := range objects {
+		if sized, ok := obj.(interface{ Size() int }); ok {
+			size += sized.Size()
+		}
+	}
+	return len(objects), size
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newPod(name string) v1core.Pod {
+	return v1core.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1core.PodSpec{Volumes: []v1core.Volume{{Name: "data"}}}}
+}
+
+func TestBoundedListWatch(t *testing.T) {
+	pods := []v1core.Pod{newPod("a"), newPod("b"), newPod("c")}
+	var requests []metav1.ListOptions
+	fakeWatch := watch.NewFake()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			requests = append(requests, options)
+			start := 0
+			if options.Continue != "" {
+				start = int(options.Continue[0] - '0')
+			}
+			end := len(pods)
+			if options.Limit > 0 && start+int(options.Limit) < end {
+				end = start + int(options.Limit)
+			}
+			list := &v1core.PodList{ListMeta: metav1.ListMeta{ResourceVersion: "10"},
+				Items: append([]v1core.Pod(nil), pods[start:end]...)}
+			if end < len(pods) {
+				list.Continue = string(rune('0' + end))
+			}
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return fakeWatch, nil
+		},
+	}
+	strip := func(obj runtime.Object) {
+		obj.(*v1core.Pod).Spec.Volumes = nil
+	}
+
+	list, err := NewBoundedListWatch(lw, 2, strip).List(metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		t.Fatalf("unexpected list error: %s", err)
+	}
+	if len(requests) != 2 || requests[0].Limit != 2 || requests[0].ResourceVersion != "" ||
+		requests[1].Continue != "2" {
+		t.Errorf("expected 2 pages of 2 pods read from etcd, got the requests %+v", requests)
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		t.Fatalf("failed to extract the items of the list: %s", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("expected the 3 pods, got %d", len(items))
+	}
+	for _, item := range items {
+		if pod := item.(*v1core.Pod); pod.Spec.Volumes != nil {
+			t.Errorf("expected the volumes of pod %s to be stripped", pod.Name)
+		}
+	}
+	if accessor, err := meta.ListAccessor(list); err != nil || accessor.GetResourceVersion() != "10" {
+		t.Errorf("expected the resource version of the list, got %v", accessor)
+	}
+
+	requests = nil
+	if _, err := NewBoundedListWatch(lw, 0, nil).List(metav1.ListOptions{ResourceVersion: "0"}); err != nil {
+		t.Fatalf("unexpected list error: %s", err)
+	}
+	if len(requests) != 1 || requests[0].Limit != 0 || requests[0].ResourceVersion != "0" {
+		t.Errorf("expected a single request to the watch cache without page size, got %+v", requests)
+	}
+
+	w, err := NewBoundedListWatch(lw, 2, strip).Watch(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected watch error: %s", err)
+	}
+	defer w.Stop()
+	pod := newPod("d")
+	go fakeWatch.Add(&pod)
+	event := <-w.ResultChan()
+	if event.Type != watch.Added || event.Object.(*v1core.Pod).Spec.Volumes != nil {
+		t.Errorf("expected the pod added with its volumes stripped, got %+v", event)
+	}
+}
+
+func TestInformerCacheSize(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	expected := 0
+	for _, name := range []string{"a", "b"} {
+		pod := newPod(name)
+		expected += pod.Size()
+		if err := store.Add(&pod); err != nil {
+			t.Fatalf("failed to add pod: %s", err)
+		}
+	}
+	if objects, size := informerCacheSize(store); objects != 2 || size != expected {
+		t.Errorf("expected 2 objects of %d bytes, got %d objects of %d bytes", expected, objects, size)
+	}
+}
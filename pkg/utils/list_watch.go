@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// operations of the informers reported by the watch error metric
+const (
+	watchOperationList    = "list"
+	watchOperationWatch   = "watch"
+	watchOperationEvent   = "watch_event"
+	watchOperationExpired = "watch_expired"
+)
+
+var (
+	// delays before retrying a list or watch after consecutive failures, the reflector of the informer retries
+	// every second otherwise
+	watchBackoffInitial = 1 * time.Second
+	watchBackoffMax     = 1 * time.Minute
+	// a watch still open this long after the timeout requested to the API server is abandoned, the connection
+	// is likely dead without having been closed
+	watchExpiryGrace = 1 * time.Minute
+)
+
+// instrumentedListWatch wraps the ListerWatcher of an informer to report list and watch failures, back off
+// while they fail, and abandon watches the API server stopped answering
+type instrumentedListWatch struct {
+	resource string
+	lw       cache.ListerWatcher
+
+	mu       sync.Mutex
+	failures int
+}
+
+// NewInstrumentedListWatch wraps the ListerWatcher of an informer of resource. Failures are logged and counted
+// in the controller_informer_watch_errors metric, and retried with an exponential backoff. Watches still open
+// after the timeout requested to the API server are stopped, so the informer lists again instead of waiting for
+// events forever.
+func NewInstrumentedListWatch(resource string, lw cache.ListerWatcher) cache.ListerWatcher {
+	return &instrumentedListWatch{resource: resource, lw: lw}
+}
+
+func (ilw *instrumentedListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	ilw.backoff()
+	list, err := ilw.lw.List(options)
+	if err != nil {
+		ilw.failed(watchOperationList, err)
+		return nil, err
+	}
+	ilw.succeeded()
+	return list, nil
+}
+
+func (ilw *instrumentedListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	ilw.backoff()
+	w, err := ilw.lw.Watch(options)
+	if err != nil {
+		ilw.failed(watchOperationWatch, err)
+		return nil, err
+	}
+	ilw.succeeded()
+	var expiry time.Duration
+	if options.TimeoutSeconds != nil {
+		expiry = time.Duration(*options.TimeoutSeconds)*time.Second + watchExpiryGrace
+	}
+	return newExpiringWatch(w, expiry, ilw.failed), nil
+}
+
+// backoff waits before retrying after consecutive failures
+func (ilw *instrumentedListWatch) backoff() {
+	ilw.mu.Lock()
+	failures := ilw.failures
+	ilw.mu.Unlock()
+	if failures == 0 {
+		return
+	}
+	time.Sleep(watchBackoff(failures))
+}
+
+func watchBackoff(failures int) time.Duration {
+	delay := watchBackoffInitial
+	for i := 1; i < failures && delay < watchBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > watchBackoffMax {
+		delay = watchBackoffMax
+	}
+	return delay
+}
+
+func (ilw *instrumentedListWatch) failed(operation string, err error) {
+	ilw.mu.Lock()
+	ilw.failures++
+	failures := ilw.failures
+	ilw.mu.Unlock()
+	metrics.ControllerInformerWatchErrors.WithLabelValues(ilw.resource, operation).Inc()
+	metrics.ControllerInformerWatchHealthy.WithLabelValues(ilw.resource).Set(0)
+	staleness.failed(ilw.resource)
+	glog.Errorf("Informer of %s failed on %s (%d consecutive failures, retrying in %s): %s", ilw.resource,
+		operation, failures, watchBackoff(failures), err)
+}
+
+func (ilw *instrumentedListWatch) succeeded() {
+	ilw.mu.Lock()
+	recovered := ilw.failures > 0
+	ilw.failures = 0
+	ilw.mu.Unlock()
+	metrics.ControllerInformerWatchHealthy.WithLabelValues(ilw.resource).Set(1)
+	staleness.succeeded(ilw.resource)
+	if recovered {
+		glog.Infof("Informer of %s recovered", ilw.resource)
+	}
+}
+
+// expiringWatch forwards the events of a watch, reporting the error events, until it is stopped or expires
+type expiringWatch struct {
+	w        watch.Interface
+	result   chan watch.Event
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// newExpiringWatch forwards the events of w. The watch is stopped after expiry, unless expiry is 0, and failed is
+// called for the error events and on expiry.
+func newExpiringWatch(w watch.Interface, expiry time.Duration, failed func(operation string, err error)) watch.Interface {
+	ew := &expiringWatch{w: w, result: make(chan watch.Event), stopCh: make(chan struct{})}
+	go ew.run(expiry, failed)
+	return ew
+}
+
+func (ew *expiringWatch) run(expiry time.Duration, failed func(operation string, err error)) {
+	defer close(ew.result)
+	defer ew.w.Stop()
+	var expired <-chan time.Time
+	if expiry > 0 {
+		timer := time.NewTimer(expiry)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case event, ok := <-ew.w.ResultChan():
+			if !ok {
+				return
+			}
+			if event.Type == watch.Error {
+				// an expired resource version only makes the informer list again
+				err := apierrors.FromObject(event.Object)
+				if !apierrors.IsGone(err) && !apierrors.IsResourceExpired(err) {
+					failed(watchOperationEvent, err)
+				}
+			}
+			select {
+			case ew.result <- event:
+			case <-ew.stopCh:
+				return
+			}
+		case <-expired:
+			failed(watchOperationExpired, errWatchExpired{expiry})
+			return
+		case <-ew.stopCh:
+			return
+		}
+	}
+}
+
+func (ew *expiringWatch) Stop() {
+	ew.stopOnce.Do(func() { close(ew.stopCh) })
+}
+
+func (ew *expiringWatch) ResultChan() <-chan watch.Event {
+	return ew.result
+}
+
+type errWatchExpired struct {
+	expiry time.Duration
+}
+
+func (e errWatchExpired) Error() string {
+	return "watch still open " + e.expiry.String() + " after it started, the API server stopped answering"
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	v1core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestWatchBackoff(t *testing.T) {
+	for failures, expected := range map[int]time.Duration{
+		1:  watchBackoffInitial,
+		2:  2 * watchBackoffInitial,
+		4:  8 * watchBackoffInitial,
+		20: watchBackoffMax,
+	} {
+		if got := watchBackoff(failures); got != expected {
+			t.Errorf("expected a backoff of %s after %d failures, got %s", expected, failures, got)
+		}
+	}
+}
+
+func TestInstrumentedListWatch(t *testing.T) {
+	watchBackoffInitial = time.Millisecond
+	watchExpiryGrace = 10 * time.Millisecond
+
+	var listErr error
+	fakeWatch := watch.NewFake()
+	lw := NewInstrumentedListWatch("pods", &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return &v1core.PodList{}, listErr
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return fakeWatch, nil
+		},
+	}).(*instrumentedListWatch)
+
+	listErr = errors.New("connection refused")
+	for i := 0; i < 3; i++ {
+		if _, err := lw.List(metav1.ListOptions{}); err == nil {
+			t.Fatalf("expected the list error to be returned")
+		}
+	}
+	if lw.failures != 3 {
+		t.Errorf("expected 3 consecutive failures, got %d", lw.failures)
+	}
+	listErr = nil
+	if _, err := lw.List(metav1.ListOptions{}); err != nil {
+		t.Fatalf("unexpected list error: %s", err)
+	}
+	if lw.failures != 0 {
+		t.Errorf("expected the failures to be reset, got %d", lw.failures)
+	}
+
+	// error events are forwarded to the informer and counted, except for expired resource versions
+	w, err := lw.Watch(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected watch error: %s", err)
+	}
+	go func() {
+		fakeWatch.Error(&apierrors.NewResourceExpired("too old resource version").ErrStatus)
+		fakeWatch.Error(&apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("denied")).ErrStatus)
+	}()
+	for i := 0; i < 2; i++ {
+		if event := <-w.ResultChan(); event.Type != watch.Error {
+			t.Errorf("expected an error event, got %v", event.Type)
+		}
+	}
+	w.Stop()
+	if _, ok := <-w.ResultChan(); ok {
+		t.Errorf("expected the result channel to be closed once stopped")
+	}
+	if lw.failures != 1 {
+		t.Errorf("expected only the forbidden error to be counted, got %d failures", lw.failures)
+	}
+
+	// watches still open after the requested timeout are abandoned
+	fakeWatch = watch.NewFake()
+	timeout := int64(0)
+	w, err = lw.Watch(metav1.ListOptions{TimeoutSeconds: &timeout})
+	if err != nil {
+		t.Fatalf("unexpected watch error: %s", err)
+	}
+	select {
+	case _, ok := <-w.ResultChan():
+		if ok {
+			t.Errorf("expected the result channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the watch to expire")
+	}
+	if !fakeWatch.IsStopped() {
+		t.Errorf("expected the expired watch to be stopped")
+	}
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+	"golang.org/x/sys/unix"
+
+	v1core "k8s.io/api/core/v1"
+)
+
+const (
+	loadGovernorSignalLoad = "load"
+	loadGovernorSignalLock = "iptables_lock"
+
+	syncThrottledReason      = "SyncThrottled"
+	syncThrottleLiftedReason = "SyncThrottleLifted"
+)
+
+// paths read by the governor, and sampling intervals, overridden by the tests
+var (
+	loadAvgPath      = "/proc/loadavg"
+	xtablesLockPath  = "/run/xtables.lock"
+	loadGovernorTick = 1 * time.Second
+	// the node is evaluated once per window from the samples taken during the window
+	loadGovernorWindow = 1 * time.Minute
+)
+
+// LoadGovernorConfig are the thresholds above which the load governor finds the node overloaded
+type LoadGovernorConfig struct {
+	// 1 minute load average per CPU
+	LoadThreshold float64
+	// fraction of the samples finding the iptables lock held
+	LockThreshold float64
+	// maximum factor the sync periods are stretched by
+	MaxStretch int
+}
+
+// LoadGovernor stretches the periodic sync periods of the controllers while the node is overloaded, shown by a high
+// load average or contention on the iptables lock. The stretch factor doubles after each overloaded window, up to
+// the maximum, and halves back after each window the node recovered.
+type LoadGovernor struct {
+	config LoadGovernorConfig
+	events *EventSink
+
+	mu      sync.Mutex
+	stretch int
+}
+
+// NewLoadGovernor returns a load governor recording events about its throttling to the sink
+func NewLoadGovernor(config LoadGovernorConfig, events *EventSink) (*LoadGovernor, error) {
+	if config.LoadThreshold <= 0 || config.LockThreshold <= 0 || config.LockThreshold > 1 {
+		return nil, fmt.Errorf("invalid load governor thresholds, the load threshold must be positive and the " +
+			"iptables lock threshold between 0 and 1")
+	}
+	if config.MaxStretch < 2 {
+		return nil, fmt.Errorf("invalid load governor maximum stretch %d, must be at least 2", config.MaxStretch)
+	}
+	metrics.ControllerSyncStretchFactor.Set(1)
+	return &LoadGovernor{config: config, events: events, stretch: 1}, nil
+}
+
+// Run samples the load of the node until stopCh is closed
+func (g *LoadGovernor) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(loadGovernorTick)
+	defer ticker.Stop()
+	var loadSum float64
+	var samples, lockHeld, loadSamples int
+	windowStart := time.Now()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		if load, err := readLoadAverage(); err == nil {
+			loadSum += load
+			loadSamples++
+		} else {
+			glog.V(2).Infof("Failed to read the load average: %s", err)
+		}
+		if xtablesLockHeld() {
+			lockHeld++
+		}
+		samples++
+		if time.Since(windowStart) < loadGovernorWindow {
+			continue
+		}
+		var load float64
+		if loadSamples > 0 {
+			load = loadSum / float64(loadSamples) / float64(runtime.NumCPU())
+		}
+		g.evaluate(load, float64(lockHeld)/float64(samples))
+		loadSum, samples, lockHeld, loadSamples = 0, 0, 0, 0
+		windowStart = time.Now()
+	}
+}
+
+// evaluate adjusts the stretch factor from the average load per CPU and the iptables lock contention of a window
+func (g *LoadGovernor) evaluate(load, lockContention float64) {
+	loadOverloaded := load > g.config.LoadThreshold
+	lockOverloaded := lockContention > g.config.LockThreshold
+	metrics.ControllerLoadGovernorOverloaded.WithLabelValues(loadGovernorSignalLoad).Set(boolToFloat(loadOverloaded))
+	metrics.ControllerLoadGovernorOverloaded.WithLabelValues(loadGovernorSignalLock).Set(boolToFloat(lockOverloaded))
+
+	g.mu.Lock()
+	previous := g.stretch
+	if loadOverloaded || lockOverloaded {
+		g.stretch *= 2
+		if g.stretch > g.config.MaxStretch {
+			g.stretch = g.config.MaxStretch
+		}
+	} else if g.stretch > 1 {
+		g.stretch /= 2
+	}
+	stretch := g.stretch
+	g.mu.Unlock()
+	if stretch == previous {
+		return
+	}
+
+	metrics.ControllerSyncStretchFactor.Set(float64(stretch))
+	if stretch > previous {
+		glog.Warningf("Periodic syncs stretched by %d (load per CPU %.2f, iptables lock held %.0f%% of the time)",
+			stretch, load, lockContention*100)
+	} else {
+		glog.Infof("Periodic syncs stretch lowered to %d as the node recovers", stretch)
+	}
+	var eventType, reason, message string
+	switch {
+	case previous == 1:
+		eventType, reason = v1core.EventTypeWarning, syncThrottledReason
+		message = fmt.Sprintf("kube-router stretches its periodic syncs as the node is overloaded: load per CPU "+
+			"%.2f, iptables lock held %.0f%% of the time", load, lockContention*100)
+	case stretch == 1:
+		eventType, reason = v1core.EventTypeNormal, syncThrottleLiftedReason
+		message = "kube-router resumes its periodic syncs as the node recovered"
+	default:
+		return
+	}
+	g.events.RecordNodeEvent(eventType, reason, message)
+}
+
+// Stretch returns the factor the periodic sync periods are currently stretched by, 1 with a nil governor
+func (g *LoadGovernor) Stretch() int {
+	if g == nil {
+		return 1
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stretch
+}
+
+// Due tells whether the periodic sync is due on a tick of the sync period ticker, counting in ticks the ticks skipped
+// while the periods are stretched. Every tick is due with a nil governor.
+func (g *LoadGovernor) Due(ticks *int) bool {
+	*ticks++
+	if *ticks < g.Stretch() {
+		return false
+	}
+	*ticks = 0
+	return true
+}
+
+// WaitTick waits for the tick of the sync period ticker the periodic sync is due on, and tells whether it came
+// rather than stopCh being closed
+func (g *LoadGovernor) WaitTick(ticker <-chan time.Time, stopCh <-chan struct{}) bool {
+	ticks := 0
+	for {
+		select {
+		case <-stopCh:
+			return false
+		case <-ticker:
+		}
+		if g.Due(&ticks) {
+			return true
+		}
+	}
+}
+
+func readLoadAverage() (float64, error) {
+	content, err := ioutil.ReadFile(loadAvgPath)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected content of %s", loadAvgPath)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// xtablesLockHeld tells whether another process holds the lock iptables takes while changing the rules
+func xtablesLockHeld() bool {
+	f, err := os.Open(xtablesLockPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		return err == unix.EWOULDBLOCK
+	}
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	return false
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
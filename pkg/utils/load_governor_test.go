@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLoadGovernor(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	events := NewEventSink(client, "node", time.Minute)
+	g, err := NewLoadGovernor(LoadGovernorConfig{LoadThreshold: 2, LockThreshold: 0.5, MaxStretch: 4}, events)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	reasons := func() []string {
+		events, err := client.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list events: %s", err)
+		}
+		reasons := make([]string, 0)
+		for _, event := range events.Items {
+			reasons = append(reasons, event.Reason)
+		}
+		return reasons
+	}
+
+	for _, step := range []struct {
+		load, lockContention float64
+		stretch              int
+		events               int
+	}{
+		{1, 0.1, 1, 0},
+		{3, 0.1, 2, 1},
+		{1, 0.9, 4, 1},
+		{3, 0.9, 4, 1},
+		{1, 0.1, 2, 1},
+		{1, 0.1, 1, 2},
+	} {
+		g.evaluate(step.load, step.lockContention)
+		if g.Stretch() != step.stretch {
+			t.Errorf("expected a stretch of %d after load %.1f and lock contention %.1f, got %d", step.stretch,
+				step.load, step.lockContention, g.Stretch())
+		}
+		if got := reasons(); len(got) != step.events {
+			t.Errorf("expected %d events, got %v", step.events, got)
+		}
+	}
+	if got := reasons(); got[0] != syncThrottledReason || got[1] != syncThrottleLiftedReason {
+		t.Errorf("unexpected events %v", got)
+	}
+
+	g.evaluate(3, 0)
+	ticks, due := 0, 0
+	for i := 0; i < 6; i++ {
+		if g.Due(&ticks) {
+			due++
+		}
+	}
+	if due != 3 {
+		t.Errorf("expected the periodic sync to be due on every other tick, got %d out of 6", due)
+	}
+
+	var nilGovernor *LoadGovernor
+	if !nilGovernor.Due(&ticks) {
+		t.Errorf("expected every tick to be due without a governor")
+	}
+
+	if _, err := NewLoadGovernor(LoadGovernorConfig{LoadThreshold: 2, LockThreshold: 2, MaxStretch: 4}, events); err == nil {
+		t.Errorf("expected a lock threshold above 1 to be rejected")
+	}
+}
+
+func TestReadLoadAverage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loadavg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	loadAvgPath = filepath.Join(dir, "loadavg")
+	if err := ioutil.WriteFile(loadAvgPath, []byte("3.52 2.10 1.05 2/1234 5678\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	load, err := readLoadAverage()
+	if err != nil || load != 3.52 {
+		t.Errorf("expected a load average of 3.52, got %f (%v)", load, err)
+	}
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// nfnetlink_log protocol, see linux/netfilter/nfnetlink_log.h
+const (
+	nfnlSubsysULog = 4
+
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind   = 1
+	nfulnlCfgCmdPfBind = 3
+
+	nfulnlCopyPacket = 2
+
+	nfulaPayload = 9
+	nfulaPrefix  = 10
+
+	// attribute type flags
+	nlaTypeMask = 0x3fff
+)
+
+// NFLogCopyRange is the number of bytes of each logged packet copied to the reader, enough for the network and
+// transport headers
+const NFLogCopyRange = 128
+
+// NFLogPacket is a packet logged to an NFLOG group
+type NFLogPacket struct {
+	// Prefix is the --nflog-prefix of the rule that logged the packet
+	Prefix string
+	// Payload is the packet from its network header, truncated
+	Payload []byte
+}
+
+// NFLogReader reads the packets logged to an NFLOG group. A group is read by a single reader on the node, binding a
+// group already read by another process fails.
+type NFLogReader struct {
+	fd    int
+	group uint16
+	buf   []byte
+}
+
+// NewNFLogReader binds a reader to the NFLOG group
+func NewNFLogReader(group uint16) (*NFLogReader, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netfilter netlink socket: %s", err)
+	}
+	r := &NFLogReader{fd: fd, group: group, buf: make([]byte, 65536)}
+	// reads return regularly so the reader can be stopped
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to set the receive timeout of netfilter netlink socket: %s", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to bind netfilter netlink socket: %s", err)
+	}
+	// kernels before 3.17 need the reader bound to the address families, later ones ignore it
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		if err := r.config(family, 0, nfLogCmdAttr(nfulnlCfgCmdPfBind)); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to bind NFLOG to address family %d: %s", family, err)
+		}
+	}
+	if err := r.config(unix.AF_UNSPEC, group, nfLogCmdAttr(nfulnlCfgCmdBind)); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to bind NFLOG group %d, is it read by another process? %s", group, err)
+	}
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode, NFLogCopyRange)
+	mode[4] = nfulnlCopyPacket
+	if err := r.config(unix.AF_UNSPEC, group, nfnlAttr(nfulaCfgMode, mode)); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to set the copy mode of NFLOG group %d: %s", group, err)
+	}
+	return r, nil
+}
+
+// Close unbinds the reader
+func (r *NFLogReader) Close() {
+	unix.Close(r.fd)
+}
+
+// Read blocks until packets are logged and returns them, or returns no packet after a second
+func (r *NFLogReader) Read() ([]NFLogPacket, error) {
+	n, _, err := unix.Recvfrom(r.fd, r.buf, 0)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseNFLogMessages(r.buf[:n])
+}
+
+// nfnlAttr returns the netlink attribute of the type, padded to its alignment
+func nfnlAttr(attrType uint16, data []byte) []byte {
+	attr := make([]byte, unix.NLA_HDRLEN+len(data), nlaAlign(unix.NLA_HDRLEN+len(data)))
+	binary.LittleEndian.PutUint16(attr[0:2], uint16(unix.NLA_HDRLEN+len(data)))
+	binary.LittleEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[unix.NLA_HDRLEN:], data)
+	return attr[:cap(attr)]
+}
+
+func nfLogCmdAttr(cmd uint8) []byte {
+	return nfnlAttr(nfulaCfgCmd, []byte{cmd})
+}
+
+func nlaAlign(n int) int {
+	return (n + unix.NLA_ALIGNTO - 1) & ^(unix.NLA_ALIGNTO - 1)
+}
+
+// config sends a configuration message for the group and waits for its acknowledgement
+func (r *NFLogReader) config(family uint8, group uint16, attr []byte) error {
+	return nfnlRequest(r.fd, r.buf, nfnlSubsysULog<<8|nfulnlMsgConfig, family, group, attr)
+}
+
+// nfnlRequest sends a netfilter netlink message of the type, for the address family and the resource, e.g. the NFLOG
+// group, with its attributes, and waits for its acknowledgement, received into buf
+func nfnlRequest(fd int, buf []byte, msgType uint16, family uint8, resID uint16, attrs ...[]byte) error {
+	length := unix.NLMSG_HDRLEN + 4
+	for _, attr := range attrs {
+		length += len(attr)
+	}
+	msg := make([]byte, unix.NLMSG_HDRLEN+4, length)
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(length))
+	binary.LittleEndian.PutUint16(msg[4:6], msgType)
+	binary.LittleEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	// nfgenmsg: family, version and the resource in network byte order
+	msg[unix.NLMSG_HDRLEN] = family
+	binary.BigEndian.PutUint16(msg[unix.NLMSG_HDRLEN+2:], resID)
+	for _, attr := range attrs {
+		msg = append(msg, attr...)
+	}
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return fmt.Errorf("truncated netlink error")
+			}
+			if errno := int32(binary.LittleEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+// parseNFAttrs calls fn with the type and value of each of the attributes of a netfilter netlink message, which
+// follow its nfgenmsg
+func parseNFAttrs(attrs []byte, fn func(attrType uint16, value []byte)) error {
+	for len(attrs) >= unix.NLA_HDRLEN {
+		length := int(binary.LittleEndian.Uint16(attrs[0:2]))
+		if length < unix.NLA_HDRLEN || length > len(attrs) {
+			return fmt.Errorf("malformed netfilter netlink attribute")
+		}
+		fn(binary.LittleEndian.Uint16(attrs[2:4])&nlaTypeMask, attrs[unix.NLA_HDRLEN:length])
+		if aligned := nlaAlign(length); aligned < len(attrs) {
+			attrs = attrs[aligned:]
+		} else {
+			break
+		}
+	}
+	return nil
+}
+
+// parseNFLogMessages returns the packets of the NFLOG packet messages of a netlink datagram
+func parseNFLogMessages(data []byte) ([]NFLogPacket, error) {
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	packets := make([]NFLogPacket, 0, len(msgs))
+	for _, m := range msgs {
+		if m.Header.Type != nfnlSubsysULog<<8|nfulnlMsgPacket || len(m.Data) < 4 {
+			continue
+		}
+		var packet NFLogPacket
+		// attributes follow the nfgenmsg
+		err := parseNFAttrs(m.Data[4:], func(attrType uint16, value []byte) {
+			switch attrType {
+			case nfulaPayload:
+				packet.Payload = append([]byte(nil), value...)
+			case nfulaPrefix:
+				packet.Prefix = strings.TrimRight(string(value), "\x00")
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("malformed NFLOG message: %s", err)
+		}
+		packets = append(packets, packet)
+	}
+	return packets, nil
+}
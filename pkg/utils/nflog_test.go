@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func nflogPacketMessage(attrs ...[]byte) []byte {
+	body := []byte{unix.AF_INET, 0, 0, 100}
+	for _, attr := range attrs {
+		body = append(body, attr...)
+	}
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(body))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(body)))
+	binary.LittleEndian.PutUint16(msg[4:6], nfnlSubsysULog<<8|nfulnlMsgPacket)
+	return append(msg, body...)
+}
+
+func Test_parseNFLogMessages(t *testing.T) {
+	payload := []byte{0x45, 0, 0, 20, 1, 2, 3}
+	data := append(nflogPacketMessage(nfnlAttr(nfulaPrefix, []byte("DROP\x00")), nfnlAttr(nfulaPayload, payload)),
+		nflogPacketMessage(nfnlAttr(nfulaPayload, []byte{0x60}))...)
+	packets, err := parseNFLogMessages(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+	if packets[0].Prefix != "DROP" || !bytes.Equal(packets[0].Payload, payload) {
+		t.Errorf("unexpected first packet %+v", packets[0])
+	}
+	if packets[1].Prefix != "" || !bytes.Equal(packets[1].Payload, []byte{0x60}) {
+		t.Errorf("unexpected second packet %+v", packets[1])
+	}
+
+	malformed := nflogPacketMessage([]byte{0xff, 0, nfulaPayload, 0})
+	if _, err := parseNFLogMessages(malformed); err == nil {
+		t.Error("expected an attribute longer than the message to be rejected")
+	}
+}
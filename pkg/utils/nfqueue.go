@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// nfnetlink_queue protocol, see linux/netfilter/nfnetlink_queue.h
+const (
+	nfnlSubsysQueue = 3
+
+	nfqnlMsgPacket  = 0
+	nfqnlMsgVerdict = 1
+	nfqnlMsgConfig  = 2
+
+	nfqaPacketHdr  = 1
+	nfqaVerdictHdr = 2
+	nfqaPayload    = 10
+
+	nfqaCfgCmd    = 1
+	nfqaCfgParams = 2
+	nfqaCfgMask   = 4
+	nfqaCfgFlags  = 5
+
+	nfqnlCfgCmdBind   = 1
+	nfqnlCfgCmdPfBind = 3
+
+	nfqnlCopyPacket = 2
+
+	// packets are accepted rather than dropped when the queue is full
+	nfqaCfgFFailOpen = 1
+
+	nfAccept = 1
+)
+
+// NFQueueCopyRange is the number of bytes of each queued packet copied to the reader, the largest IPv4 packet
+const NFQueueCopyRange = 65535
+
+// NFQueuePacket is a packet queued to an NFQUEUE queue, waiting for its verdict
+type NFQueuePacket struct {
+	// ID identifies the packet in its verdict
+	ID uint32
+	// Payload is the packet from its network header
+	Payload []byte
+}
+
+// NFQueue reads the packets queued to an NFQUEUE queue and gives their verdicts. A queue is read by a single reader
+// on the node, binding a queue already read by another process fails. The packets are accepted when the queue is
+// full.
+type NFQueue struct {
+	fd    int
+	queue uint16
+	buf   []byte
+}
+
+// NewNFQueue binds a reader to the NFQUEUE queue
+func NewNFQueue(queue uint16) (*NFQueue, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netfilter netlink socket: %s", err)
+	}
+	q := &NFQueue{fd: fd, queue: queue, buf: make([]byte, 2*NFQueueCopyRange)}
+	// reads return regularly so the reader can be stopped
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		q.Close()
+		return nil, fmt.Errorf("failed to set the receive timeout of netfilter netlink socket: %s", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		q.Close()
+		return nil, fmt.Errorf("failed to bind netfilter netlink socket: %s", err)
+	}
+	// kernels before 3.8 need the reader bound to the address families, later ones ignore it
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		if err := q.config(family, nfQueueCmdAttr(nfqnlCfgCmdPfBind, family)); err != nil {
+			q.Close()
+			return nil, fmt.Errorf("failed to bind NFQUEUE to address family %d: %s", family, err)
+		}
+	}
+	if err := q.config(unix.AF_UNSPEC, nfQueueCmdAttr(nfqnlCfgCmdBind, unix.AF_UNSPEC)); err != nil {
+		q.Close()
+		return nil, fmt.Errorf("failed to bind NFQUEUE queue %d, is it read by another process? %s", queue, err)
+	}
+	params := make([]byte, 5)
+	binary.BigEndian.PutUint32(params, NFQueueCopyRange)
+	params[4] = nfqnlCopyPacket
+	if err := q.config(unix.AF_UNSPEC, nfnlAttr(nfqaCfgParams, params)); err != nil {
+		q.Close()
+		return nil, fmt.Errorf("failed to set the copy mode of NFQUEUE queue %d: %s", queue, err)
+	}
+	flags := make([]byte, 4)
+	binary.BigEndian.PutUint32(flags, nfqaCfgFFailOpen)
+	if err := q.config(unix.AF_UNSPEC, nfnlAttr(nfqaCfgMask, flags), nfnlAttr(nfqaCfgFlags, flags)); err != nil {
+		// kernels before 3.6 drop the packets when the queue is full
+		if err != syscall.EINVAL {
+			q.Close()
+			return nil, fmt.Errorf("failed to set the flags of NFQUEUE queue %d: %s", queue, err)
+		}
+	}
+	return q, nil
+}
+
+// Close unbinds the reader, the packets still queued are accepted by the kernel
+func (q *NFQueue) Close() {
+	unix.Close(q.fd)
+}
+
+// Read blocks until packets are queued and returns them, or returns no packet after a second. Each packet returned
+// waits for its verdict.
+func (q *NFQueue) Read() ([]NFQueuePacket, error) {
+	n, _, err := unix.Recvfrom(q.fd, q.buf, 0)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseNFQueueMessages(q.buf[:n])
+}
+
+// Accept lets the queued packet continue its traversal of the chains
+func (q *NFQueue) Accept(id uint32) error {
+	verdict := make([]byte, 8)
+	binary.BigEndian.PutUint32(verdict[0:4], nfAccept)
+	binary.BigEndian.PutUint32(verdict[4:8], id)
+	attr := nfnlAttr(nfqaVerdictHdr, verdict)
+
+	// verdicts are not acknowledged
+	msg := make([]byte, unix.NLMSG_HDRLEN+4, unix.NLMSG_HDRLEN+4+len(attr))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(cap(msg)))
+	binary.LittleEndian.PutUint16(msg[4:6], nfnlSubsysQueue<<8|nfqnlMsgVerdict)
+	binary.LittleEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST)
+	msg[unix.NLMSG_HDRLEN] = unix.AF_UNSPEC
+	binary.BigEndian.PutUint16(msg[unix.NLMSG_HDRLEN+2:], q.queue)
+	msg = append(msg, attr...)
+	return unix.Sendto(q.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+}
+
+func nfQueueCmdAttr(cmd uint8, family uint8) []byte {
+	// nfqnl_msg_config_cmd: the command, padding and the address family in network byte order
+	return nfnlAttr(nfqaCfgCmd, []byte{cmd, 0, 0, family})
+}
+
+// config sends a configuration message for the queue and waits for its acknowledgement
+func (q *NFQueue) config(family uint8, attrs ...[]byte) error {
+	return nfnlRequest(q.fd, q.buf, nfnlSubsysQueue<<8|nfqnlMsgConfig, family, q.queue, attrs...)
+}
+
+// parseNFQueueMessages returns the packets of the NFQUEUE packet messages of a netlink datagram
+func parseNFQueueMessages(data []byte) ([]NFQueuePacket, error) {
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	packets := make([]NFQueuePacket, 0, len(msgs))
+	for _, m := range msgs {
+		if m.Header.Type != nfnlSubsysQueue<<8|nfqnlMsgPacket || len(m.Data) < 4 {
+			continue
+		}
+		var packet NFQueuePacket
+		hasID := false
+		err := parseNFAttrs(m.Data[4:], func(attrType uint16, value []byte) {
+			switch attrType {
+			case nfqaPacketHdr:
+				if len(value) >= 4 {
+					packet.ID, hasID = binary.BigEndian.Uint32(value[0:4]), true
+				}
+			case nfqaPayload:
+				packet.Payload = append([]byte(nil), value...)
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("malformed NFQUEUE message: %s", err)
+		}
+		if !hasID {
+			return nil, fmt.Errorf("NFQUEUE packet message without packet header")
+		}
+		packets = append(packets, packet)
+	}
+	return packets, nil
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func nfqueuePacketMessage(attrs ...[]byte) []byte {
+	body := []byte{unix.AF_INET, 0, 0, 100}
+	for _, attr := range attrs {
+		body = append(body, attr...)
+	}
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(body))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(body)))
+	binary.LittleEndian.PutUint16(msg[4:6], nfnlSubsysQueue<<8|nfqnlMsgPacket)
+	return append(msg, body...)
+}
+
+func Test_parseNFQueueMessages(t *testing.T) {
+	payload := []byte{0x45, 0, 0, 20, 1, 2, 3}
+	// packet id, hardware protocol and hook
+	header := []byte{0, 0, 1, 2, 0x08, 0, 4}
+	data := append(nfqueuePacketMessage(nfnlAttr(nfqaPacketHdr, header), nfnlAttr(nfqaPayload, payload)),
+		nfqueuePacketMessage(nfnlAttr(nfqaPacketHdr, []byte{0, 0, 0, 7, 0x08, 0, 4}))...)
+	packets, err := parseNFQueueMessages(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+	if packets[0].ID != 258 || !bytes.Equal(packets[0].Payload, payload) {
+		t.Errorf("unexpected first packet %+v", packets[0])
+	}
+	if packets[1].ID != 7 || len(packets[1].Payload) != 0 {
+		t.Errorf("unexpected second packet %+v", packets[1])
+	}
+
+	if _, err := parseNFQueueMessages(nfqueuePacketMessage(nfnlAttr(nfqaPayload, payload))); err == nil {
+		t.Error("expected a packet without packet header to be rejected, its verdict cannot be given")
+	}
+	malformed := nfqueuePacketMessage([]byte{0xff, 0, nfqaPayload, 0})
+	if _, err := parseNFQueueMessages(malformed); err == nil {
+		t.Error("expected an attribute longer than the message to be rejected")
+	}
+}
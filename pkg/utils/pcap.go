@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// link type of the packets starting with their IPv4 or IPv6 header, see pcap-linktype(7)
+const pcapLinkTypeRaw = 101
+
+// PCAPWriter writes packets in the pcap format read by tcpdump and wireshark
+type PCAPWriter struct {
+	w       io.Writer
+	snapLen uint32
+}
+
+// NewPCAPWriter writes the pcap file header for packets truncated to snapLen bytes, and returns the writer of the
+// packets
+func NewPCAPWriter(w io.Writer, snapLen uint32) (*PCAPWriter, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	// version 2.4, the time zone and accuracy of the timestamps are always 0
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], snapLen)
+	binary.LittleEndian.PutUint32(header[20:24], pcapLinkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &PCAPWriter{w: w, snapLen: snapLen}, nil
+}
+
+// WritePacket writes the packet, starting with its network header, captured at the given time. The original length
+// of the packet is read from its network header, as the packets logged to NFLOG are truncated.
+func (p *PCAPWriter) WritePacket(captured time.Time, packet []byte) error {
+	if uint32(len(packet)) > p.snapLen {
+		packet = packet[:p.snapLen]
+	}
+	record := make([]byte, 16, 16+len(packet))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(captured.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(captured.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:16], ipPacketLength(packet))
+	_, err := p.w.Write(append(record, packet...))
+	return err
+}
+
+// ipPacketLength returns the length of the IP packet given in its header, or the length of the truncated packet
+// when the header is truncated
+func ipPacketLength(packet []byte) uint32 {
+	length := uint32(len(packet))
+	if len(packet) == 0 {
+		return length
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) >= 4 {
+			length = uint32(binary.BigEndian.Uint16(packet[2:4]))
+		}
+	case 6:
+		if len(packet) >= 6 {
+			length = 40 + uint32(binary.BigEndian.Uint16(packet[4:6]))
+		}
+	}
+	if length < uint32(len(packet)) {
+		return uint32(len(packet))
+	}
+	return length
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestPCAPWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewPCAPWriter(&b, 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// IPv4 header of a 1500 bytes packet, truncated
+	packet := []byte{0x45, 0, 0x05, 0xdc, 0, 0, 0, 0, 64, 6}
+	captured := time.Unix(1500000000, 250000000)
+	if err := w.WritePacket(captured, packet); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	out := b.Bytes()
+	if len(out) != 24+16+8 {
+		t.Fatalf("expected a header, a record header and 8 bytes of packet, got %d bytes", len(out))
+	}
+	if magic := binary.LittleEndian.Uint32(out[0:4]); magic != 0xa1b2c3d4 {
+		t.Errorf("expected the pcap magic number, got %x", magic)
+	}
+	if linkType := binary.LittleEndian.Uint32(out[20:24]); linkType != pcapLinkTypeRaw {
+		t.Errorf("expected raw IP link type, got %d", linkType)
+	}
+	record := out[24:40]
+	for i, expected := range []uint32{1500000000, 250000, 8, 1500} {
+		if got := binary.LittleEndian.Uint32(record[i*4 : i*4+4]); got != expected {
+			t.Errorf("expected field %d of the record header to be %d, got %d", i, expected, got)
+		}
+	}
+	if !bytes.Equal(out[40:], packet[:8]) {
+		t.Errorf("expected the packet truncated to the snap length, got %x", out[40:])
+	}
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+)
+
+// While the API server is unreachable the informer caches keep the last objects they listed and watched, and the
+// controllers keep enforcing them. The caches are stale from the first of the failed lists and watches of the
+// informers that have not recovered yet.
+
+// apiStaleness tracks since when the informers of each resource failed to list and watch
+type apiStaleness struct {
+	mu           sync.Mutex
+	failingSince map[string]time.Time
+}
+
+var staleness = &apiStaleness{failingSince: make(map[string]time.Time)}
+
+func (s *apiStaleness) failed(resource string) {
+	s.mu.Lock()
+	if _, ok := s.failingSince[resource]; !ok {
+		s.failingSince[resource] = time.Now()
+	}
+	s.mu.Unlock()
+	metrics.ControllerAPIStaleness.Set(APIStaleness().Seconds())
+}
+
+func (s *apiStaleness) succeeded(resource string) {
+	s.mu.Lock()
+	delete(s.failingSince, resource)
+	s.mu.Unlock()
+	metrics.ControllerAPIStaleness.Set(APIStaleness().Seconds())
+}
+
+// APIStaleSince returns since when the informer caches are stale, the zero time while the API server is reachable
+func APIStaleSince() time.Time {
+	staleness.mu.Lock()
+	defer staleness.mu.Unlock()
+	var since time.Time
+	for _, failingSince := range staleness.failingSince {
+		if since.IsZero() || failingSince.Before(since) {
+			since = failingSince
+		}
+	}
+	return since
+}
+
+// APIStaleness returns for how long the informer caches have been stale, 0 while the API server is reachable
+func APIStaleness() time.Duration {
+	since := APIStaleSince()
+	if since.IsZero() {
+		return 0
+	}
+	return time.Since(since)
+}
+
+// EmptyCacheGuard keeps a controller from wiping the state of the node when the cache of a resource it synced
+// objects of finds none: an empty cache does not mean the objects were deleted when the API server is unreachable,
+// or when the informer missed them. The emptiness is confirmed with the API server before the state is wiped.
+type EmptyCacheGuard struct {
+	// Resource names the guarded resource in logs and metrics
+	Resource string
+	// Count reads at most limit objects from the API server and returns how many it read
+	Count func(limit int64) (int, error)
+}
+
+// Check returns an error wrapping ErrAPIUnavailable when the cache holds no object while synced objects were synced
+// last, unless the API server confirms there are none left
+func (g *EmptyCacheGuard) Check(cached, synced int) error {
+	if cached > 0 || synced == 0 {
+		return nil
+	}
+	var err error
+	if stale := APIStaleness(); stale > 0 {
+		err = fmt.Errorf("%w: the cache of %s is empty and the API server has been unreachable for %s, keeping "+
+			"the %d synced last", ErrAPIUnavailable, g.Resource, stale.Round(time.Second), synced)
+	} else if g.Count != nil {
+		objects, listErr := g.Count(1)
+		switch {
+		case listErr != nil:
+			err = fmt.Errorf("%w: the cache of %s is empty and listing them from the API server failed, keeping "+
+				"the %d synced last: %s", ErrAPIUnavailable, g.Resource, synced, listErr)
+		case objects > 0:
+			err = fmt.Errorf("%w: the cache of %s is empty while the API server still has some, keeping the %d "+
+				"synced last", ErrAPIUnavailable, g.Resource, synced)
+		}
+	}
+	if err != nil {
+		metrics.ControllerEmptyCacheGuards.WithLabelValues(g.Resource).Inc()
+		return err
+	}
+	if g.Count != nil {
+		glog.Infof("The API server confirmed there is no %s left, removing the %d synced last", g.Resource, synced)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAPIStaleness(t *testing.T) {
+	staleness = &apiStaleness{failingSince: make(map[string]time.Time)}
+	if !APIStaleSince().IsZero() || APIStaleness() != 0 {
+		t.Fatalf("expected the caches to be fresh before any failure")
+	}
+	staleness.failed("pods")
+	since := APIStaleSince()
+	staleness.failed("nodes")
+	staleness.failed("pods")
+	if since.IsZero() || APIStaleSince() != since {
+		t.Errorf("expected the caches to be stale since the first failure")
+	}
+	staleness.succeeded("pods")
+	if APIStaleSince().IsZero() {
+		t.Errorf("expected the caches to stay stale while the nodes fail")
+	}
+	staleness.succeeded("nodes")
+	if !APIStaleSince().IsZero() {
+		t.Errorf("expected the caches to be fresh once all the informers recovered")
+	}
+}
+
+func TestEmptyCacheGuard(t *testing.T) {
+	staleness = &apiStaleness{failingSince: make(map[string]time.Time)}
+	var listed int
+	var listErr error
+	guard := &EmptyCacheGuard{Resource: "pods", Count: func(limit int64) (int, error) {
+		return listed, listErr
+	}}
+
+	if err := guard.Check(0, 0); err != nil {
+		t.Errorf("expected an empty cache to be accepted when nothing was synced: %s", err)
+	}
+	if err := guard.Check(2, 3); err != nil {
+		t.Errorf("expected a non empty cache to be accepted: %s", err)
+	}
+
+	listed = 1
+	if err := guard.Check(0, 3); !errors.Is(err, ErrAPIUnavailable) {
+		t.Errorf("expected the empty cache to be refused while the API server has pods, got %v", err)
+	}
+	listed, listErr = 0, errors.New("connection refused")
+	if err := guard.Check(0, 3); !errors.Is(err, ErrAPIUnavailable) {
+		t.Errorf("expected the empty cache to be refused when the API server cannot be listed, got %v", err)
+	}
+	listErr = nil
+	if err := guard.Check(0, 3); err != nil {
+		t.Errorf("expected the empty cache to be accepted once the API server confirmed it: %s", err)
+	}
+
+	staleness.failed("pods")
+	defer staleness.succeeded("pods")
+	time.Sleep(time.Millisecond)
+	if err := guard.Check(0, 3); !errors.Is(err, ErrAPIUnavailable) {
+		t.Errorf("expected the empty cache to be refused while the API server is unreachable, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/golang/glog"
+)
+
+// SyncPriority is the priority of a sync at the sync coordinator
+type SyncPriority int
+
+const (
+	// SyncPeriodic is the priority of the periodic syncs
+	SyncPeriodic SyncPriority = iota
+	// SyncEvent is the priority of the syncs requested by the events, run ahead of the periodic ones
+	SyncEvent
+)
+
+func (p SyncPriority) String() string {
+	if p == SyncEvent {
+		return "event"
+	}
+	return "periodic"
+}
+
+// SyncCoordinator runs the syncs of the controllers sharing the node one at a time, so they do not contend for the
+// iptables lock. The syncs requested by the events go ahead of the periodic syncs waiting for their turn, and the
+// periodic syncs of the controllers are staggered over their periods rather than all starting together.
+type SyncCoordinator struct {
+	// controllers in the order their periodic syncs are staggered in
+	controllers []string
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// controller whose sync is running, empty when none is
+	running string
+	waiting [SyncEvent + 1]int
+}
+
+// NewSyncCoordinator returns a sync coordinator of the controllers, whose periodic syncs are staggered in the given
+// order
+func NewSyncCoordinator(controllers []string) *SyncCoordinator {
+	c := &SyncCoordinator{controllers: controllers}
+	c.cond = sync.NewCond(&c.mu)
+	for priority := SyncPeriodic; priority <= SyncEvent; priority++ {
+		metrics.ControllerSyncQueueLength.WithLabelValues(priority.String()).Set(0)
+	}
+	return c
+}
+
+// Offset returns how much the periodic syncs of the controller are delayed, its share of the period. It is 0 with a
+// nil coordinator.
+func (c *SyncCoordinator) Offset(controller string, period time.Duration) time.Duration {
+	if c == nil {
+		return 0
+	}
+	for i, name := range c.controllers {
+		if name == controller {
+			return period * time.Duration(i) / time.Duration(len(c.controllers))
+		}
+	}
+	return 0
+}
+
+// Acquire waits for the turn of the sync of the controller and returns the function to call once the sync is done.
+// It returns at once with a nil coordinator.
+func (c *SyncCoordinator) Acquire(controller string, priority SyncPriority) func() {
+	if c == nil {
+		return func() {}
+	}
+	start := time.Now()
+	c.mu.Lock()
+	if !c.turn(priority) {
+		glog.V(2).Infof("Sync of %s waiting for the sync of %s", controller, c.running)
+		metrics.ControllerSyncContention.WithLabelValues(controller, priority.String()).Inc()
+		c.waiting[priority]++
+		c.setQueueLength(priority)
+		for !c.turn(priority) {
+			c.cond.Wait()
+		}
+		c.waiting[priority]--
+		c.setQueueLength(priority)
+	}
+	c.running = controller
+	c.mu.Unlock()
+	metrics.ControllerSyncWaitTime.WithLabelValues(controller, priority.String()).Observe(time.Since(start).Seconds())
+
+	return func() {
+		c.mu.Lock()
+		c.running = ""
+		c.mu.Unlock()
+		c.cond.Broadcast()
+	}
+}
+
+// turn tells whether a sync of the priority can run, with the lock held
+func (c *SyncCoordinator) turn(priority SyncPriority) bool {
+	if c.running != "" {
+		return false
+	}
+	return priority == SyncEvent || c.waiting[SyncEvent] == 0
+}
+
+func (c *SyncCoordinator) setQueueLength(priority SyncPriority) {
+	metrics.ControllerSyncQueueLength.WithLabelValues(priority.String()).Set(float64(c.waiting[priority]))
+}
+
+// SyncTicker delivers the ticks of the periodic syncs of a controller, delayed by the offset of the controller
+type SyncTicker struct {
+	C      <-chan time.Time
+	ticker *time.Ticker
+	stop   chan struct{}
+}
+
+// NewTicker returns the ticker of the periodic syncs of the controller. Its first tick comes after the period and the
+// offset of the controller.
+func (c *SyncCoordinator) NewTicker(controller string, period time.Duration) *SyncTicker {
+	offset := c.Offset(controller, period)
+	if offset == 0 {
+		ticker := time.NewTicker(period)
+		return &SyncTicker{C: ticker.C, ticker: ticker}
+	}
+	ticks := make(chan time.Time, 1)
+	t := &SyncTicker{C: ticks, stop: make(chan struct{})}
+	go func() {
+		timer := time.NewTimer(offset)
+		select {
+		case <-t.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case tick := <-ticker.C:
+				// ticks are dropped for slow receivers, like those of time.Ticker
+				select {
+				case ticks <- tick:
+				default:
+				}
+			}
+		}
+	}()
+	return t
+}
+
+// Stop stops the ticker
+func (t *SyncTicker) Stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+		return
+	}
+	close(t.stop)
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncCoordinatorOffset(t *testing.T) {
+	c := NewSyncCoordinator([]string{"NPC", "NRC", "NSC"})
+	for controller, expected := range map[string]time.Duration{
+		"NPC": 0,
+		"NRC": time.Minute,
+		"NSC": 2 * time.Minute,
+		"MC":  0,
+	} {
+		if offset := c.Offset(controller, 3*time.Minute); offset != expected {
+			t.Errorf("expected the periodic syncs of %s delayed by %s, got %s", controller, expected, offset)
+		}
+	}
+
+	var nilCoordinator *SyncCoordinator
+	if offset := nilCoordinator.Offset("NSC", time.Minute); offset != 0 {
+		t.Errorf("expected no delay without a coordinator, got %s", offset)
+	}
+	nilCoordinator.Acquire("NSC", SyncPeriodic)()
+}
+
+func TestSyncCoordinatorPriorities(t *testing.T) {
+	c := NewSyncCoordinator([]string{"NPC", "NRC", "NSC"})
+	release := c.Acquire("NPC", SyncPeriodic)
+
+	var mu sync.Mutex
+	order := make([]string, 0)
+	var wg sync.WaitGroup
+	acquire := func(controller string, priority SyncPriority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := c.Acquire(controller, priority)
+			mu.Lock()
+			order = append(order, controller)
+			mu.Unlock()
+			release()
+		}()
+		// waits for the sync to queue up
+		for {
+			c.mu.Lock()
+			queued := c.waiting[priority]
+			c.mu.Unlock()
+			if queued > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	acquire("NRC", SyncPeriodic)
+	acquire("NSC", SyncEvent)
+	release()
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != "NSC" || order[1] != "NRC" {
+		t.Errorf("expected the event-driven sync to run ahead of the periodic one, got %v", order)
+	}
+}
+
+func TestSyncTicker(t *testing.T) {
+	c := NewSyncCoordinator([]string{"NPC", "NSC"})
+	start := time.Now()
+	ticker := c.NewTicker("NSC", 100*time.Millisecond)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a tick")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the first tick after the period and the offset, got it after %s", elapsed)
+	}
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// SyncSummary is the JSON document passed to the post sync hook after a successful sync of a controller
+type SyncSummary struct {
+	Controller string                 `json:"controller"`
+	Node       string                 `json:"node"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Duration   float64                `json:"durationSeconds"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// PostSyncHook runs an external program or calls a webhook with the summary of each successful sync. Target
+// starting with http:// or https:// is POSTed the summary, anything else is executed as a program getting the
+// summary on its standard input.
+type PostSyncHook struct {
+	target  string
+	timeout time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// NewPostSyncHook returns new PostSyncHook for given target, nil if target is empty
+func NewPostSyncHook(target string, timeout time.Duration) (*PostSyncHook, error) {
+	if target == "" {
+		return nil, nil
+	}
+	if timeout <= 0 {
+		return nil, errors.New("post sync hook timeout must be greater than 0")
+	}
+	return &PostSyncHook{
+		target:  target,
+		timeout: timeout,
+		client:  &http.Client{Timeout: timeout},
+		running: make(map[string]bool),
+	}, nil
+}
+
+func (h *PostSyncHook) isWebhook() bool {
+	return strings.HasPrefix(h.target, "http://") || strings.HasPrefix(h.target, "https://")
+}
+
+// Run invokes the hook asynchronously with the summary, so that a slow hook does not delay the controllers.
+// If the hook is still running for the previous sync of the same controller, the invocation is skipped.
+// Safe to call on a nil PostSyncHook.
+func (h *PostSyncHook) Run(summary SyncSummary) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	if h.running[summary.Controller] {
+		h.mu.Unlock()
+		glog.Warningf("Skipping post sync hook for %s as previous invocation is still running", summary.Controller)
+		return
+	}
+	h.running[summary.Controller] = true
+	h.mu.Unlock()
+
+	go func() {
+		defer func() {
+			h.mu.Lock()
+			delete(h.running, summary.Controller)
+			h.mu.Unlock()
+		}()
+		if err := h.invoke(summary); err != nil {
+			glog.Errorf("Post sync hook for %s failed: %s", summary.Controller, err.Error())
+		}
+	}()
+}
+
+func (h *PostSyncHook) invoke(summary SyncSummary) error {
+	payload, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync summary: %s", err.Error())
+	}
+
+	if h.isWebhook() {
+		resp, err := h.client.Post(h.target, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook %s returned status %s", h.target, resp.Status)
+		}
+		return nil
+	}
+
+	out, err := ExecWithOptions(ExecOptions{Stdin: bytes.NewReader(payload), Timeout: h.timeout}, h.target)
+	if err != nil {
+		return err
+	}
+	glog.V(3).Infof("Post sync hook for %s output: %s", summary.Controller, string(out))
+	return nil
+}
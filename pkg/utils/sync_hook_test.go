@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_PostSyncHookWebhook(t *testing.T) {
+	received := make(chan SyncSummary, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var summary SyncSummary
+		if err := json.NewDecoder(r.Body).Decode(&summary); err != nil {
+			t.Errorf("failed to decode sync summary: %v", err)
+		}
+		received <- summary
+	}))
+	defer server.Close()
+
+	hook, err := NewPostSyncHook(server.URL, 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create post sync hook: %v", err)
+	}
+	hook.Run(SyncSummary{Controller: "NPC", Node: "node", Details: map[string]interface{}{"policies": 2}})
+
+	select {
+	case summary := <-received:
+		if summary.Controller != "NPC" || summary.Node != "node" || summary.Details["policies"] != float64(2) {
+			t.Errorf("unexpected sync summary received: %+v", summary)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the webhook to be called")
+	}
+}
+
+func Test_NewPostSyncHook(t *testing.T) {
+	hook, err := NewPostSyncHook("", time.Second)
+	if hook != nil || err != nil {
+		t.Errorf("expected no hook without target")
+	}
+	// must be safe to call on a disabled hook
+	hook.Run(SyncSummary{Controller: "NPC"})
+
+	if _, err = NewPostSyncHook("/bin/true", 0); err == nil {
+		t.Errorf("expected error for zero timeout")
+	}
+}